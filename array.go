@@ -19,6 +19,7 @@
 package atree
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
@@ -52,14 +53,52 @@ type Array struct {
 	// if it is nil before adding/updating elements.  Range, delete, and read are no-ops on nil Go map.
 	// TODO: maybe optimize by replacing map to get faster updates.
 	mutableElementIndex map[ValueID]uint64
+
+	// elementValidator is called to validate new element before it is stored
+	// by Append, Insert, and Set.  If elementValidator is nil, no validation is done.
+	// elementValidator is not stored physically and is only in memory.
+	elementValidator ArrayElementValidator
+
+	// modCount is incremented by successful mutating operations (Set, Insert,
+	// Remove, PopIterate, and Compact), so a failed operation doesn't
+	// invalidate iterators.  Updating child values through parent notification
+	// doesn't change modCount.  Read-only iterators return ConcurrentModificationError
+	// if modCount is changed after iterator is created.  modCount is only in memory.
+	modCount uint64
+
+	// structuralModCount is incremented by successful mutating operations
+	// which change element indexes (Insert, Remove, PopIterate, and Compact).
+	// Mutable iterators and views return ConcurrentModificationError if
+	// structuralModCount is changed, so elements can be Set during mutable
+	// iteration.  structuralModCount is only in memory.
+	structuralModCount uint64
+
+	// readOnly is true if this array is a child of read-only map.
+	// Mutation functions of read-only array return ReadOnlyError.
+	readOnly bool
 }
 
+// ArrayElementValidator returns error if value can't be stored as array element.
+type ArrayElementValidator func(Value) error
+
 var _ Value = &Array{}
 var _ mutableValueNotifier = &Array{}
 
 // Create, copy, and load array
 
-func NewArray(storage SlabStorage, address Address, typeInfo TypeInfo) (*Array, error) {
+// ArrayOption configures Array when it is created.
+type ArrayOption interface {
+	applyArrayOption(a *Array)
+}
+
+// NewArray creates a new empty array at given address.
+// It returns TempAddressError if address is AddressUndefined,
+// unless AllowTempAddress option is used.
+func NewArray(storage SlabStorage, address Address, typeInfo TypeInfo, opts ...ArrayOption) (*Array, error) {
+
+	if address == AddressUndefined && !tempAddressAllowed(opts) {
+		return nil, NewTempAddressError()
+	}
 
 	extraData := &ArrayExtraData{TypeInfo: typeInfo}
 
@@ -84,10 +123,43 @@ func NewArray(storage SlabStorage, address Address, typeInfo TypeInfo) (*Array,
 		return nil, err
 	}
 
-	return &Array{
+	a := &Array{
 		Storage: storage,
 		root:    root,
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt.applyArrayOption(a)
+	}
+
+	return a, nil
+}
+
+// NewArrayWithSchema is like NewArray, but it also records caller-supplied
+// schema ID with array.  Schema ID is stored in array extra data, so a
+// caller loading array by root slab ID can check that array elements were
+// encoded with the current schema (e.g. to trigger migration).  Atree
+// doesn't interpret schema ID.  Schema ID 0 means no schema ID.
+func NewArrayWithSchema(storage SlabStorage, address Address, typeInfo TypeInfo, schemaID uint64, opts ...ArrayOption) (*Array, error) {
+	a, err := NewArray(storage, address, typeInfo, opts...)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by NewArray().
+		return nil, err
+	}
+
+	if schemaID == 0 {
+		return a, nil
+	}
+
+	a.root.ExtraData().SchemaID = schemaID
+
+	// Store modified root slab in storage since schema ID is part of extraData stored in root slab.
+	err = storeSlab(storage, a.root)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
 }
 
 func NewArrayWithRootID(storage SlabStorage, rootID SlabID) (*Array, error) {
@@ -112,9 +184,94 @@ func NewArrayWithRootID(storage SlabStorage, rootID SlabID) (*Array, error) {
 	}, nil
 }
 
+// ArrayCountFromRoot returns element count of array with given root slab ID.
+// Since root slab header contains element count of entire array,
+// only root slab is retrieved.
+func ArrayCountFromRoot(storage SlabStorage, rootID SlabID) (uint64, error) {
+	if rootID == SlabIDUndefined {
+		return 0, NewSlabIDErrorf("cannot get Array count from undefined slab ID")
+	}
+
+	root, err := getArraySlab(storage, rootID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+		return 0, err
+	}
+
+	extraData := root.ExtraData()
+	if extraData == nil {
+		return 0, NewNotValueError(rootID)
+	}
+
+	return uint64(root.Header().count), nil
+}
+
 type ArrayElementProvider func() (Value, error)
 
+// NewArrayFromBatchData returns a new array with elements provided by fn callback.
+// If it fails, slabs created by it (such as slabs of large elements)
+// are removed from storage, so failed batch build doesn't leave orphaned
+// slabs.  Slabs removed by it (such as slabs of child containers inlined
+// into new array) aren't restored.
 func NewArrayFromBatchData(storage SlabStorage, address Address, typeInfo TypeInfo, fn ArrayElementProvider) (*Array, error) {
+	tracker := newSlabDeltaCounter(storage)
+	defer tracker.stop()
+
+	array, err := newArrayFromBatchData(tracker, address, typeInfo, fn)
+	if err != nil {
+		removeErr := tracker.removeCreatedSlabs()
+		if removeErr != nil {
+			// Return err joined with removeErr, so failure to remove
+			// created slabs doesn't hide why batch build failed.
+			// Don't need to wrap error as external error because err and removeErr are already categorized.
+			return nil, errors.Join(err, removeErr)
+		}
+		// Don't need to wrap error as external error because err is already categorized by newArrayFromBatchData().
+		return nil, err
+	}
+
+	array.Storage = storage
+
+	return array, nil
+}
+
+// NewArrayFilled returns a new array of count elements with given value.
+// Container value (such as Array and OrderedMap) is deep copied for each
+// element, so elements don't share slabs with each other or with value.
+// comparator and hip are used to deep copy nested OrderedMap elements.
+// Array is built with NewArrayFromBatchData, which is much faster than
+// appending count elements.
+func NewArrayFilled(
+	storage SlabStorage,
+	address Address,
+	typeInfo TypeInfo,
+	value Value,
+	count uint64,
+	comparator ValueComparator,
+	hip HashInputProvider,
+) (*Array, error) {
+	if value == nil && count > 0 {
+		return nil, NewUserError(fmt.Errorf("failed to fill array: value is nil"))
+	}
+
+	remaining := count
+
+	return NewArrayFromBatchData(
+		storage,
+		address,
+		typeInfo,
+		func() (Value, error) {
+			if remaining == 0 {
+				return nil, nil
+			}
+			remaining--
+
+			// Don't need to wrap error as external error because err is already categorized by deepCopyValue().
+			return deepCopyValue(storage, address, comparator, hip, value)
+		})
+}
+
+func newArrayFromBatchData(storage SlabStorage, address Address, typeInfo TypeInfo, fn ArrayElementProvider) (*Array, error) {
 
 	var slabs []ArraySlab
 
@@ -172,10 +329,10 @@ func NewArrayFromBatchData(storage SlabStorage, address Address, typeInfo TypeIn
 
 		}
 
-		storable, err := value.Storable(storage, address, maxInlineArrayElementSize)
+		storable, err := newValueStorable(storage, address, value, maxInlineArrayElementSize)
 		if err != nil {
-			// Wrap err as external error (if needed) because err is returned by Value interface.
-			return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get value's storable")
+			// Don't need to wrap error as external error because err is already categorized by newValueStorable().
+			return nil, err
 		}
 
 		// Append new element
@@ -187,6 +344,36 @@ func NewArrayFromBatchData(storage SlabStorage, address Address, typeInfo TypeIn
 	// Append last data slab to slabs
 	slabs = append(slabs, dataSlab)
 
+	root, err := newArraySlabTree(storage, address, slabs)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by newArraySlabTree().
+		return nil, err
+	}
+
+	extraData := &ArrayExtraData{TypeInfo: typeInfo}
+
+	// Set extra data in root
+	root.SetExtraData(extraData)
+
+	// Store root
+	err = storeSlab(storage, root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Array{
+		Storage: storage,
+		root:    root,
+	}, nil
+}
+
+// newArraySlabTree returns root slab of array slab tree built from linked
+// data slabs.  It rebalances last slab of each level, creates metadata
+// slabs, and stores all non-root slabs in storage.
+// Caller is responsible for setting extra data in root and storing root.
+func newArraySlabTree(storage SlabStorage, address Address, slabs []ArraySlab) (ArraySlab, error) {
+	var err error
+
 	for len(slabs) > 1 {
 
 		lastSlab := slabs[len(slabs)-1]
@@ -253,21 +440,7 @@ func NewArrayFromBatchData(storage SlabStorage, address Address, typeInfo TypeIn
 		dataSlab.header.size = dataSlab.header.size - arrayDataSlabPrefixSize + arrayRootDataSlabPrefixSize
 	}
 
-	extraData := &ArrayExtraData{TypeInfo: typeInfo}
-
-	// Set extra data in root
-	root.SetExtraData(extraData)
-
-	// Store root
-	err = storeSlab(storage, root)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Array{
-		Storage: storage,
-		root:    root,
-	}, nil
+	return root, nil
 }
 
 // nextLevelArraySlabs returns next level meta data slabs from slabs.
@@ -336,6 +509,10 @@ func nextLevelArraySlabs(storage SlabStorage, address Address, slabs []ArraySlab
 // Array operations (get, set, insert, remove, and pop iterate)
 
 func (a *Array) Get(i uint64) (Value, error) {
+	if a.IsEmpty() {
+		return nil, NewIndexOutOfBoundsError(i, 0, 0)
+	}
+
 	storable, err := a.root.Get(a.Storage, i)
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by ArraySlab.Get().
@@ -356,17 +533,34 @@ func (a *Array) Get(i uint64) (Value, error) {
 }
 
 func (a *Array) Set(index uint64, value Value) (Storable, error) {
-	existingStorable, err := a.set(index, value)
+	// Don't need to wrap error as external error because err is already categorized by Array.setWithStorage().
+	return a.setWithStorage(a.Storage, index, value)
+}
+
+// setWithStorage is Set with slab operations performed through storage.
+func (a *Array) setWithStorage(storage SlabStorage, index uint64, value Value) (Storable, error) {
+	if a.readOnly {
+		return nil, NewReadOnlyError(a.ValueID())
+	}
+
+	err := a.validateElement(value)
 	if err != nil {
 		return nil, err
 	}
 
+	existingStorable, err := a.set(storage, index, value)
+	if err != nil {
+		return nil, err
+	}
+
+	a.modCount++
+
 	var existingValueID ValueID
 
 	// If overwritten storable is an inlined slab, uninline the slab and store it in storage.
 	// This is to prevent potential data loss because the overwritten inlined slab was not in
 	// storage and any future changes to it would have been lost.
-	existingStorable, existingValueID, _, err = uninlineStorableIfNeeded(a.Storage, existingStorable)
+	existingStorable, existingValueID, _, err = uninlineStorableIfNeeded(storage, existingStorable)
 	if err != nil {
 		return nil, err
 	}
@@ -385,15 +579,15 @@ func (a *Array) Set(index uint64, value Value) (Storable, error) {
 	return existingStorable, nil
 }
 
-func (a *Array) set(index uint64, value Value) (Storable, error) {
-	existingStorable, err := a.root.Set(a.Storage, a.Address(), index, value)
+func (a *Array) set(storage SlabStorage, index uint64, value Value) (Storable, error) {
+	existingStorable, err := a.root.Set(storage, a.Address(), index, value)
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by ArraySlab.Set().
 		return nil, err
 	}
 
 	if a.root.IsFull() {
-		err = a.splitRoot()
+		err = a.splitRoot(storage)
 		if err != nil {
 			// Don't need to wrap error as external error because err is already categorized by Array.splitRoot().
 			return nil, err
@@ -403,7 +597,7 @@ func (a *Array) set(index uint64, value Value) (Storable, error) {
 	if !a.root.IsData() {
 		root := a.root.(*ArrayMetaDataSlab)
 		if len(root.childrenHeaders) == 1 {
-			err = a.promoteChildAsNewRoot(root.childrenHeaders[0].slabID)
+			err = a.promoteChildAsNewRoot(storage, root.childrenHeaders[0].slabID)
 			if err != nil {
 				// Don't need to wrap error as external error because err is already categorized by Array.promoteChildAsNewRoot().
 				return nil, err
@@ -444,14 +638,32 @@ func (a *Array) Append(value Value) error {
 }
 
 func (a *Array) Insert(index uint64, value Value) error {
-	err := a.root.Insert(a.Storage, a.Address(), index, value)
+	// Don't need to wrap error as external error because err is already categorized by Array.insert().
+	return a.insert(a.Storage, index, value)
+}
+
+// insert is Insert with slab operations performed through storage.
+func (a *Array) insert(storage SlabStorage, index uint64, value Value) error {
+	if a.readOnly {
+		return NewReadOnlyError(a.ValueID())
+	}
+
+	err := a.validateElement(value)
+	if err != nil {
+		return err
+	}
+
+	err = a.root.Insert(storage, a.Address(), index, value)
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by ArraySlab.Insert().
 		return err
 	}
 
+	a.modCount++
+	a.structuralModCount++
+
 	if a.root.IsFull() {
-		err = a.splitRoot()
+		err = a.splitRoot(storage)
 		if err != nil {
 			// Don't need to wrap error as external error because err is already categorized by Array.splitRoot().
 			return err
@@ -490,12 +702,74 @@ func (a *Array) Insert(index uint64, value Value) error {
 	return nil
 }
 
+// CopyRangeTo inserts srcCount elements of array a starting at srcStart
+// into dst at dstIndex.  dst can be the same array as a, and source range
+// can overlap dstIndex.
+//
+// Each copied element is stored again with dst's address, so elements
+// stored in external StorableSlab are copied instead of being shared
+// between arrays.  Container elements (Array and OrderedMap) are not
+// supported because they can only have one parent.
+func (a *Array) CopyRangeTo(dst *Array, dstIndex, srcStart, srcCount uint64) error {
+	srcCountTotal := a.Count()
+
+	if srcStart > srcCountTotal || srcCount > srcCountTotal-srcStart {
+		return NewSliceOutOfBoundsError(srcStart, srcStart+srcCount, 0, srcCountTotal)
+	}
+
+	if dstIndex > dst.Count() {
+		return NewIndexOutOfBoundsError(dstIndex, 0, dst.Count())
+	}
+
+	if srcCount == 0 {
+		return nil
+	}
+
+	// Read all source values before modifying dst, so
+	// overlapping range is handled correctly when dst is a.
+	values := make([]Value, 0, srcCount)
+
+	err := a.IterateReadOnlyRange(srcStart, srcStart+srcCount, func(v Value) (bool, error) {
+		switch v.(type) {
+		case *Array, *OrderedMap:
+			return false, NewUserError(fmt.Errorf("failed to copy array element: container element %s isn't supported", v))
+		}
+		values = append(values, v)
+		return true, nil
+	})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.IterateReadOnlyRange().
+		return err
+	}
+
+	for i, v := range values {
+		err = dst.Insert(dstIndex+uint64(i), v)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by Array.Insert().
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (a *Array) Remove(index uint64) (Storable, error) {
+	if a.readOnly {
+		return nil, NewReadOnlyError(a.ValueID())
+	}
+
+	if a.IsEmpty() {
+		return nil, NewIndexOutOfBoundsError(index, 0, 0)
+	}
+
 	storable, err := a.remove(index)
 	if err != nil {
 		return nil, err
 	}
 
+	a.modCount++
+	a.structuralModCount++
+
 	// If removed storable is an inlined slab, uninline the slab and store it in storage.
 	// This is to prevent potential data loss because the overwritten inlined slab was not in
 	// storage and any future changes to it would have been lost.
@@ -512,6 +786,49 @@ func (a *Array) Remove(index uint64) (Storable, error) {
 	return removedStorable, nil
 }
 
+// PeekFirst returns the first element of the array.
+// It returns IndexOutOfBoundsError if the array is empty.
+func (a *Array) PeekFirst() (Value, error) {
+	if a.IsEmpty() {
+		return nil, NewIndexOutOfBoundsError(0, 0, 0)
+	}
+	// Don't need to wrap error as external error because err is already categorized by Array.Get().
+	return a.Get(0)
+}
+
+// PeekLast returns the last element of the array.
+// It returns IndexOutOfBoundsError if the array is empty.
+func (a *Array) PeekLast() (Value, error) {
+	if a.IsEmpty() {
+		return nil, NewIndexOutOfBoundsError(0, 0, 0)
+	}
+	// Don't need to wrap error as external error because err is already categorized by Array.Get().
+	return a.Get(a.Count() - 1)
+}
+
+// PopFirst removes and returns the first element of the array,
+// so the array can be used as a FIFO queue.
+// It returns IndexOutOfBoundsError if the array is empty.
+func (a *Array) PopFirst() (Storable, error) {
+	if a.IsEmpty() {
+		return nil, NewIndexOutOfBoundsError(0, 0, 0)
+	}
+	// Don't need to wrap error as external error because err is already categorized by Array.Remove().
+	return a.Remove(0)
+}
+
+// PopLast removes and returns the last element of the array,
+// so the array can be used as a LIFO stack.  Removing the last
+// element doesn't shift any remaining elements.
+// It returns IndexOutOfBoundsError if the array is empty.
+func (a *Array) PopLast() (Storable, error) {
+	if a.IsEmpty() {
+		return nil, NewIndexOutOfBoundsError(0, 0, 0)
+	}
+	// Don't need to wrap error as external error because err is already categorized by Array.Remove().
+	return a.Remove(a.Count() - 1)
+}
+
 func (a *Array) remove(index uint64) (Storable, error) {
 	storable, err := a.root.Remove(a.Storage, index)
 	if err != nil {
@@ -523,7 +840,7 @@ func (a *Array) remove(index uint64) (Storable, error) {
 		// Set root to its child slab if root has one child slab.
 		root := a.root.(*ArrayMetaDataSlab)
 		if len(root.childrenHeaders) == 1 {
-			err = a.promoteChildAsNewRoot(root.childrenHeaders[0].slabID)
+			err = a.promoteChildAsNewRoot(a.Storage, root.childrenHeaders[0].slabID)
 			if err != nil {
 				// Don't need to wrap error as external error because err is already categorized by Array.promoteChildAsNewRoot().
 				return nil, err
@@ -551,6 +868,9 @@ type ArrayPopIterationFunc func(Storable)
 // PopIterate iterates and removes elements backward.
 // Each element is passed to ArrayPopIterationFunc callback before removal.
 func (a *Array) PopIterate(fn ArrayPopIterationFunc) error {
+	if a.readOnly {
+		return NewReadOnlyError(a.ValueID())
+	}
 
 	err := a.root.PopIterate(a.Storage, fn)
 	if err != nil {
@@ -558,6 +878,9 @@ func (a *Array) PopIterate(fn ArrayPopIterationFunc) error {
 		return err
 	}
 
+	a.modCount++
+	a.structuralModCount++
+
 	rootID := a.root.SlabID()
 
 	extraData := a.root.ExtraData()
@@ -590,9 +913,200 @@ func (a *Array) PopIterate(fn ArrayPopIterationFunc) error {
 	return nil
 }
 
+// SetElementValidator sets validator to check new elements before they are
+// stored by Append, Insert, and Set.  Validator error is returned and
+// array is unchanged if validator rejects new element.  Nil validator
+// (default) disables validation.
+func (a *Array) SetElementValidator(validator ArrayElementValidator) {
+	a.elementValidator = validator
+}
+
+func (a *Array) validateElement(value Value) error {
+	if a.elementValidator == nil {
+		return nil
+	}
+
+	err := a.elementValidator(value)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by ArrayElementValidator callback.
+		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to validate array element")
+	}
+
+	return nil
+}
+
+// Compact rebuilds array slab tree from its elements, so an array with
+// under-filled slabs (e.g. after many removes) has the same minimal tree
+// as an array built by NewArrayFromBatchData with the same elements.
+// Elements are moved to new data slabs, and slabs no longer used are
+// removed from storage.  Root slab ID and type info are preserved.
+// Compact is a no-op for array with root data slab.
+func (a *Array) Compact() error {
+	if a.readOnly {
+		return NewReadOnlyError(a.ValueID())
+	}
+
+	if a.root.IsData() {
+		return nil
+	}
+
+	rootID := a.root.SlabID()
+	address := rootID.address
+
+	oldSlabIDs, err := arrayNonRootSlabIDs(a.Storage, a.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by arrayNonRootSlabIDs().
+		return err
+	}
+
+	dataSlab, err := firstArrayDataSlab(a.Storage, a.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstArrayDataSlab().
+		return err
+	}
+
+	var slabs []ArraySlab
+
+	id, err := a.Storage.GenerateSlabID(address)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return wrapErrorfAsExternalErrorIfNeeded(
+			err,
+			fmt.Sprintf("failed to generate slab ID for address 0x%x", address))
+	}
+
+	newDataSlab := &ArrayDataSlab{
+		header: ArraySlabHeader{
+			slabID: id,
+			size:   arrayDataSlabPrefixSize,
+		},
+	}
+
+	// Append elements of all data slabs to new data slabs
+	for {
+		for _, storable := range dataSlab.elements {
+
+			// Finalize current data slab without appending new element
+			if newDataSlab.header.size >= uint32(targetThreshold) {
+
+				nextID, err := a.Storage.GenerateSlabID(address)
+				if err != nil {
+					// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+					return wrapErrorfAsExternalErrorIfNeeded(
+						err,
+						fmt.Sprintf("failed to generate slab ID for address 0x%x", address))
+				}
+
+				newDataSlab.next = nextID
+
+				slabs = append(slabs, newDataSlab)
+
+				newDataSlab = &ArrayDataSlab{
+					header: ArraySlabHeader{
+						slabID: nextID,
+						size:   arrayDataSlabPrefixSize,
+					},
+				}
+			}
+
+			newDataSlab.elements = append(newDataSlab.elements, storable)
+			newDataSlab.header.count++
+			newDataSlab.header.size += storable.ByteSize()
+		}
+
+		if dataSlab.next == SlabIDUndefined {
+			break
+		}
+
+		slab, err := getArraySlab(a.Storage, dataSlab.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+			return err
+		}
+
+		var ok bool
+		dataSlab, ok = slab.(*ArrayDataSlab)
+		if !ok {
+			return NewSlabDataErrorf("slab %s isn't ArrayDataSlab", slab.SlabID())
+		}
+	}
+
+	// Append last data slab
+	slabs = append(slabs, newDataSlab)
+
+	root, err := newArraySlabTree(a.Storage, address, slabs)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by newArraySlabTree().
+		return err
+	}
+
+	// Preserve root slab ID and extra data
+	root.SetSlabID(rootID)
+	root.SetExtraData(a.root.ExtraData())
+
+	err = storeSlab(a.Storage, root)
+	if err != nil {
+		return err
+	}
+
+	a.root = root
+
+	a.modCount++
+	a.structuralModCount++
+
+	// Remove slabs of old slab tree
+	for _, id := range oldSlabIDs {
+		err = a.Storage.Remove(id)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", id))
+		}
+	}
+
+	return nil
+}
+
+// arrayNonRootSlabIDs returns IDs of data and metadata slabs
+// of array slab tree excluding root slab.
+func arrayNonRootSlabIDs(storage SlabStorage, root ArraySlab) ([]SlabID, error) {
+	var ids []SlabID
+
+	var collect func(slab ArraySlab) error
+	collect = func(slab ArraySlab) error {
+		metaSlab, ok := slab.(*ArrayMetaDataSlab)
+		if !ok {
+			return nil
+		}
+
+		for _, h := range metaSlab.childrenHeaders {
+			ids = append(ids, h.slabID)
+
+			child, err := getArraySlab(storage, h.slabID)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+				return err
+			}
+
+			err = collect(child)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err := collect(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
 // Slab operations (split root, promote child slab to root)
 
-func (a *Array) splitRoot() error {
+func (a *Array) splitRoot(storage SlabStorage) error {
 
 	if a.root.IsData() {
 		// Adjust root data slab size before splitting
@@ -607,7 +1121,7 @@ func (a *Array) splitRoot() error {
 	rootID := a.root.SlabID()
 
 	// Assign a new slab ID to old root before splitting it.
-	sID, err := a.Storage.GenerateSlabID(a.Address())
+	sID, err := storage.GenerateSlabID(a.Address())
 	if err != nil {
 		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
 		return wrapErrorfAsExternalErrorIfNeeded(
@@ -619,7 +1133,7 @@ func (a *Array) splitRoot() error {
 	oldRoot.SetSlabID(sID)
 
 	// Split old root
-	leftSlab, rightSlab, err := oldRoot.Split(a.Storage)
+	leftSlab, rightSlab, err := oldRoot.Split(storage)
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by ArraySlab.Split().
 		return err
@@ -642,22 +1156,22 @@ func (a *Array) splitRoot() error {
 
 	a.root = newRoot
 
-	err = storeSlab(a.Storage, left)
+	err = storeSlab(storage, left)
 	if err != nil {
 		return err
 	}
 
-	err = storeSlab(a.Storage, right)
+	err = storeSlab(storage, right)
 	if err != nil {
 		return err
 	}
 
-	return storeSlab(a.Storage, a.root)
+	return storeSlab(storage, a.root)
 }
 
-func (a *Array) promoteChildAsNewRoot(childID SlabID) error {
+func (a *Array) promoteChildAsNewRoot(storage SlabStorage, childID SlabID) error {
 
-	child, err := getArraySlab(a.Storage, childID)
+	child, err := getArraySlab(storage, childID)
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by getArraySlab().
 		return err
@@ -679,12 +1193,12 @@ func (a *Array) promoteChildAsNewRoot(childID SlabID) error {
 
 	a.root.SetExtraData(extraData)
 
-	err = storeSlab(a.Storage, a.root)
+	err = storeSlab(storage, a.root)
 	if err != nil {
 		return err
 	}
 
-	err = a.Storage.Remove(childID)
+	err = storage.Remove(childID)
 	if err != nil {
 		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
 		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", childID))
@@ -727,6 +1241,25 @@ func (a *Array) decrementIndexFrom(index uint64) error {
 	return nil
 }
 
+// checkModCount returns ConcurrentModificationError if array is
+// modified after modCount is captured by iterator.
+func (a *Array) checkModCount(modCount uint64) error {
+	if a.modCount != modCount {
+		return NewConcurrentModificationError(a.ValueID())
+	}
+	return nil
+}
+
+// checkStructuralModCount returns ConcurrentModificationError if array
+// elements are inserted or removed after structuralModCount is captured
+// by mutable iterator or view.
+func (a *Array) checkStructuralModCount(structuralModCount uint64) error {
+	if a.structuralModCount != structuralModCount {
+		return NewConcurrentModificationError(a.ValueID())
+	}
+	return nil
+}
+
 func (a *Array) getIndexByValueID(id ValueID) (uint64, bool) {
 	index, exist := a.mutableElementIndex[id]
 	return index, exist
@@ -747,6 +1280,12 @@ func (a *Array) setCallbackWithChild(i uint64, child Value, maxInlineSize uint64
 		return
 	}
 
+	// Child of read-only container is read-only, so parent doesn't need to be notified.
+	if a.readOnly {
+		setReadOnly(c)
+		return
+	}
+
 	if maxInlineSize < wrapperSize {
 		maxInlineSize = 0
 	} else {
@@ -809,7 +1348,7 @@ func (a *Array) setCallbackWithChild(i uint64, child Value, maxInlineSize uint64
 
 		// Set child value with parent array using updated index.
 		// Set() calls child.Storable() which returns inlined or not-inlined child storable.
-		existingValueStorable, err := a.set(adjustedIndex, child)
+		existingValueStorable, err := a.set(a.Storage, adjustedIndex, child)
 		if err != nil {
 			return false, err
 		}
@@ -900,6 +1439,9 @@ func (a *Array) getMutableElementIndex() map[ValueID]uint64 {
 // - SlabIDStorable, or
 // - inlined data slab storable
 func (a *Array) Storable(_ SlabStorage, _ Address, maxInlineSize uint64) (Storable, error) {
+	if a.readOnly {
+		return nil, NewReadOnlyError(a.ValueID())
+	}
 
 	inlined := a.root.Inlined()
 	inlinable := a.root.Inlinable(maxInlineSize)
@@ -959,8 +1501,9 @@ func (a *Array) Iterator() (ArrayIterator, error) {
 	}
 
 	return &mutableArrayIterator{
-		array:     a,
-		lastIndex: a.Count(),
+		array:              a,
+		lastIndex:          a.Count(),
+		structuralModCount: a.structuralModCount,
 	}, nil
 }
 
@@ -1007,6 +1550,7 @@ func (a *Array) ReadOnlyIteratorWithMutationCallback(
 		dataSlab:              slab,
 		remainingCount:        a.Count(),
 		valueMutationCallback: valueMutationCallback,
+		modCount:              a.modCount,
 	}, nil
 }
 
@@ -1026,9 +1570,10 @@ func (a *Array) RangeIterator(startIndex uint64, endIndex uint64) (ArrayIterator
 	}
 
 	return &mutableArrayIterator{
-		array:     a,
-		nextIndex: startIndex,
-		lastIndex: endIndex,
+		array:              a,
+		nextIndex:          startIndex,
+		lastIndex:          endIndex,
+		structuralModCount: a.structuralModCount,
 	}, nil
 }
 
@@ -1113,6 +1658,7 @@ func (a *Array) ReadOnlyRangeIteratorWithMutationCallback(
 		indexInDataSlab:       index,
 		remainingCount:        numberOfElements,
 		valueMutationCallback: valueMutationCallback,
+		modCount:              a.modCount,
 	}, nil
 }
 
@@ -1157,13 +1703,24 @@ func (a *Array) ReadOnlyLoadedValueIterator() (*ArrayLoadedValueIterator, error)
 
 // Iterate functions with callback
 
-func (a *Array) Iterate(fn ArrayIterationFunc) error {
+// Iterate iterates array elements.  Options (such as WithRetrieveRetries)
+// configure iteration.
+func (a *Array) Iterate(fn ArrayIterationFunc, opts ...IterationOption) error {
+	if a.IsEmpty() {
+		return nil
+	}
+
+	config := newIterationConfig(opts)
+
 	iterator, err := a.Iterator()
+	for retry := 0; err != nil && config.shouldRetry(err, retry); retry++ {
+		iterator, err = a.Iterator()
+	}
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by Array.Iterator().
 		return err
 	}
-	return iterateArray(iterator, fn)
+	return iterateArray(iterator, fn, config)
 }
 
 // IterateReadOnly iterates readonly array elements.
@@ -1173,8 +1730,133 @@ func (a *Array) Iterate(fn ArrayIterationFunc) error {
 // NOTE:
 // Use readonly iterator if mutation is not needed for better performance.
 // If callback is needed (e.g. for logging mutation, etc.), use IterateReadOnlyWithMutationCallback().
-func (a *Array) IterateReadOnly(fn ArrayIterationFunc) error {
-	return a.IterateReadOnlyWithMutationCallback(fn, nil)
+// Options (such as WithRetrieveRetries) configure iteration.
+func (a *Array) IterateReadOnly(fn ArrayIterationFunc, opts ...IterationOption) error {
+	return a.iterateReadOnly(fn, nil, newIterationConfig(opts))
+}
+
+// ForEachDataSlab iterates readonly array elements in batches of data slabs.
+// fn is called with all elements of each data slab in order, so consumer can
+// process elements in chunks matching storage layout.  elements passed to fn
+// is reused between calls, so it must be copied if retained.
+// If elements are mutated:
+// - those changes are not guaranteed to persist.
+// - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
+func (a *Array) ForEachDataSlab(fn ArrayDataSlabIterationFunc) error {
+	if a.IsEmpty() {
+		return nil
+	}
+
+	dataSlab, err := firstArrayDataSlab(a.Storage, a.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstArrayDataSlab().
+		return err
+	}
+
+	// iterator is only used to set up mutation callback of child containers
+	// the same way as readonly iterator.
+	iterator := &readOnlyArrayIterator{
+		array:                 a,
+		valueMutationCallback: defaultReadOnlyArrayIteratorMutatinCallback,
+	}
+
+	modCount := a.modCount
+
+	var elements []Value
+
+	for {
+		elements = elements[:0]
+
+		for _, storable := range dataSlab.elements {
+			element, err := storable.StoredValue(a.Storage)
+			if err != nil {
+				// Wrap err as external error (if needed) because err is returned by Storable interface.
+				return wrapErrorfAsExternalErrorIfNeeded(err, "failed to get storable's stored value")
+			}
+
+			iterator.setMutationCallback(element)
+
+			elements = append(elements, element)
+		}
+
+		resume, err := fn(elements)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by ArrayDataSlabIterationFunc callback.
+			return wrapErrorAsExternalErrorIfNeeded(err)
+		}
+		if !resume {
+			return nil
+		}
+
+		err = a.checkModCount(modCount)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by Array.checkModCount().
+			return err
+		}
+
+		if dataSlab.next == SlabIDUndefined {
+			return nil
+		}
+
+		slab, err := getArraySlab(a.Storage, dataSlab.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+			return err
+		}
+
+		var ok bool
+		dataSlab, ok = slab.(*ArrayDataSlab)
+		if !ok {
+			return NewSlabDataErrorf("slab %s isn't ArrayDataSlab", slab.SlabID())
+		}
+	}
+}
+
+// ReduceArray folds array elements from left to right with fn, starting with initial.
+// Elements are iterated with readonly iterator without loading all elements in memory.
+// If fn returns error, iteration is stopped and error is returned.
+func ReduceArray[T any](a *Array, initial T, fn func(acc T, v Value) (T, error)) (T, error) {
+	acc := initial
+
+	err := a.IterateReadOnly(func(v Value) (bool, error) {
+		var err error
+		acc, err = fn(acc, v)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		var zero T
+		// Don't need to wrap error as external error because err is already categorized by Array.IterateReadOnly().
+		return zero, err
+	}
+
+	return acc, nil
+}
+
+// Contains returns true if array has element equal to value.  Elements are
+// compared with equal(value, element) in iteration order without loading
+// all elements in memory, and iteration is stopped at the first match, so
+// data slabs after the matching element aren't retrieved.
+// If equal returns error, iteration is stopped and error is returned.
+func (a *Array) Contains(value Value, equal func(Value, Value) (bool, error)) (bool, error) {
+	found := false
+
+	err := a.IterateReadOnly(func(element Value) (bool, error) {
+		var err error
+		found, err = equal(value, element)
+		if err != nil {
+			return false, err
+		}
+		return !found, nil
+	})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.IterateReadOnly().
+		return false, err
+	}
+
+	return found, nil
 }
 
 // IterateReadOnlyWithMutationCallback iterates readonly array elements.
@@ -1192,12 +1874,27 @@ func (a *Array) IterateReadOnlyWithMutationCallback(
 	fn ArrayIterationFunc,
 	valueMutationCallback ReadOnlyArrayIteratorMutationCallback,
 ) error {
+	return a.iterateReadOnly(fn, valueMutationCallback, iterationConfig{})
+}
+
+func (a *Array) iterateReadOnly(
+	fn ArrayIterationFunc,
+	valueMutationCallback ReadOnlyArrayIteratorMutationCallback,
+	config iterationConfig,
+) error {
+	if a.IsEmpty() {
+		return nil
+	}
+
 	iterator, err := a.ReadOnlyIteratorWithMutationCallback(valueMutationCallback)
+	for retry := 0; err != nil && config.shouldRetry(err, retry); retry++ {
+		iterator, err = a.ReadOnlyIteratorWithMutationCallback(valueMutationCallback)
+	}
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by Array.ReadOnlyIterator().
 		return err
 	}
-	return iterateArray(iterator, fn)
+	return iterateArray(iterator, fn, config)
 }
 
 func (a *Array) IterateRange(startIndex uint64, endIndex uint64, fn ArrayIterationFunc) error {
@@ -1206,7 +1903,7 @@ func (a *Array) IterateRange(startIndex uint64, endIndex uint64, fn ArrayIterati
 		// Don't need to wrap error as external error because err is already categorized by Array.RangeIterator().
 		return err
 	}
-	return iterateArray(iterator, fn)
+	return iterateArray(iterator, fn, iterationConfig{})
 }
 
 // IterateReadOnlyRange iterates readonly array elements from specified startIndex to endIndex.
@@ -1247,7 +1944,7 @@ func (a *Array) IterateReadOnlyRangeWithMutationCallback(
 		// Don't need to wrap error as external error because err is already categorized by Array.ReadOnlyRangeIterator().
 		return err
 	}
-	return iterateArray(iterator, fn)
+	return iterateArray(iterator, fn, iterationConfig{})
 }
 
 // IterateReadOnlyLoadedValues iterates loaded array values.
@@ -1292,6 +1989,15 @@ func (a *Array) Count() uint64 {
 	return uint64(a.root.Header().count)
 }
 
+// IsEmpty returns true if array has no elements.
+func (a *Array) IsEmpty() bool {
+	return a.root.Header().count == 0
+}
+
+// SlabID returns root slab ID of array.  Root slab ID is generated when
+// array is created, and it doesn't change when root slab is split, merged,
+// or committed.  SlabID returns SlabIDUndefined while array is inlined in
+// parent container.  See OrderedMap.SlabID for details.
 func (a *Array) SlabID() SlabID {
 	if a.root.Inlined() {
 		return SlabIDUndefined
@@ -1310,7 +2016,89 @@ func (a *Array) Type() TypeInfo {
 	return nil
 }
 
+// ExtraData returns copy of extra data in array root slab (type info),
+// so array metadata can be inspected without using array.  Modifying
+// returned extra data doesn't modify array.  Array count isn't part of
+// extra data, and it is returned by Count.
+func (a *Array) ExtraData() ArrayExtraData {
+	extraData := a.root.ExtraData()
+	if extraData == nil {
+		return ArrayExtraData{}
+	}
+
+	copied := *extraData
+	if copied.TypeInfo != nil {
+		copied.TypeInfo = copied.TypeInfo.Copy()
+	}
+	return copied
+}
+
+// DeepCopy returns a new array at given address with copied elements.
+// Nested Array and OrderedMap elements are deep copied recursively, and
+// other elements are stored again with new address, so the new array and
+// its nested containers have new slab IDs and share no slabs with array a.
+// comparator and hip are used to deep copy nested OrderedMap elements.
+func (a *Array) DeepCopy(
+	storage SlabStorage,
+	address Address,
+	comparator ValueComparator,
+	hip HashInputProvider,
+) (*Array, error) {
+	iterator, err := a.ReadOnlyIterator()
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.ReadOnlyIterator().
+		return nil, err
+	}
+
+	copied, err := NewArrayFromBatchData(
+		storage,
+		address,
+		a.Type(),
+		func() (Value, error) {
+			v, err := iterator.Next()
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by ArrayIterator.Next().
+				return nil, err
+			}
+			if v == nil {
+				return nil, nil
+			}
+
+			// Don't need to wrap error as external error because err is already categorized by deepCopyValue().
+			return deepCopyValue(storage, address, comparator, hip, v)
+		})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by NewArrayFromBatchData().
+		return nil, err
+	}
+
+	if schemaID := a.SchemaID(); schemaID != 0 {
+		copied.root.ExtraData().SchemaID = schemaID
+
+		// Store modified root slab in storage since schema ID is part of extraData stored in root slab.
+		err = storeSlab(storage, copied.root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return copied, nil
+}
+
+// SchemaID returns schema ID recorded with array by NewArrayWithSchema.
+// It returns 0 if array doesn't have schema ID.
+func (a *Array) SchemaID() uint64 {
+	if extraData := a.root.ExtraData(); extraData != nil {
+		return extraData.SchemaID
+	}
+	return 0
+}
+
 func (a *Array) SetType(typeInfo TypeInfo) error {
+	if a.readOnly {
+		return NewReadOnlyError(a.ValueID())
+	}
+
 	extraData := a.root.ExtraData()
 	extraData.TypeInfo = typeInfo
 
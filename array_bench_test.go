@@ -147,6 +147,52 @@ func BenchmarkArrayPopIterate(b *testing.B) {
 	}
 }
 
+// BenchmarkArrayForEachDataSlab benchmarks summing array elements
+// element-wise with IterateReadOnly and chunk-wise with ForEachDataSlab.
+func BenchmarkArrayForEachDataSlab(b *testing.B) {
+	const initialArrayCount = 100_000
+
+	storage := newTestPersistentStorage(b)
+
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	array, err := atree.NewArray(storage, address, test_utils.NewSimpleTypeInfo(42))
+	require.NoError(b, err)
+
+	for i := range initialArrayCount {
+		err := array.Append(test_utils.Uint64Value(i))
+		require.NoError(b, err)
+	}
+
+	b.Run("element-wise", func(b *testing.B) {
+		var sum uint64
+		for range b.N {
+			sum = 0
+			err := array.IterateReadOnly(func(v atree.Value) (bool, error) {
+				sum += uint64(v.(test_utils.Uint64Value))
+				return true, nil
+			})
+			require.NoError(b, err)
+		}
+		noopValue = test_utils.Uint64Value(sum)
+	})
+
+	b.Run("chunk-wise", func(b *testing.B) {
+		var sum uint64
+		for range b.N {
+			sum = 0
+			err := array.ForEachDataSlab(func(elements []atree.Value) (bool, error) {
+				for _, v := range elements {
+					sum += uint64(v.(test_utils.Uint64Value))
+				}
+				return true, nil
+			})
+			require.NoError(b, err)
+		}
+		noopValue = test_utils.Uint64Value(sum)
+	})
+}
+
 func BenchmarkNewArrayFromAppend(b *testing.B) {
 	benchmarks := []struct {
 		name              string
@@ -394,3 +440,80 @@ func benchmarkNewArrayFromBatchData(b *testing.B, initialArrayCount int) {
 		}
 	}
 }
+
+func BenchmarkConcatArrays(b *testing.B) {
+	benchmarks := []struct {
+		name       string
+		arrayCount int
+		long       bool
+	}{
+		{"1000", 1000, false},
+		{"10000", 10_000, false},
+		{"100000", 100_000, false},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			if bm.long && testing.Short() {
+				b.Skipf("Skipping %s in short mode", bm.name)
+			}
+
+			b.Run("ConcatArrays", func(b *testing.B) {
+				benchmarkConcatArrays(b, bm.arrayCount, false)
+			})
+
+			b.Run("Append", func(b *testing.B) {
+				benchmarkConcatArrays(b, bm.arrayCount, true)
+			})
+		})
+	}
+}
+
+func benchmarkConcatArrays(b *testing.B, arrayCount int, appendAll bool) {
+
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+
+	storage := newTestPersistentStorage(b)
+
+	newArray := func(start int) *atree.Array {
+		i := start
+		array, err := atree.NewArrayFromBatchData(storage, address, typeInfo, func() (atree.Value, error) {
+			if i == start+arrayCount {
+				return nil, nil
+			}
+			v := test_utils.Uint64Value(i)
+			i++
+			return v, nil
+		})
+		require.NoError(b, err)
+		return array
+	}
+
+	for range b.N {
+		b.StopTimer()
+
+		array1 := newArray(0)
+		array2 := newArray(arrayCount)
+
+		b.StartTimer()
+
+		var concatenated *atree.Array
+		if appendAll {
+			err := array2.IterateReadOnly(func(v atree.Value) (bool, error) {
+				return true, array1.Append(v)
+			})
+			require.NoError(b, err)
+
+			concatenated = array1
+		} else {
+			var err error
+			concatenated, err = atree.ConcatArrays(storage, address, typeInfo, array1, array2, test_utils.CompareValue, test_utils.GetHashInput)
+			require.NoError(b, err)
+		}
+
+		if concatenated.Count() != uint64(arrayCount*2) {
+			b.Errorf("Concatenated array has %d elements, want %d", concatenated.Count(), arrayCount*2)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"fmt"
+)
+
+// ConcatArrays returns a new array with elements of a followed by elements of b.
+//
+// If a and b are in given storage and at given address, their slabs are
+// reused instead of re-appending elements: slab tree of the shorter array
+// is grafted onto slab tree of the taller array at the level of the same
+// height, and only slabs along the seam are rebalanced.  Returned array has
+// a's root slab ID, and a and b must not be used after ConcatArrays returns.
+//
+// Array in different storage or at different address is deep copied to
+// given address first, and it is left unchanged.  comparator and hip are
+// used to deep copy nested OrderedMap elements.
+//
+// a and b must be different root arrays (not child containers).
+func ConcatArrays(
+	storage SlabStorage,
+	address Address,
+	typeInfo TypeInfo,
+	a, b *Array,
+	comparator ValueComparator,
+	hip HashInputProvider,
+) (*Array, error) {
+
+	if a == nil || b == nil {
+		return nil, NewUserError(fmt.Errorf("failed to concatenate arrays: array is nil"))
+	}
+
+	if a == b || (a.Storage == b.Storage && a.SlabID() == b.SlabID()) {
+		return nil, NewUserError(fmt.Errorf("failed to concatenate array %s with itself", a.ValueID()))
+	}
+
+	for _, array := range []*Array{a, b} {
+		if array.readOnly {
+			return nil, NewReadOnlyError(array.ValueID())
+		}
+		if array.Inlined() || array.hasParentUpdater() {
+			return nil, NewUserError(fmt.Errorf("failed to concatenate arrays: array %s is child container", array.ValueID()))
+		}
+	}
+
+	left, err := arrayForConcat(storage, address, comparator, hip, a)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by arrayForConcat().
+		return nil, err
+	}
+
+	right, err := arrayForConcat(storage, address, comparator, hip, b)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by arrayForConcat().
+		return nil, err
+	}
+
+	// Invalidate iterators of moved arrays.
+	left.modCount++
+	left.structuralModCount++
+	right.modCount++
+	right.structuralModCount++
+
+	root, err := concatArraySlabTrees(storage, address, left.root, right.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by concatArraySlabTrees().
+		return nil, err
+	}
+
+	root.SetExtraData(&ArrayExtraData{TypeInfo: typeInfo})
+
+	err = storeSlab(storage, root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Array{
+		Storage: storage,
+		root:    root,
+	}, nil
+}
+
+// arrayForConcat returns array if it is in given storage and at given address.
+// Otherwise, it returns a deep copy of array in given storage and at given address.
+func arrayForConcat(
+	storage SlabStorage,
+	address Address,
+	comparator ValueComparator,
+	hip HashInputProvider,
+	array *Array,
+) (*Array, error) {
+	if array.Storage == storage && array.Address() == address {
+		return array, nil
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by Array.DeepCopy().
+	return array.DeepCopy(storage, address, comparator, hip)
+}
+
+// concatArraySlabTrees grafts slab tree of right root after slab tree of left
+// root and returns root slab of combined slab tree with left root's slab ID.
+// Extra data of left and right roots are removed, and caller is responsible
+// for setting extra data in returned root and storing it.
+//
+// Both roots become children of a new root metadata slab, which grafts the
+// shorter tree as the first or last child of a slab on the spine of the
+// taller tree.  Only the grafted slab and the spine are rebalanced, and
+// new root is replaced by its only child if needed.
+func concatArraySlabTrees(storage SlabStorage, address Address, left, right ArraySlab) (ArraySlab, error) {
+
+	leftHeight, err := arraySlabHeight(storage, left)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by arraySlabHeight().
+		return nil, err
+	}
+
+	rightHeight, err := arraySlabHeight(storage, right)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by arraySlabHeight().
+		return nil, err
+	}
+
+	// Link last data slab of left tree to first data slab of right tree.
+	leftLastDataSlab, err := arrayEdgeDataSlab(storage, left, true)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by arrayEdgeDataSlab().
+		return nil, err
+	}
+
+	rightFirstDataSlab, err := arrayEdgeDataSlab(storage, right, false)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by arrayEdgeDataSlab().
+		return nil, err
+	}
+
+	leftLastDataSlab.next = rightFirstDataSlab.SlabID()
+
+	err = storeSlab(storage, leftLastDataSlab)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert left and right roots to non-root slabs.
+	rootID := left.SlabID()
+
+	for _, slab := range []ArraySlab{left, right} {
+		slab.RemoveExtraData()
+
+		if dataSlab, ok := slab.(*ArrayDataSlab); ok {
+			dataSlab.header.size = dataSlab.header.size - arrayRootDataSlabPrefixSize + arrayDataSlabPrefixSize
+		}
+	}
+
+	// Assign a new slab ID to left root, so new root has left root's slab ID.
+	newID, err := storage.GenerateSlabID(address)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(
+			err,
+			fmt.Sprintf("failed to generate slab ID for address 0x%x", address))
+	}
+	left.SetSlabID(newID)
+
+	err = storeSlab(storage, left)
+	if err != nil {
+		return nil, err
+	}
+
+	err = storeSlab(storage, right)
+	if err != nil {
+		return nil, err
+	}
+
+	// Graft shorter tree onto taller tree, which is the only child of new root.
+	tallTree, shortTree, shortHeight := left, right, rightHeight
+	appendShortTree := true
+	if leftHeight < rightHeight {
+		tallTree, shortTree, shortHeight = right, left, leftHeight
+		appendShortTree = false
+	}
+
+	root := &ArrayMetaDataSlab{
+		header: ArraySlabHeader{
+			slabID: rootID,
+		},
+		childrenHeaders: []ArraySlabHeader{tallTree.Header()},
+	}
+	resetArrayMetaDataSlabHeader(root)
+
+	// Find path from new root to parent slab of grafted tree on the spine.
+	path := []*ArrayMetaDataSlab{root}
+	for parentHeight := max(leftHeight, rightHeight) + 1; parentHeight > shortHeight+1; parentHeight-- {
+		parent := path[len(path)-1]
+
+		childIndex := 0
+		if appendShortTree {
+			childIndex = len(parent.childrenHeaders) - 1
+		}
+
+		child, err := getArraySlab(storage, parent.childrenHeaders[childIndex].slabID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+			return nil, err
+		}
+
+		metaSlab, ok := child.(*ArrayMetaDataSlab)
+		if !ok {
+			return nil, NewSlabDataErrorf("slab %s isn't ArrayMetaDataSlab", child.SlabID())
+		}
+
+		path = append(path, metaSlab)
+	}
+
+	// Graft shorter tree.
+	parent := path[len(path)-1]
+
+	childIndex := 0
+	if appendShortTree {
+		childIndex = len(parent.childrenHeaders)
+		parent.childrenHeaders = append(parent.childrenHeaders, shortTree.Header())
+	} else {
+		parent.childrenHeaders = append([]ArraySlabHeader{shortTree.Header()}, parent.childrenHeaders...)
+	}
+	resetArrayMetaDataSlabHeader(parent)
+
+	// Grafted tree is rebalanced with its sibling, which is a child of
+	// non-root slab of taller tree.  If new root is parent, both children
+	// are old roots and they are rebalanced later.
+	if parent != root {
+		err = rebalanceArrayChildSlab(storage, parent, shortTree, childIndex)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by rebalanceArrayChildSlab().
+			return nil, err
+		}
+	}
+
+	// Update headers along the spine and split full slabs.
+	for i := len(path) - 1; i > 0; i-- {
+		child := path[i]
+		parent := path[i-1]
+
+		childIndex := 0
+		if appendShortTree {
+			childIndex = len(parent.childrenHeaders) - 1
+		}
+
+		parent.childrenHeaders[childIndex] = child.Header()
+		resetArrayMetaDataSlabHeader(parent)
+
+		if child.IsFull() {
+			err = parent.SplitChildSlab(storage, child, childIndex)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by ArrayMetaDataSlab.SplitChildSlab().
+				return nil, err
+			}
+			continue
+		}
+
+		err = storeSlab(storage, child)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Rebalance children of new root, which include old roots.
+	for {
+		rebalanced, err := rebalanceArrayRootChildSlabs(storage, root)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by rebalanceArrayRootChildSlabs().
+			return nil, err
+		}
+		if !rebalanced {
+			break
+		}
+	}
+
+	if len(root.childrenHeaders) > 1 {
+		return root, nil
+	}
+
+	// Replace new root with its only child.
+	child, err := getArraySlab(storage, root.childrenHeaders[0].slabID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+		return nil, err
+	}
+
+	childID := child.SlabID()
+
+	if dataSlab, ok := child.(*ArrayDataSlab); ok {
+		dataSlab.header.size = dataSlab.header.size - arrayDataSlabPrefixSize + arrayRootDataSlabPrefixSize
+	}
+
+	child.SetSlabID(rootID)
+
+	err = storage.Remove(childID)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", childID))
+	}
+
+	return child, nil
+}
+
+// rebalanceArrayRootChildSlabs splits, merges, or rebalances the first
+// child slab of root which is full or underflow, and returns true if any
+// child slab is changed.  Underflow child is merged with underflow sibling,
+// because metadata slab can't lend enough child headers if it underflows.
+func rebalanceArrayRootChildSlabs(storage SlabStorage, root *ArrayMetaDataSlab) (bool, error) {
+	for i, h := range root.childrenHeaders {
+		child, err := getArraySlab(storage, h.slabID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+			return false, err
+		}
+
+		if child.IsFull() {
+			// Don't need to wrap error as external error because err is already categorized by ArrayMetaDataSlab.SplitChildSlab().
+			return true, root.SplitChildSlab(storage, child, i)
+		}
+
+		underflowSize, underflow := child.IsUnderflow()
+		if !underflow || len(root.childrenHeaders) == 1 {
+			continue
+		}
+
+		sibIndex := i - 1
+		if i == 0 {
+			sibIndex = i + 1
+		}
+
+		sib, err := getArraySlab(storage, root.childrenHeaders[sibIndex].slabID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+			return false, err
+		}
+
+		if _, sibUnderflow := sib.IsUnderflow(); !sibUnderflow {
+			// Don't need to wrap error as external error because err is already categorized by ArrayMetaDataSlab.MergeOrRebalanceChildSlab().
+			return true, root.MergeOrRebalanceChildSlab(storage, child, i, underflowSize)
+		}
+
+		// Merge underflow child and underflow sibling, which fit in one slab.
+		left, right, leftIndex := sib, child, sibIndex
+		if sibIndex > i {
+			left, right, leftIndex = child, sib, i
+		}
+
+		err = left.Merge(right)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by ArraySlab.Merge().
+			return false, err
+		}
+
+		root.childrenHeaders[leftIndex] = left.Header()
+		root.childrenHeaders = append(root.childrenHeaders[:leftIndex+1], root.childrenHeaders[leftIndex+2:]...)
+		resetArrayMetaDataSlabHeader(root)
+
+		err = storeSlab(storage, left)
+		if err != nil {
+			return false, err
+		}
+
+		err = storage.Remove(right.SlabID())
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return false, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", right.SlabID()))
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// rebalanceArrayChildSlab splits child slab if it is full, or merges or
+// rebalances child slab with its siblings if it is underflow.  Old root
+// slabs can be full or underflow after they become non-root slabs, because
+// non-root data slab has larger prefix and root slab can be underflow.
+func rebalanceArrayChildSlab(storage SlabStorage, parent *ArrayMetaDataSlab, child ArraySlab, childIndex int) error {
+	if child.IsFull() {
+		// Don't need to wrap error as external error because err is already categorized by ArrayMetaDataSlab.SplitChildSlab().
+		return parent.SplitChildSlab(storage, child, childIndex)
+	}
+
+	if underflowSize, underflow := child.IsUnderflow(); underflow {
+		// Don't need to wrap error as external error because err is already categorized by ArrayMetaDataSlab.MergeOrRebalanceChildSlab().
+		return parent.MergeOrRebalanceChildSlab(storage, child, childIndex, underflowSize)
+	}
+
+	return nil
+}
+
+// resetArrayMetaDataSlabHeader updates childrenCountSum, count, and size
+// of metadata slab from its childrenHeaders.
+func resetArrayMetaDataSlabHeader(slab *ArrayMetaDataSlab) {
+	slab.childrenCountSum = make([]uint32, len(slab.childrenHeaders))
+
+	count := uint32(0)
+	for i, h := range slab.childrenHeaders {
+		count += h.count
+		slab.childrenCountSum[i] = count
+	}
+
+	slab.header.count = count
+	slab.header.size = arrayMetaDataSlabPrefixSize + arraySlabHeaderSize*uint32(len(slab.childrenHeaders))
+}
+
+// arraySlabHeight returns number of metadata slab levels in slab tree of given slab.
+func arraySlabHeight(storage SlabStorage, slab ArraySlab) (int, error) {
+	height := 0
+	for !slab.IsData() {
+		metaSlab, ok := slab.(*ArrayMetaDataSlab)
+		if !ok || len(metaSlab.childrenHeaders) == 0 {
+			return 0, NewSlabDataErrorf("slab %s has no child slab", slab.SlabID())
+		}
+
+		var err error
+		slab, err = getArraySlab(storage, metaSlab.childrenHeaders[0].slabID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+			return 0, err
+		}
+
+		height++
+	}
+	return height, nil
+}
+
+// arrayEdgeDataSlab returns first or last data slab in slab tree of given slab.
+func arrayEdgeDataSlab(storage SlabStorage, slab ArraySlab, last bool) (*ArrayDataSlab, error) {
+	for {
+		switch s := slab.(type) {
+		case *ArrayDataSlab:
+			return s, nil
+
+		case *ArrayMetaDataSlab:
+			if len(s.childrenHeaders) == 0 {
+				return nil, NewSlabDataErrorf("slab %s has no child slab", s.SlabID())
+			}
+
+			index := 0
+			if last {
+				index = len(s.childrenHeaders) - 1
+			}
+
+			var err error
+			slab, err = getArraySlab(storage, s.childrenHeaders[index].slabID)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+				return nil, err
+			}
+
+		default:
+			return nil, NewSlabDataErrorf("slab %s has unexpected type %T", slab.SlabID(), slab)
+		}
+	}
+}
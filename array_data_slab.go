@@ -59,10 +59,10 @@ func (a *ArrayDataSlab) Set(storage SlabStorage, address Address, index uint64,
 
 	oldElem := a.elements[index]
 
-	storable, err := value.Storable(storage, address, maxInlineArrayElementSize)
+	storable, err := newValueStorable(storage, address, value, maxInlineArrayElementSize)
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by Value interface.
-		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get value's storable")
+		// Don't need to wrap error as external error because err is already categorized by newValueStorable().
+		return nil, err
 	}
 
 	a.elements[index] = storable
@@ -92,10 +92,10 @@ func (a *ArrayDataSlab) Insert(storage SlabStorage, address Address, index uint6
 		return NewIndexOutOfBoundsError(index, 0, uint64(len(a.elements)))
 	}
 
-	storable, err := value.Storable(storage, address, maxInlineArrayElementSize)
+	storable, err := newValueStorable(storage, address, value, maxInlineArrayElementSize)
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by Value interface.
-		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to get value's storable")
+		// Don't need to wrap error as external error because err is already categorized by newValueStorable().
+		return err
 	}
 
 	if index == uint64(len(a.elements)) {
@@ -510,10 +510,11 @@ func (a *ArrayDataSlab) String() string {
 		elemsStr[i] = fmt.Sprint(e)
 	}
 
-	return fmt.Sprintf("ArrayDataSlab id:%s size:%d count:%d elements: [%s]",
+	return fmt.Sprintf("ArrayDataSlab id:%s size:%d count:%d next:%s elements: [%s]",
 		a.header.slabID,
 		a.header.size,
 		a.header.count,
+		a.next,
 		strings.Join(elemsStr, " "),
 	)
 }
@@ -396,6 +396,7 @@ func DecodeInlinedArrayStorable(
 			// Make a copy of extraData.TypeInfo because
 			// inlined extra data are shared by all inlined slabs.
 			TypeInfo: extraData.TypeInfo.Copy(),
+			SchemaID: extraData.SchemaID,
 		},
 		inlined: true,
 	}, nil
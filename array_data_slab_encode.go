@@ -123,7 +123,7 @@ func (a *ArrayDataSlab) Encode(enc *Encoder) error {
 	elementBuf := getBuffer()
 	defer putBuffer(elementBuf)
 
-	elementEnc := NewEncoder(elementBuf, enc.encMode)
+	elementEnc := enc.newElementEncoder(elementBuf)
 
 	err := a.encodeElements(elementEnc)
 	if err != nil {
@@ -26,11 +26,19 @@ import (
 
 type ArrayExtraData struct {
 	TypeInfo TypeInfo // array type
+
+	// SchemaID is optional caller-supplied ID recorded with array
+	// (e.g. to identify element encoding used to build the array).
+	// SchemaID 0 means no schema ID, and it isn't encoded.
+	SchemaID uint64
 }
 
 var _ ExtraData = &ArrayExtraData{}
 
-const arrayExtraDataLength = 1
+const (
+	arrayExtraDataLength             = 1
+	arrayExtraDataWithSchemaIDLength = 2
+)
 
 func newArrayExtraDataFromData(
 	data []byte,
@@ -54,18 +62,23 @@ func newArrayExtraDataFromData(
 // newArrayExtraData decodes CBOR array to extra data:
 //
 //	cborArray{type info}
+//
+// or extra data with schema ID:
+//
+//	cborArray{type info, schema ID}
 func newArrayExtraData(dec *cbor.StreamDecoder, decodeTypeInfo TypeInfoDecoder) (*ArrayExtraData, error) {
 	length, err := dec.DecodeArrayHead()
 	if err != nil {
 		return nil, NewDecodingError(err)
 	}
 
-	if length != arrayExtraDataLength {
+	if length != arrayExtraDataLength && length != arrayExtraDataWithSchemaIDLength {
 		return nil, NewDecodingError(
 			fmt.Errorf(
-				"array extra data has invalid length %d, want %d",
+				"array extra data has invalid length %d, want %d or %d",
 				length,
 				arrayExtraDataLength,
+				arrayExtraDataWithSchemaIDLength,
 			))
 	}
 
@@ -75,14 +88,34 @@ func newArrayExtraData(dec *cbor.StreamDecoder, decodeTypeInfo TypeInfoDecoder)
 		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to decode type info")
 	}
 
-	return &ArrayExtraData{TypeInfo: typeInfo}, nil
+	var schemaID uint64
+	if length == arrayExtraDataWithSchemaIDLength {
+		schemaID, err = dec.DecodeUint64()
+		if err != nil {
+			return nil, NewDecodingError(err)
+		}
+		if schemaID == 0 {
+			return nil, NewDecodingError(fmt.Errorf("array extra data has encoded schema ID 0"))
+		}
+	}
+
+	return &ArrayExtraData{TypeInfo: typeInfo, SchemaID: schemaID}, nil
 }
 
 // Encode encodes extra data as CBOR array:
 //
 //	[type info]
+//
+// or extra data with non-zero schema ID:
+//
+//	[type info, schema ID]
 func (a *ArrayExtraData) Encode(enc *Encoder, encodeTypeInfo encodeTypeInfo) error {
-	err := enc.CBOR.EncodeArrayHead(arrayExtraDataLength)
+	length := arrayExtraDataLength
+	if a.SchemaID != 0 {
+		length = arrayExtraDataWithSchemaIDLength
+	}
+
+	err := enc.CBOR.EncodeArrayHead(uint64(length))
 	if err != nil {
 		return NewEncodingError(err)
 	}
@@ -93,6 +126,13 @@ func (a *ArrayExtraData) Encode(enc *Encoder, encodeTypeInfo encodeTypeInfo) err
 		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to encode type info")
 	}
 
+	if a.SchemaID != 0 {
+		err = enc.CBOR.EncodeUint64(a.SchemaID)
+		if err != nil {
+			return NewEncodingError(err)
+		}
+	}
+
 	err = enc.CBOR.Flush()
 	if err != nil {
 		return NewEncodingError(err)
@@ -49,9 +49,10 @@ func (*emptyArrayIterator) Next() (Value, error) {
 // Mutable array iterator
 
 type mutableArrayIterator struct {
-	array     *Array
-	nextIndex uint64
-	lastIndex uint64 // noninclusive index
+	array              *Array
+	nextIndex          uint64
+	lastIndex          uint64 // noninclusive index
+	structuralModCount uint64 // array's structuralModCount when iterator is created
 }
 
 var _ ArrayIterator = &mutableArrayIterator{}
@@ -61,6 +62,12 @@ func (i *mutableArrayIterator) CanMutate() bool {
 }
 
 func (i *mutableArrayIterator) Next() (Value, error) {
+	err := i.array.checkStructuralModCount(i.structuralModCount)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.checkStructuralModCount().
+		return nil, err
+	}
+
 	if i.nextIndex == i.lastIndex {
 		// No more elements.
 		return nil, nil
@@ -88,6 +95,7 @@ type readOnlyArrayIterator struct {
 	indexInDataSlab       uint64
 	remainingCount        uint64 // needed for range iteration
 	valueMutationCallback ReadOnlyArrayIteratorMutationCallback
+	modCount              uint64 // array's modCount when iterator is created
 }
 
 // defaultReadOnlyArrayIteratorMutatinCallback is no-op.
@@ -100,6 +108,11 @@ func (i *readOnlyArrayIterator) setMutationCallback(value Value) {
 	unwrappedChild, _ := unwrapValue(value)
 
 	if v, ok := unwrappedChild.(mutableValueNotifier); ok {
+		if i.array.readOnly {
+			setReadOnly(v)
+			return
+		}
+
 		v.setParentUpdater(func() (found bool, err error) {
 			i.valueMutationCallback(value)
 			return true, NewReadOnlyIteratorElementMutationError(i.array.ValueID(), v.ValueID())
@@ -112,6 +125,12 @@ func (i *readOnlyArrayIterator) CanMutate() bool {
 }
 
 func (i *readOnlyArrayIterator) Next() (Value, error) {
+	err := i.array.checkModCount(i.modCount)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.checkModCount().
+		return nil, err
+	}
+
 	if i.remainingCount == 0 {
 		return nil, nil
 	}
@@ -304,9 +323,16 @@ func (i *ArrayLoadedValueIterator) Next() (Value, error) {
 
 type ArrayIterationFunc func(element Value) (resume bool, err error)
 
-func iterateArray(iterator ArrayIterator, fn ArrayIterationFunc) error {
+// ArrayDataSlabIterationFunc is called with elements of one data slab.
+// elements is reused between calls, so it must be copied if retained.
+type ArrayDataSlabIterationFunc func(elements []Value) (resume bool, err error)
+
+func iterateArray(iterator ArrayIterator, fn ArrayIterationFunc, config iterationConfig) error {
 	for {
 		value, err := iterator.Next()
+		for retry := 0; err != nil && config.shouldRetry(err, retry); retry++ {
+			value, err = iterator.Next()
+		}
 		if err != nil {
 			// Don't need to wrap error as external error because err is already categorized by ArrayIterator.Next().
 			return err
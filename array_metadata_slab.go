@@ -854,20 +854,27 @@ func (a ArrayMetaDataSlab) IsFull() bool {
 }
 
 func (a ArrayMetaDataSlab) IsUnderflow() (uint32, bool) {
+	var underflowSize uint32
 	if uint32(minThreshold) > a.header.size {
-		return uint32(minThreshold) - a.header.size, true
+		underflowSize = uint32(minThreshold) - a.header.size
 	}
-	return 0, false
+	// Slab with fewer than min children is underflow even if its size isn't.
+	if n := len(a.childrenHeaders); n < minArrayMetaDataSlabFanout {
+		underflowSize = max(underflowSize, uint32(minArrayMetaDataSlabFanout-n)*arraySlabHeaderSize)
+	}
+	return underflowSize, underflowSize > 0
 }
 
 func (a *ArrayMetaDataSlab) CanLendToLeft(size uint32) bool {
 	n := uint32(math.Ceil(float64(size) / arraySlabHeaderSize))
-	return a.header.size-arraySlabHeaderSize*n > uint32(minThreshold)
+	return a.header.size-arraySlabHeaderSize*n > uint32(minThreshold) &&
+		len(a.childrenHeaders)-int(n) >= minArrayMetaDataSlabFanout
 }
 
 func (a *ArrayMetaDataSlab) CanLendToRight(size uint32) bool {
 	n := uint32(math.Ceil(float64(size) / arraySlabHeaderSize))
-	return a.header.size-arraySlabHeaderSize*n > uint32(minThreshold)
+	return a.header.size-arraySlabHeaderSize*n > uint32(minThreshold) &&
+		len(a.childrenHeaders)-int(n) >= minArrayMetaDataSlabFanout
 }
 
 // Inline operations
@@ -348,6 +348,7 @@ func computeSize(data []byte) (int, error) {
 	// - excludes slab extra data size
 	// - excludes inlined slab extra data size
 	// - adds next slab ID for non-root data slab if not encoded
+	// - adds prev slab ID for non-root map data slab if enabled and not encoded
 	size := len(data)
 	size -= slabExtraDataSize
 	size -= inlinedSlabExtrDataSize
@@ -356,6 +357,13 @@ func computeSize(data []byte) (int, error) {
 		size += SlabIDLength
 	}
 
+	isMapDataSlab := h.getSlabMapType() == slabMapData ||
+		h.getSlabMapType() == slabMapCollisionGroup
+
+	if mapDataSlabPrevSlabIDEnabled && !h.isRoot() && isMapDataSlab && !h.hasPrevSlabID() {
+		size += SlabIDLength
+	}
+
 	return size, nil
 }
 
@@ -24,9 +24,12 @@ import (
 	"math/rand"
 	"reflect"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/stretchr/testify/require"
 
 	"github.com/onflow/atree"
@@ -4978,6 +4981,91 @@ func TestArrayFromBatchData(t *testing.T) {
 
 		testArray(t, storage, typeInfo, address, copied, expectedValues, false)
 	})
+
+	t.Run("failure removes created slabs", func(t *testing.T) {
+		atree.SetThreshold(256)
+		defer atree.SetThreshold(1024)
+
+		const arrayCount = 100
+		const failAt = 60
+
+		typeInfo := test_utils.NewSimpleTypeInfo(42)
+
+		storage := atree.NewBasicSlabStorage(nil, nil, nil, nil)
+		address := atree.Address{2, 3, 4, 5, 6, 7, 8, 9}
+
+		newArray := func(failAt int) (*atree.Array, error) {
+			count := 0
+			return atree.NewArrayFromBatchData(
+				storage,
+				address,
+				typeInfo,
+				func() (atree.Value, error) {
+					if count == failAt {
+						return nil, errors.New("test")
+					}
+					if count == arrayCount {
+						return nil, nil
+					}
+					count++
+
+					// Large elements are stored in separate slabs.
+					return test_utils.NewStringValue(strings.Repeat("a", 256)), nil
+				})
+		}
+
+		array, err := newArray(failAt)
+		require.Nil(t, array)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+
+		// No slab is left in storage.
+		require.Equal(t, 0, storage.Count())
+
+		// Successful batch build uses provided storage.
+		array, err = newArray(-1)
+		require.NoError(t, err)
+		require.Equal(t, uint64(arrayCount), array.Count())
+		require.True(t, array.Storage == atree.SlabStorage(storage))
+		require.Greater(t, storage.Count(), arrayCount)
+	})
+	t.Run("failure to remove created slabs", func(t *testing.T) {
+		atree.SetThreshold(256)
+		defer atree.SetThreshold(1024)
+
+		const failAt = 60
+
+		typeInfo := test_utils.NewSimpleTypeInfo(42)
+
+		storage := &removeFailingSlabStorage{atree.NewBasicSlabStorage(nil, nil, nil, nil)}
+		address := atree.Address{2, 3, 4, 5, 6, 7, 8, 9}
+
+		errBatch := errors.New("test")
+
+		count := 0
+		array, err := atree.NewArrayFromBatchData(
+			storage,
+			address,
+			typeInfo,
+			func() (atree.Value, error) {
+				if count == failAt {
+					return nil, errBatch
+				}
+				count++
+
+				// Large elements are stored in separate slabs.
+				return test_utils.NewStringValue(strings.Repeat("a", 256)), nil
+			})
+		require.Nil(t, array)
+
+		// Error of batch build is returned with error of removing created slabs.
+		require.ErrorIs(t, err, errBatch)
+		require.ErrorIs(t, err, errRemoveSlab)
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.Equal(t, errBatch, externalError.Unwrap())
+	})
 }
 
 func TestArrayNestedStorables(t *testing.T) {
@@ -5105,7 +5193,7 @@ func TestArraySlabDump(t *testing.T) {
 		}
 
 		want := []string{
-			"level 1, ArrayDataSlab id:0x102030405060708.1 size:23 count:6 elements: [0 1 2 3 4 5]",
+			"level 1, ArrayDataSlab id:0x102030405060708.1 size:23 count:6 next:0x0.0 elements: [0 1 2 3 4 5]",
 		}
 		dumps, err := atree.DumpArraySlabs(array)
 		require.NoError(t, err)
@@ -5129,8 +5217,8 @@ func TestArraySlabDump(t *testing.T) {
 
 		want := []string{
 			"level 1, ArrayMetaDataSlab id:0x102030405060708.1 size:40 count:120 children: [{id:0x102030405060708.2 size:213 count:54} {id:0x102030405060708.3 size:285 count:66}]",
-			"level 2, ArrayDataSlab id:0x102030405060708.2 size:213 count:54 elements: [0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19 20 21 22 23 24 25 26 27 28 29 30 31 32 33 34 35 36 37 38 39 40 41 42 43 44 45 46 47 48 49 50 51 52 53]",
-			"level 2, ArrayDataSlab id:0x102030405060708.3 size:285 count:66 elements: [54 55 56 57 58 59 60 61 62 63 64 65 66 67 68 69 70 71 72 73 74 75 76 77 78 79 80 81 82 83 84 85 86 87 88 89 90 91 92 93 94 95 96 97 98 99 100 101 102 103 104 105 106 107 108 109 110 111 112 113 114 115 116 117 118 119]",
+			"level 2, ArrayDataSlab id:0x102030405060708.2 size:213 count:54 next:0x102030405060708.3 elements: [0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19 20 21 22 23 24 25 26 27 28 29 30 31 32 33 34 35 36 37 38 39 40 41 42 43 44 45 46 47 48 49 50 51 52 53]",
+			"level 2, ArrayDataSlab id:0x102030405060708.3 size:285 count:66 next:0x0.0 elements: [54 55 56 57 58 59 60 61 62 63 64 65 66 67 68 69 70 71 72 73 74 75 76 77 78 79 80 81 82 83 84 85 86 87 88 89 90 91 92 93 94 95 96 97 98 99 100 101 102 103 104 105 106 107 108 109 110 111 112 113 114 115 116 117 118 119]",
 		}
 
 		dumps, err := atree.DumpArraySlabs(array)
@@ -5151,8 +5239,8 @@ func TestArraySlabDump(t *testing.T) {
 		require.NoError(t, err)
 
 		want := []string{
-			"level 1, ArrayDataSlab id:0x102030405060708.1 size:24 count:1 elements: [SlabIDStorable({[1 2 3 4 5 6 7 8] [0 0 0 0 0 0 0 2]})]",
-			"StorableSlab id:0x102030405060708.2 storable:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			"level 1, ArrayDataSlab id:0x102030405060708.1 size:24 count:1 next:0x0.0 elements: [SlabIDStorable({[1 2 3 4 5 6 7 8] [0 0 0 0 0 0 0 2]})]",
+			"StorableSlab id:0x102030405060708.2 size:121 storable:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
 		}
 
 		dumps, err := atree.DumpArraySlabs(array)
@@ -9109,3 +9197,2486 @@ func testExistingInlinedArraySetType(
 	require.Equal(t, expectedCount, childArray2.Count())
 	require.Equal(t, newTypeInfo, childArray2.Type())
 }
+
+func TestArrayMetaDataSlabFanout(t *testing.T) {
+
+	const threshold = 256
+
+	atree.SetThreshold(threshold)
+	defer atree.SetThreshold(1024)
+
+	defaultMinFanout, defaultMaxFanout := atree.ArrayMetaDataSlabFanout(threshold)
+	require.True(t, defaultMinFanout > 1)
+	require.True(t, defaultMinFanout < defaultMaxFanout)
+
+	// Fanout is computed with array slab header size.
+	_, mapMaxFanout := atree.MapMetaDataSlabFanout(threshold)
+	require.Greater(t, defaultMaxFanout, mapMaxFanout)
+
+	// Threshold smaller than min slab size is rejected.
+	for _, invalidThreshold := range []uint64{0, 8, 16, threshold - 1} {
+		require.Panics(t, func() {
+			atree.MetaDataSlabFanout(invalidThreshold)
+		})
+		require.Panics(t, func() {
+			atree.ArrayMetaDataSlabFanout(invalidThreshold)
+		})
+	}
+
+	require.Panics(t, func() {
+		atree.SetMinMetaDataSlabFanout(-1)
+	})
+
+	test := func(t *testing.T, minFanout, maxFanout int) {
+		const (
+			arrayCount     = 8192
+			remainingCount = 2048
+		)
+
+		typeInfo := test_utils.NewSimpleTypeInfo(42)
+		address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+		storage := newTestPersistentStorage(t)
+
+		r := newRand(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		expectedValues := make(test_utils.ExpectedArrayValue, arrayCount)
+		for i := range expectedValues {
+			v := test_utils.Uint64Value(i)
+			expectedValues[i] = v
+
+			err := array.Append(v)
+			require.NoError(t, err)
+		}
+
+		// Remove most elements at random positions
+		for array.Count() > remainingCount {
+			index := r.Intn(int(array.Count()))
+
+			existingStorable, err := array.Remove(uint64(index))
+			require.NoError(t, err)
+
+			existingValue, err := existingStorable.StoredValue(storage)
+			require.NoError(t, err)
+			testValueEqual(t, expectedValues[index], existingValue)
+
+			expectedValues = append(expectedValues[:index], expectedValues[index+1:]...)
+		}
+
+		require.False(t, IsArrayRootDataSlab(array))
+
+		// Verify non-root metadata slabs aren't below min fanout
+		nonRootMetaDataSlabCount := 0
+		for _, slab := range atree.GetDeltas(storage) {
+			metaDataSlab, ok := slab.(*atree.ArrayMetaDataSlab)
+			if !ok || metaDataSlab.ExtraData() != nil {
+				continue
+			}
+
+			childSlabIDs, _ := atree.GetArrayMetaDataSlabChildInfo(metaDataSlab)
+			require.GreaterOrEqual(t, len(childSlabIDs), minFanout)
+			require.LessOrEqual(t, len(childSlabIDs), maxFanout)
+
+			nonRootMetaDataSlabCount++
+		}
+		require.True(t, nonRootMetaDataSlabCount > 0)
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		test(t, defaultMinFanout, defaultMaxFanout)
+	})
+
+	t.Run("min fanout", func(t *testing.T) {
+		// Min fanout larger than min fanout derived from slab size
+		// makes slabs with enough bytes but too few children underflow.
+		minFanoutSetting := defaultMaxFanout / 2
+		require.Greater(t, minFanoutSetting, defaultMinFanout)
+
+		atree.SetMinMetaDataSlabFanout(minFanoutSetting)
+		defer atree.SetMinMetaDataSlabFanout(0)
+
+		minFanout, maxFanout := atree.ArrayMetaDataSlabFanout(threshold)
+		require.Equal(t, minFanoutSetting, minFanout)
+		require.Equal(t, defaultMaxFanout, maxFanout)
+
+		test(t, minFanout, maxFanout)
+	})
+
+	t.Run("min fanout is capped", func(t *testing.T) {
+		atree.SetMinMetaDataSlabFanout(defaultMaxFanout)
+		defer atree.SetMinMetaDataSlabFanout(0)
+
+		minFanout, maxFanout := atree.ArrayMetaDataSlabFanout(threshold)
+		require.Equal(t, defaultMaxFanout/2, minFanout)
+
+		test(t, minFanout, maxFanout)
+	})
+
+	t.Run("rebalance hysteresis", func(t *testing.T) {
+		// Min fanout is derived from lowered underflow threshold.
+		atree.SetRebalanceHysteresis(0.5)
+		defer atree.SetRebalanceHysteresis(0)
+
+		minFanout, maxFanout := atree.ArrayMetaDataSlabFanout(threshold)
+		require.Less(t, minFanout, defaultMinFanout)
+		require.Equal(t, defaultMaxFanout, maxFanout)
+
+		test(t, minFanout, maxFanout)
+	})
+}
+
+func TestArrayElementValidator(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	errWrongType := errors.New("element isn't Uint64Value")
+
+	uint64Validator := func(v atree.Value) error {
+		if _, ok := v.(test_utils.Uint64Value); !ok {
+			return errWrongType
+		}
+		return nil
+	}
+
+	t.Run("nil validator", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		array.SetElementValidator(nil)
+
+		err = array.Append(test_utils.NewStringValue("a"))
+		require.NoError(t, err)
+
+		err = array.Append(test_utils.Uint64Value(1))
+		require.NoError(t, err)
+
+		testArray(t, storage, typeInfo, address, array, test_utils.ExpectedArrayValue{test_utils.NewStringValue("a"), test_utils.Uint64Value(1)}, false)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		const arrayCount = 4096
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		array.SetElementValidator(uint64Validator)
+
+		expectedValues := make(test_utils.ExpectedArrayValue, 0, arrayCount)
+
+		rejectedValue := test_utils.NewStringValue(strings.Repeat("a", 100))
+
+		for i := range arrayCount {
+			v := test_utils.Uint64Value(i)
+
+			err := array.Append(v)
+			require.NoError(t, err)
+
+			expectedValues = append(expectedValues, v)
+
+			// Try to insert off-type element in the middle so that some inserts
+			// would trigger slab split if element were accepted.
+			index := uint64(len(expectedValues) / 2)
+
+			err = array.Insert(index, rejectedValue)
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var externalError *atree.ExternalError
+			require.ErrorAs(t, err, &externalError)
+			require.ErrorIs(t, err, errWrongType)
+
+			err = array.Append(rejectedValue)
+			require.ErrorIs(t, err, errWrongType)
+
+			existingStorable, err := array.Set(index, rejectedValue)
+			require.ErrorIs(t, err, errWrongType)
+			require.Nil(t, existingStorable)
+
+			require.Equal(t, uint64(len(expectedValues)), array.Count())
+		}
+
+		require.False(t, IsArrayRootDataSlab(array))
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+	})
+
+	t.Run("set", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		array.SetElementValidator(uint64Validator)
+
+		err = array.Append(test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		existingStorable, err := array.Set(0, test_utils.Uint64Value(1))
+		require.NoError(t, err)
+		require.Equal(t, test_utils.Uint64Value(0), existingStorable)
+
+		testArray(t, storage, typeInfo, address, array, test_utils.ExpectedArrayValue{test_utils.Uint64Value(1)}, false)
+	})
+}
+
+func TestArrayExternalValueThreshold(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// String value with encoded size between external value threshold and default max inline size.
+	value := test_utils.NewStringValue(strings.Repeat("a", 100))
+
+	testExternalValueThreshold := func(t *testing.T, externalValueThreshold uint64, expectedInlined bool) {
+		atree.SetExternalValueThreshold(externalValueThreshold)
+		defer atree.SetExternalValueThreshold(0)
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array.Append(value)
+		require.NoError(t, err)
+
+		storables := atree.GetArrayRootSlabStorables(array)
+		require.Equal(t, 1, len(storables))
+
+		_, isSlabIDStorable := storables[0].(atree.SlabIDStorable)
+		require.Equal(t, expectedInlined, !isSlabIDStorable)
+
+		testArray(t, storage, typeInfo, address, array, test_utils.ExpectedArrayValue{value}, false)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		require.True(t, uint64(value.ByteSize()) < atree.MaxInlineArrayElementSize())
+		testExternalValueThreshold(t, 0, true)
+	})
+
+	t.Run("value larger than threshold", func(t *testing.T) {
+		testExternalValueThreshold(t, 64, false)
+	})
+
+	t.Run("value smaller than threshold", func(t *testing.T) {
+		testExternalValueThreshold(t, 128, true)
+	})
+
+	t.Run("threshold too small", func(t *testing.T) {
+		defer atree.SetExternalValueThreshold(0)
+
+		require.Panics(t, func() {
+			atree.SetExternalValueThreshold(1)
+		})
+	})
+
+	t.Run("threshold larger than slab derived limit", func(t *testing.T) {
+		maxInlineSize := atree.MaxInlineArrayElementSize()
+
+		atree.SetExternalValueThreshold(maxInlineSize * 2)
+		defer atree.SetExternalValueThreshold(0)
+
+		require.Equal(t, maxInlineSize, atree.MaxInlineArrayElementSize())
+	})
+}
+
+func TestArrayMaxValueSize(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// String value with encoded size smaller than default max inline size.
+	smallValue := test_utils.NewStringValue(strings.Repeat("a", 100))
+
+	// String value with encoded size larger than default max inline size.
+	largeValue := test_utils.NewStringValue(strings.Repeat("b", 1000))
+
+	requireMaxValueSizeError := func(t *testing.T, err error) {
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		var maxValueSizeError *atree.MaxValueSizeError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &maxValueSizeError)
+		require.ErrorAs(t, userError, &maxValueSizeError)
+	}
+
+	testError := func(t *testing.T, value atree.Value, maxValueSize uint64, expectError bool) {
+		atree.SetMaxValueSize(maxValueSize, atree.MaxValueSizeModeError)
+		defer atree.SetMaxValueSize(0, atree.MaxValueSizeModeError)
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array.Append(test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		err = array.Append(value)
+		if !expectError {
+			require.NoError(t, err)
+
+			testArray(t, storage, typeInfo, address, array, test_utils.ExpectedArrayValue{test_utils.Uint64Value(0), value}, false)
+			return
+		}
+		requireMaxValueSizeError(t, err)
+
+		err = array.Insert(0, value)
+		requireMaxValueSizeError(t, err)
+
+		existingStorable, err := array.Set(0, value)
+		requireMaxValueSizeError(t, err)
+		require.Nil(t, existingStorable)
+
+		// Array is unchanged and rejected external value isn't left in storage.
+		testArray(t, storage, typeInfo, address, array, test_utils.ExpectedArrayValue{test_utils.Uint64Value(0)}, false)
+	}
+
+	t.Run("error mode, inlined value at limit", func(t *testing.T) {
+		testError(t, smallValue, uint64(smallValue.ByteSize()), false)
+	})
+
+	t.Run("error mode, inlined value over limit", func(t *testing.T) {
+		testError(t, smallValue, uint64(smallValue.ByteSize())-1, true)
+	})
+
+	t.Run("error mode, external value at limit", func(t *testing.T) {
+		require.True(t, uint64(largeValue.ByteSize()) > atree.MaxInlineArrayElementSize())
+		testError(t, largeValue, uint64(largeValue.ByteSize()), false)
+	})
+
+	t.Run("error mode, external value over limit", func(t *testing.T) {
+		testError(t, largeValue, uint64(largeValue.ByteSize())-1, true)
+	})
+
+	testForceExternal := func(t *testing.T, maxValueSize uint64, expectedInlined bool) {
+		atree.SetMaxValueSize(maxValueSize, atree.MaxValueSizeModeForceExternal)
+		defer atree.SetMaxValueSize(0, atree.MaxValueSizeModeError)
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array.Append(smallValue)
+		require.NoError(t, err)
+
+		storables := atree.GetArrayRootSlabStorables(array)
+		require.Equal(t, 1, len(storables))
+
+		_, isSlabIDStorable := storables[0].(atree.SlabIDStorable)
+		require.Equal(t, expectedInlined, !isSlabIDStorable)
+
+		testArray(t, storage, typeInfo, address, array, test_utils.ExpectedArrayValue{smallValue}, false)
+	}
+
+	t.Run("force external mode, value at limit", func(t *testing.T) {
+		testForceExternal(t, uint64(smallValue.ByteSize()), true)
+	})
+
+	t.Run("force external mode, value over limit", func(t *testing.T) {
+		testForceExternal(t, uint64(smallValue.ByteSize())-1, false)
+	})
+
+	t.Run("force external mode with external value threshold", func(t *testing.T) {
+		atree.SetExternalValueThreshold(64)
+		defer atree.SetExternalValueThreshold(0)
+
+		// Smaller of external value threshold and max value size is used.
+		testForceExternal(t, uint64(smallValue.ByteSize()), false)
+	})
+
+	t.Run("force external mode, size too small", func(t *testing.T) {
+		defer atree.SetMaxValueSize(0, atree.MaxValueSizeModeError)
+
+		require.Panics(t, func() {
+			atree.SetMaxValueSize(1, atree.MaxValueSizeModeForceExternal)
+		})
+	})
+}
+
+func TestArrayCopyRangeTo(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+	address2 := atree.Address{8, 7, 6, 5, 4, 3, 2, 1}
+
+	// newValue returns large string values every 4 elements so
+	// these values are stored in external StorableSlab.
+	newValue := func(i int) atree.Value {
+		if i%4 == 0 {
+			return test_utils.NewStringValue(strings.Repeat(strconv.Itoa(i), 512))
+		}
+		return test_utils.Uint64Value(i)
+	}
+
+	newArray := func(t *testing.T, storage *atree.PersistentSlabStorage, address atree.Address, start, count int) (*atree.Array, []atree.Value) {
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		values := make([]atree.Value, count)
+		for i := range count {
+			values[i] = newValue(start + i)
+
+			err := array.Append(values[i])
+			require.NoError(t, err)
+		}
+
+		return array, values
+	}
+
+	verifyArray := func(t *testing.T, address atree.Address, array *atree.Array, expectedValues []atree.Value) {
+		require.Equal(t, uint64(len(expectedValues)), array.Count())
+
+		i := 0
+		err := array.IterateReadOnly(func(v atree.Value) (bool, error) {
+			testValueEqual(t, expectedValues[i], v)
+			i++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, len(expectedValues), i)
+
+		err = atree.VerifyArray(array, address, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		if err != nil {
+			atree.PrintArray(array)
+		}
+		require.NoError(t, err)
+	}
+
+	splice := func(dst []atree.Value, dstIndex int, src []atree.Value) []atree.Value {
+		expected := make([]atree.Value, 0, len(dst)+len(src))
+		expected = append(expected, dst[:dstIndex]...)
+		expected = append(expected, src...)
+		expected = append(expected, dst[dstIndex:]...)
+		return expected
+	}
+
+	testCases := []struct {
+		name     string
+		dstIndex uint64
+		srcStart uint64
+		srcCount uint64
+	}{
+		{name: "prepend", dstIndex: 0, srcStart: 10, srcCount: 100},
+		{name: "insert", dstIndex: 50, srcStart: 0, srcCount: 200},
+		{name: "append", dstIndex: 100, srcStart: 150, srcCount: 50},
+		{name: "empty", dstIndex: 30, srcStart: 200, srcCount: 0},
+	}
+
+	for _, addr := range []struct {
+		name    string
+		address atree.Address
+	}{
+		{name: "same address", address: address},
+		{name: "different address", address: address2},
+	} {
+		for _, tc := range testCases {
+			t.Run(addr.name+" "+tc.name, func(t *testing.T) {
+				storage := newTestPersistentStorage(t)
+
+				src, srcValues := newArray(t, storage, address, 0, 200)
+				dst, dstValues := newArray(t, storage, addr.address, 1000, 100)
+
+				err := src.CopyRangeTo(dst, tc.dstIndex, tc.srcStart, tc.srcCount)
+				require.NoError(t, err)
+
+				expectedDstValues := splice(dstValues, int(tc.dstIndex), srcValues[tc.srcStart:tc.srcStart+tc.srcCount])
+
+				verifyArray(t, address, src, srcValues)
+				verifyArray(t, addr.address, dst, expectedDstValues)
+
+				rootIDs, err := atree.CheckStorageHealth(storage, 2)
+				require.NoError(t, err)
+				require.Equal(t, 2, len(rootIDs))
+
+				// Removing all source elements doesn't affect copied elements.
+				err = src.PopIterate(func(storable atree.Storable) {
+					if slabIDStorable, ok := storable.(atree.SlabIDStorable); ok {
+						err := storage.Remove(atree.SlabID(slabIDStorable))
+						require.NoError(t, err)
+					}
+				})
+				require.NoError(t, err)
+
+				verifyArray(t, addr.address, dst, expectedDstValues)
+
+				_, err = atree.CheckStorageHealth(storage, 2)
+				require.NoError(t, err)
+			})
+		}
+	}
+
+	t.Run("self overlapping", func(t *testing.T) {
+		for _, tc := range []struct {
+			name     string
+			dstIndex uint64
+			srcStart uint64
+			srcCount uint64
+		}{
+			{name: "dst before range", dstIndex: 10, srcStart: 50, srcCount: 100},
+			{name: "dst in range", dstIndex: 80, srcStart: 50, srcCount: 100},
+			{name: "dst after range", dstIndex: 180, srcStart: 50, srcCount: 100},
+			{name: "whole array", dstIndex: 200, srcStart: 0, srcCount: 200},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				storage := newTestPersistentStorage(t)
+
+				array, values := newArray(t, storage, address, 0, 200)
+
+				err := array.CopyRangeTo(array, tc.dstIndex, tc.srcStart, tc.srcCount)
+				require.NoError(t, err)
+
+				expectedValues := splice(values, int(tc.dstIndex), values[tc.srcStart:tc.srcStart+tc.srcCount])
+
+				testArray(t, storage, typeInfo, address, array, expectedValues, false)
+			})
+		}
+	})
+
+	t.Run("out of bounds", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		src, _ := newArray(t, storage, address, 0, 10)
+		dst, _ := newArray(t, storage, address2, 0, 10)
+
+		var userError *atree.UserError
+		var sliceOutOfBoundsError *atree.SliceOutOfBoundsError
+		var indexOutOfBoundsError *atree.IndexOutOfBoundsError
+
+		err := src.CopyRangeTo(dst, 0, 11, 0)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &sliceOutOfBoundsError)
+
+		err = src.CopyRangeTo(dst, 0, 5, 6)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &sliceOutOfBoundsError)
+
+		err = src.CopyRangeTo(dst, 0, 5, math.MaxUint64)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &sliceOutOfBoundsError)
+
+		err = src.CopyRangeTo(dst, 11, 0, 1)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &indexOutOfBoundsError)
+
+		// Arrays are unchanged.
+		require.Equal(t, uint64(10), src.Count())
+		require.Equal(t, uint64(10), dst.Count())
+	})
+
+	t.Run("container element", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		src, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		child, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = src.Append(child)
+		require.NoError(t, err)
+
+		dst, err := atree.NewArray(storage, address2, typeInfo)
+		require.NoError(t, err)
+
+		err = src.CopyRangeTo(dst, 0, 0, 1)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+		require.Equal(t, uint64(0), dst.Count())
+	})
+}
+
+func TestArrayDeepCopy(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+	address2 := atree.Address{8, 7, 6, 5, 4, 3, 2, 1}
+
+	const arrayCount = 100
+
+	storage := newTestPersistentStorage(t)
+
+	array, expectedValues, _ := createArrayWithChildArrays(t, storage, address, typeInfo, arrayCount, false)
+
+	copied, err := array.DeepCopy(storage, address2, test_utils.CompareValue, test_utils.GetHashInput)
+	require.NoError(t, err)
+	require.Equal(t, address2, copied.Address())
+	require.NotEqual(t, array.SlabID(), copied.SlabID())
+
+	testValueEqual(t, expectedValues, copied)
+
+	err = atree.VerifyArray(copied, address2, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+	require.NoError(t, err)
+
+	// Source and copy don't share any slab.
+	_, err = atree.CheckStorageHealth(storage, 2)
+	require.NoError(t, err)
+
+	// Mutate child arrays of copy.
+	for i := range arrayCount {
+		v, err := copied.Get(uint64(i))
+		require.NoError(t, err)
+
+		childArray, ok := v.(*atree.Array)
+		require.True(t, ok)
+		require.Equal(t, address2, childArray.Address())
+
+		err = childArray.Append(test_utils.Uint64Value(100))
+		require.NoError(t, err)
+	}
+
+	// Source is untouched.
+	testValueEqual(t, expectedValues, array)
+
+	err = atree.VerifyArray(array, address, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+	require.NoError(t, err)
+
+	_, err = atree.CheckStorageHealth(storage, 2)
+	require.NoError(t, err)
+}
+
+func TestArrayConcurrentModification(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const arrayCount = 100
+
+	newArray := func(t *testing.T) *atree.Array {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range arrayCount {
+			err = array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		return array
+	}
+
+	requireConcurrentModificationError := func(t *testing.T, err error) {
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		var concurrentModificationError *atree.ConcurrentModificationError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &concurrentModificationError)
+	}
+
+	testCases := []struct {
+		name   string
+		mutate func(*atree.Array) error
+	}{
+		{
+			name: "append",
+			mutate: func(array *atree.Array) error {
+				return array.Append(test_utils.Uint64Value(0))
+			},
+		},
+		{
+			name: "insert",
+			mutate: func(array *atree.Array) error {
+				return array.Insert(0, test_utils.Uint64Value(0))
+			},
+		},
+		{
+			name: "remove",
+			mutate: func(array *atree.Array) error {
+				_, err := array.Remove(0)
+				return err
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			array := newArray(t)
+
+			count := 0
+			err := array.Iterate(func(atree.Value) (bool, error) {
+				count++
+				if count == 10 {
+					err := tc.mutate(array)
+					require.NoError(t, err)
+				}
+				return true, nil
+			})
+			requireConcurrentModificationError(t, err)
+			require.Equal(t, 10, count)
+
+			iterator, err := array.ReadOnlyRangeIterator(0, 10)
+			require.NoError(t, err)
+
+			err = tc.mutate(array)
+			require.NoError(t, err)
+
+			_, err = iterator.Next()
+			requireConcurrentModificationError(t, err)
+		})
+	}
+
+	t.Run("set", func(t *testing.T) {
+		array := newArray(t)
+
+		// Replacing element during iteration is supported.
+		count := 0
+		err := array.Iterate(func(atree.Value) (bool, error) {
+			_, err := array.Set(uint64(count), test_utils.Uint64Value(count*2))
+			require.NoError(t, err)
+
+			count++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, arrayCount, count)
+
+		// Replacing element invalidates readonly iterator.
+		iterator, err := array.ReadOnlyRangeIterator(0, 10)
+		require.NoError(t, err)
+
+		_, err = array.Set(0, test_utils.Uint64Value(1))
+		require.NoError(t, err)
+
+		_, err = iterator.Next()
+		requireConcurrentModificationError(t, err)
+	})
+
+	t.Run("failed operations", func(t *testing.T) {
+		array := newArray(t)
+
+		iterator, err := array.ReadOnlyRangeIterator(0, 10)
+		require.NoError(t, err)
+
+		// Failed operations don't invalidate iterator.
+		err = array.Insert(arrayCount+1, test_utils.Uint64Value(0))
+		require.Error(t, err)
+
+		_, err = array.Set(arrayCount, test_utils.Uint64Value(0))
+		require.Error(t, err)
+
+		_, err = array.Remove(arrayCount)
+		require.Error(t, err)
+
+		for i := range 10 {
+			v, err := iterator.Next()
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(i), v)
+		}
+	})
+}
+
+func TestReduceArray(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const arrayCount = 1000
+
+	storage := newTestPersistentStorage(t)
+
+	array, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+
+	r := newRand(t)
+
+	var expectedSum, expectedMax uint64
+	for range arrayCount {
+		v := uint64(r.Intn(1000))
+
+		err = array.Append(test_utils.Uint64Value(v))
+		require.NoError(t, err)
+
+		expectedSum += v
+		expectedMax = max(expectedMax, v)
+	}
+
+	t.Run("sum", func(t *testing.T) {
+		sum, err := atree.ReduceArray(array, uint64(0), func(acc uint64, v atree.Value) (uint64, error) {
+			return acc + uint64(v.(test_utils.Uint64Value)), nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, expectedSum, sum)
+	})
+
+	t.Run("max", func(t *testing.T) {
+		maxValue, err := atree.ReduceArray(array, uint64(0), func(acc uint64, v atree.Value) (uint64, error) {
+			return max(acc, uint64(v.(test_utils.Uint64Value))), nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, expectedMax, maxValue)
+	})
+
+	t.Run("order", func(t *testing.T) {
+		index, err := atree.ReduceArray(array, 0, func(acc int, v atree.Value) (int, error) {
+			expected, err := array.Get(uint64(acc))
+			require.NoError(t, err)
+			require.Equal(t, expected, v)
+			return acc + 1, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, arrayCount, index)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		emptyArray, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		result, err := atree.ReduceArray(emptyArray, "initial", func(string, atree.Value) (string, error) {
+			require.Fail(t, "fn shouldn't be called")
+			return "", nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "initial", result)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		testErr := errors.New("test")
+
+		calls := 0
+		result, err := atree.ReduceArray(array, uint64(0), func(acc uint64, v atree.Value) (uint64, error) {
+			calls++
+			if calls == 10 {
+				return 0, testErr
+			}
+			return acc + uint64(v.(test_utils.Uint64Value)), nil
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.Equal(t, testErr, externalError.Unwrap())
+		require.Equal(t, uint64(0), result)
+		require.Equal(t, 10, calls)
+	})
+}
+
+func TestArrayView(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	const arrayCount = 1024
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	newArray := func(t *testing.T) (*atree.Array, []atree.Value) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		values := make([]atree.Value, arrayCount)
+		for i := range values {
+			v := test_utils.Uint64Value(i)
+			values[i] = v
+
+			err := array.Append(v)
+			require.NoError(t, err)
+		}
+
+		require.False(t, IsArrayRootDataSlab(array))
+
+		return array, values
+	}
+
+	testView := func(t *testing.T, view *atree.ArrayView, expectedValues []atree.Value) {
+		require.Equal(t, uint64(len(expectedValues)), view.Count())
+
+		for i, expected := range expectedValues {
+			v, err := view.Get(uint64(i))
+			require.NoError(t, err)
+			testValueEqual(t, expected, v)
+		}
+
+		_, err := view.Get(view.Count())
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		var indexOutOfBoundsError *atree.IndexOutOfBoundsError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &indexOutOfBoundsError)
+
+		i := 0
+		err = view.Iterate(func(v atree.Value) (bool, error) {
+			testValueEqual(t, expectedValues[i], v)
+			i++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, len(expectedValues), i)
+	}
+
+	t.Run("window", func(t *testing.T) {
+		array, values := newArray(t)
+
+		ranges := [][2]uint64{
+			{0, 0},
+			{0, arrayCount},
+			{0, 10},
+			{arrayCount - 10, arrayCount},
+			{100, 900},
+			{arrayCount, arrayCount},
+		}
+
+		for _, r := range ranges {
+			view, err := array.View(r[0], r[1])
+			require.NoError(t, err)
+
+			testView(t, view, values[r[0]:r[1]])
+		}
+	})
+
+	t.Run("out of bounds", func(t *testing.T) {
+		array, _ := newArray(t)
+
+		view, err := array.View(0, arrayCount+1)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var sliceOutOfBoundsError *atree.SliceOutOfBoundsError
+		require.ErrorAs(t, err, &sliceOutOfBoundsError)
+		require.Nil(t, view)
+
+		view, err = array.View(10, 9)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var invalidSliceIndexError *atree.InvalidSliceIndexError
+		require.ErrorAs(t, err, &invalidSliceIndexError)
+		require.Nil(t, view)
+	})
+
+	t.Run("set is visible", func(t *testing.T) {
+		array, values := newArray(t)
+
+		view, err := array.View(100, 200)
+		require.NoError(t, err)
+
+		newValue := test_utils.Uint64Value(arrayCount)
+
+		existingStorable, err := array.Set(150, newValue)
+		require.NoError(t, err)
+		require.Equal(t, values[150], existingStorable)
+
+		values[150] = newValue
+
+		testView(t, view, values[100:200])
+	})
+
+	t.Run("invalidated by mutation", func(t *testing.T) {
+		mutations := map[string]func(*testing.T, *atree.Array){
+			"append": func(t *testing.T, array *atree.Array) {
+				err := array.Append(test_utils.Uint64Value(arrayCount))
+				require.NoError(t, err)
+			},
+			"insert": func(t *testing.T, array *atree.Array) {
+				err := array.Insert(0, test_utils.Uint64Value(arrayCount))
+				require.NoError(t, err)
+			},
+			"remove": func(t *testing.T, array *atree.Array) {
+				_, err := array.Remove(0)
+				require.NoError(t, err)
+			},
+		}
+
+		for name, mutate := range mutations {
+			t.Run(name, func(t *testing.T) {
+				array, _ := newArray(t)
+
+				view, err := array.View(100, 200)
+				require.NoError(t, err)
+
+				mutate(t, array)
+
+				var concurrentModificationError *atree.ConcurrentModificationError
+
+				_, err = view.Get(0)
+				require.Equal(t, 1, errorCategorizationCount(err))
+				require.ErrorAs(t, err, &concurrentModificationError)
+
+				err = view.Iterate(func(atree.Value) (bool, error) {
+					require.Fail(t, "iteration callback shouldn't be called")
+					return true, nil
+				})
+				require.Equal(t, 1, errorCategorizationCount(err))
+				require.ErrorAs(t, err, &concurrentModificationError)
+			})
+		}
+	})
+
+	t.Run("mutation during iteration", func(t *testing.T) {
+		array, _ := newArray(t)
+
+		view, err := array.View(100, 200)
+		require.NoError(t, err)
+
+		err = view.Iterate(func(atree.Value) (bool, error) {
+			err := array.Append(test_utils.Uint64Value(arrayCount))
+			require.NoError(t, err)
+			return true, nil
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var concurrentModificationError *atree.ConcurrentModificationError
+		require.ErrorAs(t, err, &concurrentModificationError)
+	})
+}
+
+func TestNewArrayTempAddress(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+
+	t.Run("not allowed", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, atree.AddressUndefined, typeInfo)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		var tempAddressError *atree.TempAddressError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &tempAddressError)
+		require.Nil(t, array)
+
+		// No slab is created.
+		require.Equal(t, uint(0), storage.Deltas())
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, atree.AddressUndefined, typeInfo, atree.AllowTempAddress())
+		require.NoError(t, err)
+		require.True(t, array.SlabID().HasTempAddress())
+
+		err = array.Append(test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		testArray(t, storage, typeInfo, atree.AddressUndefined, array, []atree.Value{test_utils.Uint64Value(0)}, false)
+
+		// Temp slabs aren't committed.
+		err = storage.Commit()
+		require.NoError(t, err)
+		require.Equal(t, uint(1), storage.Deltas())
+		require.Equal(t, uint(0), storage.DeltasWithoutTempAddresses())
+	})
+}
+
+func TestArrayCountFromRoot(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("undefined slab ID", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		count, err := atree.ArrayCountFromRoot(storage, atree.SlabIDUndefined)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var slabIDError *atree.SlabIDError
+		require.ErrorAs(t, err, &slabIDError)
+		require.Equal(t, uint64(0), count)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		rootID := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
+
+		count, err := atree.ArrayCountFromRoot(storage, rootID)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var slabNotFoundError *atree.SlabNotFoundError
+		require.ErrorAs(t, err, &slabNotFoundError)
+		require.Equal(t, uint64(0), count)
+	})
+
+	for _, arrayCount := range []uint64{0, 10, 100_000} {
+		t.Run(strconv.FormatUint(arrayCount, 10), func(t *testing.T) {
+			baseStorage := test_utils.NewInMemBaseStorage()
+
+			storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			array, err := atree.NewArray(storage, address, typeInfo)
+			require.NoError(t, err)
+
+			for i := range arrayCount {
+				err := array.Append(test_utils.Uint64Value(i))
+				require.NoError(t, err)
+			}
+
+			err = storage.Commit()
+			require.NoError(t, err)
+
+			if arrayCount == 100_000 {
+				stats, err := atree.GetArrayStats(array)
+				require.NoError(t, err)
+				require.Greater(t, stats.Levels, uint64(1))
+			}
+
+			// Get count with new storage
+			storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			segmentsReturned := baseStorage.SegmentsReturned()
+
+			count, err := atree.ArrayCountFromRoot(storage2, array.SlabID())
+			require.NoError(t, err)
+			require.Equal(t, arrayCount, count)
+
+			// Only root slab is retrieved.
+			require.Equal(t, segmentsReturned+1, baseStorage.SegmentsReturned())
+		})
+	}
+}
+
+func TestArrayQueueAndStack(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("empty", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		var indexOutOfBoundsError *atree.IndexOutOfBoundsError
+		var userError *atree.UserError
+
+		v, err := array.PeekFirst()
+		require.Nil(t, v)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &indexOutOfBoundsError)
+
+		v, err = array.PeekLast()
+		require.Nil(t, v)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.ErrorAs(t, err, &indexOutOfBoundsError)
+
+		s, err := array.PopFirst()
+		require.Nil(t, s)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.ErrorAs(t, err, &indexOutOfBoundsError)
+
+		s, err = array.PopLast()
+		require.Nil(t, s)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.ErrorAs(t, err, &indexOutOfBoundsError)
+
+		testEmptyArray(t, storage, typeInfo, address, array)
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		const arrayCount = 4096
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range arrayCount {
+			err := array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+		require.False(t, IsArrayRootDataSlab(array))
+
+		next := uint64(arrayCount)
+		for i := range uint64(arrayCount) {
+			v, err := array.PeekFirst()
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(i), v)
+
+			s, err := array.PopFirst()
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(i), s)
+
+			// Enqueue a new element for the first half.
+			if i < arrayCount/2 {
+				err = array.Append(test_utils.Uint64Value(next))
+				require.NoError(t, err)
+				next++
+
+				v, err = array.PeekLast()
+				require.NoError(t, err)
+				require.Equal(t, test_utils.Uint64Value(next-1), v)
+			}
+		}
+
+		expectedValues := make([]atree.Value, 0, arrayCount/2)
+		for i := uint64(arrayCount); i < next; i++ {
+			expectedValues = append(expectedValues, test_utils.Uint64Value(i))
+		}
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+
+		for i := uint64(arrayCount); i < next; i++ {
+			s, err := array.PopFirst()
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(i), s)
+		}
+
+		testEmptyArray(t, storage, typeInfo, address, array)
+	})
+
+	t.Run("stack", func(t *testing.T) {
+		const arrayCount = 4096
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range arrayCount {
+			err := array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+		require.False(t, IsArrayRootDataSlab(array))
+
+		for i := arrayCount - 1; i >= arrayCount/2; i-- {
+			v, err := array.PeekLast()
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(i), v)
+
+			s, err := array.PopLast()
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(i), s)
+		}
+
+		v, err := array.PeekFirst()
+		require.NoError(t, err)
+		require.Equal(t, test_utils.Uint64Value(0), v)
+
+		expectedValues := make([]atree.Value, arrayCount/2)
+		for i := range expectedValues {
+			expectedValues[i] = test_utils.Uint64Value(i)
+		}
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+
+		for i := arrayCount/2 - 1; i >= 0; i-- {
+			s, err := array.PopLast()
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(i), s)
+		}
+
+		testEmptyArray(t, storage, typeInfo, address, array)
+	})
+}
+
+func TestArrayIsEmpty(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	baseStorage := test_utils.NewInMemBaseStorage()
+
+	storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	array, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+	require.True(t, array.IsEmpty())
+
+	err = array.Append(test_utils.Uint64Value(0))
+	require.NoError(t, err)
+	require.False(t, array.IsEmpty())
+
+	_, err = array.Remove(0)
+	require.NoError(t, err)
+	require.True(t, array.IsEmpty())
+
+	err = storage.Commit()
+	require.NoError(t, err)
+
+	// Load empty array with new storage
+	storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	array2, err := atree.NewArrayWithRootID(storage2, array.SlabID())
+	require.NoError(t, err)
+
+	segmentsReturned := baseStorage.SegmentsReturned()
+
+	require.True(t, array2.IsEmpty())
+
+	var indexOutOfBoundsError *atree.IndexOutOfBoundsError
+
+	v, err := array2.Get(0)
+	require.Nil(t, v)
+	require.Equal(t, 1, errorCategorizationCount(err))
+	require.ErrorAs(t, err, &indexOutOfBoundsError)
+
+	s, err := array2.Remove(0)
+	require.Nil(t, s)
+	require.Equal(t, 1, errorCategorizationCount(err))
+	require.ErrorAs(t, err, &indexOutOfBoundsError)
+
+	iterate := func(atree.Value) (bool, error) {
+		require.Fail(t, "iteration callback shouldn't be called for empty array")
+		return false, nil
+	}
+
+	err = array2.Iterate(iterate)
+	require.NoError(t, err)
+
+	err = array2.IterateReadOnly(iterate)
+	require.NoError(t, err)
+
+	// No slab is retrieved for operations on empty array.
+	require.Equal(t, segmentsReturned, baseStorage.SegmentsReturned())
+
+	testEmptyArray(t, storage2, typeInfo, address, array2)
+}
+
+func TestArrayCompact(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("root data slab", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		expectedValues := make([]atree.Value, 10)
+		for i := range expectedValues {
+			v := test_utils.Uint64Value(i)
+			err := array.Append(v)
+			require.NoError(t, err)
+			expectedValues[i] = v
+		}
+		require.True(t, IsArrayRootDataSlab(array))
+
+		err = array.Compact()
+		require.NoError(t, err)
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+	})
+
+	t.Run("after heavy churn", func(t *testing.T) {
+		const arrayCount = 64 * 1024
+		const remainingCount = 16 * 1024
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		rootID := array.SlabID()
+
+		expectedValues := make([]atree.Value, arrayCount)
+		for i := range expectedValues {
+			v := test_utils.Uint64Value(i)
+			err := array.Append(v)
+			require.NoError(t, err)
+			expectedValues[i] = v
+		}
+
+		// Remove elements at random indexes in the middle.
+		r := newRand(t)
+		for len(expectedValues) > remainingCount {
+			index := 1 + r.Intn(len(expectedValues)-2)
+
+			existingStorable, err := array.Remove(uint64(index))
+			require.NoError(t, err)
+			require.Equal(t, expectedValues[index], existingStorable)
+
+			expectedValues = append(expectedValues[:index], expectedValues[index+1:]...)
+		}
+
+		statsBeforeCompact, err := atree.GetArrayStats(array)
+		require.NoError(t, err)
+
+		err = array.Compact()
+		require.NoError(t, err)
+
+		require.Equal(t, rootID, array.SlabID())
+		require.Equal(t, typeInfo, array.Type())
+		require.Equal(t, uint64(remainingCount), array.Count())
+
+		stats, err := atree.GetArrayStats(array)
+		require.NoError(t, err)
+
+		// Data slab fill factor improves and tree doesn't get taller.
+		require.Greater(
+			t,
+			float64(stats.ElementCount)/float64(stats.DataSlabCount),
+			float64(statsBeforeCompact.ElementCount)/float64(statsBeforeCompact.DataSlabCount))
+		require.LessOrEqual(t, stats.Levels, statsBeforeCompact.Levels)
+
+		// Build fresh array with the same elements.
+		freshStorage := newTestPersistentStorage(t)
+
+		index := 0
+		freshArray, err := atree.NewArrayFromBatchData(
+			freshStorage,
+			address,
+			typeInfo,
+			func() (atree.Value, error) {
+				if index == len(expectedValues) {
+					return nil, nil
+				}
+				v := expectedValues[index]
+				index++
+				return v, nil
+			})
+		require.NoError(t, err)
+
+		freshStats, err := atree.GetArrayStats(freshArray)
+		require.NoError(t, err)
+		require.Equal(t, freshStats, stats)
+
+		// testArray verifies that slabs of old slab tree are removed from storage.
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+
+		// Array can be modified after compaction.
+		for i := range 1000 {
+			v := test_utils.Uint64Value(arrayCount + i)
+			index := r.Intn(len(expectedValues) + 1)
+
+			err := array.Insert(uint64(index), v)
+			require.NoError(t, err)
+
+			expectedValues = append(expectedValues[:index], append([]atree.Value{v}, expectedValues[index:]...)...)
+		}
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+	})
+}
+
+func TestArrayElementSizeHistogram(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("sizes", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		// 100 elements of 11 bytes, 20 elements of 102 bytes,
+		// and 5 elements stored in separate slabs of 2005 bytes.
+		for _, tc := range []struct {
+			count int
+			size  int
+		}{
+			{count: 100, size: 10},
+			{count: 20, size: 100},
+			{count: 5, size: 2000},
+		} {
+			for range tc.count {
+				err := array.Append(test_utils.NewStringValue(strings.Repeat("a", tc.size)))
+				require.NoError(t, err)
+			}
+		}
+
+		histogram, err := atree.ArrayElementSizeHistogram(array, []uint64{16, 128, 1024})
+		require.NoError(t, err)
+		require.Equal(t, map[uint64]uint64{16: 100, 128: 20, 1024: 0, math.MaxUint64: 5}, histogram)
+
+		// Elements larger than the last bucket aren't counted if last bucket is math.MaxUint64.
+		histogram, err = atree.ArrayElementSizeHistogram(array, []uint64{11, 2005, math.MaxUint64})
+		require.NoError(t, err)
+		require.Equal(t, map[uint64]uint64{11: 100, 2005: 25, math.MaxUint64: 0}, histogram)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		histogram, err := atree.ArrayElementSizeHistogram(array, []uint64{16})
+		require.NoError(t, err)
+		require.Equal(t, map[uint64]uint64{16: 0}, histogram)
+	})
+
+	t.Run("invalid buckets", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for _, buckets := range [][]uint64{nil, {16, 16}, {128, 16}} {
+			histogram, err := atree.ArrayElementSizeHistogram(array, buckets)
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var userError *atree.UserError
+			require.ErrorAs(t, err, &userError)
+			require.Nil(t, histogram)
+		}
+	})
+}
+
+func TestArrayDataSlabSizeEstimate(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const arrayCount = 256
+
+	r := newRand(t)
+
+	storage := newTestPersistentStorage(t)
+
+	array, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+
+	for i := range arrayCount {
+		var v atree.Value = test_utils.Uint64Value(i)
+		if i%2 == 0 {
+			v = test_utils.NewStringValue(randStr(r, r.Intn(32)))
+		}
+		err := array.Append(v)
+		require.NoError(t, err)
+	}
+
+	dataSlabCount := 0
+	for id, slab := range atree.GetDeltas(storage) {
+		dataSlab, ok := slab.(*atree.ArrayDataSlab)
+		if !ok || id == array.SlabID() {
+			continue
+		}
+
+		b, err := atree.EncodeSlab(dataSlab, atree.GetCBOREncMode(storage))
+		require.NoError(t, err)
+
+		estimatedSize := atree.EstimateArrayDataSlabSize(dataSlab.ChildStorables())
+		require.Equal(t, dataSlab.ByteSize(), estimatedSize)
+
+		// Next slab ID isn't encoded for the last data slab.
+		encodedSize := estimatedSize
+		if atree.GetArrayDataSlabNextSlabID(dataSlab) == atree.SlabIDUndefined {
+			encodedSize -= atree.SlabIDLength
+		}
+		require.Equal(t, uint32(len(b)), encodedSize)
+
+		dataSlabCount++
+	}
+	require.True(t, dataSlabCount > 1)
+}
+
+func TestArrayForEachDataSlab(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("empty", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array.ForEachDataSlab(func([]atree.Value) (bool, error) {
+			require.Fail(t, "callback shouldn't be called for empty array")
+			return true, nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("all data slabs", func(t *testing.T) {
+		const arrayCount = 1_000
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range arrayCount {
+			err := array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		values := make([]atree.Value, 0, arrayCount)
+		batchCount := 0
+		err = array.ForEachDataSlab(func(elements []atree.Value) (bool, error) {
+			require.NotEmpty(t, elements)
+			values = append(values, elements...)
+			batchCount++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.True(t, batchCount > 1)
+		require.Equal(t, arrayCount, len(values))
+		for i, v := range values {
+			require.Equal(t, test_utils.Uint64Value(i), v)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		const arrayCount = 1_000
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range arrayCount {
+			err := array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		batchCount := 0
+		err = array.ForEachDataSlab(func([]atree.Value) (bool, error) {
+			batchCount++
+			return false, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, batchCount)
+
+		testErr := errors.New("test")
+
+		err = array.ForEachDataSlab(func([]atree.Value) (bool, error) {
+			return false, testErr
+		})
+		// err is testErr wrapped in atree.ExternalError.
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.Equal(t, testErr, externalError.Unwrap())
+	})
+
+	t.Run("mutate child", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		parentArray, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		childArray, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = parentArray.Append(childArray)
+		require.NoError(t, err)
+
+		var mutationError *atree.ReadOnlyIteratorElementMutationError
+		err = parentArray.ForEachDataSlab(func(elements []atree.Value) (bool, error) {
+			require.Equal(t, 1, len(elements))
+
+			c, ok := elements[0].(*atree.Array)
+			require.True(t, ok)
+
+			err := c.Append(test_utils.Uint64Value(0))
+			require.ErrorAs(t, err, &mutationError)
+
+			return true, nil
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestArrayExtraData(t *testing.T) {
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	storage := newTestPersistentStorage(t)
+
+	array, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+
+	extraData := array.ExtraData()
+	require.Equal(t, typeInfo, extraData.TypeInfo)
+
+	// Modifying returned extra data doesn't modify array.
+	extraData.TypeInfo = test_utils.NewSimpleTypeInfo(43)
+	require.Equal(t, typeInfo, array.Type())
+}
+
+func TestArrayFilled(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	childTypeInfo := test_utils.NewSimpleTypeInfo(43)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("zero count", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArrayFilled(storage, address, typeInfo, test_utils.Uint64Value(1), 0, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), array.Count())
+
+		testEmptyArray(t, storage, typeInfo, address, array)
+	})
+
+	t.Run("nil value", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArrayFilled(storage, address, typeInfo, nil, 10, test_utils.CompareValue, test_utils.GetHashInput)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+		require.Nil(t, array)
+	})
+
+	t.Run("scalar", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		const arrayCount = 4096
+
+		v := test_utils.Uint64Value(42)
+
+		array, err := atree.NewArrayFilled(storage, address, typeInfo, v, arrayCount, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.Equal(t, uint64(arrayCount), array.Count())
+
+		expectedValues := make([]atree.Value, arrayCount)
+		for i := range expectedValues {
+			expectedValues[i] = v
+		}
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+	})
+
+	t.Run("large scalar", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		const arrayCount = 10
+
+		v := test_utils.NewStringValue(strings.Repeat("a", int(atree.MaxInlineArrayElementSize())+1))
+
+		array, err := atree.NewArrayFilled(storage, address, typeInfo, v, arrayCount, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.Equal(t, uint64(arrayCount), array.Count())
+
+		expectedValues := make([]atree.Value, arrayCount)
+		for i := range expectedValues {
+			expectedValues[i] = v
+		}
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+	})
+
+	t.Run("array", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		const arrayCount = 100
+		const childArrayCount = 50
+
+		// Fill value is in separate storage, so storage only contains new array.
+		childStorage := newTestPersistentStorage(t)
+
+		childArray, err := atree.NewArray(childStorage, address, childTypeInfo)
+		require.NoError(t, err)
+
+		expectedChildValues := make(test_utils.ExpectedArrayValue, childArrayCount)
+		for i := range childArrayCount {
+			v := test_utils.Uint64Value(i)
+			err = childArray.Append(v)
+			require.NoError(t, err)
+			expectedChildValues[i] = v
+		}
+
+		array, err := atree.NewArrayFilled(storage, address, typeInfo, childArray, arrayCount, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.Equal(t, uint64(arrayCount), array.Count())
+
+		expectedValues := make([]atree.Value, arrayCount)
+		for i := range expectedValues {
+			expectedValues[i] = expectedChildValues
+		}
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, true)
+
+		// Elements are copies of fill value.
+		seen := make(map[atree.ValueID]struct{})
+		for i := range uint64(arrayCount) {
+			v, err := array.Get(i)
+			require.NoError(t, err)
+
+			element, ok := v.(*atree.Array)
+			require.True(t, ok)
+			require.NotEqual(t, childArray.ValueID(), element.ValueID())
+			seen[element.ValueID()] = struct{}{}
+		}
+		require.Equal(t, arrayCount, len(seen))
+
+		// Fill value is untouched.
+		testValueEqual(t, expectedChildValues, childArray)
+
+		_, err = atree.CheckStorageHealth(childStorage, 1)
+		require.NoError(t, err)
+	})
+
+	t.Run("map", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		const arrayCount = 100
+		const childMapCount = 50
+
+		// Fill value is in separate storage, so storage only contains new array.
+		childStorage := newTestPersistentStorage(t)
+
+		childMap, err := atree.NewMap(childStorage, address, atree.NewDefaultDigesterBuilder(), childTypeInfo)
+		require.NoError(t, err)
+
+		expectedChildValues := make(test_utils.ExpectedMapValue)
+		for i := range childMapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+
+			existingStorable, err := childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			expectedChildValues[k] = v
+		}
+
+		array, err := atree.NewArrayFilled(storage, address, typeInfo, childMap, arrayCount, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.Equal(t, uint64(arrayCount), array.Count())
+
+		expectedValues := make([]atree.Value, arrayCount)
+		for i := range expectedValues {
+			expectedValues[i] = expectedChildValues
+		}
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, true)
+
+		// Fill value is untouched.
+		testValueEqual(t, expectedChildValues, childMap)
+
+		_, err = atree.CheckStorageHealth(childStorage, 1)
+		require.NoError(t, err)
+	})
+}
+
+func TestConcatArrays(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+	address2 := atree.Address{8, 7, 6, 5, 4, 3, 2, 1}
+
+	// newArray returns array with values from start to start+count.
+	// Array is created by appending or by batch data, so slab trees
+	// of the same count can be different.
+	newArray := func(t *testing.T, storage atree.SlabStorage, address atree.Address, start, count int, batch bool) (*atree.Array, []atree.Value) {
+		expectedValues := make([]atree.Value, count)
+		for i := range expectedValues {
+			expectedValues[i] = test_utils.Uint64Value(start + i)
+		}
+
+		if batch {
+			i := 0
+			array, err := atree.NewArrayFromBatchData(storage, address, typeInfo, func() (atree.Value, error) {
+				if i == count {
+					return nil, nil
+				}
+				v := expectedValues[i]
+				i++
+				return v, nil
+			})
+			require.NoError(t, err)
+			return array, expectedValues
+		}
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for _, v := range expectedValues {
+			err = array.Append(v)
+			require.NoError(t, err)
+		}
+		return array, expectedValues
+	}
+
+	counts := []int{0, 1, 10, 100, 1000, 10_000}
+
+	t.Run("same address", func(t *testing.T) {
+		for _, count1 := range counts {
+			for _, count2 := range counts {
+				for _, batch := range []bool{false, true} {
+					name := strconv.Itoa(count1) + "+" + strconv.Itoa(count2) + " batch " + strconv.FormatBool(batch)
+					t.Run(name, func(t *testing.T) {
+						storage := newTestPersistentStorage(t)
+
+						array1, expectedValues1 := newArray(t, storage, address, 0, count1, batch)
+						array2, expectedValues2 := newArray(t, storage, address, count1, count2, !batch)
+
+						rootID := array1.SlabID()
+
+						array, err := atree.ConcatArrays(storage, address, typeInfo, array1, array2, test_utils.CompareValue, test_utils.GetHashInput)
+						require.NoError(t, err)
+						require.Equal(t, rootID, array.SlabID())
+						require.Equal(t, uint64(count1+count2), array.Count())
+
+						expectedValues := append(expectedValues1, expectedValues2...)
+
+						testArray(t, storage, typeInfo, address, array, expectedValues, false)
+					})
+				}
+			}
+		}
+	})
+
+	t.Run("mutate after concat", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array1, expectedValues1 := newArray(t, storage, address, 0, 1000, false)
+		array2, expectedValues2 := newArray(t, storage, address, 1000, 5000, true)
+
+		array, err := atree.ConcatArrays(storage, address, typeInfo, array1, array2, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+
+		expectedValues := append(expectedValues1, expectedValues2...)
+
+		r := newRand(t)
+
+		for range 2000 {
+			index := r.Intn(len(expectedValues))
+
+			existingStorable, err := array.Remove(uint64(index))
+			require.NoError(t, err)
+			require.Equal(t, expectedValues[index], existingStorable)
+
+			expectedValues = slices.Delete(expectedValues, index, index+1)
+		}
+
+		for i := range 2000 {
+			index := r.Intn(len(expectedValues) + 1)
+			v := test_utils.Uint64Value(10_000 + i)
+
+			err := array.Insert(uint64(index), v)
+			require.NoError(t, err)
+
+			expectedValues = slices.Insert(expectedValues, index, atree.Value(v))
+		}
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+	})
+
+	t.Run("child arrays", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array1, expectedValues1, _ := createArrayWithChildArrays(t, storage, address, typeInfo, 100, false)
+		array2, expectedValues2, _ := createArrayWithChildArrays(t, storage, address, typeInfo, 50, false)
+
+		array, err := atree.ConcatArrays(storage, address, typeInfo, array1, array2, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+
+		expectedValues := append(expectedValues1, expectedValues2...)
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, true)
+	})
+
+	t.Run("different address", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array1, expectedValues1 := newArray(t, storage, address, 0, 1000, false)
+		array2, expectedValues2, _ := createArrayWithChildArrays(t, storage, address2, typeInfo, 50, false)
+
+		array, err := atree.ConcatArrays(storage, address, typeInfo, array1, array2, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.Equal(t, address, array.Address())
+
+		expectedValues := append(expectedValues1, expectedValues2...)
+
+		testValueEqual(t, test_utils.ExpectedArrayValue(expectedValues), array)
+
+		err = atree.VerifyArray(array, address, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.NoError(t, err)
+
+		// Array at different address is copied and unchanged.
+		testValueEqual(t, test_utils.ExpectedArrayValue(expectedValues2), array2)
+
+		err = atree.VerifyArray(array2, address2, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.NoError(t, err)
+
+		// Concatenated array and copied array don't share any slab.
+		_, err = atree.CheckStorageHealth(storage, 2)
+		require.NoError(t, err)
+	})
+
+	t.Run("same array", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array1, _ := newArray(t, storage, address, 0, 10, false)
+
+		array, err := atree.ConcatArrays(storage, address, typeInfo, array1, array1, test_utils.CompareValue, test_utils.GetHashInput)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+		require.Nil(t, array)
+	})
+
+	t.Run("child container", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		parentArray, _, _ := createArrayWithChildArrays(t, storage, address, typeInfo, 2, false)
+
+		v, err := parentArray.Get(0)
+		require.NoError(t, err)
+
+		childArray, ok := v.(*atree.Array)
+		require.True(t, ok)
+
+		array1, _ := newArray(t, storage, address, 0, 10, false)
+
+		array, err := atree.ConcatArrays(storage, address, typeInfo, array1, childArray, test_utils.CompareValue, test_utils.GetHashInput)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+		require.Nil(t, array)
+	})
+}
+
+func TestArrayRebalanceHysteresis(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	const arrayCount = 4096
+	const opCount = 10_000
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	encMode, err := cbor.EncOptions{}.EncMode()
+	require.NoError(t, err)
+
+	decMode, err := cbor.DecOptions{}.DecMode()
+	require.NoError(t, err)
+
+	// runWorkload returns number of slab rewrites by alternating remove and insert
+	// of the same element, after array is shrunk so data slabs are close to
+	// underflow threshold without hysteresis.
+	// Removing and inserting the same element doesn't change slab content unless
+	// slab is rebalanced or merged with its sibling, so slab rewrites are counted
+	// as increments of slab generations.
+	runWorkload := func(t *testing.T, seed int64, gapFraction float64) uint64 {
+		r := rand.New(rand.NewSource(seed))
+
+		storage := atree.NewPersistentSlabStorage(
+			test_utils.NewInMemBaseStorage(),
+			encMode,
+			decMode,
+			test_utils.DecodeStorable,
+			test_utils.DecodeTypeInfo,
+			atree.WithSlabGenerations(),
+		)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		values := make([]atree.Value, 0, arrayCount)
+		for i := range uint64(arrayCount) {
+			v := test_utils.Uint64Value(i)
+			values = append(values, v)
+
+			err := array.Append(v)
+			require.NoError(t, err)
+		}
+
+		// Remove half of elements at random indexes.
+		for range arrayCount / 2 {
+			index := r.Intn(len(values))
+
+			existingStorable, err := array.Remove(uint64(index))
+			require.NoError(t, err)
+			testValueEqual(t, values[index], existingStorable.(atree.Value))
+
+			values = append(values[:index], values[index+1:]...)
+		}
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		atree.SetRebalanceHysteresis(gapFraction)
+		defer atree.SetRebalanceHysteresis(0)
+
+		slabIDs := make(map[atree.SlabID]uint64)
+		addSlabIDs := func() {
+			iterator, err := storage.SlabIterator()
+			require.NoError(t, err)
+
+			for {
+				id, _ := iterator()
+				if id == atree.SlabIDUndefined {
+					break
+				}
+				if _, exists := slabIDs[id]; !exists {
+					generation, _ := storage.SlabGeneration(id)
+					slabIDs[id] = generation
+				}
+			}
+		}
+		addSlabIDs()
+
+		for range opCount {
+			index := r.Intn(len(values))
+
+			existingStorable, err := array.Remove(uint64(index))
+			require.NoError(t, err)
+			testValueEqual(t, values[index], existingStorable.(atree.Value))
+
+			err = array.Insert(uint64(index), values[index])
+			require.NoError(t, err)
+
+			err = storage.Commit()
+			require.NoError(t, err)
+
+			addSlabIDs()
+		}
+
+		rewrites := uint64(0)
+		for id, initialGeneration := range slabIDs {
+			generation, _ := storage.SlabGeneration(id)
+			rewrites += generation - initialGeneration
+		}
+
+		err = atree.VerifyArray(array, address, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.NoError(t, err)
+
+		require.Equal(t, uint64(len(values)), array.Count())
+		for i, expected := range values {
+			v, err := array.Get(uint64(i))
+			require.NoError(t, err)
+			testValueEqual(t, expected, v)
+		}
+
+		return rewrites
+	}
+
+	seed := newRand(t).Int63()
+
+	rewritesWithoutHysteresis := runWorkload(t, seed, 0)
+
+	rewritesWithHysteresis := runWorkload(t, seed, 0.5)
+
+	t.Logf("slab rewrites without hysteresis: %d, with hysteresis: %d", rewritesWithoutHysteresis, rewritesWithHysteresis)
+
+	require.Less(t, rewritesWithHysteresis, rewritesWithoutHysteresis)
+}
+
+func TestSetRebalanceHysteresis(t *testing.T) {
+
+	minThreshold, maxThreshold, _, _ := atree.SetThreshold(1024)
+	require.Equal(t, uint64(512), minThreshold)
+	require.Equal(t, uint64(1536), maxThreshold)
+
+	atree.SetRebalanceHysteresis(0.25)
+	defer atree.SetRebalanceHysteresis(0)
+
+	minThreshold, maxThreshold, _, _ = atree.SetThreshold(1024)
+	require.Equal(t, uint64(384), minThreshold)
+	require.Equal(t, uint64(1536), maxThreshold)
+
+	for _, gapFraction := range []float64{-0.1, 1, math.NaN()} {
+		require.Panics(t, func() {
+			atree.SetRebalanceHysteresis(gapFraction)
+		})
+	}
+}
+
+func TestArraySchemaID(t *testing.T) {
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("encode and decode", func(t *testing.T) {
+		storage := newTestBasicStorage(t)
+
+		array, err := atree.NewArrayWithSchema(storage, address, typeInfo, 7)
+		require.NoError(t, err)
+		require.Equal(t, uint64(7), array.SchemaID())
+
+		expected := []byte{
+			// version
+			0x10,
+			// flag
+			0x80,
+
+			// extra data
+			// array of extra data
+			0x82,
+			// type info
+			0x18, 0x2a,
+			// schema ID: 7
+			0x07,
+
+			// CBOR encoded array head (fixed size 3 byte)
+			0x99, 0x00, 0x00,
+		}
+
+		// Verify encoded data
+		stored, err := storage.Encode()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(stored))
+		require.Equal(t, expected, stored[array.SlabID()])
+
+		// Decode data to new storage
+		storage2 := newTestPersistentStorageWithData(t, stored)
+
+		decodedArray, err := atree.NewArrayWithRootID(storage2, array.SlabID())
+		require.NoError(t, err)
+		require.Equal(t, uint64(7), decodedArray.SchemaID())
+
+		testEmptyArray(t, storage2, typeInfo, address, decodedArray)
+	})
+
+	t.Run("decode without schema ID", func(t *testing.T) {
+		storage := newTestBasicStorage(t)
+
+		array, err := atree.NewArrayWithSchema(storage, address, typeInfo, 0)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), array.SchemaID())
+
+		stored, err := storage.Encode()
+		require.NoError(t, err)
+		require.Equal(t, byte(0x81), stored[array.SlabID()][2])
+
+		storage2 := newTestPersistentStorageWithData(t, stored)
+
+		decodedArray, err := atree.NewArrayWithRootID(storage2, array.SlabID())
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), decodedArray.SchemaID())
+	})
+
+	t.Run("decode zero schema ID", func(t *testing.T) {
+		id1 := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
+
+		data := map[atree.SlabID][]byte{
+			id1: {
+				// version
+				0x10,
+				// flag
+				0x80,
+
+				// extra data
+				// array of extra data
+				0x82,
+				// type info
+				0x18, 0x2a,
+				// schema ID: 0 (invalid)
+				0x00,
+
+				// CBOR encoded array head (fixed size 3 byte)
+				0x99, 0x00, 0x00,
+			},
+		}
+
+		storage := newTestPersistentStorageWithData(t, data)
+
+		array, err := atree.NewArrayWithRootID(storage, id1)
+		require.Nil(t, array)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var decodingError *atree.DecodingError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &decodingError)
+	})
+
+	t.Run("metadata slab root", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArrayWithSchema(storage, address, typeInfo, math.MaxUint64)
+		require.NoError(t, err)
+
+		const arrayCount = 10_000
+		expectedValues := make(test_utils.ExpectedArrayValue, arrayCount)
+		for i := range arrayCount {
+			v := test_utils.Uint64Value(i)
+			err := array.Append(v)
+			require.NoError(t, err)
+			expectedValues[i] = v
+		}
+		require.False(t, IsArrayRootDataSlab(array))
+
+		testArray(t, storage, typeInfo, address, array, expectedValues, false)
+
+		err = storage.FastCommit(runtime.NumCPU())
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		array2, err := atree.NewArrayWithRootID(storage2, array.SlabID())
+		require.NoError(t, err)
+		require.Equal(t, uint64(math.MaxUint64), array2.SchemaID())
+		require.Equal(t, uint64(arrayCount), array2.Count())
+
+		// DeepCopy preserves schema ID
+		copyStorage := newTestPersistentStorage(t)
+
+		copied, err := array2.DeepCopy(copyStorage, address, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.Equal(t, uint64(math.MaxUint64), copied.SchemaID())
+
+		testArray(t, copyStorage, typeInfo, address, copied, expectedValues, false)
+	})
+
+	t.Run("inlined arrays", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		parentArray, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		// Inlined child arrays with the same type info and different schema IDs
+		// don't share inlined extra data.
+		schemaIDs := []uint64{0, 3, 3, 5}
+
+		for _, schemaID := range schemaIDs {
+			childArray, err := atree.NewArrayWithSchema(storage, address, typeInfo, schemaID)
+			require.NoError(t, err)
+
+			err = childArray.Append(test_utils.Uint64Value(schemaID))
+			require.NoError(t, err)
+
+			err = parentArray.Append(childArray)
+			require.NoError(t, err)
+			require.True(t, childArray.Inlined())
+		}
+
+		err = storage.FastCommit(runtime.NumCPU())
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		parentArray2, err := atree.NewArrayWithRootID(storage2, parentArray.SlabID())
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), parentArray2.SchemaID())
+
+		for i, schemaID := range schemaIDs {
+			v, err := parentArray2.Get(uint64(i))
+			require.NoError(t, err)
+
+			childArray2, ok := v.(*atree.Array)
+			require.True(t, ok)
+			require.True(t, childArray2.Inlined())
+			require.Equal(t, schemaID, childArray2.SchemaID())
+		}
+	})
+}
+
+func TestArrayContains(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const arrayCount = 1000
+
+	equal := func(a, b atree.Value) (bool, error) {
+		return a == b, nil
+	}
+
+	baseStorage := test_utils.NewInMemBaseStorage()
+	storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	array, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+
+	for i := range uint64(arrayCount) {
+		err := array.Append(test_utils.Uint64Value(i))
+		require.NoError(t, err)
+	}
+	require.False(t, IsArrayRootDataSlab(array))
+
+	err = storage.Commit()
+	require.NoError(t, err)
+
+	// containsWithNewStorage loads array with new storage and returns
+	// result of Contains and number of slabs retrieved by Contains.
+	containsWithNewStorage := func(t *testing.T, value atree.Value) (bool, int) {
+		storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		array2, err := atree.NewArrayWithRootID(storage2, array.SlabID())
+		require.NoError(t, err)
+
+		baseStorage.ResetReporter()
+
+		found, err := array2.Contains(value, equal)
+		require.NoError(t, err)
+
+		return found, baseStorage.SegmentsReturned()
+	}
+
+	_, allSlabsRetrieved := containsWithNewStorage(t, test_utils.Uint64Value(arrayCount))
+
+	t.Run("present early", func(t *testing.T) {
+		found, slabsRetrieved := containsWithNewStorage(t, test_utils.Uint64Value(0))
+		require.True(t, found)
+
+		// Only data slabs before the matching element are retrieved.
+		require.Less(t, slabsRetrieved, allSlabsRetrieved/2)
+	})
+
+	t.Run("present late", func(t *testing.T) {
+		found, slabsRetrieved := containsWithNewStorage(t, test_utils.Uint64Value(arrayCount-1))
+		require.True(t, found)
+		require.Equal(t, allSlabsRetrieved, slabsRetrieved)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		found, slabsRetrieved := containsWithNewStorage(t, test_utils.Uint64Value(arrayCount))
+		require.False(t, found)
+		require.Equal(t, allSlabsRetrieved, slabsRetrieved)
+
+		stats, err := atree.GetArrayStats(array)
+		require.NoError(t, err)
+
+		// All slabs except root slab are retrieved.
+		require.Equal(t, int(stats.SlabCount())-1, slabsRetrieved)
+	})
+
+	t.Run("stop at first match", func(t *testing.T) {
+		calls := 0
+		found, err := array.Contains(test_utils.Uint64Value(10), func(a, b atree.Value) (bool, error) {
+			calls++
+			return a == b, nil
+		})
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, 11, calls)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		emptyArray, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		found, err := emptyArray.Contains(test_utils.Uint64Value(0), func(atree.Value, atree.Value) (bool, error) {
+			require.Fail(t, "equal shouldn't be called")
+			return false, nil
+		})
+		require.NoError(t, err)
+		require.False(t, found)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		testErr := errors.New("test")
+
+		found, err := array.Contains(test_utils.Uint64Value(0), func(atree.Value, atree.Value) (bool, error) {
+			return false, testErr
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.Equal(t, testErr, externalError.Unwrap())
+		require.False(t, found)
+	})
+}
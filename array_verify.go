@@ -498,7 +498,9 @@ func verifyNotInlinedValueStatusAndSize(v Value, maxInlineSize uint32) error {
 		}
 
 		// Verify not-inlined map size.
-		if v.root.IsData() {
+		// Map with narrow digests or single digest level is never inlined.
+		extraData := v.root.ExtraData()
+		if v.root.IsData() && !extraData.narrowDigests && !extraData.singleDigestLevel {
 			inlinableSize := v.root.ByteSize() - mapRootDataSlabPrefixSize + inlinedMapDataSlabPrefixSize
 			if inlinableSize <= maxInlineSize {
 				return NewFatalError(
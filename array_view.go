@@ -0,0 +1,93 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+// ArrayView is a read-only window of array elements in range [startIndex, endIndex).
+// ArrayView shares slabs with its array, so creating a view doesn't copy elements.
+//
+// Array.Set is visible through view because it doesn't change element indexes.
+// Other array mutations (Insert, Remove, and PopIterate) invalidate the view,
+// and view operations return ConcurrentModificationError after that.
+type ArrayView struct {
+	array              *Array
+	startIndex         uint64
+	endIndex           uint64
+	structuralModCount uint64
+}
+
+// View returns a read-only view of array elements in range [startIndex, endIndex).
+func (a *Array) View(startIndex uint64, endIndex uint64) (*ArrayView, error) {
+	count := a.Count()
+
+	if startIndex > count || endIndex > count {
+		return nil, NewSliceOutOfBoundsError(startIndex, endIndex, 0, count)
+	}
+
+	if startIndex > endIndex {
+		return nil, NewInvalidSliceIndexError(startIndex, endIndex)
+	}
+
+	return &ArrayView{
+		array:              a,
+		startIndex:         startIndex,
+		endIndex:           endIndex,
+		structuralModCount: a.structuralModCount,
+	}, nil
+}
+
+// Count returns number of elements in the view.
+func (v *ArrayView) Count() uint64 {
+	return v.endIndex - v.startIndex
+}
+
+// Get returns element at index i of the view, which is
+// element at index startIndex+i of underlying array.
+func (v *ArrayView) Get(i uint64) (Value, error) {
+	err := v.array.checkStructuralModCount(v.structuralModCount)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.checkStructuralModCount().
+		return nil, err
+	}
+
+	if i >= v.Count() {
+		return nil, NewIndexOutOfBoundsError(i, 0, v.Count())
+	}
+
+	element, err := v.array.Get(v.startIndex + i)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.Get().
+		return nil, err
+	}
+
+	return element, nil
+}
+
+// Iterate iterates elements of the view with readonly iterator.
+// If the underlying array is mutated during iteration,
+// ConcurrentModificationError is returned.
+func (v *ArrayView) Iterate(fn ArrayIterationFunc) error {
+	err := v.array.checkStructuralModCount(v.structuralModCount)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.checkStructuralModCount().
+		return err
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by Array.IterateReadOnlyRange().
+	return v.array.IterateReadOnlyRange(v.startIndex, v.endIndex, fn)
+}
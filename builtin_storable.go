@@ -0,0 +1,149 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+// builtinStorableKind identifies storable encoded with CBORTagBuiltinStorable.
+type builtinStorableKind uint64
+
+const (
+	builtinStorableKindTuple builtinStorableKind = iota
+	builtinStorableKindStringDictionary
+	builtinStorableKindInternedString
+	builtinStorableKindStreamChunk
+)
+
+// encodeBuiltinStorableHead encodes head of builtin storable as
+//
+//	cbor.Tag{
+//			Number: CBORTagBuiltinStorable,
+//			Content: []any{
+//				kind (uint64),
+//				fields...,
+//			},
+//	}
+//
+// Caller encodes fieldCount fields after head.
+func encodeBuiltinStorableHead(enc *Encoder, kind builtinStorableKind, fieldCount uint64) error {
+	err := enc.CBOR.EncodeRawBytes([]byte{
+		// tag number
+		0xd8, CBORTagBuiltinStorable,
+	})
+	if err != nil {
+		return NewEncodingError(err)
+	}
+
+	err = enc.CBOR.EncodeArrayHead(fieldCount + 1)
+	if err != nil {
+		return NewEncodingError(err)
+	}
+
+	err = enc.CBOR.EncodeUint64(uint64(kind))
+	if err != nil {
+		return NewEncodingError(err)
+	}
+
+	return nil
+}
+
+// builtinStorableHeadSize returns encoded size of builtin storable head.
+func builtinStorableHeadSize(kind builtinStorableKind, fieldCount uint64) uint32 {
+	// tag number (2 bytes) + array head + kind
+	return 2 + GetUintCBORSize(fieldCount+1) + GetUintCBORSize(uint64(kind))
+}
+
+// isBuiltinStorableData returns true if b starts with CBOR tag number of builtin storable.
+func isBuiltinStorableData(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0xd8 && b[1] == CBORTagBuiltinStorable
+}
+
+// DecodeBuiltinStorable decodes inlined storable of value implemented by
+// atree, such as TupleValue and InternedStringValue.  Tag number
+// CBORTagBuiltinStorable is already decoded by caller, and nested
+// storables are decoded by decodeStorable.
+func DecodeBuiltinStorable(
+	dec *cbor.StreamDecoder,
+	decodeStorable StorableDecoder,
+	slabID SlabID,
+	inlinedExtraData []ExtraData,
+) (
+	Storable,
+	error,
+) {
+	return decodeBuiltinStorable(dec, decodeStorable, slabID, inlinedExtraData, false)
+}
+
+// decodeBuiltinStorable decodes builtin storable.  If storableSlab is true,
+// builtin storable is content of StorableSlab with slabID.  String dictionary
+// and stream chunk are only stored in their own StorableSlab.
+func decodeBuiltinStorable(
+	dec *cbor.StreamDecoder,
+	decodeStorable StorableDecoder,
+	slabID SlabID,
+	inlinedExtraData []ExtraData,
+	storableSlab bool,
+) (
+	Storable,
+	error,
+) {
+	length, err := dec.DecodeArrayHead()
+	if err != nil {
+		return nil, NewDecodingError(err)
+	}
+	if length == 0 {
+		return nil, NewDecodingErrorf("failed to decode builtin storable: expect at least 1 element, got 0")
+	}
+
+	kind, err := dec.DecodeUint64()
+	if err != nil {
+		return nil, NewDecodingError(err)
+	}
+
+	fieldCount := length - 1
+
+	switch builtinStorableKind(kind) {
+	case builtinStorableKindTuple:
+		// Don't need to wrap error as external error because err is already categorized by decodeTupleStorable().
+		return decodeTupleStorable(dec, fieldCount, decodeStorable, slabID, inlinedExtraData)
+
+	case builtinStorableKindInternedString:
+		// Don't need to wrap error as external error because err is already categorized by decodeInternedStringStorable().
+		return decodeInternedStringStorable(dec, fieldCount)
+
+	case builtinStorableKindStringDictionary:
+		if !storableSlab {
+			return nil, NewDecodingErrorf("failed to decode builtin storable: string dictionary can't be inlined")
+		}
+		// Don't need to wrap error as external error because err is already categorized by decodeStringDictionaryStorable().
+		return decodeStringDictionaryStorable(dec, fieldCount, slabID)
+
+	case builtinStorableKindStreamChunk:
+		if !storableSlab {
+			return nil, NewDecodingErrorf("failed to decode builtin storable: stream chunk can't be inlined")
+		}
+		// Don't need to wrap error as external error because err is already categorized by decodeStreamChunkStorable().
+		return decodeStreamChunkStorable(dec, fieldCount)
+
+	default:
+		return nil, NewDecodingErrorf("failed to decode builtin storable: invalid kind %d", kind)
+	}
+}
@@ -43,7 +43,12 @@ const (
 	_ = 242
 	_ = 243
 	_ = 244
-	_ = 245
+
+	// CBORTagBuiltinStorable is shared by storables of values implemented
+	// by atree (TupleValue, StringDictionary, InternedStringValue, and
+	// StreamValue).  Tag content starts with builtin storable kind, so
+	// new builtin values don't need new tag numbers.
+	CBORTagBuiltinStorable = 245
 
 	CBORTagTypeInfoRef = 246
 
@@ -0,0 +1,142 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// containerBlob is encoded as CBOR array of root slab ID and
+// encoded slabs of the container.
+type containerBlob struct {
+	_      struct{} `cbor:",toarray"`
+	RootID []byte
+	Slabs  []containerBlobSlab
+}
+
+type containerBlobSlab struct {
+	_    struct{} `cbor:",toarray"`
+	ID   []byte
+	Data []byte
+}
+
+// EncodeContainerToBlob encodes map m and all slabs it references (including
+// slabs of nested containers, external collision groups, and values stored
+// in separate slabs) into a single self-contained blob.  Slabs are encoded
+// in IterateSlabs order, so the same slab layout always produces the same blob.
+// Blob can be decoded by DecodeContainerFromBlob without the storage of m.
+// It returns error if m is inlined because inlined map doesn't have its own
+// root slab in storage.
+func EncodeContainerToBlob(m *OrderedMap, encMode cbor.EncMode) ([]byte, error) {
+	if m.Inlined() {
+		return nil, NewUserError(fmt.Errorf("failed to encode inlined map %s to blob", m.ValueID()))
+	}
+
+	blob := containerBlob{
+		RootID: slabIDToRawBytes(m.SlabID()),
+	}
+
+	err := IterateSlabs(m.Storage, m.SlabID(), func(id SlabID, slab Slab) (bool, error) {
+		data, err := EncodeSlab(slab, encMode)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by EncodeSlab().
+			return false, err
+		}
+
+		blob.Slabs = append(blob.Slabs, containerBlobSlab{
+			ID:   slabIDToRawBytes(id),
+			Data: data,
+		})
+
+		return true, nil
+	})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by IterateSlabs().
+		return nil, err
+	}
+
+	data, err := encMode.Marshal(blob)
+	if err != nil {
+		return nil, NewEncodingError(err)
+	}
+
+	return data, nil
+}
+
+// DecodeContainerFromBlob decodes blob encoded by EncodeContainerToBlob into
+// a fresh in-memory BasicSlabStorage, and returns map loaded from decoded
+// root slab.  Decoded slabs keep their original slab IDs, and new slab IDs
+// generated by returned storage don't collide with decoded slab IDs.
+// encMode is used by returned storage to encode slabs.
+func DecodeContainerFromBlob(
+	data []byte,
+	encMode cbor.EncMode,
+	decMode cbor.DecMode,
+	decodeStorable StorableDecoder,
+	decodeTypeInfo TypeInfoDecoder,
+	digesterBuilder DigesterBuilder,
+) (*OrderedMap, error) {
+	var blob containerBlob
+
+	err := decMode.Unmarshal(data, &blob)
+	if err != nil {
+		return nil, NewDecodingError(err)
+	}
+
+	rootID, err := NewSlabIDFromRawBytes(blob.RootID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by NewSlabIDFromRawBytes().
+		return nil, err
+	}
+
+	storage := NewBasicSlabStorage(encMode, decMode, decodeStorable, decodeTypeInfo)
+
+	for _, s := range blob.Slabs {
+		id, err := NewSlabIDFromRawBytes(s.ID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by NewSlabIDFromRawBytes().
+			return nil, err
+		}
+
+		slab, err := DecodeSlab(id, s.Data, decMode, decodeStorable, decodeTypeInfo)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by DecodeSlab().
+			return nil, err
+		}
+
+		storage.Slabs[id] = slab
+
+		index := storage.slabIndex[id.address]
+		if id.IndexAsUint64() > binary.BigEndian.Uint64(index[:]) {
+			storage.slabIndex[id.address] = id.index
+		}
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by NewMapWithRootID().
+	return NewMapWithRootID(storage, rootID, digesterBuilder)
+}
+
+func slabIDToRawBytes(id SlabID) []byte {
+	b := make([]byte, SlabIDLength)
+	_, _ = id.ToRawBytes(b)
+	return b
+}
@@ -18,7 +18,11 @@
 
 package atree
 
-import "github.com/fxamacker/cbor/v2"
+import (
+	"runtime/debug"
+
+	"github.com/fxamacker/cbor/v2"
+)
 
 type StorableDecoder func(
 	decoder *cbor.StreamDecoder,
@@ -29,12 +33,57 @@ type StorableDecoder func(
 	error,
 )
 
+// recoverDecodingPanic determines whether DecodeSlab recovers from panics
+// raised while decoding slab data (e.g. by CBOR library or StorableDecoder).
+var recoverDecodingPanic = true
+
+// SetRecoverDecodingPanic enables or disables panic recovery in DecodeSlab
+// and returns previous setting.  Panic recovery is enabled by default so
+// a single corrupt slab can't crash a program decoding many slabs.
+// Fuzz tests can disable it so panics are surfaced instead of recovered.
+func SetRecoverDecodingPanic(enable bool) bool {
+	prev := recoverDecodingPanic
+	recoverDecodingPanic = enable
+	return prev
+}
+
+// DecodeSlab decodes slab data with given slab ID.
+// If panic recovery is enabled (default), DecodeSlab returns DecodingPanicError
+// (wrapped in DecodingError) if a panic is raised during decoding.
 func DecodeSlab(
 	id SlabID,
 	data []byte,
 	decMode cbor.DecMode,
 	decodeStorable StorableDecoder,
 	decodeTypeInfo TypeInfoDecoder,
+) (
+	slab Slab,
+	err error,
+) {
+	if recoverDecodingPanic {
+		defer func() {
+			if r := recover(); r != nil {
+				slab = nil
+				err = NewDecodingPanicError(id, r, debug.Stack())
+			}
+		}()
+	}
+
+	return decodeSlab(id, data, decMode, decodeStorable, decodeTypeInfo)
+}
+
+// decodeTypeInfoNotConfigured is used in place of nil TypeInfoDecoder,
+// so decoding type info returns DecodingError instead of panicking.
+func decodeTypeInfoNotConfigured(*cbor.StreamDecoder) (TypeInfo, error) {
+	return nil, NewDecodingErrorf("type info decoder not configured")
+}
+
+func decodeSlab(
+	id SlabID,
+	data []byte,
+	decMode cbor.DecMode,
+	decodeStorable StorableDecoder,
+	decodeTypeInfo TypeInfoDecoder,
 ) (
 	Slab,
 	error,
@@ -43,6 +92,12 @@ func DecodeSlab(
 		return nil, NewDecodingErrorf("data is too short")
 	}
 
+	if decodeTypeInfo == nil {
+		// Type info is only decoded from slabs with extra data (such as root slabs),
+		// so other slabs can still be decoded without type info decoder.
+		decodeTypeInfo = decodeTypeInfoNotConfigured
+	}
+
 	h, err := newHeadFromData(data[:versionAndFlagSize])
 	if err != nil {
 		return nil, NewDecodingError(err)
@@ -80,6 +135,27 @@ func DecodeSlab(
 
 	case slabStorable:
 		cborDec := decMode.NewByteStreamDecoder(data[versionAndFlagSize:])
+
+		// Tag number CBORTagBuiltinStorable is reserved for atree, so builtin
+		// storable (such as string dictionary or stream chunk) is decoded by atree
+		// without relying on StorableDecoder to handle it.
+		if isBuiltinStorableData(data[versionAndFlagSize:]) {
+			_, err := cborDec.DecodeTagNumber()
+			if err != nil {
+				return nil, NewDecodingError(err)
+			}
+
+			storable, err := decodeBuiltinStorable(cborDec, decodeStorable, id, nil, true)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by decodeBuiltinStorable().
+				return nil, err
+			}
+			return &StorableSlab{
+				slabID:   id,
+				storable: storable,
+			}, nil
+		}
+
 		storable, err := decodeStorable(cborDec, id, nil)
 		if err != nil {
 			// Wrap err as external error (if needed) because err is returned by StorableDecoder callback.
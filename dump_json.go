@@ -0,0 +1,138 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ValueToJSON converts non-container element value to value
+// which can be marshaled by encoding/json.
+type ValueToJSON func(Value) (any, error)
+
+// mapEntryJSON is JSON representation of map element.  Map is dumped
+// as JSON array of entries because map keys aren't necessarily strings
+// and JSON object doesn't preserve iteration order.
+type mapEntryJSON struct {
+	Key   any `json:"key"`
+	Value any `json:"value"`
+}
+
+// DumpArrayJSON writes logical content of array (not slab structure)
+// to w as JSON array in iteration order.  Nested arrays and maps are
+// dumped recursively, and other elements are converted by valueToJSON.
+func DumpArrayJSON(a *Array, valueToJSON ValueToJSON, w io.Writer) error {
+	v, err := arrayToJSON(a, valueToJSON)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by arrayToJSON().
+		return err
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by writeJSON().
+	return writeJSON(v, w)
+}
+
+// DumpMapJSON writes logical content of map (not slab structure) to w
+// as JSON array of {"key": ..., "value": ...} entries in iteration order.
+// Nested arrays and maps are dumped recursively, and other keys and
+// values are converted by valueToJSON.
+func DumpMapJSON(m *OrderedMap, valueToJSON ValueToJSON, w io.Writer) error {
+	v, err := mapToJSON(m, valueToJSON)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by mapToJSON().
+		return err
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by writeJSON().
+	return writeJSON(v, w)
+}
+
+func writeJSON(v any, w io.Writer) error {
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by io.Writer or ValueToJSON result.
+		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to write JSON")
+	}
+	return nil
+}
+
+func arrayToJSON(a *Array, valueToJSON ValueToJSON) ([]any, error) {
+	elements := make([]any, 0, a.Count())
+
+	err := a.IterateReadOnly(func(element Value) (bool, error) {
+		v, err := valueToJSONRecursive(element, valueToJSON)
+		if err != nil {
+			return false, err
+		}
+		elements = append(elements, v)
+		return true, nil
+	})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.IterateReadOnly().
+		return nil, err
+	}
+
+	return elements, nil
+}
+
+func mapToJSON(m *OrderedMap, valueToJSON ValueToJSON) ([]mapEntryJSON, error) {
+	entries := make([]mapEntryJSON, 0, m.Count())
+
+	err := m.IterateReadOnly(func(key Value, value Value) (bool, error) {
+		k, err := valueToJSONRecursive(key, valueToJSON)
+		if err != nil {
+			return false, err
+		}
+
+		v, err := valueToJSONRecursive(value, valueToJSON)
+		if err != nil {
+			return false, err
+		}
+
+		entries = append(entries, mapEntryJSON{Key: k, Value: v})
+		return true, nil
+	})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.IterateReadOnly().
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func valueToJSONRecursive(value Value, valueToJSON ValueToJSON) (any, error) {
+	switch value := value.(type) {
+	case *Array:
+		// Don't need to wrap error as external error because err is already categorized by arrayToJSON().
+		return arrayToJSON(value, valueToJSON)
+
+	case *OrderedMap:
+		// Don't need to wrap error as external error because err is already categorized by mapToJSON().
+		return mapToJSON(value, valueToJSON)
+
+	default:
+		v, err := valueToJSON(value)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by ValueToJSON callback.
+			return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to convert value to JSON")
+		}
+		return v, nil
+	}
+}
@@ -20,6 +20,7 @@ package atree
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"math"
 
@@ -33,6 +34,11 @@ type Encoder struct {
 	Scratch           [64]byte
 	encMode           cbor.EncMode
 	_inlinedExtraData *InlinedExtraData
+
+	// canonicalStorage is non-nil if Encoder is in canonical encode mode
+	// (see EncodeSlabCanonical).  It is used to retrieve keys stored in
+	// separate slabs to order colliding map elements.
+	canonicalStorage SlabStorage
 }
 
 func NewEncoder(w io.Writer, encMode cbor.EncMode) *Encoder {
@@ -44,6 +50,14 @@ func NewEncoder(w io.Writer, encMode cbor.EncMode) *Encoder {
 	}
 }
 
+// newElementEncoder returns Encoder used to encode elements of slab
+// encoded by enc.  Returned Encoder inherits encode mode of enc.
+func (enc *Encoder) newElementEncoder(w io.Writer) *Encoder {
+	elementEnc := NewEncoder(w, enc.encMode)
+	elementEnc.canonicalStorage = enc.canonicalStorage
+	return elementEnc
+}
+
 func (enc *Encoder) inlinedExtraData() *InlinedExtraData {
 	if enc._inlinedExtraData == nil {
 		enc._inlinedExtraData = newInlinedExtraData()
@@ -59,8 +73,31 @@ func (enc *Encoder) hasInlinedExtraData() bool {
 }
 
 func EncodeSlab(slab Slab, encMode cbor.EncMode) ([]byte, error) {
+	// Don't need to wrap error as external error because err is already categorized by encodeSlab().
+	return encodeSlab(slab, encMode, nil)
+}
+
+// EncodeSlabCanonical is like EncodeSlab, but encodes slab in canonical
+// encode mode.  In canonical encode mode, map elements with the same
+// digests at all levels are encoded in ascending lexicographical order
+// of encoded key, regardless of their in-memory order (e.g. elements
+// decoded from data encoded before colliding elements were ordered by key).
+// So the same logical content of a map slab always has the same encoding.
+//
+// Keys stored in separate slabs are ordered by encoded content of their
+// slabs (the same order as colliding elements in memory), so storage is
+// used to retrieve these slabs.  Canonical encode mode doesn't change slab
+// boundaries (which depend on history of inserts and removes).
+func EncodeSlabCanonical(storage SlabStorage, slab Slab, encMode cbor.EncMode) ([]byte, error) {
+	// Don't need to wrap error as external error because err is already categorized by encodeSlab().
+	return encodeSlab(slab, encMode, storage)
+}
+
+// encodeSlab encodes slab, in canonical encode mode if canonicalStorage is non-nil.
+func encodeSlab(slab Slab, encMode cbor.EncMode, canonicalStorage SlabStorage) ([]byte, error) {
 	var buf bytes.Buffer
 	enc := NewEncoder(&buf, encMode)
+	enc.canonicalStorage = canonicalStorage
 
 	err := slab.Encode(enc)
 	if err != nil {
@@ -76,6 +113,22 @@ func EncodeSlab(slab Slab, encMode cbor.EncMode) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// EncodeSlabByID retrieves slab with given ID from storage and returns its encoded data.
+// It is useful for exporting a single slab without encoding the entire storage.
+func EncodeSlabByID(s SlabStorage, id SlabID, encMode cbor.EncMode) ([]byte, error) {
+	slab, found, err := s.Retrieve(id)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+	}
+	if !found {
+		return nil, NewSlabNotFoundErrorf(id, "failed to retrieve slab")
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by EncodeSlab().
+	return EncodeSlab(slab, encMode)
+}
+
 func GetUintCBORSize(n uint64) uint32 {
 	if n <= 23 {
 		return 1
@@ -19,9 +19,12 @@
 package atree
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"runtime/debug"
+	"slices"
+	"strings"
 )
 
 type ExternalError struct {
@@ -44,6 +47,42 @@ func (e *ExternalError) Unwrap() error {
 	return e.err
 }
 
+// Temporary returns true if wrapped error is transient (e.g. network
+// failure) and the failed operation can be retried.  Wrapped error is
+// transient if any error in its chain has Temporary() returning true.
+func (e *ExternalError) Temporary() bool {
+	var te temporary
+	return errors.As(e.err, &te) && te.Temporary()
+}
+
+type temporary interface {
+	Temporary() bool
+}
+
+// IsTemporaryError returns true if err is ExternalError wrapping
+// transient error, so the failed operation can be retried.
+func IsTemporaryError(err error) bool {
+	var externalError *ExternalError
+	return errors.As(err, &externalError) && externalError.Temporary()
+}
+
+// temporaryError marks error classified as transient by RetryableBaseStorage.
+type temporaryError struct {
+	err error
+}
+
+func (e *temporaryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *temporaryError) Unwrap() error {
+	return e.err
+}
+
+func (e *temporaryError) Temporary() bool {
+	return true
+}
+
 type UserError struct {
 	err error
 }
@@ -166,6 +205,12 @@ func NewDuplicateKeyError(key any) error {
 	return NewFatalError(&DuplicateKeyError{key: key})
 }
 
+// NewDuplicateKeyUserError constructs a DuplicateKeyError as user error.
+// It is returned by OrderedMap.Insert when key already exists in the map.
+func NewDuplicateKeyUserError(key any) error {
+	return NewUserError(&DuplicateKeyError{key: key})
+}
+
 func (e *DuplicateKeyError) Error() string {
 	return fmt.Sprintf("duplicate key (%s)", e.key)
 }
@@ -184,6 +229,79 @@ func (e *KeyNotFoundError) Error() string {
 	return fmt.Sprintf("key (%s) not found", e.key)
 }
 
+// NaNKeyError is a user error returned when NaN is used as map key.
+// NaN isn't equal to itself, so element with NaN key can't be found.
+type NaNKeyError struct {
+	key any
+}
+
+// NewNaNKeyError constructs a NaNKeyError
+func NewNaNKeyError(key any) error {
+	return NewUserError(&NaNKeyError{key: key})
+}
+
+func (e *NaNKeyError) Error() string {
+	return fmt.Sprintf("key (%s) is NaN", e.key)
+}
+
+// DuplicateCBORTagError is a user error returned when CBOR tag number
+// is registered more than once with StorableRegistry.
+type DuplicateCBORTagError struct {
+	tagNum uint64
+}
+
+// NewDuplicateCBORTagError constructs a DuplicateCBORTagError
+func NewDuplicateCBORTagError(tagNum uint64) error {
+	return NewUserError(&DuplicateCBORTagError{tagNum: tagNum})
+}
+
+func (e *DuplicateCBORTagError) Error() string {
+	return fmt.Sprintf("CBOR tag number %d is already registered", e.tagNum)
+}
+
+// TempAddressError is a user error returned when container is created with
+// AddressUndefined without AllowTempAddress option.
+type TempAddressError struct {
+}
+
+// NewTempAddressError constructs a TempAddressError
+func NewTempAddressError() error {
+	return NewUserError(&TempAddressError{})
+}
+
+func (e *TempAddressError) Error() string {
+	return "cannot create container with undefined (temp) address without AllowTempAddress option"
+}
+
+// InsertionOrderIndexError is a user error returned when map
+// without insertion order index is iterated in insertion order.
+type InsertionOrderIndexError struct {
+	valueID ValueID
+}
+
+// NewInsertionOrderIndexError constructs an InsertionOrderIndexError
+func NewInsertionOrderIndexError(valueID ValueID) error {
+	return NewUserError(&InsertionOrderIndexError{valueID: valueID})
+}
+
+func (e *InsertionOrderIndexError) Error() string {
+	return fmt.Sprintf("map %s doesn't have insertion order index", e.valueID)
+}
+
+// ReadOnlyError is a user error returned when read-only container is mutated.
+type ReadOnlyError struct {
+	valueID ValueID
+}
+
+// NewReadOnlyError constructs a ReadOnlyError
+func NewReadOnlyError(valueID ValueID) error {
+	return NewUserError(&ReadOnlyError{valueID: valueID})
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("container %s is read-only", e.valueID)
+}
+
 // HashSeedUninitializedError is a fatal error returned when hash seed is uninitialized.
 type HashSeedUninitializedError struct {
 }
@@ -364,6 +482,75 @@ func (e *DecodingError) Error() string {
 	return fmt.Sprintf("decoding error: %s", e.err.Error())
 }
 
+func (e *DecodingError) Unwrap() error {
+	return e.err
+}
+
+// decodingPanicErrorStackFrames is max number of stack frames
+// (starting from frame which panicked) included in error message
+// of DecodingPanicError.  Full stack is in DecodingPanicError.Stack.
+const decodingPanicErrorStackFrames = 5
+
+// DecodingPanicError is returned (wrapped in DecodingError) when a panic is
+// recovered while decoding slab data.  Error message only includes a few
+// stack frames, and Stack has the full stack trace.
+type DecodingPanicError struct {
+	slabID    SlabID
+	recovered any
+	Stack     []byte
+}
+
+// NewDecodingPanicError constructs a DecodingError wrapping DecodingPanicError.
+func NewDecodingPanicError(slabID SlabID, recovered any, stack []byte) error {
+	return NewDecodingError(&DecodingPanicError{
+		slabID:    slabID,
+		recovered: recovered,
+		Stack:     stack,
+	})
+}
+
+// SlabID returns ID of slab being decoded when panic occurred.
+func (e *DecodingPanicError) SlabID() SlabID {
+	return e.slabID
+}
+
+func (e *DecodingPanicError) Error() string {
+	return fmt.Sprintf(
+		"recovered from panic while decoding slab %s: %v\n%s",
+		e.slabID,
+		e.recovered,
+		truncateStack(e.Stack, decodingPanicErrorStackFrames),
+	)
+}
+
+// truncateStack returns at most frameCount frames of stack trace
+// returned by debug.Stack(), starting from the frame which panicked.
+// Each frame is a function line followed by a file line.
+func truncateStack(stack []byte, frameCount int) []byte {
+	lines := bytes.Split(bytes.TrimRight(stack, "\n"), []byte("\n"))
+	if len(lines) < 2 {
+		return stack
+	}
+
+	// Skip goroutine header line.
+	frames := lines[1:]
+
+	// Skip frames of debug.Stack() and recover handler up to panic().
+	for i := 0; i+1 < len(frames); i += 2 {
+		if bytes.HasPrefix(frames[i], []byte("panic(")) {
+			frames = frames[i+2:]
+			break
+		}
+	}
+
+	if len(frames) <= frameCount*2 {
+		return bytes.Join(frames, []byte("\n"))
+	}
+
+	truncated := bytes.Join(frames[:frameCount*2], []byte("\n"))
+	return append(truncated, "\n..."...)
+}
+
 // NotImplementedError is a fatal error returned when a method is called which is not yet implemented
 // this is a temporary error
 type NotImplementedError struct {
@@ -474,6 +661,136 @@ func (e *ReadOnlyIteratorElementMutationError) Error() string {
 	return fmt.Sprintf("element (%s) cannot be mutated because it is from readonly iterator of container (%s)", e.elementValueID, e.containerValueID)
 }
 
+// MaxMapSizeError is returned when inserting a new element into
+// a map which already has max number of elements.
+type MaxMapSizeError struct {
+	maxCount uint64
+}
+
+// NewMaxMapSizeError constructs a MaxMapSizeError.
+func NewMaxMapSizeError(maxCount uint64) error {
+	return NewUserError(&MaxMapSizeError{maxCount: maxCount})
+}
+
+func (e *MaxMapSizeError) Error() string {
+	return fmt.Sprintf("map element count exceeds max count %d", e.maxCount)
+}
+
+// MaxValueSizeError is returned when adding or updating array element
+// or map value larger than max value size (see SetMaxValueSize).
+type MaxValueSizeError struct {
+	size    uint64
+	maxSize uint64
+}
+
+// NewMaxValueSizeError constructs a MaxValueSizeError.
+func NewMaxValueSizeError(size uint64, maxSize uint64) error {
+	return NewUserError(&MaxValueSizeError{size: size, maxSize: maxSize})
+}
+
+func (e *MaxValueSizeError) Error() string {
+	return fmt.Sprintf("value size %d exceeds max value size %d", e.size, e.maxSize)
+}
+
+// NotContainerError is returned when value of map key is expected
+// to be a container (Array or OrderedMap), but it isn't.
+type NotContainerError struct {
+	key   any
+	value any
+}
+
+// NewNotContainerError constructs a NotContainerError.
+func NewNotContainerError(key any, value any) error {
+	return NewUserError(&NotContainerError{key: key, value: value})
+}
+
+func (e *NotContainerError) Error() string {
+	return fmt.Sprintf("value (%T) of key (%s) isn't container", e.value, e.key)
+}
+
+// ConcurrentModificationError is returned when iterator is used
+// after its container is modified by Set, Insert, Remove, etc.
+type ConcurrentModificationError struct {
+	valueID ValueID
+}
+
+// NewConcurrentModificationError constructs a ConcurrentModificationError.
+func NewConcurrentModificationError(valueID ValueID) error {
+	return NewUserError(&ConcurrentModificationError{valueID: valueID})
+}
+
+func (e *ConcurrentModificationError) Error() string {
+	return fmt.Sprintf("container (%s) is modified during iteration", e.valueID)
+}
+
+// IteratorClosedError is a user error returned when iterator is used after Close.
+type IteratorClosedError struct {
+}
+
+// NewIteratorClosedError constructs an IteratorClosedError.
+func NewIteratorClosedError() error {
+	return NewUserError(&IteratorClosedError{})
+}
+
+func (e *IteratorClosedError) Error() string {
+	return "iterator is closed"
+}
+
+// StaleReadError is a fatal error returned when a slab retrieved from
+// versioned base storage has an older version than previously retrieved.
+type StaleReadError struct {
+	slabID          SlabID
+	version         uint64
+	previousVersion uint64
+}
+
+// NewStaleReadError constructs a StaleReadError.
+func NewStaleReadError(slabID SlabID, version, previousVersion uint64) error {
+	return NewFatalError(&StaleReadError{
+		slabID:          slabID,
+		version:         version,
+		previousVersion: previousVersion,
+	})
+}
+
+func (e *StaleReadError) Error() string {
+	return fmt.Sprintf("slab (%s) version %d is older than previously retrieved version %d", e.slabID, e.version, e.previousVersion)
+}
+
+// ContainerValidationError is a fatal error returned by ValidateAll.
+// It contains verification error of each invalid container.
+type ContainerValidationError struct {
+	errs map[SlabID]error
+}
+
+// NewContainerValidationError constructs a ContainerValidationError.
+func NewContainerValidationError(errs map[SlabID]error) error {
+	return NewFatalError(&ContainerValidationError{errs: errs})
+}
+
+// Errors returns verification errors keyed by root slab ID of invalid container.
+func (e *ContainerValidationError) Errors() map[SlabID]error {
+	return e.errs
+}
+
+func (e *ContainerValidationError) Error() string {
+	ids := make([]SlabID, 0, len(e.errs))
+	for id := range e.errs {
+		ids = append(ids, id)
+	}
+
+	slices.SortFunc(ids, func(a, b SlabID) int {
+		return a.Compare(b)
+	})
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d containers are invalid:", len(ids))
+	for _, id := range ids {
+		fmt.Fprintf(&sb, " %s: %s;", id, e.errs[id])
+	}
+	return strings.TrimSuffix(sb.String(), ";")
+}
+
 func wrapErrorAsExternalErrorIfNeeded(err error) error {
 	return wrapErrorfAsExternalErrorIfNeeded(err, "")
 }
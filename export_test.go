@@ -20,6 +20,8 @@ package atree
 
 import (
 	"fmt"
+	"slices"
+	"time"
 )
 
 // Exported functions of PersistentSlabStorage for testing.
@@ -63,6 +65,13 @@ var (
 	UnwrapStorable = unwrapStorable
 )
 
+// Exported constant and function of iteration options for testing.
+const MaxRetryBackoff = maxRetryBackoff
+
+func GetRetryBackoff(opts []IterationOption, retry int) time.Duration {
+	return newIterationConfig(opts).retryBackoff(retry)
+}
+
 func NewArrayRootDataSlab(id SlabID, storables []Storable) ArraySlab {
 	size := uint32(arrayRootDataSlabPrefixSize)
 
@@ -104,6 +113,20 @@ func GetArrayMetaDataSlabChildInfo(metaDataSlab *ArrayMetaDataSlab) (childSlabID
 	return childSlabIDs, childCounts
 }
 
+func GetArrayDataSlabNextSlabID(dataSlab *ArrayDataSlab) SlabID {
+	return dataSlab.next
+}
+
+func GetMapDataSlabNextAndPrevSlabIDs(dataSlab *MapDataSlab) (next SlabID, prev SlabID) {
+	return dataSlab.next, dataSlab.prev
+}
+
+// SetMapDataSlabDigest sets digest at given index in data slab.
+// It is used to corrupt digest order for testing.
+func SetMapDataSlabDigest(dataSlab *MapDataSlab, index int, d Digest) {
+	dataSlab.elements.(*hkeyElements).hkeys[index] = d
+}
+
 func GetMapMetaDataSlabChildInfo(metaDataSlab *MapMetaDataSlab) (childSlabIDs []SlabID, childSizes []uint32, childFirstKeys []Digest) {
 	childSlabIDs = make([]SlabID, len(metaDataSlab.childrenHeaders))
 	childSizes = make([]uint32, len(metaDataSlab.childrenHeaders))
@@ -118,6 +141,15 @@ func GetMapMetaDataSlabChildInfo(metaDataSlab *MapMetaDataSlab) (childSlabIDs []
 	return childSlabIDs, childSizes, childFirstKeys
 }
 
+// SetPersistentSlabStorageTempSlabIndex sets temp slab index of storage.
+func SetPersistentSlabStorageTempSlabIndex(storage *PersistentSlabStorage, index uint64) {
+	storage.tempSlabIndex = index
+}
+
+func GetPersistentSlabStorageTempSlabIndex(storage *PersistentSlabStorage) uint64 {
+	return storage.tempSlabIndex
+}
+
 func GetMutableValueNotifierValueID(v Value) (ValueID, error) {
 	m, ok := v.(mutableValueNotifier)
 	if !ok {
@@ -207,3 +239,26 @@ func ComputeInlinedMapSlabByteSize(elementStorableByteSizes [][2]uint32) uint32
 
 	return slabSize
 }
+
+// ReverseMapCollisionElements reverses order of fully colliding elements
+// in root data slab of map, to simulate elements decoded from data
+// encoded before colliding elements were ordered by key.
+func ReverseMapCollisionElements(m *OrderedMap) {
+	var reverse func(elems elements)
+	reverse = func(elems elements) {
+		switch elems := elems.(type) {
+		case *hkeyElements:
+			for _, elem := range elems.elems {
+				if group, ok := elem.(*inlineCollisionGroup); ok {
+					reverse(group.elements)
+				}
+			}
+		case *singleElements:
+			slices.Reverse(elems.elems)
+		}
+	}
+
+	if dataSlab, ok := m.rootSlab().(*MapDataSlab); ok {
+		reverse(dataSlab.elements)
+	}
+}
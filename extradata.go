@@ -20,6 +20,7 @@ package atree
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"sort"
 	"sync"
@@ -36,7 +37,7 @@ type ExtraData interface {
 type InlinedExtraData struct {
 	extraData         []extraDataAndEncodedTypeInfo // Used to encode deduplicated ExtraData in order
 	compactMapTypeSet map[string]compactMapTypeInfo // Used to deduplicate compactMapExtraData by encoded TypeInfo + sorted field names
-	arrayExtraDataSet map[string]int                // Used to deduplicate arrayExtraData by encoded TypeInfo
+	arrayExtraDataSet map[string]int                // Used to deduplicate arrayExtraData by encoded TypeInfo + schema ID
 }
 
 type compactMapTypeInfo struct {
@@ -285,8 +286,8 @@ func (ied *InlinedExtraData) findDuplicateTypeInfo() ([]string, map[string]int)
 }
 
 // addArrayExtraData returns index of deduplicated array extra data.
-// Array extra data is deduplicated by array type info ID because array
-// extra data only contains type info.
+// Array extra data is deduplicated by array type info ID and schema ID
+// because array extra data only contains type info and schema ID.
 func (ied *InlinedExtraData) addArrayExtraData(data *ArrayExtraData) (int, error) {
 	encodedTypeInfo, err := getEncodedTypeInfo(data.TypeInfo)
 	if err != nil {
@@ -298,14 +299,21 @@ func (ied *InlinedExtraData) addArrayExtraData(data *ArrayExtraData) (int, error
 		ied.arrayExtraDataSet = make(map[string]int)
 	}
 
-	index, exist := ied.arrayExtraDataSet[encodedTypeInfo]
+	// Encoded type info is a complete CBOR data item, so appending
+	// schema ID to it doesn't create key of another type info.
+	arrayExtraDataID := encodedTypeInfo
+	if data.SchemaID != 0 {
+		arrayExtraDataID = string(binary.BigEndian.AppendUint64([]byte(encodedTypeInfo), data.SchemaID))
+	}
+
+	index, exist := ied.arrayExtraDataSet[arrayExtraDataID]
 	if exist {
 		return index, nil
 	}
 
 	index = len(ied.extraData)
 	ied.extraData = append(ied.extraData, extraDataAndEncodedTypeInfo{data, encodedTypeInfo})
-	ied.arrayExtraDataSet[encodedTypeInfo] = index
+	ied.arrayExtraDataSet[arrayExtraDataID] = index
 
 	return index, nil
 }
@@ -53,9 +53,11 @@ const (
 // Version and flag masks for the 1st byte of encoded slab.
 // Flags in this group are only for v1 and above.
 const (
-	maskVersion         byte = 0b1111_0000
-	maskHasNextSlabID   byte = 0b0000_0010 // This flag is only relevant for data slab.
-	maskHasInlinedSlabs byte = 0b0000_0001
+	maskVersion          byte = 0b1111_0000
+	maskHasNarrowDigests byte = 0b0000_1000 // This flag is only relevant for map data slab and root map metadata slab.
+	maskHasPrevSlabID    byte = 0b0000_0100 // This flag is only relevant for map data slab.
+	maskHasNextSlabID    byte = 0b0000_0010 // This flag is only relevant for data slab.
+	maskHasInlinedSlabs  byte = 0b0000_0001
 )
 
 // Flag masks for the 2nd byte of encoded slab.
@@ -206,6 +208,28 @@ func (h *head) setHasNextSlabID() {
 	h[0] |= maskHasNextSlabID
 }
 
+func (h *head) hasPrevSlabID() bool {
+	if h.version() == 0 {
+		return false
+	}
+	return h[0]&maskHasPrevSlabID > 0
+}
+
+func (h *head) setHasPrevSlabID() {
+	h[0] |= maskHasPrevSlabID
+}
+
+func (h *head) hasNarrowDigests() bool {
+	if h.version() == 0 {
+		return false
+	}
+	return h[0]&maskHasNarrowDigests > 0
+}
+
+func (h *head) setHasNarrowDigests() {
+	h[0] |= maskHasNarrowDigests
+}
+
 func (h head) getSlabType() slabType {
 	f := h[1]
 	// Extract 4th and 5th bits for slab type.
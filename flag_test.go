@@ -181,6 +181,64 @@ func TestFlagSetHasNextSlabIDV1(t *testing.T) {
 	}
 }
 
+func TestFlagHasPrevSlabID(t *testing.T) {
+	var h head
+	h[0] = 1 << 4 // v1
+
+	t.Run("has", func(t *testing.T) {
+		// Flags in the first byte
+		for i := range 32 {
+			h[0] |= byte(i)
+			h[0] |= maskHasPrevSlabID
+
+			// Flags in the second byte
+			for j := range 256 {
+				h[1] = byte(j)
+				require.True(t, h.hasPrevSlabID())
+			}
+		}
+	})
+
+	t.Run("doesn't have", func(t *testing.T) {
+		// Flags in the first byte
+		for i := range 32 {
+			h[0] |= byte(i)
+			h[0] &= ^maskHasPrevSlabID
+
+			// Flags in the second byte
+			for j := range 256 {
+				h[1] = byte(j)
+				require.False(t, h.hasPrevSlabID())
+			}
+		}
+	})
+
+	t.Run("v0", func(t *testing.T) {
+		var h head
+		h[0] = maskHasPrevSlabID
+
+		require.False(t, h.hasPrevSlabID())
+	})
+}
+
+func TestFlagSetHasPrevSlabIDV1(t *testing.T) {
+	var h head
+	h[0] = 1 << 4 // version 1
+
+	// Flags in the first byte
+	for i := range 32 {
+		h[0] |= byte(i)
+
+		// Flags in the second byte
+		for i := range 256 {
+			h[1] = byte(i)
+
+			h.setHasPrevSlabID()
+			require.True(t, h.hasPrevSlabID())
+		}
+	}
+}
+
 func TestFlagHasInlinedSlabs(t *testing.T) {
 	var h head
 	h[0] = 1 << 4 // v1
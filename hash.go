@@ -18,6 +18,8 @@ package atree
 
 import (
 	"encoding/binary"
+	"fmt"
+	"slices"
 	"sync"
 
 	"github.com/fxamacker/circlehash"
@@ -26,6 +28,60 @@ import (
 
 type HashInputProvider func(value Value, buffer []byte) ([]byte, error)
 
+// HashInputScratchSize is size of scratch buffer for hash input
+// provided by digesters.
+const HashInputScratchSize = 32
+
+// FixedSizeHashInputValue is Value with small fixed-size hash input,
+// such as integer value.  Digesters get hash input of FixedSizeHashInputValue
+// from FixedSizeHashInput instead of HashInputProvider (which can be nil),
+// so hash input is written to digester's scratch buffer without allocation.
+// FixedSizeHashInput must return the same hash input as HashInputProvider
+// used with the map, otherwise keys can't be found.
+type FixedSizeHashInputValue interface {
+	Value
+	// FixedSizeHashInput writes hash input to scratch and returns it.
+	FixedSizeHashInput(scratch *[HashInputScratchSize]byte) []byte
+}
+
+// RejectNaNKeys returns HashInputProvider which returns NaNKeyError
+// if isNaN returns true for key, and otherwise returns hash input
+// from hip.  It is recommended for maps with floating-point key types
+// that don't implement NaNValue, so NaN key is rejected by Set instead
+// of being stored as element which can't be found.
+//
+// hip must not be nil.  Returned HashInputProvider isn't called for keys
+// implementing FixedSizeHashInputValue, so values which can be NaN must
+// not implement FixedSizeHashInputValue.
+func RejectNaNKeys(hip HashInputProvider, isNaN func(Value) bool) HashInputProvider {
+	return func(value Value, scratch []byte) ([]byte, error) {
+		if isNaN(value) {
+			return nil, NewNaNKeyError(value)
+		}
+		return hip(value, scratch)
+	}
+}
+
+// getHashInput returns hash input of value written to scratch by
+// FixedSizeHashInputValue, or by hip if value isn't FixedSizeHashInputValue.
+func getHashInput(hip HashInputProvider, value Value, scratch *[HashInputScratchSize]byte) ([]byte, error) {
+	if v, ok := value.(FixedSizeHashInputValue); ok {
+		return v.FixedSizeHashInput(scratch), nil
+	}
+
+	if hip == nil {
+		return nil, NewUserError(fmt.Errorf("failed to generate hash input: HashInputProvider is nil and %T isn't FixedSizeHashInputValue", value))
+	}
+
+	msg, err := hip(value, scratch[:])
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by HashInputProvider callback.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to generate hash input")
+	}
+
+	return msg, nil
+}
+
 type Digest uint64
 
 type DigesterBuilder interface {
@@ -33,6 +89,15 @@ type DigesterBuilder interface {
 	Digest(HashInputProvider, Value) (Digester, error)
 }
 
+// SeededDigesterBuilder is a DigesterBuilder that can return its seed.
+// OrderedMap uses it to detect that digester builder was reseeded after
+// it was bound to the map (e.g. by another map sharing the builder),
+// so keys aren't digested with a seed different from map's seed.
+type SeededDigesterBuilder interface {
+	DigesterBuilder
+	Seed() (k0 uint64, k1 uint64)
+}
+
 type Digester interface {
 	// DigestPrefix returns digests before specified level.
 	// If level is 0, DigestPrefix returns nil.
@@ -52,12 +117,12 @@ type basicDigesterBuilder struct {
 	k1 uint64
 }
 
-var _ DigesterBuilder = &basicDigesterBuilder{}
+var _ SeededDigesterBuilder = &basicDigesterBuilder{}
 
 type basicDigester struct {
 	circleHash64 uint64
 	blake3Hash   [4]uint64
-	scratch      [32]byte
+	scratch      [HashInputScratchSize]byte
 	msg          []byte
 }
 
@@ -73,11 +138,20 @@ func getBasicDigester() *basicDigester {
 }
 
 func putDigester(e Digester) {
-	if _, ok := e.(*basicDigester); !ok {
-		return
+	switch e := e.(type) {
+	case *basicDigester:
+		e.Reset()
+		basicDigesterPool.Put(e)
+	case *integerKeyDigester:
+		e.Reset()
+		integerKeyDigesterPool.Put(e)
+	case *narrowDigester:
+		putDigester(e.Digester)
+	case *singleLevelDigester:
+		putDigester(e.Digester)
+		e.Digester = nil
+		singleLevelDigesterPool.Put(e)
 	}
-	e.Reset()
-	basicDigesterPool.Put(e)
 }
 
 var (
@@ -97,6 +171,10 @@ func (bdb *basicDigesterBuilder) SetSeed(k0 uint64, k1 uint64) {
 	bdb.k1 = k1
 }
 
+func (bdb *basicDigesterBuilder) Seed() (uint64, uint64) {
+	return bdb.k0, bdb.k1
+}
+
 func (bdb *basicDigesterBuilder) Digest(hip HashInputProvider, value Value) (Digester, error) {
 	if bdb.k0 == 0 {
 		return nil, NewHashSeedUninitializedError()
@@ -104,11 +182,11 @@ func (bdb *basicDigesterBuilder) Digest(hip HashInputProvider, value Value) (Dig
 
 	digester := getBasicDigester()
 
-	msg, err := hip(value, digester.scratch[:])
+	msg, err := getHashInput(hip, value, &digester.scratch)
 	if err != nil {
 		putDigester(digester)
-		// Wrap err as external error (if needed) because err is returned by HashInputProvider callback.
-		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to generate hash input")
+		// Don't need to wrap error as external error because err is already categorized by getHashInput().
+		return nil, err
 	}
 
 	digester.msg = msg
@@ -168,3 +246,286 @@ func (bd *basicDigester) Digest(level uint) (Digest, error) {
 func (bd *basicDigester) Levels() uint {
 	return 4
 }
+
+const (
+	// defaultDigesterBuilderID is ID of default digester builder and
+	// digester builders implemented by applications.
+	defaultDigesterBuilderID = 0
+
+	// integerKeyDigesterBuilderID is ID of IntegerKeyDigesterBuilder.
+	integerKeyDigesterBuilderID = 1
+)
+
+// copyDigesterBuilder returns a copy of digester builder provided by
+// this package, so each map seeds its own builder and maps sharing
+// digester builder (or callers reseeding it) don't affect each other.
+// Digester builders implemented by applications are returned as is.
+func copyDigesterBuilder(b DigesterBuilder) DigesterBuilder {
+	switch b := b.(type) {
+	case *basicDigesterBuilder:
+		c := *b
+		return &c
+	case *integerKeyDigesterBuilder:
+		c := *b
+		return &c
+	default:
+		return b
+	}
+}
+
+// digesterBuilderIDOf returns ID of digester builder kind, which is
+// stored in map extra data, so map created with one kind of digester
+// builder can't be used with another kind of digester builder.
+func digesterBuilderIDOf(b DigesterBuilder) uint64 {
+	if _, ok := b.(*integerKeyDigesterBuilder); ok {
+		return integerKeyDigesterBuilderID
+	}
+	return defaultDigesterBuilderID
+}
+
+// IntegerKeyToUint64 returns integer value of map key and true,
+// or false if key isn't an integer.
+type IntegerKeyToUint64 func(Value) (uint64, bool)
+
+type integerKeyDigesterBuilder struct {
+	k0          uint64
+	k1          uint64
+	keyToUint64 IntegerKeyToUint64
+}
+
+var _ SeededDigesterBuilder = &integerKeyDigesterBuilder{}
+
+// integerKeyDigester uses keyed bijective mix of integer key as level 0
+// digest, so distinct integer keys never collide at level 0.
+// Hash input is only created and hashed with blake3 if level 1 or
+// higher digest is requested on collision.
+type integerKeyDigester struct {
+	digest0    uint64
+	blake3Hash [4]uint64
+	scratch    [HashInputScratchSize]byte
+	hip        HashInputProvider
+	value      Value
+}
+
+// integerKeyDigesterPool caches unused integerKeyDigester objects for later reuse.
+var integerKeyDigesterPool = sync.Pool{
+	New: func() any {
+		return &integerKeyDigester{}
+	},
+}
+
+// NewIntegerKeyDigesterBuilder returns DigesterBuilder for maps keyed by integers.
+// Level 0 digest of integer key is a cheap keyed mix of key's integer value
+// returned by keyToUint64, instead of circlehash of key's hash input.
+// Keys that aren't integers use circlehash like the default digester builder.
+// Level 1 and higher digests are blake3 hash of key's hash input.
+//
+// Kind of digester builder is stored with the map, so map created with
+// IntegerKeyDigesterBuilder must be loaded with IntegerKeyDigesterBuilder,
+// and NewMapWithRootID and key operations return UserError otherwise.
+// Since nested maps are always loaded with the default digester builder,
+// IntegerKeyDigesterBuilder should only be used with maps that aren't
+// elements of other containers.
+func NewIntegerKeyDigesterBuilder(keyToUint64 IntegerKeyToUint64) DigesterBuilder {
+	return &integerKeyDigesterBuilder{keyToUint64: keyToUint64}
+}
+
+func (idb *integerKeyDigesterBuilder) SetSeed(k0 uint64, k1 uint64) {
+	idb.k0 = k0
+	idb.k1 = k1
+}
+
+func (idb *integerKeyDigesterBuilder) Seed() (uint64, uint64) {
+	return idb.k0, idb.k1
+}
+
+func (idb *integerKeyDigesterBuilder) Digest(hip HashInputProvider, value Value) (Digester, error) {
+	if idb.k0 == 0 {
+		return nil, NewHashSeedUninitializedError()
+	}
+
+	digester := integerKeyDigesterPool.Get().(*integerKeyDigester)
+	digester.hip = hip
+	digester.value = value
+
+	if n, ok := idb.keyToUint64(value); ok {
+		digester.digest0 = mixUint64(n ^ idb.k0)
+		return digester, nil
+	}
+
+	msg, err := getHashInput(hip, value, &digester.scratch)
+	if err != nil {
+		putDigester(digester)
+		// Don't need to wrap error as external error because err is already categorized by getHashInput().
+		return nil, err
+	}
+
+	digester.digest0 = circlehash.Hash64(msg, idb.k0)
+
+	return digester, nil
+}
+
+// mixUint64 is the bijective finalizer of splitmix64.
+func mixUint64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (id *integerKeyDigester) Reset() {
+	id.digest0 = 0
+	id.blake3Hash = emptyBlake3Hash
+	id.hip = nil
+	id.value = nil
+}
+
+func (id *integerKeyDigester) DigestPrefix(level uint) ([]Digest, error) {
+	if level > id.Levels() {
+		// level must be [0, id.Levels()] (inclusive) for prefix
+		return nil, NewHashLevelErrorf("cannot get digest < level %d: level must be [0, %d]", level, id.Levels())
+	}
+	var prefix []Digest
+	for i := range level {
+		d, err := id.Digest(i)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by integerKeyDigester.Digest().
+			return nil, err
+		}
+		prefix = append(prefix, d)
+	}
+	return prefix, nil
+}
+
+func (id *integerKeyDigester) Digest(level uint) (Digest, error) {
+	if level >= id.Levels() {
+		// level must be [0, id.Levels()) (not inclusive) for digest
+		return 0, NewHashLevelErrorf("cannot get digest at level %d: level must be [0, %d)", level, id.Levels())
+	}
+
+	switch level {
+	case 0:
+		return Digest(id.digest0), nil
+
+	case 1, 2, 3:
+		if id.blake3Hash == emptyBlake3Hash {
+			msg, err := getHashInput(id.hip, id.value, &id.scratch)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by getHashInput().
+				return 0, err
+			}
+			sum := blake3.Sum256(msg)
+			id.blake3Hash[0] = binary.BigEndian.Uint64(sum[:])
+			id.blake3Hash[1] = binary.BigEndian.Uint64(sum[8:])
+			id.blake3Hash[2] = binary.BigEndian.Uint64(sum[16:])
+			id.blake3Hash[3] = binary.BigEndian.Uint64(sum[24:])
+		}
+		return Digest(id.blake3Hash[level-1]), nil
+
+	default: // list mode
+		return 0, nil
+	}
+}
+
+func (id *integerKeyDigester) Levels() uint {
+	return 4
+}
+
+// narrowDigester truncates level 0 digest of underlying digester to
+// 32 bits for map created with WithNarrowDigests.  Digests at other
+// levels aren't changed.
+type narrowDigester struct {
+	Digester
+}
+
+var _ Digester = &narrowDigester{}
+
+func newNarrowDigester(d Digester) *narrowDigester {
+	return &narrowDigester{Digester: d}
+}
+
+func (nd *narrowDigester) DigestPrefix(level uint) ([]Digest, error) {
+	prefix, err := nd.Digester.DigestPrefix(level)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefix) > 0 {
+		// Clone prefix in case underlying digester reuses returned slice.
+		prefix = slices.Clone(prefix)
+		prefix[0] = narrowDigest(prefix[0])
+	}
+	return prefix, nil
+}
+
+func (nd *narrowDigester) Digest(level uint) (Digest, error) {
+	d, err := nd.Digester.Digest(level)
+	if err != nil {
+		return 0, err
+	}
+	if level == 0 {
+		return narrowDigest(d), nil
+	}
+	return d, nil
+}
+
+// narrowDigest returns low 32 bits of digest.
+func narrowDigest(d Digest) Digest {
+	return Digest(uint32(d))
+}
+
+// singleLevelDigester limits underlying digester to level 0 digest for map
+// created with WithSingleDigestLevel.  Colliding elements at level 0 are
+// stored in list mode at level 1, so digests at higher levels (blake3 for
+// default digester) are never computed.
+type singleLevelDigester struct {
+	Digester
+}
+
+var _ Digester = &singleLevelDigester{}
+
+// singleLevelDigesterPool caches unused singleLevelDigester objects for later reuse.
+var singleLevelDigesterPool = sync.Pool{
+	New: func() any {
+		return &singleLevelDigester{}
+	},
+}
+
+func newSingleLevelDigester(d Digester) *singleLevelDigester {
+	sd := singleLevelDigesterPool.Get().(*singleLevelDigester)
+	sd.Digester = d
+	return sd
+}
+
+func (sd *singleLevelDigester) DigestPrefix(level uint) ([]Digest, error) {
+	if level > sd.Levels() {
+		// level must be [0, sd.Levels()] (inclusive) for prefix
+		return nil, NewHashLevelErrorf("cannot get digest < level %d: level must be [0, %d]", level, sd.Levels())
+	}
+	return sd.Digester.DigestPrefix(level)
+}
+
+func (sd *singleLevelDigester) Digest(level uint) (Digest, error) {
+	if level >= sd.Levels() {
+		// level must be [0, sd.Levels()) (not inclusive) for digest
+		return 0, NewHashLevelErrorf("cannot get digest at level %d: level must be [0, %d)", level, sd.Levels())
+	}
+	return sd.Digester.Digest(level)
+}
+
+func (sd *singleLevelDigester) Levels() uint {
+	return 1
+}
+
+// newMapKeyDigester wraps digester created by map's digester builder
+// with digest width and levels recorded in map.
+func newMapKeyDigester(d Digester, narrowDigests bool, singleDigestLevel bool) Digester {
+	if narrowDigests {
+		d = newNarrowDigester(d)
+	}
+	if singleDigestLevel {
+		d = newSingleLevelDigester(d)
+	}
+	return d
+}
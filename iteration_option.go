@@ -0,0 +1,82 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import "time"
+
+// IterationOption configures Iterate and IterateReadOnly of Array and OrderedMap.
+type IterationOption func(*iterationConfig)
+
+// maxRetryBackoff is max wait time before retry, up to which backoff doubles.
+const maxRetryBackoff = 10 * time.Second
+
+type iterationConfig struct {
+	maxRetries int
+	backoff    time.Duration
+}
+
+// WithRetrieveRetries retries getting next element up to maxRetries times
+// if it fails with temporary error (see IsTemporaryError), such as slab
+// retrieve error classified as retryable by RetryableBaseStorage.
+// Wait time before the n-th retry is backoff * 2^(n-1), capped at 10 seconds
+// (backoff longer than that isn't shortened).  Iteration resumes from the
+// element which failed, so elements aren't skipped or repeated.
+// Errors returned by iteration callback aren't retried.
+// Retries are disabled by default.
+func WithRetrieveRetries(maxRetries int, backoff time.Duration) IterationOption {
+	return func(c *iterationConfig) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+func newIterationConfig(opts []IterationOption) iterationConfig {
+	var c iterationConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// shouldRetry returns true after waiting for backoff if operation which
+// failed with err should be retried.  retry is number of retries so far.
+func (c iterationConfig) shouldRetry(err error, retry int) bool {
+	if retry >= c.maxRetries || !IsTemporaryError(err) {
+		return false
+	}
+	time.Sleep(c.retryBackoff(retry))
+	return true
+}
+
+// retryBackoff returns wait time before retry after retry number of retries.
+// Backoff is doubled for each retry without overflow, up to maxRetryBackoff.
+func (c iterationConfig) retryBackoff(retry int) time.Duration {
+	if c.backoff <= 0 || c.backoff >= maxRetryBackoff {
+		return c.backoff
+	}
+
+	backoff := c.backoff
+	for range retry {
+		backoff *= 2
+		if backoff >= maxRetryBackoff {
+			return maxRetryBackoff
+		}
+	}
+	return backoff
+}
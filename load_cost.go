@@ -0,0 +1,107 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import "fmt"
+
+// EstimateLoadCost estimates number of slabs and total byte size of slabs
+// touched by full traversal of array or map with root slab rootID.
+// Instead of traversing the container, it only retrieves slabs on the path
+// from root slab to first data slab.  At each level, number and size of slabs are
+// extrapolated from child count and child header sizes of the sampled
+// metadata slab at the level above.
+// So estimate is exact for container with data slab as root, or with root
+// metadata slab referencing data slabs.
+// Nested containers and values stored in separate slabs (including external
+// collision groups) aren't included.
+func EstimateLoadCost(storage SlabStorage, rootID SlabID) (slabs int, bytes int, err error) {
+	slab, err := retrieveSlabForLoadCost(storage, rootID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by retrieveSlabForLoadCost().
+		return 0, 0, err
+	}
+
+	switch slab := slab.(type) {
+	case ArraySlab:
+		if slab.ExtraData() == nil {
+			return 0, 0, NewNotValueError(rootID)
+		}
+	case MapSlab:
+		if slab.ExtraData() == nil {
+			return 0, 0, NewNotValueError(rootID)
+		}
+	default:
+		return 0, 0, NewNotValueError(rootID)
+	}
+
+	slabs = 1
+	bytes = int(slab.ByteSize())
+
+	levelSlabCount := 1
+
+	for {
+		var firstChildID SlabID
+		var childCount int
+		var childSize int
+
+		switch slab := slab.(type) {
+		case *ArrayMetaDataSlab:
+			firstChildID = slab.childrenHeaders[0].slabID
+			childCount = len(slab.childrenHeaders)
+			for _, h := range slab.childrenHeaders {
+				childSize += int(h.size)
+			}
+
+		case *MapMetaDataSlab:
+			firstChildID = slab.childrenHeaders[0].slabID
+			childCount = len(slab.childrenHeaders)
+			for _, h := range slab.childrenHeaders {
+				childSize += int(h.size)
+			}
+
+		default:
+			// Reached data slab.
+			return slabs, bytes, nil
+		}
+
+		// Extrapolate number and size of slabs at child level
+		// from sampled metadata slab at this level.
+		bytes += levelSlabCount * childSize
+		levelSlabCount *= childCount
+		slabs += levelSlabCount
+
+		slab, err = retrieveSlabForLoadCost(storage, firstChildID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by retrieveSlabForLoadCost().
+			return 0, 0, err
+		}
+	}
+}
+
+func retrieveSlabForLoadCost(storage SlabStorage, id SlabID) (Slab, error) {
+	slab, found, err := storage.Retrieve(id)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+	}
+	if !found {
+		return nil, NewSlabNotFoundErrorf(id, "slab not found for load cost estimate")
+	}
+	return slab, nil
+}
@@ -19,9 +19,12 @@
 package atree
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/fxamacker/circlehash"
@@ -64,14 +67,156 @@ type OrderedMap struct {
 	// It is setup when child map is returned from parent's Get.  It is also setup when
 	// new child is added to parent through Set or Insert.
 	parentUpdater parentUpdater
+
+	// changeJournal records keys modified by Set and Remove after
+	// change journal is enabled by EnableChangeJournal.
+	// changeJournal is not stored physically and is only in memory.
+	changeJournal        []Value
+	changeJournalEnabled bool
+
+	// operationAutoCommit is true if storage is committed after each
+	// mutating operation (see WithOperationAutoCommit).
+	operationAutoCommit bool
+
+	// hashInputStabilityCheck is true if Set checks that
+	// HashInputProvider returns the same hash input for the key twice.
+	hashInputStabilityCheck bool
+
+	// keyReplacementOnSet is true if Set replaces stored key with
+	// incoming key when updating existing element.
+	keyReplacementOnSet bool
+
+	// modCount is incremented by successful mutating operations (Set, Insert,
+	// Remove, Swap, PopIterate, and Compact), so a failed operation doesn't
+	// invalidate iterators.  Updating child values through parent notification
+	// doesn't change modCount.  Read-only iterators return ConcurrentModificationError
+	// if modCount is changed after iterator is created.  modCount is only in memory.
+	modCount uint64
+
+	// structuralModCount is incremented by successful mutating operations
+	// which add or remove elements (Set of new key, Insert, Remove, PopIterate,
+	// and Compact).  Mutable iterators return ConcurrentModificationError if
+	// structuralModCount is changed, so existing elements can be updated during
+	// mutable iteration.  structuralModCount is only in memory.
+	structuralModCount uint64
+
+	// lazyRootID is slab ID of root slab which isn't retrieved yet.
+	// It is only set by NewMapWithRootIDLazy, and root is nil until
+	// root slab is retrieved by loadRoot on first use.
+	lazyRootID SlabID
+
+	// insertionOrder is array of keys in the order they are first set.
+	// It is only set by WithInsertionOrderIndex.
+	insertionOrder *Array
+
+	// insertionOrderPositions maps keys to their positions in insertionOrder.
+	// It is only in memory, and it is built on first use.
+	insertionOrderPositions *insertionOrderPositions
+
+	// readOnly is true if this map is created by NewMapWithRootIDReadOnly
+	// or is a child of read-only container.  Mutation functions of
+	// read-only map return ReadOnlyError without modifying storage.
+	readOnly bool
 }
 
 var _ Value = &OrderedMap{}
 var _ mutableValueNotifier = &OrderedMap{}
 
+// MapOption configures OrderedMap when it is created.
+type MapOption interface {
+	applyMapOption(m *OrderedMap)
+}
+
+type mapOptionFunc func(m *OrderedMap)
+
+func (f mapOptionFunc) applyMapOption(m *OrderedMap) {
+	f(m)
+}
+
+// WithHashInputStabilityCheck enables debug mode which gets hash input of
+// key twice on each Set, and returns HashError if hash inputs are different.
+// This catches nondeterministic HashInputProvider, which makes keys unfindable.
+// It is disabled by default for performance.
+func WithHashInputStabilityCheck() MapOption {
+	return mapOptionFunc(func(m *OrderedMap) {
+		m.hashInputStabilityCheck = true
+	})
+}
+
+// WithKeyReplacementOnSet sets whether Set replaces stored key with
+// incoming key when updating existing element.  Keys equal per
+// ValueComparator can have different encodings (e.g. different
+// representations of the same logical value).  If replace is false
+// (default), Set keeps the original stored key and only updates value.
+func WithKeyReplacementOnSet(replace bool) MapOption {
+	return mapOptionFunc(func(m *OrderedMap) {
+		m.keyReplacementOnSet = replace
+	})
+}
+
+// WithNarrowDigests returns option which makes NewMap and NewMapWithSeed
+// create map with 32-bit level 0 digests.  Each digest in map data slab is
+// encoded in 4 bytes instead of 8 bytes, so data slabs of maps with small
+// elements hold more elements.  The tradeoff is higher collision rate at
+// level 0, so it should only be used if higher collision rate is acceptable.
+// Digest width is stored in map, so this option is ignored when existing map
+// is loaded.  Map with narrow digests isn't inlined in parent container.
+func WithNarrowDigests() MapOption {
+	return narrowDigestsOption{}
+}
+
+type narrowDigestsOption struct{}
+
+func (narrowDigestsOption) applyMapOption(*OrderedMap) {}
+
+func narrowDigestsEnabled(opts []MapOption) bool {
+	for _, opt := range opts {
+		if _, ok := opt.(narrowDigestsOption); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WithSingleDigestLevel returns option which makes NewMap and NewMapWithSeed
+// create map with only level 0 digest (circlehash for default digester).
+// Elements colliding at level 0 are stored in a list and found by linear
+// scan, instead of being resolved by higher level digests (blake3 for
+// default digester).  This saves CPU of inserting elements into small maps,
+// at the cost of slower lookup of colliding elements, so it should only be
+// used if collisions are acceptable.  Digest levels are stored in map, so
+// this option is ignored when existing map is loaded.  Map with single
+// digest level isn't inlined in parent container.
+func WithSingleDigestLevel() MapOption {
+	return singleDigestLevelOption{}
+}
+
+type singleDigestLevelOption struct{}
+
+func (singleDigestLevelOption) applyMapOption(*OrderedMap) {}
+
+func singleDigestLevelEnabled(opts []MapOption) bool {
+	for _, opt := range opts {
+		if _, ok := opt.(singleDigestLevelOption); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // Create, copy, and load array
 
-func NewMap(storage SlabStorage, address Address, digestBuilder DigesterBuilder, typeInfo TypeInfo) (*OrderedMap, error) {
+func NewMap(
+	storage SlabStorage,
+	address Address,
+	digestBuilder DigesterBuilder,
+	typeInfo TypeInfo,
+	opts ...MapOption,
+) (*OrderedMap, error) {
+
+	if address == AddressUndefined && !tempAddressAllowed(opts) {
+		return nil, NewTempAddressError()
+	}
 
 	// Create root slab ID
 	sID, err := storage.GenerateSlabID(address)
@@ -94,37 +239,107 @@ func NewMap(storage SlabStorage, address Address, digestBuilder DigesterBuilder,
 	b := binary.LittleEndian.Uint64(sID.index[:])
 	k0 := circlehash.Hash64Uint64x2(a, b, uint64(0))
 
+	// Don't need to wrap error as external error because err is already categorized by newMapWithSlabID().
+	return newMapWithSlabID(storage, sID, digestBuilder, typeInfo, k0, opts...)
+}
+
+// NewMapWithSeed creates a new map with given seed instead of seed derived
+// from map's slab ID.  Maps created with the same seed and elements have the
+// same encoding, so different nodes can build byte-identical maps.
+// Like NewMap, only 64-bit seed is stored in map, and the other half
+// of 128-bit seed is a constant.  Seed must not be 0.
+func NewMapWithSeed(
+	storage SlabStorage,
+	address Address,
+	digestBuilder DigesterBuilder,
+	typeInfo TypeInfo,
+	seed uint64,
+	opts ...MapOption,
+) (*OrderedMap, error) {
+
+	if seed == 0 {
+		return nil, NewHashSeedUninitializedError()
+	}
+
+	if address == AddressUndefined && !tempAddressAllowed(opts) {
+		return nil, NewTempAddressError()
+	}
+
+	// Create root slab ID
+	sID, err := storage.GenerateSlabID(address)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to generate slab ID for address 0x%x", address))
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by newMapWithSlabID().
+	return newMapWithSlabID(storage, sID, digestBuilder, typeInfo, seed, opts...)
+}
+
+func newMapWithSlabID(
+	storage SlabStorage,
+	sID SlabID,
+	digestBuilder DigesterBuilder,
+	typeInfo TypeInfo,
+	k0 uint64,
+	opts ...MapOption,
+) (*OrderedMap, error) {
+
 	// To save storage space, only store 64-bits of the seed.
 	// Use a 64-bit const for the unstored half to create 128-bit seed.
 	k1 := typicalRandomConstant
 
+	// Seed map's own copy of digester builder.
+	digestBuilder = copyDigesterBuilder(digestBuilder)
 	digestBuilder.SetSeed(k0, k1)
 
+	narrowDigests := narrowDigestsEnabled(opts)
+
 	// Create extra data with type info and seed
-	extraData := &MapExtraData{TypeInfo: typeInfo, Seed: k0}
+	extraData := &MapExtraData{
+		TypeInfo:          typeInfo,
+		Seed:              k0,
+		narrowDigests:     narrowDigests,
+		singleDigestLevel: singleDigestLevelEnabled(opts),
+		digesterBuilderID: digesterBuilderIDOf(digestBuilder),
+	}
+
+	elements := newHkeyElements(0)
+	elements.narrow = narrowDigests
 
 	root := &MapDataSlab{
 		header: MapSlabHeader{
 			slabID: sID,
 			size:   mapRootDataSlabPrefixSize + hkeyElementsPrefixSize,
 		},
-		elements:  newHkeyElements(0),
+		elements:  elements,
 		extraData: extraData,
 	}
 
-	err = storeSlab(storage, root)
+	err := storeSlab(storage, root)
 	if err != nil {
 		return nil, err
 	}
 
-	return &OrderedMap{
+	m := &OrderedMap{
 		Storage:         storage,
 		root:            root,
 		digesterBuilder: digestBuilder,
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt.applyMapOption(m)
+	}
+
+	return m, nil
 }
 
-func NewMapWithRootID(storage SlabStorage, rootID SlabID, digestBuilder DigesterBuilder) (*OrderedMap, error) {
+func NewMapWithRootID(
+	storage SlabStorage,
+	rootID SlabID,
+	digestBuilder DigesterBuilder,
+	opts ...MapOption,
+) (*OrderedMap, error) {
 	if rootID == SlabIDUndefined {
 		return nil, NewSlabIDErrorf("cannot create OrderedMap from undefined slab ID")
 	}
@@ -140,13 +355,221 @@ func NewMapWithRootID(storage SlabStorage, rootID SlabID, digestBuilder Digester
 		return nil, NewNotValueError(rootID)
 	}
 
+	err = checkDigesterBuilder(rootID, extraData, digestBuilder)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by checkDigesterBuilder().
+		return nil, err
+	}
+
+	// Seed map's own copy of digester builder.
+	digestBuilder = copyDigesterBuilder(digestBuilder)
 	digestBuilder.SetSeed(extraData.Seed, typicalRandomConstant)
 
-	return &OrderedMap{
+	m := &OrderedMap{
 		Storage:         storage,
 		root:            root,
 		digesterBuilder: digestBuilder,
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt.applyMapOption(m)
+	}
+
+	return m, nil
+}
+
+// ResetWithRootID re-points m at map with given root slab ID, so one
+// OrderedMap object can be reused to process many maps without allocating
+// new OrderedMap for each map.  State of previous map (root, count, type
+// info, seed, parent, insertion order index, and change journal) is
+// replaced, while options (read-only, hash input stability check, key
+// replacement on Set, operation auto-commit, and change journal enabled)
+// are kept.  Change journal buffer is reused.  Iterators created before
+// reset are invalidated in the same way as by map modification.
+// If ResetWithRootID returns error, m isn't modified.
+func (m *OrderedMap) ResetWithRootID(
+	storage SlabStorage,
+	rootID SlabID,
+	digestBuilder DigesterBuilder,
+) error {
+	if rootID == SlabIDUndefined {
+		return NewSlabIDErrorf("cannot reset OrderedMap with undefined slab ID")
+	}
+
+	root, err := getMapSlab(storage, rootID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+		return err
+	}
+
+	extraData := root.ExtraData()
+	if extraData == nil {
+		return NewNotValueError(rootID)
+	}
+
+	err = checkDigesterBuilder(rootID, extraData, digestBuilder)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by checkDigesterBuilder().
+		return err
+	}
+
+	// Seed map's own copy of digester builder.
+	digestBuilder = copyDigesterBuilder(digestBuilder)
+	digestBuilder.SetSeed(extraData.Seed, typicalRandomConstant)
+
+	m.Storage = storage
+	m.root = root
+	m.digesterBuilder = digestBuilder
+	m.parentUpdater = nil
+	m.lazyRootID = SlabIDUndefined
+	m.insertionOrder = nil
+	m.insertionOrderPositions = nil
+
+	clear(m.changeJournal)
+	m.changeJournal = m.changeJournal[:0]
+
+	// Invalidate iterators of previous map.
+	m.modCount++
+	m.structuralModCount++
+
+	return nil
+}
+
+// NewMapWithRootIDReadOnly returns a read-only map with given root slab ID.
+// Set, Remove, PopIterate, and other mutation functions of read-only map
+// return ReadOnlyError immediately, so storage is never modified through
+// this map.  Child containers retrieved from read-only map are also read-only.
+func NewMapWithRootIDReadOnly(
+	storage SlabStorage,
+	rootID SlabID,
+	digestBuilder DigesterBuilder,
+	opts ...MapOption,
+) (*OrderedMap, error) {
+	m, err := NewMapWithRootID(storage, rootID, digestBuilder, opts...)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by NewMapWithRootID().
+		return nil, err
+	}
+
+	setReadOnly(m)
+
+	return m, nil
+}
+
+// setReadOnly makes given container read-only.
+func setReadOnly(v mutableValueNotifier) {
+	switch v := v.(type) {
+	case *OrderedMap:
+		v.readOnly = true
+		if v.insertionOrder != nil {
+			v.insertionOrder.readOnly = true
+		}
+	case *Array:
+		v.readOnly = true
+	}
+}
+
+// NewMapWithRootIDLazy returns a map with given root slab ID without
+// retrieving root slab from storage.  Root slab is retrieved on first use
+// of the map, so creating the map is free.
+//
+// Since root slab isn't retrieved here, errors from a missing or invalid
+// root slab are returned by first map operation that returns an error,
+// not by this function.  Methods without error result (e.g. Count, Type,
+// and Seed) panic with the error if root slab can't be retrieved, because
+// their zero values can't be distinguished from an empty map.  Call Load,
+// which returns the error instead, before calling them.
+func NewMapWithRootIDLazy(
+	storage SlabStorage,
+	rootID SlabID,
+	digestBuilder DigesterBuilder,
+	opts ...MapOption,
+) (*OrderedMap, error) {
+	if rootID == SlabIDUndefined {
+		return nil, NewSlabIDErrorf("cannot create OrderedMap from undefined slab ID")
+	}
+
+	m := &OrderedMap{
+		Storage:         storage,
+		digesterBuilder: copyDigesterBuilder(digestBuilder),
+		lazyRootID:      rootID,
+	}
+
+	for _, opt := range opts {
+		opt.applyMapOption(m)
+	}
+
+	return m, nil
+}
+
+// loadRoot retrieves root slab of map created by NewMapWithRootIDLazy
+// if root slab isn't retrieved yet.
+func (m *OrderedMap) loadRoot() error {
+	if m.root != nil {
+		return nil
+	}
+
+	root, err := getMapSlab(m.Storage, m.lazyRootID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+		return err
+	}
+
+	extraData := root.ExtraData()
+	if extraData == nil {
+		return NewNotValueError(m.lazyRootID)
+	}
+
+	err = checkDigesterBuilder(m.lazyRootID, extraData, m.digesterBuilder)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by checkDigesterBuilder().
+		return err
+	}
+
+	m.digesterBuilder.SetSeed(extraData.Seed, typicalRandomConstant)
+
+	m.root = root
+	m.lazyRootID = SlabIDUndefined
+
+	return nil
+}
+
+// Load retrieves root slab of map created by NewMapWithRootIDLazy, and
+// returns error if root slab can't be retrieved or is invalid.  It does
+// nothing if root slab is already retrieved.
+func (m *OrderedMap) Load() error {
+	// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+	return m.loadRoot()
+}
+
+// mustLoadRoot is like loadRoot, but panics with the error if root slab
+// can't be retrieved.  It is used by methods without error result, so
+// unreadable map isn't reported with zero values (e.g. as an empty map).
+func (m *OrderedMap) mustLoadRoot() {
+	if err := m.loadRoot(); err != nil {
+		panic(err)
+	}
+}
+
+// MapCountFromRoot returns element count of map with given root slab ID.
+// Since count is stored in extra data of root slab, only root slab is retrieved.
+func MapCountFromRoot(storage SlabStorage, rootID SlabID) (uint64, error) {
+	if rootID == SlabIDUndefined {
+		return 0, NewSlabIDErrorf("cannot get OrderedMap count from undefined slab ID")
+	}
+
+	root, err := getMapSlab(storage, rootID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+		return 0, err
+	}
+
+	extraData := root.ExtraData()
+	if extraData == nil {
+		return 0, NewNotValueError(rootID)
+	}
+
+	return extraData.Count, nil
 }
 
 type MapElementProvider func() (Value, Value, error)
@@ -154,8 +577,14 @@ type MapElementProvider func() (Value, Value, error)
 // NewMapFromBatchData returns a new map with elements provided by fn callback.
 // Provided seed must be the same seed used to create the original map.
 // And callback function must return elements in the same order as the original map.
+// Elements with the same digests at all levels are ordered by encoded key, so
+// they can be returned in any order and new map has the same order as the original map.
 // New map uses and stores the same seed as the original map.
 // This function should only be used for copying a map.
+// If it fails, slabs created by it (such as slabs of large keys and values)
+// are removed from storage, so failed batch build doesn't leave orphaned
+// slabs.  Slabs removed by it (such as slabs of child containers inlined
+// into new map) aren't restored.
 func NewMapFromBatchData(
 	storage SlabStorage,
 	address Address,
@@ -169,6 +598,64 @@ func NewMapFromBatchData(
 	*OrderedMap,
 	error,
 ) {
+	// Don't need to wrap error as external error because err is already categorized by buildMapFromBatchData().
+	return buildMapFromBatchData(storage, address, digesterBuilder, typeInfo, comparator, hip, seed, false, false, fn)
+}
+
+// buildMapFromBatchData is like NewMapFromBatchData, but new map uses
+// narrow digests if narrowDigests is true (see WithNarrowDigests), and
+// single digest level if singleDigestLevel is true (see WithSingleDigestLevel).
+func buildMapFromBatchData(
+	storage SlabStorage,
+	address Address,
+	digesterBuilder DigesterBuilder,
+	typeInfo TypeInfo,
+	comparator ValueComparator,
+	hip HashInputProvider,
+	seed uint64,
+	narrowDigests bool,
+	singleDigestLevel bool,
+	fn MapElementProvider,
+) (
+	*OrderedMap,
+	error,
+) {
+	tracker := newSlabDeltaCounter(storage)
+	defer tracker.stop()
+
+	m, err := newMapFromBatchData(tracker, address, digesterBuilder, typeInfo, comparator, hip, seed, narrowDigests, singleDigestLevel, fn)
+	if err != nil {
+		removeErr := tracker.removeCreatedSlabs()
+		if removeErr != nil {
+			// Return err joined with removeErr, so failure to remove
+			// created slabs doesn't hide why batch build failed.
+			// Don't need to wrap error as external error because err and removeErr are already categorized.
+			return nil, errors.Join(err, removeErr)
+		}
+		// Don't need to wrap error as external error because err is already categorized by newMapFromBatchData().
+		return nil, err
+	}
+
+	m.Storage = storage
+
+	return m, nil
+}
+
+func newMapFromBatchData(
+	storage SlabStorage,
+	address Address,
+	digesterBuilder DigesterBuilder,
+	typeInfo TypeInfo,
+	comparator ValueComparator,
+	hip HashInputProvider,
+	seed uint64,
+	narrowDigests bool,
+	singleDigestLevel bool,
+	fn MapElementProvider,
+) (
+	*OrderedMap,
+	error,
+) {
 
 	const defaultElementCountInSlab = 32
 
@@ -176,7 +663,8 @@ func NewMapFromBatchData(
 		return nil, NewHashSeedUninitializedError()
 	}
 
-	// Seed digester
+	// Seed map's own copy of digester builder.
+	digesterBuilder = copyDigesterBuilder(digesterBuilder)
 	digesterBuilder.SetSeed(seed, typicalRandomConstant)
 
 	var slabs []MapSlab
@@ -187,17 +675,24 @@ func NewMapFromBatchData(
 		return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to generate slab ID for address 0x%x", address))
 	}
 
-	elements := &hkeyElements{
-		level: 0,
-		size:  hkeyElementsPrefixSize,
-		hkeys: make([]Digest, 0, defaultElementCountInSlab),
-		elems: make([]element, 0, defaultElementCountInSlab),
+	newElements := func() *hkeyElements {
+		return &hkeyElements{
+			level:  0,
+			size:   hkeyElementsPrefixSize,
+			hkeys:  make([]Digest, 0, defaultElementCountInSlab),
+			elems:  make([]element, 0, defaultElementCountInSlab),
+			narrow: narrowDigests,
+		}
 	}
 
+	elements := newElements()
+
 	count := uint64(0)
 
 	var prevHkey Digest
 
+	var prevID SlabID
+
 	// Appends all elements
 	for {
 		key, value, err := fn()
@@ -215,6 +710,8 @@ func NewMapFromBatchData(
 			return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to create map key digester")
 		}
 
+		digester = newMapKeyDigester(digester, narrowDigests, singleDigestLevel)
+
 		hkey, err := digester.Digest(0)
 		if err != nil {
 			// Wrap err as external error (if needed) because err is returned by Digester interface.
@@ -234,6 +731,17 @@ func NewMapFromBatchData(
 			prevElem := elements.elems[lastElementIndex]
 			prevElemSize := prevElem.Size()
 
+			// Check duplicate key before modifying element.
+			_, _, err = prevElem.Get(storage, digester, 0, hkey, comparator, key)
+			if err == nil {
+				return nil, NewDuplicateKeyError(key)
+			}
+			var knf *KeyNotFoundError
+			if !errors.As(err, &knf) {
+				// Don't need to wrap error as external error because err is already categorized by element.Get().
+				return nil, err
+			}
+
 			elem, _, existingMapValueStorable, err := prevElem.Set(storage, address, digesterBuilder, digester, 0, hkey, comparator, hip, key, value)
 			if err != nil {
 				// Don't need to wrap error as external error because err is already categorized by element.Set().
@@ -264,8 +772,8 @@ func NewMapFromBatchData(
 		}
 
 		// Finalize data slab
-		currentSlabSize := mapDataSlabPrefixSize + elements.Size()
-		newElementSize := digestSize + elem.Size()
+		currentSlabSize := nonRootMapDataSlabPrefixSize() + elements.Size()
+		newElementSize := elements.hkeySize() + elem.Size()
 		if currentSlabSize >= uint32(targetThreshold) ||
 			currentSlabSize+newElementSize > uint32(maxThreshold) {
 
@@ -280,31 +788,30 @@ func NewMapFromBatchData(
 			dataSlab := &MapDataSlab{
 				header: MapSlabHeader{
 					slabID:   id,
-					size:     mapDataSlabPrefixSize + elements.Size(),
+					size:     nonRootMapDataSlabPrefixSize() + elements.Size(),
 					firstKey: elements.firstKey(),
 				},
 				elements: elements,
 				next:     nextID,
+				prev:     prevID,
 			}
 
 			// Append data slab to dataSlabs
 			slabs = append(slabs, dataSlab)
 
 			// Save id
+			if mapDataSlabPrevSlabIDEnabled {
+				prevID = id
+			}
 			id = nextID
 
 			// Create new elements for next data slab
-			elements = &hkeyElements{
-				level: 0,
-				size:  hkeyElementsPrefixSize,
-				hkeys: make([]Digest, 0, defaultElementCountInSlab),
-				elems: make([]element, 0, defaultElementCountInSlab),
-			}
+			elements = newElements()
 		}
 
 		elements.hkeys = append(elements.hkeys, hkey)
 		elements.elems = append(elements.elems, elem)
-		elements.size += digestSize + elem.Size()
+		elements.size += newElementSize
 
 		prevHkey = hkey
 
@@ -315,15 +822,54 @@ func NewMapFromBatchData(
 	dataSlab := &MapDataSlab{
 		header: MapSlabHeader{
 			slabID:   id,
-			size:     mapDataSlabPrefixSize + elements.Size(),
+			size:     nonRootMapDataSlabPrefixSize() + elements.Size(),
 			firstKey: elements.firstKey(),
 		},
 		elements: elements,
+		prev:     prevID,
 	}
 
 	// Append last data slab to slabs
 	slabs = append(slabs, dataSlab)
 
+	root, err := newMapSlabTree(storage, address, slabs)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by newMapSlabTree().
+		return nil, err
+	}
+
+	extraData := &MapExtraData{
+		TypeInfo:          typeInfo,
+		Count:             count,
+		Seed:              seed,
+		narrowDigests:     narrowDigests,
+		singleDigestLevel: singleDigestLevel,
+		digesterBuilderID: digesterBuilderIDOf(digesterBuilder),
+	}
+
+	// Set extra data in root
+	root.SetExtraData(extraData)
+
+	// Store root
+	err = storeSlab(storage, root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderedMap{
+		Storage:         storage,
+		root:            root,
+		digesterBuilder: digesterBuilder,
+	}, nil
+}
+
+// newMapSlabTree returns root slab of map slab tree built from data slabs,
+// which are linked and sorted by digest.  It rebalances last slab of each
+// level, creates metadata slabs, and stores all non-root slabs in storage.
+// Caller is responsible for setting extra data in root and storing root.
+func newMapSlabTree(storage SlabStorage, address Address, slabs []MapSlab) (MapSlab, error) {
+	var err error
+
 	for len(slabs) > 1 {
 
 		lastSlab := slabs[len(slabs)-1]
@@ -387,25 +933,10 @@ func NewMapFromBatchData(
 
 	// root is data slab, adjust its size
 	if dataSlab, ok := root.(*MapDataSlab); ok {
-		dataSlab.header.size = dataSlab.header.size - mapDataSlabPrefixSize + mapRootDataSlabPrefixSize
-	}
-
-	extraData := &MapExtraData{TypeInfo: typeInfo, Count: count, Seed: seed}
-
-	// Set extra data in root
-	root.SetExtraData(extraData)
-
-	// Store root
-	err = storeSlab(storage, root)
-	if err != nil {
-		return nil, err
+		dataSlab.header.size = dataSlab.header.size - nonRootMapDataSlabPrefixSize() + mapRootDataSlabPrefixSize
 	}
 
-	return &OrderedMap{
-		Storage:         storage,
-		root:            root,
-		digesterBuilder: digesterBuilder,
-	}, nil
+	return root, nil
 }
 
 // nextLevelMapSlabs returns next level meta data slabs from slabs.
@@ -483,6 +1014,19 @@ func nextLevelMapSlabs(storage SlabStorage, address Address, slabs []MapSlab) ([
 // Map operations (has, get, set, remove, and pop iterate)
 
 func (m *OrderedMap) Has(comparator ValueComparator, hip HashInputProvider, key Value) (bool, error) {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return false, err
+	}
+
+	if m.isEmpty() {
+		if err := m.checkKeyDigest(hip, key); err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkKeyDigest().
+			return false, err
+		}
+		return false, nil
+	}
+
 	_, _, err := m.get(comparator, hip, key)
 	if err != nil {
 		var knf *KeyNotFoundError
@@ -496,6 +1040,18 @@ func (m *OrderedMap) Has(comparator ValueComparator, hip HashInputProvider, key
 }
 
 func (m *OrderedMap) Get(comparator ValueComparator, hip HashInputProvider, key Value) (Value, error) {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return nil, err
+	}
+
+	if m.isEmpty() {
+		if err := m.checkKeyDigest(hip, key); err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkKeyDigest().
+			return nil, err
+		}
+		return nil, NewKeyNotFoundError(key)
+	}
 
 	keyStorable, valueStorable, err := m.get(comparator, hip, key)
 	if err != nil {
@@ -517,12 +1073,121 @@ func (m *OrderedMap) Get(comparator ValueComparator, hip HashInputProvider, key
 	return v, nil
 }
 
-func (m *OrderedMap) get(comparator ValueComparator, hip HashInputProvider, key Value) (Storable, Storable, error) {
+// GetContainer returns nested container stored as value of given key.
+// Either returned OrderedMap or Array is non-nil, depending on container
+// type of value.  Value wrapped by WrapperValue is unwrapped.
+// Returned container is bound to the same storage as map m, and
+// it notifies map m when it is modified (the same as value returned by Get).
+// It returns KeyNotFoundError if key doesn't exist, and NotContainerError
+// if value isn't a container.
+func (m *OrderedMap) GetContainer(comparator ValueComparator, hip HashInputProvider, key Value) (*OrderedMap, *Array, error) {
+	v, err := m.Get(comparator, hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.Get().
+		return nil, nil, err
+	}
+
+	unwrapped, _ := unwrapValue(v)
+
+	switch unwrapped := unwrapped.(type) {
+	case *OrderedMap:
+		return unwrapped, nil, nil
+	case *Array:
+		return nil, unwrapped, nil
+	default:
+		return nil, nil, NewNotContainerError(key, v)
+	}
+}
+
+// checkDigesterBuilder returns UserError if digestBuilder isn't the same
+// kind of digester builder map with extraData is created with, because
+// keys digested by another kind of digester builder can't be found in map.
+func checkDigesterBuilder(id SlabID, extraData *MapExtraData, digestBuilder DigesterBuilder) error {
+	builderID := digesterBuilderIDOf(digestBuilder)
+	if builderID != extraData.digesterBuilderID {
+		return NewUserError(
+			fmt.Errorf(
+				"map %s is created with digester builder ID %d, but it is used with digester builder %T (ID %d)",
+				id,
+				extraData.digesterBuilderID,
+				digestBuilder,
+				builderID,
+			))
+	}
+	return nil
+}
+
+// digestKey returns digester of given key.  Map seeds its own copy of
+// digester builder provided by this package, so reseeding or sharing
+// caller's builder doesn't affect the map.  Digester builders implemented
+// by applications can't be copied, so it returns HashError if builder's
+// seed doesn't match map's seed (e.g. builder is shared by maps with
+// different seeds), because keys digested with a different seed can't
+// be found in the map.
+func (m *OrderedMap) digestKey(hip HashInputProvider, key Value) (Digester, error) {
+	// Nested maps are created with default digester builder, so
+	// digester builder is checked again before key is digested.
+	err := checkDigesterBuilder(m.SlabID(), m.root.ExtraData(), m.digesterBuilder)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by checkDigesterBuilder().
+		return nil, err
+	}
+
+	if b, ok := m.digesterBuilder.(SeededDigesterBuilder); ok {
+		seed := m.root.ExtraData().Seed
+
+		k0, k1 := b.Seed()
+		if k0 != seed || k1 != typicalRandomConstant {
+			return nil, NewHashError(
+				fmt.Errorf(
+					"digester builder seed (%d, %d) doesn't match map seed (%d, %d)",
+					k0,
+					k1,
+					seed,
+					typicalRandomConstant,
+				))
+		}
+	}
 
 	keyDigest, err := m.digesterBuilder.Digest(hip, key)
 	if err != nil {
 		// Wrap err as external error (if needed) because err is returned by DigesterBuilder interface.
-		return nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to create map key digester")
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to create map key digester")
+	}
+
+	extraData := m.root.ExtraData()
+
+	return newMapKeyDigester(keyDigest, extraData.narrowDigests, extraData.singleDigestLevel), nil
+}
+
+// checkKeyDigest digests key without looking it up, so empty map
+// reports the same hash input and digester errors as non-empty map
+// without loading any slab.
+func (m *OrderedMap) checkKeyDigest(hip HashInputProvider, key Value) error {
+	keyDigest, err := m.digestKey(hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+		return err
+	}
+	defer putDigester(keyDigest)
+
+	level := uint(0)
+
+	_, err = keyDigest.Digest(level)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Digesert interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to get map key digest at level %d", level))
+	}
+
+	return nil
+}
+
+func (m *OrderedMap) get(comparator ValueComparator, hip HashInputProvider, key Value) (Storable, Storable, error) {
+
+	keyDigest, err := m.digestKey(hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+		return nil, nil, err
 	}
 	defer putDigester(keyDigest)
 
@@ -538,12 +1203,361 @@ func (m *OrderedMap) get(comparator ValueComparator, hip HashInputProvider, key
 	return m.root.Get(m.Storage, keyDigest, level, hkey, comparator, key)
 }
 
+// DataSlabIDForKey returns ID of data slab containing given key.
+// If key is in external collision group, ID of external collision group
+// slab is returned.  Returned bool is false if key doesn't exist in the map.
+// NOTE: returned slab ID is only stable until next mutation of the map
+// because elements can be moved to other slabs by split, merge, and rebalance.
+func (m *OrderedMap) DataSlabIDForKey(comparator ValueComparator, hip HashInputProvider, key Value) (SlabID, bool, error) {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return SlabIDUndefined, false, err
+	}
+
+	keyDigest, err := m.digestKey(hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+		return SlabIDUndefined, false, err
+	}
+	defer putDigester(keyDigest)
+
+	level := uint(0)
+
+	hkey, err := keyDigest.Digest(level)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Digesert interface.
+		return SlabIDUndefined, false, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to get map key digest at level %d", level))
+	}
+
+	keyNotFoundOrError := func(err error) (SlabID, bool, error) {
+		var knf *KeyNotFoundError
+		if errors.As(err, &knf) {
+			return SlabIDUndefined, false, nil
+		}
+		return SlabIDUndefined, false, err
+	}
+
+	// Find data slab containing hkey
+	dataSlab, _, _, err := m.getDataSlabByDigest(hkey, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.getDataSlabByDigest().
+		return keyNotFoundOrError(err)
+	}
+
+	id := dataSlab.SlabID()
+	elems := dataSlab.elements
+
+	// Find key in elements and collision groups
+	for {
+		var elem element
+
+		switch e := elems.(type) {
+		case *hkeyElements:
+			elem, _, err = e.getElement(keyDigest, level, hkey, key)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by hkeyElements.getElement().
+				return keyNotFoundOrError(err)
+			}
+
+		case *singleElements:
+			_, _, _, err = e.get(m.Storage, keyDigest, level, hkey, comparator, key)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by singleElements.get().
+				return keyNotFoundOrError(err)
+			}
+			return id, true, nil
+
+		default:
+			return SlabIDUndefined, false, NewUnreachableError()
+		}
+
+		switch e := elem.(type) {
+		case *singleElement:
+			_, _, err = e.Get(m.Storage, keyDigest, level, hkey, comparator, key)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by singleElement.Get().
+				return keyNotFoundOrError(err)
+			}
+			return id, true, nil
+
+		case *inlineCollisionGroup:
+			elems = e.elements
+
+		case *externalCollisionGroup:
+			collisionSlab, err := getMapSlab(m.Storage, e.slabID)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+				return SlabIDUndefined, false, err
+			}
+
+			collisionDataSlab, ok := collisionSlab.(*MapDataSlab)
+			if !ok {
+				return SlabIDUndefined, false, NewSlabDataErrorf("slab %s isn't MapDataSlab", e.slabID)
+			}
+
+			id = e.slabID
+			elems = collisionDataSlab.elements
+
+		default:
+			return SlabIDUndefined, false, NewUnreachableError()
+		}
+
+		// Adjust level and hkey for collision group
+		level++
+		if level > keyDigest.Levels() {
+			return SlabIDUndefined, false, NewHashLevelErrorf("collision group digest level is %d, want <= %d", level, keyDigest.Levels())
+		}
+		hkey, _ = keyDigest.Digest(level)
+	}
+}
+
+// getDataSlabByDigest returns data slab which can contain given hkey.
+// It also returns first digest of next data slab as exclusive upper bound
+// of digests in returned data slab.  Returned bool is false if returned
+// data slab is the last data slab and there isn't upper bound.
+func (m *OrderedMap) getDataSlabByDigest(hkey Digest, key Value) (*MapDataSlab, Digest, bool, error) {
+	var upperBound Digest
+	hasUpperBound := false
+
+	slab := m.root
+	for !slab.IsData() {
+		metaDataSlab, ok := slab.(*MapMetaDataSlab)
+		if !ok {
+			return nil, 0, false, NewSlabDataErrorf("slab %s isn't MapMetaDataSlab", slab.SlabID())
+		}
+
+		var index int
+		var err error
+		slab, index, err = metaDataSlab.getChildSlabByDigest(m.Storage, hkey, key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by MapMetaDataSlab.getChildSlabByDigest().
+			return nil, 0, false, err
+		}
+
+		// First digest of next sibling is tighter upper bound than upper bound from parent.
+		if index+1 < len(metaDataSlab.childrenHeaders) {
+			upperBound = metaDataSlab.childrenHeaders[index+1].firstKey
+			hasUpperBound = true
+		}
+	}
+
+	dataSlab, ok := slab.(*MapDataSlab)
+	if !ok {
+		return nil, 0, false, NewSlabDataErrorf("slab %s isn't MapDataSlab", slab.SlabID())
+	}
+
+	return dataSlab, upperBound, hasUpperBound, nil
+}
+
+// HasAll returns whether each key exists in the map, in the same order as keys.
+// Keys are sorted by digest before lookup, so each data slab is found once
+// for all keys in it.  Values aren't decoded.
+func (m *OrderedMap) HasAll(comparator ValueComparator, hip HashInputProvider, keys []Value) ([]bool, error) {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return nil, err
+	}
+
+	type keyDigestInfo struct {
+		index    int
+		digester Digester
+		hkey     Digest
+	}
+
+	const level = uint(0)
+
+	keyDigests := make([]keyDigestInfo, 0, len(keys))
+	defer func() {
+		for _, kd := range keyDigests {
+			putDigester(kd.digester)
+		}
+	}()
+
+	for i, key := range keys {
+		keyDigest, err := m.digestKey(hip, key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+			return nil, err
+		}
+
+		hkey, err := keyDigest.Digest(level)
+		if err != nil {
+			putDigester(keyDigest)
+			// Wrap err as external error (if needed) because err is returned by Digesert interface.
+			return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to get map key digest at level %d", level))
+		}
+
+		keyDigests = append(keyDigests, keyDigestInfo{index: i, digester: keyDigest, hkey: hkey})
+	}
+
+	slices.SortStableFunc(keyDigests, func(a, b keyDigestInfo) int {
+		return cmp.Compare(a.hkey, b.hkey)
+	})
+
+	result := make([]bool, len(keys))
+
+	var dataSlab *MapDataSlab
+	var upperBound Digest
+	var hasUpperBound bool
+
+	for _, kd := range keyDigests {
+		key := keys[kd.index]
+
+		// Find data slab if hkey isn't in current data slab.
+		if dataSlab == nil || (hasUpperBound && kd.hkey >= upperBound) {
+			var err error
+			dataSlab, upperBound, hasUpperBound, err = m.getDataSlabByDigest(kd.hkey, key)
+			if err != nil {
+				var knf *KeyNotFoundError
+				if errors.As(err, &knf) {
+					continue
+				}
+				// Don't need to wrap error as external error because err is already categorized by OrderedMap.getDataSlabByDigest().
+				return nil, err
+			}
+		}
+
+		_, _, err := dataSlab.Get(m.Storage, kd.digester, level, kd.hkey, comparator, key)
+		if err != nil {
+			var knf *KeyNotFoundError
+			if errors.As(err, &knf) {
+				continue
+			}
+			// Don't need to wrap error as external error because err is already categorized by MapDataSlab.Get().
+			return nil, err
+		}
+
+		result[kd.index] = true
+	}
+
+	return result, nil
+}
+
+// SetFromGoMap inserts all entries of goMap into the map.  Entries are
+// sorted by digest before insertion so that consecutive inserts go to the
+// same data slab, which makes insertion order deterministic even though
+// Go map iteration order is unspecified.  Entries with the same digest are
+// ordered by hash input.
+// SetFromGoMap doesn't update existing elements because overwritten values
+// can't be returned to caller.  It returns UserError without modifying the
+// map if any key already exists, and Set should be used instead.
+func (m *OrderedMap) SetFromGoMap(comparator ValueComparator, hip HashInputProvider, goMap map[Value]Value) error {
+	if m.readOnly {
+		return NewReadOnlyError(m.ValueID())
+	}
+
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
+
+	if len(goMap) == 0 {
+		return nil
+	}
+
+	type goMapEntry struct {
+		key       Value
+		value     Value
+		hkey      Digest
+		hashInput []byte
+	}
+
+	const level = uint(0)
+
+	entries := make([]goMapEntry, 0, len(goMap))
+	for key, value := range goMap {
+		if isNaNKey(key) {
+			return NewNaNKeyError(key)
+		}
+
+		keyDigest, err := m.digestKey(hip, key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+			return err
+		}
+
+		hkey, err := keyDigest.Digest(level)
+		putDigester(keyDigest)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Digesert interface.
+			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to get map key digest at level %d", level))
+		}
+
+		entries = append(entries, goMapEntry{key: key, value: value, hkey: hkey})
+	}
+
+	slices.SortFunc(entries, func(a, b goMapEntry) int {
+		return cmp.Compare(a.hkey, b.hkey)
+	})
+
+	// Order entries with the same digest by hash input, so that
+	// insertion order doesn't depend on Go map iteration order.
+	for start := 0; start < len(entries); {
+		end := start + 1
+		for end < len(entries) && entries[end].hkey == entries[start].hkey {
+			end++
+		}
+
+		if end-start > 1 {
+			for i := start; i < end; i++ {
+				hashInput, err := hip(entries[i].key, nil)
+				if err != nil {
+					// Wrap err as external error (if needed) because err is returned by HashInputProvider callback.
+					return wrapErrorfAsExternalErrorIfNeeded(err, "failed to get hash input")
+				}
+				// Clone hash input in case hip reuses returned buffer.
+				entries[i].hashInput = bytes.Clone(hashInput)
+			}
+
+			slices.SortFunc(entries[start:end], func(a, b goMapEntry) int {
+				return bytes.Compare(a.hashInput, b.hashInput)
+			})
+		}
+
+		start = end
+	}
+
+	if m.Count() > 0 {
+		keys := make([]Value, len(entries))
+		for i, e := range entries {
+			keys[i] = e.key
+		}
+
+		exists, err := m.HasAll(comparator, hip, keys)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.HasAll().
+			return err
+		}
+
+		for i, found := range exists {
+			if found {
+				return NewUserError(fmt.Errorf("key %s already exists in map", keys[i]))
+			}
+		}
+	}
+
+	for _, e := range entries {
+		existingStorable, err := m.Set(comparator, hip, e.key, e.value)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.Set().
+			return err
+		}
+
+		if existingStorable != nil {
+			// Distinct Go map keys are equal by comparator.
+			return NewUserError(fmt.Errorf("key %s is duplicate in input map", e.key))
+		}
+	}
+
+	return nil
+}
+
 func (m *OrderedMap) getElementAndNextKey(comparator ValueComparator, hip HashInputProvider, key Value) (Value, Value, Value, error) {
 
-	keyDigest, err := m.digesterBuilder.Digest(hip, key)
+	keyDigest, err := m.digestKey(hip, key)
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by DigesterBuilder interface.
-		return nil, nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to create map key digester")
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+		return nil, nil, nil, err
 	}
 	defer putDigester(keyDigest)
 
@@ -591,10 +1605,10 @@ func (m *OrderedMap) getElementAndNextKey(comparator ValueComparator, hip HashIn
 
 func (m *OrderedMap) getNextKey(comparator ValueComparator, hip HashInputProvider, key Value) (Value, error) {
 
-	keyDigest, err := m.digesterBuilder.Digest(hip, key)
+	keyDigest, err := m.digestKey(hip, key)
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by DigesterBuilder interface.
-		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to create map key digester")
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+		return nil, err
 	}
 	defer putDigester(keyDigest)
 
@@ -625,702 +1639,2086 @@ func (m *OrderedMap) getNextKey(comparator ValueComparator, hip HashInputProvide
 }
 
 func (m *OrderedMap) Set(comparator ValueComparator, hip HashInputProvider, key Value, value Value) (Storable, error) {
-	storable, err := m.set(comparator, hip, key, value)
-	if err != nil {
-		return nil, err
+	// Don't need to wrap error as external error because err is already categorized by OrderedMap.setWithStorage().
+	return m.setWithStorage(m.Storage, m.insertionOrderStorage(), comparator, hip, key, value)
+}
+
+// setWithStorage is Set with slab operations of map performed through
+// storage, and slab operations of insertion order index performed through
+// insertionOrderStorage.  It is used to record slab operations (e.g. by
+// SetReturningSlabDelta) without changing storage of map and its index.
+func (m *OrderedMap) setWithStorage(
+	storage SlabStorage,
+	insertionOrderStorage SlabStorage,
+	comparator ValueComparator,
+	hip HashInputProvider,
+	key Value,
+	value Value,
+) (Storable, error) {
+	if m.readOnly {
+		return nil, NewReadOnlyError(m.ValueID())
 	}
 
-	// If overwritten storable is an inlined slab, uninline the slab and store it in storage.
-	// This is to prevent potential data loss because the overwritten inlined slab was not in
-	// storage and any future changes to it would have been lost.
+	if isNaNKey(key) {
+		return nil, NewNaNKeyError(key)
+	}
 
-	storable, _, _, err = uninlineStorableIfNeeded(m.Storage, storable)
-	if err != nil {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
 		return nil, err
 	}
 
-	return storable, nil
-}
-
-func (m *OrderedMap) set(comparator ValueComparator, hip HashInputProvider, key Value, value Value) (Storable, error) {
+	if m.Count() >= maxMapElementCount {
+		// Only existing element can be updated when map has max number of elements.
+		exists, err := m.Has(comparator, hip, key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.Has().
+			return nil, err
+		}
+		if !exists {
+			return nil, NewMaxMapSizeError(maxMapElementCount)
+		}
+	}
 
-	keyDigest, err := m.digesterBuilder.Digest(hip, key)
+	// Insertion order index is updated before map, and it is restored if map
+	// isn't updated, so the index and map are consistent when error is returned.
+	k, err := m.findInsertionOrder(comparator, hip, key)
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by DigesterBuilder interface.
-		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to create map key digester")
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.findInsertionOrder().
+		return nil, err
 	}
-	defer putDigester(keyDigest)
-
-	level := uint(0)
 
-	hkey, err := keyDigest.Digest(level)
+	replacedKeyStorable, err := m.setInsertionOrder(insertionOrderStorage, k, key)
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by Digesert interface.
-		return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to get map key digest at level %d", level))
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.setInsertionOrder().
+		return nil, err
 	}
 
-	keyStorable, existingMapValueStorable, err := m.root.Set(m.Storage, m.digesterBuilder, keyDigest, level, hkey, comparator, hip, key, value)
+	storable, replacedValueStorable, err := m.setWithKeyReplacement(storage, comparator, hip, key, value)
 	if err != nil {
-		// Don't need to wrap error as external error because err is already categorized by MapSlab.Set().
+		rollbackErr := m.rollbackSetInsertionOrder(insertionOrderStorage, k, replacedKeyStorable)
+		if rollbackErr != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.rollbackSetInsertionOrder().
+			return nil, rollbackErr
+		}
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.setWithKeyReplacement().
 		return nil, err
 	}
 
-	if existingMapValueStorable == nil {
-		m.root.ExtraData().incrementCount()
-	}
-
-	if !m.root.IsData() {
-		// Set root to its child slab if root has one child slab.
-		root := m.root.(*MapMetaDataSlab)
-		if len(root.childrenHeaders) == 1 {
-			err := m.promoteChildAsNewRoot(root.childrenHeaders[0].slabID)
-			if err != nil {
-				// Don't need to wrap error as external error because err is already categorized by OrderedMap.promoteChildAsNewRoot().
-				return nil, err
-			}
-		}
-	}
+	m.modCount++
 
-	if m.root.IsFull() {
-		err := m.splitRoot()
-		if err != nil {
-			// Don't need to wrap error as external error because err is already categorized by OrderedMap.splitRoot().
-			return nil, err
-		}
+	inserted := storable == nil && replacedValueStorable == nil
+	if m.insertionOrder != nil && inserted == k.found {
+		return nil, NewSlabDataErrorf("key is inconsistent between map %s and its insertion order index", m.ValueID())
 	}
 
-	// This map (m) is a parent to the new child (value), and this map
-	// can also be a child in another container.
-	//
-	// As a parent, this map needs to setup notification callback with
-	// the new child value, so it can be notified when child is modified.
-	//
-	// If this map is a child, it needs to notify its parent because its
-	// content (maybe also its size) is changed by this "Set" operation.
-
-	// If this map is a child, it notifies parent by invoking callback because
-	// this map is changed by setting new child.
-	err = m.notifyParentIfNeeded()
+	err = m.commitSetInsertionOrder(insertionOrderStorage, replacedKeyStorable)
 	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.commitSetInsertionOrder().
 		return nil, err
 	}
 
-	// As a parent, this map sets up notification callback with child value
-	// so this map can be notified when child value is modified.
-	//
-	// Setting up notification with new child value can happen at any time
-	// (either before or after this map notifies its parent) because
-	// setting up notification doesn't trigger any read/write ops on parent or child.
-	maxInlineSize := maxInlineMapValueSize(uint64(keyStorable.ByteSize()))
-	m.setCallbackWithChild(comparator, hip, key, value, maxInlineSize)
-
-	return existingMapValueStorable, nil
-}
-
-func (m *OrderedMap) Remove(comparator ValueComparator, hip HashInputProvider, key Value) (Storable, Storable, error) {
-	keyStorable, valueStorable, err := m.remove(comparator, hip, key)
-	if err != nil {
-		return nil, nil, err
+	if replacedValueStorable != nil {
+		// Existing element is updated with replaced key.
+		storable = replacedValueStorable
+	} else if inserted {
+		// New element is inserted.
+		m.structuralModCount++
 	}
 
 	// If overwritten storable is an inlined slab, uninline the slab and store it in storage.
 	// This is to prevent potential data loss because the overwritten inlined slab was not in
 	// storage and any future changes to it would have been lost.
 
-	keyStorable, _, _, err = uninlineStorableIfNeeded(m.Storage, keyStorable)
+	storable, _, _, err = uninlineStorableIfNeeded(storage, storable)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	valueStorable, _, _, err = uninlineStorableIfNeeded(m.Storage, valueStorable)
+	m.recordChange(key)
+
+	err = m.commitOperationIfNeeded()
 	if err != nil {
-		return nil, nil, err
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.commitOperationIfNeeded().
+		return nil, err
 	}
 
-	return keyStorable, valueStorable, nil
+	return storable, nil
 }
 
-func (m *OrderedMap) remove(comparator ValueComparator, hip HashInputProvider, key Value) (Storable, Storable, error) {
+// setWithKeyReplacement sets key and value in map.  If map replaces keys
+// on set, existing element is removed first, and its value storable is
+// returned as replacedValueStorable.
+func (m *OrderedMap) setWithKeyReplacement(
+	storage SlabStorage,
+	comparator ValueComparator,
+	hip HashInputProvider,
+	key Value,
+	value Value,
+) (existingStorable Storable, replacedValueStorable Storable, err error) {
+	if m.keyReplacementOnSet {
+		replacedValueStorable, err = m.removeForKeyReplacement(storage, comparator, hip, key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.removeForKeyReplacement().
+			return nil, nil, err
+		}
+	}
 
-	keyDigest, err := m.digesterBuilder.Digest(hip, key)
+	existingStorable, err = m.set(storage, comparator, hip, key, value)
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by DigesterBuilder interface.
-		return nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to create map key digester")
+		return nil, nil, err
 	}
-	defer putDigester(keyDigest)
 
-	level := uint(0)
+	return existingStorable, replacedValueStorable, nil
+}
 
-	hkey, err := keyDigest.Digest(level)
-	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by Digesert interface.
-		return nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to create map key digest at level %d", level))
+// Insert inserts key and value into the map.  Unlike Set, Insert doesn't
+// overwrite existing element.  If key already exists, Insert returns
+// DuplicateKeyError (as user error) and the map isn't modified.
+// Key is digested and the map is traversed only once.
+func (m *OrderedMap) Insert(comparator ValueComparator, hip HashInputProvider, key Value, value Value) error {
+	if m.readOnly {
+		return NewReadOnlyError(m.ValueID())
 	}
 
-	k, v, err := m.root.Remove(m.Storage, keyDigest, level, hkey, comparator, key)
-	if err != nil {
-		// Don't need to wrap error as external error because err is already categorized by MapSlab.Remove().
-		return nil, nil, err
+	if isNaNKey(key) {
+		return NewNaNKeyError(key)
 	}
 
-	m.root.ExtraData().decrementCount()
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
 
-	if !m.root.IsData() {
-		// Set root to its child slab if root has one child slab.
-		root := m.root.(*MapMetaDataSlab)
-		if len(root.childrenHeaders) == 1 {
-			err := m.promoteChildAsNewRoot(root.childrenHeaders[0].slabID)
-			if err != nil {
-				// Don't need to wrap error as external error because err is already categorized by OrderedMap.promoteChildAsNewRoot().
-				return nil, nil, err
-			}
+	if m.Count() >= maxMapElementCount {
+		exists, err := m.Has(comparator, hip, key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.Has().
+			return err
+		}
+		if exists {
+			return NewDuplicateKeyUserError(key)
 		}
+		return NewMaxMapSizeError(maxMapElementCount)
 	}
 
-	if m.root.IsFull() {
-		err := m.splitRoot()
+	// insertComparator aborts set with DuplicateKeyError when key matches
+	// an existing key.  Key comparison happens before any element or slab
+	// is modified, so the map is unchanged when error is returned.
+	insertComparator := func(storage SlabStorage, value Value, otherStorable Storable) (bool, error) {
+		equal, err := comparator(storage, value, otherStorable)
 		if err != nil {
-			// Don't need to wrap error as external error because err is already categorized by OrderedMap.splitRoot().
-			return nil, nil, err
+			// Wrap err as external error (if needed) because err is returned by ValueComparator callback.
+			return false, wrapErrorfAsExternalErrorIfNeeded(err, "failed to compare keys")
+		}
+		if equal {
+			return false, NewDuplicateKeyUserError(key)
 		}
+		return false, nil
 	}
 
-	// If this map is a child, it notifies parent by invoking callback because
-	// this map is changed by removing element.
-	err = m.notifyParentIfNeeded()
+	// Insertion order index is updated before map, and it is restored if map
+	// isn't updated, so the index and map are consistent when error is returned.
+	k, err := m.findInsertionOrder(comparator, hip, key)
 	if err != nil {
-		return nil, nil, err
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.findInsertionOrder().
+		return err
+	}
+	if k.found {
+		return NewDuplicateKeyUserError(key)
 	}
 
-	return k, v, nil
-}
-
-type MapPopIterationFunc func(Storable, Storable)
-
-// PopIterate iterates and removes elements backward.
-// Each element is passed to MapPopIterationFunc callback before removal.
-func (m *OrderedMap) PopIterate(fn MapPopIterationFunc) error {
-
-	err := m.root.PopIterate(m.Storage, fn)
+	err = m.appendInsertionOrder(m.insertionOrderStorage(), k, key)
 	if err != nil {
-		// Don't need to wrap error as external error because err is already categorized by MapSlab.PopIterate().
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.appendInsertionOrder().
 		return err
 	}
 
-	rootID := m.root.SlabID()
-
-	// Set map count to 0 in extraData
-	extraData := m.root.ExtraData()
-	extraData.Count = 0
-
-	inlined := m.root.Inlined()
-
-	prefixSize := uint32(mapRootDataSlabPrefixSize)
-	if inlined {
-		prefixSize = uint32(inlinedMapDataSlabPrefixSize)
+	existingStorable, err := m.set(m.Storage, insertComparator, hip, key, value)
+	if err != nil {
+		rollbackErr := m.rollbackAppendInsertionOrder(k)
+		if rollbackErr != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.rollbackAppendInsertionOrder().
+			return rollbackErr
+		}
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.set().
+		return err
 	}
 
-	// Set root to empty data slab
-	m.root = &MapDataSlab{
-		header: MapSlabHeader{
-			slabID: rootID,
-			size:   prefixSize + hkeyElementsPrefixSize,
-		},
-		elements:  newHkeyElements(0),
-		extraData: extraData,
-		inlined:   inlined,
+	if existingStorable != nil {
+		// This shouldn't happen because insertComparator never matches existing key.
+		return NewUnreachableError()
 	}
 
-	if !m.Inlined() {
-		// Save root slab
-		err = storeSlab(m.Storage, m.root)
-		if err != nil {
-			return err
-		}
+	m.modCount++
+	m.structuralModCount++
+
+	m.recordChange(key)
+
+	err = m.commitOperationIfNeeded()
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.commitOperationIfNeeded().
+		return err
 	}
 
 	return nil
 }
 
-// Slab operations (split root, promote child slab to root)
-
-func (m *OrderedMap) splitRoot() error {
-
-	if m.root.IsData() {
-		// Adjust root data slab size before splitting
-		dataSlab := m.root.(*MapDataSlab)
-		dataSlab.header.size = dataSlab.header.size - mapRootDataSlabPrefixSize + mapDataSlabPrefixSize
+// Swap exchanges values of two existing keys.  It returns KeyNotFoundError
+// if either key doesn't exist, and the map isn't modified.  Swap is no-op
+// if keyA and keyB are equal.
+// Array and map values are moved like Set, so they are inlined or uninlined
+// depending on their new keys.  Other values are moved as storables without
+// being re-encoded, and max value size (see SetMaxValueSize) isn't checked again.
+// Array and map values of keyA and keyB previously returned by Get are
+// outdated after Swap, like values removed by Remove.  Get them again
+// by their new keys to modify them.
+func (m *OrderedMap) Swap(comparator ValueComparator, hip HashInputProvider, keyA Value, keyB Value) error {
+	if m.readOnly {
+		return NewReadOnlyError(m.ValueID())
 	}
 
-	// Get old root's extra data and reset it to nil in old root
-	extraData := m.root.RemoveExtraData()
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
 
-	// Save root node id
-	rootID := m.root.SlabID()
+	if m.isEmpty() {
+		return NewKeyNotFoundError(keyA)
+	}
 
-	// Assign a new slab ID to old root before splitting it.
-	sID, err := m.Storage.GenerateSlabID(m.Address())
+	keyStorableA, valueStorableA, err := m.get(comparator, hip, keyA)
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
-		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to generate slab ID for address 0x%x", m.Address()))
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.get().
+		return err
 	}
 
-	oldRoot := m.root
-	oldRoot.SetSlabID(sID)
+	equal, err := comparator(m.Storage, keyB, keyStorableA)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by ValueComparator callback.
+		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to compare keys")
+	}
+	if equal {
+		return nil
+	}
 
-	// Split old root
-	leftSlab, rightSlab, err := oldRoot.Split(m.Storage)
+	_, valueStorableB, err := m.get(comparator, hip, keyB)
 	if err != nil {
-		// Don't need to wrap error as external error because err is already categorized by MapSlab.Split().
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.get().
 		return err
 	}
 
-	left := leftSlab.(MapSlab)
-	right := rightSlab.(MapSlab)
+	valueA, err := swappedValue(m.Storage, valueStorableA)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by swappedValue().
+		return err
+	}
 
-	// Create new MapMetaDataSlab with the old root's slab ID
-	newRoot := &MapMetaDataSlab{
-		header: MapSlabHeader{
-			slabID:   rootID,
-			size:     mapMetaDataSlabPrefixSize + mapSlabHeaderSize*2,
-			firstKey: left.Header().firstKey,
-		},
-		childrenHeaders: []MapSlabHeader{left.Header(), right.Header()},
-		extraData:       extraData,
+	valueB, err := swappedValue(m.Storage, valueStorableB)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by swappedValue().
+		return err
 	}
 
-	m.root = newRoot
+	// Replaced storables aren't removed from storage because they are moved to the other key.
 
-	err = storeSlab(m.Storage, left)
+	_, err = m.set(m.Storage, comparator, hip, keyA, valueB)
 	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.set().
 		return err
 	}
 
-	err = storeSlab(m.Storage, right)
+	_, err = m.set(m.Storage, comparator, hip, keyB, valueA)
 	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.set().
 		return err
 	}
 
-	return storeSlab(m.Storage, m.root)
-}
+	m.modCount++
 
-func (m *OrderedMap) promoteChildAsNewRoot(childID SlabID) error {
+	m.recordChange(keyA)
+	m.recordChange(keyB)
 
-	child, err := getMapSlab(m.Storage, childID)
+	err = m.commitOperationIfNeeded()
 	if err != nil {
-		// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.commitOperationIfNeeded().
 		return err
 	}
 
-	if child.IsData() {
-		// Adjust data slab size before promoting non-root data slab to root
-		dataSlab := child.(*MapDataSlab)
-		dataSlab.header.size = dataSlab.header.size - mapDataSlabPrefixSize + mapRootDataSlabPrefixSize
+	return nil
+}
+
+// swappedValue returns value to be stored under the other key by Swap.
+// Array and map values are returned as stored values, so they can be
+// inlined or uninlined and notified like values set by Set.
+// Other values are returned as movedValue to reuse their storables.
+func swappedValue(storage SlabStorage, storable Storable) (Value, error) {
+	v, err := storable.StoredValue(storage)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Storable interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get storable's stored value")
 	}
 
-	extraData := m.root.RemoveExtraData()
+	unwrapped, _ := unwrapValue(v)
+	if _, ok := unwrapped.(mutableValueNotifier); ok {
+		return v, nil
+	}
 
-	rootID := m.root.SlabID()
+	return movedValue{storable: storable}, nil
+}
 
-	m.root = child
+// movedValue is a value moved from one element to another within
+// the same map, with its existing storable.
+type movedValue struct {
+	storable Storable
+}
 
-	m.root.SetSlabID(rootID)
+var _ Value = movedValue{}
 
-	m.root.SetExtraData(extraData)
+// Storable returns existing storable, or stores it in a new StorableSlab
+// if it is larger than maxInlineSize under new key.
+func (v movedValue) Storable(storage SlabStorage, address Address, maxInlineSize uint64) (Storable, error) {
+	if uint64(v.storable.ByteSize()) <= maxInlineSize {
+		return v.storable, nil
+	}
+	// Don't need to wrap error as external error because err is already categorized by NewStorableSlab().
+	return NewStorableSlab(storage, address, v.storable)
+}
 
-	err = storeSlab(m.Storage, m.root)
+// removeForKeyReplacement removes existing element with key so that
+// following set stores incoming key instead of original stored key.
+// It returns removed value storable, or nil if key doesn't exist.
+// Unlike Remove, modCount and insertion order index aren't changed
+// because Set treats key replacement as update of existing element.
+func (m *OrderedMap) removeForKeyReplacement(storage SlabStorage, comparator ValueComparator, hip HashInputProvider, key Value) (Storable, error) {
+	if m.isEmpty() {
+		return nil, nil
+	}
+
+	exists, err := m.Has(comparator, hip, key)
 	if err != nil {
-		return err
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.Has().
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
 	}
 
-	err = m.Storage.Remove(childID)
+	keyStorable, valueStorable, err := m.remove(storage, comparator, hip, key)
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
-		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", childID))
+		return nil, err
 	}
-	return nil
-}
 
-// mutableValue operations (parent updater callback, mutableElementIndex, etc)
+	// Remove slab of original key if it is stored externally
+	// because key storable isn't returned to caller of Set.
+	err = removeExternalKeyStorable(storage, keyStorable)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by removeExternalKeyStorable().
+		return nil, err
+	}
 
-func (m *OrderedMap) Inlined() bool {
-	return m.root.Inlined()
+	return valueStorable, nil
 }
 
-func (m *OrderedMap) Inlinable(maxInlineSize uint64) bool {
-	return m.root.Inlinable(maxInlineSize)
-}
+// checkHashInputStability returns HashError if hip returns
+// different hash inputs for the same key.  Key implementing
+// FixedSizeHashInputValue isn't checked because its hash input
+// isn't from hip.
+func checkHashInputStability(hip HashInputProvider, key Value) error {
+	if _, ok := key.(FixedSizeHashInputValue); ok || hip == nil {
+		return nil
+	}
 
-func (m *OrderedMap) setParentUpdater(f parentUpdater) {
-	m.parentUpdater = f
-}
+	input1, err := hip(key, nil)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by HashInputProvider callback.
+		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to get hash input")
+	}
 
-// setCallbackWithChild sets up callback function with child value (child)
-// so parent map (m) can be notified when child value is modified.
-func (m *OrderedMap) setCallbackWithChild(
-	comparator ValueComparator,
-	hip HashInputProvider,
-	key Value,
-	child Value,
-	maxInlineSize uint64,
-) {
-	// Unwrap child value if needed (e.g. interpreter.SomeValue)
-	unwrappedChild, wrapperSize := unwrapValue(child)
+	// Clone hash input in case hip reuses returned buffer.
+	input1 = bytes.Clone(input1)
 
-	c, ok := unwrappedChild.(mutableValueNotifier)
-	if !ok {
-		return
+	input2, err := hip(key, nil)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by HashInputProvider callback.
+		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to get hash input")
 	}
 
-	if maxInlineSize < wrapperSize {
-		maxInlineSize = 0
-	} else {
-		maxInlineSize -= wrapperSize
+	if !bytes.Equal(input1, input2) {
+		return NewHashError(fmt.Errorf("hash input of key %s isn't deterministic: %x != %x", key, input1, input2))
 	}
 
-	vid := c.ValueID()
+	return nil
+}
+
+func (m *OrderedMap) set(storage SlabStorage, comparator ValueComparator, hip HashInputProvider, key Value, value Value) (Storable, error) {
+
+	if m.hashInputStabilityCheck {
+		err := checkHashInputStability(hip, key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by checkHashInputStability().
+			return nil, err
+		}
+	}
+
+	keyDigest, err := m.digestKey(hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+		return nil, err
+	}
+	defer putDigester(keyDigest)
+
+	level := uint(0)
+
+	hkey, err := keyDigest.Digest(level)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Digesert interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to get map key digest at level %d", level))
+	}
+
+	keyStorable, existingMapValueStorable, err := m.root.Set(storage, m.digesterBuilder, keyDigest, level, hkey, comparator, hip, key, value)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by MapSlab.Set().
+		return nil, err
+	}
+
+	if existingMapValueStorable == nil {
+		m.root.ExtraData().incrementCount()
+	}
+
+	if !m.root.IsData() {
+		// Set root to its child slab if root has one child slab.
+		root := m.root.(*MapMetaDataSlab)
+		if len(root.childrenHeaders) == 1 {
+			err := m.promoteChildAsNewRoot(storage, root.childrenHeaders[0].slabID)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by OrderedMap.promoteChildAsNewRoot().
+				return nil, err
+			}
+		}
+	}
+
+	if m.root.IsFull() {
+		err := m.splitRoot(storage)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.splitRoot().
+			return nil, err
+		}
+	}
+
+	// This map (m) is a parent to the new child (value), and this map
+	// can also be a child in another container.
+	//
+	// As a parent, this map needs to setup notification callback with
+	// the new child value, so it can be notified when child is modified.
+	//
+	// If this map is a child, it needs to notify its parent because its
+	// content (maybe also its size) is changed by this "Set" operation.
+
+	// If this map is a child, it notifies parent by invoking callback because
+	// this map is changed by setting new child.
+	err = m.notifyParentIfNeeded()
+	if err != nil {
+		return nil, err
+	}
+
+	// As a parent, this map sets up notification callback with child value
+	// so this map can be notified when child value is modified.
+	//
+	// Setting up notification with new child value can happen at any time
+	// (either before or after this map notifies its parent) because
+	// setting up notification doesn't trigger any read/write ops on parent or child.
+	maxInlineSize := maxInlineMapValueSize(uint64(keyStorable.ByteSize()))
+	m.setCallbackWithChild(comparator, hip, key, value, maxInlineSize)
+
+	return existingMapValueStorable, nil
+}
+
+func (m *OrderedMap) Remove(comparator ValueComparator, hip HashInputProvider, key Value) (Storable, Storable, error) {
+	if m.readOnly {
+		return nil, nil, NewReadOnlyError(m.ValueID())
+	}
+
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return nil, nil, err
+	}
+
+	if m.isEmpty() {
+		if err := m.checkKeyDigest(hip, key); err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkKeyDigest().
+			return nil, nil, err
+		}
+		return nil, nil, NewKeyNotFoundError(key)
+	}
+
+	// Insertion order index is updated before map, and it is restored if map
+	// isn't updated, so the index and map are consistent when error is returned.
+	k, err := m.findInsertionOrder(comparator, hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.findInsertionOrder().
+		return nil, nil, err
+	}
+
+	removedKeyStorable, err := m.removeInsertionOrder(k)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.removeInsertionOrder().
+		return nil, nil, err
+	}
+
+	keyStorable, valueStorable, err := m.remove(m.Storage, comparator, hip, key)
+	if err != nil {
+		rollbackErr := m.rollbackRemoveInsertionOrder(k, removedKeyStorable)
+		if rollbackErr != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.rollbackRemoveInsertionOrder().
+			return nil, nil, rollbackErr
+		}
+		return nil, nil, err
+	}
+
+	m.modCount++
+	m.structuralModCount++
+
+	err = m.commitRemoveInsertionOrder(k, removedKeyStorable)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.commitRemoveInsertionOrder().
+		return nil, nil, err
+	}
+
+	// If overwritten storable is an inlined slab, uninline the slab and store it in storage.
+	// This is to prevent potential data loss because the overwritten inlined slab was not in
+	// storage and any future changes to it would have been lost.
+
+	keyStorable, _, _, err = uninlineStorableIfNeeded(m.Storage, keyStorable)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	valueStorable, _, _, err = uninlineStorableIfNeeded(m.Storage, valueStorable)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.recordChange(key)
+
+	err = m.commitOperationIfNeeded()
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.commitOperationIfNeeded().
+		return nil, nil, err
+	}
+
+	return keyStorable, valueStorable, nil
+}
+
+// RemoveIfPresent removes key and its value from map if key exists.
+// Unlike Remove, it returns removed=false without error if key doesn't
+// exist, and map isn't modified in that case (iterators remain valid).
+// If key is removed, removed key and value storables are returned
+// the same way as Remove.
+func (m *OrderedMap) RemoveIfPresent(
+	comparator ValueComparator,
+	hip HashInputProvider,
+	key Value,
+) (removed bool, keyStorable Storable, valueStorable Storable, err error) {
+	if m.readOnly {
+		return false, nil, nil, NewReadOnlyError(m.ValueID())
+	}
+
+	found, err := m.Has(comparator, hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.Has().
+		return false, nil, nil, err
+	}
+	if !found {
+		return false, nil, nil, nil
+	}
+
+	keyStorable, valueStorable, err = m.Remove(comparator, hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.Remove().
+		return false, nil, nil, err
+	}
+
+	return true, keyStorable, valueStorable, nil
+}
+
+// checkModCount returns ConcurrentModificationError if map is
+// modified after modCount is captured by iterator.
+func (m *OrderedMap) checkModCount(modCount uint64) error {
+	if m.modCount != modCount {
+		return NewConcurrentModificationError(m.ValueID())
+	}
+	return nil
+}
+
+// checkStructuralModCount returns ConcurrentModificationError if map
+// elements are added or removed after structuralModCount is captured
+// by mutable iterator.
+func (m *OrderedMap) checkStructuralModCount(structuralModCount uint64) error {
+	if m.structuralModCount != structuralModCount {
+		return NewConcurrentModificationError(m.ValueID())
+	}
+	return nil
+}
+
+// EnableChangeJournal enables recording keys modified by Set and Remove.
+// Recorded keys can be retrieved by DrainChangeJournal.
+// Change journal is separate from slab-level deltas in storage and
+// it is only kept in memory by this OrderedMap instance.
+func (m *OrderedMap) EnableChangeJournal() {
+	m.changeJournalEnabled = true
+}
+
+// DrainChangeJournal returns keys modified by Set and Remove since
+// change journal was enabled or last drained, and clears the journal.
+// A key is recorded once for each successful Set and Remove.
+func (m *OrderedMap) DrainChangeJournal() []Value {
+	keys := m.changeJournal
+	m.changeJournal = nil
+	return keys
+}
+
+func (m *OrderedMap) recordChange(key Value) {
+	if m.changeJournalEnabled {
+		m.changeJournal = append(m.changeJournal, key)
+	}
+}
+
+// WithOperationAutoCommit enables committing storage after each completed
+// mutating operation (Set, Remove, PopIterate, SetType, and SetSchemaID)
+// of this OrderedMap instance, so base storage reflects every operation
+// without explicit commit.  It returns m for chaining.
+//
+// Map storage must be PersistentSlabStorage with TransactionalBaseStorage,
+// so slabs modified by an operation are written to base storage in one
+// transaction and a crash can't leave an operation partially committed
+// in base storage.  Otherwise, UserError is returned.
+//
+// If commit fails, mutating operation returns error after the operation
+// is applied in memory.  Base storage isn't changed by the failed
+// transaction, and changes remain uncommitted in storage, so caller can
+// retry PersistentSlabStorage.Commit or discard storage.
+//
+// Auto-commit is a tradeoff of performance for crash-consistency at
+// operation granularity.  Each operation encodes and writes modified
+// slabs to base storage instead of batching changes of many operations,
+// so slabs modified repeatedly (e.g. root slab) are written repeatedly.
+// Also, commit writes all uncommitted changes in storage, including
+// changes made by other containers in the same storage.
+//
+// Auto-commit isn't inherited by child containers.  Operations on child
+// containers are committed by the next operation of a map with auto-commit.
+func (m *OrderedMap) WithOperationAutoCommit() (*OrderedMap, error) {
+	storage, ok := m.Storage.(*PersistentSlabStorage)
+	if !ok {
+		return nil, NewUserError(fmt.Errorf("failed to enable auto-commit for map %s: storage %T isn't PersistentSlabStorage", m.ValueID(), m.Storage))
+	}
+
+	if _, ok := storage.baseStorage.(TransactionalBaseStorage); !ok {
+		return nil, NewUserError(fmt.Errorf("failed to enable auto-commit for map %s: base storage %T isn't TransactionalBaseStorage", m.ValueID(), storage.baseStorage))
+	}
+
+	m.operationAutoCommit = true
+	return m, nil
+}
+
+func (m *OrderedMap) commitOperationIfNeeded() error {
+	if !m.operationAutoCommit {
+		return nil
+	}
+
+	storage, ok := m.Storage.(*PersistentSlabStorage)
+	if !ok {
+		return NewUserError(fmt.Errorf("failed to auto-commit map %s: storage %T isn't PersistentSlabStorage", m.ValueID(), m.Storage))
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.Commit().
+	return storage.Commit()
+}
+
+func (m *OrderedMap) remove(storage SlabStorage, comparator ValueComparator, hip HashInputProvider, key Value) (Storable, Storable, error) {
+
+	keyDigest, err := m.digestKey(hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+		return nil, nil, err
+	}
+	defer putDigester(keyDigest)
+
+	level := uint(0)
+
+	hkey, err := keyDigest.Digest(level)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Digesert interface.
+		return nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to create map key digest at level %d", level))
+	}
+
+	k, v, err := m.root.Remove(storage, keyDigest, level, hkey, comparator, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by MapSlab.Remove().
+		return nil, nil, err
+	}
+
+	m.root.ExtraData().decrementCount()
+
+	if !m.root.IsData() {
+		// Set root to its child slab if root has one child slab.
+		root := m.root.(*MapMetaDataSlab)
+		if len(root.childrenHeaders) == 1 {
+			err := m.promoteChildAsNewRoot(storage, root.childrenHeaders[0].slabID)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by OrderedMap.promoteChildAsNewRoot().
+				return nil, nil, err
+			}
+		}
+	}
+
+	if m.root.IsFull() {
+		err := m.splitRoot(storage)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.splitRoot().
+			return nil, nil, err
+		}
+	}
+
+	// If this map is a child, it notifies parent by invoking callback because
+	// this map is changed by removing element.
+	err = m.notifyParentIfNeeded()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return k, v, nil
+}
+
+type MapPopIterationFunc func(Storable, Storable)
+
+// PopIterate iterates and removes elements backward.
+// Each element is passed to MapPopIterationFunc callback before removal.
+func (m *OrderedMap) PopIterate(fn MapPopIterationFunc) error {
+	if m.readOnly {
+		return NewReadOnlyError(m.ValueID())
+	}
+
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
+
+	err := m.root.PopIterate(m.Storage, fn)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by MapSlab.PopIterate().
+		return err
+	}
+
+	m.modCount++
+	m.structuralModCount++
+
+	rootID := m.root.SlabID()
+
+	// Set map count to 0 in extraData
+	extraData := m.root.ExtraData()
+	extraData.Count = 0
+
+	inlined := m.root.Inlined()
+
+	prefixSize := uint32(mapRootDataSlabPrefixSize)
+	if inlined {
+		prefixSize = uint32(inlinedMapDataSlabPrefixSize)
+	}
+
+	elements := newHkeyElements(0)
+	elements.narrow = extraData.narrowDigests
+
+	// Set root to empty data slab
+	m.root = &MapDataSlab{
+		header: MapSlabHeader{
+			slabID: rootID,
+			size:   prefixSize + hkeyElementsPrefixSize,
+		},
+		elements:  elements,
+		extraData: extraData,
+		inlined:   inlined,
+	}
+
+	if !m.Inlined() {
+		// Save root slab
+		err = storeSlab(m.Storage, m.root)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = m.clearInsertionOrder()
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.clearInsertionOrder().
+		return err
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by OrderedMap.commitOperationIfNeeded().
+	return m.commitOperationIfNeeded()
+}
+
+// Compact rebuilds map slab tree from its elements, so a map with many
+// removed elements has the same minimal tree as a map built by
+// NewMapFromBatchData with the same elements.  Elements are moved to new
+// data slabs without rehashing keys, and slabs no longer used are removed
+// from storage.  Root slab ID, type info, seed, and count are preserved.
+// Compact is a no-op for map with root data slab.
+func (m *OrderedMap) Compact() error {
+	if m.readOnly {
+		return NewReadOnlyError(m.ValueID())
+	}
+
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
+
+	if m.root.IsData() {
+		return nil
+	}
+
+	rootID := m.root.SlabID()
+	address := rootID.address
+
+	oldSlabIDs, err := mapNonRootSlabIDs(m.Storage, m.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by mapNonRootSlabIDs().
+		return err
+	}
+
+	dataSlab, err := firstMapDataSlab(m.Storage, m.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstMapDataSlab().
+		return err
+	}
+
+	const defaultElementCountInSlab = 32
+
+	narrowDigests := m.root.ExtraData().narrowDigests
+
+	newElements := func() *hkeyElements {
+		return &hkeyElements{
+			level:  0,
+			size:   hkeyElementsPrefixSize,
+			hkeys:  make([]Digest, 0, defaultElementCountInSlab),
+			elems:  make([]element, 0, defaultElementCountInSlab),
+			narrow: narrowDigests,
+		}
+	}
+
+	var slabs []MapSlab
+
+	var prevID SlabID
+
+	id, err := m.Storage.GenerateSlabID(address)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to generate slab ID for address 0x%x", address))
+	}
+
+	elements := newElements()
+
+	// Append elements of all data slabs to new data slabs
+	for {
+		oldElements, ok := dataSlab.elements.(*hkeyElements)
+		if !ok {
+			return NewSlabDataErrorf("data slab %s has unexpected elements type %T", dataSlab.SlabID(), dataSlab.elements)
+		}
+
+		for i, elem := range oldElements.elems {
+
+			// Finalize data slab
+			currentSlabSize := nonRootMapDataSlabPrefixSize() + elements.Size()
+			newElementSize := elements.hkeySize() + elem.Size()
+			if len(elements.elems) > 0 &&
+				(currentSlabSize >= uint32(targetThreshold) ||
+					currentSlabSize+newElementSize > uint32(maxThreshold)) {
+
+				nextID, err := m.Storage.GenerateSlabID(address)
+				if err != nil {
+					// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+					return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to generate slab ID for address 0x%x", address))
+				}
+
+				slabs = append(slabs, &MapDataSlab{
+					header: MapSlabHeader{
+						slabID:   id,
+						size:     nonRootMapDataSlabPrefixSize() + elements.Size(),
+						firstKey: elements.firstKey(),
+					},
+					elements: elements,
+					next:     nextID,
+					prev:     prevID,
+				})
+
+				if mapDataSlabPrevSlabIDEnabled {
+					prevID = id
+				}
+				id = nextID
+
+				elements = newElements()
+			}
+
+			elements.hkeys = append(elements.hkeys, oldElements.hkeys[i])
+			elements.elems = append(elements.elems, elem)
+			elements.size += newElementSize
+		}
+
+		if dataSlab.next == SlabIDUndefined {
+			break
+		}
+
+		slab, err := getMapSlab(m.Storage, dataSlab.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return err
+		}
+
+		dataSlab, ok = slab.(*MapDataSlab)
+		if !ok {
+			return NewSlabDataErrorf("slab %s isn't MapDataSlab", slab.SlabID())
+		}
+	}
+
+	// Append last data slab
+	slabs = append(slabs, &MapDataSlab{
+		header: MapSlabHeader{
+			slabID:   id,
+			size:     nonRootMapDataSlabPrefixSize() + elements.Size(),
+			firstKey: elements.firstKey(),
+		},
+		elements: elements,
+		prev:     prevID,
+	})
+
+	root, err := newMapSlabTree(m.Storage, address, slabs)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by newMapSlabTree().
+		return err
+	}
+
+	// Preserve root slab ID and extra data
+	root.SetSlabID(rootID)
+	root.SetExtraData(m.root.ExtraData())
+
+	err = storeSlab(m.Storage, root)
+	if err != nil {
+		return err
+	}
+
+	m.root = root
+
+	m.modCount++
+	m.structuralModCount++
+
+	// Remove slabs of old slab tree
+	for _, id := range oldSlabIDs {
+		err = m.Storage.Remove(id)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", id))
+		}
+	}
+
+	return nil
+}
+
+// mapNonRootSlabIDs returns IDs of data and metadata slabs of map slab
+// tree excluding root slab.  External collision group slabs aren't included.
+func mapNonRootSlabIDs(storage SlabStorage, root MapSlab) ([]SlabID, error) {
+	var ids []SlabID
+
+	var collect func(slab MapSlab) error
+	collect = func(slab MapSlab) error {
+		metaSlab, ok := slab.(*MapMetaDataSlab)
+		if !ok {
+			return nil
+		}
+
+		for _, h := range metaSlab.childrenHeaders {
+			ids = append(ids, h.slabID)
+
+			child, err := getMapSlab(storage, h.slabID)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+				return err
+			}
+
+			err = collect(child)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err := collect(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Slab operations (split root, promote child slab to root)
+
+func (m *OrderedMap) splitRoot(storage SlabStorage) error {
+
+	if m.root.IsData() {
+		// Adjust root data slab size before splitting
+		dataSlab := m.root.(*MapDataSlab)
+		dataSlab.header.size = dataSlab.header.size - mapRootDataSlabPrefixSize + nonRootMapDataSlabPrefixSize()
+	}
+
+	// Get old root's extra data and reset it to nil in old root
+	extraData := m.root.RemoveExtraData()
+
+	// Save root node id
+	rootID := m.root.SlabID()
+
+	// Assign a new slab ID to old root before splitting it.
+	sID, err := storage.GenerateSlabID(m.Address())
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to generate slab ID for address 0x%x", m.Address()))
+	}
+
+	oldRoot := m.root
+	oldRoot.SetSlabID(sID)
+
+	// Split old root
+	leftSlab, rightSlab, err := oldRoot.Split(storage)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by MapSlab.Split().
+		return err
+	}
+
+	left := leftSlab.(MapSlab)
+	right := rightSlab.(MapSlab)
+
+	// Create new MapMetaDataSlab with the old root's slab ID
+	newRoot := &MapMetaDataSlab{
+		header: MapSlabHeader{
+			slabID:   rootID,
+			size:     mapMetaDataSlabPrefixSize + mapSlabHeaderSize*2,
+			firstKey: left.Header().firstKey,
+		},
+		childrenHeaders: []MapSlabHeader{left.Header(), right.Header()},
+		extraData:       extraData,
+	}
+
+	m.root = newRoot
+
+	err = storeSlab(storage, left)
+	if err != nil {
+		return err
+	}
+
+	err = storeSlab(storage, right)
+	if err != nil {
+		return err
+	}
+
+	return storeSlab(storage, m.root)
+}
+
+func (m *OrderedMap) promoteChildAsNewRoot(storage SlabStorage, childID SlabID) error {
+
+	child, err := getMapSlab(storage, childID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+		return err
+	}
+
+	if child.IsData() {
+		// Adjust data slab size before promoting non-root data slab to root
+		dataSlab := child.(*MapDataSlab)
+		dataSlab.header.size = dataSlab.header.size - nonRootMapDataSlabPrefixSize() + mapRootDataSlabPrefixSize
+	}
+
+	extraData := m.root.RemoveExtraData()
+
+	rootID := m.root.SlabID()
+
+	m.root = child
+
+	m.root.SetSlabID(rootID)
+
+	m.root.SetExtraData(extraData)
+
+	err = storeSlab(storage, m.root)
+	if err != nil {
+		return err
+	}
+
+	err = storage.Remove(childID)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", childID))
+	}
+	return nil
+}
+
+// mutableValue operations (parent updater callback, mutableElementIndex, etc)
+
+func (m *OrderedMap) Inlined() bool {
+	if m.root == nil {
+		// Root slab of lazily created map is standalone.
+		return false
+	}
+	return m.root.Inlined()
+}
+
+func (m *OrderedMap) Inlinable(maxInlineSize uint64) bool {
+	m.mustLoadRoot()
+	return m.root.Inlinable(maxInlineSize)
+}
+
+func (m *OrderedMap) setParentUpdater(f parentUpdater) {
+	m.parentUpdater = f
+}
+
+// setCallbackWithChild sets up callback function with child value (child)
+// so parent map (m) can be notified when child value is modified.
+func (m *OrderedMap) setCallbackWithChild(
+	comparator ValueComparator,
+	hip HashInputProvider,
+	key Value,
+	child Value,
+	maxInlineSize uint64,
+) {
+	// Unwrap child value if needed (e.g. interpreter.SomeValue)
+	unwrappedChild, wrapperSize := unwrapValue(child)
+
+	c, ok := unwrappedChild.(mutableValueNotifier)
+	if !ok {
+		return
+	}
+
+	// Child of read-only container is read-only, so parent doesn't need to be notified.
+	if m.readOnly {
+		setReadOnly(c)
+		return
+	}
+
+	if maxInlineSize < wrapperSize {
+		maxInlineSize = 0
+	} else {
+		maxInlineSize -= wrapperSize
+	}
+
+	vid := c.ValueID()
+
+	c.setParentUpdater(func() (found bool, err error) {
+
+		// Avoid unnecessary write operation on parent container.
+		// Child value was stored as SlabIDStorable (not inlined) in parent container,
+		// and continues to be stored as SlabIDStorable (still not inlinable),
+		// so no update to parent container is needed.
+		if !c.Inlined() && !c.Inlinable(maxInlineSize) {
+			return true, nil
+		}
+
+		// Retrieve element value under the same key and
+		// verify retrieved value is this child (c).
+		_, valueStorable, err := m.get(comparator, hip, key)
+		if err != nil {
+			var knf *KeyNotFoundError
+			if errors.As(err, &knf) {
+				return false, nil
+			}
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.Get().
+			return false, err
+		}
+
+		valueStorable = unwrapStorable(valueStorable)
+
+		// Verify retrieved element value is either SlabIDStorable or Slab, with identical value ID.
+		switch valueStorable := valueStorable.(type) {
+		case SlabIDStorable:
+			sid := SlabID(valueStorable)
+			if !vid.equal(sid) {
+				return false, nil
+			}
+
+		case Slab:
+			sid := valueStorable.SlabID()
+			if !vid.equal(sid) {
+				return false, nil
+			}
+
+		default:
+			return false, nil
+		}
+
+		// NOTE: Must reset child using original child (not unwrapped child)
+
+		// Set child value with parent map using same key.
+		// Set() calls child.Storable() which returns inlined or not-inlined child storable.
+		existingValueStorable, err := m.set(m.Storage, comparator, hip, key, child)
+		if err != nil {
+			return false, err
+		}
+
+		// Verify overwritten storable has identical value ID.
+
+		existingValueStorable = unwrapStorable(existingValueStorable)
+
+		switch existingValueStorable := existingValueStorable.(type) {
+		case SlabIDStorable:
+			sid := SlabID(existingValueStorable)
+			if !vid.equal(sid) {
+				return false, NewFatalError(
+					fmt.Errorf(
+						"failed to reset child value in parent updater callback: overwritten SlabIDStorable %s != value ID %s",
+						sid,
+						vid))
+			}
+
+		case Slab:
+			sid := existingValueStorable.SlabID()
+			if !vid.equal(sid) {
+				return false, NewFatalError(
+					fmt.Errorf(
+						"failed to reset child value in parent updater callback: overwritten Slab ID %s != value ID %s",
+						sid,
+						vid))
+			}
+
+		case nil:
+			return false, NewFatalError(
+				fmt.Errorf(
+					"failed to reset child value in parent updater callback: overwritten value is nil"))
+
+		default:
+			return false, NewFatalError(
+				fmt.Errorf(
+					"failed to reset child value in parent updater callback: overwritten value is wrong type %T",
+					existingValueStorable))
+		}
+
+		return true, nil
+	})
+}
+
+// notifyParentIfNeeded calls parent updater if this map (m) is a child
+// element in another container.
+func (m *OrderedMap) notifyParentIfNeeded() error {
+	if m.parentUpdater == nil {
+		return nil
+	}
+
+	// If parentUpdater() doesn't find child map (m), then no-op on parent container
+	// and unset parentUpdater callback in child map.  This can happen when child
+	// map is an outdated reference (removed or overwritten in parent container).
+	found, err := m.parentUpdater()
+	if err != nil {
+		return err
+	}
+	if !found {
+		m.parentUpdater = nil
+	}
+	return nil
+}
+
+// Value operations
+
+// Storable returns OrderedMap m as either:
+// - SlabIDStorable, or
+// - inlined data slab storable
+func (m *OrderedMap) Storable(_ SlabStorage, _ Address, maxInlineSize uint64) (Storable, error) {
+	if m.readOnly {
+		return nil, NewReadOnlyError(m.ValueID())
+	}
+
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return nil, err
+	}
+
+	inlined := m.root.Inlined()
+	inlinable := m.root.Inlinable(maxInlineSize)
+
+	switch {
+
+	case inlinable && inlined:
+		// Root slab is inlinable and was inlined.
+		// Return root slab as storable, no size adjustment and change to storage.
+		return m.root, nil
+
+	case !inlinable && !inlined:
+		// Root slab is not inlinable and was not inlined.
+		// Return root slab as storable, no size adjustment and change to storage.
+		return SlabIDStorable(m.SlabID()), nil
+
+	case inlinable && !inlined:
+		// Root slab is inlinable and was NOT inlined.
+
+		// Inline root data slab.
+		err := m.root.Inline(m.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		return m.root, nil
+
+	case !inlinable && inlined:
+		// Root slab is NOT inlinable and was inlined.
+
+		// Uninline root slab.
+		err := m.root.Uninline(m.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		return SlabIDStorable(m.SlabID()), nil
+
+	default:
+		panic(NewUnreachableError())
+	}
+}
+
+// Iterators
+
+// Iterator returns mutable iterator for map elements.
+// Mutable iterator handles:
+// - indirect element mutation, such as modifying nested container
+// - direct element mutation, such as overwriting existing element with new element
+// Mutable iterator doesn't handle:
+// - inserting new elements into the map
+// - removing existing elements from the map
+// NOTE: Use readonly iterator if mutation is not needed for better performance.
+func (m *OrderedMap) Iterator(comparator ValueComparator, hip HashInputProvider) (MapIterator, error) {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return nil, err
+	}
+
+	if m.Count() == 0 {
+		return emptyMutableMapIterator, nil
+	}
+
+	keyStorable, err := firstKeyInMapSlab(m.Storage, m.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstKeyInMapSlab().
+		return nil, err
+	}
+
+	if keyStorable == nil {
+		// This should never happen because m.Count() > 0.
+		return nil, NewSlabDataErrorf("failed to find first key in map while map count > 0")
+	}
+
+	key, err := keyStorable.StoredValue(m.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMutableMapIterator(m, comparator, hip, key), nil
+}
+
+// ReadOnlyIterator returns readonly iterator for map elements.
+// If elements are mutated:
+// - those changes are not guaranteed to persist.
+// - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
+// NOTE:
+// Use readonly iterator if mutation is not needed for better performance.
+// If callback is needed (e.g. for logging mutation, etc.), use ReadOnlyIteratorWithMutationCallback().
+func (m *OrderedMap) ReadOnlyIterator() (MapIterator, error) {
+	return m.ReadOnlyIteratorWithMutationCallback(nil, nil)
+}
+
+// ReadOnlyIteratorWithMutationCallback returns readonly iterator for map elements.
+// keyMutatinCallback and valueMutationCallback are useful for logging, etc. with
+// more context when mutation occurs.  Mutation handling here is the same with or
+// without these callbacks.
+// If elements are mutated:
+// - those changes are not guaranteed to persist.
+// - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
+// - keyMutatinCallback and valueMutationCallback are called if provided
+// NOTE:
+// Use readonly iterator if mutation is not needed for better performance.
+// If callback isn't needed, use ReadOnlyIterator().
+func (m *OrderedMap) ReadOnlyIteratorWithMutationCallback(
+	keyMutatinCallback ReadOnlyMapIteratorMutationCallback,
+	valueMutationCallback ReadOnlyMapIteratorMutationCallback,
+) (MapIterator, error) {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return nil, err
+	}
+
+	if m.Count() == 0 {
+		return emptyReadOnlyMapIterator, nil
+	}
+
+	dataSlab, err := firstMapDataSlab(m.Storage, m.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstMapDataSlab().
+		return nil, err
+	}
+
+	if keyMutatinCallback == nil {
+		keyMutatinCallback = defaultReadOnlyMapIteratorMutatinCallback
+	}
+
+	if valueMutationCallback == nil {
+		valueMutationCallback = defaultReadOnlyMapIteratorMutatinCallback
+	}
+
+	return newReadOnlyMapIterator(m, dataSlab, keyMutatinCallback, valueMutationCallback), nil
+}
+
+// ReadOnlyLoadedValueIterator returns iterator to iterate loaded map elements.
+func (m *OrderedMap) ReadOnlyLoadedValueIterator() (*MapLoadedValueIterator, error) {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return nil, err
+	}
+
+	switch slab := m.root.(type) {
+
+	case *MapDataSlab:
+		// Create a data iterator from root slab.
+		dataIterator := &mapLoadedElementIterator{
+			storage:  m.Storage,
+			elements: slab.elements,
+		}
+
+		// Create iterator with data iterator (no parents).
+		iterator := &MapLoadedValueIterator{
+			storage:      m.Storage,
+			dataIterator: dataIterator,
+		}
+
+		return iterator, nil
+
+	case *MapMetaDataSlab:
+		// Create a slab iterator from root slab.
+		slabIterator := &mapLoadedSlabIterator{
+			storage: m.Storage,
+			slab:    slab,
+		}
+
+		// Create iterator with parent (data iterater is uninitialized).
+		iterator := &MapLoadedValueIterator{
+			storage: m.Storage,
+			parents: []*mapLoadedSlabIterator{slabIterator},
+		}
+
+		return iterator, nil
+
+	default:
+		return nil, NewSlabDataErrorf("slab %s isn't MapSlab", slab.SlabID())
+	}
+}
+
+// Iterate functions with callbacks
+
+// Iterate iterates map elements.  Options (such as WithRetrieveRetries)
+// configure iteration.
+func (m *OrderedMap) Iterate(
+	comparator ValueComparator,
+	hip HashInputProvider,
+	fn MapEntryIterationFunc,
+	opts ...IterationOption,
+) error {
+	config := newIterationConfig(opts)
+
+	err := m.loadRoot()
+	for retry := 0; err != nil && config.shouldRetry(err, retry); retry++ {
+		err = m.loadRoot()
+	}
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
+
+	if m.isEmpty() {
+		return nil
+	}
+
+	iterator, err := m.Iterator(comparator, hip)
+	for retry := 0; err != nil && config.shouldRetry(err, retry); retry++ {
+		iterator, err = m.Iterator(comparator, hip)
+	}
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.Iterator().
+		return err
+	}
+	return iterateMap(iterator, fn, config)
+}
+
+// IterateReadOnly iterates readonly map elements.
+// If elements are mutated:
+// - those changes are not guaranteed to persist.
+// - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
+// NOTE:
+// Use readonly iterator if mutation is not needed for better performance.
+// If callback is needed (e.g. for logging mutation, etc.), use IterateReadOnlyWithMutationCallback().
+// Options (such as WithRetrieveRetries) configure iteration.
+func (m *OrderedMap) IterateReadOnly(
+	fn MapEntryIterationFunc,
+	opts ...IterationOption,
+) error {
+	return m.iterateReadOnly(fn, nil, nil, newIterationConfig(opts))
+}
+
+// ReduceMap folds map elements in iteration order with fn, starting with initial.
+// Elements are iterated with readonly iterator without loading all elements in memory.
+// If fn returns error, iteration is stopped and error is returned.
+func ReduceMap[T any](m *OrderedMap, initial T, fn func(acc T, k Value, v Value) (T, error)) (T, error) {
+	acc := initial
+
+	err := m.IterateReadOnly(func(k Value, v Value) (bool, error) {
+		var err error
+		acc, err = fn(acc, k, v)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		var zero T
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.IterateReadOnly().
+		return zero, err
+	}
+
+	return acc, nil
+}
+
+// IterateReadOnlyWithMutationCallback iterates readonly map elements.
+// keyMutatinCallback and valueMutationCallback are useful for logging, etc. with
+// more context when mutation occurs.  Mutation handling here is the same with or
+// without these callbacks.
+// If elements are mutated:
+// - those changes are not guaranteed to persist.
+// - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
+// - keyMutatinCallback/valueMutationCallback is called if provided
+// NOTE:
+// Use readonly iterator if mutation is not needed for better performance.
+// If callback isn't needed, use IterateReadOnly().
+func (m *OrderedMap) IterateReadOnlyWithMutationCallback(
+	fn MapEntryIterationFunc,
+	keyMutatinCallback ReadOnlyMapIteratorMutationCallback,
+	valueMutationCallback ReadOnlyMapIteratorMutationCallback,
+) error {
+	return m.iterateReadOnly(fn, keyMutatinCallback, valueMutationCallback, iterationConfig{})
+}
 
-	c.setParentUpdater(func() (found bool, err error) {
+func (m *OrderedMap) iterateReadOnly(
+	fn MapEntryIterationFunc,
+	keyMutatinCallback ReadOnlyMapIteratorMutationCallback,
+	valueMutationCallback ReadOnlyMapIteratorMutationCallback,
+	config iterationConfig,
+) error {
+	err := m.loadRoot()
+	for retry := 0; err != nil && config.shouldRetry(err, retry); retry++ {
+		err = m.loadRoot()
+	}
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
 
-		// Avoid unnecessary write operation on parent container.
-		// Child value was stored as SlabIDStorable (not inlined) in parent container,
-		// and continues to be stored as SlabIDStorable (still not inlinable),
-		// so no update to parent container is needed.
-		if !c.Inlined() && !c.Inlinable(maxInlineSize) {
-			return true, nil
-		}
+	if m.isEmpty() {
+		return nil
+	}
 
-		// Retrieve element value under the same key and
-		// verify retrieved value is this child (c).
-		_, valueStorable, err := m.get(comparator, hip, key)
+	iterator, err := m.ReadOnlyIteratorWithMutationCallback(keyMutatinCallback, valueMutationCallback)
+	for retry := 0; err != nil && config.shouldRetry(err, retry); retry++ {
+		iterator, err = m.ReadOnlyIteratorWithMutationCallback(keyMutatinCallback, valueMutationCallback)
+	}
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.ReadOnlyIterator().
+		return err
+	}
+	return iterateMap(iterator, fn, config)
+}
+
+// IterateReadOnlyReverse iterates readonly map elements in reverse
+// iteration order (from last element to first element).
+// Data slabs are stepped backward by their prev slab IDs, which are stored
+// if SetMapDataSlabPrevSlabIDEnabled is enabled.  If prev slab ID isn't
+// stored (e.g. data slab is encoded while it is disabled), IDs of preceding
+// data slabs are collected by following next slab IDs from first data slab.
+// If elements are mutated:
+// - those changes are not guaranteed to persist.
+// - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
+func (m *OrderedMap) IterateReadOnlyReverse(fn MapEntryIterationFunc) error {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
+
+	if m.isEmpty() {
+		return nil
+	}
+
+	firstDataSlab, err := firstMapDataSlab(m.Storage, m.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstMapDataSlab().
+		return err
+	}
+
+	dataSlab, err := lastMapDataSlab(m.Storage, m.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by lastMapDataSlab().
+		return err
+	}
+
+	// readonly iterator is only used to set up mutation callback of child containers.
+	iterator := &readOnlyMapIterator{
+		m:                     m,
+		keyMutationCallback:   defaultReadOnlyMapIteratorMutatinCallback,
+		valueMutationCallback: defaultReadOnlyMapIteratorMutatinCallback,
+	}
+
+	modCount := m.modCount
+
+	// prevSlabIDs is only used if prev slab ID isn't stored in data slab.
+	var prevSlabIDs []SlabID
+
+	for {
+		resume, err := m.iterateReadOnlyReverseElements(iterator, dataSlab, modCount, fn)
 		if err != nil {
-			var knf *KeyNotFoundError
-			if errors.As(err, &knf) {
-				return false, nil
-			}
-			// Don't need to wrap error as external error because err is already categorized by OrderedMap.Get().
-			return false, err
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.iterateReadOnlyReverseElements().
+			return err
+		}
+		if !resume || dataSlab.SlabID() == firstDataSlab.SlabID() {
+			return nil
 		}
 
-		valueStorable = unwrapStorable(valueStorable)
+		var prevSlabID SlabID
+		switch {
+		case len(prevSlabIDs) > 0:
+			prevSlabID = prevSlabIDs[len(prevSlabIDs)-1]
+			prevSlabIDs = prevSlabIDs[:len(prevSlabIDs)-1]
 
-		// Verify retrieved element value is either SlabIDStorable or Slab, with identical value ID.
-		switch valueStorable := valueStorable.(type) {
-		case SlabIDStorable:
-			sid := SlabID(valueStorable)
-			if !vid.equal(sid) {
-				return false, nil
-			}
+		case dataSlab.prev != SlabIDUndefined:
+			prevSlabID = dataSlab.prev
 
-		case Slab:
-			sid := valueStorable.SlabID()
-			if !vid.equal(sid) {
-				return false, nil
+		default:
+			prevSlabIDs, err = m.mapDataSlabIDsBefore(firstDataSlab, dataSlab.SlabID())
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by OrderedMap.mapDataSlabIDsBefore().
+				return err
 			}
+			prevSlabID = prevSlabIDs[len(prevSlabIDs)-1]
+			prevSlabIDs = prevSlabIDs[:len(prevSlabIDs)-1]
+		}
 
-		default:
-			return false, nil
+		slab, err := getMapSlab(m.Storage, prevSlabID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return err
 		}
 
-		// NOTE: Must reset child using original child (not unwrapped child)
+		var ok bool
+		dataSlab, ok = slab.(*MapDataSlab)
+		if !ok {
+			return NewSlabDataErrorf("slab %s isn't MapDataSlab", prevSlabID)
+		}
+	}
+}
 
-		// Set child value with parent map using same key.
-		// Set() calls child.Storable() which returns inlined or not-inlined child storable.
-		existingValueStorable, err := m.set(comparator, hip, key, child)
+// iterateReadOnlyReverseElements calls fn with elements of dataSlab
+// in reverse iteration order.
+func (m *OrderedMap) iterateReadOnlyReverseElements(
+	iterator *readOnlyMapIterator,
+	dataSlab *MapDataSlab,
+	modCount uint64,
+	fn MapEntryIterationFunc,
+) (resume bool, err error) {
+
+	// Collect element storables of data slab (including elements in
+	// collision groups) in iteration order.
+	var keyStorables, valueStorables []Storable
+
+	elemIterator := mapElementIterator{
+		storage:  m.Storage,
+		elements: dataSlab.elements,
+	}
+
+	for {
+		ks, vs, err := elemIterator.next()
 		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by mapElementIterator.next().
 			return false, err
 		}
+		if ks == nil {
+			break
+		}
+		keyStorables = append(keyStorables, ks)
+		valueStorables = append(valueStorables, vs)
+	}
 
-		// Verify overwritten storable has identical value ID.
+	for i := len(keyStorables) - 1; i >= 0; i-- {
+		err = m.checkModCount(modCount)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkModCount().
+			return false, err
+		}
 
-		existingValueStorable = unwrapStorable(existingValueStorable)
+		key, err := keyStorables[i].StoredValue(m.Storage)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Storable interface.
+			return false, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get map key's stored value")
+		}
 
-		switch existingValueStorable := existingValueStorable.(type) {
-		case SlabIDStorable:
-			sid := SlabID(existingValueStorable)
-			if !vid.equal(sid) {
-				return false, NewFatalError(
-					fmt.Errorf(
-						"failed to reset child value in parent updater callback: overwritten SlabIDStorable %s != value ID %s",
-						sid,
-						vid))
-			}
+		value, err := valueStorables[i].StoredValue(m.Storage)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Storable interface.
+			return false, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get map value's stored value")
+		}
 
-		case Slab:
-			sid := existingValueStorable.SlabID()
-			if !vid.equal(sid) {
-				return false, NewFatalError(
-					fmt.Errorf(
-						"failed to reset child value in parent updater callback: overwritten Slab ID %s != value ID %s",
-						sid,
-						vid))
-			}
+		iterator.setMutationCallback(key, value)
 
-		case nil:
-			return false, NewFatalError(
-				fmt.Errorf(
-					"failed to reset child value in parent updater callback: overwritten value is nil"))
+		resume, err := fn(key, value)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by MapEntryIterationFunc callback.
+			return false, wrapErrorAsExternalErrorIfNeeded(err)
+		}
+		if !resume {
+			return false, nil
+		}
+	}
 
-		default:
-			return false, NewFatalError(
-				fmt.Errorf(
-					"failed to reset child value in parent updater callback: overwritten value is wrong type %T",
-					existingValueStorable))
+	return true, nil
+}
+
+// mapDataSlabIDsBefore returns IDs of data slabs before data slab with
+// given ID, following next slab IDs from firstDataSlab.
+func (m *OrderedMap) mapDataSlabIDsBefore(firstDataSlab *MapDataSlab, id SlabID) ([]SlabID, error) {
+	var ids []SlabID
+
+	dataSlab := firstDataSlab
+	for dataSlab.SlabID() != id {
+		ids = append(ids, dataSlab.SlabID())
+
+		if dataSlab.next == SlabIDUndefined {
+			return nil, NewSlabDataErrorf("data slab %s isn't found by following next slab IDs", id)
 		}
 
-		return true, nil
-	})
+		slab, err := getMapSlab(m.Storage, dataSlab.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return nil, err
+		}
+
+		var ok bool
+		dataSlab, ok = slab.(*MapDataSlab)
+		if !ok {
+			return nil, NewSlabDataErrorf("slab %s isn't MapDataSlab", slab.SlabID())
+		}
+	}
+
+	return ids, nil
 }
 
-// notifyParentIfNeeded calls parent updater if this map (m) is a child
-// element in another container.
-func (m *OrderedMap) notifyParentIfNeeded() error {
-	if m.parentUpdater == nil {
+// IterateValidating iterates readonly map elements while verifying that
+// element digests are sorted within and across data slabs, and within
+// collision groups.  It returns SlabDataError on first violation.
+// This is a lighter-weight alternative to VerifyMap for sanity checks
+// during iteration.
+// If elements are mutated:
+// - those changes are not guaranteed to persist.
+// - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
+func (m *OrderedMap) IterateValidating(fn MapEntryIterationFunc) error {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
+
+	if m.Count() == 0 {
 		return nil
 	}
 
-	// If parentUpdater() doesn't find child map (m), then no-op on parent container
-	// and unset parentUpdater callback in child map.  This can happen when child
-	// map is an outdated reference (removed or overwritten in parent container).
-	found, err := m.parentUpdater()
+	dataSlab, err := firstMapDataSlab(m.Storage, m.root)
 	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstMapDataSlab().
 		return err
 	}
-	if !found {
-		m.parentUpdater = nil
-	}
-	return nil
-}
 
-// Value operations
+	// readonly iterator is only used to set up mutation callback of child containers.
+	iterator := &readOnlyMapIterator{
+		m:                     m,
+		keyMutationCallback:   defaultReadOnlyMapIteratorMutatinCallback,
+		valueMutationCallback: defaultReadOnlyMapIteratorMutatinCallback,
+	}
 
-// Storable returns OrderedMap m as either:
-// - SlabIDStorable, or
-// - inlined data slab storable
-func (m *OrderedMap) Storable(_ SlabStorage, _ Address, maxInlineSize uint64) (Storable, error) {
+	var prevHkey Digest
+	hasPrevHkey := false
 
-	inlined := m.root.Inlined()
-	inlinable := m.root.Inlinable(maxInlineSize)
+	for {
+		hkeys, ok := dataSlab.elements.(*hkeyElements)
+		if !ok {
+			return NewSlabDataErrorf("data slab %s elements type %T is wrong, want *hkeyElements", dataSlab.SlabID(), dataSlab.elements)
+		}
 
-	switch {
+		// Verify first digest of this data slab is greater than last digest of previous data slab.
+		if hasPrevHkey && len(hkeys.hkeys) > 0 && hkeys.hkeys[0] <= prevHkey {
+			return NewSlabDataErrorf(
+				"data slab %s first digest %d isn't greater than previous data slab's last digest %d",
+				dataSlab.SlabID(), hkeys.hkeys[0], prevHkey)
+		}
 
-	case inlinable && inlined:
-		// Root slab is inlinable and was inlined.
-		// Return root slab as storable, no size adjustment and change to storage.
-		return m.root, nil
+		resume, err := m.iterateValidatingElements(iterator, dataSlab.SlabID(), hkeys, fn)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.iterateValidatingElements().
+			return err
+		}
+		if !resume {
+			return nil
+		}
 
-	case !inlinable && !inlined:
-		// Root slab is not inlinable and was not inlined.
-		// Return root slab as storable, no size adjustment and change to storage.
-		return SlabIDStorable(m.SlabID()), nil
+		if len(hkeys.hkeys) > 0 {
+			prevHkey = hkeys.hkeys[len(hkeys.hkeys)-1]
+			hasPrevHkey = true
+		}
 
-	case inlinable && !inlined:
-		// Root slab is inlinable and was NOT inlined.
+		if dataSlab.next == SlabIDUndefined {
+			return nil
+		}
 
-		// Inline root data slab.
-		err := m.root.Inline(m.Storage)
+		slab, err := getMapSlab(m.Storage, dataSlab.next)
 		if err != nil {
-			return nil, err
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return err
 		}
 
-		return m.root, nil
+		dataSlab, ok = slab.(*MapDataSlab)
+		if !ok {
+			return NewSlabDataErrorf("slab %s isn't MapDataSlab", slab.SlabID())
+		}
+	}
+}
 
-	case !inlinable && inlined:
-		// Root slab is NOT inlinable and was inlined.
+func (m *OrderedMap) iterateValidatingElements(
+	iterator *readOnlyMapIterator,
+	id SlabID,
+	elems elements,
+	fn MapEntryIterationFunc,
+) (resume bool, err error) {
+
+	switch elems := elems.(type) {
+	case *hkeyElements:
+		if len(elems.hkeys) != len(elems.elems) {
+			return false, NewSlabDataErrorf(
+				"slab %s has %d digests and %d elements at level %d",
+				id, len(elems.hkeys), len(elems.elems), elems.level)
+		}
 
-		// Uninline root slab.
-		err := m.root.Uninline(m.Storage)
-		if err != nil {
-			return nil, err
+		for i, elem := range elems.elems {
+			// Verify digests are sorted and unique.
+			if i > 0 && elems.hkeys[i] <= elems.hkeys[i-1] {
+				return false, NewSlabDataErrorf(
+					"slab %s digest %d at index %d isn't greater than digest %d at index %d at level %d",
+					id, elems.hkeys[i], i, elems.hkeys[i-1], i-1, elems.level)
+			}
+
+			resume, err = m.iterateValidatingElement(iterator, id, elem, fn)
+			if err != nil || !resume {
+				return resume, err
+			}
 		}
 
-		return SlabIDStorable(m.SlabID()), nil
+	case *singleElements:
+		for _, elem := range elems.elems {
+			resume, err = m.iterateValidatingElement(iterator, id, elem, fn)
+			if err != nil || !resume {
+				return resume, err
+			}
+		}
 
 	default:
-		panic(NewUnreachableError())
+		return false, NewSlabDataErrorf("slab %s has unexpected elements type %T", id, elems)
 	}
+
+	return true, nil
 }
 
-// Iterators
+func (m *OrderedMap) iterateValidatingElement(
+	iterator *readOnlyMapIterator,
+	id SlabID,
+	elem element,
+	fn MapEntryIterationFunc,
+) (resume bool, err error) {
+
+	switch elem := elem.(type) {
+	case *singleElement:
+		key, err := elem.key.StoredValue(m.Storage)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Storable interface.
+			return false, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get map key's stored value")
+		}
+
+		value, err := elem.value.StoredValue(m.Storage)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Storable interface.
+			return false, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get map value's stored value")
+		}
+
+		iterator.setMutationCallback(key, value)
+
+		resume, err = fn(key, value)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by MapEntryIterationFunc callback.
+			return false, wrapErrorAsExternalErrorIfNeeded(err)
+		}
+		return resume, nil
 
-// Iterator returns mutable iterator for map elements.
-// Mutable iterator handles:
-// - indirect element mutation, such as modifying nested container
-// - direct element mutation, such as overwriting existing element with new element
-// Mutable iterator doesn't handle:
-// - inserting new elements into the map
-// - removing existing elements from the map
-// NOTE: Use readonly iterator if mutation is not needed for better performance.
-func (m *OrderedMap) Iterator(comparator ValueComparator, hip HashInputProvider) (MapIterator, error) {
-	if m.Count() == 0 {
-		return emptyMutableMapIterator, nil
-	}
+	case *inlineCollisionGroup:
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.iterateValidatingElements().
+		return m.iterateValidatingElements(iterator, id, elem.elements, fn)
 
-	keyStorable, err := firstKeyInMapSlab(m.Storage, m.root)
-	if err != nil {
-		// Don't need to wrap error as external error because err is already categorized by firstKeyInMapSlab().
-		return nil, err
-	}
+	case *externalCollisionGroup:
+		elems, err := elem.Elements(m.Storage)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by externalCollisionGroup.Elements().
+			return false, err
+		}
 
-	if keyStorable == nil {
-		// This should never happen because m.Count() > 0.
-		return nil, NewSlabDataErrorf("failed to find first key in map while map count > 0")
-	}
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.iterateValidatingElements().
+		return m.iterateValidatingElements(iterator, elem.slabID, elems, fn)
 
-	key, err := keyStorable.StoredValue(m.Storage)
-	if err != nil {
-		return nil, err
+	default:
+		return false, NewSlabDataErrorf("slab %s has unexpected element type %T", id, elem)
 	}
-
-	return &mutableMapIterator{
-		m:          m,
-		comparator: comparator,
-		hip:        hip,
-		nextKey:    key,
-	}, nil
 }
 
-// ReadOnlyIterator returns readonly iterator for map elements.
-// If elements are mutated:
-// - those changes are not guaranteed to persist.
-// - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
-// NOTE:
-// Use readonly iterator if mutation is not needed for better performance.
-// If callback is needed (e.g. for logging mutation, etc.), use ReadOnlyIteratorWithMutationCallback().
-func (m *OrderedMap) ReadOnlyIterator() (MapIterator, error) {
-	return m.ReadOnlyIteratorWithMutationCallback(nil, nil)
-}
+// MapIndexIterationFunc is called by IterateForIndex with each element, its first
+// level digest, and ID of slab containing the element.
+type MapIndexIterationFunc func(key Value, value Value, digest Digest, slabID SlabID) (resume bool, err error)
 
-// ReadOnlyIteratorWithMutationCallback returns readonly iterator for map elements.
-// keyMutatinCallback and valueMutationCallback are useful for logging, etc. with
-// more context when mutation occurs.  Mutation handling here is the same with or
-// without these callbacks.
+// IterateForIndex iterates readonly map elements in a single scan, and passes
+// each element with its first level digest and ID of slab containing the element
+// to fn.  If element is in external collision group, ID of external collision
+// group slab is passed (same as DataSlabIDForKey).  This is useful for building
+// external index which can be verified or invalidated later.
+// NOTE: slab ID is only stable until next mutation of the map.
 // If elements are mutated:
 // - those changes are not guaranteed to persist.
 // - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
-// - keyMutatinCallback and valueMutationCallback are called if provided
-// NOTE:
-// Use readonly iterator if mutation is not needed for better performance.
-// If callback isn't needed, use ReadOnlyIterator().
-func (m *OrderedMap) ReadOnlyIteratorWithMutationCallback(
-	keyMutatinCallback ReadOnlyMapIteratorMutationCallback,
-	valueMutationCallback ReadOnlyMapIteratorMutationCallback,
-) (MapIterator, error) {
-	if m.Count() == 0 {
-		return emptyReadOnlyMapIterator, nil
+func (m *OrderedMap) IterateForIndex(fn MapIndexIterationFunc) error {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
+
+	if m.isEmpty() {
+		return nil
 	}
 
 	dataSlab, err := firstMapDataSlab(m.Storage, m.root)
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by firstMapDataSlab().
-		return nil, err
-	}
-
-	if keyMutatinCallback == nil {
-		keyMutatinCallback = defaultReadOnlyMapIteratorMutatinCallback
+		return err
 	}
 
-	if valueMutationCallback == nil {
-		valueMutationCallback = defaultReadOnlyMapIteratorMutatinCallback
+	// readonly iterator is only used to set up mutation callback of child containers.
+	iterator := &readOnlyMapIterator{
+		m:                     m,
+		keyMutationCallback:   defaultReadOnlyMapIteratorMutatinCallback,
+		valueMutationCallback: defaultReadOnlyMapIteratorMutatinCallback,
 	}
 
-	return &readOnlyMapIterator{
-		m:              m,
-		nextDataSlabID: dataSlab.next,
-		elemIterator: &mapElementIterator{
-			storage:  m.Storage,
-			elements: dataSlab.elements,
-		},
-		keyMutationCallback:   keyMutatinCallback,
-		valueMutationCallback: valueMutationCallback,
-	}, nil
-}
+	for {
+		hkeys, ok := dataSlab.elements.(*hkeyElements)
+		if !ok {
+			return NewSlabDataErrorf("data slab %s elements type %T is wrong, want *hkeyElements", dataSlab.SlabID(), dataSlab.elements)
+		}
 
-// ReadOnlyLoadedValueIterator returns iterator to iterate loaded map elements.
-func (m *OrderedMap) ReadOnlyLoadedValueIterator() (*MapLoadedValueIterator, error) {
-	switch slab := m.root.(type) {
+		for i, elem := range hkeys.elems {
+			resume, err := m.iterateElementForIndex(iterator, dataSlab.SlabID(), hkeys.hkeys[i], elem, fn)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by OrderedMap.iterateElementForIndex().
+				return err
+			}
+			if !resume {
+				return nil
+			}
+		}
 
-	case *MapDataSlab:
-		// Create a data iterator from root slab.
-		dataIterator := &mapLoadedElementIterator{
-			storage:  m.Storage,
-			elements: slab.elements,
+		if dataSlab.next == SlabIDUndefined {
+			return nil
 		}
 
-		// Create iterator with data iterator (no parents).
-		iterator := &MapLoadedValueIterator{
-			storage:      m.Storage,
-			dataIterator: dataIterator,
+		slab, err := getMapSlab(m.Storage, dataSlab.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return err
 		}
 
-		return iterator, nil
+		dataSlab, ok = slab.(*MapDataSlab)
+		if !ok {
+			return NewSlabDataErrorf("slab %s isn't MapDataSlab", slab.SlabID())
+		}
+	}
+}
 
-	case *MapMetaDataSlab:
-		// Create a slab iterator from root slab.
-		slabIterator := &mapLoadedSlabIterator{
-			storage: m.Storage,
-			slab:    slab,
+func (m *OrderedMap) iterateElementForIndex(
+	iterator *readOnlyMapIterator,
+	id SlabID,
+	digest Digest,
+	elem element,
+	fn MapIndexIterationFunc,
+) (resume bool, err error) {
+
+	switch elem := elem.(type) {
+	case *singleElement:
+		key, err := elem.key.StoredValue(m.Storage)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Storable interface.
+			return false, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get map key's stored value")
 		}
 
-		// Create iterator with parent (data iterater is uninitialized).
-		iterator := &MapLoadedValueIterator{
-			storage: m.Storage,
-			parents: []*mapLoadedSlabIterator{slabIterator},
+		value, err := elem.value.StoredValue(m.Storage)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Storable interface.
+			return false, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get map value's stored value")
 		}
 
-		return iterator, nil
+		iterator.setMutationCallback(key, value)
 
-	default:
-		return nil, NewSlabDataErrorf("slab %s isn't MapSlab", slab.SlabID())
-	}
-}
+		resume, err = fn(key, value, digest, id)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by MapIndexIterationFunc callback.
+			return false, wrapErrorAsExternalErrorIfNeeded(err)
+		}
+		return resume, nil
 
-// Iterate functions with callbacks
+	case elementGroup:
+		if !elem.Inline() {
+			id = elem.(*externalCollisionGroup).slabID
+		}
 
-func (m *OrderedMap) Iterate(comparator ValueComparator, hip HashInputProvider, fn MapEntryIterationFunc) error {
-	iterator, err := m.Iterator(comparator, hip)
-	if err != nil {
-		// Don't need to wrap error as external error because err is already categorized by OrderedMap.Iterator().
-		return err
-	}
-	return iterateMap(iterator, fn)
-}
+		elems, err := elem.Elements(m.Storage)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by elementGroup.Elements().
+			return false, err
+		}
 
-// IterateReadOnly iterates readonly map elements.
-// If elements are mutated:
-// - those changes are not guaranteed to persist.
-// - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
-// NOTE:
-// Use readonly iterator if mutation is not needed for better performance.
-// If callback is needed (e.g. for logging mutation, etc.), use IterateReadOnlyWithMutationCallback().
-func (m *OrderedMap) IterateReadOnly(
-	fn MapEntryIterationFunc,
-) error {
-	return m.IterateReadOnlyWithMutationCallback(fn, nil, nil)
-}
+		for i := range elems.Count() {
+			e, err := elems.Element(int(i))
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by elements.Element().
+				return false, err
+			}
 
-// IterateReadOnlyWithMutationCallback iterates readonly map elements.
-// keyMutatinCallback and valueMutationCallback are useful for logging, etc. with
-// more context when mutation occurs.  Mutation handling here is the same with or
-// without these callbacks.
-// If elements are mutated:
-// - those changes are not guaranteed to persist.
-// - mutation functions of child containers return ReadOnlyIteratorElementMutationError.
-// - keyMutatinCallback/valueMutationCallback is called if provided
-// NOTE:
-// Use readonly iterator if mutation is not needed for better performance.
-// If callback isn't needed, use IterateReadOnly().
-func (m *OrderedMap) IterateReadOnlyWithMutationCallback(
-	fn MapEntryIterationFunc,
-	keyMutatinCallback ReadOnlyMapIteratorMutationCallback,
-	valueMutationCallback ReadOnlyMapIteratorMutationCallback,
-) error {
-	iterator, err := m.ReadOnlyIteratorWithMutationCallback(keyMutatinCallback, valueMutationCallback)
-	if err != nil {
-		// Don't need to wrap error as external error because err is already categorized by OrderedMap.ReadOnlyIterator().
-		return err
+			resume, err = m.iterateElementForIndex(iterator, id, digest, e, fn)
+			if err != nil || !resume {
+				return resume, err
+			}
+		}
+		return true, nil
+
+	default:
+		return false, NewSlabDataErrorf("slab %s has unexpected element type %T", id, elem)
 	}
-	return iterateMap(iterator, fn)
 }
 
 func (m *OrderedMap) IterateKeys(comparator ValueComparator, hip HashInputProvider, fn MapElementIterationFunc) error {
@@ -1413,6 +3811,29 @@ func (m *OrderedMap) IterateReadOnlyValuesWithMutationCallback(
 	return iterateMapValues(iterator, fn)
 }
 
+// IterateKeysNoValueLoad iterates readonly map keys without loading values.
+// It never retrieves external slab of any value (such as StorableSlab of
+// large value or root slab of child container which isn't inlined), so it
+// is useful for counting or inspecting keys.  Slabs of the map (including
+// external collision groups) and external slabs of keys are retrieved.
+// Keys are readonly as in IterateReadOnlyKeys().
+func (m *OrderedMap) IterateKeysNoValueLoad(fn MapElementIterationFunc) error {
+	// Readonly key iterator only gets stored value of key storables.
+	// Don't need to wrap error as external error because err is already categorized by OrderedMap.IterateReadOnlyKeys().
+	return m.IterateReadOnlyKeys(fn)
+}
+
+// IterateValuesLoadingOnly iterates readonly map values, loading only
+// values.  It never retrieves external slab of any key (such as StorableSlab
+// of large key).  Slabs of the map (including external collision groups)
+// and external slabs of values are retrieved.
+// Values are readonly as in IterateReadOnlyValues().
+func (m *OrderedMap) IterateValuesLoadingOnly(fn MapElementIterationFunc) error {
+	// Readonly value iterator only gets stored value of value storables.
+	// Don't need to wrap error as external error because err is already categorized by OrderedMap.IterateReadOnlyValues().
+	return m.IterateReadOnlyValues(fn)
+}
+
 // IterateReadOnlyLoadedValues iterates loaded map values.
 func (m *OrderedMap) IterateReadOnlyLoadedValues(fn MapEntryIterationFunc) error {
 	iterator, err := m.ReadOnlyLoadedValueIterator()
@@ -1445,25 +3866,139 @@ func (m *OrderedMap) IterateReadOnlyLoadedValues(fn MapEntryIterationFunc) error
 // Other operations
 
 func (m *OrderedMap) Seed() uint64 {
+	m.mustLoadRoot()
 	return m.root.ExtraData().Seed
 }
 
 func (m *OrderedMap) Count() uint64 {
+	m.mustLoadRoot()
 	return m.root.ExtraData().Count
 }
 
+// IsEmpty returns true if map has no elements.
+func (m *OrderedMap) IsEmpty() bool {
+	m.mustLoadRoot()
+	return m.isEmpty()
+}
+
+// isEmpty returns true if map has no elements.  Root slab must be
+// retrieved by loadRoot before calling isEmpty.
+func (m *OrderedMap) isEmpty() bool {
+	return m.root.ExtraData().Count == 0
+}
+
 func (m *OrderedMap) Address() Address {
+	if m.root == nil {
+		return m.lazyRootID.address
+	}
 	return m.root.SlabID().address
 }
 
 func (m *OrderedMap) Type() TypeInfo {
+	m.mustLoadRoot()
 	if extraData := m.root.ExtraData(); extraData != nil {
 		return extraData.TypeInfo
 	}
 	return nil
 }
 
+// ExtraData returns copy of extra data in map root slab (type info,
+// count, seed, and schema ID), so map metadata can be inspected without
+// using map.  Modifying returned extra data doesn't modify map.
+// Type info bytes can be produced with TypeInfo.Encode.
+func (m *OrderedMap) ExtraData() MapExtraData {
+	m.mustLoadRoot()
+
+	extraData := m.root.ExtraData()
+	if extraData == nil {
+		return MapExtraData{}
+	}
+
+	copied := *extraData
+	if copied.TypeInfo != nil {
+		copied.TypeInfo = copied.TypeInfo.Copy()
+	}
+	return copied
+}
+
+// DeepCopy returns a new map at given address with copied elements.
+// Nested Array and OrderedMap keys and values are deep copied recursively,
+// and other keys and values are stored again with new address, so the new
+// map and its nested containers have new slab IDs and share no slabs with map m.
+// The new map uses the same seed, schema ID, and digester builder as map m.
+func (m *OrderedMap) DeepCopy(
+	storage SlabStorage,
+	address Address,
+	comparator ValueComparator,
+	hip HashInputProvider,
+) (*OrderedMap, error) {
+	iterator, err := m.ReadOnlyIterator()
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.ReadOnlyIterator().
+		return nil, err
+	}
+	defer closeMapIterator(iterator)
+
+	copied, err := buildMapFromBatchData(
+		storage,
+		address,
+		m.digesterBuilder,
+		m.Type(),
+		comparator,
+		hip,
+		m.Seed(),
+		m.root.ExtraData().narrowDigests,
+		m.root.ExtraData().singleDigestLevel,
+		func() (Value, Value, error) {
+			k, v, err := iterator.Next()
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by MapIterator.Next().
+				return nil, nil, err
+			}
+			if k == nil {
+				return nil, nil, nil
+			}
+
+			copiedKey, err := deepCopyValue(storage, address, comparator, hip, k)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by deepCopyValue().
+				return nil, nil, err
+			}
+
+			copiedValue, err := deepCopyValue(storage, address, comparator, hip, v)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by deepCopyValue().
+				return nil, nil, err
+			}
+
+			return copiedKey, copiedValue, nil
+		})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by buildMapFromBatchData().
+		return nil, err
+	}
+
+	if schemaID := m.SchemaID(); schemaID != 0 {
+		err = copied.SetSchemaID(schemaID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.SetSchemaID().
+			return nil, err
+		}
+	}
+
+	return copied, nil
+}
+
 func (m *OrderedMap) SetType(typeInfo TypeInfo) error {
+	if m.readOnly {
+		return NewReadOnlyError(m.ValueID())
+	}
+
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
+
 	extraData := m.root.ExtraData()
 	extraData.TypeInfo = typeInfo
 
@@ -1473,13 +4008,71 @@ func (m *OrderedMap) SetType(typeInfo TypeInfo) error {
 		// Map is inlined.
 
 		// Notify parent container so parent slab is saved in storage with updated TypeInfo of inlined array.
-		return m.notifyParentIfNeeded()
+		err := m.notifyParentIfNeeded()
+		if err != nil {
+			return err
+		}
+	} else {
+		// Map is standalone.
+
+		// Store modified root slab in storage since typeInfo is part of extraData stored in root slab.
+		err := storeSlab(m.Storage, m.root)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by OrderedMap.commitOperationIfNeeded().
+	return m.commitOperationIfNeeded()
+}
+
+// SchemaID returns schema ID recorded with map by SetSchemaID.
+// It returns 0 if map doesn't have schema ID.
+func (m *OrderedMap) SchemaID() uint64 {
+	m.mustLoadRoot()
+	return m.root.ExtraData().SchemaID
+}
+
+// SetSchemaID records caller-supplied schema ID with map.  Schema ID is
+// stored in map extra data, so a caller loading map by root slab ID can
+// check that it uses the same ValueComparator and HashInputProvider that
+// were used to build the map.  Atree doesn't interpret schema ID.
+// Schema ID 0 removes schema ID from map.
+func (m *OrderedMap) SetSchemaID(schemaID uint64) error {
+	if m.readOnly {
+		return NewReadOnlyError(m.ValueID())
+	}
+
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
 	}
 
-	// Map is standalone.
+	extraData := m.root.ExtraData()
+	extraData.SchemaID = schemaID
+
+	m.root.SetExtraData(extraData)
+
+	if m.Inlined() {
+		// Map is inlined.
+
+		// Notify parent container so parent slab is saved in storage with updated schema ID of inlined map.
+		err := m.notifyParentIfNeeded()
+		if err != nil {
+			return err
+		}
+	} else {
+		// Map is standalone.
+
+		// Store modified root slab in storage since schema ID is part of extraData stored in root slab.
+		err := storeSlab(m.Storage, m.root)
+		if err != nil {
+			return err
+		}
+	}
 
-	// Store modified root slab in storage since typeInfo is part of extraData stored in root slab.
-	return storeSlab(m.Storage, m.root)
+	// Don't need to wrap error as external error because err is already categorized by OrderedMap.commitOperationIfNeeded().
+	return m.commitOperationIfNeeded()
 }
 
 func (m *OrderedMap) String() string {
@@ -1487,6 +4080,7 @@ func (m *OrderedMap) String() string {
 	if err != nil {
 		return err.Error()
 	}
+	defer closeMapIterator(iterator)
 
 	var elemsStr []string
 	for {
@@ -1511,6 +4105,7 @@ func (m *MapExtraData) decrementCount() {
 	m.Count--
 }
 func (m *OrderedMap) rootSlab() MapSlab {
+	m.mustLoadRoot()
 	return m.root
 }
 
@@ -1518,7 +4113,20 @@ func (m *OrderedMap) getDigesterBuilder() DigesterBuilder {
 	return m.digesterBuilder
 }
 
+// SlabID returns root slab ID of map.  Root slab ID is generated when map
+// is created, and it doesn't change when root slab is split, merged, or
+// committed, so caller can record it right after map is created and load
+// map with NewMapWithRootID after commit.  SlabID returns SlabIDUndefined
+// while map is inlined in parent container, and it returns the same root
+// slab ID again after map is uninlined.  ValueID is the same during the
+// whole lifetime of map, including while map is inlined.
+// Map created with AllowTempAddress at AddressUndefined has temporary
+// root slab ID, which isn't committed to base storage.
 func (m *OrderedMap) SlabID() SlabID {
+	if m.root == nil {
+		// Root slab of lazily created map is standalone.
+		return m.lazyRootID
+	}
 	if m.root.Inlined() {
 		return SlabIDUndefined
 	}
@@ -1526,5 +4134,8 @@ func (m *OrderedMap) SlabID() SlabID {
 }
 
 func (m *OrderedMap) ValueID() ValueID {
+	if m.root == nil {
+		return slabIDToValueID(m.lazyRootID)
+	}
 	return slabIDToValueID(m.root.SlabID())
 }
@@ -0,0 +1,341 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/onflow/atree"
+	"github.com/onflow/atree/test_utils"
+)
+
+var noopBools []bool
+
+func BenchmarkMapHas1000x(b *testing.B) {
+	m, keys := newBenchmarkMapAndLookupKeys(b, 100_000, 1000)
+
+	b.ResetTimer()
+
+	for range b.N {
+		for _, k := range keys {
+			has, err := m.Has(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(b, err)
+			noopBools = append(noopBools[:0], has)
+		}
+	}
+}
+
+func BenchmarkMapHasAll1000x(b *testing.B) {
+	m, keys := newBenchmarkMapAndLookupKeys(b, 100_000, 1000)
+
+	b.ResetTimer()
+
+	for range b.N {
+		result, err := m.HasAll(test_utils.CompareValue, test_utils.GetHashInput, keys)
+		require.NoError(b, err)
+		noopBools = result
+	}
+}
+
+// newBenchmarkMapAndLookupKeys returns map with mapCount elements and
+// keyCount lookup keys, half of which are present in the map.
+func newBenchmarkMapAndLookupKeys(b *testing.B, mapCount int, keyCount int) (*atree.OrderedMap, []atree.Value) {
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	storage := newTestPersistentStorage(b)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(b, err)
+
+	for i := range uint64(mapCount) {
+		k := test_utils.Uint64Value(i)
+		v := test_utils.Uint64Value(i)
+		_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+		require.NoError(b, err)
+	}
+
+	r := newRand(b)
+
+	keys := make([]atree.Value, keyCount)
+	for i := range keys {
+		if i%2 == 0 {
+			keys[i] = test_utils.Uint64Value(r.Intn(mapCount))
+		} else {
+			keys[i] = test_utils.Uint64Value(mapCount + r.Intn(mapCount))
+		}
+	}
+
+	return m, keys
+}
+
+func BenchmarkMapSetIntegerKeyDefaultDigester(b *testing.B) {
+	benchmarkMapSetIntegerKey(b, atree.NewDefaultDigesterBuilder())
+}
+
+func BenchmarkMapSetIntegerKeyIntegerDigester(b *testing.B) {
+	benchmarkMapSetIntegerKey(b, atree.NewIntegerKeyDigesterBuilder(func(v atree.Value) (uint64, bool) {
+		n, ok := v.(test_utils.Uint64Value)
+		return uint64(n), ok
+	}))
+}
+
+func benchmarkMapSetIntegerKey(b *testing.B, digesterBuilder atree.DigesterBuilder) {
+	const mapCount = 10_000
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	b.ReportAllocs()
+
+	for range b.N {
+		b.StopTimer()
+
+		storage := newTestPersistentStorage(b)
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(b, err)
+
+		b.StartTimer()
+
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(b, err)
+		}
+	}
+}
+
+// BenchmarkMapSetIntegerKeyHashInput benchmarks inserting integer keys
+// with hash input from HashInputProvider using digester's scratch buffer,
+// and from FixedSizeHashInput.
+func BenchmarkMapSetIntegerKeyHashInput(b *testing.B) {
+	keyValue := func(v atree.Value) test_utils.Uint64Value {
+		if v, ok := v.(providerHashInputValue); ok {
+			return v.v
+		}
+		return v.(test_utils.Uint64Value)
+	}
+
+	comparator := func(_ atree.SlabStorage, value atree.Value, storable atree.Storable) (bool, error) {
+		return keyValue(value) == storable, nil
+	}
+
+	// hip gets hash input like test_utils.GetHashInput, using scratch buffer.
+	hip := func(value atree.Value, scratch []byte) ([]byte, error) {
+		return test_utils.GetHashInput(keyValue(value), scratch)
+	}
+
+	benchmarks := []struct {
+		name   string
+		hip    atree.HashInputProvider
+		newKey func(uint64) atree.Value
+	}{
+		{"HashInputProvider", hip, func(i uint64) atree.Value { return providerHashInputValue{v: test_utils.Uint64Value(i)} }},
+		{"FixedSizeHashInput", test_utils.GetHashInput, func(i uint64) atree.Value { return test_utils.Uint64Value(i) }},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			const mapCount = 10_000
+
+			typeInfo := test_utils.NewSimpleTypeInfo(42)
+			address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+			keys := make([]atree.Value, mapCount)
+			for i := range keys {
+				keys[i] = bm.newKey(uint64(i))
+			}
+
+			b.ReportAllocs()
+
+			for range b.N {
+				b.StopTimer()
+
+				storage := newTestPersistentStorage(b)
+
+				m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+				require.NoError(b, err)
+
+				b.StartTimer()
+
+				for _, k := range keys {
+					_, err := m.Set(comparator, bm.hip, k, test_utils.Uint64Value(0))
+					require.NoError(b, err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMapNarrowDigests benchmarks inserting integer keys into map
+// with default and narrow digests, and reports encoded bytes of all slabs.
+func BenchmarkMapNarrowDigests(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		opts []atree.MapOption
+	}{
+		{"Default", nil},
+		{"NarrowDigests", []atree.MapOption{atree.WithNarrowDigests()}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			const mapCount = 10_000
+
+			typeInfo := test_utils.NewSimpleTypeInfo(42)
+			address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+			b.ReportAllocs()
+
+			var encodedSize int
+
+			for range b.N {
+				b.StopTimer()
+
+				storage := newTestPersistentStorage(b)
+
+				m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, bm.opts...)
+				require.NoError(b, err)
+
+				b.StartTimer()
+
+				for i := range uint64(mapCount) {
+					k := test_utils.Uint64Value(i)
+					_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+					require.NoError(b, err)
+				}
+
+				err = storage.Commit()
+				require.NoError(b, err)
+
+				encodedSize = atree.GetBaseStorage(storage).Size()
+			}
+
+			b.ReportMetric(float64(encodedSize), "bytes")
+		})
+	}
+}
+
+// BenchmarkMapSingleDigestLevel benchmarks inserting keys into small map
+// with default digest levels and single digest level.
+func BenchmarkMapSingleDigestLevel(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		opts []atree.MapOption
+	}{
+		{"DefaultDigestLevels", nil},
+		{"SingleDigestLevel", []atree.MapOption{atree.WithSingleDigestLevel()}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			const mapCount = 16
+
+			typeInfo := test_utils.NewSimpleTypeInfo(42)
+			address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+			keys := make([]atree.Value, mapCount)
+			for i := range keys {
+				keys[i] = test_utils.NewStringValue(strconv.Itoa(i))
+			}
+
+			b.ReportAllocs()
+
+			for range b.N {
+				b.StopTimer()
+
+				storage := newTestPersistentStorage(b)
+
+				m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, bm.opts...)
+				require.NoError(b, err)
+
+				b.StartTimer()
+
+				for _, k := range keys {
+					_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+					require.NoError(b, err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMapIteratorClose benchmarks iterating many tiny maps
+// with and without closing iterators, which returns them to pool.
+func BenchmarkMapIteratorClose(b *testing.B) {
+	const mapCount = 10_000
+	const elementCount = 4
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	storage := newTestPersistentStorage(b)
+
+	maps := make([]*atree.OrderedMap, mapCount)
+	for i := range maps {
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(b, err)
+
+		for j := range uint64(elementCount) {
+			k := test_utils.Uint64Value(j)
+			_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(b, err)
+		}
+
+		maps[i] = m
+	}
+
+	benchmarks := []struct {
+		name  string
+		close bool
+	}{
+		{"WithoutClose", false},
+		{"WithClose", true},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for range b.N {
+				for _, m := range maps {
+					iterator, err := m.ReadOnlyIterator()
+					require.NoError(b, err)
+
+					for {
+						k, _, err := iterator.Next()
+						require.NoError(b, err)
+						if k == nil {
+							break
+						}
+					}
+
+					if bm.close {
+						iterator.(atree.ClosableMapIterator).Close()
+					}
+				}
+			}
+		})
+	}
+}
@@ -23,7 +23,13 @@ import "fmt"
 // MapDataSlab is leaf node, implementing MapSlab.
 // anySize is true for data slab that isn't restricted by size requirement.
 type MapDataSlab struct {
-	next   SlabID
+	next SlabID
+
+	// prev is ID of previous data slab.  It is only maintained if prev
+	// slab ID is enabled by SetMapDataSlabPrevSlabIDEnabled, and it can be
+	// SlabIDUndefined for data slab encoded while it was disabled.
+	prev SlabID
+
 	header MapSlabHeader
 
 	elements
@@ -139,7 +145,7 @@ func (m *MapDataSlab) Split(storage SlabStorage) (Slab, Slab, error) {
 	rightSlab := &MapDataSlab{
 		header: MapSlabHeader{
 			slabID:   sID,
-			size:     mapDataSlabPrefixSize + rightElements.Size(),
+			size:     nonRootMapDataSlabPrefixSize() + rightElements.Size(),
 			firstKey: rightElements.firstKey(),
 		},
 		next:     m.next,
@@ -147,14 +153,53 @@ func (m *MapDataSlab) Split(storage SlabStorage) (Slab, Slab, error) {
 		anySize:  m.anySize,
 	}
 
+	if mapDataSlabPrevSlabIDEnabled {
+		rightSlab.prev = m.header.slabID
+	}
+
 	// Modify left (original) slab
-	m.header.size = mapDataSlabPrefixSize + leftElements.Size()
+	m.header.size = nonRootMapDataSlabPrefixSize() + leftElements.Size()
 	m.next = rightSlab.header.slabID
 	m.elements = leftElements
 
+	// Update prev slab ID of right slab's next slab
+	err = rightSlab.updateNextSlabPrev(storage)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by MapDataSlab.updateNextSlabPrev().
+		return nil, nil, err
+	}
+
 	return m, rightSlab, nil
 }
 
+// updateNextSlabPrev sets prev slab ID of next data slab to this slab's ID,
+// if prev slab ID is enabled.  It needs to be called after next data slab
+// is changed by split or merge.
+func (m *MapDataSlab) updateNextSlabPrev(storage SlabStorage) error {
+	if !mapDataSlabPrevSlabIDEnabled || m.next == SlabIDUndefined {
+		return nil
+	}
+
+	nextSlab, err := getMapSlab(storage, m.next)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+		return err
+	}
+
+	nextDataSlab, ok := nextSlab.(*MapDataSlab)
+	if !ok {
+		return NewSlabDataErrorf("slab %s isn't MapDataSlab", m.next)
+	}
+
+	if nextDataSlab.prev == m.header.slabID {
+		return nil
+	}
+
+	nextDataSlab.prev = m.header.slabID
+
+	return storeSlab(storage, nextDataSlab)
+}
+
 func (m *MapDataSlab) Merge(slab Slab) error {
 
 	rightSlab := slab.(*MapDataSlab)
@@ -165,11 +210,14 @@ func (m *MapDataSlab) Merge(slab Slab) error {
 		return err
 	}
 
-	m.header.size = mapDataSlabPrefixSize + m.elements.Size()
+	m.header.size = nonRootMapDataSlabPrefixSize() + m.elements.Size()
 	m.header.firstKey = m.elements.firstKey()
 
 	m.next = rightSlab.next
 
+	// Caller needs to call updateNextSlabPrev() to update
+	// prev slab ID of the new next slab.
+
 	return nil
 }
 
@@ -189,11 +237,11 @@ func (m *MapDataSlab) LendToRight(slab Slab) error {
 
 	// Update right slab
 	rightSlab.elements = rightElements
-	rightSlab.header.size = mapDataSlabPrefixSize + rightElements.Size()
+	rightSlab.header.size = nonRootMapDataSlabPrefixSize() + rightElements.Size()
 	rightSlab.header.firstKey = rightElements.firstKey()
 
 	// Update left slab
-	m.header.size = mapDataSlabPrefixSize + m.elements.Size()
+	m.header.size = nonRootMapDataSlabPrefixSize() + m.elements.Size()
 
 	return nil
 }
@@ -215,11 +263,11 @@ func (m *MapDataSlab) BorrowFromRight(slab Slab) error {
 
 	// Update right slab
 	rightSlab.elements = rightElements
-	rightSlab.header.size = mapDataSlabPrefixSize + rightElements.Size()
+	rightSlab.header.size = nonRootMapDataSlabPrefixSize() + rightElements.Size()
 	rightSlab.header.firstKey = rightElements.firstKey()
 
 	// Update left slab
-	m.header.size = mapDataSlabPrefixSize + m.elements.Size()
+	m.header.size = nonRootMapDataSlabPrefixSize() + m.elements.Size()
 	m.header.firstKey = m.elements.firstKey()
 
 	return nil
@@ -271,6 +319,7 @@ func (m *MapDataSlab) Inlined() bool {
 
 // Inlinable returns true if
 // - map data slab is root slab
+// - map doesn't use narrow digests or single digest level
 // - size of inlined map data slab <= maxInlineSize
 func (m *MapDataSlab) Inlinable(maxInlineSize uint64) bool {
 	if m.extraData == nil {
@@ -278,6 +327,16 @@ func (m *MapDataSlab) Inlinable(maxInlineSize uint64) bool {
 		return false
 	}
 
+	if m.extraData.narrowDigests {
+		// Inlined map encoding doesn't have flag for narrow digests.
+		return false
+	}
+
+	if m.extraData.singleDigestLevel {
+		// Inlined map encoding doesn't have digest levels.
+		return false
+	}
+
 	inlinedSize := inlinedMapDataSlabPrefixSize + m.elements.Size()
 
 	// Inlined byte size must be less than max inline size.
@@ -337,7 +396,7 @@ func (m *MapDataSlab) getPrefixSize() uint32 {
 	if m.extraData != nil {
 		return mapRootDataSlabPrefixSize
 	}
-	return mapDataSlabPrefixSize
+	return nonRootMapDataSlabPrefixSize()
 }
 
 func (m *MapDataSlab) isCollisionGroup() bool {
@@ -403,10 +462,12 @@ func (m *MapDataSlab) SetExtraData(extraData *MapExtraData) {
 }
 
 func (m *MapDataSlab) String() string {
-	return fmt.Sprintf("MapDataSlab id:%s size:%d firstkey:%d elements: [%s]",
+	return fmt.Sprintf("MapDataSlab id:%s size:%d count:%d firstKey:%d next:%s elements: [%s]",
 		m.header.slabID,
 		m.header.size,
+		m.elements.Count(),
 		m.header.firstKey,
+		m.next,
 		m.elements.String(),
 	)
 }
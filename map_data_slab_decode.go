@@ -138,16 +138,16 @@ func newMapDataSlabFromDataV0(
 
 	// Decode elements
 	cborDec := decMode.NewByteStreamDecoder(data)
-	elements, err := newElementsFromData(cborDec, decodeStorable, id, nil)
+	elements, err := newElementsFromData(cborDec, decodeStorable, id, nil, false)
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by newElementsFromDataV0().
 		return nil, err
 	}
 
 	// Compute slab size for version 1.
-	slabSize := versionAndFlagSize + elements.Size()
+	slabSize := mapRootDataSlabPrefixSize + elements.Size()
 	if !h.isRoot() {
-		slabSize += SlabIDLength
+		slabSize = nonRootMapDataSlabPrefixSize() + elements.Size()
 	}
 
 	header := MapSlabHeader{
@@ -170,9 +170,9 @@ func newMapDataSlabFromDataV0(
 //
 // DataSlab Header:
 //
-//	+-------------------------------+----------------------+---------------------------------+-----------------------------+
-//	| slab version + flag (2 bytes) | extra data (if root) | inlined extra data (if present) | next slab ID (if non-empty) |
-//	+-------------------------------+----------------------+---------------------------------+-----------------------------+
+//	+-------------------------------+----------------------+---------------------------------+-----------------------------+-----------------------------+
+//	| slab version + flag (2 bytes) | extra data (if root) | inlined extra data (if present) | next slab ID (if non-empty) | prev slab ID (if non-empty) |
+//	+-------------------------------+----------------------+---------------------------------+-----------------------------+-----------------------------+
 //
 // Content:
 //
@@ -196,6 +196,7 @@ func newMapDataSlabFromDataV1(
 	var extraData *MapExtraData
 	var inlinedExtraData []ExtraData
 	var next SlabID
+	var prev SlabID
 
 	// Decode extra data
 	if h.isRoot() {
@@ -204,6 +205,8 @@ func newMapDataSlabFromDataV1(
 			// Don't need to wrap error as external error because err is already categorized by newMapExtraDataFromData().
 			return nil, err
 		}
+
+		extraData.narrowDigests = h.hasNarrowDigests()
 	}
 
 	// Decode inlined extra data
@@ -235,18 +238,36 @@ func newMapDataSlabFromDataV1(
 		data = data[SlabIDLength:]
 	}
 
+	// Decode prev slab ID for non-root slab.
+	// Prev slab ID is skipped if it isn't enabled, so it isn't re-encoded.
+	if h.hasPrevSlabID() {
+		if len(data) < SlabIDLength {
+			return nil, NewDecodingErrorf("data is too short for map data slab")
+		}
+
+		if mapDataSlabPrevSlabIDEnabled {
+			prev, err = NewSlabIDFromRawBytes(data)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by NewSlabIDFromRawBytes().
+				return nil, err
+			}
+		}
+
+		data = data[SlabIDLength:]
+	}
+
 	// Decode elements
 	cborDec := decMode.NewByteStreamDecoder(data)
-	elements, err := newElementsFromData(cborDec, decodeStorable, id, inlinedExtraData)
+	elements, err := newElementsFromData(cborDec, decodeStorable, id, inlinedExtraData, h.hasNarrowDigests())
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by newElementsFromDataV1().
 		return nil, err
 	}
 
 	// Compute slab size.
-	slabSize := versionAndFlagSize + elements.Size()
+	slabSize := mapRootDataSlabPrefixSize + elements.Size()
 	if !h.isRoot() {
-		slabSize += SlabIDLength
+		slabSize = nonRootMapDataSlabPrefixSize() + elements.Size()
 	}
 
 	header := MapSlabHeader{
@@ -257,6 +278,7 @@ func newMapDataSlabFromDataV1(
 
 	return &MapDataSlab{
 		next:           next,
+		prev:           prev,
 		header:         header,
 		elements:       elements,
 		extraData:      extraData,
@@ -395,9 +417,11 @@ func DecodeInlinedCompactMapStorable(
 		extraData: &MapExtraData{
 			// Make a copy of extraData.TypeInfo because
 			// inlined extra data are shared by all inlined slabs.
-			TypeInfo: extraData.mapExtraData.TypeInfo.Copy(),
-			Count:    extraData.mapExtraData.Count,
-			Seed:     extraData.mapExtraData.Seed,
+			TypeInfo:          extraData.mapExtraData.TypeInfo.Copy(),
+			Count:             extraData.mapExtraData.Count,
+			Seed:              extraData.mapExtraData.Seed,
+			SchemaID:          extraData.mapExtraData.SchemaID,
+			digesterBuilderID: extraData.mapExtraData.digesterBuilderID,
 		},
 		anySize:        false,
 		collisionGroup: false,
@@ -478,7 +502,7 @@ func DecodeInlinedMapStorable(
 	slabID := NewSlabID(parentSlabID.address, index)
 
 	// Decode elements
-	elements, err := newElementsFromData(dec, decodeStorable, slabID, inlinedExtraData)
+	elements, err := newElementsFromData(dec, decodeStorable, slabID, inlinedExtraData, false)
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by newElementsFromData().
 		return nil, err
@@ -498,9 +522,11 @@ func DecodeInlinedMapStorable(
 		extraData: &MapExtraData{
 			// Make a copy of extraData.TypeInfo because
 			// inlined extra data are shared by all inlined slabs.
-			TypeInfo: extraData.TypeInfo.Copy(),
-			Count:    extraData.Count,
-			Seed:     extraData.Seed,
+			TypeInfo:          extraData.TypeInfo.Copy(),
+			Count:             extraData.Count,
+			Seed:              extraData.Seed,
+			SchemaID:          extraData.SchemaID,
+			digesterBuilderID: extraData.digesterBuilderID,
 		},
 		anySize:        false,
 		collisionGroup: false,
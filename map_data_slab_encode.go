@@ -24,9 +24,9 @@ import "fmt"
 //
 // Root DataSlab Header:
 //
-//	+-------------------------------+----------------------+---------------------------------+-----------------------------+
-//	| slab version + flag (2 bytes) | extra data (if root) | inlined extra data (if present) | next slab ID (if non-empty) |
-//	+-------------------------------+----------------------+---------------------------------+-----------------------------+
+//	+-------------------------------+----------------------+---------------------------------+-----------------------------+-----------------------------+
+//	| slab version + flag (2 bytes) | extra data (if root) | inlined extra data (if present) | next slab ID (if non-empty) | prev slab ID (if non-empty) |
+//	+-------------------------------+----------------------+---------------------------------+-----------------------------+-----------------------------+
 //
 // Content:
 //
@@ -50,7 +50,7 @@ func (m *MapDataSlab) Encode(enc *Encoder) error {
 	elementBuf := getBuffer()
 	defer putBuffer(elementBuf)
 
-	elemEnc := NewEncoder(elementBuf, enc.encMode)
+	elemEnc := enc.newElementEncoder(elementBuf)
 
 	err := m.encodeElements(elemEnc)
 	if err != nil {
@@ -77,6 +77,10 @@ func (m *MapDataSlab) Encode(enc *Encoder) error {
 		h.setHasNextSlabID()
 	}
 
+	if m.prev != SlabIDUndefined {
+		h.setHasPrevSlabID()
+	}
+
 	if m.anySize {
 		h.setNoSizeLimit()
 	}
@@ -85,6 +89,10 @@ func (m *MapDataSlab) Encode(enc *Encoder) error {
 		h.setRoot()
 	}
 
+	if elements, ok := m.elements.(*hkeyElements); ok && elements.narrow {
+		h.setHasNarrowDigests()
+	}
+
 	if elemEnc.hasInlinedExtraData() {
 		h.setHasInlinedSlabs()
 	}
@@ -128,6 +136,21 @@ func (m *MapDataSlab) Encode(enc *Encoder) error {
 		}
 	}
 
+	// Encode prev slab ID for non-root slab
+	if m.prev != SlabIDUndefined {
+		n, err := m.prev.ToRawBytes(enc.Scratch[:])
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by SlabID.ToRawBytes().
+			return err
+		}
+
+		// Write scratch content to encoder
+		_, err = enc.Write(enc.Scratch[:n])
+		if err != nil {
+			return NewEncodingError(err)
+		}
+	}
+
 	// Encode elements
 	err = enc.CBOR.EncodeRawBytes(elementBuf.Bytes())
 	if err != nil {
@@ -365,6 +388,12 @@ func (m *MapDataSlab) canBeEncodedAsCompactMap() ([]Digest, []ComparableStorable
 		return nil, nil, nil, false
 	}
 
+	// Map with schema ID isn't encoded as compact map because
+	// compact map extra data is shared by maps with the same type and keys.
+	if m.extraData.SchemaID != 0 {
+		return nil, nil, nil, false
+	}
+
 	elements, ok := m.elements.(*hkeyElements)
 	if !ok {
 		return nil, nil, nil, false
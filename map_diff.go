@@ -0,0 +1,253 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"fmt"
+)
+
+// DiffSince returns keys which are changed since snapshot taken by
+// PersistentSlabStorage.Snapshot, by comparing map elements in snapshot
+// with current map elements:
+//   - added keys are in current map but not in snapshot.
+//   - removed keys are in snapshot but not in current map.
+//   - modified keys are in both, with different values.
+//
+// Keys are compared by encoded key, so DiffSince doesn't need
+// ValueComparator and HashInputProvider.  Values are compared by
+// encoded value, except that child arrays and maps are compared by
+// slab ID.  So changes inside child containers aren't reported, and
+// replacing child container with a new container is reported as modified.
+//
+// Since only states at snapshot and now are compared:
+//   - key set then removed after snapshot isn't reported.
+//   - key removed then set again after snapshot is reported as modified
+//     only if its value is different from value in snapshot.
+//
+// Added and modified keys are returned in current map iteration order,
+// and removed keys are returned in map iteration order in snapshot.
+// Removed keys are loaded from snapshot.
+//
+// DiffSince requires map to be stored in PersistentSlabStorage and
+// map to be not inlined.  If map doesn't exist in snapshot, all keys
+// are returned as added.
+func (m *OrderedMap) DiffSince(snapshotID int) (added, removed, modified []Value, err error) {
+	storage, ok := m.Storage.(*PersistentSlabStorage)
+	if !ok {
+		return nil, nil, nil, NewUserError(fmt.Errorf("failed to diff map %s: storage isn't PersistentSlabStorage", m.SlabID()))
+	}
+
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return nil, nil, nil, err
+	}
+
+	if m.Inlined() {
+		return nil, nil, nil, NewUserError(fmt.Errorf("failed to diff map %s: map is inlined", m.SlabID()))
+	}
+
+	snapshotStorage, err := storage.snapshotStorage(snapshotID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.snapshotStorage().
+		return nil, nil, nil, err
+	}
+
+	// Collect elements in snapshot.
+
+	var oldEntries []mapDiffEntry
+	oldEntryIndexes := make(map[string]int)
+
+	_, found, err := snapshotStorage.Retrieve(m.SlabID())
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return nil, nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", m.SlabID()))
+	}
+
+	if found {
+		oldRoot, err := getMapSlab(snapshotStorage, m.SlabID())
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return nil, nil, nil, err
+		}
+
+		err = iterateMapDiffEntries(snapshotStorage, oldRoot, func(entry mapDiffEntry) error {
+			oldEntryIndexes[string(entry.keyData)] = len(oldEntries)
+			oldEntries = append(oldEntries, entry)
+			return nil
+		})
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by iterateMapDiffEntries().
+			return nil, nil, nil, err
+		}
+	}
+
+	// Compare current elements with elements in snapshot.
+
+	matched := make([]bool, len(oldEntries))
+
+	err = iterateMapDiffEntries(storage, m.root, func(entry mapDiffEntry) error {
+		i, exists := oldEntryIndexes[string(entry.keyData)]
+		if exists {
+			matched[i] = true
+			if string(oldEntries[i].valueData) == string(entry.valueData) {
+				return nil
+			}
+		}
+
+		key, err := entry.key.StoredValue(storage)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Storable interface.
+			return wrapErrorfAsExternalErrorIfNeeded(err, "failed to get storable's stored value")
+		}
+
+		if exists {
+			modified = append(modified, key)
+		} else {
+			added = append(added, key)
+		}
+		return nil
+	})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by iterateMapDiffEntries().
+		return nil, nil, nil, err
+	}
+
+	for i, entry := range oldEntries {
+		if matched[i] {
+			continue
+		}
+
+		key, err := entry.key.StoredValue(snapshotStorage)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Storable interface.
+			return nil, nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get storable's stored value")
+		}
+
+		removed = append(removed, key)
+	}
+
+	return added, removed, modified, nil
+}
+
+// mapDiffEntry contains map element and its encoded key and value used for comparison.
+type mapDiffEntry struct {
+	key       Storable
+	keyData   []byte
+	valueData []byte
+}
+
+// iterateMapDiffEntries calls fn with every element of map in iteration order.
+func iterateMapDiffEntries(storage SlabStorage, root MapSlab, fn func(mapDiffEntry) error) error {
+	dataSlab, err := firstMapDataSlab(storage, root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstMapDataSlab().
+		return err
+	}
+
+	for {
+		err = iterateMapDiffElements(storage, dataSlab.elements, fn)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by iterateMapDiffElements().
+			return err
+		}
+
+		if dataSlab.next == SlabIDUndefined {
+			return nil
+		}
+
+		slab, err := getMapSlab(storage, dataSlab.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return err
+		}
+
+		var ok bool
+		dataSlab, ok = slab.(*MapDataSlab)
+		if !ok {
+			return NewSlabDataErrorf("slab %s isn't MapDataSlab", slab.SlabID())
+		}
+	}
+}
+
+func iterateMapDiffElements(storage SlabStorage, elems elements, fn func(mapDiffEntry) error) error {
+	for i := range elems.Count() {
+		elem, err := elems.Element(int(i))
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by elements.Element().
+			return err
+		}
+
+		switch e := elem.(type) {
+		case *singleElement:
+			keyData, err := encodeCollisionKey(storage, e.key)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by encodeCollisionKey().
+				return err
+			}
+
+			valueData, err := encodeDiffValue(storage, e.value)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by encodeDiffValue().
+				return err
+			}
+
+			err = fn(mapDiffEntry{key: e.key, keyData: keyData, valueData: valueData})
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by fn.
+				return err
+			}
+
+		case elementGroup:
+			nested, err := e.Elements(storage)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by elementGroup.Elements().
+				return err
+			}
+
+			err = iterateMapDiffElements(storage, nested, fn)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by iterateMapDiffElements().
+				return err
+			}
+
+		default:
+			return NewUnreachableError()
+		}
+	}
+
+	return nil
+}
+
+// encodeDiffValue returns encoded value storable used to detect modified values.
+// Child arrays and maps are compared by slab ID, so inlined child arrays
+// and maps are encoded as slab ID without retrieving the slab (which
+// doesn't exist in storage while child is inlined).
+func encodeDiffValue(storage SlabStorage, vs Storable) ([]byte, error) {
+	switch s := unwrapStorable(vs).(type) {
+	case ArraySlab:
+		// Don't need to wrap error as external error because err is already categorized by encodeStorableForComparison().
+		return encodeStorableForComparison(SlabIDStorable(s.SlabID()))
+	case MapSlab:
+		// Don't need to wrap error as external error because err is already categorized by encodeStorableForComparison().
+		return encodeStorableForComparison(SlabIDStorable(s.SlabID()))
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by encodeCollisionKey().
+	return encodeCollisionKey(storage, vs)
+}
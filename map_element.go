@@ -110,10 +110,21 @@ func newSingleElement(storage SlabStorage, address Address, key Value, value Val
 		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get key's storable")
 	}
 
-	vs, err := value.Storable(storage, address, maxInlineMapValueSize(uint64(ks.ByteSize())))
+	vs, err := newValueStorable(storage, address, value, maxInlineMapValueSize(uint64(ks.ByteSize())))
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by Value interface.
-		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get value's storable")
+		// Remove slab of key if it is stored externally because element isn't created.
+		// Ignore removal error because err from newValueStorable() is more relevant.
+		_ = removeExternalKeyStorable(storage, ks)
+		_ = removeUnreferencedInternedString(storage, ks)
+
+		// Don't need to wrap error as external error because err is already categorized by newValueStorable().
+		return nil, err
+	}
+
+	err = retainInternedString(storage, ks)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by retainInternedString().
+		return nil, err
 	}
 
 	return &singleElement{
@@ -177,10 +188,10 @@ func (e *singleElement) Set(
 	if equal {
 		existingMapValueStorable := e.value
 
-		valueStorable, err := value.Storable(storage, address, maxInlineMapValueSize(uint64(e.key.ByteSize())))
+		valueStorable, err := newValueStorable(storage, address, value, maxInlineMapValueSize(uint64(e.key.ByteSize())))
 		if err != nil {
-			// Wrap err as external error (if needed) because err is returned by Value interface.
-			return nil, nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get value's storable")
+			// Don't need to wrap error as external error because err is already categorized by newValueStorable().
+			return nil, nil, nil, err
 		}
 
 		e.value = valueStorable
@@ -344,7 +355,7 @@ func (e *inlineCollisionGroup) Set(
 	if level == 1 {
 		// Export oversized inline collision group to separate slab (external collision group)
 		// for first level collision.
-		if e.Size() > uint32(maxInlineMapElementSize) {
+		if e.Size() > uint32(MaxInlineCollisionGroupSize()) {
 
 			id, err := storage.GenerateSlabID(address)
 			if err != nil {
@@ -358,7 +369,7 @@ func (e *inlineCollisionGroup) Set(
 			slab := &MapDataSlab{
 				header: MapSlabHeader{
 					slabID:   id,
-					size:     mapDataSlabPrefixSize + e.elements.Size(),
+					size:     nonRootMapDataSlabPrefixSize() + e.elements.Size(),
 					firstKey: e.elements.firstKey(),
 				},
 				elements:       e.elements, // elems shouldn't be copied
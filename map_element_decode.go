@@ -86,7 +86,7 @@ func newSingleElementFromData(cborDec *cbor.StreamDecoder, decodeStorable Storab
 }
 
 func newInlineCollisionGroupFromData(cborDec *cbor.StreamDecoder, decodeStorable StorableDecoder, slabID SlabID, inlinedExtraData []ExtraData) (*inlineCollisionGroup, error) {
-	elements, err := newElementsFromData(cborDec, decodeStorable, slabID, inlinedExtraData)
+	elements, err := newElementsFromData(cborDec, decodeStorable, slabID, inlinedExtraData, false)
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by newElementsFromData().
 		return nil, err
@@ -25,7 +25,15 @@ import (
 	"github.com/fxamacker/cbor/v2"
 )
 
-func newElementsFromData(cborDec *cbor.StreamDecoder, decodeStorable StorableDecoder, slabID SlabID, inlinedExtraData []ExtraData) (elements, error) {
+// newElementsFromData decodes elements.  If narrowDigests is true,
+// elements must be level 0 hkeyElements with 4-byte hkeys.
+func newElementsFromData(
+	cborDec *cbor.StreamDecoder,
+	decodeStorable StorableDecoder,
+	slabID SlabID,
+	inlinedExtraData []ExtraData,
+	narrowDigests bool,
+) (elements, error) {
 
 	arrayCount, err := cborDec.DecodeArrayHead()
 	if err != nil {
@@ -46,14 +54,27 @@ func newElementsFromData(cborDec *cbor.StreamDecoder, decodeStorable StorableDec
 		return nil, NewDecodingError(err)
 	}
 
-	if len(digestBytes)%digestSize != 0 {
-		return nil, NewDecodingError(fmt.Errorf("decoding digests failed: number of bytes is not multiple of %d", digestSize))
+	if narrowDigests && level != 0 {
+		return nil, NewDecodingError(fmt.Errorf("decoding elements failed: narrow digests at level %d, want level 0", level))
 	}
 
-	digestCount := len(digestBytes) / digestSize
+	hkeySize := digestSize
+	if narrowDigests {
+		hkeySize = narrowDigestSize
+	}
+
+	if len(digestBytes)%hkeySize != 0 {
+		return nil, NewDecodingError(fmt.Errorf("decoding digests failed: number of bytes is not multiple of %d", hkeySize))
+	}
+
+	digestCount := len(digestBytes) / hkeySize
 	hkeys := make([]Digest, digestCount)
 	for i := range hkeys {
-		hkeys[i] = Digest(binary.BigEndian.Uint64(digestBytes[i*digestSize:]))
+		if narrowDigests {
+			hkeys[i] = Digest(binary.BigEndian.Uint32(digestBytes[i*hkeySize:]))
+		} else {
+			hkeys[i] = Digest(binary.BigEndian.Uint64(digestBytes[i*hkeySize:]))
+		}
 	}
 
 	elemCount, err := cborDec.DecodeArrayHead()
@@ -68,6 +89,10 @@ func newElementsFromData(cborDec *cbor.StreamDecoder, decodeStorable StorableDec
 	if digestCount == 0 && elemCount > 0 {
 		// elements are singleElements
 
+		if narrowDigests {
+			return nil, NewDecodingError(fmt.Errorf("decoding elements failed: narrow digests without hkeys"))
+		}
+
 		// Decode elements
 		size := uint32(singleElementsPrefixSize)
 		elems := make([]*singleElement, elemCount)
@@ -105,15 +130,16 @@ func newElementsFromData(cborDec *cbor.StreamDecoder, decodeStorable StorableDec
 		}
 
 		elems[i] = elem
-		size += digestSize + elem.Size()
+		size += uint32(hkeySize) + elem.Size()
 	}
 
 	// Create hkeyElements
 	elements := &hkeyElements{
-		hkeys: hkeys,
-		elems: elems,
-		level: uint(level),
-		size:  size,
+		hkeys:  hkeys,
+		elems:  elems,
+		level:  uint(level),
+		size:   size,
+		narrow: narrowDigests,
 	}
 
 	return elements, nil
@@ -19,8 +19,11 @@
 package atree
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
+	"slices"
 )
 
 // Encode encodes hkeyElements to the given encoder.
@@ -30,12 +33,19 @@ import (
 //	    1: hkeys (byte string)
 //	    2: elements (array)
 //	]
+//
+// Each hkey is encoded in 8 bytes, or in 4 bytes if elements have narrow
+// digests.  Narrow digests are indicated by flag in data slab head.
 func (e *hkeyElements) Encode(enc *Encoder) error {
 
 	if e.level > maxDigestLevel {
 		return NewFatalError(fmt.Errorf("hash level %d exceeds max digest level %d", e.level, maxDigestLevel))
 	}
 
+	if e.narrow && e.level != 0 {
+		return NewEncodingError(fmt.Errorf("narrow digests at level %d, want level 0", e.level))
+	}
+
 	// Encode CBOR array head of 3 elements (level, hkeys, elements)
 	const cborArrayHeadOfThreeElements = 0x83
 	enc.Scratch[0] = cborArrayHeadOfThreeElements
@@ -51,7 +61,9 @@ func (e *hkeyElements) Encode(enc *Encoder) error {
 	const cborByteStringHead = 0x59
 	enc.Scratch[2] = cborByteStringHead
 
-	binary.BigEndian.PutUint16(enc.Scratch[3:], uint16(len(e.hkeys)*8))
+	hkeySize := e.hkeySize()
+
+	binary.BigEndian.PutUint16(enc.Scratch[3:], uint16(len(e.hkeys)*int(hkeySize)))
 
 	// Write scratch content to encoder
 	const totalSize = 5
@@ -62,8 +74,15 @@ func (e *hkeyElements) Encode(enc *Encoder) error {
 
 	// Encode hkeys
 	for i := range e.hkeys {
-		binary.BigEndian.PutUint64(enc.Scratch[:], uint64(e.hkeys[i]))
-		err = enc.CBOR.EncodeRawBytes(enc.Scratch[:digestSize])
+		if e.narrow {
+			if e.hkeys[i] > math.MaxUint32 {
+				return NewEncodingError(fmt.Errorf("digest %d doesn't fit in %d bytes", e.hkeys[i], narrowDigestSize))
+			}
+			binary.BigEndian.PutUint32(enc.Scratch[:], uint32(e.hkeys[i]))
+		} else {
+			binary.BigEndian.PutUint64(enc.Scratch[:], uint64(e.hkeys[i]))
+		}
+		err = enc.CBOR.EncodeRawBytes(enc.Scratch[:hkeySize])
 		if err != nil {
 			return NewEncodingError(err)
 		}
@@ -136,8 +155,17 @@ func (e *singleElements) Encode(enc *Encoder) error {
 		return NewEncodingError(err)
 	}
 
+	elems := e.elems
+	if enc.canonicalStorage != nil {
+		elems, err = e.canonicalElements(enc.canonicalStorage)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by singleElements.canonicalElements().
+			return err
+		}
+	}
+
 	// Encode each element
-	for _, e := range e.elems {
+	for _, e := range elems {
 		err = e.Encode(enc)
 		if err != nil {
 			// Don't need to wrap error as external error because err is already categorized by singleElement.Encode().
@@ -153,3 +181,40 @@ func (e *singleElements) Encode(enc *Encoder) error {
 
 	return nil
 }
+
+// canonicalElements returns elements ordered by encoded key in ascending
+// lexicographical order, which is the order of elements encoded in canonical
+// encode mode.  Keys are encoded by encodeCollisionKey (content of key stored
+// in separate slab is encoded), like ordering colliding elements in memory.
+// Elements are not modified.
+func (e *singleElements) canonicalElements(storage SlabStorage) ([]*singleElement, error) {
+	if len(e.elems) < 2 {
+		return e.elems, nil
+	}
+
+	encodedKeys := make([][]byte, len(e.elems))
+	for i, elem := range e.elems {
+		b, err := encodeCollisionKey(storage, elem.key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by encodeCollisionKey().
+			return nil, err
+		}
+		encodedKeys[i] = b
+	}
+
+	indexes := make([]int, len(e.elems))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	slices.SortStableFunc(indexes, func(a, b int) int {
+		return bytes.Compare(encodedKeys[a], encodedKeys[b])
+	})
+
+	elems := make([]*singleElement, len(e.elems))
+	for i, index := range indexes {
+		elems[i] = e.elems[index]
+	}
+
+	return elems, nil
+}
@@ -37,6 +37,10 @@ type hkeyElements struct {
 	elems []element // elements corresponding to hkeys
 	size  uint32    // total byte sizes
 	level uint
+
+	// narrow is true if hkeys are 32-bit level 0 digests, which are
+	// encoded in 4 bytes each (see WithNarrowDigests).
+	narrow bool
 }
 
 var _ elements = &hkeyElements{}
@@ -48,6 +52,14 @@ func newHkeyElements(level uint) *hkeyElements {
 	}
 }
 
+// hkeySize returns encoded size of each hkey.
+func (e *hkeyElements) hkeySize() uint32 {
+	if e.narrow {
+		return narrowDigestSize
+	}
+	return digestSize
+}
+
 func newHkeyElementsWithElement(level uint, hkey Digest, elem element) *hkeyElements {
 	return &hkeyElements{
 		hkeys: []Digest{hkey},
@@ -187,7 +199,7 @@ func (e *hkeyElements) Set(
 
 		e.elems = []element{newElem}
 
-		e.size += digestSize + newElem.Size()
+		e.size += e.hkeySize() + newElem.Size()
 
 		return newElem.key, nil, nil
 	}
@@ -209,7 +221,7 @@ func (e *hkeyElements) Set(
 		copy(e.elems[1:], e.elems)
 		e.elems[0] = newElem
 
-		e.size += digestSize + newElem.Size()
+		e.size += e.hkeySize() + newElem.Size()
 
 		return newElem.key, nil, nil
 	}
@@ -227,7 +239,7 @@ func (e *hkeyElements) Set(
 
 		e.elems = append(e.elems, newElem)
 
-		e.size += digestSize + newElem.Size()
+		e.size += e.hkeySize() + newElem.Size()
 
 		return newElem.key, nil, nil
 	}
@@ -304,7 +316,7 @@ func (e *hkeyElements) Set(
 		// Given this, size diff of the old and new element can be 0 even when its actual size changed.
 		size := uint32(hkeyElementsPrefixSize)
 		for _, element := range e.elems {
-			size += element.Size() + digestSize
+			size += element.Size() + e.hkeySize()
 		}
 		e.size = size
 
@@ -329,7 +341,7 @@ func (e *hkeyElements) Set(
 	copy(e.elems[lessThanIndex+1:], e.elems[lessThanIndex:])
 	e.elems[lessThanIndex] = newElem
 
-	e.size += digestSize + newElem.Size()
+	e.size += e.hkeySize() + newElem.Size()
 
 	return newElem.key, nil, nil
 }
@@ -390,7 +402,7 @@ func (e *hkeyElements) Remove(storage SlabStorage, digester Digester, level uint
 		e.hkeys = e.hkeys[:len(e.hkeys)-1]
 
 		// Adjust size
-		e.size -= digestSize + oldElemSize
+		e.size -= e.hkeySize() + oldElemSize
 
 		return k, v, nil
 	}
@@ -439,6 +451,10 @@ func (e *hkeyElements) Merge(elems elements) error {
 		return NewSlabMergeError(fmt.Errorf("cannot merge elements of different types (%T, %T)", e, elems))
 	}
 
+	if e.narrow != rElems.narrow {
+		return NewSlabMergeError(fmt.Errorf("cannot merge elements of different digest sizes (%d, %d)", e.hkeySize(), rElems.hkeySize()))
+	}
+
 	e.hkeys = append(e.hkeys, rElems.hkeys...)
 	e.elems = append(e.elems, rElems.elems...)
 	e.size += rElems.Size() - hkeyElementsPrefixSize
@@ -460,7 +476,7 @@ func (e *hkeyElements) Split() (elements, elements, error) {
 	leftSize := uint32(0)
 	leftCount := 0
 	for i, elem := range e.elems {
-		elemSize := elem.Size() + digestSize
+		elemSize := elem.Size() + e.hkeySize()
 		if leftSize+elemSize >= midPoint {
 			// i is mid point element.  Place i on the small side.
 			if leftSize <= dataSize-leftSize-elemSize {
@@ -478,7 +494,7 @@ func (e *hkeyElements) Split() (elements, elements, error) {
 	rightCount := len(e.elems) - leftCount
 
 	// Create right slab elements
-	rightElements := &hkeyElements{level: e.level}
+	rightElements := &hkeyElements{level: e.level, narrow: e.narrow}
 
 	rightElements.hkeys = make([]Digest, rightCount)
 	copy(rightElements.hkeys, e.hkeys[leftCount:])
@@ -503,7 +519,7 @@ func (e *hkeyElements) Split() (elements, elements, error) {
 // LendToRight rebalances elements by moving elements from left to right
 func (e *hkeyElements) LendToRight(re elements) error {
 
-	minSize := minThreshold - mapDataSlabPrefixSize - hkeyElementsPrefixSize
+	minSize := minThreshold - uint64(nonRootMapDataSlabPrefixSize()) - hkeyElementsPrefixSize
 
 	rightElements := re.(*hkeyElements)
 
@@ -513,6 +529,12 @@ func (e *hkeyElements) LendToRight(re elements) error {
 		)
 	}
 
+	if e.narrow != rightElements.narrow {
+		return NewSlabRebalanceError(
+			fmt.Errorf("left slab digest size %d != right slab digest size %d", e.hkeySize(), rightElements.hkeySize()),
+		)
+	}
+
 	count := len(e.elems) + len(rightElements.elems)
 	size := e.Size() + rightElements.Size() - hkeyElementsPrefixSize*2
 
@@ -523,7 +545,7 @@ func (e *hkeyElements) LendToRight(re elements) error {
 
 	// Left elements size is as close to midPoint as possible while right elements size >= minThreshold
 	for i := len(e.elems) - 1; i >= 0; i-- {
-		elemSize := e.elems[i].Size() + digestSize
+		elemSize := e.elems[i].Size() + e.hkeySize()
 		if leftSize-elemSize < midPoint && size-leftSize >= uint32(minSize) {
 			break
 		}
@@ -562,7 +584,7 @@ func (e *hkeyElements) LendToRight(re elements) error {
 // BorrowFromRight rebalances slabs by moving elements from right slab to left slab.
 func (e *hkeyElements) BorrowFromRight(re elements) error {
 
-	minSize := minThreshold - mapDataSlabPrefixSize - hkeyElementsPrefixSize
+	minSize := minThreshold - uint64(nonRootMapDataSlabPrefixSize()) - hkeyElementsPrefixSize
 
 	rightElements := re.(*hkeyElements)
 
@@ -572,6 +594,12 @@ func (e *hkeyElements) BorrowFromRight(re elements) error {
 		)
 	}
 
+	if e.narrow != rightElements.narrow {
+		return NewSlabRebalanceError(
+			fmt.Errorf("left slab digest size %d != right slab digest size %d", e.hkeySize(), rightElements.hkeySize()),
+		)
+	}
+
 	size := e.Size() + rightElements.Size() - hkeyElementsPrefixSize*2
 
 	leftCount := len(e.elems)
@@ -580,7 +608,7 @@ func (e *hkeyElements) BorrowFromRight(re elements) error {
 	midPoint := (size + 1) >> 1
 
 	for _, elem := range rightElements.elems {
-		elemSize := elem.Size() + digestSize
+		elemSize := elem.Size() + e.hkeySize()
 		if leftSize+elemSize > midPoint {
 			if size-leftSize-elemSize >= uint32(minSize) {
 				// Include this element in left elements
@@ -622,14 +650,14 @@ func (e *hkeyElements) CanLendToLeft(size uint32) bool {
 		return false
 	}
 
-	minSize := minThreshold - mapDataSlabPrefixSize
+	minSize := minThreshold - uint64(nonRootMapDataSlabPrefixSize())
 	if e.Size()-size < uint32(minSize) {
 		return false
 	}
 
 	lendSize := uint32(0)
 	for i := range e.elems {
-		lendSize += e.elems[i].Size() + digestSize
+		lendSize += e.elems[i].Size() + e.hkeySize()
 		if e.Size()-lendSize < uint32(minSize) {
 			return false
 		}
@@ -649,14 +677,14 @@ func (e *hkeyElements) CanLendToRight(size uint32) bool {
 		return false
 	}
 
-	minSize := minThreshold - mapDataSlabPrefixSize
+	minSize := minThreshold - uint64(nonRootMapDataSlabPrefixSize())
 	if e.Size()-size < uint32(minSize) {
 		return false
 	}
 
 	lendSize := uint32(0)
 	for i := len(e.elems) - 1; i >= 0; i-- {
-		lendSize += e.elems[i].Size() + digestSize
+		lendSize += e.elems[i].Size() + e.hkeySize()
 		if e.Size()-lendSize < uint32(minSize) {
 			return false
 		}
@@ -19,8 +19,13 @@
 package atree
 
 import (
+	"bytes"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
+
+	"github.com/fxamacker/cbor/v2"
 )
 
 // singleElements
@@ -131,10 +136,10 @@ func (e *singleElements) Set(
 			existingKeyStorable := elem.key
 			existingValueStorable := elem.value
 
-			vs, err := value.Storable(storage, address, maxInlineMapValueSize(uint64(elem.key.ByteSize())))
+			vs, err := newValueStorable(storage, address, value, maxInlineMapValueSize(uint64(elem.key.ByteSize())))
 			if err != nil {
-				// Wrap err as external error (if needed) because err is returned by Value interface.
-				return nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get value's storable")
+				// Don't need to wrap error as external error because err is already categorized by newValueStorable().
+				return nil, nil, err
 			}
 
 			elem.value = vs
@@ -153,18 +158,120 @@ func (e *singleElements) Set(
 		}
 	}
 
-	// no matching key, append new element to the end.
+	// no matching key, insert new element ordered by encoded key.
 	newElem, err := newSingleElement(storage, address, key, value)
 	if err != nil {
 		// Don't need to wrap error as external error because err is already categorized by newSingleElement().
 		return nil, nil, err
 	}
-	e.elems = append(e.elems, newElem)
+
+	index, err := e.insertionIndex(storage, newElem.key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by singleElements.insertionIndex().
+		return nil, nil, err
+	}
+
+	e.elems = slices.Insert(e.elems, index, newElem)
 	e.size += newElem.size
 
 	return newElem.key, nil, nil
 }
 
+// insertionIndex returns index of new element with given key storable.
+//
+// Elements with the same digests at all levels are ordered by encoded
+// key in ascending lexicographical order, so iteration order of colliding
+// keys is deterministic and doesn't depend on history of inserts and
+// removes.  Key stored in separate slab is ordered by its encoded content,
+// not by its slab ID.  Since elements are ordered, index is found by
+// binary search, so only O(log n) existing keys are encoded (and
+// retrieved if stored in separate slab) for each insert.
+func (e *singleElements) insertionIndex(storage SlabStorage, ks Storable) (int, error) {
+	if len(e.elems) == 0 {
+		return 0, nil
+	}
+
+	b, err := encodeCollisionKey(storage, ks)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by encodeCollisionKey().
+		return 0, err
+	}
+
+	var searchErr error
+
+	// Find index of first element with encoded key greater than new key.
+	index := sort.Search(len(e.elems), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+
+		eb, err := encodeCollisionKey(storage, e.elems[i].key)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+
+		return bytes.Compare(b, eb) < 0
+	})
+
+	if searchErr != nil {
+		// Don't need to wrap error as external error because err is already categorized by encodeCollisionKey().
+		return 0, searchErr
+	}
+
+	return index, nil
+}
+
+// comparisonEncMode is used to encode storables for comparison,
+// such as ordering colliding elements by encoded key.
+var comparisonEncMode = func() cbor.EncMode {
+	encMode, err := cbor.EncOptions{}.EncMode()
+	if err != nil {
+		panic(NewEncodingError(err))
+	}
+	return encMode
+}()
+
+// encodeCollisionKey returns encoded key storable used to order colliding elements.
+// If key is stored in separate slab, content of the slab is encoded.
+func encodeCollisionKey(storage SlabStorage, ks Storable) ([]byte, error) {
+	if id, ok := ks.(SlabIDStorable); ok {
+		slab, found, err := storage.Retrieve(SlabID(id))
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+		}
+		if !found {
+			return nil, NewSlabNotFoundErrorf(SlabID(id), "failed to retrieve slab")
+		}
+		if storableSlab, ok := slab.(*StorableSlab); ok {
+			ks = storableSlab.storable
+		}
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by encodeStorableForComparison().
+	return encodeStorableForComparison(ks)
+}
+
+// encodeStorableForComparison returns encoded storable.
+func encodeStorableForComparison(s Storable) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, comparisonEncMode)
+
+	err := s.Encode(enc)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Storable interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to encode storable")
+	}
+
+	err = enc.CBOR.Flush()
+	if err != nil {
+		return nil, NewEncodingError(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
 func (e *singleElements) Remove(storage SlabStorage, digester Digester, level uint, _ Digest, comparator ValueComparator, key Value) (MapKey, MapValue, error) {
 
 	if level != digester.Levels() {
@@ -28,15 +28,63 @@ type MapExtraData struct {
 	TypeInfo TypeInfo
 	Count    uint64
 	Seed     uint64
+
+	// SchemaID is optional caller-supplied ID recorded with map
+	// (e.g. to identify ValueComparator and HashInputProvider used
+	// to build the map).  SchemaID 0 means no schema ID, and
+	// it isn't encoded.
+	SchemaID uint64
+
+	// narrowDigests is true if map uses 32-bit level 0 digests
+	// (see WithNarrowDigests).  It is encoded as flag in root slab head
+	// instead of in extra data.
+	narrowDigests bool
+
+	// singleDigestLevel is true if map uses only level 0 digest
+	// (see WithSingleDigestLevel).  It is encoded as digest levels
+	// in extra data.
+	singleDigestLevel bool
+
+	// digesterBuilderID identifies kind of digester builder map is
+	// created with (see digesterBuilderIDOf), so map isn't used with
+	// a different kind of digester builder.  Default digester builder
+	// ID 0 isn't encoded.
+	digesterBuilderID uint64
 }
 
 var _ ExtraData = &MapExtraData{}
 
-const mapExtraDataLength = 3
+const (
+	mapExtraDataLength                      = 3
+	mapExtraDataWithSchemaIDLength          = 4
+	mapExtraDataWithDigestLevelsLength      = 5
+	mapExtraDataWithDigesterBuilderIDLength = 6
+)
+
+const (
+	// defaultDigestLevelCount is encoded digest levels of map with default
+	// digest levels.  It is only encoded if digester builder ID is encoded.
+	defaultDigestLevelCount = 0
+
+	// singleDigestLevelCount is encoded digest levels of map with single digest level.
+	singleDigestLevelCount = 1
+)
 
 // newMapExtraDataFromData decodes CBOR array to extra data:
 //
 //	[type info, count, seed]
+//
+// or extra data with schema ID:
+//
+//	[type info, count, seed, schema ID]
+//
+// or extra data with digest levels (schema ID can be 0):
+//
+//	[type info, count, seed, schema ID, digest levels]
+//
+// or extra data with digester builder ID (schema ID and digest levels can be 0):
+//
+//	[type info, count, seed, schema ID, digest levels, digester builder ID]
 func newMapExtraDataFromData(
 	data []byte,
 	decMode cbor.DecMode,
@@ -63,12 +111,18 @@ func newMapExtraData(dec *cbor.StreamDecoder, decodeTypeInfo TypeInfoDecoder) (*
 		return nil, NewDecodingError(err)
 	}
 
-	if length != mapExtraDataLength {
+	if length != mapExtraDataLength &&
+		length != mapExtraDataWithSchemaIDLength &&
+		length != mapExtraDataWithDigestLevelsLength &&
+		length != mapExtraDataWithDigesterBuilderIDLength {
 		return nil, NewDecodingError(
 			fmt.Errorf(
-				"data has invalid length %d, want %d",
+				"data has invalid length %d, want %d, %d, %d, or %d",
 				length,
 				mapExtraDataLength,
+				mapExtraDataWithSchemaIDLength,
+				mapExtraDataWithDigestLevelsLength,
+				mapExtraDataWithDigesterBuilderIDLength,
 			))
 	}
 
@@ -88,10 +142,50 @@ func newMapExtraData(dec *cbor.StreamDecoder, decodeTypeInfo TypeInfoDecoder) (*
 		return nil, NewDecodingError(err)
 	}
 
+	var schemaID uint64
+	if length >= mapExtraDataWithSchemaIDLength {
+		schemaID, err = dec.DecodeUint64()
+		if err != nil {
+			return nil, NewDecodingError(err)
+		}
+		if schemaID == 0 && length == mapExtraDataWithSchemaIDLength {
+			return nil, NewDecodingError(fmt.Errorf("data has encoded schema ID 0"))
+		}
+	}
+
+	singleDigestLevel := false
+	if length >= mapExtraDataWithDigestLevelsLength {
+		digestLevels, err := dec.DecodeUint64()
+		if err != nil {
+			return nil, NewDecodingError(err)
+		}
+		switch {
+		case digestLevels == singleDigestLevelCount:
+			singleDigestLevel = true
+		case digestLevels == defaultDigestLevelCount && length == mapExtraDataWithDigesterBuilderIDLength:
+		default:
+			return nil, NewDecodingError(fmt.Errorf("data has digest levels %d, want %d", digestLevels, singleDigestLevelCount))
+		}
+	}
+
+	var digesterBuilderID uint64
+	if length == mapExtraDataWithDigesterBuilderIDLength {
+		digesterBuilderID, err = dec.DecodeUint64()
+		if err != nil {
+			return nil, NewDecodingError(err)
+		}
+		if digesterBuilderID == defaultDigesterBuilderID {
+			return nil, NewDecodingError(fmt.Errorf("data has encoded default digester builder ID %d", digesterBuilderID))
+		}
+	}
+
 	return &MapExtraData{
-		TypeInfo: typeInfo,
-		Count:    count,
-		Seed:     seed,
+		TypeInfo:          typeInfo,
+		Count:             count,
+		Seed:              seed,
+		SchemaID:          schemaID,
+		singleDigestLevel: singleDigestLevel,
+		digesterBuilderID: digesterBuilderID,
 	}, nil
 }
 
@@ -106,9 +200,31 @@ func (m *MapExtraData) Type() TypeInfo {
 // Encode encodes extra data as CBOR array:
 //
 //	[type info, count, seed]
+//
+// or extra data with non-zero schema ID:
+//
+//	[type info, count, seed, schema ID]
+//
+// or extra data of map with single digest level (schema ID can be 0):
+//
+//	[type info, count, seed, schema ID, digest levels]
+//
+// or extra data of map with non-default digester builder (schema ID and
+// digest levels can be 0):
+//
+//	[type info, count, seed, schema ID, digest levels, digester builder ID]
 func (m *MapExtraData) Encode(enc *Encoder, encodeTypeInfo encodeTypeInfo) error {
 
-	err := enc.CBOR.EncodeArrayHead(mapExtraDataLength)
+	length := mapExtraDataLength
+	if m.digesterBuilderID != defaultDigesterBuilderID {
+		length = mapExtraDataWithDigesterBuilderIDLength
+	} else if m.singleDigestLevel {
+		length = mapExtraDataWithDigestLevelsLength
+	} else if m.SchemaID != 0 {
+		length = mapExtraDataWithSchemaIDLength
+	}
+
+	err := enc.CBOR.EncodeArrayHead(uint64(length))
 	if err != nil {
 		return NewEncodingError(err)
 	}
@@ -129,6 +245,32 @@ func (m *MapExtraData) Encode(enc *Encoder, encodeTypeInfo encodeTypeInfo) error
 		return NewEncodingError(err)
 	}
 
+	if length >= mapExtraDataWithSchemaIDLength {
+		err = enc.CBOR.EncodeUint64(m.SchemaID)
+		if err != nil {
+			return NewEncodingError(err)
+		}
+	}
+
+	if length >= mapExtraDataWithDigestLevelsLength {
+		digestLevels := uint64(defaultDigestLevelCount)
+		if m.singleDigestLevel {
+			digestLevels = singleDigestLevelCount
+		}
+
+		err = enc.CBOR.EncodeUint64(digestLevels)
+		if err != nil {
+			return NewEncodingError(err)
+		}
+	}
+
+	if length == mapExtraDataWithDigesterBuilderIDLength {
+		err = enc.CBOR.EncodeUint64(m.digesterBuilderID)
+		if err != nil {
+			return NewEncodingError(err)
+		}
+	}
+
 	err = enc.CBOR.Flush()
 	if err != nil {
 		return NewEncodingError(err)
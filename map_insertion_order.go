@@ -0,0 +1,496 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"fmt"
+	"slices"
+)
+
+// WithInsertionOrderIndex maintains keys of map in given array in the order
+// they are first set, so IterateInsertionOrder can iterate map elements in
+// insertion order.  Array must be empty when map is created, and the same
+// array (e.g. loaded by NewArrayWithRootID) must be used when map is loaded
+// by NewMapWithRootID.  Caller is responsible for storing slab ID of array.
+//
+// Insertion order index stores each key a second time, so it roughly doubles
+// storage used by keys, and it adds array slabs to storage.  Set appends new
+// keys to the array, and Set and Remove find position of existing key in the
+// array by its digest.  Positions are only kept in memory, so they are built
+// from the array (digesting every key) on first Set or Remove after map is
+// created or loaded.
+//
+// Keys must not be containers (Array or OrderedMap) because the same key
+// can't be stored in both map and array.
+func WithInsertionOrderIndex(keys *Array) MapOption {
+	return mapOptionFunc(func(m *OrderedMap) {
+		m.insertionOrder = keys
+		m.insertionOrderPositions = nil
+	})
+}
+
+// InsertionOrderIndex returns array of keys in insertion order set by
+// WithInsertionOrderIndex, or nil if map doesn't have insertion order index.
+func (m *OrderedMap) InsertionOrderIndex() *Array {
+	return m.insertionOrder
+}
+
+// IterateInsertionOrder iterates map elements in the order keys are first set.
+// It returns InsertionOrderIndexError if map is created without WithInsertionOrderIndex.
+func (m *OrderedMap) IterateInsertionOrder(
+	comparator ValueComparator,
+	hip HashInputProvider,
+	fn MapEntryIterationFunc,
+) error {
+	if m.insertionOrder == nil {
+		return NewInsertionOrderIndexError(m.ValueID())
+	}
+
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
+
+	modCount := m.modCount
+
+	iterator, err := m.insertionOrder.ReadOnlyIterator()
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.ReadOnlyIterator().
+		return err
+	}
+
+	for {
+		key, err := iterator.Next()
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by ArrayIterator.Next().
+			return err
+		}
+		if key == nil {
+			return nil
+		}
+
+		value, err := m.Get(comparator, hip, key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.Get().
+			return err
+		}
+
+		resume, err := fn(key, value)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by MapEntryIterationFunc callback.
+			return wrapErrorAsExternalErrorIfNeeded(err)
+		}
+		if !resume {
+			return nil
+		}
+
+		err = m.checkModCount(modCount)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkModCount().
+			return err
+		}
+	}
+}
+
+// insertionOrderStorage returns storage of insertion order index,
+// or nil if map doesn't have insertion order index.
+func (m *OrderedMap) insertionOrderStorage() SlabStorage {
+	if m.insertionOrder == nil {
+		return nil
+	}
+	return m.insertionOrder.Storage
+}
+
+// insertionOrderPositions maps keys to their positions in insertion order
+// index, so keys are found without scanning the index.  Each key appended
+// to the index gets a sequence number, and position of key is the number
+// of keys in the index with smaller sequence numbers, which is counted by
+// Fenwick tree of key counts by sequence number.  It is only in memory,
+// and it is built from the index on first use after map is created or
+// loaded.
+type insertionOrderPositions struct {
+	// seqs maps level 0 digest of key to sequence numbers of keys with the digest.
+	seqs map[Digest][]uint64
+
+	// tree is 1-based Fenwick tree of key counts by sequence number.
+	// Sequence number n is at index n+1.
+	tree []uint64
+
+	// count is number of keys in the index.
+	count uint64
+}
+
+func newInsertionOrderPositions() *insertionOrderPositions {
+	return &insertionOrderPositions{
+		seqs: make(map[Digest][]uint64),
+		tree: []uint64{0},
+	}
+}
+
+// prefixCount returns number of keys with sequence number less than seq,
+// which is position of key with sequence number seq in the index.
+func (p *insertionOrderPositions) prefixCount(seq uint64) uint64 {
+	var n uint64
+	for i := seq; i > 0; i -= i & -i {
+		n += p.tree[i]
+	}
+	return n
+}
+
+// add appends key with given digest to the end of the index.
+func (p *insertionOrderPositions) add(d Digest) {
+	seq := uint64(len(p.tree) - 1)
+
+	// Fenwick tree node at index i (i = seq+1) covers sequence numbers
+	// (i - lowbit(i), i], so it is computed from prefix counts.
+	i := seq + 1
+	p.tree = append(p.tree, 1+p.prefixCount(seq)-p.prefixCount(i-(i&-i)))
+
+	p.seqs[d] = append(p.seqs[d], seq)
+	p.count++
+}
+
+// remove removes key with given digest and sequence number from the index.
+func (p *insertionOrderPositions) remove(d Digest, seq uint64) {
+	for i := seq + 1; i < uint64(len(p.tree)); i += i & -i {
+		p.tree[i]--
+	}
+
+	seqs := p.seqs[d]
+	if len(seqs) == 1 {
+		delete(p.seqs, d)
+	} else {
+		p.seqs[d] = slices.DeleteFunc(seqs, func(n uint64) bool { return n == seq })
+	}
+	p.count--
+}
+
+// sparse returns true if most sequence numbers belong to removed keys,
+// so positions should be rebuilt to release memory.
+func (p *insertionOrderPositions) sparse() bool {
+	return uint64(len(p.tree)) > 2*p.count+1024
+}
+
+// insertionOrderKeyDigest returns level 0 digest of key, which is used
+// to find key in insertion order positions.
+func (m *OrderedMap) insertionOrderKeyDigest(hip HashInputProvider, key Value) (Digest, error) {
+	keyDigest, err := m.digestKey(hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+		return 0, err
+	}
+	defer putDigester(keyDigest)
+
+	d, err := keyDigest.Digest(0)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Digester interface.
+		return 0, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get map key digest at level 0")
+	}
+
+	return d, nil
+}
+
+// getInsertionOrderPositions returns insertion order positions, which are
+// built from insertion order index if they aren't built yet.
+func (m *OrderedMap) getInsertionOrderPositions(hip HashInputProvider) (*insertionOrderPositions, error) {
+	if m.insertionOrderPositions != nil {
+		return m.insertionOrderPositions, nil
+	}
+
+	positions := newInsertionOrderPositions()
+
+	iterator, err := m.insertionOrder.ReadOnlyIterator()
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.ReadOnlyIterator().
+		return nil, err
+	}
+
+	for {
+		key, err := iterator.Next()
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by ArrayIterator.Next().
+			return nil, err
+		}
+		if key == nil {
+			break
+		}
+
+		d, err := m.insertionOrderKeyDigest(hip, key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.insertionOrderKeyDigest().
+			return nil, err
+		}
+
+		positions.add(d)
+	}
+
+	m.insertionOrderPositions = positions
+
+	return positions, nil
+}
+
+// insertionOrderKey is position of key in insertion order index.
+type insertionOrderKey struct {
+	digest   Digest
+	seq      uint64
+	position uint64
+	found    bool
+}
+
+// findInsertionOrder returns position of key in insertion order index.
+// Only keys with the same level 0 digest are compared.
+func (m *OrderedMap) findInsertionOrder(comparator ValueComparator, hip HashInputProvider, key Value) (insertionOrderKey, error) {
+	if m.insertionOrder == nil {
+		return insertionOrderKey{}, nil
+	}
+
+	positions, err := m.getInsertionOrderPositions(hip)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.getInsertionOrderPositions().
+		return insertionOrderKey{}, err
+	}
+
+	d, err := m.insertionOrderKeyDigest(hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.insertionOrderKeyDigest().
+		return insertionOrderKey{}, err
+	}
+
+	for _, seq := range positions.seqs[d] {
+		position := positions.prefixCount(seq)
+
+		storable, err := m.insertionOrder.root.Get(m.insertionOrder.Storage, position)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by ArraySlab.Get().
+			return insertionOrderKey{}, err
+		}
+
+		equal, err := comparator(m.insertionOrder.Storage, key, storable)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by ValueComparator callback.
+			return insertionOrderKey{}, wrapErrorfAsExternalErrorIfNeeded(err, "failed to compare keys")
+		}
+		if equal {
+			return insertionOrderKey{digest: d, seq: seq, position: position, found: true}, nil
+		}
+	}
+
+	return insertionOrderKey{digest: d}, nil
+}
+
+// appendInsertionOrder appends key not found in insertion order index,
+// with slab operations performed through storage.
+func (m *OrderedMap) appendInsertionOrder(storage SlabStorage, k insertionOrderKey, key Value) error {
+	if m.insertionOrder == nil {
+		return nil
+	}
+
+	err := m.insertionOrder.insert(storage, m.insertionOrder.Count(), key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.insert().
+		return err
+	}
+
+	m.insertionOrderPositions.add(k.digest)
+
+	return nil
+}
+
+// rollbackAppendInsertionOrder removes key appended by appendInsertionOrder
+// when map operation fails after key is appended.
+func (m *OrderedMap) rollbackAppendInsertionOrder(k insertionOrderKey) error {
+	if m.insertionOrder == nil {
+		return nil
+	}
+
+	storable, err := m.insertionOrder.Remove(m.insertionOrder.Count() - 1)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.Remove().
+		return err
+	}
+
+	positions := m.insertionOrderPositions
+	positions.remove(k.digest, uint64(len(positions.tree)-2))
+
+	// Don't need to wrap error as external error because err is already categorized by removeExternalKeyStorable().
+	return removeExternalKeyStorable(m.insertionOrder.Storage, storable)
+}
+
+// setInsertionOrder updates insertion order index before key is set in map.
+// It appends key not found in the index, or replaces key found in the index
+// if map replaces keys on set.  It returns replaced storable, whose slab
+// (if stored externally) is removed by commitSetInsertionOrder after map is
+// updated, so replaced key can be restored by rollbackSetInsertionOrder.
+func (m *OrderedMap) setInsertionOrder(storage SlabStorage, k insertionOrderKey, key Value) (Storable, error) {
+	if !k.found {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.appendInsertionOrder().
+		return nil, m.appendInsertionOrder(storage, k, key)
+	}
+
+	if !m.keyReplacementOnSet {
+		return nil, nil
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by Array.setWithStorage().
+	return m.insertionOrder.setWithStorage(storage, k.position, key)
+}
+
+// rollbackSetInsertionOrder restores insertion order index updated by
+// setInsertionOrder when map operation fails.
+func (m *OrderedMap) rollbackSetInsertionOrder(storage SlabStorage, k insertionOrderKey, replacedStorable Storable) error {
+	if !k.found {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.rollbackAppendInsertionOrder().
+		return m.rollbackAppendInsertionOrder(k)
+	}
+
+	if replacedStorable == nil {
+		return nil
+	}
+
+	key, err := replacedStorable.StoredValue(m.insertionOrder.Storage)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Storable interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to get storable's stored value")
+	}
+
+	storable, err := m.insertionOrder.setWithStorage(storage, k.position, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.setWithStorage().
+		return err
+	}
+
+	// Slab of replaced key isn't referenced after its value is set again.
+	err = removeExternalKeyStorable(storage, replacedStorable)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by removeExternalKeyStorable().
+		return err
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by removeExternalKeyStorable().
+	return removeExternalKeyStorable(storage, storable)
+}
+
+// commitSetInsertionOrder removes slab of key replaced by setInsertionOrder
+// after map is updated.
+func (m *OrderedMap) commitSetInsertionOrder(storage SlabStorage, replacedStorable Storable) error {
+	if replacedStorable == nil {
+		return nil
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by removeExternalKeyStorable().
+	return removeExternalKeyStorable(storage, replacedStorable)
+}
+
+// removeInsertionOrder removes key found in insertion order index before
+// key is removed from map.  It returns removed storable, whose slab (if
+// stored externally) is removed by commitRemoveInsertionOrder after key is
+// removed from map, so key can be restored by rollbackRemoveInsertionOrder.
+func (m *OrderedMap) removeInsertionOrder(k insertionOrderKey) (Storable, error) {
+	if !k.found {
+		return nil, nil
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by Array.Remove().
+	return m.insertionOrder.Remove(k.position)
+}
+
+// rollbackRemoveInsertionOrder inserts key removed by removeInsertionOrder
+// back to its position when map operation fails.
+func (m *OrderedMap) rollbackRemoveInsertionOrder(k insertionOrderKey, removedStorable Storable) error {
+	if !k.found {
+		return nil
+	}
+
+	key, err := removedStorable.StoredValue(m.insertionOrder.Storage)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Storable interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to get storable's stored value")
+	}
+
+	err = m.insertionOrder.insert(m.insertionOrder.Storage, k.position, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.insert().
+		return err
+	}
+
+	// Slab of removed key isn't referenced after its value is inserted again.
+	// Don't need to wrap error as external error because err is already categorized by removeExternalKeyStorable().
+	return removeExternalKeyStorable(m.insertionOrder.Storage, removedStorable)
+}
+
+// commitRemoveInsertionOrder removes position and slab of key removed by
+// removeInsertionOrder after key is removed from map.
+func (m *OrderedMap) commitRemoveInsertionOrder(k insertionOrderKey, removedStorable Storable) error {
+	if m.insertionOrder == nil {
+		return nil
+	}
+
+	if !k.found {
+		return NewSlabDataErrorf("removed key isn't found in insertion order index")
+	}
+
+	positions := m.insertionOrderPositions
+	positions.remove(k.digest, k.seq)
+	if positions.sparse() {
+		// Rebuild positions on next use to release memory of removed keys.
+		m.insertionOrderPositions = nil
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by removeExternalKeyStorable().
+	return removeExternalKeyStorable(m.insertionOrder.Storage, removedStorable)
+}
+
+// clearInsertionOrder removes all keys from insertion order index.
+func (m *OrderedMap) clearInsertionOrder() error {
+	if m.insertionOrder == nil {
+		return nil
+	}
+
+	m.insertionOrderPositions = nil
+
+	var removeErr error
+	err := m.insertionOrder.PopIterate(func(storable Storable) {
+		if removeErr == nil {
+			removeErr = removeExternalKeyStorable(m.insertionOrder.Storage, storable)
+		}
+	})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by Array.PopIterate().
+		return err
+	}
+
+	return removeErr
+}
+
+// removeExternalKeyStorable removes slab of key stored externally
+// in map or in insertion order index.
+func removeExternalKeyStorable(storage SlabStorage, storable Storable) error {
+	id, ok := storable.(SlabIDStorable)
+	if !ok {
+		return nil
+	}
+
+	err := storage.Remove(SlabID(id))
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", SlabID(id)))
+	}
+
+	return nil
+}
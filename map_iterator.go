@@ -18,7 +18,10 @@
 
 package atree
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
 type MapIterator interface {
 	CanMutate() bool
@@ -27,13 +30,34 @@ type MapIterator interface {
 	NextValue() (Value, error)
 }
 
+// ClosableMapIterator is implemented by map iterators returned by
+// OrderedMap.Iterator and OrderedMap.ReadOnlyIterator.
+type ClosableMapIterator interface {
+	MapIterator
+
+	// Close returns iterator to pool, so it can be reused by later
+	// Iterator and ReadOnlyIterator calls.  Calling Close is optional,
+	// but it reduces allocations when many maps are iterated.
+	// Calling Close again is no-op.  Other iterator functions must
+	// not be used after Close: they return IteratorClosedError until
+	// iterator is reused by another Iterator or ReadOnlyIterator call.
+	Close()
+}
+
+// closeMapIterator closes iterator if it is ClosableMapIterator.
+func closeMapIterator(iterator MapIterator) {
+	if i, ok := iterator.(ClosableMapIterator); ok {
+		i.Close()
+	}
+}
+
 // Empty map iterator
 
 type emptyMapIterator struct {
 	readOnly bool
 }
 
-var _ MapIterator = &emptyMapIterator{}
+var _ ClosableMapIterator = &emptyMapIterator{}
 
 var emptyMutableMapIterator = &emptyMapIterator{readOnly: false}
 var emptyReadOnlyMapIterator = &emptyMapIterator{readOnly: true}
@@ -54,22 +78,66 @@ func (*emptyMapIterator) NextValue() (Value, error) {
 	return nil, nil
 }
 
+// Close is no-op because empty map iterators are shared.
+func (*emptyMapIterator) Close() {
+}
+
 // Mutable map iterator
 
 type mutableMapIterator struct {
-	m          *OrderedMap
-	comparator ValueComparator
-	hip        HashInputProvider
-	nextKey    Value
+	m                  *OrderedMap
+	comparator         ValueComparator
+	hip                HashInputProvider
+	nextKey            Value
+	structuralModCount uint64 // map's structuralModCount when iterator is created
 }
 
-var _ MapIterator = &mutableMapIterator{}
+var _ ClosableMapIterator = &mutableMapIterator{}
+
+var mutableMapIteratorPool = sync.Pool{
+	New: func() any {
+		return new(mutableMapIterator)
+	},
+}
+
+func newMutableMapIterator(m *OrderedMap, comparator ValueComparator, hip HashInputProvider, nextKey Value) *mutableMapIterator {
+	i := mutableMapIteratorPool.Get().(*mutableMapIterator)
+	*i = mutableMapIterator{
+		m:                  m,
+		comparator:         comparator,
+		hip:                hip,
+		nextKey:            nextKey,
+		structuralModCount: m.structuralModCount,
+	}
+	return i
+}
+
+// Close resets iterator so pooled iterator doesn't reference
+// map or elements, and returns iterator to pool.
+func (i *mutableMapIterator) Close() {
+	if i.m == nil {
+		// Iterator is already closed.
+		return
+	}
+	*i = mutableMapIterator{}
+	mutableMapIteratorPool.Put(i)
+}
 
 func (i *mutableMapIterator) CanMutate() bool {
 	return true
 }
 
 func (i *mutableMapIterator) Next() (Value, Value, error) {
+	if i.m == nil {
+		return nil, nil, NewIteratorClosedError()
+	}
+
+	err := i.m.checkStructuralModCount(i.structuralModCount)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkStructuralModCount().
+		return nil, nil, err
+	}
+
 	if i.nextKey == nil {
 		// No more elements.
 		return nil, nil, nil
@@ -88,6 +156,16 @@ func (i *mutableMapIterator) Next() (Value, Value, error) {
 }
 
 func (i *mutableMapIterator) NextKey() (Value, error) {
+	if i.m == nil {
+		return nil, NewIteratorClosedError()
+	}
+
+	err := i.m.checkStructuralModCount(i.structuralModCount)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkStructuralModCount().
+		return nil, err
+	}
+
 	if i.nextKey == nil {
 		// No more elements.
 		return nil, nil
@@ -106,6 +184,16 @@ func (i *mutableMapIterator) NextKey() (Value, error) {
 }
 
 func (i *mutableMapIterator) NextValue() (Value, error) {
+	if i.m == nil {
+		return nil, NewIteratorClosedError()
+	}
+
+	err := i.m.checkStructuralModCount(i.structuralModCount)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkStructuralModCount().
+		return nil, err
+	}
+
 	if i.nextKey == nil {
 		// No more elements.
 		return nil, nil
@@ -130,65 +218,144 @@ type ReadOnlyMapIteratorMutationCallback func(mutatedValue Value)
 type readOnlyMapIterator struct {
 	m                     *OrderedMap
 	nextDataSlabID        SlabID
-	elemIterator          *mapElementIterator
+	elemIterator          *mapElementIterator // points to dataSlabIterator or nil
+	dataSlabIterator      mapElementIterator  // element iterator of current data slab
 	keyMutationCallback   ReadOnlyMapIteratorMutationCallback
 	valueMutationCallback ReadOnlyMapIteratorMutationCallback
+	modCount              uint64 // map's modCount when iterator is created
+
+	// pendingKey and pendingValue are storables of element which
+	// failed to be returned by Next, so Next can be retried.
+	pendingKey   Storable
+	pendingValue Storable
 }
 
 // defaultReadOnlyMapIteratorMutatinCallback is no-op.
 var defaultReadOnlyMapIteratorMutatinCallback ReadOnlyMapIteratorMutationCallback = func(Value) {}
 
-var _ MapIterator = &readOnlyMapIterator{}
+var _ ClosableMapIterator = &readOnlyMapIterator{}
+
+var readOnlyMapIteratorPool = sync.Pool{
+	New: func() any {
+		return new(readOnlyMapIterator)
+	},
+}
+
+func newReadOnlyMapIterator(
+	m *OrderedMap,
+	dataSlab *MapDataSlab,
+	keyMutationCallback ReadOnlyMapIteratorMutationCallback,
+	valueMutationCallback ReadOnlyMapIteratorMutationCallback,
+) *readOnlyMapIterator {
+	i := readOnlyMapIteratorPool.Get().(*readOnlyMapIterator)
+	*i = readOnlyMapIterator{
+		m:              m,
+		nextDataSlabID: dataSlab.next,
+		dataSlabIterator: mapElementIterator{
+			storage:  m.Storage,
+			elements: dataSlab.elements,
+		},
+		keyMutationCallback:   keyMutationCallback,
+		valueMutationCallback: valueMutationCallback,
+		modCount:              m.modCount,
+	}
+	i.elemIterator = &i.dataSlabIterator
+	return i
+}
+
+// Close resets iterator so pooled iterator doesn't reference
+// map or elements, and returns iterator to pool.
+func (i *readOnlyMapIterator) Close() {
+	if i.m == nil {
+		// Iterator is already closed.
+		return
+	}
+	*i = readOnlyMapIterator{}
+	readOnlyMapIteratorPool.Put(i)
+}
 
 func (i *readOnlyMapIterator) setMutationCallback(key, value Value) {
 
+	// Parent updaters capture map and mutation callbacks instead of
+	// iterator because iterator can be reused by another map after Close.
+	m := i.m
+
 	unwrappedKey, _ := unwrapValue(key)
 
 	if k, ok := unwrappedKey.(mutableValueNotifier); ok {
-		k.setParentUpdater(func() (found bool, err error) {
-			i.keyMutationCallback(key)
-			return true, NewReadOnlyIteratorElementMutationError(i.m.ValueID(), k.ValueID())
-		})
+		if m.readOnly {
+			setReadOnly(k)
+		} else {
+			keyMutationCallback := i.keyMutationCallback
+			k.setParentUpdater(func() (found bool, err error) {
+				keyMutationCallback(key)
+				return true, NewReadOnlyIteratorElementMutationError(m.ValueID(), k.ValueID())
+			})
+		}
 	}
 
 	unwrappedValue, _ := unwrapValue(value)
 
 	if v, ok := unwrappedValue.(mutableValueNotifier); ok {
-		v.setParentUpdater(func() (found bool, err error) {
-			i.valueMutationCallback(value)
-			return true, NewReadOnlyIteratorElementMutationError(i.m.ValueID(), v.ValueID())
-		})
+		if m.readOnly {
+			setReadOnly(v)
+		} else {
+			valueMutationCallback := i.valueMutationCallback
+			v.setParentUpdater(func() (found bool, err error) {
+				valueMutationCallback(value)
+				return true, NewReadOnlyIteratorElementMutationError(m.ValueID(), v.ValueID())
+			})
+		}
 	}
 }
 
 func (i *readOnlyMapIterator) Next() (key Value, value Value, err error) {
-	if i.elemIterator == nil {
-		if i.nextDataSlabID == SlabIDUndefined {
-			return nil, nil, nil
+	if i.m == nil {
+		return nil, nil, NewIteratorClosedError()
+	}
+
+	err = i.m.checkModCount(i.modCount)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkModCount().
+		return nil, nil, err
+	}
+
+	var ks, vs Storable
+
+	if i.pendingKey != nil {
+		ks, vs = i.pendingKey, i.pendingValue
+		i.pendingKey, i.pendingValue = nil, nil
+	} else {
+		if i.elemIterator == nil {
+			if i.nextDataSlabID == SlabIDUndefined {
+				return nil, nil, nil
+			}
+
+			err = i.advance()
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by MapIterator.advance().
+				return nil, nil, err
+			}
 		}
 
-		err = i.advance()
+		ks, vs, err = i.elemIterator.next()
 		if err != nil {
-			// Don't need to wrap error as external error because err is already categorized by MapIterator.advance().
+			// Don't need to wrap error as external error because err is already categorized by MapElementIterator.Next().
 			return nil, nil, err
 		}
 	}
 
-	var ks, vs Storable
-	ks, vs, err = i.elemIterator.next()
-	if err != nil {
-		// Don't need to wrap error as external error because err is already categorized by MapElementIterator.Next().
-		return nil, nil, err
-	}
 	if ks != nil {
 		key, err = ks.StoredValue(i.m.Storage)
 		if err != nil {
+			i.pendingKey, i.pendingValue = ks, vs
 			// Wrap err as external error (if needed) because err is returned by Storable interface.
 			return nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get map key's stored value")
 		}
 
 		value, err = vs.StoredValue(i.m.Storage)
 		if err != nil {
+			i.pendingKey, i.pendingValue = ks, vs
 			// Wrap err as external error (if needed) because err is returned by Storable interface.
 			return nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get map value's stored value")
 		}
@@ -205,6 +372,16 @@ func (i *readOnlyMapIterator) Next() (key Value, value Value, err error) {
 }
 
 func (i *readOnlyMapIterator) NextKey() (key Value, err error) {
+	if i.m == nil {
+		return nil, NewIteratorClosedError()
+	}
+
+	err = i.m.checkModCount(i.modCount)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkModCount().
+		return nil, err
+	}
+
 	if i.elemIterator == nil {
 		if i.nextDataSlabID == SlabIDUndefined {
 			return nil, nil
@@ -242,6 +419,16 @@ func (i *readOnlyMapIterator) NextKey() (key Value, err error) {
 }
 
 func (i *readOnlyMapIterator) NextValue() (value Value, err error) {
+	if i.m == nil {
+		return nil, NewIteratorClosedError()
+	}
+
+	err = i.m.checkModCount(i.modCount)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.checkModCount().
+		return nil, err
+	}
+
 	if i.elemIterator == nil {
 		if i.nextDataSlabID == SlabIDUndefined {
 			return nil, nil
@@ -295,10 +482,11 @@ func (i *readOnlyMapIterator) advance() error {
 
 	i.nextDataSlabID = dataSlab.next
 
-	i.elemIterator = &mapElementIterator{
+	i.dataSlabIterator = mapElementIterator{
 		storage:  i.m.Storage,
 		elements: dataSlab.elements,
 	}
+	i.elemIterator = &i.dataSlabIterator
 
 	return nil
 }
@@ -582,11 +770,16 @@ func (i *MapLoadedValueIterator) Next() (Value, Value, error) {
 
 type MapEntryIterationFunc func(Value, Value) (resume bool, err error)
 
-func iterateMap(iterator MapIterator, fn MapEntryIterationFunc) error {
+func iterateMap(iterator MapIterator, fn MapEntryIterationFunc, config iterationConfig) error {
+	defer closeMapIterator(iterator)
+
 	var err error
 	var key, value Value
 	for {
 		key, value, err = iterator.Next()
+		for retry := 0; err != nil && config.shouldRetry(err, retry); retry++ {
+			key, value, err = iterator.Next()
+		}
 		if err != nil {
 			// Don't need to wrap error as external error because err is already categorized by MapIterator.Next().
 			return err
@@ -608,6 +801,8 @@ func iterateMap(iterator MapIterator, fn MapEntryIterationFunc) error {
 type MapElementIterationFunc func(Value) (resume bool, err error)
 
 func iterateMapKeys(iterator MapIterator, fn MapElementIterationFunc) error {
+	defer closeMapIterator(iterator)
+
 	var err error
 	var key Value
 	for {
@@ -631,6 +826,8 @@ func iterateMapKeys(iterator MapIterator, fn MapElementIterationFunc) error {
 }
 
 func iterateMapValues(iterator MapIterator, fn MapElementIterationFunc) error {
+	defer closeMapIterator(iterator)
+
 	var err error
 	var value Value
 	for {
@@ -515,9 +515,9 @@ func (m *MapMetaDataSlab) MergeOrRebalanceChildSlab(
 	if leftSib == nil {
 
 		// Merge with right
-		err := child.Merge(rightSib)
+		err := mergeMapSlabs(storage, child, rightSib)
 		if err != nil {
-			// Don't need to wrap error as external error because err is already categorized by MapSlab.Merge().
+			// Don't need to wrap error as external error because err is already categorized by mergeMapSlabs().
 			return err
 		}
 
@@ -557,9 +557,9 @@ func (m *MapMetaDataSlab) MergeOrRebalanceChildSlab(
 	if rightSib == nil {
 
 		// Merge with left
-		err := leftSib.Merge(child)
+		err := mergeMapSlabs(storage, leftSib, child)
 		if err != nil {
-			// Don't need to wrap error as external error because err is already categorized by MapSlab.Merge().
+			// Don't need to wrap error as external error because err is already categorized by mergeMapSlabs().
 			return err
 		}
 
@@ -593,9 +593,9 @@ func (m *MapMetaDataSlab) MergeOrRebalanceChildSlab(
 
 	// Merge with smaller sib
 	if leftSib.ByteSize() < rightSib.ByteSize() {
-		err := leftSib.Merge(child)
+		err := mergeMapSlabs(storage, leftSib, child)
 		if err != nil {
-			// Don't need to wrap error as external error because err is already categorized by MapSlab.Merge().
+			// Don't need to wrap error as external error because err is already categorized by mergeMapSlabs().
 			return err
 		}
 
@@ -628,9 +628,9 @@ func (m *MapMetaDataSlab) MergeOrRebalanceChildSlab(
 	} else {
 		// leftSib.ByteSize() > rightSib.ByteSize
 
-		err := child.Merge(rightSib)
+		err := mergeMapSlabs(storage, child, rightSib)
 		if err != nil {
-			// Don't need to wrap error as external error because err is already categorized by MapSlab.Merge().
+			// Don't need to wrap error as external error because err is already categorized by mergeMapSlabs().
 			return err
 		}
 
@@ -668,6 +668,27 @@ func (m *MapMetaDataSlab) MergeOrRebalanceChildSlab(
 	}
 }
 
+// mergeMapSlabs merges right slab into left slab.  If merged slabs are
+// data slabs and prev slab ID is enabled, prev slab ID of left slab's
+// new next slab is updated.
+func mergeMapSlabs(storage SlabStorage, left MapSlab, right MapSlab) error {
+	err := left.Merge(right)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by MapSlab.Merge().
+		return err
+	}
+
+	if dataSlab, ok := left.(*MapDataSlab); ok {
+		err = dataSlab.updateNextSlabPrev(storage)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by MapDataSlab.updateNextSlabPrev().
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (m *MapMetaDataSlab) Merge(slab Slab) error {
 	rightSlab := slab.(*MapMetaDataSlab)
 
@@ -763,20 +784,27 @@ func (m MapMetaDataSlab) IsFull() bool {
 }
 
 func (m MapMetaDataSlab) IsUnderflow() (uint32, bool) {
+	var underflowSize uint32
 	if uint32(minThreshold) > m.header.size {
-		return uint32(minThreshold) - m.header.size, true
+		underflowSize = uint32(minThreshold) - m.header.size
+	}
+	// Slab with fewer than min children is underflow even if its size isn't.
+	if n := len(m.childrenHeaders); n < minMapMetaDataSlabFanout {
+		underflowSize = max(underflowSize, uint32(minMapMetaDataSlabFanout-n)*mapSlabHeaderSize)
 	}
-	return 0, false
+	return underflowSize, underflowSize > 0
 }
 
 func (m *MapMetaDataSlab) CanLendToLeft(size uint32) bool {
 	n := uint32(math.Ceil(float64(size) / mapSlabHeaderSize))
-	return m.header.size-mapSlabHeaderSize*n > uint32(minThreshold)
+	return m.header.size-mapSlabHeaderSize*n > uint32(minThreshold) &&
+		len(m.childrenHeaders)-int(n) >= minMapMetaDataSlabFanout
 }
 
 func (m *MapMetaDataSlab) CanLendToRight(size uint32) bool {
 	n := uint32(math.Ceil(float64(size) / mapSlabHeaderSize))
-	return m.header.size-mapSlabHeaderSize*n > uint32(minThreshold)
+	return m.header.size-mapSlabHeaderSize*n > uint32(minThreshold) &&
+		len(m.childrenHeaders)-int(n) >= minMapMetaDataSlabFanout
 }
 
 // Inline operations
@@ -217,6 +217,8 @@ func newMapMetaDataSlabFromDataV1(
 			// Don't need to wrap error as external error because err is already categorized by newMapExtraDataFromData().
 			return nil, err
 		}
+
+		extraData.narrowDigests = h.hasNarrowDigests()
 	}
 
 	// Check minimum data length after version, flag, and extra data are processed
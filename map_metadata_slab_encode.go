@@ -50,6 +50,10 @@ func (m *MapMetaDataSlab) Encode(enc *Encoder) error {
 
 	if m.extraData != nil {
 		h.setRoot()
+
+		if m.extraData.narrowDigests {
+			h.setHasNarrowDigests()
+		}
 	}
 
 	// Write head (version and flag)
@@ -38,6 +38,12 @@ func VerifyMapSerialization(
 	decodeTypeInfo TypeInfoDecoder,
 	compare StorableComparator,
 ) error {
+	// Retrieve root slab of lazily created map.
+	err := m.loadRoot()
+	if err != nil {
+		return err
+	}
+
 	// Skip verification of inlined map serialization.
 	if m.Inlined() {
 		return nil
@@ -185,6 +191,11 @@ func (v *serializationVerifier) mapDataSlabEqual(expected, actual *MapDataSlab)
 		return NewFatalError(fmt.Errorf("next %d is wrong, want %d", actual.next, expected.next))
 	}
 
+	// Compare prev
+	if expected.prev != actual.prev {
+		return NewFatalError(fmt.Errorf("prev %d is wrong, want %d", actual.prev, expected.prev))
+	}
+
 	// Compare anySize flag
 	if expected.anySize != actual.anySize {
 		return NewFatalError(fmt.Errorf("anySize %t is wrong, want %t", actual.anySize, expected.anySize))
@@ -455,5 +466,13 @@ func mapExtraDataEqual(expected, actual *MapExtraData, actualDecodedFromCompactM
 		}
 	}
 
+	if expected.singleDigestLevel != actual.singleDigestLevel {
+		return NewFatalError(fmt.Errorf("map extra data single digest level %t is wrong, want %t", actual.singleDigestLevel, expected.singleDigestLevel))
+	}
+
+	if expected.digesterBuilderID != actual.digesterBuilderID {
+		return NewFatalError(fmt.Errorf("map extra data digester builder ID %d is wrong, want %d", actual.digesterBuilderID, expected.digesterBuilderID))
+	}
+
 	return nil
 }
@@ -23,6 +23,9 @@ package atree
 const (
 	digestSize = 8
 
+	// Size of level 0 digest in data slab of map created with WithNarrowDigests.
+	narrowDigestSize = 4
+
 	// Encoded size of single element prefix size: CBOR array header (1 byte)
 	singleElementPrefixSize = 1
 
@@ -57,6 +60,7 @@ const (
 	mapMetaDataSlabPrefixSize = versionAndFlagSize + SlabAddressLength + 2
 
 	// version (1 byte) + flag (1 byte) + next id (16 bytes)
+	// NOTE: prev id (16 bytes) is included by nonRootMapDataSlabPrefixSize() if it is enabled.
 	mapDataSlabPrefixSize = versionAndFlagSize + SlabIDLength
 
 	// version (1 byte) + flag (1 byte)
@@ -126,3 +126,23 @@ func firstMapDataSlab(storage SlabStorage, slab MapSlab) (*MapDataSlab, error) {
 		return nil, NewUnreachableError()
 	}
 }
+
+func lastMapDataSlab(storage SlabStorage, slab MapSlab) (*MapDataSlab, error) {
+	switch slab := slab.(type) {
+	case *MapDataSlab:
+		return slab, nil
+
+	case *MapMetaDataSlab:
+		lastChildID := slab.childrenHeaders[len(slab.childrenHeaders)-1].slabID
+		lastChild, err := getMapSlab(storage, lastChildID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return nil, err
+		}
+		// Don't need to wrap error as external error because err is already categorized by lastMapDataSlab().
+		return lastMapDataSlab(storage, lastChild)
+
+	default:
+		return nil, NewUnreachableError()
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"fmt"
+	"slices"
+)
+
+// SetReturningSlabDelta is like Set, but also returns number of slabs
+// created and removed by this operation (e.g. splitting slab creates
+// slabs and merging slabs removes slabs).  Slabs of map and its insertion
+// order index are counted.  Slab created and removed within the same
+// operation isn't counted.
+func (m *OrderedMap) SetReturningSlabDelta(
+	comparator ValueComparator,
+	hip HashInputProvider,
+	key Value,
+	value Value,
+) (existingStorable Storable, slabsCreated int, slabsRemoved int, err error) {
+	// Counter is passed to slab operations, so m.Storage isn't changed
+	// and values loaded during this operation don't keep the counter.
+	counter := newSlabDeltaCounter(m.Storage)
+	defer counter.stop()
+
+	var insertionOrderCounter *slabDeltaCounter
+	var insertionOrderStorage SlabStorage
+
+	if m.insertionOrder != nil {
+		if m.insertionOrder.Storage == m.Storage {
+			insertionOrderStorage = counter
+		} else {
+			insertionOrderCounter = newSlabDeltaCounter(m.insertionOrder.Storage)
+			defer insertionOrderCounter.stop()
+
+			insertionOrderStorage = insertionOrderCounter
+		}
+	}
+
+	existingStorable, err = m.setWithStorage(counter, insertionOrderStorage, comparator, hip, key, value)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.setWithStorage().
+		return nil, 0, 0, err
+	}
+
+	slabsCreated, slabsRemoved = counter.delta()
+
+	if insertionOrderCounter != nil {
+		created, removed := insertionOrderCounter.delta()
+		slabsCreated += created
+		slabsRemoved += removed
+	}
+
+	return existingStorable, slabsCreated, slabsRemoved, nil
+}
+
+// slabDeltaCounter is SlabStorage which records slabs created
+// and removed through it.
+type slabDeltaCounter struct {
+	SlabStorage
+
+	// generated contains slab IDs generated through this storage.
+	// Slab with generated ID is created when it is stored.
+	generated map[SlabID]struct{}
+
+	// created contains generated slab IDs which are stored and not removed.
+	created map[SlabID]struct{}
+
+	// removed contains slab IDs of existing slabs which are removed.
+	removed map[SlabID]struct{}
+
+	// stopped is true if counter only forwards calls to SlabStorage.
+	stopped bool
+}
+
+func newSlabDeltaCounter(storage SlabStorage) *slabDeltaCounter {
+	return &slabDeltaCounter{
+		SlabStorage: storage,
+		generated:   make(map[SlabID]struct{}),
+		created:     make(map[SlabID]struct{}),
+		removed:     make(map[SlabID]struct{}),
+	}
+}
+
+func (s *slabDeltaCounter) GenerateSlabID(address Address) (SlabID, error) {
+	id, err := s.SlabStorage.GenerateSlabID(address)
+	if err != nil {
+		return SlabID{}, err
+	}
+
+	if !s.stopped {
+		s.generated[id] = struct{}{}
+	}
+
+	return id, nil
+}
+
+func (s *slabDeltaCounter) Store(id SlabID, slab Slab) error {
+	err := s.SlabStorage.Store(id, slab)
+	if err != nil {
+		return err
+	}
+
+	if s.stopped {
+		return nil
+	}
+
+	if _, ok := s.generated[id]; ok {
+		s.created[id] = struct{}{}
+	}
+
+	return nil
+}
+
+func (s *slabDeltaCounter) Remove(id SlabID) error {
+	err := s.SlabStorage.Remove(id)
+	if err != nil {
+		return err
+	}
+
+	if s.stopped {
+		return nil
+	}
+
+	if _, ok := s.generated[id]; ok {
+		delete(s.created, id)
+	} else {
+		s.removed[id] = struct{}{}
+	}
+
+	return nil
+}
+
+// removeCreatedSlabs removes slabs created through counter from
+// underlying storage, so failed operation doesn't leave orphaned slabs.
+func (s *slabDeltaCounter) removeCreatedSlabs() error {
+	ids := make([]SlabID, 0, len(s.created))
+	for id := range s.created {
+		ids = append(ids, id)
+	}
+
+	// Remove slabs in deterministic order.
+	slices.SortFunc(ids, func(a, b SlabID) int {
+		return a.Compare(b)
+	})
+
+	for _, id := range ids {
+		err := s.SlabStorage.Remove(id)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", id))
+		}
+		delete(s.created, id)
+	}
+
+	return nil
+}
+
+func (s *slabDeltaCounter) delta() (created int, removed int) {
+	return len(s.created), len(s.removed)
+}
+
+func (s *slabDeltaCounter) stop() {
+	s.stopped = true
+	s.generated = nil
+	s.created = nil
+	s.removed = nil
+}
@@ -0,0 +1,119 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"fmt"
+)
+
+// GetReturningSlabsRead is like Get, but returns value storable and number
+// of slabs read by this operation, so callers can charge for read depth.
+// Slabs read include root slab, metadata and data slabs on the path to
+// the element, external collision group slabs, external key slabs read
+// for comparison, and external value slab.  Each slab is counted once,
+// whether or not it is already loaded.  slabsRead is also returned with
+// error (e.g. KeyNotFoundError) because slabs are read before key is found
+// missing.
+func (m *OrderedMap) GetReturningSlabsRead(
+	comparator ValueComparator,
+	hip HashInputProvider,
+	key Value,
+) (valueStorable Storable, slabsRead int, err error) {
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return nil, 0, err
+	}
+
+	counter := newSlabReadCounter(m.Storage)
+
+	// Root slab is read.
+	counter.read[m.root.SlabID()] = struct{}{}
+
+	if m.isEmpty() {
+		return nil, counter.count(), NewKeyNotFoundError(key)
+	}
+
+	keyDigest, err := m.digestKey(hip, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.digestKey().
+		return nil, counter.count(), err
+	}
+	defer putDigester(keyDigest)
+
+	level := uint(0)
+
+	hkey, err := keyDigest.Digest(level)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Digesert interface.
+		return nil, counter.count(), wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to get map key digest at level %d", level))
+	}
+
+	_, valueStorable, err = m.root.Get(counter, keyDigest, level, hkey, comparator, key)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by MapSlab.Get().
+		return nil, counter.count(), err
+	}
+
+	// Read external value slab.  Root slab of child container is also
+	// counted because reading child value starts from its root slab.
+	if id, ok := valueStorable.(SlabIDStorable); ok {
+		_, found, err := counter.Retrieve(SlabID(id))
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return nil, counter.count(), wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", SlabID(id)))
+		}
+		if !found {
+			return nil, counter.count(), NewSlabNotFoundErrorf(SlabID(id), "value slab not found")
+		}
+	}
+
+	return valueStorable, counter.count(), nil
+}
+
+// slabReadCounter is SlabStorage which records slabs retrieved through it.
+type slabReadCounter struct {
+	SlabStorage
+
+	// read contains IDs of slabs retrieved through this storage.
+	read map[SlabID]struct{}
+}
+
+func newSlabReadCounter(storage SlabStorage) *slabReadCounter {
+	return &slabReadCounter{
+		SlabStorage: storage,
+		read:        make(map[SlabID]struct{}),
+	}
+}
+
+func (s *slabReadCounter) Retrieve(id SlabID) (Slab, bool, error) {
+	slab, found, err := s.SlabStorage.Retrieve(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if found {
+		s.read[id] = struct{}{}
+	}
+
+	return slab, found, nil
+}
+
+func (s *slabReadCounter) count() int {
+	return len(s.read)
+}
@@ -133,3 +133,106 @@ func GetMapStats(m *OrderedMap) (MapStats, error) {
 		StorableSlabCount:      storableDataSlabCount,
 	}, nil
 }
+
+// expectedElementsPerDigestBucket is the minimum expected number of
+// elements in each bucket used by MapDigestUniformity.
+const expectedElementsPerDigestBucket = 5
+
+// maxDigestBucketCount is the maximum number of buckets used by
+// MapDigestUniformity.
+const maxDigestBucketCount = 1 << 16
+
+// MapDigestUniformity returns chi-square statistic of level 0 digests of
+// map elements, distributed into bucketCount buckets by high bits of digest.
+// It can be used to detect poor hash input or adversarial keys, because
+// the statistic is close to bucketCount-1 for uniformly distributed digests
+// and much larger if many elements have the same or similar digests.
+// bucketCount is a power of 2, so each bucket has at least 5 expected elements
+// (or 1 bucket for small maps).  Elements in collision group are counted in
+// the bucket of their shared digest.
+func MapDigestUniformity(m *OrderedMap) (chiSquare float64, bucketCount, elementCount uint64, err error) {
+	err = m.loadRoot()
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return 0, 0, 0, err
+	}
+
+	elementCount = m.Count()
+	if elementCount == 0 {
+		return 0, 0, 0, nil
+	}
+
+	bucketBits := 0
+	for (uint64(1)<<(bucketBits+1))*expectedElementsPerDigestBucket <= elementCount &&
+		uint64(1)<<(bucketBits+1) <= maxDigestBucketCount {
+		bucketBits++
+	}
+	bucketCount = uint64(1) << bucketBits
+
+	digestBits := digestSize * 8
+	if m.root.ExtraData().narrowDigests {
+		digestBits = narrowDigestSize * 8
+	}
+
+	buckets := make([]uint64, bucketCount)
+
+	dataSlab, err := firstMapDataSlab(m.Storage, m.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstMapDataSlab().
+		return 0, 0, 0, err
+	}
+
+	countedElements := uint64(0)
+
+	for {
+		elements, ok := dataSlab.elements.(*hkeyElements)
+		if !ok {
+			return 0, 0, 0, NewSlabDataErrorf("data slab %s elements type %T is wrong, want *hkeyElements", dataSlab.SlabID(), dataSlab.elements)
+		}
+
+		for i, hkey := range elements.hkeys {
+			count, err := elements.elems[i].Count(m.Storage)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by element.Count().
+				return 0, 0, 0, err
+			}
+
+			bucket := uint64(0)
+			if bucketBits > 0 {
+				bucket = uint64(hkey) >> (digestBits - bucketBits)
+			}
+
+			buckets[bucket] += uint64(count)
+			countedElements += uint64(count)
+		}
+
+		if dataSlab.next == SlabIDUndefined {
+			break
+		}
+
+		slab, err := getMapSlab(m.Storage, dataSlab.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return 0, 0, 0, err
+		}
+
+		dataSlab, ok = slab.(*MapDataSlab)
+		if !ok {
+			return 0, 0, 0, NewSlabDataErrorf("slab %s isn't MapDataSlab", slab.SlabID())
+		}
+	}
+
+	if countedElements != elementCount {
+		return 0, 0, 0, NewFatalError(
+			fmt.Errorf("map %s element count %d is wrong, want %d", m.ValueID(), elementCount, countedElements))
+	}
+
+	expected := float64(elementCount) / float64(bucketCount)
+
+	for _, observed := range buckets {
+		diff := float64(observed) - expected
+		chiSquare += diff * diff / expected
+	}
+
+	return chiSquare, bucketCount, elementCount, nil
+}
@@ -0,0 +1,125 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StreamMapSorted writes map elements to w as a stream of records, one
+// record per element.  Each record is encoded key and value returned by
+// encodeKV, prefixed by its length as unsigned varint.
+//
+// Records are written in map iteration order, which is ascending order of
+// key digests (hkeys) computed with map seed and digester builder.  Elements
+// with colliding digests are written in the same order as map iteration.
+// Since this is the same order in which NewMapFromBatchData requires elements,
+// stream can be read by ReadMapSorted and imported with NewMapFromBatchData
+// by using the same seed (see OrderedMap.Seed) and digester builder.
+// EncodingError is returned if record is larger than MaxMapStreamRecordSize.
+func StreamMapSorted(m *OrderedMap, w io.Writer, encodeKV func(k, v Value) ([]byte, error)) error {
+	var lengthBuf [binary.MaxVarintLen64]byte
+
+	err := m.IterateReadOnly(func(k Value, v Value) (bool, error) {
+		data, err := encodeKV(k, v)
+		if err != nil {
+			return false, err
+		}
+
+		if uint64(len(data)) > maxMapStreamRecordSize {
+			return false, NewEncodingErrorf("map record size %d exceeds max map stream record size %d", len(data), maxMapStreamRecordSize)
+		}
+
+		n := binary.PutUvarint(lengthBuf[:], uint64(len(data)))
+
+		_, err = w.Write(lengthBuf[:n])
+		if err != nil {
+			return false, err
+		}
+
+		_, err = w.Write(data)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.IterateReadOnly().
+		return err
+	}
+
+	return nil
+}
+
+// ReadMapSorted returns MapElementProvider which reads records written by
+// StreamMapSorted from r, and decodes them with decodeKV.  Returned provider
+// can be used with NewMapFromBatchData to rebuild the map.  Provider returns
+// nil key and value after last record is read.  Provider returns DecodingError
+// if record length is larger than MaxMapStreamRecordSize, so corrupted or
+// malicious length doesn't cause large allocation.
+func ReadMapSorted(r io.Reader, decodeKV func(data []byte) (Value, Value, error)) MapElementProvider {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		bufReader := bufio.NewReader(r)
+		br = bufReader
+		r = bufReader
+	}
+
+	return func() (Value, Value, error) {
+		length, err := binary.ReadUvarint(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// No more records
+				return nil, nil, nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, nil, NewDecodingErrorf("failed to read map record length: %s", err)
+			}
+			// Wrap err as external error (if needed) because err is returned by io.Reader interface.
+			return nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to read map record length")
+		}
+
+		if length > maxMapStreamRecordSize {
+			return nil, nil, NewDecodingErrorf("map record size %d exceeds max map stream record size %d", length, maxMapStreamRecordSize)
+		}
+
+		data := make([]byte, length)
+		_, err = io.ReadFull(r, data)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, nil, NewDecodingErrorf("failed to read map record of %d bytes: %s", length, err)
+			}
+			// Wrap err as external error (if needed) because err is returned by io.Reader interface.
+			return nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to read map record of %d bytes", length))
+		}
+
+		k, v, err := decodeKV(data)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by decodeKV callback.
+			return nil, nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to decode map record")
+		}
+
+		return k, v, nil
+	}
+}
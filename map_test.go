@@ -19,16 +19,23 @@
 package atree_test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
@@ -299,7 +306,31 @@ func (d keysByDigest) Less(i, j int) bool {
 			return digest1[z] < digest2[z] // sort by hkey
 		}
 	}
-	return i < j // sort by insertion order with hash collision
+	// sort by encoded key with hash collision
+	return bytes.Compare(encodeCollisionKey(d.keys[i]), encodeCollisionKey(d.keys[j])) < 0
+}
+
+// encodeCollisionKey returns encoded key, which orders elements with the same digests.
+func encodeCollisionKey(key atree.Value) []byte {
+	encMode, err := cbor.EncOptions{}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	enc := atree.NewEncoder(&buf, encMode)
+
+	err = key.(atree.Storable).Encode(enc)
+	if err != nil {
+		panic(err)
+	}
+
+	err = enc.CBOR.Flush()
+	if err != nil {
+		panic(err)
+	}
+
+	return buf.Bytes()
 }
 
 func TestMapSetAndGet(t *testing.T) {
@@ -1191,7 +1222,7 @@ func TestReadOnlyMapIterate(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i = uint64(0)
@@ -1273,7 +1304,7 @@ func TestReadOnlyMapIterate(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i := uint64(0)
@@ -2270,7 +2301,7 @@ func TestMutableMapIterate(t *testing.T) {
 		require.True(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		err = m.Iterate(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value, v atree.Value) (bool, error) {
@@ -2330,7 +2361,7 @@ func TestMutableMapIterate(t *testing.T) {
 		require.False(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		err = m.Iterate(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value, v atree.Value) (bool, error) {
@@ -2390,7 +2421,7 @@ func TestMutableMapIterate(t *testing.T) {
 		require.True(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		r := 'a'
@@ -2452,7 +2483,7 @@ func TestMutableMapIterate(t *testing.T) {
 		require.False(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		r := 'a'
@@ -2516,7 +2547,7 @@ func TestMutableMapIterate(t *testing.T) {
 		require.False(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		err = m.Iterate(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value, v atree.Value) (bool, error) {
@@ -2581,7 +2612,7 @@ func TestMutableMapIterate(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i := uint64(0)
@@ -2646,7 +2677,7 @@ func TestMutableMapIterate(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i := uint64(0)
@@ -2718,7 +2749,7 @@ func TestMutableMapIterate(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (updating elements)
 		i := uint64(0)
@@ -2802,7 +2833,7 @@ func TestMutableMapIterate(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (updating elements)
 		i := uint64(0)
@@ -2894,7 +2925,7 @@ func TestMutableMapIterate(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -2989,7 +3020,7 @@ func TestMutableMapIterate(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -3084,7 +3115,7 @@ func TestMutableMapIterate(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -3173,7 +3204,7 @@ func TestMutableMapIterate(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i := uint64(0)
@@ -3259,7 +3290,7 @@ func TestMutableMapIterate(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i := uint64(0)
@@ -3343,7 +3374,7 @@ func TestMutableMapIterate(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i = uint64(0)
@@ -3468,7 +3499,7 @@ func TestMutableMapIterate(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -3562,7 +3593,7 @@ func TestMutableMapIterate(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -3656,7 +3687,7 @@ func TestMutableMapIterate(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -3750,7 +3781,7 @@ func TestMutableMapIterate(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -3847,7 +3878,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		require.True(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		err = m.IterateKeys(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value) (bool, error) {
@@ -3907,7 +3938,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		require.False(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		err = m.IterateKeys(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value) (bool, error) {
@@ -3967,7 +3998,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		require.True(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		r := 'a'
@@ -4029,7 +4060,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		require.False(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		r := 'a'
@@ -4093,7 +4124,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		require.False(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		err = m.IterateKeys(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value) (bool, error) {
@@ -4158,7 +4189,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := uint64(0)
 		err = m.IterateKeys(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value) (resume bool, err error) {
@@ -4222,7 +4253,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := uint64(0)
 		err = m.IterateKeys(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value) (resume bool, err error) {
@@ -4293,7 +4324,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (updating elements)
 		i := uint64(0)
@@ -4379,7 +4410,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (updating elements)
 		i := uint64(0)
@@ -4473,7 +4504,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -4570,7 +4601,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -4667,7 +4698,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -4758,7 +4789,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i := uint64(0)
@@ -4846,7 +4877,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := uint64(0)
 		err = m.IterateKeys(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value) (resume bool, err error) {
@@ -4931,7 +4962,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i = uint64(0)
@@ -5060,7 +5091,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -5156,7 +5187,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -5252,7 +5283,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -5348,7 +5379,7 @@ func TestMutableMapIterateKeys(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -5447,7 +5478,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		require.True(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		err = m.IterateValues(test_utils.CompareValue, test_utils.GetHashInput, func(v atree.Value) (bool, error) {
@@ -5508,7 +5539,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		require.False(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		err = m.IterateValues(test_utils.CompareValue, test_utils.GetHashInput, func(v atree.Value) (bool, error) {
@@ -5569,7 +5600,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		require.True(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		r := 'a'
@@ -5633,7 +5664,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		require.False(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		r := 'a'
@@ -5698,7 +5729,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		require.False(t, IsMapRootDataSlab(m))
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := 0
 		err = m.IterateValues(test_utils.CompareValue, test_utils.GetHashInput, func(v atree.Value) (bool, error) {
@@ -5764,7 +5795,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i := uint64(0)
@@ -5830,7 +5861,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i := uint64(0)
@@ -5903,7 +5934,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (updating elements)
 		i := uint64(0)
@@ -5988,7 +6019,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (updating elements)
 		i := uint64(0)
@@ -6081,7 +6112,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -6177,7 +6208,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -6273,7 +6304,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -6363,7 +6394,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i := uint64(0)
@@ -6450,7 +6481,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		}
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i := uint64(0)
@@ -6535,7 +6566,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i = uint64(0)
@@ -6662,7 +6693,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -6757,7 +6788,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -6852,7 +6883,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -6947,7 +6978,7 @@ func TestMutableMapIterateValues(t *testing.T) {
 		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
 
 		// Sort keys by digest
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate and mutate child map (inserting elements)
 		i := uint64(0)
@@ -13524,7 +13555,7 @@ func TestMapPopIterate(t *testing.T) {
 
 		require.Equal(t, 1, storage.Count())
 
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		i := mapCount
 		err = m.PopIterate(func(k, v atree.Storable) {
@@ -13579,7 +13610,7 @@ func TestMapPopIterate(t *testing.T) {
 		err = storage.Commit()
 		require.NoError(t, err)
 
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		// Iterate key value pairs
 		i = len(keyValues)
@@ -13645,7 +13676,7 @@ func TestMapPopIterate(t *testing.T) {
 			}
 		}
 
-		sort.Stable(keysByDigest{sortedKeys, digesterBuilder})
+		sort.Stable(keysByDigest{sortedKeys, atree.GetMapDigesterBuilder(m)})
 
 		err = storage.Commit()
 		require.NoError(t, err)
@@ -14259,6 +14290,194 @@ func TestMapFromBatchData(t *testing.T) {
 
 		testMap(t, storage, typeInfo, address, copied, keyValues, sortedKeys, false)
 	})
+
+	t.Run("duplicate keys", func(t *testing.T) {
+		typeInfo := test_utils.NewSimpleTypeInfo(42)
+		address := atree.Address{2, 3, 4, 5, 6, 7, 8, 9}
+
+		const seed = 0x1234
+
+		testDuplicateKeys := func(t *testing.T, digesterBuilder atree.DigesterBuilder, keys []atree.Value) {
+			storage := newTestPersistentStorage(t)
+
+			i := 0
+			copied, err := atree.NewMapFromBatchData(
+				storage,
+				address,
+				digesterBuilder,
+				typeInfo,
+				test_utils.CompareValue,
+				test_utils.GetHashInput,
+				seed,
+				func() (atree.Value, atree.Value, error) {
+					if i == len(keys) {
+						return nil, nil, nil
+					}
+					k := keys[i]
+					i++
+					return k, test_utils.Uint64Value(i), nil
+				})
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var fatalError *atree.FatalError
+			var duplicateKeyError *atree.DuplicateKeyError
+			require.ErrorAs(t, err, &fatalError)
+			require.ErrorAs(t, err, &duplicateKeyError)
+			require.ErrorAs(t, fatalError, &duplicateKeyError)
+			require.Nil(t, copied)
+
+			// No slab is stored
+			require.Equal(t, 0, GetDeltasCount(storage))
+		}
+
+		t.Run("no collision", func(t *testing.T) {
+			k := test_utils.Uint64Value(1)
+
+			testDuplicateKeys(t, atree.NewDefaultDigesterBuilder(), []atree.Value{k, k})
+		})
+
+		t.Run("collision", func(t *testing.T) {
+			digesterBuilder := &mockDigesterBuilder{}
+
+			keys := []atree.Value{
+				test_utils.Uint64Value(0),
+				test_utils.Uint64Value(1),
+				test_utils.Uint64Value(2),
+				test_utils.Uint64Value(1),
+			}
+
+			for i, k := range keys[:3] {
+				digests := []atree.Digest{atree.Digest(0), atree.Digest(i)}
+				digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+			}
+
+			testDuplicateKeys(t, digesterBuilder, keys)
+		})
+	})
+
+	t.Run("failure removes created slabs", func(t *testing.T) {
+		atree.SetThreshold(256)
+		defer atree.SetThreshold(1024)
+
+		const mapCount = 100
+		const failAt = 60
+
+		typeInfo := test_utils.NewSimpleTypeInfo(42)
+
+		m, err := atree.NewMap(
+			newTestPersistentStorage(t),
+			atree.Address{1, 2, 3, 4, 5, 6, 7, 8},
+			atree.NewDefaultDigesterBuilder(),
+			typeInfo,
+		)
+		require.NoError(t, err)
+
+		// Large keys are stored in separate slabs in new map.
+		for i := range mapCount {
+			k := test_utils.NewStringValue(strings.Repeat(fmt.Sprint(i), 256))
+			storable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, storable)
+		}
+
+		storage := atree.NewBasicSlabStorage(nil, nil, nil, nil)
+		address := atree.Address{2, 3, 4, 5, 6, 7, 8, 9}
+
+		newCopy := func(failAt int) (*atree.OrderedMap, error) {
+			iter, err := m.ReadOnlyIterator()
+			require.NoError(t, err)
+
+			count := 0
+			return atree.NewMapFromBatchData(
+				storage,
+				address,
+				atree.NewDefaultDigesterBuilder(),
+				m.Type(),
+				test_utils.CompareValue,
+				test_utils.GetHashInput,
+				m.Seed(),
+				func() (atree.Value, atree.Value, error) {
+					if count == failAt {
+						return nil, nil, errors.New("test")
+					}
+					count++
+					return iter.Next()
+				})
+		}
+
+		copied, err := newCopy(failAt)
+		require.Nil(t, copied)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+
+		// No slab is left in storage.
+		require.Equal(t, 0, storage.Count())
+
+		// Successful batch build uses provided storage.
+		copied, err = newCopy(-1)
+		require.NoError(t, err)
+		require.Equal(t, uint64(mapCount), copied.Count())
+		require.True(t, copied.Storage == atree.SlabStorage(storage))
+		require.Greater(t, storage.Count(), mapCount)
+	})
+	t.Run("failure to remove created slabs", func(t *testing.T) {
+		atree.SetThreshold(256)
+		defer atree.SetThreshold(1024)
+
+		const mapCount = 100
+		const failAt = 60
+
+		typeInfo := test_utils.NewSimpleTypeInfo(42)
+
+		m, err := atree.NewMap(
+			newTestPersistentStorage(t),
+			atree.Address{1, 2, 3, 4, 5, 6, 7, 8},
+			atree.NewDefaultDigesterBuilder(),
+			typeInfo,
+		)
+		require.NoError(t, err)
+
+		// Large keys are stored in separate slabs in new map.
+		for i := range mapCount {
+			k := test_utils.NewStringValue(strings.Repeat(fmt.Sprint(i), 256))
+			storable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, storable)
+		}
+
+		storage := &removeFailingSlabStorage{atree.NewBasicSlabStorage(nil, nil, nil, nil)}
+		address := atree.Address{2, 3, 4, 5, 6, 7, 8, 9}
+
+		iter, err := m.ReadOnlyIterator()
+		require.NoError(t, err)
+
+		errBatch := errors.New("test")
+
+		count := 0
+		copied, err := atree.NewMapFromBatchData(
+			storage,
+			address,
+			atree.NewDefaultDigesterBuilder(),
+			m.Type(),
+			test_utils.CompareValue,
+			test_utils.GetHashInput,
+			m.Seed(),
+			func() (atree.Value, atree.Value, error) {
+				if count == failAt {
+					return nil, nil, errBatch
+				}
+				count++
+				return iter.Next()
+			})
+		require.Nil(t, copied)
+
+		// Error of batch build is returned with error of removing created slabs.
+		require.ErrorIs(t, err, errBatch)
+		require.ErrorIs(t, err, errRemoveSlab)
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.Equal(t, errBatch, externalError.Unwrap())
+	})
 }
 
 func TestMapNestedStorables(t *testing.T) {
@@ -14448,7 +14667,7 @@ func TestMapSlabDump(t *testing.T) {
 		}
 
 		want := []string{
-			"level 1, MapDataSlab id:0x102030405060708.1 size:55 firstkey:0 elements: [0:0:0 1:1:1 2:2:2]",
+			"level 1, MapDataSlab id:0x102030405060708.1 size:55 count:3 firstKey:0 next:0x0.0 elements: [0:0:0 1:1:1 2:2:2]",
 		}
 		dumps, err := atree.DumpMapSlabs(m)
 		require.NoError(t, err)
@@ -14478,8 +14697,8 @@ func TestMapSlabDump(t *testing.T) {
 
 		want := []string{
 			"level 1, MapMetaDataSlab id:0x102030405060708.1 size:48 firstKey:0 children: [{id:0x102030405060708.2 size:221 firstKey:0} {id:0x102030405060708.3 size:293 firstKey:13}]",
-			"level 2, MapDataSlab id:0x102030405060708.2 size:221 firstkey:0 elements: [0:0:0 1:1:1 2:2:2 3:3:3 4:4:4 5:5:5 6:6:6 7:7:7 8:8:8 9:9:9 10:10:10 11:11:11 12:12:12]",
-			"level 2, MapDataSlab id:0x102030405060708.3 size:293 firstkey:13 elements: [13:13:13 14:14:14 15:15:15 16:16:16 17:17:17 18:18:18 19:19:19 20:20:20 21:21:21 22:22:22 23:23:23 24:24:24 25:25:25 26:26:26 27:27:27 28:28:28 29:29:29]",
+			"level 2, MapDataSlab id:0x102030405060708.2 size:221 count:13 firstKey:0 next:0x102030405060708.3 elements: [0:0:0 1:1:1 2:2:2 3:3:3 4:4:4 5:5:5 6:6:6 7:7:7 8:8:8 9:9:9 10:10:10 11:11:11 12:12:12]",
+			"level 2, MapDataSlab id:0x102030405060708.3 size:293 count:17 firstKey:13 next:0x0.0 elements: [13:13:13 14:14:14 15:15:15 16:16:16 17:17:17 18:18:18 19:19:19 20:20:20 21:21:21 22:22:22 23:23:23 24:24:24 25:25:25 26:26:26 27:27:27 28:28:28 29:29:29]",
 		}
 		dumps, err := atree.DumpMapSlabs(m)
 		require.NoError(t, err)
@@ -14509,8 +14728,8 @@ func TestMapSlabDump(t *testing.T) {
 
 		want := []string{
 			"level 1, MapMetaDataSlab id:0x102030405060708.1 size:48 firstKey:0 children: [{id:0x102030405060708.2 size:213 firstKey:0} {id:0x102030405060708.3 size:221 firstKey:5}]",
-			"level 2, MapDataSlab id:0x102030405060708.2 size:213 firstkey:0 elements: [0:inline[:0:0 :10:10 :20:20] 1:inline[:1:1 :11:11 :21:21] 2:inline[:2:2 :12:12 :22:22] 3:inline[:3:3 :13:13 :23:23] 4:inline[:4:4 :14:14 :24:24]]",
-			"level 2, MapDataSlab id:0x102030405060708.3 size:221 firstkey:5 elements: [5:inline[:5:5 :15:15 :25:25] 6:inline[:6:6 :16:16 :26:26] 7:inline[:7:7 :17:17 :27:27] 8:inline[:8:8 :18:18 :28:28] 9:inline[:9:9 :19:19 :29:29]]",
+			"level 2, MapDataSlab id:0x102030405060708.2 size:213 count:5 firstKey:0 next:0x102030405060708.3 elements: [0:inline[:0:0 :10:10 :20:20] 1:inline[:1:1 :11:11 :21:21] 2:inline[:2:2 :12:12 :22:22] 3:inline[:3:3 :13:13 :23:23] 4:inline[:4:4 :14:14 :24:24]]",
+			"level 2, MapDataSlab id:0x102030405060708.3 size:221 count:5 firstKey:5 next:0x0.0 elements: [5:inline[:5:5 :15:15 :25:25] 6:inline[:6:6 :16:16 :26:26] 7:inline[:7:7 :17:17 :27:27] 8:inline[:8:8 :18:18 :28:28] 9:inline[:9:9 :19:19 :29:29]]",
 		}
 		dumps, err := atree.DumpMapSlabs(m)
 		require.NoError(t, err)
@@ -14539,9 +14758,9 @@ func TestMapSlabDump(t *testing.T) {
 		}
 
 		want := []string{
-			"level 1, MapDataSlab id:0x102030405060708.1 size:68 firstkey:0 elements: [0:external(0x102030405060708.2) 1:external(0x102030405060708.3)]",
-			"collision: MapDataSlab id:0x102030405060708.2 size:135 firstkey:0 elements: [:0:0 :2:2 :4:4 :6:6 :8:8 :10:10 :12:12 :14:14 :16:16 :18:18 :20:20 :22:22 :24:24 :26:26 :28:28]",
-			"collision: MapDataSlab id:0x102030405060708.3 size:135 firstkey:0 elements: [:1:1 :3:3 :5:5 :7:7 :9:9 :11:11 :13:13 :15:15 :17:17 :19:19 :21:21 :23:23 :25:25 :27:27 :29:29]",
+			"level 1, MapDataSlab id:0x102030405060708.1 size:68 count:2 firstKey:0 next:0x0.0 elements: [0:external(0x102030405060708.2) 1:external(0x102030405060708.3)]",
+			"collision: MapDataSlab id:0x102030405060708.2 size:135 count:15 firstKey:0 next:0x0.0 elements: [:0:0 :2:2 :4:4 :6:6 :8:8 :10:10 :12:12 :14:14 :16:16 :18:18 :20:20 :22:22 :24:24 :26:26 :28:28]",
+			"collision: MapDataSlab id:0x102030405060708.3 size:135 count:15 firstKey:0 next:0x0.0 elements: [:1:1 :3:3 :5:5 :7:7 :9:9 :11:11 :13:13 :15:15 :17:17 :19:19 :21:21 :23:23 :25:25 :27:27 :29:29]",
 		}
 		dumps, err := atree.DumpMapSlabs(m)
 		require.NoError(t, err)
@@ -14567,8 +14786,8 @@ func TestMapSlabDump(t *testing.T) {
 		require.Nil(t, existingStorable)
 
 		want := []string{
-			"level 1, MapDataSlab id:0x102030405060708.1 size:93 firstkey:0 elements: [0:SlabIDStorable({[1 2 3 4 5 6 7 8] [0 0 0 0 0 0 0 2]}):bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb]",
-			"StorableSlab id:0x102030405060708.2 storable:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			"level 1, MapDataSlab id:0x102030405060708.1 size:93 count:1 firstKey:0 next:0x0.0 elements: [0:SlabIDStorable({[1 2 3 4 5 6 7 8] [0 0 0 0 0 0 0 2]}):bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb]",
+			"StorableSlab id:0x102030405060708.2 size:57 storable:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
 		}
 		dumps, err := atree.DumpMapSlabs(m)
 		require.NoError(t, err)
@@ -14594,8 +14813,8 @@ func TestMapSlabDump(t *testing.T) {
 		require.Nil(t, existingStorable)
 
 		want := []string{
-			"level 1, MapDataSlab id:0x102030405060708.1 size:91 firstkey:0 elements: [0:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa:SlabIDStorable({[1 2 3 4 5 6 7 8] [0 0 0 0 0 0 0 2]})]",
-			"StorableSlab id:0x102030405060708.2 storable:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+			"level 1, MapDataSlab id:0x102030405060708.1 size:91 count:1 firstKey:0 next:0x0.0 elements: [0:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa:SlabIDStorable({[1 2 3 4 5 6 7 8] [0 0 0 0 0 0 0 2]})]",
+			"StorableSlab id:0x102030405060708.2 size:111 storable:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
 		}
 		dumps, err := atree.DumpMapSlabs(m)
 		require.NoError(t, err)
@@ -19889,101 +20108,8754 @@ func TestMapSetType(t *testing.T) {
 	})
 }
 
-func testExistingMapSetType(
-	t *testing.T,
-	id atree.SlabID,
-	baseStorage atree.BaseStorage,
-	expectedTypeInfo test_utils.SimpleTypeInfo,
-	expectedCount uint64,
-	expectedSeed uint64,
-) {
-	newTypeInfo := test_utils.NewSimpleTypeInfo(expectedTypeInfo.Value() + 1)
+func TestMapSchemaID(t *testing.T) {
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
 
-	// Create storage from existing data
-	storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+	t.Run("encode and decode", func(t *testing.T) {
+		storage := newTestBasicStorage(t)
 
-	// Load existing map by ID
-	m, err := atree.NewMapWithRootID(storage, id, atree.NewDefaultDigesterBuilder())
-	require.NoError(t, err)
-	require.Equal(t, expectedCount, m.Count())
-	require.Equal(t, expectedTypeInfo, m.Type())
-	require.Equal(t, expectedSeed, m.Seed())
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), m.SchemaID())
 
-	// Modify type info of existing map
-	err = m.SetType(newTypeInfo)
-	require.NoError(t, err)
-	require.Equal(t, expectedCount, m.Count())
-	require.Equal(t, newTypeInfo, m.Type())
-	require.Equal(t, expectedSeed, m.Seed())
+		err = m.SetSchemaID(7)
+		require.NoError(t, err)
+		require.Equal(t, uint64(7), m.SchemaID())
 
-	// Commit data in storage
-	err = storage.FastCommit(runtime.NumCPU())
-	require.NoError(t, err)
+		id1 := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
 
-	// Create storage from existing data
-	storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+		expected := []byte{
+			// version
+			0x10,
+			// flag: root + map data
+			0x88,
 
-	// Load existing map again from storage
-	m2, err := atree.NewMapWithRootID(storage2, id, atree.NewDefaultDigesterBuilder())
-	require.NoError(t, err)
-	require.Equal(t, expectedCount, m2.Count())
-	require.Equal(t, newTypeInfo, m2.Type())
-	require.Equal(t, expectedSeed, m2.Seed())
-}
+			// extra data
+			// CBOR encoded array of 4 elements
+			0x84,
+			// type info
+			0x18, 0x2a,
+			// count: 0
+			0x00,
+			// seed
+			0x1b, 0x52, 0xa8, 0x78, 0x3, 0x85, 0x2c, 0xaa, 0x49,
+			// schema ID: 7
+			0x07,
 
-func testExistingInlinedMapSetType(
-	t *testing.T,
-	parentID atree.SlabID,
-	inlinedChildKey atree.Value,
-	baseStorage atree.BaseStorage,
-	expectedTypeInfo test_utils.SimpleTypeInfo,
-	expectedCount uint64,
-	expectedSeed uint64,
-) {
-	newTypeInfo := test_utils.NewSimpleTypeInfo(expectedTypeInfo.Value() + 1)
+			// elements (array of 3 elements)
+			0x83,
+			// level: 0
+			0x00,
+			// hkeys (byte string of length 8 * 0)
+			0x59, 0x00, 0x00,
+			// elements (array of 0 elements)
+			0x99, 0x00, 0x00,
+		}
 
-	// Create storage from existing data
-	storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+		// Verify encoded data
+		stored, err := storage.Encode()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(stored))
+		require.Equal(t, expected, stored[id1])
 
-	// Load existing map by ID
-	parentMap, err := atree.NewMapWithRootID(storage, parentID, atree.NewDefaultDigesterBuilder())
-	require.NoError(t, err)
+		// Decode data to new storage
+		storage2 := newTestPersistentStorageWithData(t, stored)
 
-	element, err := parentMap.Get(test_utils.CompareValue, test_utils.GetHashInput, inlinedChildKey)
-	require.NoError(t, err)
+		decodedMap, err := atree.NewMapWithRootID(storage2, id1, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+		require.Equal(t, uint64(7), decodedMap.SchemaID())
 
-	childMap, ok := element.(*atree.OrderedMap)
-	require.True(t, ok)
+		testEmptyMap(t, storage2, typeInfo, address, decodedMap)
 
-	require.Equal(t, expectedCount, childMap.Count())
-	require.Equal(t, expectedTypeInfo, childMap.Type())
-	require.Equal(t, expectedSeed, childMap.Seed())
+		// Remove schema ID
+		err = m.SetSchemaID(0)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), m.SchemaID())
 
-	// Modify type info of existing map
-	err = childMap.SetType(newTypeInfo)
-	require.NoError(t, err)
-	require.Equal(t, expectedCount, childMap.Count())
-	require.Equal(t, newTypeInfo, childMap.Type())
-	require.Equal(t, expectedSeed, childMap.Seed())
+		stored, err = storage.Encode()
+		require.NoError(t, err)
+		require.Equal(t, byte(0x83), stored[id1][2])
+		require.Equal(t, len(expected)-1, len(stored[id1]))
+	})
 
-	// Commit data in storage
-	err = storage.FastCommit(runtime.NumCPU())
-	require.NoError(t, err)
+	t.Run("decode zero schema ID", func(t *testing.T) {
+		id1 := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
 
-	// Create storage from existing data
-	storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+		data := map[atree.SlabID][]byte{
+			id1: {
+				// version
+				0x10,
+				// flag: root + map data
+				0x88,
 
-	// Load existing map again from storage
-	parentMap2, err := atree.NewMapWithRootID(storage2, parentID, atree.NewDefaultDigesterBuilder())
-	require.NoError(t, err)
+				// extra data
+				// CBOR encoded array of 4 elements
+				0x84,
+				// type info
+				0x18, 0x2a,
+				// count: 0
+				0x00,
+				// seed
+				0x1b, 0x52, 0xa8, 0x78, 0x3, 0x85, 0x2c, 0xaa, 0x49,
+				// schema ID: 0 (invalid)
+				0x00,
 
-	element2, err := parentMap2.Get(test_utils.CompareValue, test_utils.GetHashInput, inlinedChildKey)
-	require.NoError(t, err)
+				// elements (array of 3 elements)
+				0x83,
+				// level: 0
+				0x00,
+				// hkeys (byte string of length 8 * 0)
+				0x59, 0x00, 0x00,
+				// elements (array of 0 elements)
+				0x99, 0x00, 0x00,
+			},
+		}
 
-	childMap2, ok := element2.(*atree.OrderedMap)
-	require.True(t, ok)
+		storage := newTestPersistentStorageWithData(t, data)
 
-	require.Equal(t, expectedCount, childMap2.Count())
-	require.Equal(t, newTypeInfo, childMap2.Type())
+		m, err := atree.NewMapWithRootID(storage, id1, atree.NewDefaultDigesterBuilder())
+		require.Nil(t, m)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var decodingError *atree.DecodingError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &decodingError)
+	})
+
+	t.Run("metadata slab root", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		mapCount := 10_000
+		expectedKeyValues := make(test_utils.ExpectedMapValue)
+		for i := range mapCount {
+			v := test_utils.Uint64Value(i)
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, v, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+			expectedKeyValues[v] = v
+		}
+		require.False(t, IsMapRootDataSlab(m))
+
+		err = m.SetSchemaID(math.MaxUint64)
+		require.NoError(t, err)
+
+		testMap(t, storage, typeInfo, address, m, expectedKeyValues, nil, false)
+
+		err = storage.FastCommit(runtime.NumCPU())
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		m2, err := atree.NewMapWithRootID(storage2, m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+		require.Equal(t, uint64(math.MaxUint64), m2.SchemaID())
+		require.Equal(t, uint64(mapCount), m2.Count())
+
+		// DeepCopy preserves schema ID
+		copied, err := m2.DeepCopy(storage2, address, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.Equal(t, uint64(math.MaxUint64), copied.SchemaID())
+	})
+
+	t.Run("inlined map", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		parentMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		k := test_utils.Uint64Value(0)
+		existingStorable, err := parentMap.Set(test_utils.CompareValue, test_utils.GetHashInput, k, childMap)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+		require.True(t, childMap.Inlined())
+
+		err = childMap.SetSchemaID(3)
+		require.NoError(t, err)
+
+		err = storage.FastCommit(runtime.NumCPU())
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		parentMap2, err := atree.NewMapWithRootID(storage2, parentMap.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		v, err := parentMap2.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+		require.NoError(t, err)
+
+		childMap2, ok := v.(*atree.OrderedMap)
+		require.True(t, ok)
+		require.True(t, childMap2.Inlined())
+		require.Equal(t, uint64(3), childMap2.SchemaID())
+		require.Equal(t, uint64(0), parentMap2.SchemaID())
+	})
+}
+
+// appDigesterBuilder is digester builder implemented by application,
+// which can't be copied by maps.
+type appDigesterBuilder struct {
+	atree.SeededDigesterBuilder
+}
+
+func TestMapReseededDigesterBuilder(t *testing.T) {
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 100
+
+	t.Run("reseeded", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := atree.NewDefaultDigesterBuilder()
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		expectedKeyValues := make(test_utils.ExpectedMapValue)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 10)
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+			expectedKeyValues[k] = v
+		}
+
+		seededDigesterBuilder, ok := digesterBuilder.(atree.SeededDigesterBuilder)
+		require.True(t, ok)
+
+		// Map seeds its own copy of digester builder.
+		k0, k1 := seededDigesterBuilder.Seed()
+		require.Equal(t, uint64(0), k0)
+		require.Equal(t, uint64(0), k1)
+
+		// Reseed caller's digester builder.
+		digesterBuilder.SetSeed(m.Seed()+1, 1)
+
+		// Keys are still digested with map's seed.
+		k := test_utils.Uint64Value(0)
+
+		has, err := m.Has(test_utils.CompareValue, test_utils.GetHashInput, k)
+		require.NoError(t, err)
+		require.True(t, has)
+
+		v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+		require.NoError(t, err)
+		testValueEqual(t, expectedKeyValues[k], v)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(mapCount), test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+		expectedKeyValues[test_utils.Uint64Value(mapCount)] = test_utils.Uint64Value(0)
+
+		_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+		require.NoError(t, err)
+		delete(expectedKeyValues, k)
+
+		testMap(t, storage, typeInfo, address, m, expectedKeyValues, nil, false)
+
+		// Caller's digester builder isn't modified by map.
+		reseededK0, reseededK1 := seededDigesterBuilder.Seed()
+		require.Equal(t, m.Seed()+1, reseededK0)
+		require.Equal(t, uint64(1), reseededK1)
+	})
+
+	t.Run("shared by maps", func(t *testing.T) {
+		digesterBuilder := atree.NewDefaultDigesterBuilder()
+
+		storage1 := newTestPersistentStorage(t)
+		storage2 := newTestPersistentStorage(t)
+
+		m1, err := atree.NewMapWithSeed(storage1, address, digesterBuilder, typeInfo, 1)
+		require.NoError(t, err)
+
+		m2, err := atree.NewMapWithSeed(storage2, address, digesterBuilder, typeInfo, 2)
+		require.NoError(t, err)
+
+		require.NotEqual(t, m1.Seed(), m2.Seed())
+
+		expectedKeyValues := make(test_utils.ExpectedMapValue)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 10)
+			expectedKeyValues[k] = v
+
+			for _, m := range []*atree.OrderedMap{m1, m2} {
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+			}
+		}
+
+		for _, storage := range []*atree.PersistentSlabStorage{storage1, storage2} {
+			err = storage.Commit()
+			require.NoError(t, err)
+		}
+
+		// Maps loaded with their own digester builders find all keys.
+		for i, m := range []*atree.OrderedMap{m1, m2} {
+			storage := []*atree.PersistentSlabStorage{storage1, storage2}[i]
+
+			loadedStorage := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+			loadedMap, err := atree.NewMapWithRootID(loadedStorage, m.SlabID(), atree.NewDefaultDigesterBuilder())
+			require.NoError(t, err)
+
+			testMap(t, loadedStorage, typeInfo, address, loadedMap, expectedKeyValues, nil, false)
+		}
+	})
+
+	t.Run("application digester builder reseeded", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := &appDigesterBuilder{
+			SeededDigesterBuilder: atree.NewDefaultDigesterBuilder().(atree.SeededDigesterBuilder),
+		}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		expectedKeyValues := make(test_utils.ExpectedMapValue)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 10)
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+			expectedKeyValues[k] = v
+		}
+
+		k0, k1 := digesterBuilder.Seed()
+
+		// Reseed digester builder bound to populated map.
+		digesterBuilder.SetSeed(k0+1, k1)
+
+		requireHashError := func(t *testing.T, err error) {
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var fatalError *atree.FatalError
+			var hashError *atree.HashError
+			require.ErrorAs(t, err, &fatalError)
+			require.ErrorAs(t, err, &hashError)
+		}
+
+		k := test_utils.Uint64Value(0)
+
+		_, err = m.Has(test_utils.CompareValue, test_utils.GetHashInput, k)
+		requireHashError(t, err)
+
+		_, err = m.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+		requireHashError(t, err)
+
+		_, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(mapCount), test_utils.Uint64Value(0))
+		requireHashError(t, err)
+
+		_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+		requireHashError(t, err)
+
+		// Map isn't modified by failed operations.
+		require.Equal(t, uint64(mapCount), m.Count())
+
+		// Restore seed.
+		digesterBuilder.SetSeed(k0, k1)
+
+		testMap(t, storage, typeInfo, address, m, expectedKeyValues, nil, false)
+	})
+}
+
+func TestMapIntegerKeyDigesterBuilder(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	uint64KeyToUint64 := func(v atree.Value) (uint64, bool) {
+		n, ok := v.(test_utils.Uint64Value)
+		return uint64(n), ok
+	}
+
+	testIntegerKeyMap := func(t *testing.T, digesterBuilder atree.DigesterBuilder, keys []atree.Value) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		expectedKeyValues := make(test_utils.ExpectedMapValue)
+		for i, k := range keys {
+			v := test_utils.Uint64Value(i)
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+			expectedKeyValues[k] = v
+		}
+
+		testMap(t, storage, typeInfo, address, m, expectedKeyValues, nil, false)
+
+		// Iteration order is digest order.
+		var prevDigest atree.Digest
+		i := 0
+		err = m.IterateReadOnlyKeys(func(k atree.Value) (bool, error) {
+			digester, err := atree.GetMapDigesterBuilder(m).Digest(test_utils.GetHashInput, k)
+			require.NoError(t, err)
+
+			d, err := digester.Digest(0)
+			require.NoError(t, err)
+
+			if i > 0 {
+				require.LessOrEqual(t, prevDigest, d)
+			}
+			prevDigest = d
+			i++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, len(keys), i)
+
+		// Remove half of the keys.
+		for _, k := range keys[:len(keys)/2] {
+			existingKeyStorable, existingValueStorable, err := m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.Equal(t, k, existingKeyStorable)
+			require.Equal(t, expectedKeyValues[k], existingValueStorable)
+			delete(expectedKeyValues, k)
+		}
+
+		testMap(t, storage, typeInfo, address, m, expectedKeyValues, nil, false)
+	}
+
+	t.Run("integer keys", func(t *testing.T) {
+		const mapCount = 10_000
+
+		r := newRand(t)
+
+		keys := make([]atree.Value, 0, mapCount)
+		seen := make(map[uint64]struct{}, mapCount)
+		for len(keys) < mapCount {
+			n := r.Uint64()
+			if _, ok := seen[n]; ok {
+				continue
+			}
+			seen[n] = struct{}{}
+			keys = append(keys, test_utils.Uint64Value(n))
+		}
+
+		testIntegerKeyMap(t, atree.NewIntegerKeyDigesterBuilder(uint64KeyToUint64), keys)
+	})
+
+	t.Run("forced collisions", func(t *testing.T) {
+		const mapCount = 1_000
+
+		// Map keys to 16 integers to force level 0 collisions.
+		collidingKeyToUint64 := func(v atree.Value) (uint64, bool) {
+			n, ok := v.(test_utils.Uint64Value)
+			return uint64(n) % 16, ok
+		}
+
+		keys := make([]atree.Value, mapCount)
+		for i := range keys {
+			keys[i] = test_utils.Uint64Value(i)
+		}
+
+		testIntegerKeyMap(t, atree.NewIntegerKeyDigesterBuilder(collidingKeyToUint64), keys)
+	})
+
+	t.Run("non-integer keys", func(t *testing.T) {
+		const mapCount = 1_000
+
+		keys := make([]atree.Value, mapCount)
+		for i := range keys {
+			if i%2 == 0 {
+				keys[i] = test_utils.Uint64Value(i)
+			} else {
+				keys[i] = test_utils.NewStringValue(fmt.Sprintf("s%d", i))
+			}
+		}
+
+		testIntegerKeyMap(t, atree.NewIntegerKeyDigesterBuilder(uint64KeyToUint64), keys)
+	})
+
+	t.Run("digester", func(t *testing.T) {
+		digesterBuilder := atree.NewIntegerKeyDigesterBuilder(uint64KeyToUint64)
+
+		_, err := digesterBuilder.Digest(test_utils.GetHashInput, test_utils.Uint64Value(1))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var hashSeedUninitializedError *atree.HashSeedUninitializedError
+		require.ErrorAs(t, err, &hashSeedUninitializedError)
+
+		digesterBuilder.SetSeed(1, 2)
+
+		digester1, err := digesterBuilder.Digest(test_utils.GetHashInput, test_utils.Uint64Value(1))
+		require.NoError(t, err)
+		require.Equal(t, uint(4), digester1.Levels())
+
+		digester2, err := digesterBuilder.Digest(test_utils.GetHashInput, test_utils.Uint64Value(2))
+		require.NoError(t, err)
+
+		prefix1, err := digester1.DigestPrefix(digester1.Levels())
+		require.NoError(t, err)
+		require.Equal(t, 4, len(prefix1))
+
+		prefix2, err := digester2.DigestPrefix(digester2.Levels())
+		require.NoError(t, err)
+		require.Equal(t, 4, len(prefix2))
+
+		for i := range prefix1 {
+			require.NotEqual(t, prefix1[i], prefix2[i])
+		}
+
+		_, err = digester1.Digest(digester1.Levels())
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var hashLevelError *atree.HashLevelError
+		require.ErrorAs(t, err, &hashLevelError)
+	})
+
+	t.Run("digester builder is stored with map", func(t *testing.T) {
+		const mapCount = 100
+
+		newMap := func(t *testing.T, digesterBuilder atree.DigesterBuilder) (atree.BaseStorage, atree.SlabID) {
+			storage := newTestPersistentStorage(t)
+
+			m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+			require.NoError(t, err)
+
+			for i := range mapCount {
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+			}
+
+			err = storage.Commit()
+			require.NoError(t, err)
+
+			return atree.GetBaseStorage(storage), m.SlabID()
+		}
+
+		t.Run("same builder", func(t *testing.T) {
+			baseStorage, rootID := newMap(t, atree.NewIntegerKeyDigesterBuilder(uint64KeyToUint64))
+
+			storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			m, err := atree.NewMapWithRootID(storage, rootID, atree.NewIntegerKeyDigesterBuilder(uint64KeyToUint64))
+			require.NoError(t, err)
+
+			for i := range mapCount {
+				v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i))
+				require.NoError(t, err)
+				require.Equal(t, test_utils.Uint64Value(i), v)
+			}
+		})
+
+		t.Run("default builder", func(t *testing.T) {
+			baseStorage, rootID := newMap(t, atree.NewIntegerKeyDigesterBuilder(uint64KeyToUint64))
+
+			storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			m, err := atree.NewMapWithRootID(storage, rootID, atree.NewDefaultDigesterBuilder())
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var userError *atree.UserError
+			require.ErrorAs(t, err, &userError)
+			require.Nil(t, m)
+		})
+
+		t.Run("default builder lazy", func(t *testing.T) {
+			baseStorage, rootID := newMap(t, atree.NewIntegerKeyDigesterBuilder(uint64KeyToUint64))
+
+			storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			m, err := atree.NewMapWithRootIDLazy(storage, rootID, atree.NewDefaultDigesterBuilder())
+			require.NoError(t, err)
+
+			_, err = m.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var userError *atree.UserError
+			require.ErrorAs(t, err, &userError)
+		})
+
+		t.Run("integer key builder with default map", func(t *testing.T) {
+			baseStorage, rootID := newMap(t, atree.NewDefaultDigesterBuilder())
+
+			storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			m, err := atree.NewMapWithRootID(storage, rootID, atree.NewIntegerKeyDigesterBuilder(uint64KeyToUint64))
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var userError *atree.UserError
+			require.ErrorAs(t, err, &userError)
+			require.Nil(t, m)
+		})
+	})
+}
+
+func TestMapMaxInlineCollisionGroupSize(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// externalizedAt returns number of elements in first level collision
+	// group when the group is moved to its own slab.
+	externalizedAt := func(t *testing.T, maxInlineCollisionGroupSize uint64) int {
+		atree.SetMaxInlineCollisionGroupSize(maxInlineCollisionGroupSize)
+		defer atree.SetMaxInlineCollisionGroupSize(0)
+
+		const mapCount = 100
+
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		externalizedCount := 0
+
+		expectedKeyValues := make(test_utils.ExpectedMapValue)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i)
+
+			// All keys collide at level 0.
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: []atree.Digest{0, atree.Digest(i)}})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			expectedKeyValues[k] = v
+
+			stats, err := atree.GetMapStats(m)
+			require.NoError(t, err)
+
+			if externalizedCount == 0 {
+				if stats.CollisionDataSlabCount == 1 {
+					externalizedCount = i + 1
+				}
+			} else {
+				require.Equal(t, uint64(1), stats.CollisionDataSlabCount)
+			}
+		}
+		require.Greater(t, externalizedCount, 0)
+
+		testMap(t, storage, typeInfo, address, m, expectedKeyValues, nil, false)
+
+		return externalizedCount
+	}
+
+	defaultExternalizedAt := externalizedAt(t, 0)
+	require.Equal(t, atree.MaxInlineMapElementSize(), atree.MaxInlineCollisionGroupSize())
+
+	t.Run("smaller", func(t *testing.T) {
+		n := externalizedAt(t, atree.MaxInlineMapElementSize()/2)
+		require.Less(t, n, defaultExternalizedAt)
+	})
+
+	t.Run("smallest", func(t *testing.T) {
+		// Inline collision group with 2 elements is externalized.
+		n := externalizedAt(t, 1)
+		require.Equal(t, 2, n)
+	})
+
+	t.Run("larger", func(t *testing.T) {
+		// Max inline collision group size can't exceed max inline map element size.
+		atree.SetMaxInlineCollisionGroupSize(atree.MaxInlineMapElementSize() * 2)
+		require.Equal(t, atree.MaxInlineMapElementSize(), atree.MaxInlineCollisionGroupSize())
+		atree.SetMaxInlineCollisionGroupSize(0)
+
+		n := externalizedAt(t, atree.MaxInlineMapElementSize()*2)
+		require.Equal(t, defaultExternalizedAt, n)
+	})
+}
+
+func TestMapIsEmpty(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	baseStorage := test_utils.NewInMemBaseStorage()
+
+	storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+	require.True(t, m.IsEmpty())
+
+	// Key isn't FixedSizeHashInputValue, so its hash input is from hip.
+	k := test_utils.NewStringValue("a")
+
+	existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+	require.False(t, m.IsEmpty())
+
+	_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+	require.NoError(t, err)
+	require.True(t, m.IsEmpty())
+
+	err = storage.Commit()
+	require.NoError(t, err)
+
+	// Load empty map with new storage
+	storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	m2, err := atree.NewMapWithRootID(storage2, m.SlabID(), atree.NewDefaultDigesterBuilder())
+	require.NoError(t, err)
+
+	segmentsReturned := baseStorage.SegmentsReturned()
+
+	require.True(t, m2.IsEmpty())
+
+	// Keys are still hashed for operations on empty map,
+	// so hash input errors are reported consistently.
+	hashInputCount := 0
+	hip := func(v atree.Value, scratch []byte) ([]byte, error) {
+		hashInputCount++
+		return test_utils.GetHashInput(v, scratch)
+	}
+
+	has, err := m2.Has(test_utils.CompareValue, hip, k)
+	require.NoError(t, err)
+	require.False(t, has)
+	require.Equal(t, 1, hashInputCount)
+
+	var keyNotFoundError *atree.KeyNotFoundError
+
+	v, err := m2.Get(test_utils.CompareValue, hip, k)
+	require.Nil(t, v)
+	require.Equal(t, 1, errorCategorizationCount(err))
+	require.ErrorAs(t, err, &keyNotFoundError)
+	require.Equal(t, 2, hashInputCount)
+
+	existingKeyStorable, existingValueStorable, err := m2.Remove(test_utils.CompareValue, hip, k)
+	require.Nil(t, existingKeyStorable)
+	require.Nil(t, existingValueStorable)
+	require.Equal(t, 1, errorCategorizationCount(err))
+	require.ErrorAs(t, err, &keyNotFoundError)
+	require.Equal(t, 3, hashInputCount)
+
+	// Hash input errors are reported for operations on empty map.
+	testErr := errors.New("test")
+	failingHip := func(atree.Value, []byte) ([]byte, error) {
+		return nil, testErr
+	}
+
+	var externalError *atree.ExternalError
+
+	has, err = m2.Has(test_utils.CompareValue, failingHip, k)
+	require.False(t, has)
+	require.Equal(t, 1, errorCategorizationCount(err))
+	require.ErrorAs(t, err, &externalError)
+	require.ErrorIs(t, err, testErr)
+
+	v, err = m2.Get(test_utils.CompareValue, failingHip, k)
+	require.Nil(t, v)
+	require.Equal(t, 1, errorCategorizationCount(err))
+	require.ErrorAs(t, err, &externalError)
+	require.ErrorIs(t, err, testErr)
+
+	existingKeyStorable, existingValueStorable, err = m2.Remove(test_utils.CompareValue, failingHip, k)
+	require.Nil(t, existingKeyStorable)
+	require.Nil(t, existingValueStorable)
+	require.Equal(t, 1, errorCategorizationCount(err))
+	require.ErrorAs(t, err, &externalError)
+	require.ErrorIs(t, err, testErr)
+
+	iterate := func(atree.Value, atree.Value) (bool, error) {
+		require.Fail(t, "iteration callback shouldn't be called for empty map")
+		return false, nil
+	}
+
+	err = m2.Iterate(test_utils.CompareValue, hip, iterate)
+	require.NoError(t, err)
+
+	err = m2.IterateReadOnly(iterate)
+	require.NoError(t, err)
+
+	// No slab is retrieved for operations on empty map.
+	require.Equal(t, segmentsReturned, baseStorage.SegmentsReturned())
+
+	testEmptyMap(t, storage2, typeInfo, address, m2)
+}
+
+func TestMapInsertionOrder(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("no index", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+		require.Nil(t, m.InsertionOrderIndex())
+
+		err = m.IterateInsertionOrder(test_utils.CompareValue, test_utils.GetHashInput, func(atree.Value, atree.Value) (bool, error) {
+			return true, nil
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		var insertionOrderIndexError *atree.InsertionOrderIndexError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &insertionOrderIndexError)
+	})
+
+	t.Run("set and remove", func(t *testing.T) {
+		const mapCount = 1_000
+
+		baseStorage := test_utils.NewInMemBaseStorage()
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		keys, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, atree.WithInsertionOrderIndex(keys))
+		require.NoError(t, err)
+		require.Equal(t, keys, m.InsertionOrderIndex())
+
+		r := newRand(t)
+
+		insertionOrder := make([]atree.Value, 0, mapCount)
+		expectedKeyValues := make(test_utils.ExpectedMapValue)
+		for len(insertionOrder) < mapCount {
+			k := test_utils.Uint64Value(r.Intn(mapCount * 10))
+			if _, ok := expectedKeyValues[k]; ok {
+				continue
+			}
+			v := test_utils.Uint64Value(len(insertionOrder))
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			insertionOrder = append(insertionOrder, k)
+			expectedKeyValues[k] = v
+		}
+
+		// Updating existing elements doesn't change insertion order.
+		for _, k := range insertionOrder[:mapCount/10] {
+			v := test_utils.Uint64Value(r.Uint64())
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.NotNil(t, existingStorable)
+
+			expectedKeyValues[k] = v
+		}
+
+		// Remove every third element.
+		remainingInsertionOrder := make([]atree.Value, 0, len(insertionOrder))
+		for i, k := range insertionOrder {
+			if i%3 != 0 {
+				remainingInsertionOrder = append(remainingInsertionOrder, k)
+				continue
+			}
+
+			existingKeyStorable, existingValueStorable, err := m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.Equal(t, k, existingKeyStorable)
+			require.Equal(t, expectedKeyValues[k], existingValueStorable)
+
+			delete(expectedKeyValues, k)
+		}
+		insertionOrder = remainingInsertionOrder
+
+		testInsertionOrder := func(t *testing.T, m *atree.OrderedMap) {
+			require.Equal(t, uint64(len(insertionOrder)), m.InsertionOrderIndex().Count())
+
+			// Digest order
+			var digestOrder []atree.Value
+			err := m.IterateReadOnly(func(k atree.Value, v atree.Value) (bool, error) {
+				digestOrder = append(digestOrder, k)
+				testValueEqual(t, expectedKeyValues[k], v)
+				return true, nil
+			})
+			require.NoError(t, err)
+			require.ElementsMatch(t, insertionOrder, digestOrder)
+			require.NotEqual(t, insertionOrder, digestOrder)
+
+			// Insertion order
+			var iterated []atree.Value
+			err = m.IterateInsertionOrder(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value, v atree.Value) (bool, error) {
+				iterated = append(iterated, k)
+				testValueEqual(t, expectedKeyValues[k], v)
+				return true, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, insertionOrder, iterated)
+		}
+
+		testInsertionOrder(t, m)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		rootIDs, err := atree.CheckStorageHealth(storage, -1)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(rootIDs))
+		require.Contains(t, rootIDs, m.SlabID())
+		require.Contains(t, rootIDs, keys.SlabID())
+
+		// Load map and insertion order index with new storage
+		storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		keys2, err := atree.NewArrayWithRootID(storage2, keys.SlabID())
+		require.NoError(t, err)
+
+		m2, err := atree.NewMapWithRootID(storage2, m.SlabID(), atree.NewDefaultDigesterBuilder(), atree.WithInsertionOrderIndex(keys2))
+		require.NoError(t, err)
+
+		testInsertionOrder(t, m2)
+
+		// Stop iteration early
+		count := 0
+		err = m2.IterateInsertionOrder(test_utils.CompareValue, test_utils.GetHashInput, func(atree.Value, atree.Value) (bool, error) {
+			count++
+			return count < 10, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 10, count)
+
+		// Mutation during iteration
+		err = m2.IterateInsertionOrder(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value, _ atree.Value) (bool, error) {
+			_, _, err := m2.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			return true, nil
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var concurrentModificationError *atree.ConcurrentModificationError
+		require.ErrorAs(t, err, &concurrentModificationError)
+
+		// PopIterate clears insertion order index.
+		err = m2.PopIterate(func(atree.Storable, atree.Storable) {})
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), m2.Count())
+		require.Equal(t, uint64(0), keys2.Count())
+
+		err = m2.IterateInsertionOrder(test_utils.CompareValue, test_utils.GetHashInput, func(atree.Value, atree.Value) (bool, error) {
+			require.Fail(t, "iteration callback shouldn't be called for empty map")
+			return false, nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("drain", func(t *testing.T) {
+		const mapCount = 10_000
+
+		storage := newTestPersistentStorage(t)
+
+		keys, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, atree.WithInsertionOrderIndex(keys))
+		require.NoError(t, err)
+
+		insertionOrder := make([]atree.Value, mapCount)
+		for i := range mapCount {
+			k := test_utils.NewStringValue(fmt.Sprintf("%08d", i))
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			insertionOrder[i] = k
+		}
+
+		// Remove elements in random order, so removed keys are at any position.
+		r := newRand(t)
+		for i, j := range r.Perm(mapCount) {
+			k := insertionOrder[j]
+
+			existingKeyStorable, existingValueStorable, err := m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.Equal(t, k, existingKeyStorable)
+			require.Equal(t, test_utils.Uint64Value(j), existingValueStorable)
+
+			insertionOrder[j] = nil
+
+			if i%1_000 == 0 {
+				expected := make([]atree.Value, 0, mapCount-i-1)
+				for _, k := range insertionOrder {
+					if k != nil {
+						expected = append(expected, k)
+					}
+				}
+
+				var iterated []atree.Value
+				err = m.IterateInsertionOrder(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value, _ atree.Value) (bool, error) {
+					iterated = append(iterated, k)
+					return true, nil
+				})
+				require.NoError(t, err)
+				require.Equal(t, expected, iterated)
+			}
+		}
+
+		require.Equal(t, uint64(0), m.Count())
+		require.Equal(t, uint64(0), keys.Count())
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		const mapCount = 100
+
+		testErr := errors.New("test")
+
+		// Key is longer than max inline key size, so it is stored in its own slab.
+		newKey := func(i int) atree.Value {
+			return test_utils.NewStringValue(fmt.Sprintf("%0512d", i))
+		}
+
+		// failAfter returns comparator and hash input provider which
+		// return testErr when they are called more than n times with key.
+		failAfter := func(key atree.Value, comparatorCount int, hipCount int) (atree.ValueComparator, atree.HashInputProvider) {
+			comparator := func(storage atree.SlabStorage, value atree.Value, storable atree.Storable) (bool, error) {
+				if value == key {
+					if comparatorCount == 0 {
+						return false, testErr
+					}
+					comparatorCount--
+				}
+				return test_utils.CompareValue(storage, value, storable)
+			}
+			hip := func(value atree.Value, scratch []byte) ([]byte, error) {
+				if value == key {
+					if hipCount == 0 {
+						return nil, testErr
+					}
+					hipCount--
+				}
+				return test_utils.GetHashInput(value, scratch)
+			}
+			return comparator, hip
+		}
+
+		storage := newTestPersistentStorage(t)
+
+		keys, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		m, err := atree.NewMap(
+			storage,
+			address,
+			atree.NewDefaultDigesterBuilder(),
+			typeInfo,
+			atree.WithInsertionOrderIndex(keys),
+			atree.WithKeyReplacementOnSet(true),
+		)
+		require.NoError(t, err)
+
+		insertionOrder := make([]atree.Value, mapCount)
+		expectedKeyValues := make(test_utils.ExpectedMapValue)
+		for i := range mapCount {
+			k := newKey(i)
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			insertionOrder[i] = k
+			expectedKeyValues[k] = test_utils.Uint64Value(i)
+		}
+
+		requireUnchanged := func(t *testing.T, err error) {
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var externalError *atree.ExternalError
+			require.ErrorAs(t, err, &externalError)
+			require.ErrorIs(t, err, testErr)
+
+			var iterated []atree.Value
+			err = m.IterateInsertionOrder(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value, v atree.Value) (bool, error) {
+				iterated = append(iterated, k)
+				testValueEqual(t, expectedKeyValues[k], v)
+				return true, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, insertionOrder, iterated)
+
+			err = atree.VerifyMap(m, address, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+			require.NoError(t, err)
+
+			// Slabs of keys restored in insertion order index are removed.
+			err = storage.Commit()
+			require.NoError(t, err)
+
+			rootIDs, err := atree.CheckStorageHealth(storage, -1)
+			require.NoError(t, err)
+			require.Equal(t, 2, len(rootIDs))
+		}
+
+		t.Run("set new key", func(t *testing.T) {
+			k := newKey(mapCount)
+
+			// Key is digested by insertion order index, and then by map.
+			comparator, hip := failAfter(k, math.MaxInt, 1)
+
+			_, err := m.Set(comparator, hip, k, test_utils.Uint64Value(mapCount))
+			requireUnchanged(t, err)
+		})
+
+		t.Run("insert", func(t *testing.T) {
+			k := newKey(mapCount)
+
+			comparator, hip := failAfter(k, math.MaxInt, 1)
+
+			err := m.Insert(comparator, hip, k, test_utils.Uint64Value(mapCount))
+			requireUnchanged(t, err)
+		})
+
+		t.Run("replace key", func(t *testing.T) {
+			k := newKey(mapCount / 2)
+
+			// Key is compared by insertion order index, and then by map.
+			comparator, hip := failAfter(k, 1, math.MaxInt)
+
+			_, err := m.Set(comparator, hip, k, test_utils.Uint64Value(0))
+			requireUnchanged(t, err)
+		})
+
+		t.Run("remove", func(t *testing.T) {
+			k := newKey(mapCount / 2)
+
+			comparator, hip := failAfter(k, 1, math.MaxInt)
+
+			_, _, err := m.Remove(comparator, hip, k)
+			requireUnchanged(t, err)
+		})
+	})
+}
+
+func TestMapCompact(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("root data slab", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		expectedKeyValues := make(test_utils.ExpectedMapValue)
+		for i := range 10 {
+			k := test_utils.Uint64Value(i)
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+			expectedKeyValues[k] = k
+		}
+		require.True(t, IsMapRootDataSlab(m))
+
+		err = m.Compact()
+		require.NoError(t, err)
+
+		testMap(t, storage, typeInfo, address, m, expectedKeyValues, nil, false)
+	})
+
+	t.Run("after heavy deletion", func(t *testing.T) {
+		const mapCount = 64 * 1024
+		const remainingCount = 1024
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		rootID := m.SlabID()
+		seed := m.Seed()
+
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		// Remove all but every 64th element.
+		expectedKeyValues := make(test_utils.ExpectedMapValue)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			if i%(mapCount/remainingCount) == 0 {
+				expectedKeyValues[k] = k
+				continue
+			}
+			_, _, err := m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+		}
+		require.Equal(t, uint64(remainingCount), m.Count())
+
+		statsBeforeCompact, err := atree.GetMapStats(m)
+		require.NoError(t, err)
+
+		err = m.Compact()
+		require.NoError(t, err)
+
+		require.Equal(t, rootID, m.SlabID())
+		require.Equal(t, typeInfo, m.Type())
+		require.Equal(t, seed, m.Seed())
+		require.Equal(t, uint64(remainingCount), m.Count())
+
+		stats, err := atree.GetMapStats(m)
+		require.NoError(t, err)
+		require.Less(t, stats.SlabCount(), statsBeforeCompact.SlabCount())
+
+		// Build fresh map with the same elements and seed.
+		iterator, err := m.ReadOnlyIterator()
+		require.NoError(t, err)
+
+		freshStorage := newTestPersistentStorage(t)
+
+		freshMap, err := atree.NewMapFromBatchData(
+			freshStorage,
+			address,
+			atree.NewDefaultDigesterBuilder(),
+			typeInfo,
+			test_utils.CompareValue,
+			test_utils.GetHashInput,
+			seed,
+			func() (atree.Value, atree.Value, error) {
+				// Don't need to wrap error as external error because err is already categorized by MapIterator.Next().
+				return iterator.Next()
+			})
+		require.NoError(t, err)
+
+		freshStats, err := atree.GetMapStats(freshMap)
+		require.NoError(t, err)
+		require.Equal(t, freshStats, stats)
+
+		// testMap verifies that slabs of old slab tree are removed from storage.
+		testMap(t, storage, typeInfo, address, m, expectedKeyValues, nil, false)
+
+		// Map can be modified after compaction.
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			if _, ok := expectedKeyValues[k]; ok {
+				continue
+			}
+			v := test_utils.Uint64Value(i * 2)
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+			expectedKeyValues[k] = v
+		}
+
+		testMap(t, storage, typeInfo, address, m, expectedKeyValues, nil, false)
+	})
+}
+
+func TestMapSizeHistogram(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("sizes", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		// Keys are 3 bytes (< 24), 4 bytes (< 256), and 5 bytes (< 1000).
+		// Values are 900 strings of 11 bytes, 95 strings of 102 bytes,
+		// and 5 strings stored in separate slabs of 2005 bytes.
+		for i := range 1000 {
+			size := 10
+			if i >= 995 {
+				size = 2000
+			} else if i >= 900 {
+				size = 100
+			}
+
+			existingStorable, err := m.Set(
+				test_utils.CompareValue,
+				test_utils.GetHashInput,
+				test_utils.Uint64Value(i),
+				test_utils.NewStringValue(strings.Repeat("a", size)),
+			)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		histogram, err := atree.MapKeySizeHistogram(m, []uint64{3, 4, 16})
+		require.NoError(t, err)
+		require.Equal(t, map[uint64]uint64{3: 24, 4: 232, 16: 744}, histogram)
+
+		histogram, err = atree.MapValueSizeHistogram(m, []uint64{16, 128, 1024})
+		require.NoError(t, err)
+		require.Equal(t, map[uint64]uint64{16: 900, 128: 95, 1024: 0, math.MaxUint64: 5}, histogram)
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		// All keys collide at first level, some of them are in external collision group.
+		for i := range 100 {
+			k := test_utils.Uint64Value(i)
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: []atree.Digest{atree.Digest(i % 2), atree.Digest(i)}})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.NewStringValue(strings.Repeat("a", 10)))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		histogram, err := atree.MapKeySizeHistogram(m, []uint64{3, 4})
+		require.NoError(t, err)
+		require.Equal(t, map[uint64]uint64{3: 24, 4: 76}, histogram)
+
+		histogram, err = atree.MapValueSizeHistogram(m, []uint64{16})
+		require.NoError(t, err)
+		require.Equal(t, map[uint64]uint64{16: 100}, histogram)
+	})
+
+	t.Run("invalid buckets", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		histogram, err := atree.MapValueSizeHistogram(m, []uint64{16, 8})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+		require.Nil(t, histogram)
+	})
+}
+
+func TestMapReadOnly(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 64
+
+	baseStorage := test_utils.NewInMemBaseStorage()
+	storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	expectedValues := make(test_utils.ExpectedMapValue)
+
+	for i := range mapCount {
+		k := test_utils.Uint64Value(i)
+		v := test_utils.Uint64Value(i * 10)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		expectedValues[k] = v
+	}
+
+	// Add child map and child array.
+	childMapKey := test_utils.NewStringValue("map")
+	childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	existingStorable, err := childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+
+	existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, childMapKey, childMap)
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+
+	childArrayKey := test_utils.NewStringValue("array")
+	childArray, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+
+	err = childArray.Append(test_utils.Uint64Value(0))
+	require.NoError(t, err)
+
+	existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, childArrayKey, childArray)
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+
+	err = storage.Commit()
+	require.NoError(t, err)
+
+	rootID := m.SlabID()
+
+	segmentsUpdated := baseStorage.SegmentsUpdated()
+
+	requireReadOnlyError := func(t *testing.T, err error) {
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+		var readOnlyError *atree.ReadOnlyError
+		require.ErrorAs(t, err, &readOnlyError)
+	}
+
+	storage = newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	readOnlyMap, err := atree.NewMapWithRootIDReadOnly(storage, rootID, atree.NewDefaultDigesterBuilder())
+	require.NoError(t, err)
+
+	// Reads work.
+	require.Equal(t, uint64(mapCount+2), readOnlyMap.Count())
+
+	for k, v := range expectedValues {
+		value, err := readOnlyMap.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+		require.NoError(t, err)
+		require.Equal(t, v, value)
+	}
+
+	count := 0
+	err = readOnlyMap.IterateReadOnly(func(atree.Value, atree.Value) (bool, error) {
+		count++
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, mapCount+2, count)
+
+	// Mutations return ReadOnlyError.
+	_, err = readOnlyMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(1))
+	requireReadOnlyError(t, err)
+
+	_, _, err = readOnlyMap.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+	requireReadOnlyError(t, err)
+
+	err = readOnlyMap.PopIterate(func(atree.Storable, atree.Storable) {
+		require.Fail(t, "PopIterate callback shouldn't be called")
+	})
+	requireReadOnlyError(t, err)
+
+	err = readOnlyMap.Compact()
+	requireReadOnlyError(t, err)
+
+	err = readOnlyMap.SetType(test_utils.NewSimpleTypeInfo(43))
+	requireReadOnlyError(t, err)
+
+	err = readOnlyMap.SetSchemaID(1)
+	requireReadOnlyError(t, err)
+
+	// Child containers are read-only.
+	v, err := readOnlyMap.Get(test_utils.CompareValue, test_utils.GetHashInput, childMapKey)
+	require.NoError(t, err)
+	require.IsType(t, &atree.OrderedMap{}, v)
+
+	readOnlyChildMap := v.(*atree.OrderedMap)
+
+	value, err := readOnlyChildMap.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+	require.NoError(t, err)
+	require.Equal(t, test_utils.Uint64Value(0), value)
+
+	_, err = readOnlyChildMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(1))
+	requireReadOnlyError(t, err)
+
+	v, err = readOnlyMap.Get(test_utils.CompareValue, test_utils.GetHashInput, childArrayKey)
+	require.NoError(t, err)
+	require.IsType(t, &atree.Array{}, v)
+
+	readOnlyChildArray := v.(*atree.Array)
+
+	value, err = readOnlyChildArray.Get(0)
+	require.NoError(t, err)
+	require.Equal(t, test_utils.Uint64Value(0), value)
+
+	err = readOnlyChildArray.Append(test_utils.Uint64Value(1))
+	requireReadOnlyError(t, err)
+
+	_, err = readOnlyChildArray.Set(0, test_utils.Uint64Value(1))
+	requireReadOnlyError(t, err)
+
+	_, err = readOnlyChildArray.Remove(0)
+	requireReadOnlyError(t, err)
+
+	// Child containers from iteration are read-only.
+	err = readOnlyMap.IterateReadOnly(func(_ atree.Value, v atree.Value) (bool, error) {
+		if child, ok := v.(*atree.OrderedMap); ok {
+			_, err := child.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(1))
+			requireReadOnlyError(t, err)
+		}
+		if child, ok := v.(*atree.Array); ok {
+			err := child.Append(test_utils.Uint64Value(1))
+			requireReadOnlyError(t, err)
+		}
+		return true, nil
+	})
+	require.NoError(t, err)
+
+	// Storage isn't modified.
+	require.Equal(t, uint(0), storage.Deltas())
+	require.Equal(t, segmentsUpdated, baseStorage.SegmentsUpdated())
+
+	// Map is unchanged.
+	m, err = atree.NewMapWithRootID(storage, rootID, atree.NewDefaultDigesterBuilder())
+	require.NoError(t, err)
+	require.Equal(t, uint64(mapCount+2), m.Count())
+}
+
+func TestMapCollisionOrder(t *testing.T) {
+
+	const keyCount = 32
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	r := newRand(t)
+
+	keys := make([]atree.Value, 0, keyCount)
+	digesterBuilder := &mockDigesterBuilder{}
+
+	for len(keys) < keyCount {
+		k := test_utils.NewStringValue(randStr(r, 8))
+		if slices.Contains(keys, atree.Value(k)) {
+			continue
+		}
+		keys = append(keys, k)
+
+		// All keys have the same digests at all levels.
+		digesterBuilder.On("Digest", k).Return(mockDigester{d: []atree.Digest{1, 2}})
+	}
+
+	expectedKeys := slices.Clone(keys)
+	slices.SortFunc(expectedKeys, func(a, b atree.Value) int {
+		return bytes.Compare(encodeCollisionKey(a), encodeCollisionKey(b))
+	})
+
+	iterationOrder := func(t *testing.T, m *atree.OrderedMap) []atree.Value {
+		var keys []atree.Value
+		err := m.IterateReadOnlyKeys(func(k atree.Value) (bool, error) {
+			keys = append(keys, k)
+			return true, nil
+		})
+		require.NoError(t, err)
+		return keys
+	}
+
+	newMap := func(t *testing.T, keys []atree.Value) *atree.OrderedMap {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		for _, k := range keys {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		return m
+	}
+
+	t.Run("insert", func(t *testing.T) {
+		m := newMap(t, keys)
+		require.Equal(t, expectedKeys, iterationOrder(t, m))
+
+		reversedKeys := slices.Clone(keys)
+		slices.Reverse(reversedKeys)
+
+		m = newMap(t, reversedKeys)
+		require.Equal(t, expectedKeys, iterationOrder(t, m))
+	})
+
+	t.Run("remove and reinsert", func(t *testing.T) {
+		m := newMap(t, keys)
+
+		// Remove and reinsert every other key in random order.
+		removedKeys := make([]atree.Value, 0, keyCount/2)
+		for i := 0; i < keyCount; i += 2 {
+			removedKeys = append(removedKeys, keys[i])
+		}
+		r.Shuffle(len(removedKeys), func(i, j int) {
+			removedKeys[i], removedKeys[j] = removedKeys[j], removedKeys[i]
+		})
+
+		for _, k := range removedKeys {
+			_, _, err := m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+		}
+
+		for _, k := range removedKeys {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		require.Equal(t, expectedKeys, iterationOrder(t, m))
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		m := newMap(t, keys)
+
+		storage := newTestPersistentStorage(t)
+
+		// Batch data of colliding keys isn't sorted.
+		i := 0
+
+		copied, err := atree.NewMapFromBatchData(
+			storage,
+			address,
+			digesterBuilder,
+			typeInfo,
+			test_utils.CompareValue,
+			test_utils.GetHashInput,
+			m.Seed(),
+			func() (atree.Value, atree.Value, error) {
+				if i == len(keys) {
+					return nil, nil, nil
+				}
+				k := keys[i]
+				i++
+				return k, k, nil
+			})
+		require.NoError(t, err)
+
+		require.Equal(t, iterationOrder(t, m), iterationOrder(t, copied))
+		require.Equal(t, expectedKeys, iterationOrder(t, copied))
+	})
+}
+
+func TestMapDumpJSON(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	valueToJSON := func(v atree.Value) (any, error) {
+		switch v := v.(type) {
+		case test_utils.Uint64Value:
+			return uint64(v), nil
+		case test_utils.StringValue:
+			return v.String(), nil
+		default:
+			return nil, fmt.Errorf("unexpected value %T", v)
+		}
+	}
+
+	storage := newTestPersistentStorage(t)
+
+	digesterBuilder := &mockDigesterBuilder{}
+
+	m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+	require.NoError(t, err)
+
+	// Child array [1, "a"]
+	childArray, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+
+	err = childArray.Append(test_utils.Uint64Value(1))
+	require.NoError(t, err)
+
+	err = childArray.Append(test_utils.NewStringValue("a"))
+	require.NoError(t, err)
+
+	// Child map {"x": 10}
+	childMap, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+	require.NoError(t, err)
+
+	childKey := test_utils.NewStringValue("x")
+	digesterBuilder.On("Digest", childKey).Return(mockDigester{d: []atree.Digest{0}})
+
+	existingStorable, err := childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, childKey, test_utils.Uint64Value(10))
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+
+	entries := []struct {
+		key    atree.Value
+		value  atree.Value
+		digest atree.Digest
+	}{
+		{key: test_utils.NewStringValue("map"), value: childMap, digest: 3},
+		{key: test_utils.Uint64Value(1), value: test_utils.NewStringValue("one"), digest: 1},
+		{key: test_utils.NewStringValue("array"), value: childArray, digest: 2},
+	}
+
+	for _, e := range entries {
+		digesterBuilder.On("Digest", e.key).Return(mockDigester{d: []atree.Digest{e.digest}})
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, e.key, e.value)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+	}
+
+	const expectedJSON = `[{"key":1,"value":"one"},{"key":"array","value":[1,"a"]},{"key":"map","value":[{"key":"x","value":10}]}]` + "\n"
+
+	var buf bytes.Buffer
+	err = atree.DumpMapJSON(m, valueToJSON, &buf)
+	require.NoError(t, err)
+	require.True(t, json.Valid(buf.Bytes()))
+	require.Equal(t, expectedJSON, buf.String())
+
+	// Output is stable.
+	buf.Reset()
+	err = atree.DumpMapJSON(m, valueToJSON, &buf)
+	require.NoError(t, err)
+	require.Equal(t, expectedJSON, buf.String())
+
+	buf.Reset()
+	err = atree.DumpArrayJSON(childArray, valueToJSON, &buf)
+	require.NoError(t, err)
+	require.Equal(t, `[1,"a"]`+"\n", buf.String())
+
+	t.Run("error", func(t *testing.T) {
+		testErr := errors.New("test")
+
+		err := atree.DumpMapJSON(m, func(atree.Value) (any, error) { return nil, testErr }, io.Discard)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.ErrorIs(t, err, testErr)
+	})
+}
+
+func TestMapIterateForIndex(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	type indexEntry struct {
+		key    atree.Value
+		value  atree.Value
+		digest atree.Digest
+		slabID atree.SlabID
+	}
+
+	testIterateForIndex := func(t *testing.T, digesterBuilder atree.DigesterBuilder, keys []atree.Value) *atree.OrderedMap {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		for i, k := range keys {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		var entries []indexEntry
+		err = m.IterateForIndex(func(k atree.Value, v atree.Value, digest atree.Digest, slabID atree.SlabID) (bool, error) {
+			entries = append(entries, indexEntry{key: k, value: v, digest: digest, slabID: slabID})
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, len(keys), len(entries))
+
+		// Iteration order is the same as Iterate.
+		i := 0
+		err = m.IterateReadOnly(func(k atree.Value, v atree.Value) (bool, error) {
+			require.Equal(t, entries[i].key, k)
+			require.Equal(t, entries[i].value, v)
+			i++
+			return true, nil
+		})
+		require.NoError(t, err)
+
+		// Cross-check with separate lookups.
+		for _, e := range entries {
+			v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, e.key)
+			require.NoError(t, err)
+			require.Equal(t, e.value, v)
+
+			slabID, found, err := m.DataSlabIDForKey(test_utils.CompareValue, test_utils.GetHashInput, e.key)
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Equal(t, slabID, e.slabID)
+
+			digester, err := atree.GetMapDigesterBuilder(m).Digest(test_utils.GetHashInput, e.key)
+			require.NoError(t, err)
+
+			digest, err := digester.Digest(0)
+			require.NoError(t, err)
+			require.Equal(t, digest, e.digest)
+		}
+
+		// Stop iteration early.
+		count := 0
+		err = m.IterateForIndex(func(atree.Value, atree.Value, atree.Digest, atree.SlabID) (bool, error) {
+			count++
+			return count < 3, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, count)
+
+		return m
+	}
+
+	t.Run("unique keys", func(t *testing.T) {
+		const mapCount = 512
+
+		keys := make([]atree.Value, mapCount)
+		for i := range keys {
+			keys[i] = test_utils.Uint64Value(i)
+		}
+
+		testIterateForIndex(t, atree.NewDefaultDigesterBuilder(), keys)
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		const mapCount = 512
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		keys := make([]atree.Value, mapCount)
+		for i := range keys {
+			k := test_utils.Uint64Value(i)
+			keys[i] = k
+
+			// Keys collide at first level, and groups of 64 elements
+			// are stored in external collision group slabs.
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: []atree.Digest{atree.Digest(i % 8), atree.Digest(i)}})
+		}
+
+		m := testIterateForIndex(t, digesterBuilder, keys)
+
+		stats, err := atree.GetMapStats(m)
+		require.NoError(t, err)
+		require.True(t, stats.CollisionDataSlabCount > 0)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		testErr := errors.New("test")
+
+		err = m.IterateForIndex(func(atree.Value, atree.Value, atree.Digest, atree.SlabID) (bool, error) {
+			return false, testErr
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.Equal(t, testErr, externalError.Unwrap())
+	})
+}
+
+func TestMapKeyReplacementOnSet(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// uintKey returns numeric value of Uint8Value and Uint64Value keys,
+	// so test_utils.Uint8Value(1) and test_utils.Uint64Value(1) are equal
+	// keys with different encodings.
+	uintKey := func(v atree.Value) (uint64, bool) {
+		switch v := v.(type) {
+		case test_utils.Uint8Value:
+			return uint64(v), true
+		case test_utils.Uint64Value:
+			return uint64(v), true
+		}
+		return 0, false
+	}
+
+	comparator := func(storage atree.SlabStorage, value atree.Value, storable atree.Storable) (bool, error) {
+		other, err := storable.StoredValue(storage)
+		if err != nil {
+			return false, err
+		}
+		k1, ok1 := uintKey(value)
+		k2, ok2 := uintKey(other)
+		return ok1 && ok2 && k1 == k2, nil
+	}
+
+	hip := func(value atree.Value, scratch []byte) ([]byte, error) {
+		k, _ := uintKey(value)
+		return test_utils.Uint64Value(k).HashInput(scratch)
+	}
+
+	const mapCount = 8
+
+	testCases := []struct {
+		name        string
+		replace     bool
+		expectedKey atree.Value
+	}{
+		{name: "keep stored key", replace: false, expectedKey: test_utils.Uint64Value(3)},
+		{name: "replace stored key", replace: true, expectedKey: test_utils.Uint8Value(3)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storage := newTestPersistentStorage(t)
+
+			keys, err := atree.NewArray(storage, address, typeInfo)
+			require.NoError(t, err)
+
+			m, err := atree.NewMap(
+				storage,
+				address,
+				atree.NewDefaultDigesterBuilder(),
+				typeInfo,
+				atree.WithInsertionOrderIndex(keys),
+				atree.WithKeyReplacementOnSet(tc.replace),
+			)
+			require.NoError(t, err)
+
+			for i := range mapCount {
+				existingStorable, err := m.Set(comparator, hip, test_utils.Uint64Value(i), test_utils.Uint64Value(i*10))
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+			}
+
+			existingStorable, err := m.Set(comparator, hip, test_utils.Uint8Value(3), test_utils.Uint64Value(300))
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(30), existingStorable)
+			require.Equal(t, uint64(mapCount), m.Count())
+
+			storedKeys := make([]atree.Value, 0, mapCount)
+			err = m.IterateReadOnly(func(k atree.Value, _ atree.Value) (bool, error) {
+				if n, _ := uintKey(k); n == 3 {
+					storedKeys = append(storedKeys, k)
+				}
+				return true, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, []atree.Value{tc.expectedKey}, storedKeys)
+
+			value, err := m.Get(comparator, hip, test_utils.Uint64Value(3))
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(300), value)
+
+			// Insertion order index keeps position of replaced key.
+			i := 0
+			err = m.IterateInsertionOrder(comparator, hip, func(k atree.Value, v atree.Value) (bool, error) {
+				n, ok := uintKey(k)
+				require.True(t, ok)
+				require.Equal(t, uint64(i), n)
+				if n == 3 {
+					require.Equal(t, tc.expectedKey, k)
+					require.Equal(t, test_utils.Uint64Value(300), v)
+				}
+				i++
+				return true, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, mapCount, i)
+
+			err = atree.VerifyMap(m, address, typeInfo, test_utils.CompareTypeInfo, hip, true)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestMapSetReturningSlabDelta(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 256
+
+	storage := atree.NewBasicSlabStorage(nil, nil, nil, nil)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	// Slab delta of each Set matches change of slab count in storage.
+	set := func(k, v atree.Value) (int, int) {
+		count := storage.Count()
+
+		_, created, removed, err := m.SetReturningSlabDelta(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+		require.NoError(t, err)
+		require.Equal(t, storage.Count()-count, created-removed)
+
+		return created, removed
+	}
+
+	t.Run("split", func(t *testing.T) {
+		totalCreated := 0
+		for i := range mapCount {
+			created, removed := set(test_utils.Uint64Value(i), test_utils.NewStringValue(strings.Repeat("a", 16)))
+			require.Equal(t, 0, removed)
+			totalCreated += created
+		}
+		require.Equal(t, uint64(mapCount), m.Count())
+		require.Positive(t, totalCreated)
+		require.Equal(t, 1+totalCreated, storage.Count())
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		countBefore := storage.Count()
+
+		totalRemoved := 0
+		for i := range mapCount {
+			created, removed := set(test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.Equal(t, 0, created)
+			totalRemoved += removed
+		}
+		require.Equal(t, uint64(mapCount), m.Count())
+		require.Positive(t, totalRemoved)
+		require.Equal(t, countBefore-totalRemoved, storage.Count())
+	})
+
+	// Storage of map isn't changed by SetReturningSlabDelta.
+	require.Equal(t, atree.SlabStorage(storage), m.Storage)
+
+	t.Run("insertion order index", func(t *testing.T) {
+		storage := atree.NewBasicSlabStorage(nil, nil, nil, nil)
+
+		keys, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, atree.WithInsertionOrderIndex(keys))
+		require.NoError(t, err)
+
+		// Slabs of insertion order index are counted.
+		for i := range mapCount {
+			count := storage.Count()
+
+			k := test_utils.NewStringValue(fmt.Sprintf("%016d", i))
+
+			_, created, removed, err := m.SetReturningSlabDelta(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Equal(t, storage.Count()-count, created-removed)
+		}
+
+		require.False(t, IsArrayRootDataSlab(keys))
+		require.Equal(t, atree.SlabStorage(storage), keys.Storage)
+	})
+
+	t.Run("child values", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			child, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+			require.NoError(t, err)
+
+			_, err = child.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+
+			_, _, _, err = m.SetReturningSlabDelta(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), child)
+			require.NoError(t, err)
+		}
+
+		// Map and child values use persistent storage, not counter,
+		// so operations requiring PersistentSlabStorage work.
+		require.Equal(t, atree.SlabStorage(storage), m.Storage)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		snapshotID, err := storage.Snapshot()
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+
+			child, ok := v.(*atree.OrderedMap)
+			require.True(t, ok)
+			require.Equal(t, atree.SlabStorage(storage), child.Storage)
+
+			_, err = child.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(mapCount+i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		added, removed, modified, err := m.DiffSince(snapshotID)
+		require.NoError(t, err)
+		require.Empty(t, added)
+		require.Empty(t, removed)
+		require.Empty(t, modified)
+	})
+}
+
+func TestMapDataSlabSizeEstimate(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("data slab", func(t *testing.T) {
+		const mapCount = 256
+
+		r := newRand(t)
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.NewStringValue(randStr(r, r.Intn(32)))
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		dataSlabCount := 0
+		for id, slab := range atree.GetDeltas(storage) {
+			dataSlab, ok := slab.(*atree.MapDataSlab)
+			if !ok || id == m.SlabID() {
+				continue
+			}
+
+			b, err := atree.EncodeSlab(dataSlab, atree.GetCBOREncMode(storage))
+			require.NoError(t, err)
+
+			// Child storables of map data slab are keys and values
+			// of elements in order.
+			storables := atree.GetMapSlabStorables(dataSlab)
+			require.Equal(t, 0, len(storables)%2)
+
+			count := len(storables) / 2
+			hkeys := make([]atree.Digest, count)
+			keys := make([]atree.Storable, count)
+			values := make([]atree.Storable, count)
+			for i := range count {
+				hkeys[i] = atree.Digest(i)
+				keys[i] = storables[i*2]
+				values[i] = storables[i*2+1]
+			}
+
+			estimatedSize, err := atree.EstimateMapDataSlabSize(hkeys, keys, values)
+			require.NoError(t, err)
+			require.Equal(t, dataSlab.ByteSize(), estimatedSize)
+
+			// Next slab ID isn't encoded for the last data slab, and
+			// prev slab ID is encoded but isn't in the size model.
+			encodedSize := estimatedSize
+			next, prev := atree.GetMapDataSlabNextAndPrevSlabIDs(dataSlab)
+			if next == atree.SlabIDUndefined {
+				encodedSize -= atree.SlabIDLength
+			}
+			if prev != atree.SlabIDUndefined {
+				encodedSize += atree.SlabIDLength
+			}
+			require.Equal(t, uint32(len(b)), encodedSize)
+
+			dataSlabCount++
+		}
+		require.True(t, dataSlabCount > 1)
+	})
+
+	t.Run("mismatched length", func(t *testing.T) {
+		_, err := atree.EstimateMapDataSlabSize(
+			[]atree.Digest{0, 1},
+			[]atree.Storable{test_utils.Uint64Value(0), test_utils.Uint64Value(1)},
+			[]atree.Storable{test_utils.Uint64Value(0)},
+		)
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+	})
+}
+
+// providerHashInputValue wraps Uint64Value without implementing
+// atree.FixedSizeHashInputValue, so its hash input is from HashInputProvider.
+type providerHashInputValue struct {
+	v test_utils.Uint64Value
+}
+
+var _ atree.Value = providerHashInputValue{}
+
+func (v providerHashInputValue) Storable(storage atree.SlabStorage, address atree.Address, maxInlineSize uint64) (atree.Storable, error) {
+	return v.v.Storable(storage, address, maxInlineSize)
+}
+
+func TestMapFixedSizeHashInput(t *testing.T) {
+
+	values := []uint64{0, 23, 24, math.MaxUint8, math.MaxUint8 + 1, math.MaxUint16, math.MaxUint16 + 1, math.MaxUint32, math.MaxUint32 + 1, math.MaxUint64}
+
+	r := newRand(t)
+	for range 100 {
+		values = append(values, r.Uint64())
+	}
+
+	providerHashInput := func(value atree.Value, scratch []byte) ([]byte, error) {
+		return value.(providerHashInputValue).v.HashInput(scratch)
+	}
+
+	digesterBuilders := map[string]atree.DigesterBuilder{
+		"default": atree.NewDefaultDigesterBuilder(),
+		"integer key": atree.NewIntegerKeyDigesterBuilder(func(atree.Value) (uint64, bool) {
+			// Use hash input for level 0 digest too.
+			return 0, false
+		}),
+	}
+
+	for name, digesterBuilder := range digesterBuilders {
+		t.Run(name, func(t *testing.T) {
+			digesterBuilder.SetSeed(r.Uint64()|1, r.Uint64())
+
+			for _, n := range values {
+				// FixedSizeHashInput is used with nil and non-nil HashInputProvider.
+				fixedSizeDigester, err := digesterBuilder.Digest(nil, test_utils.Uint64Value(n))
+				require.NoError(t, err)
+
+				fixedSizeWithProviderDigester, err := digesterBuilder.Digest(test_utils.GetHashInput, test_utils.Uint64Value(n))
+				require.NoError(t, err)
+
+				providerDigester, err := digesterBuilder.Digest(providerHashInput, providerHashInputValue{v: test_utils.Uint64Value(n)})
+				require.NoError(t, err)
+
+				require.Equal(t, providerDigester.Levels(), fixedSizeDigester.Levels())
+				require.Equal(t, providerDigester.Levels(), fixedSizeWithProviderDigester.Levels())
+
+				for level := range fixedSizeDigester.Levels() {
+					expected, err := providerDigester.Digest(level)
+					require.NoError(t, err)
+
+					digest, err := fixedSizeDigester.Digest(level)
+					require.NoError(t, err)
+					require.Equal(t, expected, digest, "key %d level %d", n, level)
+
+					digest, err = fixedSizeWithProviderDigester.Digest(level)
+					require.NoError(t, err)
+					require.Equal(t, expected, digest, "key %d level %d", n, level)
+				}
+			}
+		})
+	}
+
+	t.Run("HashInputProvider isn't used for FixedSizeHashInputValue", func(t *testing.T) {
+		testErr := errors.New("test")
+		hip := func(atree.Value, []byte) ([]byte, error) {
+			return nil, testErr
+		}
+
+		for name, digesterBuilder := range digesterBuilders {
+			// Non-nil HashInputProvider isn't called for FixedSizeHashInputValue.
+			digester, err := digesterBuilder.Digest(hip, test_utils.Uint64Value(1))
+			require.NoError(t, err, name)
+
+			// Integer key digester gets hash input lazily.
+			_, err = digester.Digest(1)
+			require.NoError(t, err, name)
+
+			// Non-nil HashInputProvider is called for other values.
+			digester, err = digesterBuilder.Digest(hip, providerHashInputValue{v: test_utils.Uint64Value(1)})
+			if err == nil {
+				_, err = digester.Digest(1)
+			}
+
+			var externalError *atree.ExternalError
+			require.ErrorAs(t, err, &externalError, name)
+			require.ErrorIs(t, err, testErr, name)
+		}
+	})
+
+	t.Run("nil HashInputProvider", func(t *testing.T) {
+		for name, digesterBuilder := range digesterBuilders {
+			// Nil HashInputProvider can't be used for value without FixedSizeHashInput.
+			digester, err := digesterBuilder.Digest(nil, providerHashInputValue{v: test_utils.Uint64Value(1)})
+			if err == nil {
+				_, err = digester.Digest(1)
+			}
+
+			var userError *atree.UserError
+			require.Equal(t, 1, errorCategorizationCount(err), name)
+			require.ErrorAs(t, err, &userError, name)
+		}
+	})
+
+	t.Run("map with nil HashInputProvider", func(t *testing.T) {
+		typeInfo := test_utils.NewSimpleTypeInfo(42)
+		address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		const mapCount = 1024
+
+		keyValues := make(test_utils.ExpectedMapValue, mapCount)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+
+			existingStorable, err := m.Set(test_utils.CompareValue, nil, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		// Keys set with nil HashInputProvider are found with HashInputProvider.
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+}
+
+func TestMapDiffSince(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 100
+
+	largeKey := test_utils.NewStringValue(strings.Repeat("k", 256))
+
+	newMapAndSnapshot := func(t *testing.T) (*atree.PersistentSlabStorage, *atree.OrderedMap, int) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, largeKey, test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		snapshotID, err := storage.Snapshot()
+		require.NoError(t, err)
+
+		return storage, m, snapshotID
+	}
+
+	set := func(t *testing.T, m *atree.OrderedMap, k, v atree.Value) {
+		_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+		require.NoError(t, err)
+	}
+
+	remove := func(t *testing.T, m *atree.OrderedMap, k atree.Value) {
+		_, _, err := m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+		require.NoError(t, err)
+	}
+
+	t.Run("no change", func(t *testing.T) {
+		_, m, snapshotID := newMapAndSnapshot(t)
+
+		added, removed, modified, err := m.DiffSince(snapshotID)
+		require.NoError(t, err)
+		require.Empty(t, added)
+		require.Empty(t, removed)
+		require.Empty(t, modified)
+	})
+
+	t.Run("mixed changes", func(t *testing.T) {
+		storage, m, snapshotID := newMapAndSnapshot(t)
+
+		var expectedAdded, expectedRemoved, expectedModified []atree.Value
+
+		// Modify keys [0, 10).
+		for i := range 10 {
+			set(t, m, test_utils.Uint64Value(i), test_utils.Uint64Value(i+1000))
+			expectedModified = append(expectedModified, test_utils.Uint64Value(i))
+		}
+
+		// Remove keys [10, 20) and key stored in separate slab.
+		for i := 10; i < 20; i++ {
+			remove(t, m, test_utils.Uint64Value(i))
+			expectedRemoved = append(expectedRemoved, test_utils.Uint64Value(i))
+		}
+		remove(t, m, largeKey)
+		expectedRemoved = append(expectedRemoved, largeKey)
+
+		// Set key 20 to the same value.
+		set(t, m, test_utils.Uint64Value(20), test_utils.Uint64Value(20))
+
+		// Commit in the middle of changes.
+		err := storage.Commit()
+		require.NoError(t, err)
+
+		// Add keys [mapCount, mapCount+10).
+		for i := mapCount; i < mapCount+10; i++ {
+			set(t, m, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			expectedAdded = append(expectedAdded, test_utils.Uint64Value(i))
+		}
+
+		// Add then remove key: not reported.
+		set(t, m, test_utils.Uint64Value(mapCount+100), test_utils.Uint64Value(0))
+		remove(t, m, test_utils.Uint64Value(mapCount+100))
+
+		// Remove then set key with the same value: not reported.
+		remove(t, m, test_utils.Uint64Value(21))
+		set(t, m, test_utils.Uint64Value(21), test_utils.Uint64Value(21))
+
+		// Remove then set key with different value: modified.
+		remove(t, m, test_utils.Uint64Value(22))
+		set(t, m, test_utils.Uint64Value(22), test_utils.Uint64Value(2200))
+		expectedModified = append(expectedModified, test_utils.Uint64Value(22))
+
+		// Modify then restore value: not reported.
+		set(t, m, test_utils.Uint64Value(23), test_utils.Uint64Value(2300))
+		set(t, m, test_utils.Uint64Value(23), test_utils.Uint64Value(23))
+
+		added, removed, modified, err := m.DiffSince(snapshotID)
+		require.NoError(t, err)
+		require.ElementsMatch(t, expectedAdded, added)
+		require.ElementsMatch(t, expectedRemoved, removed)
+		require.ElementsMatch(t, expectedModified, modified)
+
+		// Diff is the same after commit.
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		added, removed, modified, err = m.DiffSince(snapshotID)
+		require.NoError(t, err)
+		require.ElementsMatch(t, expectedAdded, added)
+		require.ElementsMatch(t, expectedRemoved, removed)
+		require.ElementsMatch(t, expectedModified, modified)
+	})
+
+	t.Run("child containers", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range 10 {
+			child, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+			require.NoError(t, err)
+
+			set(t, m, test_utils.Uint64Value(i), child)
+		}
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		snapshotID, err := storage.Snapshot()
+		require.NoError(t, err)
+
+		// Child containers are compared by slab ID, so modifying inlined
+		// child maps doesn't modify parent map values.
+		for i := range 10 {
+			v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+
+			child, ok := v.(*atree.OrderedMap)
+			require.True(t, ok)
+			require.True(t, child.Inlined())
+
+			set(t, child, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		}
+
+		added, removed, modified, err := m.DiffSince(snapshotID)
+		require.NoError(t, err)
+		require.Empty(t, added)
+		require.Empty(t, removed)
+		require.Empty(t, modified)
+
+		// Replacing child container modifies parent map value.
+		child, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		set(t, m, test_utils.Uint64Value(0), child)
+
+		added, removed, modified, err = m.DiffSince(snapshotID)
+		require.NoError(t, err)
+		require.Empty(t, added)
+		require.Empty(t, removed)
+		require.Equal(t, []atree.Value{test_utils.Uint64Value(0)}, modified)
+	})
+
+	t.Run("map created after snapshot", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		snapshotID, err := storage.Snapshot()
+		require.NoError(t, err)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		var expectedAdded []atree.Value
+		for i := range 10 {
+			set(t, m, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			expectedAdded = append(expectedAdded, test_utils.Uint64Value(i))
+		}
+
+		added, removed, modified, err := m.DiffSince(snapshotID)
+		require.NoError(t, err)
+		require.ElementsMatch(t, expectedAdded, added)
+		require.Empty(t, removed)
+		require.Empty(t, modified)
+	})
+
+	t.Run("released snapshot", func(t *testing.T) {
+		storage, m, snapshotID := newMapAndSnapshot(t)
+
+		storage.ReleaseSnapshot(snapshotID)
+
+		_, _, _, err := m.DiffSince(snapshotID)
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+	})
+
+	t.Run("storage isn't PersistentSlabStorage", func(t *testing.T) {
+		storage := atree.NewBasicSlabStorage(nil, nil, nil, nil)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		_, _, _, err = m.DiffSince(1)
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+	})
+}
+
+func TestMapOperationAutoCommit(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 100
+
+	t.Run("base storage reflects each operation", func(t *testing.T) {
+		baseStorage := &txBaseStorage{InMemBaseStorage: test_utils.NewInMemBaseStorage()}
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		// Creating map isn't an operation of map with auto-commit.
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		m, err = m.WithOperationAutoCommit()
+		require.NoError(t, err)
+
+		txCount := baseStorage.txCount
+
+		expectedValues := make(map[atree.Value]atree.Value)
+
+		// verifyBaseStorage loads map from base storage with new storage
+		// and checks that loaded map has expected elements.
+		verifyBaseStorage := func(t *testing.T) {
+			require.Equal(t, 0, GetDeltasCount(storage))
+
+			loadedStorage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			loadedMap, err := atree.NewMapWithRootID(loadedStorage, m.SlabID(), atree.NewDefaultDigesterBuilder())
+			require.NoError(t, err)
+			require.Equal(t, uint64(len(expectedValues)), loadedMap.Count())
+
+			for k, expected := range expectedValues {
+				v, err := loadedMap.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+				require.NoError(t, err)
+				require.Equal(t, expected, v)
+			}
+		}
+
+		verifyBaseStorage(t)
+
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i)
+
+			_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+
+			expectedValues[k] = v
+
+			verifyBaseStorage(t)
+
+			// Each operation is committed in one transaction.
+			txCount++
+			require.Equal(t, txCount, baseStorage.txCount)
+		}
+
+		for i := 0; i < mapCount; i += 2 {
+			k := test_utils.Uint64Value(i)
+
+			_, _, err := m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+
+			delete(expectedValues, k)
+
+			verifyBaseStorage(t)
+		}
+
+		err = m.PopIterate(func(atree.Storable, atree.Storable) {})
+		require.NoError(t, err)
+
+		clear(expectedValues)
+
+		verifyBaseStorage(t)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		_, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		require.Positive(t, GetDeltasCount(storage))
+	})
+
+	t.Run("storage isn't PersistentSlabStorage", func(t *testing.T) {
+		storage := atree.NewBasicSlabStorage(nil, nil, nil, nil)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		_, err = m.WithOperationAutoCommit()
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+	})
+
+	t.Run("base storage isn't transactional", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		_, err = m.WithOperationAutoCommit()
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+	})
+
+	t.Run("commit failure", func(t *testing.T) {
+		baseStorage := &txBaseStorage{InMemBaseStorage: test_utils.NewInMemBaseStorage()}
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		_, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		m, err = m.WithOperationAutoCommit()
+		require.NoError(t, err)
+
+		// Storing root slab fails in transaction.
+		baseStorage.failStoreID = m.SlabID()
+
+		_, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(1))
+		require.ErrorIs(t, err, errTxStore)
+
+		// Operation is applied in memory and remains uncommitted.
+		require.Equal(t, uint64(2), m.Count())
+		require.Positive(t, GetDeltasCount(storage))
+
+		// Base storage isn't changed by failed transaction.
+		loadedMap, err := atree.NewMapWithRootID(newTestPersistentStorageWithBaseStorage(t, baseStorage), m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), loadedMap.Count())
+
+		// Commit can be retried.
+		baseStorage.failStoreID = atree.SlabIDUndefined
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		loadedMap, err = atree.NewMapWithRootID(newTestPersistentStorageWithBaseStorage(t, baseStorage), m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), loadedMap.Count())
+	})
+}
+
+func TestMapExtraData(t *testing.T) {
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const seed = uint64(0x0123456789abcdef)
+	const mapCount = 10
+
+	storage := newTestPersistentStorage(t)
+
+	m, err := atree.NewMapWithSeed(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, seed)
+	require.NoError(t, err)
+
+	for i := range mapCount {
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+	}
+
+	err = m.SetSchemaID(7)
+	require.NoError(t, err)
+
+	extraData := m.ExtraData()
+	require.Equal(t, seed, extraData.Seed)
+	require.Equal(t, m.Seed(), extraData.Seed)
+	require.Equal(t, uint64(mapCount), extraData.Count)
+	require.Equal(t, uint64(7), extraData.SchemaID)
+	require.Equal(t, typeInfo, extraData.TypeInfo)
+
+	// Modifying returned extra data doesn't modify map.
+	extraData.Count = 0
+	extraData.Seed = 1
+	require.Equal(t, uint64(mapCount), m.Count())
+	require.Equal(t, seed, m.Seed())
+
+	// Extra data of loaded map is the same.
+	err = storage.Commit()
+	require.NoError(t, err)
+
+	storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+	m2, err := atree.NewMapWithRootID(storage2, m.SlabID(), atree.NewDefaultDigesterBuilder())
+	require.NoError(t, err)
+	require.Equal(t, m.ExtraData(), m2.ExtraData())
+}
+
+func TestMapTupleKey(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	newTuple := func(t *testing.T, elements ...atree.Value) *atree.TupleValue {
+		tuple, err := atree.NewTupleValue(elements...)
+		require.NoError(t, err)
+		return tuple
+	}
+
+	t.Run("round-trip", func(t *testing.T) {
+		const mapCount = 100
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		keys := make([]*atree.TupleValue, mapCount)
+		for i := range keys {
+			id := fmt.Sprintf("id%d", i)
+			if i%10 == 0 {
+				// Large tuple is stored in a separate slab.
+				id = strings.Repeat(id, 50)
+			}
+			keys[i] = newTuple(t, test_utils.Uint64Value(i%7), test_utils.NewStringValue(id))
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, keys[i], test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		// Overwrite value with equal tuple key.
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, newTuple(t, test_utils.Uint64Value(1), test_utils.NewStringValue("id1")), test_utils.Uint64Value(1000))
+		require.NoError(t, err)
+		require.Equal(t, test_utils.Uint64Value(1), existingStorable)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		// Load map from base storage.
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		m2, err := atree.NewMapWithRootID(storage2, m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+		require.Equal(t, uint64(mapCount), m2.Count())
+
+		for i, k := range keys {
+			expected := test_utils.Uint64Value(i)
+			if i == 1 {
+				expected = test_utils.Uint64Value(1000)
+			}
+
+			v, err := m2.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.Equal(t, expected, v)
+		}
+
+		// Stored keys are TupleValue.
+		count := 0
+		err = m2.IterateReadOnlyKeys(func(k atree.Value) (bool, error) {
+			tuple, ok := k.(*atree.TupleValue)
+			require.True(t, ok)
+			require.Equal(t, 2, tuple.Count())
+
+			found, err := m2.Has(test_utils.CompareValue, test_utils.GetHashInput, tuple)
+			require.NoError(t, err)
+			require.True(t, found)
+
+			count++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, mapCount, count)
+
+		// Tuple with different elements isn't found.
+		found, err := m2.Has(test_utils.CompareValue, test_utils.GetHashInput, newTuple(t, test_utils.Uint64Value(1), test_utils.NewStringValue("id2")))
+		require.NoError(t, err)
+		require.False(t, found)
+
+		found, err = m2.Has(test_utils.CompareValue, test_utils.GetHashInput, newTuple(t, test_utils.Uint64Value(1)))
+		require.NoError(t, err)
+		require.False(t, found)
+
+		// Remove keys.
+		for _, k := range keys {
+			_, _, err := m2.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+		}
+		require.Equal(t, uint64(0), m2.Count())
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		const mapCount = 10
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		// All keys have the same digests at all levels.
+		keys := make([]*atree.TupleValue, mapCount)
+		for i := range keys {
+			keys[i] = newTuple(t, test_utils.NewStringValue("a"), test_utils.Uint64Value(i))
+			digesterBuilder.On("Digest", keys[i]).Return(mockDigester{d: []atree.Digest{1, 2}})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, keys[i], test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		for i, k := range keys {
+			v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(i), v)
+		}
+
+		missingKey := newTuple(t, test_utils.NewStringValue("a"), test_utils.Uint64Value(mapCount))
+		digesterBuilder.On("Digest", missingKey).Return(mockDigester{d: []atree.Digest{1, 2}})
+
+		_, err = m.Get(test_utils.CompareValue, test_utils.GetHashInput, missingKey)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var keyNotFoundError *atree.KeyNotFoundError
+		require.ErrorAs(t, err, &keyNotFoundError)
+	})
+
+	t.Run("hash input", func(t *testing.T) {
+		tuple1 := newTuple(t, test_utils.NewStringValue("ab"), test_utils.NewStringValue("c"))
+		tuple2 := newTuple(t, test_utils.NewStringValue("a"), test_utils.NewStringValue("bc"))
+
+		b1, err := test_utils.GetHashInput(tuple1, nil)
+		require.NoError(t, err)
+
+		b2, err := test_utils.GetHashInput(tuple2, nil)
+		require.NoError(t, err)
+
+		require.NotEqual(t, b1, b2)
+
+		// Hash input is stable.
+		b, err := test_utils.GetHashInput(newTuple(t, test_utils.NewStringValue("ab"), test_utils.NewStringValue("c")), nil)
+		require.NoError(t, err)
+		require.Equal(t, b1, b)
+	})
+
+	t.Run("container element", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		_, err = atree.NewTupleValue(test_utils.Uint64Value(0), array)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+	})
+
+	t.Run("interned string element", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		dictionary, err := atree.NewStringDictionary(storage, address)
+		require.NoError(t, err)
+
+		_, err = atree.NewTupleValue(test_utils.Uint64Value(0), dictionary.Intern("a"))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+	})
+
+	t.Run("deep copy", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		tuple := newTuple(t, test_utils.Uint64Value(1), newTuple(t, test_utils.NewStringValue("a")))
+
+		copied, err := tuple.DeepCopy(storage, address, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.Equal(t, tuple, copied)
+		require.NotSame(t, tuple, copied)
+	})
+}
+
+func TestMapResetWithRootID(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	storage := newTestPersistentStorage(t)
+
+	type expectedMap struct {
+		slabID   atree.SlabID
+		typeInfo atree.TypeInfo
+		seed     uint64
+		values   map[atree.Value]atree.Value
+	}
+
+	// Create maps with different type info, seed, and count.
+	mapCounts := []int{0, 5, 200, 20}
+	expectedMaps := make([]expectedMap, len(mapCounts))
+
+	for i, mapCount := range mapCounts {
+		typeInfo := test_utils.NewSimpleTypeInfo(uint64(i))
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		values := make(map[atree.Value]atree.Value)
+		for j := range mapCount {
+			k := test_utils.Uint64Value(j)
+			v := test_utils.Uint64Value(i*1000 + j)
+			values[k] = v
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		expectedMaps[i] = expectedMap{
+			slabID:   m.SlabID(),
+			typeInfo: typeInfo,
+			seed:     m.Seed(),
+			values:   values,
+		}
+	}
+
+	err := storage.Commit()
+	require.NoError(t, err)
+
+	storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+	digesterBuilder := atree.NewDefaultDigesterBuilder()
+
+	m, err := atree.NewMapWithRootID(storage2, expectedMaps[len(expectedMaps)-1].slabID, digesterBuilder)
+	require.NoError(t, err)
+
+	// Iterator of previous map can't be used after reset.
+	iter, err := m.Iterator(test_utils.CompareValue, test_utils.GetHashInput)
+	require.NoError(t, err)
+
+	err = m.ResetWithRootID(storage2, expectedMaps[0].slabID, digesterBuilder)
+	require.NoError(t, err)
+
+	_, _, err = iter.Next()
+	require.Equal(t, 1, errorCategorizationCount(err))
+	var concurrentModificationError *atree.ConcurrentModificationError
+	require.ErrorAs(t, err, &concurrentModificationError)
+
+	// Reuse map object for every map.
+	for _, expected := range expectedMaps {
+		err = m.ResetWithRootID(storage2, expected.slabID, digesterBuilder)
+		require.NoError(t, err)
+
+		require.Equal(t, expected.slabID, m.SlabID())
+		require.Equal(t, uint64(len(expected.values)), m.Count())
+		require.Equal(t, expected.typeInfo, m.Type())
+		require.Equal(t, expected.seed, m.Seed())
+
+		for k, v := range expected.values {
+			value, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.Equal(t, v, value)
+		}
+
+		count := 0
+		err = m.IterateReadOnly(func(k, v atree.Value) (bool, error) {
+			require.Equal(t, expected.values[k], v)
+			count++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, len(expected.values), count)
+	}
+
+	// Reused map can be modified.
+	expected := expectedMaps[1]
+
+	err = m.ResetWithRootID(storage2, expected.slabID, digesterBuilder)
+	require.NoError(t, err)
+
+	existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1000), test_utils.Uint64Value(1000))
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+	require.Equal(t, uint64(len(expected.values)+1), m.Count())
+
+	// Failed reset doesn't modify map.
+	array, err := atree.NewArray(storage2, address, test_utils.NewSimpleTypeInfo(42))
+	require.NoError(t, err)
+
+	err = m.ResetWithRootID(storage2, array.SlabID(), digesterBuilder)
+	require.Error(t, err)
+	require.Equal(t, expected.slabID, m.SlabID())
+	require.Equal(t, uint64(len(expected.values)+1), m.Count())
+
+	err = m.ResetWithRootID(storage2, atree.SlabIDUndefined, digesterBuilder)
+	require.Equal(t, 1, errorCategorizationCount(err))
+	var slabIDError *atree.SlabIDError
+	require.ErrorAs(t, err, &slabIDError)
+	require.Equal(t, expected.slabID, m.SlabID())
+}
+
+func TestMapContainerValueClassification(t *testing.T) {
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	storage := newTestPersistentStorage(t)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	set := func(k, v atree.Value) {
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+	}
+
+	// Scalar values
+	set(test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+	set(test_utils.Uint64Value(1), test_utils.NewStringValue("a"))
+	set(test_utils.Uint64Value(2), test_utils.NewSomeValue(test_utils.Uint64Value(2)))
+
+	// Inlined child array
+	childArray, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+	set(test_utils.Uint64Value(3), childArray)
+
+	// Not inlined child map
+	childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+	for i := range 100 {
+		existingStorable, err := childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+	}
+	set(test_utils.Uint64Value(4), childMap)
+
+	// Wrapped not inlined child array
+	wrappedArray, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+	for i := range 1000 {
+		err := wrappedArray.Append(test_utils.Uint64Value(i))
+		require.NoError(t, err)
+	}
+	set(test_utils.Uint64Value(5), test_utils.NewSomeValue(wrappedArray))
+
+	type classification struct {
+		isContainer bool
+		rootID      atree.SlabID
+		hasRootID   bool
+	}
+
+	expected := map[atree.Value]classification{
+		test_utils.Uint64Value(0): {},
+		test_utils.Uint64Value(1): {},
+		test_utils.Uint64Value(2): {},
+		test_utils.Uint64Value(3): {isContainer: true},
+		test_utils.Uint64Value(4): {isContainer: true, rootID: childMap.SlabID(), hasRootID: true},
+		test_utils.Uint64Value(5): {isContainer: true, rootID: wrappedArray.SlabID(), hasRootID: true},
+	}
+
+	count := 0
+	err = m.IterateReadOnly(func(k, v atree.Value) (bool, error) {
+		e := expected[k]
+
+		require.Equal(t, e.isContainer, atree.IsContainerValue(v))
+
+		rootID, ok := atree.ContainerRootID(v)
+		require.Equal(t, e.hasRootID, ok)
+		require.Equal(t, e.rootID, rootID)
+
+		if ok {
+			// Nested container root slab is in storage.
+			_, found, err := storage.Retrieve(rootID)
+			require.NoError(t, err)
+			require.True(t, found)
+		}
+
+		count++
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(expected), count)
+}
+
+func TestMapCanonicalEncodeMode(t *testing.T) {
+
+	const keyCount = 16
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	encMode, err := cbor.EncOptions{}.EncMode()
+	require.NoError(t, err)
+
+	decMode, err := cbor.DecOptions{}.DecMode()
+	require.NoError(t, err)
+
+	r := newRand(t)
+
+	keys := make([]atree.Value, 0, keyCount)
+	digesterBuilder := &mockDigesterBuilder{}
+
+	for len(keys) < keyCount {
+		k := test_utils.NewStringValue(randStr(r, 8))
+		if slices.Contains(keys, atree.Value(k)) {
+			continue
+		}
+		keys = append(keys, k)
+
+		// All keys have the same digests at all levels.
+		digesterBuilder.On("Digest", k).Return(mockDigester{d: []atree.Digest{1, 2}})
+	}
+
+	reversedKeys := slices.Clone(keys)
+	slices.Reverse(reversedKeys)
+
+	newMap := func(t *testing.T, keys []atree.Value, opts ...atree.StorageOption) (*atree.PersistentSlabStorage, *atree.OrderedMap) {
+		storage := atree.NewPersistentSlabStorage(
+			test_utils.NewInMemBaseStorage(),
+			encMode,
+			decMode,
+			test_utils.DecodeStorable,
+			test_utils.DecodeTypeInfo,
+			opts...,
+		)
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		for _, k := range keys {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		return storage, m
+	}
+
+	t.Run("different insertion orders", func(t *testing.T) {
+		storage1, m1 := newMap(t, keys)
+		storage2, m2 := newMap(t, reversedKeys)
+		require.Equal(t, m1.SlabID(), m2.SlabID())
+
+		data1, err := atree.EncodeSlabCanonical(storage1, atree.GetMapRootSlab(m1), encMode)
+		require.NoError(t, err)
+
+		data2, err := atree.EncodeSlabCanonical(storage2, atree.GetMapRootSlab(m2), encMode)
+		require.NoError(t, err)
+
+		require.Equal(t, data1, data2)
+	})
+
+	t.Run("elements not ordered by key", func(t *testing.T) {
+		storage1, m1 := newMap(t, keys)
+		storage2, m2 := newMap(t, keys)
+
+		// Simulate elements decoded from data encoded before colliding elements were ordered by key.
+		atree.ReverseMapCollisionElements(m2)
+
+		data1, err := atree.EncodeSlab(atree.GetMapRootSlab(m1), encMode)
+		require.NoError(t, err)
+
+		data2, err := atree.EncodeSlab(atree.GetMapRootSlab(m2), encMode)
+		require.NoError(t, err)
+
+		require.NotEqual(t, data1, data2)
+
+		canonicalData1, err := atree.EncodeSlabCanonical(storage1, atree.GetMapRootSlab(m1), encMode)
+		require.NoError(t, err)
+		require.Equal(t, data1, canonicalData1)
+
+		canonicalData2, err := atree.EncodeSlabCanonical(storage2, atree.GetMapRootSlab(m2), encMode)
+		require.NoError(t, err)
+		require.Equal(t, canonicalData1, canonicalData2)
+	})
+
+	t.Run("keys stored in separate slabs", func(t *testing.T) {
+		const largeKeyCount = 8
+
+		largeKeys := make([]atree.Value, 0, largeKeyCount)
+		for len(largeKeys) < largeKeyCount {
+			k := test_utils.NewStringValue(randStr(r, 8) + strings.Repeat("k", 1024))
+			if slices.Contains(largeKeys, atree.Value(k)) {
+				continue
+			}
+			largeKeys = append(largeKeys, k)
+
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: []atree.Digest{1, 2}})
+		}
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		for i, k := range largeKeys {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+		require.True(t, IsMapRootDataSlab(m))
+
+		// Colliding elements in memory are ordered by content of
+		// key slabs, not by slab IDs (which follow insertion order).
+		data, err := atree.EncodeSlab(atree.GetMapRootSlab(m), encMode)
+		require.NoError(t, err)
+
+		canonicalData, err := atree.EncodeSlabCanonical(storage, atree.GetMapRootSlab(m), encMode)
+		require.NoError(t, err)
+		require.Equal(t, data, canonicalData)
+
+		atree.ReverseMapCollisionElements(m)
+
+		canonicalData, err = atree.EncodeSlabCanonical(storage, atree.GetMapRootSlab(m), encMode)
+		require.NoError(t, err)
+		require.Equal(t, data, canonicalData)
+	})
+
+	t.Run("storage option", func(t *testing.T) {
+		storage1, m1 := newMap(t, keys, atree.WithCanonicalEncodeMode())
+		storage2, m2 := newMap(t, reversedKeys, atree.WithCanonicalEncodeMode())
+
+		atree.ReverseMapCollisionElements(m2)
+
+		err := storage1.Commit()
+		require.NoError(t, err)
+
+		err = storage2.Commit()
+		require.NoError(t, err)
+
+		data1, found, err := atree.GetBaseStorage(storage1).Retrieve(m1.SlabID())
+		require.NoError(t, err)
+		require.True(t, found)
+
+		data2, found, err := atree.GetBaseStorage(storage2).Retrieve(m2.SlabID())
+		require.NoError(t, err)
+		require.True(t, found)
+
+		require.Equal(t, data1, data2)
+
+		// Committed map is decoded with elements ordered by key.
+		storage := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage2))
+
+		m, err := atree.NewMapWithRootID(storage, m2.SlabID(), digesterBuilder)
+		require.NoError(t, err)
+
+		expectedKeys := slices.Clone(keys)
+		slices.SortFunc(expectedKeys, func(a, b atree.Value) int {
+			return bytes.Compare(encodeCollisionKey(a), encodeCollisionKey(b))
+		})
+
+		var iteratedKeys []atree.Value
+		err = m.IterateReadOnlyKeys(func(k atree.Value) (bool, error) {
+			iteratedKeys = append(iteratedKeys, k)
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, expectedKeys, iteratedKeys)
+	})
+}
+
+func TestMapIterateWithoutLoadingExternalSlabs(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 50
+
+	// newMap returns map loaded from base storage which tracks retrieved slabs.
+	newMap := func(t *testing.T, largeKey bool) (*accessOrderTrackerBaseStorage, *atree.PersistentSlabStorage, *atree.OrderedMap) {
+		baseStorage := newAccessOrderTrackerBaseStorage()
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			var k, v atree.Value
+			if largeKey {
+				k = test_utils.NewStringValue(fmt.Sprintf("%04d", i) + strings.Repeat("k", 1000))
+				v = test_utils.Uint64Value(i)
+			} else {
+				k = test_utils.Uint64Value(i)
+				v = test_utils.NewStringValue(strings.Repeat("v", 1000))
+			}
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		storage = newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m, err = atree.NewMapWithRootID(storage, m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		baseStorage.segTouchOrder = baseStorage.segTouchOrder[:0]
+
+		return baseStorage, storage, m
+	}
+
+	// storableSlabCount returns number of retrieved StorableSlab.
+	storableSlabCount := func(t *testing.T, storage *atree.PersistentSlabStorage, ids []atree.SlabID) int {
+		count := 0
+		for _, id := range ids {
+			slab, found, err := storage.Retrieve(id)
+			require.NoError(t, err)
+			require.True(t, found)
+
+			if _, ok := slab.(*atree.StorableSlab); ok {
+				count++
+			}
+		}
+		return count
+	}
+
+	t.Run("keys", func(t *testing.T) {
+		baseStorage, storage, m := newMap(t, false)
+
+		count := 0
+		err := m.IterateKeysNoValueLoad(func(k atree.Value) (bool, error) {
+			require.IsType(t, test_utils.Uint64Value(0), k)
+			count++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, mapCount, count)
+
+		retrieved := slices.Clone(baseStorage.SegTouchOrder())
+		require.Equal(t, 0, storableSlabCount(t, storage, retrieved))
+
+		// Iterating values retrieves external value slabs.
+		baseStorage.segTouchOrder = baseStorage.segTouchOrder[:0]
+
+		err = m.IterateReadOnlyValues(func(atree.Value) (bool, error) {
+			return true, nil
+		})
+		require.NoError(t, err)
+
+		retrieved = slices.Clone(baseStorage.SegTouchOrder())
+		require.Equal(t, mapCount, storableSlabCount(t, storage, retrieved))
+	})
+
+	t.Run("values", func(t *testing.T) {
+		baseStorage, storage, m := newMap(t, true)
+
+		count := 0
+		err := m.IterateValuesLoadingOnly(func(v atree.Value) (bool, error) {
+			require.IsType(t, test_utils.Uint64Value(0), v)
+			count++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, mapCount, count)
+
+		retrieved := slices.Clone(baseStorage.SegTouchOrder())
+		require.Equal(t, 0, storableSlabCount(t, storage, retrieved))
+
+		// Iterating keys retrieves external key slabs.
+		baseStorage.segTouchOrder = baseStorage.segTouchOrder[:0]
+
+		err = m.IterateReadOnlyKeys(func(atree.Value) (bool, error) {
+			return true, nil
+		})
+		require.NoError(t, err)
+
+		retrieved = slices.Clone(baseStorage.SegTouchOrder())
+		require.Equal(t, mapCount, storableSlabCount(t, storage, retrieved))
+	})
+}
+
+func TestMapSetFromGoMap(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("empty", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		err = m.SetFromGoMap(test_utils.CompareValue, test_utils.GetHashInput, nil)
+		require.NoError(t, err)
+
+		err = m.SetFromGoMap(test_utils.CompareValue, test_utils.GetHashInput, map[atree.Value]atree.Value{})
+		require.NoError(t, err)
+
+		testMap(t, storage, typeInfo, address, m, test_utils.ExpectedMapValue{}, nil, false)
+	})
+
+	t.Run("same as set", func(t *testing.T) {
+		const mapCount = 1024
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		goMap := make(map[atree.Value]atree.Value, mapCount)
+		keys := make([]atree.Value, 0, mapCount)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			goMap[k] = v
+			keys = append(keys, k)
+
+			// Create collision groups
+			digests := []atree.Digest{atree.Digest(i / 4), atree.Digest(i)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+		}
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		err = m.SetFromGoMap(test_utils.CompareValue, test_utils.GetHashInput, goMap)
+		require.NoError(t, err)
+
+		testMap(t, storage, typeInfo, address, m, goMap, nil, false)
+
+		// Create map with per-element Set in reverse order.
+		expectedStorage := newTestPersistentStorage(t)
+
+		expectedMap, err := atree.NewMap(expectedStorage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		for i := len(keys) - 1; i >= 0; i-- {
+			k := keys[i]
+			existingStorable, err := expectedMap.Set(test_utils.CompareValue, test_utils.GetHashInput, k, goMap[k])
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		var expectedKeyValues [][2]atree.Value
+		err = expectedMap.IterateReadOnly(func(k, v atree.Value) (bool, error) {
+			expectedKeyValues = append(expectedKeyValues, [2]atree.Value{k, v})
+			return true, nil
+		})
+		require.NoError(t, err)
+
+		i := 0
+		err = m.IterateReadOnly(func(k, v atree.Value) (bool, error) {
+			testValueEqual(t, expectedKeyValues[i][0], k)
+			testValueEqual(t, expectedKeyValues[i][1], v)
+			i++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, mapCount, i)
+	})
+
+	t.Run("non-empty map", func(t *testing.T) {
+		const mapCount = 1024
+
+		r := newRand(t)
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		expectedValues := make(test_utils.ExpectedMapValue, mapCount*2)
+
+		for len(expectedValues) < mapCount {
+			k := test_utils.NewStringValue(randStr(r, 16))
+			v := test_utils.Uint64Value(r.Intn(1000))
+			expectedValues[k] = v
+
+			_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+		}
+
+		goMap := make(map[atree.Value]atree.Value, mapCount)
+		for len(goMap) < mapCount {
+			k := test_utils.NewStringValue(randStr(r, 17))
+			v := test_utils.Uint64Value(r.Intn(1000))
+			goMap[k] = v
+			expectedValues[k] = v
+		}
+
+		err = m.SetFromGoMap(test_utils.CompareValue, test_utils.GetHashInput, goMap)
+		require.NoError(t, err)
+
+		testMap(t, storage, typeInfo, address, m, expectedValues, nil, false)
+	})
+
+	t.Run("existing key", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		expectedValues := test_utils.ExpectedMapValue{
+			test_utils.Uint64Value(1): test_utils.Uint64Value(10),
+		}
+
+		_, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(10))
+		require.NoError(t, err)
+
+		goMap := map[atree.Value]atree.Value{
+			test_utils.Uint64Value(0): test_utils.Uint64Value(0),
+			test_utils.Uint64Value(1): test_utils.Uint64Value(100),
+			test_utils.Uint64Value(2): test_utils.Uint64Value(200),
+		}
+
+		err = m.SetFromGoMap(test_utils.CompareValue, test_utils.GetHashInput, goMap)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+
+		// Map isn't modified.
+		testMap(t, storage, typeInfo, address, m, expectedValues, nil, false)
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		readOnlyMap, err := atree.NewMapWithRootIDReadOnly(storage, m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		err = readOnlyMap.SetFromGoMap(test_utils.CompareValue, test_utils.GetHashInput, map[atree.Value]atree.Value{
+			test_utils.Uint64Value(0): test_utils.Uint64Value(0),
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var readOnlyError *atree.ReadOnlyError
+		require.ErrorAs(t, err, &readOnlyError)
+	})
+}
+
+func TestMapGetReturningSlabsRead(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	newMap := func(t *testing.T, mapCount int) *atree.OrderedMap {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		return m
+	}
+
+	// mapHeight returns number of slab levels of map.
+	mapHeight := func(t *testing.T, m *atree.OrderedMap) int {
+		height := 1
+
+		var slab atree.Slab = atree.GetMapRootSlab(m)
+		for {
+			metaDataSlab, ok := slab.(*atree.MapMetaDataSlab)
+			if !ok {
+				return height
+			}
+
+			childSlabIDs, _, _ := atree.GetMapMetaDataSlabChildInfo(metaDataSlab)
+
+			var found bool
+			var err error
+			slab, found, err = m.Storage.Retrieve(childSlabIDs[0])
+			require.NoError(t, err)
+			require.True(t, found)
+
+			height++
+		}
+	}
+
+	t.Run("tree depth", func(t *testing.T) {
+		prevSlabsRead := 0
+
+		for _, mapCount := range []int{1, 100, 1_000, 10_000} {
+			m := newMap(t, mapCount)
+
+			key := test_utils.Uint64Value(mapCount / 2)
+
+			storable, slabsRead, err := m.GetReturningSlabsRead(test_utils.CompareValue, test_utils.GetHashInput, key)
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(mapCount/2*2), storable)
+
+			// One slab is read at each level.
+			require.Equal(t, mapHeight(t, m), slabsRead)
+			require.Greater(t, slabsRead, prevSlabsRead)
+
+			prevSlabsRead = slabsRead
+		}
+	})
+
+	t.Run("key not found", func(t *testing.T) {
+		m := newMap(t, 1_000)
+		require.False(t, IsMapRootDataSlab(m))
+
+		storable, slabsRead, err := m.GetReturningSlabsRead(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1_000))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var keyNotFoundError *atree.KeyNotFoundError
+		require.ErrorAs(t, err, &keyNotFoundError)
+		require.Nil(t, storable)
+		require.Equal(t, mapHeight(t, m), slabsRead)
+	})
+
+	t.Run("external collision group", func(t *testing.T) {
+		for _, tc := range []struct {
+			name              string
+			mapCount          int
+			expectedSlabsRead int
+		}{
+			{name: "inlined", mapCount: 2, expectedSlabsRead: 1},
+			{name: "external", mapCount: 100, expectedSlabsRead: 2},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				storage := newTestPersistentStorage(t)
+
+				digesterBuilder := &mockDigesterBuilder{}
+
+				m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+				require.NoError(t, err)
+
+				for i := range tc.mapCount {
+					k := test_utils.Uint64Value(i)
+					v := test_utils.Uint64Value(i * 2)
+
+					// All keys collide at level 0.
+					digests := []atree.Digest{atree.Digest(0), atree.Digest(i)}
+					digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+					existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+					require.NoError(t, err)
+					require.Nil(t, existingStorable)
+				}
+
+				require.True(t, IsMapRootDataSlab(m))
+
+				storable, slabsRead, err := m.GetReturningSlabsRead(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1))
+				require.NoError(t, err)
+				require.Equal(t, test_utils.Uint64Value(2), storable)
+				require.Equal(t, tc.expectedSlabsRead, slabsRead)
+			})
+		}
+	})
+
+	t.Run("external value", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		k := test_utils.Uint64Value(0)
+		v := test_utils.NewStringValue(strings.Repeat("a", 512))
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		storable, slabsRead, err := m.GetReturningSlabsRead(test_utils.CompareValue, test_utils.GetHashInput, k)
+		require.NoError(t, err)
+		require.IsType(t, atree.SlabIDStorable{}, storable)
+		require.Equal(t, 2, slabsRead)
+	})
+}
+
+func TestMapNarrowDigests(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("dataslab as root", func(t *testing.T) {
+		atree.SetThreshold(256)
+		defer atree.SetThreshold(1024)
+
+		storage := newTestBasicStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo, atree.WithNarrowDigests())
+		require.NoError(t, err)
+
+		const mapCount = 2
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := 1; i <= mapCount; i++ {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+
+			// High 32 bits of level 0 digest are truncated.
+			digests := []atree.Digest{atree.Digest(0xff<<32 | i), atree.Digest(i * 2)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		id1 := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
+
+		expected := map[atree.SlabID][]byte{
+			id1: {
+				// version + flag: narrow digests
+				0x18,
+				// flag: root + map data
+				0x88,
+
+				// extra data
+				// CBOR encoded array of 3 elements
+				0x83,
+				// type info
+				0x18, 0x2a,
+				// count: 2
+				0x02,
+				// seed
+				0x1b, 0x52, 0xa8, 0x78, 0x3, 0x85, 0x2c, 0xaa, 0x49,
+
+				// the following encoded data is valid CBOR
+
+				// elements (array of 3 elements)
+				0x83,
+
+				// level: 0
+				0x00,
+
+				// hkeys (byte string of length 4 * 2)
+				0x59, 0x00, 0x08,
+				// hkey: 1
+				0x00, 0x00, 0x00, 0x01,
+				// hkey: 2
+				0x00, 0x00, 0x00, 0x02,
+
+				// elements (array of 2 elements)
+				// each element is encoded as CBOR array of 2 elements (key, value)
+				0x99, 0x00, 0x02,
+				// element: [uint64(1):uint64(2)]
+				0x82, 0xd8, 0xa4, 0x01, 0xd8, 0xa4, 0x02,
+				// element: [uint64(2):uint64(4)]
+				0x82, 0xd8, 0xa4, 0x02, 0xd8, 0xa4, 0x04,
+			},
+		}
+
+		// Verify encoded data
+		stored, err := storage.Encode()
+		require.NoError(t, err)
+
+		require.Equal(t, len(expected), len(stored))
+		require.Equal(t, expected[id1], stored[id1])
+
+		// Decode data to new storage
+		storage2 := newTestPersistentStorageWithData(t, stored)
+
+		// Test new map from storage2
+		decodedMap, err := atree.NewMapWithRootID(storage2, id1, digesterBuilder)
+		require.NoError(t, err)
+
+		testMap(t, storage2, typeInfo, address, decodedMap, keyValues, nil, false)
+	})
+
+	t.Run("metadata slab as root", func(t *testing.T) {
+		atree.SetThreshold(256)
+		defer atree.SetThreshold(1024)
+
+		const mapCount = 1000
+
+		r := newRand(t)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for len(keyValues) < mapCount {
+			keyValues[test_utils.Uint64Value(r.Uint64())] = test_utils.Uint64Value(r.Uint64())
+		}
+
+		newMap := func(t *testing.T, opts ...atree.MapOption) (*atree.PersistentSlabStorage, *atree.OrderedMap, []atree.SlabID) {
+			storage := newTestPersistentStorage(t)
+
+			m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, opts...)
+			require.NoError(t, err)
+
+			for k, v := range keyValues {
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+			}
+
+			require.False(t, IsMapRootDataSlab(m))
+
+			written, _, err := storage.CommitReturningIDs()
+			require.NoError(t, err)
+
+			return storage, m, written
+		}
+
+		storage, m, written := newMap(t, atree.WithNarrowDigests())
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+
+		baseStorage := atree.GetBaseStorage(storage)
+
+		// Root slab and all data slabs have narrow digests flag.
+		for _, id := range written {
+			data, _, err := baseStorage.Retrieve(id)
+			require.NoError(t, err)
+
+			isRoot := data[1]&0x80 != 0
+			isMapData := data[1]&0x1f == 0x08
+			if isRoot || isMapData {
+				require.Equal(t, byte(0x08), data[0]&0x08)
+			}
+		}
+
+		// Load map from base storage with default digester builder.
+		storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		decodedMap, err := atree.NewMapWithRootID(storage2, m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		testMap(t, storage2, typeInfo, address, decodedMap, keyValues, nil, false)
+
+		// Remove all elements from decoded map.
+		for k, v := range keyValues {
+			removedKeyStorable, removedValueStorable, err := decodedMap.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			testValueEqual(t, k, removedKeyStorable.(atree.Value))
+			testValueEqual(t, v, removedValueStorable.(atree.Value))
+		}
+
+		testEmptyMap(t, storage2, typeInfo, address, decodedMap)
+
+		// Map with narrow digests has smaller data slabs than map with default digests.
+		wideStorage, _, _ := newMap(t)
+		require.Less(t, baseStorage.Size(), atree.GetBaseStorage(wideStorage).Size())
+	})
+
+	t.Run("deep copy", func(t *testing.T) {
+		atree.SetThreshold(256)
+		defer atree.SetThreshold(1024)
+
+		const mapCount = 1000
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, atree.WithNarrowDigests())
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		copyStorage := newTestPersistentStorage(t)
+
+		copied, err := m.DeepCopy(copyStorage, address, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+
+		testMap(t, copyStorage, typeInfo, address, copied, keyValues, nil, false)
+
+		// Copied root slab has narrow digests flag.
+		err = copyStorage.Commit()
+		require.NoError(t, err)
+
+		data, found, err := atree.GetBaseStorage(copyStorage).Retrieve(copied.SlabID())
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, byte(0x08), data[0]&0x08)
+	})
+
+	t.Run("child map isn't inlined", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		parentArray, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, atree.WithNarrowDigests())
+		require.NoError(t, err)
+
+		existingStorable, err := childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		err = parentArray.Append(childMap)
+		require.NoError(t, err)
+
+		require.False(t, childMap.Inlined())
+
+		expected := test_utils.ExpectedArrayValue{
+			test_utils.ExpectedMapValue{test_utils.Uint64Value(0): test_utils.Uint64Value(0)},
+		}
+
+		testArray(t, storage, typeInfo, address, parentArray, expected, true)
+	})
+}
+
+func TestMapSingleDigestLevel(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("dataslab as root", func(t *testing.T) {
+		storage := newTestBasicStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo, atree.WithSingleDigestLevel())
+		require.NoError(t, err)
+
+		const mapCount = 2
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := 1; i <= mapCount; i++ {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+
+			// Keys collide at level 0, and level 1 digests aren't used.
+			digests := []atree.Digest{atree.Digest(5), atree.Digest(i * 10)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		id1 := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
+
+		expected := map[atree.SlabID][]byte{
+			id1: {
+				// version
+				0x10,
+				// flag: root + map data
+				0x88,
+
+				// extra data
+				// CBOR encoded array of 5 elements
+				0x85,
+				// type info
+				0x18, 0x2a,
+				// count: 2
+				0x02,
+				// seed
+				0x1b, 0x52, 0xa8, 0x78, 0x3, 0x85, 0x2c, 0xaa, 0x49,
+				// schema ID: 0
+				0x00,
+				// digest levels: 1
+				0x01,
+
+				// the following encoded data is valid CBOR
+
+				// elements (array of 3 elements)
+				0x83,
+
+				// level: 0
+				0x00,
+
+				// hkeys (byte string of length 8 * 1)
+				0x59, 0x00, 0x08,
+				// hkey: 5
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05,
+
+				// elements (array of 1 elements)
+				0x99, 0x00, 0x01,
+
+				// inline collision group corresponding to hkey 5
+				// (tag number CBORTagInlineCollisionGroup)
+				0xd8, 0xfd,
+				// (tag content: array of 3 elements)
+				0x83,
+
+				// level: 1
+				0x01,
+
+				// hkeys (empty byte string)
+				0x40,
+
+				// elements (array of 2 elements)
+				// each element is encoded as CBOR array of 2 elements (key, value)
+				0x99, 0x00, 0x02,
+				// element: [uint64(1):uint64(2)]
+				0x82, 0xd8, 0xa4, 0x01, 0xd8, 0xa4, 0x02,
+				// element: [uint64(2):uint64(4)]
+				0x82, 0xd8, 0xa4, 0x02, 0xd8, 0xa4, 0x04,
+			},
+		}
+
+		// Verify encoded data
+		stored, err := storage.Encode()
+		require.NoError(t, err)
+
+		require.Equal(t, len(expected), len(stored))
+		require.Equal(t, expected[id1], stored[id1])
+
+		// Decode data to new storage
+		storage2 := newTestPersistentStorageWithData(t, stored)
+
+		// Test new map from storage2
+		decodedMap, err := atree.NewMapWithRootID(storage2, id1, digesterBuilder)
+		require.NoError(t, err)
+
+		testMap(t, storage2, typeInfo, address, decodedMap, keyValues, nil, false)
+	})
+
+	t.Run("metadata slab as root", func(t *testing.T) {
+		atree.SetThreshold(256)
+		defer atree.SetThreshold(1024)
+
+		const mapCount = 1000
+
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo, atree.WithSingleDigestLevel())
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+
+			// Create inline and external collision groups at level 0.
+			// Level 1 digests would separate keys if they were used.
+			digests := []atree.Digest{atree.Digest(i % 16), atree.Digest(i)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		require.False(t, IsMapRootDataSlab(m))
+
+		stats, err := atree.GetMapStats(m)
+		require.NoError(t, err)
+		require.True(t, stats.CollisionDataSlabCount > 0)
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		// Load map from base storage.  Digest levels are loaded from map,
+		// so collision groups are still found in list mode.
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		decodedMap, err := atree.NewMapWithRootID(storage2, m.SlabID(), digesterBuilder)
+		require.NoError(t, err)
+
+		testMap(t, storage2, typeInfo, address, decodedMap, keyValues, nil, false)
+
+		// Remove all elements from decoded map.
+		for k, v := range keyValues {
+			removedKeyStorable, removedValueStorable, err := decodedMap.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			testValueEqual(t, k, removedKeyStorable.(atree.Value))
+			testValueEqual(t, v, removedValueStorable.(atree.Value))
+		}
+
+		testEmptyMap(t, storage2, typeInfo, address, decodedMap)
+	})
+
+	t.Run("deep copy", func(t *testing.T) {
+		const mapCount = 100
+
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo, atree.WithSingleDigestLevel())
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+
+			digests := []atree.Digest{atree.Digest(i % 4), atree.Digest(i)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		copyStorage := newTestPersistentStorage(t)
+
+		copied, err := m.DeepCopy(copyStorage, address, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+
+		testMap(t, copyStorage, typeInfo, address, copied, keyValues, nil, false)
+
+		// Copied map has single digest level.
+		err = copyStorage.Commit()
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(copyStorage))
+
+		decodedMap, err := atree.NewMapWithRootID(storage2, copied.SlabID(), digesterBuilder)
+		require.NoError(t, err)
+
+		testMap(t, storage2, typeInfo, address, decodedMap, keyValues, nil, false)
+	})
+
+	t.Run("child map isn't inlined", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		parentArray, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, atree.WithSingleDigestLevel())
+		require.NoError(t, err)
+
+		existingStorable, err := childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		err = parentArray.Append(childMap)
+		require.NoError(t, err)
+
+		require.False(t, childMap.Inlined())
+
+		expected := test_utils.ExpectedArrayValue{
+			test_utils.ExpectedMapValue{test_utils.Uint64Value(0): test_utils.Uint64Value(0)},
+		}
+
+		testArray(t, storage, typeInfo, address, parentArray, expected, true)
+	})
+}
+
+// floatKeyValue is float64 key which implements atree.NaNValue.
+type floatKeyValue float64
+
+var _ atree.NaNValue = floatKeyValue(0)
+
+func (v floatKeyValue) Storable(storage atree.SlabStorage, address atree.Address, maxInlineSize uint64) (atree.Storable, error) {
+	return test_utils.Uint64Value(math.Float64bits(float64(v))).Storable(storage, address, maxInlineSize)
+}
+
+func (v floatKeyValue) IsNaN() bool {
+	return math.IsNaN(float64(v))
+}
+
+// plainFloatKeyValue is float64 key which doesn't implement atree.NaNValue.
+type plainFloatKeyValue float64
+
+var _ atree.Value = plainFloatKeyValue(0)
+
+func (v plainFloatKeyValue) Storable(storage atree.SlabStorage, address atree.Address, maxInlineSize uint64) (atree.Storable, error) {
+	return test_utils.Uint64Value(math.Float64bits(float64(v))).Storable(storage, address, maxInlineSize)
+}
+
+func TestMapSetNaNKey(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	floatHashInput := func(value atree.Value, scratch []byte) ([]byte, error) {
+		var f float64
+		switch v := value.(type) {
+		case floatKeyValue:
+			f = float64(v)
+		case plainFloatKeyValue:
+			f = float64(v)
+		case test_utils.Uint64Value:
+			// Stored key is float bits.
+			f = math.Float64frombits(uint64(v))
+		}
+		return test_utils.Uint64Value(math.Float64bits(f)).HashInput(scratch)
+	}
+
+	floatComparator := func(storage atree.SlabStorage, value atree.Value, storable atree.Storable) (bool, error) {
+		var f float64
+		switch v := value.(type) {
+		case floatKeyValue:
+			f = float64(v)
+		case plainFloatKeyValue:
+			f = float64(v)
+		}
+		// NaN isn't equal to itself, like float comparison.
+		return f == math.Float64frombits(uint64(storable.(test_utils.Uint64Value))), nil
+	}
+
+	requireNaNKeyError := func(t *testing.T, err error) {
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		var nanKeyError *atree.NaNKeyError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &nanKeyError)
+		require.ErrorAs(t, userError, &nanKeyError)
+	}
+
+	t.Run("NaNValue", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(floatComparator, floatHashInput, floatKeyValue(1.5), test_utils.Uint64Value(1))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		existingStorable, err = m.Set(floatComparator, floatHashInput, floatKeyValue(math.NaN()), test_utils.Uint64Value(2))
+		requireNaNKeyError(t, err)
+		require.Nil(t, existingStorable)
+
+		err = m.SetFromGoMap(floatComparator, floatHashInput, map[atree.Value]atree.Value{
+			floatKeyValue(2.5):        test_utils.Uint64Value(3),
+			floatKeyValue(math.NaN()): test_utils.Uint64Value(4),
+		})
+		requireNaNKeyError(t, err)
+
+		// Map isn't modified.
+		require.Equal(t, uint64(1), m.Count())
+
+		err = atree.VerifyMap(m, address, typeInfo, test_utils.CompareTypeInfo, floatHashInput, true)
+		require.NoError(t, err)
+	})
+
+	t.Run("RejectNaNKeys", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		isNaN := func(value atree.Value) bool {
+			v, ok := value.(plainFloatKeyValue)
+			return ok && math.IsNaN(float64(v))
+		}
+
+		hip := atree.RejectNaNKeys(floatHashInput, isNaN)
+
+		existingStorable, err := m.Set(floatComparator, hip, plainFloatKeyValue(1.5), test_utils.Uint64Value(1))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		existingStorable, err = m.Set(floatComparator, hip, plainFloatKeyValue(math.NaN()), test_utils.Uint64Value(2))
+		requireNaNKeyError(t, err)
+		require.Nil(t, existingStorable)
+
+		// Map isn't modified.
+		require.Equal(t, uint64(1), m.Count())
+
+		storable, err := m.Get(floatComparator, hip, plainFloatKeyValue(1.5))
+		require.NoError(t, err)
+		require.Equal(t, test_utils.Uint64Value(1), storable)
+
+		err = atree.VerifyMap(m, address, typeInfo, test_utils.CompareTypeInfo, hip, true)
+		require.NoError(t, err)
+	})
+
+	t.Run("NaN key without guard", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		// NaN key without guard is inserted, but can't be found.
+		key := plainFloatKeyValue(math.NaN())
+
+		existingStorable, err := m.Set(floatComparator, floatHashInput, key, test_utils.Uint64Value(1))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		has, err := m.Has(floatComparator, floatHashInput, key)
+		require.NoError(t, err)
+		require.False(t, has)
+	})
+}
+
+func TestMapDigestUniformity(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 1000
+
+	t.Run("empty", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		chiSquare, bucketCount, elementCount, err := atree.MapDigestUniformity(m)
+		require.NoError(t, err)
+		require.Equal(t, float64(0), chiSquare)
+		require.Equal(t, uint64(0), bucketCount)
+		require.Equal(t, uint64(0), elementCount)
+	})
+
+	t.Run("uniform vs collision", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		uniformMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			existingStorable, err := uniformMap.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		uniformChiSquare, bucketCount, elementCount, err := atree.MapDigestUniformity(uniformMap)
+		require.NoError(t, err)
+		require.Equal(t, uint64(128), bucketCount)
+		require.Equal(t, uint64(mapCount), elementCount)
+
+		// Chi-square statistic of 127 degrees of freedom is less than 200 with very high probability.
+		require.Less(t, uniformChiSquare, float64(200))
+
+		// All elements of collisionMap have one of 4 level 0 digests.
+		digesterBuilder := &mockDigesterBuilder{}
+
+		collisionMap, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+
+			digests := []atree.Digest{atree.Digest(i % 4), atree.Digest(i)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+			existingStorable, err := collisionMap.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		collisionChiSquare, bucketCount, elementCount, err := atree.MapDigestUniformity(collisionMap)
+		require.NoError(t, err)
+		require.Equal(t, uint64(128), bucketCount)
+		require.Equal(t, uint64(mapCount), elementCount)
+
+		// All elements are in the first bucket, so chi-square is elementCount * (bucketCount - 1).
+		require.InDelta(t, float64(127_000), collisionChiSquare, 1e-6)
+
+		require.Greater(t, collisionChiSquare, uniformChiSquare*100)
+	})
+}
+
+func TestMapIteratorClose(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 10
+
+	newMap := func(t *testing.T, storage *atree.PersistentSlabStorage) (*atree.OrderedMap, test_utils.ExpectedMapValue) {
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		expected := make(test_utils.ExpectedMapValue)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+			expected[k] = v
+		}
+		return m, expected
+	}
+
+	iterateAll := func(t *testing.T, iterator atree.MapIterator) test_utils.ExpectedMapValue {
+		elements := make(test_utils.ExpectedMapValue)
+		for {
+			k, v, err := iterator.Next()
+			require.NoError(t, err)
+			if k == nil {
+				return elements
+			}
+			elements[k] = v
+		}
+	}
+
+	closeIterator := func(t *testing.T, iterator atree.MapIterator) {
+		closable, ok := iterator.(atree.ClosableMapIterator)
+		require.True(t, ok)
+		closable.Close()
+	}
+
+	t.Run("reuse closed iterators", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m1, expected1 := newMap(t, storage)
+		m2, expected2 := newMap(t, storage)
+
+		// Close iterators after partial iteration.
+		iterator, err := m1.ReadOnlyIterator()
+		require.NoError(t, err)
+
+		_, _, err = iterator.Next()
+		require.NoError(t, err)
+
+		closeIterator(t, iterator)
+
+		iterator, err = m1.Iterator(test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+
+		_, _, err = iterator.Next()
+		require.NoError(t, err)
+
+		closeIterator(t, iterator)
+
+		// New iterators start from the first element of their own map.
+		for range 2 {
+			iterator, err = m2.ReadOnlyIterator()
+			require.NoError(t, err)
+			require.Equal(t, expected2, iterateAll(t, iterator))
+			closeIterator(t, iterator)
+
+			iterator, err = m2.Iterator(test_utils.CompareValue, test_utils.GetHashInput)
+			require.NoError(t, err)
+			require.Equal(t, expected2, iterateAll(t, iterator))
+			closeIterator(t, iterator)
+		}
+
+		iterator, err = m1.ReadOnlyIterator()
+		require.NoError(t, err)
+		require.Equal(t, expected1, iterateAll(t, iterator))
+		closeIterator(t, iterator)
+	})
+
+	t.Run("close twice", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, expected := newMap(t, storage)
+
+		for _, newIterator := range []func() (atree.MapIterator, error){
+			m.ReadOnlyIterator,
+			func() (atree.MapIterator, error) {
+				return m.Iterator(test_utils.CompareValue, test_utils.GetHashInput)
+			},
+		} {
+			iterator, err := newIterator()
+			require.NoError(t, err)
+
+			// Second Close doesn't return iterator to pool again.
+			closeIterator(t, iterator)
+			closeIterator(t, iterator)
+
+			iterator1, err := newIterator()
+			require.NoError(t, err)
+
+			iterator2, err := newIterator()
+			require.NoError(t, err)
+
+			require.NotSame(t, iterator1, iterator2)
+			require.Equal(t, expected, iterateAll(t, iterator1))
+			require.Equal(t, expected, iterateAll(t, iterator2))
+
+			closeIterator(t, iterator1)
+			closeIterator(t, iterator2)
+		}
+	})
+
+	t.Run("use after close", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, _ := newMap(t, storage)
+
+		requireIteratorClosedError := func(t *testing.T, err error) {
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var userError *atree.UserError
+			var iteratorClosedError *atree.IteratorClosedError
+			require.ErrorAs(t, err, &userError)
+			require.ErrorAs(t, err, &iteratorClosedError)
+		}
+
+		for _, newIterator := range []func() (atree.MapIterator, error){
+			m.ReadOnlyIterator,
+			func() (atree.MapIterator, error) {
+				return m.Iterator(test_utils.CompareValue, test_utils.GetHashInput)
+			},
+		} {
+			iterator, err := newIterator()
+			require.NoError(t, err)
+
+			_, _, err = iterator.Next()
+			require.NoError(t, err)
+
+			closeIterator(t, iterator)
+
+			k, v, err := iterator.Next()
+			requireIteratorClosedError(t, err)
+			require.Nil(t, k)
+			require.Nil(t, v)
+
+			k, err = iterator.NextKey()
+			requireIteratorClosedError(t, err)
+			require.Nil(t, k)
+
+			v, err = iterator.NextValue()
+			requireIteratorClosedError(t, err)
+			require.Nil(t, v)
+
+			// Close after Close is no-op.
+			closeIterator(t, iterator)
+		}
+	})
+
+	t.Run("close empty map iterator", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		iterator, err := m.ReadOnlyIterator()
+		require.NoError(t, err)
+		closeIterator(t, iterator)
+
+		// Shared empty iterator is still usable after Close.
+		iterator, err = m.ReadOnlyIterator()
+		require.NoError(t, err)
+
+		k, v, err := iterator.Next()
+		require.NoError(t, err)
+		require.Nil(t, k)
+		require.Nil(t, v)
+	})
+
+	t.Run("mutate element after iterator is closed and reused", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		parentMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		existingStorable, err := parentMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), childMap)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		var parentCallbackCalled, otherCallbackCalled bool
+
+		iterator, err := parentMap.ReadOnlyIteratorWithMutationCallback(
+			nil,
+			func(atree.Value) {
+				parentCallbackCalled = true
+			})
+		require.NoError(t, err)
+
+		_, v, err := iterator.Next()
+		require.NoError(t, err)
+
+		child, ok := v.(*atree.OrderedMap)
+		require.True(t, ok)
+
+		closeIterator(t, iterator)
+
+		// Iterator of another map can reuse closed iterator.
+		otherMap, _ := newMap(t, storage)
+
+		iterator, err = otherMap.ReadOnlyIteratorWithMutationCallback(
+			nil,
+			func(atree.Value) {
+				otherCallbackCalled = true
+			})
+		require.NoError(t, err)
+
+		_, _, err = iterator.Next()
+		require.NoError(t, err)
+
+		// Mutating child from closed iterator reports parent map and calls its callback.
+		existingStorable, err = child.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		require.Nil(t, existingStorable)
+
+		var mutationError *atree.ReadOnlyIteratorElementMutationError
+		require.ErrorAs(t, err, &mutationError)
+		require.ErrorContains(t, err, parentMap.ValueID().String())
+
+		require.True(t, parentCallbackCalled)
+		require.False(t, otherCallbackCalled)
+
+		closeIterator(t, iterator)
+	})
+}
+
+func TestMapSlabIDAtCreation(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("root slab is split and merged", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		rootID := m.SlabID()
+		require.NotEqual(t, atree.SlabIDUndefined, rootID)
+		require.False(t, rootID.HasTempAddress())
+
+		const mapCount = 1000
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+		require.False(t, IsMapRootDataSlab(m))
+		require.Equal(t, rootID, m.SlabID())
+
+		expected := make(test_utils.ExpectedMapValue)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			if i < 10 {
+				expected[k] = k
+				continue
+			}
+			_, _, err := m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+		}
+		require.True(t, IsMapRootDataSlab(m))
+		require.Equal(t, rootID, m.SlabID())
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		// Map is loaded with root slab ID observed at creation.
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		m2, err := atree.NewMapWithRootID(storage2, rootID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		testMap(t, storage2, typeInfo, address, m2, expected, nil, false)
+	})
+
+	t.Run("child map is inlined and uninlined", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		parentMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		rootID := childMap.SlabID()
+		valueID := childMap.ValueID()
+
+		existingStorable, err := parentMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), childMap)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		// Inlined map doesn't have root slab ID, but its value ID is unchanged.
+		require.True(t, childMap.Inlined())
+		require.Equal(t, atree.SlabIDUndefined, childMap.SlabID())
+		require.Equal(t, valueID, childMap.ValueID())
+
+		expectedChild := make(test_utils.ExpectedMapValue)
+		for i := uint64(0); childMap.Inlined(); i++ {
+			k := test_utils.Uint64Value(i)
+			existingStorable, err := childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+			expectedChild[k] = k
+		}
+
+		// Uninlined map has the same root slab ID as at creation.
+		require.Equal(t, rootID, childMap.SlabID())
+		require.Equal(t, valueID, childMap.ValueID())
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		parentMap2, err := atree.NewMapWithRootID(storage2, parentMap.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		expected := test_utils.ExpectedMapValue{test_utils.Uint64Value(0): expectedChild}
+		testMap(t, storage2, typeInfo, address, parentMap2, expected, nil, true)
+
+		v, err := parentMap2.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		childMap2, ok := v.(*atree.OrderedMap)
+		require.True(t, ok)
+		require.Equal(t, rootID, childMap2.SlabID())
+	})
+}
+
+func TestMapEmptyHashInput(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	newDigesterBuilders := map[string]func() atree.DigesterBuilder{
+		"default": atree.NewDefaultDigesterBuilder,
+		"integer key": func() atree.DigesterBuilder {
+			return atree.NewIntegerKeyDigesterBuilder(func(atree.Value) (uint64, bool) {
+				// Use hash input for level 0 digest too.
+				return 0, false
+			})
+		},
+	}
+
+	// hashInputs are hash inputs of all keys.  Keys are StringValue because
+	// StringValue doesn't implement FixedSizeHashInputValue, so HashInputProvider
+	// is used for both keys and stored keys.
+	hashInputs := map[string][]byte{
+		"nil":         nil,
+		"empty":       {},
+		"single 0x00": {0x00},
+		"single 0xff": {0xff},
+	}
+
+	newHashInputProvider := func(input []byte) atree.HashInputProvider {
+		return func(_ atree.Value, scratch []byte) ([]byte, error) {
+			if input == nil {
+				return nil, nil
+			}
+			return append(scratch[:0], input...), nil
+		}
+	}
+
+	getDigests := func(t *testing.T, digesterBuilder atree.DigesterBuilder, hip atree.HashInputProvider, key atree.Value) []atree.Digest {
+		digester, err := digesterBuilder.Digest(hip, key)
+		require.NoError(t, err)
+		require.Equal(t, uint(4), digester.Levels())
+
+		// Get digests from level 3 to level 0 so that higher level digests
+		// aren't computed after level 0 digest.
+		digests := make([]atree.Digest, digester.Levels())
+		for level := int(digester.Levels()) - 1; level >= 0; level-- {
+			digests[level], err = digester.Digest(uint(level))
+			require.NoError(t, err)
+		}
+
+		// Digests are the same when they are requested again.
+		for level := range digester.Levels() {
+			d, err := digester.Digest(level)
+			require.NoError(t, err)
+			require.Equal(t, digests[level], d)
+		}
+
+		prefix, err := digester.DigestPrefix(digester.Levels())
+		require.NoError(t, err)
+		require.Equal(t, digests, prefix)
+
+		digester.Reset()
+
+		return digests
+	}
+
+	for name, newDigesterBuilder := range newDigesterBuilders {
+		t.Run(name, func(t *testing.T) {
+
+			t.Run("digests", func(t *testing.T) {
+				digesterBuilder := newDigesterBuilder()
+				digesterBuilder.SetSeed(1, 2)
+
+				digestsByInput := make(map[string][]atree.Digest)
+
+				for inputName, input := range hashInputs {
+					hip := newHashInputProvider(input)
+
+					// Digests of different keys with the same hash input are the same.
+					digests := getDigests(t, digesterBuilder, hip, test_utils.NewStringValue("a"))
+					require.Equal(t, digests, getDigests(t, digesterBuilder, hip, test_utils.NewStringValue("b")))
+
+					// Digests are stable across digester builders with the same seed.
+					digesterBuilder2 := newDigesterBuilder()
+					digesterBuilder2.SetSeed(1, 2)
+					require.Equal(t, digests, getDigests(t, digesterBuilder2, hip, test_utils.NewStringValue("a")))
+
+					digestsByInput[inputName] = digests
+				}
+
+				// Nil and empty hash inputs have the same digests.
+				require.Equal(t, digestsByInput["nil"], digestsByInput["empty"])
+
+				// Empty and single byte hash inputs have different digests at all levels.
+				for _, inputName := range []string{"single 0x00", "single 0xff"} {
+					for level, d := range digestsByInput[inputName] {
+						require.NotEqual(t, digestsByInput["empty"][level], d)
+					}
+				}
+				for level, d := range digestsByInput["single 0x00"] {
+					require.NotEqual(t, digestsByInput["single 0xff"][level], d)
+				}
+			})
+
+			for inputName, input := range hashInputs {
+				t.Run("map with "+inputName+" hash input", func(t *testing.T) {
+					const mapCount = 64
+
+					hip := newHashInputProvider(input)
+
+					storage := newTestPersistentStorage(t)
+
+					m, err := atree.NewMap(storage, address, newDigesterBuilder(), typeInfo)
+					require.NoError(t, err)
+
+					// All keys have the same digests at all levels, so
+					// elements are stored in collision group in list mode.
+					for i := range uint64(mapCount) {
+						k := test_utils.NewStringValue(strconv.FormatUint(i, 10))
+						existingStorable, err := m.Set(test_utils.CompareValue, hip, k, test_utils.Uint64Value(i*2))
+						require.NoError(t, err)
+						require.Nil(t, existingStorable)
+					}
+					require.Equal(t, uint64(mapCount), m.Count())
+
+					// Overwrite existing elements.
+					for i := range uint64(mapCount) {
+						k := test_utils.NewStringValue(strconv.FormatUint(i, 10))
+						existingStorable, err := m.Set(test_utils.CompareValue, hip, k, test_utils.Uint64Value(i))
+						require.NoError(t, err)
+						require.Equal(t, test_utils.Uint64Value(i*2), existingStorable)
+					}
+					require.Equal(t, uint64(mapCount), m.Count())
+
+					err = atree.VerifyMap(m, address, typeInfo, test_utils.CompareTypeInfo, hip, true)
+					require.NoError(t, err)
+
+					err = storage.Commit()
+					require.NoError(t, err)
+
+					// Reload map and find all elements.
+					storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+					m2, err := atree.NewMapWithRootID(storage2, m.SlabID(), newDigesterBuilder())
+					require.NoError(t, err)
+
+					for i := range uint64(mapCount) {
+						k := test_utils.NewStringValue(strconv.FormatUint(i, 10))
+						storable, err := m2.Get(test_utils.CompareValue, hip, k)
+						require.NoError(t, err)
+						require.Equal(t, test_utils.Uint64Value(i), storable)
+					}
+
+					has, err := m2.Has(test_utils.CompareValue, hip, test_utils.NewStringValue(strconv.Itoa(mapCount)))
+					require.NoError(t, err)
+					require.False(t, has)
+
+					// Remove all elements.
+					for i := range uint64(mapCount) {
+						k := test_utils.NewStringValue(strconv.FormatUint(i, 10))
+						removedKeyStorable, removedValueStorable, err := m2.Remove(test_utils.CompareValue, hip, k)
+						require.NoError(t, err)
+						testValueEqual(t, k, removedKeyStorable.(atree.Value))
+						require.Equal(t, test_utils.Uint64Value(i), removedValueStorable)
+					}
+					require.Equal(t, uint64(0), m2.Count())
+
+					err = atree.VerifyMap(m2, address, typeInfo, test_utils.CompareTypeInfo, hip, true)
+					require.NoError(t, err)
+				})
+			}
+		})
+	}
+}
+
+func TestMapInsert(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	requireDuplicateKeyError := func(t *testing.T, err error) {
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		var duplicateKeyError *atree.DuplicateKeyError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &duplicateKeyError)
+		require.ErrorAs(t, userError, &duplicateKeyError)
+	}
+
+	t.Run("dataslab as root", func(t *testing.T) {
+		const mapCount = 8
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 10)
+			keyValues[k] = v
+
+			err := m.Insert(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+		}
+
+		require.True(t, IsMapRootDataSlab(m))
+
+		for i := range uint64(mapCount) {
+			err := m.Insert(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(0))
+			requireDuplicateKeyError(t, err)
+		}
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+
+	t.Run("metadataslab as root", func(t *testing.T) {
+		const mapCount = 4096
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 10)
+			keyValues[k] = v
+
+			err := m.Insert(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+		}
+
+		require.False(t, IsMapRootDataSlab(m))
+
+		for i := range uint64(mapCount) {
+			err := m.Insert(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.NewStringValue(strings.Repeat("a", 100)))
+			requireDuplicateKeyError(t, err)
+		}
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		const (
+			mapCount      = 1024
+			keyStringSize = 16
+		)
+
+		atree.SetThreshold(256)
+		defer atree.SetThreshold(1024)
+
+		savedMaxCollisionLimitPerDigest := atree.MaxCollisionLimitPerDigest
+		atree.MaxCollisionLimitPerDigest = uint32(math.Ceil(float64(mapCount) / 10))
+		defer func() {
+			atree.MaxCollisionLimitPerDigest = savedMaxCollisionLimitPerDigest
+		}()
+
+		r := newRand(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		i := uint64(0)
+		for len(keyValues) < mapCount {
+			k := test_utils.NewStringValue(randStr(r, keyStringSize))
+			v := test_utils.Uint64Value(i)
+			keyValues[k] = v
+			i++
+
+			digests := []atree.Digest{
+				atree.Digest(i % 10),
+			}
+			digesterBuilder.On("Digest", k).Return(mockDigester{digests})
+		}
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		for k, v := range keyValues {
+			err := m.Insert(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+		}
+
+		for k := range keyValues {
+			err := m.Insert(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(0))
+			requireDuplicateKeyError(t, err)
+		}
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+
+	t.Run("insert and set", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(10))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		// Insert doesn't overwrite element added by Set.
+		err = m.Insert(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(20))
+		requireDuplicateKeyError(t, err)
+
+		err = m.Insert(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(2), test_utils.Uint64Value(30))
+		require.NoError(t, err)
+
+		// Set overwrites element added by Insert.
+		existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(2), test_utils.Uint64Value(40))
+		require.NoError(t, err)
+		require.Equal(t, test_utils.Uint64Value(30), existingStorable)
+
+		keyValues := map[atree.Value]atree.Value{
+			test_utils.Uint64Value(1): test_utils.Uint64Value(10),
+			test_utils.Uint64Value(2): test_utils.Uint64Value(40),
+		}
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+}
+
+func TestMapSwap(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	requireKeyNotFoundError := func(t *testing.T, err error) {
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		var keyNotFoundError *atree.KeyNotFoundError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &keyNotFoundError)
+		require.ErrorAs(t, userError, &keyNotFoundError)
+	}
+
+	newMap := func(t *testing.T, storage *atree.PersistentSlabStorage, mapCount int) (*atree.OrderedMap, map[atree.Value]atree.Value) {
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 10)
+			keyValues[k] = v
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		return m, keyValues
+	}
+
+	t.Run("dataslab as root", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, keyValues := newMap(t, storage, 8)
+		require.True(t, IsMapRootDataSlab(m))
+
+		keyA := test_utils.Uint64Value(1)
+		keyB := test_utils.Uint64Value(6)
+
+		err := m.Swap(test_utils.CompareValue, test_utils.GetHashInput, keyA, keyB)
+		require.NoError(t, err)
+
+		keyValues[keyA], keyValues[keyB] = keyValues[keyB], keyValues[keyA]
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+
+	t.Run("metadataslab as root", func(t *testing.T) {
+		const mapCount = 4096
+
+		storage := newTestPersistentStorage(t)
+
+		m, keyValues := newMap(t, storage, mapCount)
+		require.False(t, IsMapRootDataSlab(m))
+
+		r := newRand(t)
+
+		for range 100 {
+			keyA := test_utils.Uint64Value(r.Intn(mapCount))
+			keyB := test_utils.Uint64Value(r.Intn(mapCount))
+
+			err := m.Swap(test_utils.CompareValue, test_utils.GetHashInput, keyA, keyB)
+			require.NoError(t, err)
+
+			keyValues[keyA], keyValues[keyB] = keyValues[keyB], keyValues[keyA]
+		}
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+
+	t.Run("same key", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, keyValues := newMap(t, storage, 8)
+
+		err := m.Swap(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(3), test_utils.Uint64Value(3))
+		require.NoError(t, err)
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, keyValues := newMap(t, storage, 8)
+
+		existingKey := test_utils.Uint64Value(3)
+		missingKey := test_utils.Uint64Value(100)
+
+		err := m.Swap(test_utils.CompareValue, test_utils.GetHashInput, existingKey, missingKey)
+		requireKeyNotFoundError(t, err)
+
+		err = m.Swap(test_utils.CompareValue, test_utils.GetHashInput, missingKey, existingKey)
+		requireKeyNotFoundError(t, err)
+
+		err = m.Swap(test_utils.CompareValue, test_utils.GetHashInput, missingKey, missingKey)
+		requireKeyNotFoundError(t, err)
+
+		// Map is unchanged.
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+
+	t.Run("empty map", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		err = m.Swap(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(1))
+		requireKeyNotFoundError(t, err)
+
+		testEmptyMap(t, storage, typeInfo, address, m)
+	})
+
+	t.Run("external value", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, keyValues := newMap(t, storage, 8)
+
+		// String value stored externally in its own slab.
+		keyA := test_utils.Uint64Value(1)
+		largeValue := test_utils.NewStringValue(strings.Repeat("a", 1000))
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, keyA, largeValue)
+		require.NoError(t, err)
+		require.Equal(t, test_utils.Uint64Value(10), existingStorable)
+		keyValues[keyA] = largeValue
+
+		// String value inlined under short key, and stored externally under long key.
+		keyB := test_utils.Uint64Value(2)
+		keyC := test_utils.NewStringValue(strings.Repeat("k", int(atree.MaxInlineMapKeySize())-10))
+		smallValue := test_utils.NewStringValue(strings.Repeat("b", int(atree.MaxInlineMapKeySize())))
+
+		existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, keyB, smallValue)
+		require.NoError(t, err)
+		require.Equal(t, test_utils.Uint64Value(20), existingStorable)
+		keyValues[keyB] = smallValue
+
+		existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, keyC, test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+		keyValues[keyC] = test_utils.Uint64Value(0)
+
+		err = m.Swap(test_utils.CompareValue, test_utils.GetHashInput, keyA, keyB)
+		require.NoError(t, err)
+		keyValues[keyA], keyValues[keyB] = keyValues[keyB], keyValues[keyA]
+
+		err = m.Swap(test_utils.CompareValue, test_utils.GetHashInput, keyA, keyC)
+		require.NoError(t, err)
+		keyValues[keyA], keyValues[keyC] = keyValues[keyC], keyValues[keyA]
+
+		// External value slab isn't duplicated or leaked.
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+
+	t.Run("child containers", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		childTypeInfo := test_utils.NewSimpleTypeInfo(43)
+
+		keyValues := make(map[atree.Value]atree.Value)
+
+		// Inlined child array.
+		keyA := test_utils.Uint64Value(0)
+		inlinedChild, err := atree.NewArray(storage, address, childTypeInfo)
+		require.NoError(t, err)
+
+		err = inlinedChild.Append(test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, keyA, inlinedChild)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+		require.True(t, inlinedChild.Inlined())
+
+		keyValues[keyA] = test_utils.ExpectedArrayValue{test_utils.Uint64Value(0)}
+
+		// Not inlined child array.
+		keyB := test_utils.Uint64Value(1)
+		largeChild, err := atree.NewArray(storage, address, childTypeInfo)
+		require.NoError(t, err)
+
+		expectedLargeChild := make(test_utils.ExpectedArrayValue, 0, 100)
+		for i := range uint64(100) {
+			v := test_utils.Uint64Value(i << 40)
+			err = largeChild.Append(v)
+			require.NoError(t, err)
+			expectedLargeChild = append(expectedLargeChild, v)
+		}
+
+		existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, keyB, largeChild)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+		require.False(t, largeChild.Inlined())
+
+		keyValues[keyB] = expectedLargeChild
+
+		err = m.Swap(test_utils.CompareValue, test_utils.GetHashInput, keyA, keyB)
+		require.NoError(t, err)
+
+		keyValues[keyA], keyValues[keyB] = keyValues[keyB], keyValues[keyA]
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, true)
+
+		// Modify swapped children retrieved by new keys to test that parent is notified.
+		v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, keyB)
+		require.NoError(t, err)
+
+		inlinedChild, ok := v.(*atree.Array)
+		require.True(t, ok)
+
+		err = inlinedChild.Append(test_utils.Uint64Value(1))
+		require.NoError(t, err)
+		require.True(t, inlinedChild.Inlined())
+		keyValues[keyB] = append(keyValues[keyB].(test_utils.ExpectedArrayValue), test_utils.Uint64Value(1))
+
+		v, err = m.Get(test_utils.CompareValue, test_utils.GetHashInput, keyA)
+		require.NoError(t, err)
+
+		largeChild, ok = v.(*atree.Array)
+		require.True(t, ok)
+
+		_, err = largeChild.Set(0, test_utils.Uint64Value(1000))
+		require.NoError(t, err)
+		require.False(t, largeChild.Inlined())
+		keyValues[keyA].(test_utils.ExpectedArrayValue)[0] = test_utils.Uint64Value(1000)
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, true)
+	})
+}
+
+func TestMapEstimateLoadCost(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// actualLoadCost returns number and total byte size of slabs in storage,
+	// which only contains slabs of one container.
+	actualLoadCost := func(t *testing.T, storage *atree.PersistentSlabStorage) (int, int) {
+		iterator, err := storage.SlabIterator()
+		require.NoError(t, err)
+
+		slabs, bytes := 0, 0
+		for {
+			id, slab := iterator()
+			if id == atree.SlabIDUndefined {
+				break
+			}
+			slabs++
+			bytes += int(slab.ByteSize())
+		}
+		return slabs, bytes
+	}
+
+	testEstimate := func(t *testing.T, mapCount int, expectedLevels uint64, exact bool) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(mapCount) {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		stats, err := atree.GetMapStats(m)
+		require.NoError(t, err)
+		require.Equal(t, expectedLevels, stats.Levels)
+
+		actualSlabs, actualBytes := actualLoadCost(t, storage)
+		require.Equal(t, int(stats.SlabCount()), actualSlabs)
+
+		slabs, bytes, err := atree.EstimateLoadCost(storage, m.SlabID())
+		require.NoError(t, err)
+
+		if exact {
+			require.Equal(t, actualSlabs, slabs)
+			require.Equal(t, actualBytes, bytes)
+			return
+		}
+
+		// Estimate has the same order of magnitude as actual cost.
+		require.InDelta(t, actualSlabs, slabs, float64(actualSlabs)/2)
+		require.InDelta(t, actualBytes, bytes, float64(actualBytes)/2)
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		testEstimate(t, 0, 1, true)
+	})
+
+	t.Run("dataslab as root", func(t *testing.T) {
+		testEstimate(t, 10, 1, true)
+	})
+
+	t.Run("metadataslab as root with data slab children", func(t *testing.T) {
+		testEstimate(t, 100, 2, true)
+	})
+
+	t.Run("large", func(t *testing.T) {
+		testEstimate(t, 50_000, 4, false)
+	})
+
+	t.Run("array", func(t *testing.T) {
+		const arrayCount = 50_000
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(arrayCount) {
+			err := array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		actualSlabs, actualBytes := actualLoadCost(t, storage)
+
+		slabs, bytes, err := atree.EstimateLoadCost(storage, array.SlabID())
+		require.NoError(t, err)
+
+		require.InDelta(t, actualSlabs, slabs, float64(actualSlabs)/2)
+		require.InDelta(t, actualBytes, bytes, float64(actualBytes)/2)
+	})
+
+	t.Run("not container", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		storable, err := atree.NewStorableSlab(storage, address, test_utils.NewStringValue(strings.Repeat("a", 1000)))
+		require.NoError(t, err)
+
+		id := atree.SlabID(storable.(atree.SlabIDStorable))
+
+		_, _, err = atree.EstimateLoadCost(storage, id)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var notValueError *atree.NotValueError
+		require.ErrorAs(t, err, &notValueError)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		id := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
+
+		_, _, err := atree.EstimateLoadCost(storage, id)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var slabNotFoundError *atree.SlabNotFoundError
+		require.ErrorAs(t, err, &slabNotFoundError)
+	})
+}
+
+func TestMapIterateSlabs(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	iterateSlabIDs := func(t *testing.T, storage atree.SlabStorage, rootID atree.SlabID) []atree.SlabID {
+		var ids []atree.SlabID
+		err := atree.IterateSlabs(storage, rootID, func(id atree.SlabID, _ atree.Slab) (bool, error) {
+			ids = append(ids, id)
+			return true, nil
+		})
+		require.NoError(t, err)
+		return ids
+	}
+
+	t.Run("collision and external values", func(t *testing.T) {
+		const mapCount = 1024
+
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		r := newRand(t)
+
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+
+			var v atree.Value = test_utils.Uint64Value(i)
+			if i%8 == 0 {
+				// Create value stored in separate slab
+				v = test_utils.NewStringValue(randStr(r, 512))
+			}
+
+			// Create inline and external collision groups
+			digests := []atree.Digest{atree.Digest(i % 64), atree.Digest(i % 4), atree.Digest(i)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		stats, err := atree.GetMapStats(m)
+		require.NoError(t, err)
+		require.True(t, stats.MetaDataSlabCount > 0)
+		require.True(t, stats.CollisionDataSlabCount > 0)
+		require.True(t, stats.StorableSlabCount > 0)
+
+		var ids []atree.SlabID
+		var metaDataSlabCount, dataSlabCount, collisionDataSlabCount, storableSlabCount uint64
+
+		err = atree.IterateSlabs(storage, m.SlabID(), func(id atree.SlabID, slab atree.Slab) (bool, error) {
+			ids = append(ids, id)
+
+			switch slab := slab.(type) {
+			case *atree.MapMetaDataSlab:
+				metaDataSlabCount++
+			case *atree.MapDataSlab:
+				if atree.IsMapDataSlabCollisionGroup(slab) {
+					collisionDataSlabCount++
+				} else {
+					dataSlabCount++
+				}
+			case *atree.StorableSlab:
+				storableSlabCount++
+			default:
+				require.Fail(t, fmt.Sprintf("unexpected slab type %T", slab))
+			}
+			return true, nil
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, stats.MetaDataSlabCount, metaDataSlabCount)
+		require.Equal(t, stats.DataSlabCount, dataSlabCount)
+		require.Equal(t, stats.CollisionDataSlabCount, collisionDataSlabCount)
+		require.Equal(t, stats.StorableSlabCount, storableSlabCount)
+		require.Equal(t, int(stats.SlabCount()), len(ids))
+
+		// Root slab is visited first.
+		require.Equal(t, m.SlabID(), ids[0])
+
+		// Each slab is visited once.
+		uniqueIDs := make(map[atree.SlabID]struct{}, len(ids))
+		for _, id := range ids {
+			uniqueIDs[id] = struct{}{}
+		}
+		require.Equal(t, len(ids), len(uniqueIDs))
+
+		// Visiting order is deterministic.
+		require.Equal(t, ids, iterateSlabIDs(t, storage, m.SlabID()))
+
+		// Visiting order is deterministic after reloading map from storage.
+		err = storage.FastCommit(runtime.NumCPU())
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+		require.Equal(t, ids, iterateSlabIDs(t, storage2, m.SlabID()))
+	})
+
+	t.Run("nested containers", func(t *testing.T) {
+		const mapCount = 8
+		const childArrayCount = 100
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			childArray, err := atree.NewArray(storage, address, typeInfo)
+			require.NoError(t, err)
+
+			for j := range childArrayCount {
+				err = childArray.Append(test_utils.Uint64Value(j))
+				require.NoError(t, err)
+			}
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), childArray)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			require.False(t, childArray.Inlined())
+		}
+
+		// Storage only contains slabs of map and its child arrays.
+		iterator, err := storage.SlabIterator()
+		require.NoError(t, err)
+
+		storedIDs := make(map[atree.SlabID]struct{})
+		for {
+			id, _ := iterator()
+			if id == atree.SlabIDUndefined {
+				break
+			}
+			storedIDs[id] = struct{}{}
+		}
+
+		ids := iterateSlabIDs(t, storage, m.SlabID())
+		require.Equal(t, len(storedIDs), len(ids))
+		for _, id := range ids {
+			require.Contains(t, storedIDs, id)
+		}
+	})
+
+	t.Run("stop early", func(t *testing.T) {
+		const mapCount = 1024
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(mapCount) {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		ids := iterateSlabIDs(t, storage, m.SlabID())
+		require.True(t, len(ids) > 3)
+
+		var visitedIDs []atree.SlabID
+		err = atree.IterateSlabs(storage, m.SlabID(), func(id atree.SlabID, _ atree.Slab) (bool, error) {
+			visitedIDs = append(visitedIDs, id)
+			return len(visitedIDs) < 3, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, ids[:3], visitedIDs)
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		testErr := errors.New("test")
+
+		err = atree.IterateSlabs(storage, m.SlabID(), func(atree.SlabID, atree.Slab) (bool, error) {
+			return false, testErr
+		})
+		// err is testErr wrapped in ExternalError.
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.Equal(t, testErr, externalError.Unwrap())
+	})
+
+	t.Run("slab not found", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		id := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
+
+		err := atree.IterateSlabs(storage, id, func(atree.SlabID, atree.Slab) (bool, error) {
+			return true, nil
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var slabNotFoundError *atree.SlabNotFoundError
+		require.ErrorAs(t, err, &slabNotFoundError)
+	})
+}
+
+func TestMapRemoveIfPresent(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("empty", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		removed, removedKeyStorable, removedValueStorable, err := m.RemoveIfPresent(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.False(t, removed)
+		require.Nil(t, removedKeyStorable)
+		require.Nil(t, removedValueStorable)
+
+		testEmptyMap(t, storage, typeInfo, address, m)
+	})
+
+	t.Run("present and absent", func(t *testing.T) {
+		const mapCount = 1024
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		// Remove every other key, including removing the same key twice.
+		for i := uint64(0); i < mapCount; i += 2 {
+			k := test_utils.Uint64Value(i)
+
+			removed, removedKeyStorable, removedValueStorable, err := m.RemoveIfPresent(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.True(t, removed)
+
+			removedKey, err := removedKeyStorable.StoredValue(storage)
+			require.NoError(t, err)
+			testValueEqual(t, k, removedKey)
+
+			removedValue, err := removedValueStorable.StoredValue(storage)
+			require.NoError(t, err)
+			testValueEqual(t, keyValues[k], removedValue)
+
+			delete(keyValues, k)
+
+			// Remove the same key for the second time.
+			removed, removedKeyStorable, removedValueStorable, err = m.RemoveIfPresent(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.False(t, removed)
+			require.Nil(t, removedKeyStorable)
+			require.Nil(t, removedValueStorable)
+		}
+
+		// Remove key never inserted.
+		removed, removedKeyStorable, removedValueStorable, err := m.RemoveIfPresent(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(mapCount))
+		require.NoError(t, err)
+		require.False(t, removed)
+		require.Nil(t, removedKeyStorable)
+		require.Nil(t, removedValueStorable)
+
+		// Remove still returns error for absent key.
+		_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var keyNotFoundError *atree.KeyNotFoundError
+		require.ErrorAs(t, err, &keyNotFoundError)
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	})
+
+	t.Run("absent key doesn't invalidate iterator", func(t *testing.T) {
+		const mapCount = 10
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(mapCount) {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		iterator, err := m.ReadOnlyIterator()
+		require.NoError(t, err)
+
+		count := 0
+		for {
+			removed, _, _, err := m.RemoveIfPresent(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(mapCount))
+			require.NoError(t, err)
+			require.False(t, removed)
+
+			k, _, err := iterator.Next()
+			require.NoError(t, err)
+			if k == nil {
+				break
+			}
+			count++
+		}
+		require.Equal(t, mapCount, count)
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		storage = newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		readOnlyMap, err := atree.NewMapWithRootIDReadOnly(storage, m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		removed, _, _, err := readOnlyMap.RemoveIfPresent(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var readOnlyError *atree.ReadOnlyError
+		require.ErrorAs(t, err, &readOnlyError)
+		require.False(t, removed)
+	})
+}
+
+func TestCopyContainerToAddress(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+	address2 := atree.Address{8, 7, 6, 5, 4, 3, 2, 1}
+
+	// requireSlabsAtAddress checks that all slabs of container with given
+	// root slab ID are at given address, and that they are all slabs in storage.
+	requireSlabsAtAddress := func(t *testing.T, storage *atree.PersistentSlabStorage, rootID atree.SlabID, address atree.Address) {
+		slabCount := 0
+		err := atree.IterateSlabs(storage, rootID, func(id atree.SlabID, _ atree.Slab) (bool, error) {
+			require.Equal(t, address, id.Address())
+			slabCount++
+			return true, nil
+		})
+		require.NoError(t, err)
+
+		iterator, err := storage.SlabIterator()
+		require.NoError(t, err)
+
+		storedSlabCount := 0
+		for {
+			id, _ := iterator()
+			if id == atree.SlabIDUndefined {
+				break
+			}
+			storedSlabCount++
+		}
+		require.Equal(t, storedSlabCount, slabCount)
+	}
+
+	t.Run("map with nested arrays", func(t *testing.T) {
+		const mapCount = 50
+		const childArrayCount = 50
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		expectedValues := make(test_utils.ExpectedMapValue, mapCount)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+
+			childArray, err := atree.NewArray(storage, address, typeInfo)
+			require.NoError(t, err)
+
+			// Child arrays with odd keys are too large to be inlined.
+			count := 2
+			if i%2 == 1 {
+				count = childArrayCount
+			}
+
+			expectedChildValues := make(test_utils.ExpectedArrayValue, count)
+			for j := range count {
+				var v atree.Value = test_utils.Uint64Value(j)
+				if j == 0 {
+					// Large string is stored in external StorableSlab.
+					v = test_utils.NewStringValue(strings.Repeat("a", 2048))
+				}
+
+				err = childArray.Append(v)
+				require.NoError(t, err)
+
+				expectedChildValues[j] = v
+			}
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, childArray)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			expectedValues[k] = expectedChildValues
+		}
+
+		err = storage.FastCommit(runtime.NumCPU())
+		require.NoError(t, err)
+
+		rootID := m.SlabID()
+
+		// Migrate map from persisted data.
+		srcStorage := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+		dstStorage := newTestPersistentStorage(t)
+
+		newRootID, err := atree.CopyContainerToAddress(
+			srcStorage,
+			dstStorage,
+			rootID,
+			address2,
+			atree.NewDefaultDigesterBuilder(),
+			test_utils.CompareValue,
+			test_utils.GetHashInput,
+		)
+		require.NoError(t, err)
+		require.Equal(t, address2, newRootID.Address())
+
+		// Migrated map passes health check and validation under new address.
+		_, err = atree.CheckStorageHealth(dstStorage, 1)
+		require.NoError(t, err)
+
+		err = atree.ValidateAll(dstStorage, []atree.SlabID{newRootID}, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.NoError(t, err)
+
+		requireSlabsAtAddress(t, dstStorage, newRootID, address2)
+
+		copied, err := atree.NewMapWithRootID(dstStorage, newRootID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		testMap(t, dstStorage, typeInfo, address2, copied, expectedValues, nil, true)
+
+		// Source map is unchanged.
+		srcMap, err := atree.NewMapWithRootID(srcStorage, rootID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		testMap(t, srcStorage, typeInfo, address, srcMap, expectedValues, nil, true)
+	})
+
+	t.Run("array", func(t *testing.T) {
+		const arrayCount = 500
+
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		expectedValues := make(test_utils.ExpectedArrayValue, arrayCount)
+		for i := range arrayCount {
+			v := test_utils.Uint64Value(i)
+
+			err = array.Append(v)
+			require.NoError(t, err)
+
+			expectedValues[i] = v
+		}
+
+		dstStorage := newTestPersistentStorage(t)
+
+		newRootID, err := atree.CopyContainerToAddress(
+			storage,
+			dstStorage,
+			array.SlabID(),
+			address2,
+			atree.NewDefaultDigesterBuilder(),
+			test_utils.CompareValue,
+			test_utils.GetHashInput,
+		)
+		require.NoError(t, err)
+		require.Equal(t, address2, newRootID.Address())
+
+		requireSlabsAtAddress(t, dstStorage, newRootID, address2)
+
+		copied, err := atree.NewArrayWithRootID(dstStorage, newRootID)
+		require.NoError(t, err)
+
+		testArray(t, dstStorage, typeInfo, address2, copied, expectedValues, false)
+	})
+
+	t.Run("root slab not found", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		rootID := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
+
+		newRootID, err := atree.CopyContainerToAddress(
+			storage,
+			newTestPersistentStorage(t),
+			rootID,
+			address2,
+			atree.NewDefaultDigesterBuilder(),
+			test_utils.CompareValue,
+			test_utils.GetHashInput,
+		)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var slabNotFoundError *atree.SlabNotFoundError
+		require.ErrorAs(t, err, &slabNotFoundError)
+		require.Equal(t, atree.SlabIDUndefined, newRootID)
+	})
+}
+
+func TestMapGetContainer(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	storage := newTestPersistentStorage(t)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	childMapKey := test_utils.NewStringValue("map")
+	childArrayKey := test_utils.NewStringValue("array")
+	wrappedChildArrayKey := test_utils.NewStringValue("wrapped array")
+	scalarKey := test_utils.NewStringValue("scalar")
+
+	childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	existingStorable, err := childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+
+	existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, childMapKey, childMap)
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+
+	childArray, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+
+	err = childArray.Append(test_utils.Uint64Value(0))
+	require.NoError(t, err)
+
+	existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, childArrayKey, childArray)
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+
+	wrappedChildArray, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+
+	existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, wrappedChildArrayKey, test_utils.NewSomeValue(wrappedChildArray))
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+
+	existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, scalarKey, test_utils.Uint64Value(1))
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+
+	t.Run("nested map", func(t *testing.T) {
+		gotMap, gotArray, err := m.GetContainer(test_utils.CompareValue, test_utils.GetHashInput, childMapKey)
+		require.NoError(t, err)
+		require.Nil(t, gotArray)
+		require.NotNil(t, gotMap)
+		require.Equal(t, childMap.ValueID(), gotMap.ValueID())
+		require.Equal(t, atree.SlabStorage(storage), gotMap.Storage)
+
+		// Mutation of returned nested map is reflected in parent map.
+		existingStorable, err := gotMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(1))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, childMapKey)
+		require.NoError(t, err)
+
+		testValueEqual(
+			t,
+			test_utils.ExpectedMapValue{
+				test_utils.Uint64Value(0): test_utils.Uint64Value(0),
+				test_utils.Uint64Value(1): test_utils.Uint64Value(1),
+			},
+			v,
+		)
+	})
+
+	t.Run("nested array", func(t *testing.T) {
+		gotMap, gotArray, err := m.GetContainer(test_utils.CompareValue, test_utils.GetHashInput, childArrayKey)
+		require.NoError(t, err)
+		require.Nil(t, gotMap)
+		require.NotNil(t, gotArray)
+		require.Equal(t, childArray.ValueID(), gotArray.ValueID())
+		require.Equal(t, atree.SlabStorage(storage), gotArray.Storage)
+
+		// Mutation of returned nested array is reflected in parent map.
+		err = gotArray.Append(test_utils.Uint64Value(1))
+		require.NoError(t, err)
+
+		v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, childArrayKey)
+		require.NoError(t, err)
+
+		testValueEqual(
+			t,
+			test_utils.ExpectedArrayValue{test_utils.Uint64Value(0), test_utils.Uint64Value(1)},
+			v,
+		)
+	})
+
+	t.Run("wrapped nested array", func(t *testing.T) {
+		gotMap, gotArray, err := m.GetContainer(test_utils.CompareValue, test_utils.GetHashInput, wrappedChildArrayKey)
+		require.NoError(t, err)
+		require.Nil(t, gotMap)
+		require.NotNil(t, gotArray)
+		require.Equal(t, wrappedChildArray.ValueID(), gotArray.ValueID())
+	})
+
+	t.Run("scalar", func(t *testing.T) {
+		gotMap, gotArray, err := m.GetContainer(test_utils.CompareValue, test_utils.GetHashInput, scalarKey)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		var notContainerError *atree.NotContainerError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &notContainerError)
+		require.Nil(t, gotMap)
+		require.Nil(t, gotArray)
+	})
+
+	t.Run("key not found", func(t *testing.T) {
+		gotMap, gotArray, err := m.GetContainer(test_utils.CompareValue, test_utils.GetHashInput, test_utils.NewStringValue("none"))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var keyNotFoundError *atree.KeyNotFoundError
+		require.ErrorAs(t, err, &keyNotFoundError)
+		require.Nil(t, gotMap)
+		require.Nil(t, gotArray)
+	})
+
+	testMap(
+		t,
+		storage,
+		typeInfo,
+		address,
+		m,
+		test_utils.ExpectedMapValue{
+			childMapKey: test_utils.ExpectedMapValue{
+				test_utils.Uint64Value(0): test_utils.Uint64Value(0),
+				test_utils.Uint64Value(1): test_utils.Uint64Value(1),
+			},
+			childArrayKey:        test_utils.ExpectedArrayValue{test_utils.Uint64Value(0), test_utils.Uint64Value(1)},
+			wrappedChildArrayKey: test_utils.NewExpectedWrapperValue(test_utils.ExpectedArrayValue{}),
+			scalarKey:            test_utils.Uint64Value(1),
+		},
+		nil,
+		true,
+	)
+}
+
+func TestMapContainerBlob(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	encMode, err := cbor.EncOptions{}.EncMode()
+	require.NoError(t, err)
+
+	decMode, err := cbor.DecOptions{}.DecMode()
+	require.NoError(t, err)
+
+	t.Run("collision and external values", func(t *testing.T) {
+		const mapCount = 1024
+
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		r := newRand(t)
+
+		expectedValues := make(test_utils.ExpectedMapValue, mapCount)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+
+			var v atree.Value = test_utils.Uint64Value(i)
+			var expectedValue atree.Value = v
+
+			switch i % 8 {
+			case 0:
+				// Create value stored in separate slab
+				v = test_utils.NewStringValue(randStr(r, 512))
+				expectedValue = v
+
+			case 1:
+				// Create nested array stored in separate slabs
+				childArray, err := atree.NewArray(storage, address, typeInfo)
+				require.NoError(t, err)
+
+				expectedChildValues := make(test_utils.ExpectedArrayValue, 100)
+				for j := range expectedChildValues {
+					err = childArray.Append(test_utils.Uint64Value(j))
+					require.NoError(t, err)
+
+					expectedChildValues[j] = test_utils.Uint64Value(j)
+				}
+
+				v = childArray
+				expectedValue = expectedChildValues
+			}
+
+			// Create inline and external collision groups
+			digests := []atree.Digest{atree.Digest(i % 64), atree.Digest(i % 4), atree.Digest(i)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			expectedValues[k] = expectedValue
+		}
+
+		stats, err := atree.GetMapStats(m)
+		require.NoError(t, err)
+		require.True(t, stats.MetaDataSlabCount > 0)
+		require.True(t, stats.CollisionDataSlabCount > 0)
+		require.True(t, stats.StorableSlabCount > 0)
+
+		slabCount := 0
+		err = atree.IterateSlabs(storage, m.SlabID(), func(atree.SlabID, atree.Slab) (bool, error) {
+			slabCount++
+			return true, nil
+		})
+		require.NoError(t, err)
+
+		blob, err := atree.EncodeContainerToBlob(m, encMode)
+		require.NoError(t, err)
+
+		decoded, err := atree.DecodeContainerFromBlob(
+			blob,
+			encMode,
+			decMode,
+			test_utils.DecodeStorable,
+			test_utils.DecodeTypeInfo,
+			digesterBuilder,
+		)
+		require.NoError(t, err)
+
+		decodedStorage, ok := decoded.Storage.(*atree.BasicSlabStorage)
+		require.True(t, ok)
+		require.Equal(t, slabCount, decodedStorage.Count())
+
+		require.Equal(t, m.SlabID(), decoded.SlabID())
+		require.True(t, test_utils.CompareTypeInfo(typeInfo, decoded.Type()))
+		require.Equal(t, m.Seed(), decoded.Seed())
+		require.Equal(t, uint64(mapCount), decoded.Count())
+		testValueEqual(t, expectedValues, decoded)
+
+		// Decoded map is encoded to the same blob.
+		reencodedBlob, err := atree.EncodeContainerToBlob(decoded, encMode)
+		require.NoError(t, err)
+		require.Equal(t, blob, reencodedBlob)
+
+		// New slabs created in decoded storage don't overwrite decoded slabs.
+		k := test_utils.Uint64Value(mapCount)
+		v := test_utils.NewStringValue(randStr(r, 512))
+
+		digesterBuilder.On("Digest", k).Return(mockDigester{d: []atree.Digest{0, 0, mapCount}})
+
+		existingStorable, err := decoded.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		expectedValues[k] = v
+
+		testValueEqual(t, expectedValues, decoded)
+	})
+
+	t.Run("inlined map", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), childMap)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		childMap, _, err = m.GetContainer(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.True(t, childMap.Inlined())
+
+		blob, err := atree.EncodeContainerToBlob(childMap, encMode)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+		require.Nil(t, blob)
+	})
+
+	t.Run("malformed blob", func(t *testing.T) {
+		decoded, err := atree.DecodeContainerFromBlob(
+			[]byte{0x82, 0x01},
+			encMode,
+			decMode,
+			test_utils.DecodeStorable,
+			test_utils.DecodeTypeInfo,
+			atree.NewDefaultDigesterBuilder(),
+		)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var decodingError *atree.DecodingError
+		require.ErrorAs(t, err, &decodingError)
+		require.Nil(t, decoded)
+	})
+}
+
+func testExistingMapSetType(
+	t *testing.T,
+	id atree.SlabID,
+	baseStorage atree.BaseStorage,
+	expectedTypeInfo test_utils.SimpleTypeInfo,
+	expectedCount uint64,
+	expectedSeed uint64,
+) {
+	newTypeInfo := test_utils.NewSimpleTypeInfo(expectedTypeInfo.Value() + 1)
+
+	// Create storage from existing data
+	storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	// Load existing map by ID
+	m, err := atree.NewMapWithRootID(storage, id, atree.NewDefaultDigesterBuilder())
+	require.NoError(t, err)
+	require.Equal(t, expectedCount, m.Count())
+	require.Equal(t, expectedTypeInfo, m.Type())
+	require.Equal(t, expectedSeed, m.Seed())
+
+	// Modify type info of existing map
+	err = m.SetType(newTypeInfo)
+	require.NoError(t, err)
+	require.Equal(t, expectedCount, m.Count())
+	require.Equal(t, newTypeInfo, m.Type())
+	require.Equal(t, expectedSeed, m.Seed())
+
+	// Commit data in storage
+	err = storage.FastCommit(runtime.NumCPU())
+	require.NoError(t, err)
+
+	// Create storage from existing data
+	storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+	// Load existing map again from storage
+	m2, err := atree.NewMapWithRootID(storage2, id, atree.NewDefaultDigesterBuilder())
+	require.NoError(t, err)
+	require.Equal(t, expectedCount, m2.Count())
+	require.Equal(t, newTypeInfo, m2.Type())
+	require.Equal(t, expectedSeed, m2.Seed())
+}
+
+func testExistingInlinedMapSetType(
+	t *testing.T,
+	parentID atree.SlabID,
+	inlinedChildKey atree.Value,
+	baseStorage atree.BaseStorage,
+	expectedTypeInfo test_utils.SimpleTypeInfo,
+	expectedCount uint64,
+	expectedSeed uint64,
+) {
+	newTypeInfo := test_utils.NewSimpleTypeInfo(expectedTypeInfo.Value() + 1)
+
+	// Create storage from existing data
+	storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	// Load existing map by ID
+	parentMap, err := atree.NewMapWithRootID(storage, parentID, atree.NewDefaultDigesterBuilder())
+	require.NoError(t, err)
+
+	element, err := parentMap.Get(test_utils.CompareValue, test_utils.GetHashInput, inlinedChildKey)
+	require.NoError(t, err)
+
+	childMap, ok := element.(*atree.OrderedMap)
+	require.True(t, ok)
+
+	require.Equal(t, expectedCount, childMap.Count())
+	require.Equal(t, expectedTypeInfo, childMap.Type())
+	require.Equal(t, expectedSeed, childMap.Seed())
+
+	// Modify type info of existing map
+	err = childMap.SetType(newTypeInfo)
+	require.NoError(t, err)
+	require.Equal(t, expectedCount, childMap.Count())
+	require.Equal(t, newTypeInfo, childMap.Type())
+	require.Equal(t, expectedSeed, childMap.Seed())
+
+	// Commit data in storage
+	err = storage.FastCommit(runtime.NumCPU())
+	require.NoError(t, err)
+
+	// Create storage from existing data
+	storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+	// Load existing map again from storage
+	parentMap2, err := atree.NewMapWithRootID(storage2, parentID, atree.NewDefaultDigesterBuilder())
+	require.NoError(t, err)
+
+	element2, err := parentMap2.Get(test_utils.CompareValue, test_utils.GetHashInput, inlinedChildKey)
+	require.NoError(t, err)
+
+	childMap2, ok := element2.(*atree.OrderedMap)
+	require.True(t, ok)
+
+	require.Equal(t, expectedCount, childMap2.Count())
+	require.Equal(t, newTypeInfo, childMap2.Type())
 	require.Equal(t, expectedSeed, childMap.Seed())
 }
+
+func TestMapMetaDataSlabFanout(t *testing.T) {
+
+	const threshold = 256
+
+	atree.SetThreshold(threshold)
+	defer atree.SetThreshold(1024)
+
+	defaultMinFanout, defaultMaxFanout := atree.MapMetaDataSlabFanout(threshold)
+	require.True(t, defaultMinFanout > 1)
+	require.True(t, defaultMinFanout < defaultMaxFanout)
+
+	// Threshold smaller than min slab size is rejected.
+	for _, invalidThreshold := range []uint64{0, 8, 16, threshold - 1} {
+		require.Panics(t, func() {
+			atree.MapMetaDataSlabFanout(invalidThreshold)
+		})
+	}
+
+	test := func(t *testing.T, minFanout, maxFanout int) {
+		const (
+			mapCount       = 8192
+			remainingCount = 512
+		)
+
+		typeInfo := test_utils.NewSimpleTypeInfo(42)
+		address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		keys := make([]atree.Value, 0, mapCount)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+			keys = append(keys, k)
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		// Remove most elements
+		for _, k := range keys[:mapCount-remainingCount] {
+			testMapRemoveElement(t, m, k, keyValues[k])
+			delete(keyValues, k)
+		}
+
+		require.Equal(t, uint64(remainingCount), m.Count())
+		require.False(t, IsMapRootDataSlab(m))
+
+		// Verify non-root metadata slabs aren't below min fanout
+		nonRootMetaDataSlabCount := 0
+		for _, slab := range atree.GetDeltas(storage) {
+			metaDataSlab, ok := slab.(*atree.MapMetaDataSlab)
+			if !ok || metaDataSlab.ExtraData() != nil {
+				continue
+			}
+
+			childSlabIDs, _, _ := atree.GetMapMetaDataSlabChildInfo(metaDataSlab)
+			require.GreaterOrEqual(t, len(childSlabIDs), minFanout)
+			require.LessOrEqual(t, len(childSlabIDs), maxFanout)
+
+			nonRootMetaDataSlabCount++
+		}
+		require.True(t, nonRootMetaDataSlabCount > 0)
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		test(t, defaultMinFanout, defaultMaxFanout)
+	})
+
+	t.Run("min fanout", func(t *testing.T) {
+		// Min fanout larger than min fanout derived from slab size
+		// makes slabs with enough bytes but too few children underflow.
+		minFanoutSetting := defaultMaxFanout / 2
+		require.Greater(t, minFanoutSetting, defaultMinFanout)
+
+		atree.SetMinMetaDataSlabFanout(minFanoutSetting)
+		defer atree.SetMinMetaDataSlabFanout(0)
+
+		minFanout, maxFanout := atree.MapMetaDataSlabFanout(threshold)
+		require.Equal(t, minFanoutSetting, minFanout)
+		require.Equal(t, defaultMaxFanout, maxFanout)
+
+		test(t, minFanout, maxFanout)
+	})
+
+	t.Run("min fanout is capped", func(t *testing.T) {
+		atree.SetMinMetaDataSlabFanout(defaultMaxFanout)
+		defer atree.SetMinMetaDataSlabFanout(0)
+
+		minFanout, maxFanout := atree.MapMetaDataSlabFanout(threshold)
+		require.Equal(t, defaultMaxFanout/2, minFanout)
+
+		test(t, minFanout, maxFanout)
+	})
+
+	t.Run("rebalance hysteresis", func(t *testing.T) {
+		// Min fanout is derived from lowered underflow threshold.
+		atree.SetRebalanceHysteresis(0.5)
+		defer atree.SetRebalanceHysteresis(0)
+
+		minFanout, maxFanout := atree.MapMetaDataSlabFanout(threshold)
+		require.Less(t, minFanout, defaultMinFanout)
+		require.Equal(t, defaultMaxFanout, maxFanout)
+
+		test(t, minFanout, maxFanout)
+	})
+}
+
+func TestMapDataSlabPrevSlabID(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	require.False(t, atree.MapDataSlabPrevSlabIDEnabled())
+
+	maxInlineMapElementSize := atree.MaxInlineMapElementSize()
+
+	atree.SetMapDataSlabPrevSlabIDEnabled(true)
+	defer atree.SetMapDataSlabPrevSlabIDEnabled(false)
+
+	// Prev slab ID is included in data slab size, so less space is available for elements.
+	require.Less(t, atree.MaxInlineMapElementSize(), maxInlineMapElementSize)
+
+	const (
+		mapCount = 4096
+		opCount  = 8192
+	)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	r := newRand(t)
+
+	t.Run("random set and remove", func(t *testing.T) {
+		baseStorage := test_utils.NewInMemBaseStorage()
+
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		keys := make([]atree.Value, 0, mapCount)
+
+		for range opCount {
+			if len(keys) == 0 || r.Intn(3) > 0 {
+				k := test_utils.Uint64Value(r.Intn(mapCount))
+				v := test_utils.Uint64Value(r.Intn(mapCount))
+
+				if _, exists := keyValues[k]; !exists {
+					keys = append(keys, k)
+				}
+				keyValues[k] = v
+
+				_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+				require.NoError(t, err)
+			} else {
+				index := r.Intn(len(keys))
+				k := keys[index]
+
+				testMapRemoveElement(t, m, k, keyValues[k])
+
+				delete(keyValues, k)
+				keys = append(keys[:index], keys[index+1:]...)
+			}
+		}
+
+		require.False(t, IsMapRootDataSlab(m))
+
+		testMapDataSlabChain(t, storage, m)
+
+		testMapIterateReadOnlyReverse(t, m)
+
+		testMap(t, storage, typeInfo, address, m, keyValues, nil, false)
+
+		// Decode data to new storage and verify chain of decoded data slabs
+		err = storage.FastCommit(runtime.NumCPU())
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m2, err := atree.NewMapWithRootID(storage2, m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		dataSlabIDs := testMapDataSlabChain(t, storage2, m2)
+
+		// Prev slab ID is encoded in all data slabs except the first one.
+		const maskHasPrevSlabID = 0x04
+
+		for i, id := range dataSlabIDs {
+			data, found, err := baseStorage.Retrieve(id)
+			require.NoError(t, err)
+			require.True(t, found)
+
+			require.Equal(t, i > 0, data[0]&maskHasPrevSlabID != 0)
+		}
+
+		testMapIterateReadOnlyReverse(t, m2)
+
+		testMap(t, storage2, typeInfo, address, m2, keyValues, nil, false)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		atree.SetMapDataSlabPrevSlabIDEnabled(false)
+		defer atree.SetMapDataSlabPrevSlabIDEnabled(true)
+
+		baseStorage := test_utils.NewInMemBaseStorage()
+
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			keyValues[k] = k
+
+			_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+		}
+
+		require.False(t, IsMapRootDataSlab(m))
+
+		err = storage.FastCommit(runtime.NumCPU())
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m2, err := atree.NewMapWithRootID(storage2, m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		// Prev slab ID isn't encoded or maintained.
+		const maskHasPrevSlabID = 0x04
+
+		dataSlabIDs := testMapDataSlabChain(t, storage2, m2)
+		for _, id := range dataSlabIDs {
+			data, found, err := baseStorage.Retrieve(id)
+			require.NoError(t, err)
+			require.True(t, found)
+
+			require.Equal(t, byte(0), data[0]&maskHasPrevSlabID)
+		}
+
+		// Reverse iteration follows next slab IDs without prev slab IDs.
+		testMapIterateReadOnlyReverse(t, m2)
+
+		testMap(t, storage2, typeInfo, address, m2, keyValues, nil, false)
+	})
+
+	t.Run("reverse iteration", func(t *testing.T) {
+		savedMaxCollisionLimitPerDigest := atree.MaxCollisionLimitPerDigest
+		defer func() {
+			atree.MaxCollisionLimitPerDigest = savedMaxCollisionLimitPerDigest
+		}()
+		atree.MaxCollisionLimitPerDigest = mapCount
+
+		storage := newTestPersistentStorage(t)
+
+		// Empty map
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		testMapIterateReadOnlyReverse(t, m)
+
+		// Map with root data slab
+		_, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.True(t, IsMapRootDataSlab(m))
+
+		testMapIterateReadOnlyReverse(t, m)
+
+		// Map with all elements in one collision group
+		m, err = atree.NewMap(storage, address, fullCollisionDigesterBuilder{}, typeInfo)
+		require.NoError(t, err)
+
+		for i := range 512 {
+			k := test_utils.NewStringValue(strings.Repeat("a", i))
+			_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		testMapIterateReadOnlyReverse(t, m)
+
+		// Modifying map during iteration returns ConcurrentModificationError.
+		err = m.IterateReadOnlyReverse(func(atree.Value, atree.Value) (bool, error) {
+			_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.NewStringValue("b"), test_utils.Uint64Value(0))
+			require.NoError(t, err)
+			return true, nil
+		})
+		var concurrentModificationError *atree.ConcurrentModificationError
+		require.ErrorAs(t, err, &concurrentModificationError)
+	})
+
+	t.Run("batch data", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+			require.NoError(t, err)
+		}
+
+		iter, err := m.ReadOnlyIterator()
+		require.NoError(t, err)
+
+		copied, err := atree.NewMapFromBatchData(
+			storage,
+			address,
+			atree.NewDefaultDigesterBuilder(),
+			m.Type(),
+			test_utils.CompareValue,
+			test_utils.GetHashInput,
+			m.Seed(),
+			func() (atree.Value, atree.Value, error) {
+				return iter.Next()
+			})
+		require.NoError(t, err)
+
+		require.False(t, IsMapRootDataSlab(copied))
+
+		testMapDataSlabChain(t, storage, copied)
+	})
+}
+
+// testMapDataSlabChain verifies that next and prev slab IDs of map data slabs
+// are consistent with data slab order in map slab tree, and returns data slab IDs.
+// Prev slab IDs are undefined if they aren't enabled.
+// testMapIterateReadOnlyReverse verifies that reverse iteration returns
+// elements in reverse iteration order, and it can be stopped early.
+func testMapIterateReadOnlyReverse(t *testing.T, m *atree.OrderedMap) {
+	var keys []atree.Value
+	err := m.IterateReadOnlyKeys(func(k atree.Value) (bool, error) {
+		keys = append(keys, k)
+		return true, nil
+	})
+	require.NoError(t, err)
+
+	var reversedKeys []atree.Value
+	err = m.IterateReadOnlyReverse(func(k atree.Value, _ atree.Value) (bool, error) {
+		reversedKeys = append(reversedKeys, k)
+		return true, nil
+	})
+	require.NoError(t, err)
+
+	slices.Reverse(reversedKeys)
+	require.Equal(t, keys, reversedKeys)
+
+	// Stop iteration after the first element.
+	count := 0
+	err = m.IterateReadOnlyReverse(func(atree.Value, atree.Value) (bool, error) {
+		count++
+		return false, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, min(1, len(keys)), count)
+}
+
+func testMapDataSlabChain(t *testing.T, storage atree.SlabStorage, m *atree.OrderedMap) []atree.SlabID {
+	var dataSlabs []*atree.MapDataSlab
+
+	var collectDataSlabs func(slab atree.Slab)
+	collectDataSlabs = func(slab atree.Slab) {
+		switch slab := slab.(type) {
+		case *atree.MapDataSlab:
+			dataSlabs = append(dataSlabs, slab)
+
+		case *atree.MapMetaDataSlab:
+			childSlabIDs, _, _ := atree.GetMapMetaDataSlabChildInfo(slab)
+			for _, id := range childSlabIDs {
+				child, found, err := storage.Retrieve(id)
+				require.NoError(t, err)
+				require.True(t, found)
+
+				collectDataSlabs(child)
+			}
+
+		default:
+			require.Fail(t, "unexpected map slab type %T", slab)
+		}
+	}
+
+	collectDataSlabs(atree.GetMapRootSlab(m))
+
+	for i, dataSlab := range dataSlabs {
+		next, prev := atree.GetMapDataSlabNextAndPrevSlabIDs(dataSlab)
+
+		expectedNext := atree.SlabIDUndefined
+		if i < len(dataSlabs)-1 {
+			expectedNext = dataSlabs[i+1].SlabID()
+		}
+		require.Equal(t, expectedNext, next)
+
+		expectedPrev := atree.SlabIDUndefined
+		if i > 0 && atree.MapDataSlabPrevSlabIDEnabled() {
+			expectedPrev = dataSlabs[i-1].SlabID()
+		}
+		require.Equal(t, expectedPrev, prev)
+	}
+
+	dataSlabIDs := make([]atree.SlabID, len(dataSlabs))
+	for i, dataSlab := range dataSlabs {
+		dataSlabIDs[i] = dataSlab.SlabID()
+	}
+	return dataSlabIDs
+}
+
+func TestMapDataSlabIDForKey(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// collectKeySlabIDs returns slab IDs of data slabs (or external collision
+	// group slabs) containing keys by traversing map slabs.
+	// Values of map must not be SlabIDStorable.
+	var collectKeySlabIDs func(t *testing.T, storage atree.SlabStorage, slab atree.Slab, keySlabIDs map[atree.Value]atree.SlabID)
+	collectKeySlabIDs = func(t *testing.T, storage atree.SlabStorage, slab atree.Slab, keySlabIDs map[atree.Value]atree.SlabID) {
+		switch slab := slab.(type) {
+		case *atree.MapDataSlab:
+			storables := atree.GetMapSlabStorables(slab)
+			for i := 0; i < len(storables); {
+				if id, ok := storables[i].(atree.SlabIDStorable); ok {
+					// External collision group
+					child, found, err := storage.Retrieve(atree.SlabID(id))
+					require.NoError(t, err)
+					require.True(t, found)
+
+					collectKeySlabIDs(t, storage, child, keySlabIDs)
+					i++
+					continue
+				}
+
+				k, err := storables[i].StoredValue(storage)
+				require.NoError(t, err)
+
+				keySlabIDs[k] = slab.SlabID()
+				i += 2
+			}
+
+		case *atree.MapMetaDataSlab:
+			childSlabIDs, _, _ := atree.GetMapMetaDataSlabChildInfo(slab)
+			for _, id := range childSlabIDs {
+				child, found, err := storage.Retrieve(id)
+				require.NoError(t, err)
+				require.True(t, found)
+
+				collectKeySlabIDs(t, storage, child, keySlabIDs)
+			}
+
+		default:
+			require.Fail(t, "unexpected map slab type %T", slab)
+		}
+	}
+
+	testDataSlabIDForKey := func(t *testing.T, storage atree.SlabStorage, m *atree.OrderedMap, keyValues map[atree.Value]atree.Value) {
+		keySlabIDs := make(map[atree.Value]atree.SlabID, len(keyValues))
+		collectKeySlabIDs(t, storage, atree.GetMapRootSlab(m), keySlabIDs)
+		require.Equal(t, len(keyValues), len(keySlabIDs))
+
+		for k := range keyValues {
+			id, found, err := m.DataSlabIDForKey(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Equal(t, keySlabIDs[k], id)
+		}
+	}
+
+	t.Run("no collision", func(t *testing.T) {
+		const mapCount = 4096
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		require.False(t, IsMapRootDataSlab(m))
+
+		testDataSlabIDForKey(t, storage, m, keyValues)
+
+		// Key not found
+		id, found, err := m.DataSlabIDForKey(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(mapCount))
+		require.NoError(t, err)
+		require.False(t, found)
+		require.Equal(t, atree.SlabIDUndefined, id)
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		const mapCount = 1024
+
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+
+			// Create inline and external collision groups
+			digests := []atree.Digest{atree.Digest(i % 64), atree.Digest(i % 4), atree.Digest(i)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		testDataSlabIDForKey(t, storage, m, keyValues)
+
+		// Verify some keys are in external collision groups
+		externalCollisionCount := 0
+		for k := range keyValues {
+			id, found, err := m.DataSlabIDForKey(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.True(t, found)
+
+			slab, found, err := storage.Retrieve(id)
+			require.NoError(t, err)
+			require.True(t, found)
+
+			dataSlab, ok := slab.(*atree.MapDataSlab)
+			require.True(t, ok)
+
+			if atree.IsMapDataSlabCollisionGroup(dataSlab) {
+				externalCollisionCount++
+			}
+		}
+		require.True(t, externalCollisionCount > 0)
+
+		// Key not found with colliding digests
+		k := test_utils.Uint64Value(mapCount)
+		digests := []atree.Digest{atree.Digest(0), atree.Digest(0), atree.Digest(mapCount)}
+		digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+		id, found, err := m.DataSlabIDForKey(test_utils.CompareValue, test_utils.GetHashInput, k)
+		require.NoError(t, err)
+		require.False(t, found)
+		require.Equal(t, atree.SlabIDUndefined, id)
+	})
+}
+
+func TestMapChangeJournal(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+	storage := newTestPersistentStorage(t)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	// Changes before change journal is enabled aren't recorded
+	for i := range 10 {
+		k := test_utils.Uint64Value(i)
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+	}
+	require.Nil(t, m.DrainChangeJournal())
+
+	m.EnableChangeJournal()
+
+	// Insert new key
+	_, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(10), test_utils.Uint64Value(10))
+	require.NoError(t, err)
+
+	// Update existing key
+	_, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(100))
+	require.NoError(t, err)
+
+	// Remove existing key
+	_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(5))
+	require.NoError(t, err)
+
+	// Remove non-existent key isn't recorded
+	_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(20))
+	var keyNotFoundError *atree.KeyNotFoundError
+	require.ErrorAs(t, err, &keyNotFoundError)
+
+	// Get isn't recorded
+	_, err = m.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1))
+	require.NoError(t, err)
+
+	require.Equal(
+		t,
+		[]atree.Value{test_utils.Uint64Value(10), test_utils.Uint64Value(0), test_utils.Uint64Value(5)},
+		m.DrainChangeJournal(),
+	)
+
+	// Change journal is cleared after drain
+	require.Nil(t, m.DrainChangeJournal())
+
+	_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(10))
+	require.NoError(t, err)
+
+	require.Equal(t, []atree.Value{test_utils.Uint64Value(10)}, m.DrainChangeJournal())
+}
+
+func TestMapExternalValueThreshold(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	key := test_utils.Uint64Value(0)
+
+	// String value with encoded size between external value threshold and default max inline size.
+	value := test_utils.NewStringValue(strings.Repeat("a", 100))
+
+	testExternalValueThreshold := func(t *testing.T, externalValueThreshold uint64, expectedInlined bool) {
+		atree.SetExternalValueThreshold(externalValueThreshold)
+		defer atree.SetExternalValueThreshold(0)
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, key, value)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		storables := atree.GetMapRootSlabStorables(m)
+		require.Equal(t, 2, len(storables))
+
+		_, isSlabIDStorable := storables[1].(atree.SlabIDStorable)
+		require.Equal(t, expectedInlined, !isSlabIDStorable)
+
+		testMap(t, storage, typeInfo, address, m, map[atree.Value]atree.Value{key: value}, nil, false)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		testExternalValueThreshold(t, 0, true)
+	})
+
+	t.Run("value larger than threshold", func(t *testing.T) {
+		testExternalValueThreshold(t, 64, false)
+	})
+
+	t.Run("value smaller than threshold", func(t *testing.T) {
+		testExternalValueThreshold(t, 128, true)
+	})
+}
+
+func TestMapMaxValueSize(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// String value with encoded size smaller than default max inline size.
+	smallValue := test_utils.NewStringValue(strings.Repeat("a", 100))
+
+	// String value with encoded size larger than default max inline size.
+	largeValue := test_utils.NewStringValue(strings.Repeat("b", 1000))
+
+	// String key stored externally because it is larger than max inline key size.
+	largeKey := test_utils.NewStringValue(strings.Repeat("k", 1000))
+
+	requireMaxValueSizeError := func(t *testing.T, err error) {
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		var maxValueSizeError *atree.MaxValueSizeError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &maxValueSizeError)
+		require.ErrorAs(t, userError, &maxValueSizeError)
+	}
+
+	testError := func(t *testing.T, value atree.Value, maxValueSize uint64, expectError bool) {
+		atree.SetMaxValueSize(maxValueSize, atree.MaxValueSizeModeError)
+		defer atree.SetMaxValueSize(0, atree.MaxValueSizeModeError)
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		existingKey := test_utils.Uint64Value(0)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, existingKey, test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		newKey := test_utils.Uint64Value(1)
+
+		existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, newKey, value)
+		if !expectError {
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			expected := map[atree.Value]atree.Value{
+				existingKey: test_utils.Uint64Value(0),
+				newKey:      value,
+			}
+			testMap(t, storage, typeInfo, address, m, expected, nil, false)
+			return
+		}
+		requireMaxValueSizeError(t, err)
+		require.Nil(t, existingStorable)
+
+		// Update existing element
+		existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, existingKey, value)
+		requireMaxValueSizeError(t, err)
+		require.Nil(t, existingStorable)
+
+		// Insert element with external key
+		existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, largeKey, value)
+		requireMaxValueSizeError(t, err)
+		require.Nil(t, existingStorable)
+
+		// Map is unchanged and rejected external key and value aren't left in storage.
+		testMap(t, storage, typeInfo, address, m, map[atree.Value]atree.Value{existingKey: test_utils.Uint64Value(0)}, nil, false)
+	}
+
+	t.Run("error mode, inlined value at limit", func(t *testing.T) {
+		testError(t, smallValue, uint64(smallValue.ByteSize()), false)
+	})
+
+	t.Run("error mode, inlined value over limit", func(t *testing.T) {
+		testError(t, smallValue, uint64(smallValue.ByteSize())-1, true)
+	})
+
+	t.Run("error mode, external value at limit", func(t *testing.T) {
+		testError(t, largeValue, uint64(largeValue.ByteSize()), false)
+	})
+
+	t.Run("error mode, external value over limit", func(t *testing.T) {
+		testError(t, largeValue, uint64(largeValue.ByteSize())-1, true)
+	})
+
+	testForceExternal := func(t *testing.T, maxValueSize uint64, expectedInlined bool) {
+		atree.SetMaxValueSize(maxValueSize, atree.MaxValueSizeModeForceExternal)
+		defer atree.SetMaxValueSize(0, atree.MaxValueSizeModeError)
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		key := test_utils.Uint64Value(0)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, key, smallValue)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		storables := atree.GetMapRootSlabStorables(m)
+		require.Equal(t, 2, len(storables))
+
+		_, isSlabIDStorable := storables[1].(atree.SlabIDStorable)
+		require.Equal(t, expectedInlined, !isSlabIDStorable)
+
+		testMap(t, storage, typeInfo, address, m, map[atree.Value]atree.Value{key: smallValue}, nil, false)
+	}
+
+	t.Run("force external mode, value at limit", func(t *testing.T) {
+		testForceExternal(t, uint64(smallValue.ByteSize()), true)
+	})
+
+	t.Run("force external mode, value over limit", func(t *testing.T) {
+		testForceExternal(t, uint64(smallValue.ByteSize())-1, false)
+	})
+}
+
+func TestMapIterateValidating(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	newMap := func(t *testing.T, mapCount int) (*atree.OrderedMap, map[atree.Value]atree.Value) {
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, mapCount)
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+			keyValues[k] = v
+
+			// Create collision groups
+			digests := []atree.Digest{atree.Digest(i / 2), atree.Digest(i)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		return m, keyValues
+	}
+
+	getDataSlab := func(t *testing.T, m *atree.OrderedMap, index int) *atree.MapDataSlab {
+		// Find leftmost data slab.
+		var slab atree.Slab = atree.GetMapRootSlab(m)
+		for {
+			metaDataSlab, ok := slab.(*atree.MapMetaDataSlab)
+			if !ok {
+				break
+			}
+
+			childSlabIDs, _, _ := atree.GetMapMetaDataSlabChildInfo(metaDataSlab)
+
+			var found bool
+			var err error
+			slab, found, err = m.Storage.Retrieve(childSlabIDs[0])
+			require.NoError(t, err)
+			require.True(t, found)
+		}
+
+		dataSlab, ok := slab.(*atree.MapDataSlab)
+		require.True(t, ok)
+
+		// Follow next slab IDs.
+		for range index {
+			next, _ := atree.GetMapDataSlabNextAndPrevSlabIDs(dataSlab)
+			require.NotEqual(t, atree.SlabIDUndefined, next)
+
+			slab, found, err := m.Storage.Retrieve(next)
+			require.NoError(t, err)
+			require.True(t, found)
+
+			dataSlab, ok = slab.(*atree.MapDataSlab)
+			require.True(t, ok)
+		}
+
+		return dataSlab
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		const mapCount = 256
+
+		m, keyValues := newMap(t, mapCount)
+		require.False(t, IsMapRootDataSlab(m))
+
+		iterated := make(map[atree.Value]atree.Value, mapCount)
+		var prevKey atree.Value
+
+		err := m.IterateValidating(func(k atree.Value, v atree.Value) (bool, error) {
+			// Keys are iterated in digest order which is the same as key order.
+			if prevKey != nil {
+				require.Greater(t, k.(test_utils.Uint64Value), prevKey.(test_utils.Uint64Value))
+			}
+			prevKey = k
+
+			iterated[k] = v
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, keyValues, iterated)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		m, _ := newMap(t, 256)
+
+		count := 0
+		err := m.IterateValidating(func(atree.Value, atree.Value) (bool, error) {
+			count++
+			return count < 10, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 10, count)
+	})
+
+	t.Run("unsorted digests in data slab", func(t *testing.T) {
+		m, _ := newMap(t, 8)
+		require.True(t, IsMapRootDataSlab(m))
+
+		dataSlab := getDataSlab(t, m, 0)
+		atree.SetMapDataSlabDigest(dataSlab, 2, atree.Digest(0))
+
+		count := 0
+		err := m.IterateValidating(func(atree.Value, atree.Value) (bool, error) {
+			count++
+			return true, nil
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var slabDataError *atree.SlabDataError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &slabDataError)
+
+		// Elements before the violation are iterated.
+		require.Equal(t, 4, count)
+	})
+
+	t.Run("unsorted digests across data slabs", func(t *testing.T) {
+		m, _ := newMap(t, 256)
+		require.False(t, IsMapRootDataSlab(m))
+
+		dataSlab := getDataSlab(t, m, 1)
+		atree.SetMapDataSlabDigest(dataSlab, 0, atree.Digest(0))
+
+		err := m.IterateValidating(func(atree.Value, atree.Value) (bool, error) {
+			return true, nil
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var slabDataError *atree.SlabDataError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &slabDataError)
+	})
+}
+
+func TestValidateMapDigestOrder(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 256
+
+	newMap := func(t *testing.T) *atree.OrderedMap {
+		storage := newTestPersistentStorage(t)
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+
+			digests := []atree.Digest{atree.Digest(i)}
+			digesterBuilder.On("Digest", k).Return(mockDigester{d: digests})
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		require.False(t, IsMapRootDataSlab(m))
+
+		return m
+	}
+
+	// getSecondDataSlab returns the second data slab in next pointer order.
+	getSecondDataSlab := func(t *testing.T, m *atree.OrderedMap) *atree.MapDataSlab {
+		var slab atree.Slab = atree.GetMapRootSlab(m)
+		for {
+			metaDataSlab, ok := slab.(*atree.MapMetaDataSlab)
+			if !ok {
+				break
+			}
+
+			childSlabIDs, _, _ := atree.GetMapMetaDataSlabChildInfo(metaDataSlab)
+
+			var found bool
+			var err error
+			slab, found, err = m.Storage.Retrieve(childSlabIDs[0])
+			require.NoError(t, err)
+			require.True(t, found)
+		}
+
+		dataSlab, ok := slab.(*atree.MapDataSlab)
+		require.True(t, ok)
+
+		next, _ := atree.GetMapDataSlabNextAndPrevSlabIDs(dataSlab)
+		require.NotEqual(t, atree.SlabIDUndefined, next)
+
+		slab, found, err := m.Storage.Retrieve(next)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		dataSlab, ok = slab.(*atree.MapDataSlab)
+		require.True(t, ok)
+
+		return dataSlab
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		err = atree.ValidateMapDigestOrder(m)
+		require.NoError(t, err)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		m := newMap(t)
+
+		err := atree.ValidateMapDigestOrder(m)
+		require.NoError(t, err)
+	})
+
+	t.Run("unsorted digests across data slabs", func(t *testing.T) {
+		m := newMap(t)
+
+		// Corrupt first digest of second data slab to be less than
+		// last digest of first data slab.
+		dataSlab := getSecondDataSlab(t, m)
+		atree.SetMapDataSlabDigest(dataSlab, 0, atree.Digest(0))
+
+		err := atree.ValidateMapDigestOrder(m)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var slabDataError *atree.SlabDataError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &slabDataError)
+		require.ErrorContains(t, err, "first digest")
+	})
+
+	t.Run("unsorted digests in data slab", func(t *testing.T) {
+		m := newMap(t)
+
+		dataSlab := getSecondDataSlab(t, m)
+		atree.SetMapDataSlabDigest(dataSlab, 1, atree.Digest(0))
+
+		err := atree.ValidateMapDigestOrder(m)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var slabDataError *atree.SlabDataError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &slabDataError)
+		require.ErrorContains(t, err, "at index 1")
+	})
+}
+
+func TestMapDeepCopy(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+	address2 := atree.Address{8, 7, 6, 5, 4, 3, 2, 1}
+
+	const mapCount = 20
+	const childCount = 50
+
+	// createMapWithNestedContainers creates map with child array and child map values.
+	// Child arrays contain large strings stored in external StorableSlab.
+	createMapWithNestedContainers := func(t *testing.T, storage *atree.PersistentSlabStorage) (*atree.OrderedMap, test_utils.ExpectedMapValue) {
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		expectedValues := make(test_utils.ExpectedMapValue, mapCount)
+
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+
+			var v atree.Value
+			var expectedValue atree.Value
+
+			if i%2 == 0 {
+				childArray, err := atree.NewArray(storage, address, typeInfo)
+				require.NoError(t, err)
+
+				expectedChildValues := make(test_utils.ExpectedArrayValue, childCount)
+				for j := range childCount {
+					var cv atree.Value = test_utils.Uint64Value(j)
+					if j == 0 {
+						cv = test_utils.NewStringValue(strings.Repeat("a", 2048))
+					}
+
+					err = childArray.Append(cv)
+					require.NoError(t, err)
+
+					expectedChildValues[j] = cv
+				}
+
+				v, expectedValue = childArray, expectedChildValues
+			} else {
+				childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+				require.NoError(t, err)
+
+				expectedChildValues := make(test_utils.ExpectedMapValue, childCount)
+				for j := range childCount {
+					ck := test_utils.Uint64Value(j)
+					cv := test_utils.Uint64Value(j * 2)
+
+					existingStorable, err := childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, ck, cv)
+					require.NoError(t, err)
+					require.Nil(t, existingStorable)
+
+					expectedChildValues[ck] = cv
+				}
+
+				v, expectedValue = childMap, expectedChildValues
+			}
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			expectedValues[k] = expectedValue
+		}
+
+		return m, expectedValues
+	}
+
+	verifyMapValues := func(t *testing.T, m *atree.OrderedMap, expectedValues test_utils.ExpectedMapValue) {
+		require.Equal(t, uint64(len(expectedValues)), m.Count())
+
+		for k, expected := range expectedValues {
+			actual, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+
+			testValueEqual(t, expected, actual)
+		}
+	}
+
+	t.Run("map", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, expectedValues := createMapWithNestedContainers(t, storage)
+
+		copied, err := m.DeepCopy(storage, address2, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.Equal(t, address2, copied.Address())
+		require.NotEqual(t, m.SlabID(), copied.SlabID())
+		require.Equal(t, m.Seed(), copied.Seed())
+
+		verifyMapValues(t, copied, expectedValues)
+
+		err = atree.VerifyMap(copied, address2, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.NoError(t, err)
+
+		// Source and copy don't share any slab.
+		_, err = atree.CheckStorageHealth(storage, 2)
+		require.NoError(t, err)
+
+		// Mutate nested containers of copy.
+		for i := range mapCount {
+			v, err := copied.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+
+			switch v := v.(type) {
+			case *atree.Array:
+				require.Equal(t, address2, v.Address())
+
+				existingStorable, err := v.Set(0, test_utils.Uint64Value(100))
+				require.NoError(t, err)
+
+				// Replaced large string is stored in a new StorableSlab at target address.
+				slabIDStorable, ok := existingStorable.(atree.SlabIDStorable)
+				require.True(t, ok)
+				require.Equal(t, address2, atree.SlabID(slabIDStorable).Address())
+
+				err = storage.Remove(atree.SlabID(slabIDStorable))
+				require.NoError(t, err)
+
+			case *atree.OrderedMap:
+				require.Equal(t, address2, v.Address())
+
+				existingKey, existingValue, err := v.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+				require.NoError(t, err)
+				require.Equal(t, test_utils.Uint64Value(0), existingKey)
+				require.Equal(t, test_utils.Uint64Value(0), existingValue)
+
+			default:
+				require.Fail(t, "unexpected value type %T", v)
+			}
+		}
+
+		// Source is untouched.
+		verifyMapValues(t, m, expectedValues)
+
+		err = atree.VerifyMap(m, address, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.NoError(t, err)
+
+		_, err = atree.CheckStorageHealth(storage, 2)
+		require.NoError(t, err)
+	})
+
+	t.Run("set copied container in map", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, expectedValues := createMapWithNestedContainers(t, storage)
+
+		parentMap, err := atree.NewMap(storage, address2, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		// Set deep copy of each child container in parent map at another address.
+		for k := range expectedValues {
+			v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+
+			var copied atree.Value
+			switch v := v.(type) {
+			case *atree.Array:
+				c, err := v.DeepCopy(storage, address2, test_utils.CompareValue, test_utils.GetHashInput)
+				require.NoError(t, err)
+				require.NotEqual(t, v.ValueID(), c.ValueID())
+				copied = c
+
+			case *atree.OrderedMap:
+				c, err := v.DeepCopy(storage, address2, test_utils.CompareValue, test_utils.GetHashInput)
+				require.NoError(t, err)
+				require.NotEqual(t, v.ValueID(), c.ValueID())
+				copied = c
+
+			default:
+				require.Fail(t, "unexpected value type %T", v)
+			}
+
+			existingStorable, err := parentMap.Set(test_utils.CompareValue, test_utils.GetHashInput, k, copied)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		verifyMapValues(t, parentMap, expectedValues)
+
+		err = atree.VerifyMap(parentMap, address2, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.NoError(t, err)
+
+		_, err = atree.CheckStorageHealth(storage, 2)
+		require.NoError(t, err)
+
+		// Mutate child containers of source map.
+		for k := range expectedValues {
+			v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+
+			switch v := v.(type) {
+			case *atree.Array:
+				err = v.Append(test_utils.Uint64Value(100))
+				require.NoError(t, err)
+
+			case *atree.OrderedMap:
+				existingStorable, err := v.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(100))
+				require.NoError(t, err)
+				require.NotNil(t, existingStorable)
+			}
+		}
+
+		// Copied containers are untouched.
+		verifyMapValues(t, parentMap, expectedValues)
+
+		err = atree.VerifyMap(parentMap, address2, typeInfo, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.NoError(t, err)
+	})
+
+	t.Run("wrapped values", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		// Wrapped non-container value is copied.
+		wrappedValue := test_utils.NewSomeValue(test_utils.Uint64Value(0))
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), wrappedValue)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		copied, err := m.DeepCopy(storage, address2, test_utils.CompareValue, test_utils.GetHashInput)
+		require.NoError(t, err)
+
+		v, err := copied.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Equal(t, wrappedValue, v)
+
+		// Wrapped nested map can't be rewrapped after copying.
+		childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		existingStorable, err = childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.NewSomeValue(childMap))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		_, err = m.DeepCopy(storage, address2, test_utils.CompareValue, test_utils.GetHashInput)
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+
+		// Slabs of failed copy are removed.
+		_, err = atree.CheckStorageHealth(storage, 2)
+		require.NoError(t, err)
+	})
+}
+
+func TestMapHashInputStabilityCheck(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// nondeterministicHashInput returns different hash input on each call.
+	counter := uint64(0)
+	nondeterministicHashInput := func(value atree.Value, buffer []byte) ([]byte, error) {
+		counter++
+		b, err := test_utils.GetHashInput(value, buffer)
+		if err != nil {
+			return nil, err
+		}
+		return append(b, byte(counter)), nil
+	}
+
+	// key isn't FixedSizeHashInputValue, so its hash input is from HashInputProvider.
+	key := test_utils.NewStringValue("a")
+
+	t.Run("deterministic", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, atree.WithHashInputStabilityCheck())
+		require.NoError(t, err)
+
+		expectedValues := make(test_utils.ExpectedMapValue)
+		for i := range 100 {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			expectedValues[k] = v
+		}
+
+		testMap(t, storage, typeInfo, address, m, expectedValues, nil, false)
+	})
+
+	t.Run("nondeterministic without check", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, nondeterministicHashInput, key, test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		// Key is unfindable.
+		_, err = m.Get(test_utils.CompareValue, nondeterministicHashInput, key)
+		var keyNotFoundError *atree.KeyNotFoundError
+		require.ErrorAs(t, err, &keyNotFoundError)
+	})
+
+	t.Run("nondeterministic with check", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, atree.WithHashInputStabilityCheck())
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, nondeterministicHashInput, key, test_utils.Uint64Value(0))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var hashError *atree.HashError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &hashError)
+		require.Nil(t, existingStorable)
+
+		require.Equal(t, uint64(0), m.Count())
+	})
+
+	t.Run("nondeterministic with check on loaded map", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		m, err = atree.NewMapWithRootID(storage, m.SlabID(), atree.NewDefaultDigesterBuilder(), atree.WithHashInputStabilityCheck())
+		require.NoError(t, err)
+
+		_, err = m.Set(test_utils.CompareValue, nondeterministicHashInput, key, test_utils.Uint64Value(0))
+		var hashError *atree.HashError
+		require.ErrorAs(t, err, &hashError)
+	})
+}
+
+func TestMapConcurrentModification(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 100
+
+	newMap := func(t *testing.T) *atree.OrderedMap {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		return m
+	}
+
+	requireConcurrentModificationError := func(t *testing.T, err error) {
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		var concurrentModificationError *atree.ConcurrentModificationError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &concurrentModificationError)
+	}
+
+	t.Run("set during iteration", func(t *testing.T) {
+		m := newMap(t)
+
+		count := 0
+		err := m.Iterate(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value, _ atree.Value) (bool, error) {
+			count++
+			if count == 10 {
+				_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(mapCount), test_utils.Uint64Value(0))
+				require.NoError(t, err)
+			}
+			return true, nil
+		})
+		requireConcurrentModificationError(t, err)
+		require.Equal(t, 10, count)
+	})
+
+	t.Run("update during iteration", func(t *testing.T) {
+		m := newMap(t)
+
+		// Updating existing element during iteration is supported.
+		count := 0
+		err := m.Iterate(test_utils.CompareValue, test_utils.GetHashInput, func(k atree.Value, _ atree.Value) (bool, error) {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(0))
+			require.NoError(t, err)
+			require.NotNil(t, existingStorable)
+
+			count++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, mapCount, count)
+	})
+
+	t.Run("update during readonly iteration", func(t *testing.T) {
+		m := newMap(t)
+
+		iterator, err := m.ReadOnlyIterator()
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(1))
+		require.NoError(t, err)
+		require.NotNil(t, existingStorable)
+
+		_, _, err = iterator.Next()
+		requireConcurrentModificationError(t, err)
+	})
+
+	t.Run("swap during readonly iteration", func(t *testing.T) {
+		m := newMap(t)
+
+		iterator, err := m.ReadOnlyIterator()
+		require.NoError(t, err)
+
+		err = m.Swap(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(1))
+		require.NoError(t, err)
+
+		_, _, err = iterator.Next()
+		requireConcurrentModificationError(t, err)
+	})
+
+	t.Run("failed operations", func(t *testing.T) {
+		m := newMap(t)
+
+		iterator, err := m.ReadOnlyIterator()
+		require.NoError(t, err)
+
+		// Failed operations don't invalidate iterator.
+		_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(mapCount))
+		var keyNotFoundError *atree.KeyNotFoundError
+		require.ErrorAs(t, err, &keyNotFoundError)
+
+		err = m.Insert(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		var duplicateKeyError *atree.DuplicateKeyError
+		require.ErrorAs(t, err, &duplicateKeyError)
+
+		err = m.Swap(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(mapCount))
+		require.ErrorAs(t, err, &keyNotFoundError)
+
+		count := 0
+		for {
+			k, _, err := iterator.Next()
+			require.NoError(t, err)
+			if k == nil {
+				break
+			}
+			count++
+		}
+		require.Equal(t, mapCount, count)
+	})
+
+	t.Run("remove during readonly iteration", func(t *testing.T) {
+		m := newMap(t)
+
+		iterator, err := m.ReadOnlyIterator()
+		require.NoError(t, err)
+
+		k, _, err := iterator.Next()
+		require.NoError(t, err)
+		require.NotNil(t, k)
+
+		_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+		require.NoError(t, err)
+
+		_, _, err = iterator.Next()
+		requireConcurrentModificationError(t, err)
+
+		_, err = iterator.NextKey()
+		requireConcurrentModificationError(t, err)
+
+		_, err = iterator.NextValue()
+		requireConcurrentModificationError(t, err)
+	})
+
+	t.Run("mutate child during iteration", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range mapCount {
+			childArray, err := atree.NewArray(storage, address, typeInfo)
+			require.NoError(t, err)
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), childArray)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		// Modifying child container isn't modification of parent map.
+		count := 0
+		err = m.Iterate(test_utils.CompareValue, test_utils.GetHashInput, func(_ atree.Value, v atree.Value) (bool, error) {
+			childArray, ok := v.(*atree.Array)
+			require.True(t, ok)
+
+			err := childArray.Append(test_utils.Uint64Value(0))
+			require.NoError(t, err)
+
+			count++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, mapCount, count)
+	})
+
+	t.Run("new iterator after modification", func(t *testing.T) {
+		m := newMap(t)
+
+		_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(mapCount), test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		count := 0
+		err = m.IterateReadOnly(func(atree.Value, atree.Value) (bool, error) {
+			count++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, mapCount+1, count)
+	})
+}
+
+func TestNewMapWithSeed(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const seed = uint64(0x1234567890abcdef)
+	const mapCount = 1000
+
+	// buildMap builds map on a "node" with its own storage.
+	buildMap := func(t *testing.T, seed uint64) (*atree.BasicSlabStorage, *atree.OrderedMap) {
+		storage := newTestBasicStorage(t)
+
+		m, err := atree.NewMapWithSeed(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, seed)
+		require.NoError(t, err)
+		require.Equal(t, seed, m.Seed())
+
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.NewStringValue(strings.Repeat("a", i%16))
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		return storage, m
+	}
+
+	t.Run("same seed", func(t *testing.T) {
+		storage1, m1 := buildMap(t, seed)
+		storage2, m2 := buildMap(t, seed)
+
+		require.Equal(t, m1.SlabID(), m2.SlabID())
+
+		encoded1, err := storage1.Encode()
+		require.NoError(t, err)
+
+		encoded2, err := storage2.Encode()
+		require.NoError(t, err)
+
+		require.Equal(t, encoded1, encoded2)
+
+		// Loaded map uses stored seed.
+		decodedStorage := newTestBasicStorage(t)
+
+		err = decodedStorage.Load(encoded1)
+		require.NoError(t, err)
+
+		decodedMap, err := atree.NewMapWithRootID(decodedStorage, m1.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+		require.Equal(t, seed, decodedMap.Seed())
+
+		for i := range mapCount {
+			v, err := decodedMap.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Equal(t, test_utils.NewStringValue(strings.Repeat("a", i%16)), v)
+		}
+	})
+
+	t.Run("different seed", func(t *testing.T) {
+		storage1, _ := buildMap(t, seed)
+		storage2, _ := buildMap(t, seed+1)
+
+		encoded1, err := storage1.Encode()
+		require.NoError(t, err)
+
+		encoded2, err := storage2.Encode()
+		require.NoError(t, err)
+
+		require.NotEqual(t, encoded1, encoded2)
+	})
+
+	t.Run("zero seed", func(t *testing.T) {
+		storage := newTestBasicStorage(t)
+
+		m, err := atree.NewMapWithSeed(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo, 0)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var hashSeedUninitializedError *atree.HashSeedUninitializedError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &hashSeedUninitializedError)
+		require.Nil(t, m)
+	})
+}
+
+func TestReduceMap(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 1000
+
+	storage := newTestPersistentStorage(t)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	r := newRand(t)
+
+	var expectedKeySum, expectedValueSum, expectedMax uint64
+	for i := range mapCount {
+		k := uint64(i)
+		v := uint64(r.Intn(1000))
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(k), test_utils.Uint64Value(v))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		expectedKeySum += k
+		expectedValueSum += v
+		expectedMax = max(expectedMax, v)
+	}
+
+	t.Run("sum", func(t *testing.T) {
+		type sums struct {
+			keys   uint64
+			values uint64
+		}
+
+		result, err := atree.ReduceMap(m, sums{}, func(acc sums, k atree.Value, v atree.Value) (sums, error) {
+			acc.keys += uint64(k.(test_utils.Uint64Value))
+			acc.values += uint64(v.(test_utils.Uint64Value))
+			return acc, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, expectedKeySum, result.keys)
+		require.Equal(t, expectedValueSum, result.values)
+	})
+
+	t.Run("max", func(t *testing.T) {
+		maxValue, err := atree.ReduceMap(m, uint64(0), func(acc uint64, _ atree.Value, v atree.Value) (uint64, error) {
+			return max(acc, uint64(v.(test_utils.Uint64Value))), nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, expectedMax, maxValue)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		testErr := errors.New("test")
+
+		calls := 0
+		result, err := atree.ReduceMap(m, uint64(0), func(acc uint64, _ atree.Value, v atree.Value) (uint64, error) {
+			calls++
+			if calls == 10 {
+				return 0, testErr
+			}
+			return acc + uint64(v.(test_utils.Uint64Value)), nil
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.Equal(t, testErr, externalError.Unwrap())
+		require.Equal(t, uint64(0), result)
+		require.Equal(t, 10, calls)
+	})
+}
+
+func TestMapMaxElementCount(t *testing.T) {
+
+	const maxCount = 10
+
+	atree.SetMaxMapElementCount(maxCount)
+	defer atree.SetMaxMapElementCount(0)
+
+	require.Equal(t, uint64(maxCount), atree.MaxMapElementCount())
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	storage := newTestPersistentStorage(t)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	expectedValues := make(test_utils.ExpectedMapValue)
+	for i := range maxCount {
+		k := test_utils.Uint64Value(i)
+		v := test_utils.Uint64Value(i)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		expectedValues[k] = v
+	}
+
+	// Insert new element
+	existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(maxCount), test_utils.Uint64Value(maxCount))
+	require.Equal(t, 1, errorCategorizationCount(err))
+	var userError *atree.UserError
+	var maxMapSizeError *atree.MaxMapSizeError
+	require.ErrorAs(t, err, &userError)
+	require.ErrorAs(t, err, &maxMapSizeError)
+	require.Nil(t, existingStorable)
+
+	testMap(t, storage, typeInfo, address, m, expectedValues, nil, false)
+
+	// Update existing element
+	existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(100))
+	require.NoError(t, err)
+	require.Equal(t, test_utils.Uint64Value(0), existingStorable)
+
+	expectedValues[test_utils.Uint64Value(0)] = test_utils.Uint64Value(100)
+
+	// Remove element and insert new element
+	_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1))
+	require.NoError(t, err)
+
+	delete(expectedValues, test_utils.Uint64Value(1))
+
+	existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(maxCount), test_utils.Uint64Value(maxCount))
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+
+	expectedValues[test_utils.Uint64Value(maxCount)] = test_utils.Uint64Value(maxCount)
+
+	testMap(t, storage, typeInfo, address, m, expectedValues, nil, false)
+
+	// Reset max count
+	atree.SetMaxMapElementCount(0)
+	require.Equal(t, atree.DefaultMaxMapElementCount, atree.MaxMapElementCount())
+
+	existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(maxCount+1), test_utils.Uint64Value(maxCount+1))
+	require.NoError(t, err)
+	require.Nil(t, existingStorable)
+}
+
+func TestMapHasAll(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	testHasAll := func(t *testing.T, m *atree.OrderedMap, keys []atree.Value) {
+		result, err := m.HasAll(test_utils.CompareValue, test_utils.GetHashInput, keys)
+		require.NoError(t, err)
+		require.Equal(t, len(keys), len(result))
+
+		for i, k := range keys {
+			exist, err := m.Has(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.Equal(t, exist, result[i], "key %s", k)
+		}
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		result, err := m.HasAll(test_utils.CompareValue, test_utils.GetHashInput, nil)
+		require.NoError(t, err)
+		require.Equal(t, 0, len(result))
+
+		testHasAll(t, m, []atree.Value{test_utils.Uint64Value(0), test_utils.Uint64Value(1)})
+	})
+
+	t.Run("no collision", func(t *testing.T) {
+		const mapCount = 4096
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(mapCount) {
+			// Only insert even keys so odd keys are absent.
+			k := test_utils.Uint64Value(i * 2)
+			v := test_utils.Uint64Value(i)
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		require.False(t, IsMapRootDataSlab(m))
+
+		r := newRand(t)
+
+		keys := make([]atree.Value, 0, mapCount)
+		for range mapCount {
+			keys = append(keys, test_utils.Uint64Value(r.Intn(mapCount*2)))
+		}
+		// Duplicate keys
+		keys = append(keys, keys[:10]...)
+
+		testHasAll(t, m, keys)
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		const mapCount = 1024
+
+		savedMaxCollisionLimitPerDigest := atree.MaxCollisionLimitPerDigest
+		atree.MaxCollisionLimitPerDigest = uint32(math.Ceil(float64(mapCount) / 10))
+		defer func() {
+			atree.MaxCollisionLimitPerDigest = savedMaxCollisionLimitPerDigest
+		}()
+
+		digesterBuilder := &mockDigesterBuilder{}
+
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, digesterBuilder, typeInfo)
+		require.NoError(t, err)
+
+		keys := make([]atree.Value, 0, mapCount*2)
+		for i := range uint64(mapCount * 2) {
+			k := test_utils.Uint64Value(i)
+
+			digests := []atree.Digest{
+				atree.Digest(i % 10),
+				atree.Digest(i % 100),
+			}
+			digesterBuilder.On("Digest", k).Return(mockDigester{digests})
+
+			keys = append(keys, k)
+
+			// Only insert first half of keys so second half are absent
+			// but share digests with inserted keys.
+			if i < mapCount {
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, k)
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+			}
+		}
+
+		r := newRand(t)
+		r.Shuffle(len(keys), func(i, j int) {
+			keys[i], keys[j] = keys[j], keys[i]
+		})
+
+		testHasAll(t, m, keys)
+	})
+}
+
+func TestNewMapWithRootIDLazy(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	const mapCount = 1024
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// setupBaseStorage returns base storage containing committed map.
+	setupBaseStorage := func(t *testing.T) (*test_utils.InMemBaseStorage, atree.SlabID, test_utils.ExpectedMapValue) {
+		baseStorage := test_utils.NewInMemBaseStorage()
+
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		expectedValues := make(test_utils.ExpectedMapValue)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i)
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			expectedValues[k] = v
+		}
+
+		require.False(t, IsMapRootDataSlab(m))
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		return baseStorage, m.SlabID(), expectedValues
+	}
+
+	t.Run("undefined slab ID", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMapWithRootIDLazy(storage, atree.SlabIDUndefined, atree.NewDefaultDigesterBuilder())
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var slabIDError *atree.SlabIDError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &slabIDError)
+		require.Nil(t, m)
+	})
+
+	t.Run("no retrieval until first use", func(t *testing.T) {
+		baseStorage, rootID, expectedValues := setupBaseStorage(t)
+
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		segmentsReturned := baseStorage.SegmentsReturned()
+
+		m, err := atree.NewMapWithRootIDLazy(storage, rootID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		// Methods not requiring root slab don't retrieve root slab.
+		require.Equal(t, rootID, m.SlabID())
+		require.Equal(t, address, m.Address())
+		valueID := m.ValueID()
+		require.False(t, m.Inlined())
+		require.Equal(t, segmentsReturned, baseStorage.SegmentsReturned())
+
+		// Load retrieves root slab.
+		err = m.Load()
+		require.NoError(t, err)
+		require.Equal(t, segmentsReturned+1, baseStorage.SegmentsReturned())
+
+		// Root slab is retrieved only once.
+		require.False(t, m.IsEmpty())
+		require.Equal(t, segmentsReturned+1, baseStorage.SegmentsReturned())
+
+		require.Equal(t, uint64(mapCount), m.Count())
+		require.Equal(t, segmentsReturned+1, baseStorage.SegmentsReturned())
+
+		require.Equal(t, valueID, m.ValueID())
+
+		require.Equal(t, typeInfo, m.Type())
+
+		testMap(t, storage, typeInfo, address, m, expectedValues, nil, false)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		baseStorage, rootID, expectedValues := setupBaseStorage(t)
+
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		segmentsReturned := baseStorage.SegmentsReturned()
+
+		m, err := atree.NewMapWithRootIDLazy(storage, rootID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+		require.Equal(t, segmentsReturned, baseStorage.SegmentsReturned())
+
+		for k, expected := range expectedValues {
+			v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			testValueEqual(t, expected, v)
+		}
+		require.Greater(t, baseStorage.SegmentsReturned(), segmentsReturned)
+
+		testMap(t, storage, typeInfo, address, m, expectedValues, nil, false)
+	})
+
+	t.Run("set", func(t *testing.T) {
+		baseStorage, rootID, expectedValues := setupBaseStorage(t)
+
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m, err := atree.NewMapWithRootIDLazy(storage, rootID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		k := test_utils.Uint64Value(mapCount)
+		v := test_utils.Uint64Value(mapCount)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		expectedValues[k] = v
+
+		testMap(t, storage, typeInfo, address, m, expectedValues, nil, false)
+	})
+
+	t.Run("iterate", func(t *testing.T) {
+		baseStorage, rootID, expectedValues := setupBaseStorage(t)
+
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m, err := atree.NewMapWithRootIDLazy(storage, rootID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		count := 0
+		err = m.IterateReadOnly(func(k atree.Value, v atree.Value) (bool, error) {
+			testValueEqual(t, expectedValues[k], v)
+			count++
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, mapCount, count)
+	})
+
+	t.Run("root not found", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		rootID := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
+
+		// Missing root slab isn't detected by NewMapWithRootIDLazy.
+		m, err := atree.NewMapWithRootIDLazy(storage, rootID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		// Load returns error of retrieving root slab.
+		err = m.Load()
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var slabNotFoundError *atree.SlabNotFoundError
+		require.ErrorAs(t, err, &slabNotFoundError)
+
+		// Methods without error result panic with the error instead of
+		// reporting unreadable map as an empty map.
+		requirePanicsWithSlabNotFoundError := func(f func()) {
+			defer func() {
+				r := recover()
+				require.NotNil(t, r)
+				err, ok := r.(error)
+				require.True(t, ok)
+				require.Equal(t, 1, errorCategorizationCount(err))
+				require.ErrorAs(t, err, &slabNotFoundError)
+			}()
+			f()
+		}
+
+		requirePanicsWithSlabNotFoundError(func() { m.Count() })
+		requirePanicsWithSlabNotFoundError(func() { m.Seed() })
+		requirePanicsWithSlabNotFoundError(func() { m.Type() })
+		requirePanicsWithSlabNotFoundError(func() { m.ExtraData() })
+		requirePanicsWithSlabNotFoundError(func() { m.SchemaID() })
+		requirePanicsWithSlabNotFoundError(func() { m.IsEmpty() })
+
+		// Error is returned on first use.
+		_, err = m.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.ErrorAs(t, err, &slabNotFoundError)
+	})
+}
+
+func TestStreamMapSorted(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	encodeKV := func(k, v atree.Value) ([]byte, error) {
+		data := make([]byte, 16)
+		binary.BigEndian.PutUint64(data, uint64(k.(test_utils.Uint64Value)))
+		binary.BigEndian.PutUint64(data[8:], uint64(v.(test_utils.Uint64Value)))
+		return data, nil
+	}
+
+	decodeKV := func(data []byte) (atree.Value, atree.Value, error) {
+		if len(data) != 16 {
+			return nil, nil, fmt.Errorf("unexpected record size %d", len(data))
+		}
+		k := test_utils.Uint64Value(binary.BigEndian.Uint64(data))
+		v := test_utils.Uint64Value(binary.BigEndian.Uint64(data[8:]))
+		return k, v, nil
+	}
+
+	newMap := func(t *testing.T, mapCount int) (*atree.OrderedMap, test_utils.ExpectedMapValue) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		expectedValues := make(test_utils.ExpectedMapValue)
+		for i := range uint64(mapCount) {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.Uint64Value(i * 2)
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			expectedValues[k] = v
+		}
+
+		return m, expectedValues
+	}
+
+	for _, mapCount := range []int{0, 10, 4096} {
+		t.Run(fmt.Sprintf("round trip %d", mapCount), func(t *testing.T) {
+			m, expectedValues := newMap(t, mapCount)
+
+			var buf bytes.Buffer
+			err := atree.StreamMapSorted(m, &buf, encodeKV)
+			require.NoError(t, err)
+
+			// Records are written in iteration order.
+			i := 0
+			provider := atree.ReadMapSorted(bytes.NewReader(buf.Bytes()), decodeKV)
+			err = m.IterateReadOnly(func(k atree.Value, v atree.Value) (bool, error) {
+				streamedKey, streamedValue, err := provider()
+				require.NoError(t, err)
+				testValueEqual(t, k, streamedKey)
+				testValueEqual(t, v, streamedValue)
+				i++
+				return true, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, mapCount, i)
+
+			k, v, err := provider()
+			require.NoError(t, err)
+			require.Nil(t, k)
+			require.Nil(t, v)
+
+			// Rebuild map from stream.
+			storage := newTestPersistentStorage(t)
+
+			rebuilt, err := atree.NewMapFromBatchData(
+				storage,
+				address,
+				atree.NewDefaultDigesterBuilder(),
+				typeInfo,
+				test_utils.CompareValue,
+				test_utils.GetHashInput,
+				m.Seed(),
+				atree.ReadMapSorted(&buf, decodeKV),
+			)
+			require.NoError(t, err)
+
+			testMap(t, storage, typeInfo, address, rebuilt, expectedValues, nil, false)
+		})
+	}
+
+	t.Run("encode error", func(t *testing.T) {
+		m, _ := newMap(t, 10)
+
+		testErr := errors.New("test")
+
+		var buf bytes.Buffer
+		err := atree.StreamMapSorted(m, &buf, func(atree.Value, atree.Value) ([]byte, error) {
+			return nil, testErr
+		})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.ErrorIs(t, err, testErr)
+		require.Equal(t, 0, buf.Len())
+	})
+
+	t.Run("truncated stream", func(t *testing.T) {
+		m, _ := newMap(t, 10)
+
+		var buf bytes.Buffer
+		err := atree.StreamMapSorted(m, &buf, encodeKV)
+		require.NoError(t, err)
+
+		provider := atree.ReadMapSorted(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), decodeKV)
+
+		for range 9 {
+			_, _, err := provider()
+			require.NoError(t, err)
+		}
+
+		_, _, err = provider()
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var decodingError *atree.DecodingError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &decodingError)
+	})
+
+	t.Run("record too large", func(t *testing.T) {
+		m, _ := newMap(t, 10)
+
+		var buf bytes.Buffer
+		err := atree.StreamMapSorted(m, &buf, encodeKV)
+		require.NoError(t, err)
+
+		atree.SetMaxMapStreamRecordSize(15)
+		defer atree.SetMaxMapStreamRecordSize(0)
+
+		// Record larger than max record size isn't read.
+		provider := atree.ReadMapSorted(bytes.NewReader(buf.Bytes()), decodeKV)
+
+		_, _, err = provider()
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var decodingError *atree.DecodingError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &decodingError)
+
+		// Record larger than max record size isn't written.
+		buf.Reset()
+		err = atree.StreamMapSorted(m, &buf, encodeKV)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var encodingError *atree.EncodingError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &encodingError)
+		require.Equal(t, 0, buf.Len())
+	})
+
+	t.Run("corrupted record length", func(t *testing.T) {
+		// Record length is max uint64 and stream has no record data.
+		var lengthBuf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(lengthBuf[:], math.MaxUint64)
+
+		provider := atree.ReadMapSorted(bytes.NewReader(lengthBuf[:n]), decodeKV)
+
+		_, _, err := provider()
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var decodingError *atree.DecodingError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &decodingError)
+	})
+}
+
+func TestNewMapTempAddress(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+
+	newMapFuncs := map[string]func(storage atree.SlabStorage, opts ...atree.MapOption) (*atree.OrderedMap, error){
+		"NewMap": func(storage atree.SlabStorage, opts ...atree.MapOption) (*atree.OrderedMap, error) {
+			return atree.NewMap(storage, atree.AddressUndefined, atree.NewDefaultDigesterBuilder(), typeInfo, opts...)
+		},
+		"NewMapWithSeed": func(storage atree.SlabStorage, opts ...atree.MapOption) (*atree.OrderedMap, error) {
+			return atree.NewMapWithSeed(storage, atree.AddressUndefined, atree.NewDefaultDigesterBuilder(), typeInfo, 42, opts...)
+		},
+	}
+
+	for name, newMap := range newMapFuncs {
+		t.Run(name, func(t *testing.T) {
+
+			t.Run("not allowed", func(t *testing.T) {
+				storage := newTestPersistentStorage(t)
+
+				m, err := newMap(storage)
+				require.Equal(t, 1, errorCategorizationCount(err))
+				var userError *atree.UserError
+				var tempAddressError *atree.TempAddressError
+				require.ErrorAs(t, err, &userError)
+				require.ErrorAs(t, err, &tempAddressError)
+				require.Nil(t, m)
+
+				// No slab is created.
+				require.Equal(t, uint(0), storage.Deltas())
+			})
+
+			t.Run("allowed", func(t *testing.T) {
+				storage := newTestPersistentStorage(t)
+
+				m, err := newMap(storage, atree.AllowTempAddress(), atree.WithHashInputStabilityCheck())
+				require.NoError(t, err)
+				require.True(t, m.SlabID().HasTempAddress())
+
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+
+				expectedValues := test_utils.ExpectedMapValue{test_utils.Uint64Value(0): test_utils.Uint64Value(0)}
+				testMap(t, storage, typeInfo, atree.AddressUndefined, m, expectedValues, nil, false)
+
+				// Temp slabs aren't committed.
+				err = storage.Commit()
+				require.NoError(t, err)
+				require.Equal(t, uint(1), storage.Deltas())
+				require.Equal(t, uint(0), storage.DeltasWithoutTempAddresses())
+			})
+		})
+	}
+}
+
+func TestMapCountFromRoot(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("undefined slab ID", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		count, err := atree.MapCountFromRoot(storage, atree.SlabIDUndefined)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var slabIDError *atree.SlabIDError
+		require.ErrorAs(t, err, &slabIDError)
+		require.Equal(t, uint64(0), count)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		rootID := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
+
+		count, err := atree.MapCountFromRoot(storage, rootID)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var slabNotFoundError *atree.SlabNotFoundError
+		require.ErrorAs(t, err, &slabNotFoundError)
+		require.Equal(t, uint64(0), count)
+	})
+
+	for _, mapCount := range []uint64{0, 10, 100_000} {
+		t.Run(fmt.Sprintf("%d elements", mapCount), func(t *testing.T) {
+			baseStorage := test_utils.NewInMemBaseStorage()
+
+			storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+			require.NoError(t, err)
+
+			for i := range mapCount {
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+			}
+
+			err = storage.Commit()
+			require.NoError(t, err)
+
+			if mapCount == 100_000 {
+				stats, err := atree.GetMapStats(m)
+				require.NoError(t, err)
+				require.Greater(t, stats.Levels, uint64(1))
+			}
+
+			// Get count with new storage
+			storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			segmentsReturned := baseStorage.SegmentsReturned()
+
+			count, err := atree.MapCountFromRoot(storage2, m.SlabID())
+			require.NoError(t, err)
+			require.Equal(t, mapCount, count)
+
+			// Only root slab is retrieved.
+			require.Equal(t, segmentsReturned+1, baseStorage.SegmentsReturned())
+		})
+	}
+}
@@ -46,13 +46,19 @@ func verifyMap(
 	slabIDs map[SlabID]struct{},
 ) error {
 
+	// Retrieve root slab of lazily created map
+	err := m.loadRoot()
+	if err != nil {
+		return err
+	}
+
 	// Verify map address (independent of array inlined status)
 	if address != m.Address() {
 		return NewFatalError(fmt.Errorf("map address %v, got %v", address, m.Address()))
 	}
 
 	// Verify map value ID (independent of array inlined status)
-	err := verifyMapValueID(m)
+	err = verifyMapValueID(m)
 	if err != nil {
 		return err
 	}
@@ -86,12 +92,14 @@ func verifyMap(
 	}
 
 	v := &mapVerifier{
-		storage:         m.Storage,
-		address:         address,
-		digesterBuilder: m.digesterBuilder,
-		tic:             tic,
-		hip:             hip,
-		inlineEnabled:   inlineEnabled,
+		storage:           m.Storage,
+		address:           address,
+		digesterBuilder:   m.digesterBuilder,
+		tic:               tic,
+		hip:               hip,
+		inlineEnabled:     inlineEnabled,
+		narrowDigests:     extraData.narrowDigests,
+		singleDigestLevel: extraData.singleDigestLevel,
 	}
 
 	computedCount, dataSlabIDs, nextDataSlabIDs, firstKeys, err := v.verifySlab(
@@ -140,12 +148,14 @@ func verifyMap(
 }
 
 type mapVerifier struct {
-	storage         SlabStorage
-	address         Address
-	digesterBuilder DigesterBuilder
-	tic             TypeInfoComparator
-	hip             HashInputProvider
-	inlineEnabled   bool
+	storage           SlabStorage
+	address           Address
+	digesterBuilder   DigesterBuilder
+	tic               TypeInfoComparator
+	hip               HashInputProvider
+	inlineEnabled     bool
+	narrowDigests     bool
+	singleDigestLevel bool
 }
 
 func (v *mapVerifier) verifySlab(
@@ -277,7 +287,7 @@ func (v *mapVerifier) verifyDataSlab(
 	}
 
 	// Verify that aggregated element size + slab prefix is the same as header.size
-	computedSize := uint32(mapDataSlabPrefixSize)
+	computedSize := uint32(nonRootMapDataSlabPrefixSize())
 	if level == 0 {
 		computedSize = uint32(mapRootDataSlabPrefixSize)
 		if dataSlab.Inlined() {
@@ -306,6 +316,20 @@ func (v *mapVerifier) verifyDataSlab(
 				id, dataSlab.collisionGroup))
 	}
 
+	// Verify prev slab ID.
+	// Prev slab ID is undefined if it isn't enabled, and it can be
+	// undefined for data slab encoded while it was disabled.
+	expectedPrev := SlabIDUndefined
+	if len(dataSlabIDs) > 0 {
+		expectedPrev = dataSlabIDs[len(dataSlabIDs)-1]
+	}
+
+	if dataSlab.prev != SlabIDUndefined && dataSlab.prev != expectedPrev {
+		return 0, nil, nil, nil, NewFatalError(
+			fmt.Errorf("data slab %d prev slab ID %s is wrong, want %s",
+				id, dataSlab.prev, expectedPrev))
+	}
+
 	dataSlabIDs = append(dataSlabIDs, id)
 
 	if dataSlab.next != SlabIDUndefined {
@@ -452,6 +476,14 @@ func (v *mapVerifier) verifyHkeyElements(
 				id, elements.level, digestLevel))
 	}
 
+	// Verify digest size
+	narrow := digestLevel == 0 && v.narrowDigests
+	if elements.narrow != narrow {
+		return 0, 0, NewFatalError(
+			fmt.Errorf("data slab %d elements narrow digests %t is wrong, want %t",
+				id, elements.narrow, narrow))
+	}
+
 	// Verify number of hkeys is the same as number of elements
 	if len(elements.hkeys) != len(elements.elems) {
 		return 0, 0, NewFatalError(
@@ -485,7 +517,7 @@ func (v *mapVerifier) verifyHkeyElements(
 		copy(hkeys, hkeyPrefixes)
 		hkeys[len(hkeys)-1] = elements.hkeys[i]
 
-		elementSize += digestSize
+		elementSize += elements.hkeySize()
 
 		// Verify element size is <= inline size
 		if digestLevel == 0 {
@@ -702,6 +734,8 @@ func (v *mapVerifier) verifySingleElement(
 		return 0, 0, wrapErrorfAsExternalErrorIfNeeded(err, "failed to create digester")
 	}
 
+	digest = newMapKeyDigester(digest, v.narrowDigests, v.singleDigestLevel)
+
 	computedDigests, err := digest.DigestPrefix(digest.Levels())
 	if err != nil {
 		// Wrap err as external error (if needed) because err is returned by Digester interface.
@@ -787,3 +821,67 @@ func verifyMapSlabID(m *OrderedMap) error {
 
 	return nil
 }
+
+// ValidateMapDigestOrder walks map data slabs in next pointer order and
+// verifies that digests are non-decreasing across the whole map, including
+// across data slab boundaries.  Unlike VerifyMap, it doesn't verify slab
+// size, count, or element data.  It returns SlabDataError on first violation.
+func ValidateMapDigestOrder(m *OrderedMap) error {
+	err := m.loadRoot()
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return err
+	}
+
+	dataSlab, err := firstMapDataSlab(m.Storage, m.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstMapDataSlab().
+		return err
+	}
+
+	var prevHkey Digest
+	var prevSlabID SlabID
+
+	for {
+		elements, ok := dataSlab.elements.(*hkeyElements)
+		if !ok {
+			return NewSlabDataErrorf("data slab %s elements type %T is wrong, want *hkeyElements", dataSlab.SlabID(), dataSlab.elements)
+		}
+
+		for i, hkey := range elements.hkeys {
+			if hkey >= prevHkey {
+				prevHkey = hkey
+				continue
+			}
+
+			if i == 0 {
+				return NewSlabDataErrorf(
+					"data slab %s first digest %d is less than previous data slab %s last digest %d",
+					dataSlab.SlabID(), hkey, prevSlabID, prevHkey)
+			}
+
+			return NewSlabDataErrorf(
+				"data slab %s digest %d at index %d is less than previous digest %d",
+				dataSlab.SlabID(), hkey, i, prevHkey)
+		}
+
+		if len(elements.hkeys) > 0 {
+			prevSlabID = dataSlab.SlabID()
+		}
+
+		if dataSlab.next == SlabIDUndefined {
+			return nil
+		}
+
+		slab, err := getMapSlab(m.Storage, dataSlab.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return err
+		}
+
+		dataSlab, ok = slab.(*MapDataSlab)
+		if !ok {
+			return NewSlabDataErrorf("slab %s isn't MapDataSlab", slab.SlabID())
+		}
+	}
+}
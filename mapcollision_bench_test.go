@@ -156,3 +156,93 @@ func BenchmarkCollisionPerDigest(b *testing.B) {
 		})
 	}
 }
+
+// fullCollisionDigesterBuilder returns digester with the same digests
+// at all levels for all keys, so all keys are in one collision group.
+type fullCollisionDigesterBuilder struct{}
+
+var _ atree.DigesterBuilder = fullCollisionDigesterBuilder{}
+
+func (fullCollisionDigesterBuilder) Digest(atree.HashInputProvider, atree.Value) (atree.Digester, error) {
+	return fullCollisionDigester{}, nil
+}
+
+func (fullCollisionDigesterBuilder) SetSeed(uint64, uint64) {
+}
+
+type fullCollisionDigester struct{}
+
+var _ atree.Digester = fullCollisionDigester{}
+
+func (fullCollisionDigester) Digest(level uint) (atree.Digest, error) {
+	if level >= 4 {
+		return atree.Digest(0), fmt.Errorf("invalid digest level %d", level)
+	}
+	return atree.Digest(0), nil
+}
+
+func (fullCollisionDigester) DigestPrefix(level uint) ([]atree.Digest, error) {
+	return make([]atree.Digest, level), nil
+}
+
+func (fullCollisionDigester) Levels() uint {
+	return 4
+}
+
+func (fullCollisionDigester) Reset() {
+}
+
+// BenchmarkMapSetFullCollisionGroup benchmarks inserting keys colliding
+// at all digest levels, so each insert finds its position in a large
+// collision group ordered by encoded key.
+func BenchmarkMapSetFullCollisionGroup(b *testing.B) {
+
+	savedMaxCollisionLimitPerDigest := atree.MaxCollisionLimitPerDigest
+	defer func() {
+		atree.MaxCollisionLimitPerDigest = savedMaxCollisionLimitPerDigest
+	}()
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	benchmarks := []struct {
+		name    string
+		keySize int
+	}{
+		{"inlined key", 16},
+		{"external key", 2048},
+	}
+
+	for _, mapCount := range []int{100, 1_000} {
+		for _, bm := range benchmarks {
+			name := fmt.Sprintf("%d elements %s", mapCount, bm.name)
+
+			b.Run(name, func(b *testing.B) {
+				atree.MaxCollisionLimitPerDigest = uint32(mapCount)
+
+				keys := make([]atree.Value, mapCount)
+				for i := range keys {
+					keys[i] = test_utils.NewStringValue(fmt.Sprintf("%0*d", bm.keySize, i))
+				}
+
+				b.ReportAllocs()
+
+				for range b.N {
+					b.StopTimer()
+
+					storage := newTestPersistentStorage(b)
+
+					m, err := atree.NewMap(storage, address, fullCollisionDigesterBuilder{}, typeInfo)
+					require.NoError(b, err)
+
+					b.StartTimer()
+
+					for _, k := range keys {
+						_, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, test_utils.Uint64Value(0))
+						require.NoError(b, err)
+					}
+				}
+			})
+		}
+	}
+}
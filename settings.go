@@ -18,7 +18,10 @@
 
 package atree
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+)
 
 // Slab invariants:
 // - each element can't take up more than half of slab size (including encoding overhead and digest)
@@ -37,8 +40,78 @@ var (
 	maxInlineArrayElementSize uint64
 	maxInlineMapElementSize   uint64
 	maxInlineMapKeySize       uint64
+
+	// minArrayMetaDataSlabFanout and minMapMetaDataSlabFanout are min
+	// number of children in non-root array and map metadata slabs.
+	minArrayMetaDataSlabFanout int
+	minMapMetaDataSlabFanout   int
+
+	// minMetaDataSlabFanoutSetting is min number of children in non-root
+	// metadata slabs set by SetMinMetaDataSlabFanout.  It is 0 if min
+	// number of children is only derived from slab size threshold.
+	minMetaDataSlabFanoutSetting int
+
+	// externalValueThreshold is max inline size of array element and
+	// map value set by SetExternalValueThreshold.  It is 0 if
+	// max inline size is only derived from slab size threshold.
+	externalValueThreshold uint64
+
+	// maxMapElementCount is max number of elements in a map.
+	maxMapElementCount = DefaultMaxMapElementCount
+
+	// maxMapStreamRecordSize is max size of map record written by
+	// StreamMapSorted and read by ReadMapSorted.
+	maxMapStreamRecordSize = DefaultMaxMapStreamRecordSize
+
+	// rebalanceHysteresis is fraction by which minThreshold (underflow)
+	// is lowered, set by SetRebalanceHysteresis.  It is 0 by default.
+	rebalanceHysteresis float64
+
+	// maxInlineCollisionGroupSize is max size of inline collision group
+	// set by SetMaxInlineCollisionGroupSize.  It is 0 if max size is
+	// maxInlineMapElementSize.
+	maxInlineCollisionGroupSize uint64
+
+	// maxValueSize is max size of array element and map value set by
+	// SetMaxValueSize.  It is 0 if value size isn't limited.
+	maxValueSize uint64
+
+	// maxValueSizeMode specifies how value larger than maxValueSize is handled.
+	maxValueSizeMode MaxValueSizeMode
+
+	// mapDataSlabPrevSlabIDEnabled is true if non-root map data slabs
+	// store prev slab ID, set by SetMapDataSlabPrevSlabIDEnabled.
+	mapDataSlabPrevSlabIDEnabled bool
 )
 
+// MaxValueSizeMode specifies how array element and map value larger
+// than max value size (see SetMaxValueSize) are handled.
+type MaxValueSizeMode uint8
+
+const (
+	// MaxValueSizeModeError rejects value larger than max value size
+	// with MaxValueSizeError.
+	MaxValueSizeModeError MaxValueSizeMode = iota
+
+	// MaxValueSizeModeForceExternal stores value larger than max value
+	// size externally in its own slab.
+	MaxValueSizeModeForceExternal
+)
+
+// DefaultMaxMapElementCount is the default max number of elements in a map.
+// OrderedMap.Set returns MaxMapSizeError if inserting a new element
+// into a map with max number of elements.
+const DefaultMaxMapElementCount = uint64(math.MaxUint64)
+
+// DefaultMaxMapStreamRecordSize is the default max size of map record
+// written by StreamMapSorted and read by ReadMapSorted.
+const DefaultMaxMapStreamRecordSize = uint64(64 << 20)
+
+// minExternalValueThreshold is the smallest external value threshold.
+// Values stored externally are referenced by SlabIDStorable, so
+// the threshold must be large enough to inline SlabIDStorable.
+const minExternalValueThreshold = uint64(2 + 1 + SlabIDLength)
+
 func init() {
 	SetThreshold(defaultSlabSize)
 }
@@ -49,15 +122,17 @@ func SetThreshold(threshold uint64) (uint64, uint64, uint64, uint64) {
 	}
 
 	targetThreshold = threshold
-	minThreshold = targetThreshold / 2
-	maxThreshold = uint64(float64(targetThreshold) * 1.5)
+	minThreshold, maxThreshold = slabSizeBounds(targetThreshold)
 
 	// Total slab size available for array elements, excluding slab encoding overhead
 	availableArrayElementsSize := targetThreshold - arrayDataSlabPrefixSize
 	maxInlineArrayElementSize = availableArrayElementsSize / minElementCountInSlab
+	if limit := externalValueSizeLimit(); limit > 0 {
+		maxInlineArrayElementSize = min(maxInlineArrayElementSize, limit)
+	}
 
 	// Total slab size available for map elements, excluding slab encoding overhead
-	availableMapElementsSize := targetThreshold - mapDataSlabPrefixSize - hkeyElementsPrefixSize
+	availableMapElementsSize := targetThreshold - uint64(nonRootMapDataSlabPrefixSize()) - hkeyElementsPrefixSize
 
 	// Total encoding overhead for one map element (key+value)
 	mapElementOverheadSize := uint64(digestSize)
@@ -68,9 +143,251 @@ func SetThreshold(threshold uint64) (uint64, uint64, uint64, uint64) {
 	// Max inline size for a map's key, excluding element overhead
 	maxInlineMapKeySize = (maxInlineMapElementSize - singleElementPrefixSize) / 2
 
+	// Min number of child headers in non-root metadata slab
+	minArrayMetaDataSlabFanout, _ = metaDataSlabFanout(minThreshold, maxThreshold, arrayMetaDataSlabPrefixSize, arraySlabHeaderSize)
+	minMapMetaDataSlabFanout, _ = metaDataSlabFanout(minThreshold, maxThreshold, mapMetaDataSlabPrefixSize, mapSlabHeaderSize)
+
 	return minThreshold, maxThreshold, maxInlineArrayElementSize, maxInlineMapKeySize
 }
 
+// slabSizeBounds returns min (underflow) and max (full) slab size for
+// given slab size threshold.  Min size is lowered by rebalance hysteresis.
+func slabSizeBounds(threshold uint64) (minSize, maxSize uint64) {
+	minSize = threshold / 2
+	if rebalanceHysteresis > 0 {
+		minSize = uint64(float64(minSize) * (1 - rebalanceHysteresis))
+	}
+	maxSize = uint64(float64(threshold) * 1.5)
+	return minSize, maxSize
+}
+
+// SetExternalValueThreshold sets max inline size of array element and map value,
+// independent of slab size threshold.  Array element and map value larger than
+// threshold are stored externally in their own slabs.  Threshold 0 resets
+// max inline size to be derived from slab size threshold.
+// Threshold larger than max inline size derived from slab size threshold
+// has no effect because each data slab must hold at least 2 elements.
+func SetExternalValueThreshold(threshold uint64) {
+	if threshold > 0 && threshold < minExternalValueThreshold {
+		panic(fmt.Sprintf("External value threshold %d is smaller than minExternalValueThreshold %d", threshold, minExternalValueThreshold))
+	}
+
+	externalValueThreshold = threshold
+
+	// Recompute max inline sizes
+	SetThreshold(targetThreshold)
+}
+
+// SetMaxValueSize sets max size of array element and map value, and mode
+// specifying how larger value is handled.  Size 0 removes the limit (default).
+//
+// In MaxValueSizeModeError mode, Array and OrderedMap operations adding or
+// updating a value return MaxValueSizeError if value is larger than size.
+// Value stored externally because it is larger than max inline size (derived
+// from slab size or external value threshold) is checked by the size of its
+// storable in external slab, so external storage doesn't bypass the limit.
+// Array and map values are checked when they are added, but not when they
+// grow afterwards.  Array and map values stored in their own slabs aren't checked.
+//
+// In MaxValueSizeModeForceExternal mode, value larger than size is stored
+// externally in its own slab, the same way as external value threshold
+// (see SetExternalValueThreshold).  If both are set, the smaller one is used.
+// Size must be at least minExternalValueThreshold in this mode.
+func SetMaxValueSize(size uint64, mode MaxValueSizeMode) {
+	switch mode {
+	case MaxValueSizeModeError:
+	case MaxValueSizeModeForceExternal:
+		if size > 0 && size < minExternalValueThreshold {
+			panic(fmt.Sprintf("Max value size %d is smaller than minExternalValueThreshold %d", size, minExternalValueThreshold))
+		}
+	default:
+		panic(fmt.Sprintf("Max value size mode %d is invalid", mode))
+	}
+
+	maxValueSize = size
+	maxValueSizeMode = mode
+
+	// Recompute max inline sizes
+	SetThreshold(targetThreshold)
+}
+
+// MaxValueSize returns max size of array element and map value, and
+// mode specifying how larger value is handled.  Size 0 means no limit.
+func MaxValueSize() (uint64, MaxValueSizeMode) {
+	return maxValueSize, maxValueSizeMode
+}
+
+// SetRebalanceHysteresis lowers underflow (merge) threshold of slabs by
+// gapFraction, which widens the gap between merge and split thresholds.
+// Without hysteresis, a slab with size close to underflow threshold can be
+// rebalanced or merged with its sibling repeatedly under alternating insert
+// and remove, rewriting sibling and parent slabs each time.
+// gapFraction must be in [0, 1), and 0 disables hysteresis (default).
+func SetRebalanceHysteresis(gapFraction float64) {
+	if !(gapFraction >= 0 && gapFraction < 1) {
+		panic(fmt.Sprintf("Rebalance hysteresis %f must be in [0, 1)", gapFraction))
+	}
+
+	rebalanceHysteresis = gapFraction
+
+	// Recompute thresholds
+	SetThreshold(targetThreshold)
+}
+
+// SetMaxInlineCollisionGroupSize sets max size of first level inline
+// collision group.  Inline collision group larger than size is moved to
+// its own slab (external collision group) when it is modified.
+// Smaller size keeps data slabs small, and larger size creates fewer
+// collision group slabs.  Size 0 resets max size to MaxInlineMapElementSize.
+// Size larger than MaxInlineMapElementSize has no effect because
+// each data slab must hold at least 2 elements.
+func SetMaxInlineCollisionGroupSize(size uint64) {
+	maxInlineCollisionGroupSize = size
+}
+
+// MaxInlineCollisionGroupSize returns max size of first level inline collision group.
+func MaxInlineCollisionGroupSize() uint64 {
+	if maxInlineCollisionGroupSize > 0 {
+		return min(maxInlineCollisionGroupSize, maxInlineMapElementSize)
+	}
+	return maxInlineMapElementSize
+}
+
+// SetMapDataSlabPrevSlabIDEnabled enables storing prev slab ID in
+// non-root map data slabs, so data slabs are linked in both directions.
+// Prev slab ID is encoded as optional field (guarded by a flag) and
+// it is included in data slab size, so enabling it reduces space for
+// map elements.  It is disabled by default, so encoded data slabs
+// don't change.  Maintaining prev slab ID requires updating next data
+// slab when data slab is split or merged.  OrderedMap.IterateReadOnlyReverse
+// uses prev slab ID to step to previous data slab.
+//
+// Like SetThreshold, it must be set before maps are created or loaded,
+// and it must not be changed for existing data.  Data slabs encoded
+// while it is disabled don't have prev slab ID.
+func SetMapDataSlabPrevSlabIDEnabled(enabled bool) {
+	mapDataSlabPrevSlabIDEnabled = enabled
+
+	// Recompute max inline sizes
+	SetThreshold(targetThreshold)
+}
+
+// MapDataSlabPrevSlabIDEnabled returns true if non-root map data slabs
+// store prev slab ID.
+func MapDataSlabPrevSlabIDEnabled() bool {
+	return mapDataSlabPrevSlabIDEnabled
+}
+
+// nonRootMapDataSlabPrefixSize returns encoded prefix size of
+// non-root map data slab, including prev slab ID if it is enabled.
+func nonRootMapDataSlabPrefixSize() uint32 {
+	if mapDataSlabPrevSlabIDEnabled {
+		return mapDataSlabPrefixSize + SlabIDLength
+	}
+	return mapDataSlabPrefixSize
+}
+
+// SetMaxMapElementCount sets max number of elements in a map.
+// Count 0 resets max number of elements to DefaultMaxMapElementCount.
+func SetMaxMapElementCount(count uint64) {
+	if count == 0 {
+		count = DefaultMaxMapElementCount
+	}
+	maxMapElementCount = count
+}
+
+// MaxMapElementCount returns max number of elements in a map.
+func MaxMapElementCount() uint64 {
+	return maxMapElementCount
+}
+
+// SetMaxMapStreamRecordSize sets max size of map record written by
+// StreamMapSorted and read by ReadMapSorted.  ReadMapSorted returns
+// DecodingError for record length larger than size before allocating
+// record buffer.  Size 0 resets max size to DefaultMaxMapStreamRecordSize.
+func SetMaxMapStreamRecordSize(size uint64) {
+	if size == 0 {
+		size = DefaultMaxMapStreamRecordSize
+	}
+	maxMapStreamRecordSize = size
+}
+
+// MaxMapStreamRecordSize returns max size of map record written by
+// StreamMapSorted and read by ReadMapSorted.
+func MaxMapStreamRecordSize() uint64 {
+	return maxMapStreamRecordSize
+}
+
+// SetMinMetaDataSlabFanout sets min number of children in non-root
+// metadata slabs, so metadata slabs don't become too sparse after heavy
+// removals.  Non-root metadata slab with fewer children is underflow even
+// if its size isn't, and it is merged with (or rebalanced by) a sibling.
+// Min number of children derived from slab size threshold is used if it is
+// larger, and min number larger than half of max number of children is
+// capped, so both slabs split from a full metadata slab have enough
+// children.  Fanout 0 resets min number of children to be derived from
+// slab size threshold (default).
+func SetMinMetaDataSlabFanout(fanout int) {
+	if fanout < 0 {
+		panic(fmt.Sprintf("Min metadata slab fanout %d is negative", fanout))
+	}
+
+	minMetaDataSlabFanoutSetting = fanout
+
+	// Recompute min fanout
+	SetThreshold(targetThreshold)
+}
+
+// ArrayMetaDataSlabFanout returns min and max number of children in
+// non-root array metadata slab for given slab size threshold, with
+// current rebalance hysteresis and min metadata slab fanout settings.
+// Like SetThreshold, it panics if threshold is smaller than minSlabSize.
+func ArrayMetaDataSlabFanout(threshold uint64) (minFanout, maxFanout int) {
+	if threshold < minSlabSize {
+		panic(fmt.Sprintf("Slab size %d is smaller than minSlabSize %d", threshold, minSlabSize))
+	}
+	minSize, maxSize := slabSizeBounds(threshold)
+	return metaDataSlabFanout(minSize, maxSize, arrayMetaDataSlabPrefixSize, arraySlabHeaderSize)
+}
+
+// MapMetaDataSlabFanout returns min and max number of children in
+// non-root map metadata slab for given slab size threshold, with
+// current rebalance hysteresis and min metadata slab fanout settings.
+// Like SetThreshold, it panics if threshold is smaller than minSlabSize.
+func MapMetaDataSlabFanout(threshold uint64) (minFanout, maxFanout int) {
+	if threshold < minSlabSize {
+		panic(fmt.Sprintf("Slab size %d is smaller than minSlabSize %d", threshold, minSlabSize))
+	}
+	minSize, maxSize := slabSizeBounds(threshold)
+	return metaDataSlabFanout(minSize, maxSize, mapMetaDataSlabPrefixSize, mapSlabHeaderSize)
+}
+
+// MetaDataSlabFanout returns min and max number of children in non-root
+// metadata slab for given slab size threshold, which hold for both array
+// and map metadata slabs (see ArrayMetaDataSlabFanout and
+// MapMetaDataSlabFanout for each kind).
+// Like SetThreshold, it panics if threshold is smaller than minSlabSize.
+func MetaDataSlabFanout(threshold uint64) (minFanout, maxFanout int) {
+	arrayMin, arrayMax := ArrayMetaDataSlabFanout(threshold)
+	mapMin, mapMax := MapMetaDataSlabFanout(threshold)
+	return min(arrayMin, mapMin), max(arrayMax, mapMax)
+}
+
+// metaDataSlabFanout returns min and max number of child headers of
+// headerSize in non-root metadata slab with size in [minSize, maxSize].
+func metaDataSlabFanout(minSize, maxSize, prefixSize, headerSize uint64) (minFanout, maxFanout int) {
+	if minSize > prefixSize {
+		minFanout = int((minSize - prefixSize + headerSize - 1) / headerSize)
+	}
+	maxFanout = int((maxSize - prefixSize) / headerSize)
+
+	// Min fanout set by SetMinMetaDataSlabFanout is capped at half of
+	// max fanout, so splitting full metadata slab doesn't cause underflow.
+	minFanout = max(minFanout, min(minMetaDataSlabFanoutSetting, maxFanout/2))
+
+	return minFanout, maxFanout
+}
+
 func MaxInlineArrayElementSize() uint64 {
 	return maxInlineArrayElementSize
 }
@@ -84,7 +401,25 @@ func MaxInlineMapKeySize() uint64 {
 }
 
 func maxInlineMapValueSize(keySize uint64) uint64 {
-	return maxInlineMapElementSize - keySize - singleElementPrefixSize
+	size := maxInlineMapElementSize - keySize - singleElementPrefixSize
+	if limit := externalValueSizeLimit(); limit > 0 {
+		return min(size, limit)
+	}
+	return size
+}
+
+// externalValueSizeLimit returns size above which array element and map value
+// are stored externally, independent of slab size threshold.  It is the smaller
+// of external value threshold and max value size in MaxValueSizeModeForceExternal
+// mode.  It returns 0 if neither is set.
+func externalValueSizeLimit() uint64 {
+	limit := externalValueThreshold
+	if maxValueSize > 0 && maxValueSizeMode == MaxValueSizeModeForceExternal {
+		if limit == 0 || maxValueSize < limit {
+			limit = maxValueSize
+		}
+	}
+	return limit
 }
 
 func targetSlabSize() uint64 {
@@ -0,0 +1,224 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"fmt"
+	"math"
+)
+
+// Size histograms count elements by encoded byte size for capacity planning.
+//
+// buckets are upper bounds (inclusive) of element sizes in strictly
+// increasing order.  Returned histogram maps each bucket to number of
+// elements with size greater than previous bucket and less than or equal
+// to the bucket.  Elements larger than the last bucket are counted
+// under math.MaxUint64.
+//
+// Size of an element is its storable's ByteSize(), or byte size of
+// the referenced slab if the element is stored in a separate slab.
+
+// ArrayElementSizeHistogram returns histogram of array element sizes.
+func ArrayElementSizeHistogram(a *Array, buckets []uint64) (map[uint64]uint64, error) {
+	h, err := newSizeHistogram(buckets)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by newSizeHistogram().
+		return nil, err
+	}
+
+	dataSlab, err := firstArrayDataSlab(a.Storage, a.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstArrayDataSlab().
+		return nil, err
+	}
+
+	for {
+		for _, e := range dataSlab.elements {
+			err = h.add(a.Storage, e)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by sizeHistogram.add().
+				return nil, err
+			}
+		}
+
+		if dataSlab.next == SlabIDUndefined {
+			return h.counts, nil
+		}
+
+		slab, err := getArraySlab(a.Storage, dataSlab.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getArraySlab().
+			return nil, err
+		}
+
+		var ok bool
+		dataSlab, ok = slab.(*ArrayDataSlab)
+		if !ok {
+			return nil, NewSlabDataErrorf("slab %s isn't ArrayDataSlab", slab.SlabID())
+		}
+	}
+}
+
+// MapKeySizeHistogram returns histogram of map key sizes.
+func MapKeySizeHistogram(m *OrderedMap, buckets []uint64) (map[uint64]uint64, error) {
+	// Don't need to wrap error as external error because err is already categorized by mapSizeHistogram().
+	return mapSizeHistogram(m, buckets, true)
+}
+
+// MapValueSizeHistogram returns histogram of map value sizes.
+func MapValueSizeHistogram(m *OrderedMap, buckets []uint64) (map[uint64]uint64, error) {
+	// Don't need to wrap error as external error because err is already categorized by mapSizeHistogram().
+	return mapSizeHistogram(m, buckets, false)
+}
+
+func mapSizeHistogram(m *OrderedMap, buckets []uint64, key bool) (map[uint64]uint64, error) {
+	h, err := newSizeHistogram(buckets)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by newSizeHistogram().
+		return nil, err
+	}
+
+	if err := m.loadRoot(); err != nil {
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.loadRoot().
+		return nil, err
+	}
+
+	dataSlab, err := firstMapDataSlab(m.Storage, m.root)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by firstMapDataSlab().
+		return nil, err
+	}
+
+	for {
+		err = addMapElementsToSizeHistogram(m.Storage, h, dataSlab.elements, key)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by addMapElementsToSizeHistogram().
+			return nil, err
+		}
+
+		if dataSlab.next == SlabIDUndefined {
+			return h.counts, nil
+		}
+
+		slab, err := getMapSlab(m.Storage, dataSlab.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getMapSlab().
+			return nil, err
+		}
+
+		var ok bool
+		dataSlab, ok = slab.(*MapDataSlab)
+		if !ok {
+			return nil, NewSlabDataErrorf("slab %s isn't MapDataSlab", slab.SlabID())
+		}
+	}
+}
+
+func addMapElementsToSizeHistogram(storage SlabStorage, h *sizeHistogram, elems elements, key bool) error {
+	for i := range elems.Count() {
+		elem, err := elems.Element(int(i))
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by elements.Element().
+			return err
+		}
+
+		switch e := elem.(type) {
+		case *singleElement:
+			s := e.value
+			if key {
+				s = e.key
+			}
+
+			err = h.add(storage, s)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by sizeHistogram.add().
+				return err
+			}
+
+		case elementGroup:
+			nested, err := e.Elements(storage)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by elementGroup.Elements().
+				return err
+			}
+
+			err = addMapElementsToSizeHistogram(storage, h, nested, key)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by addMapElementsToSizeHistogram().
+				return err
+			}
+
+		default:
+			return NewUnreachableError()
+		}
+	}
+
+	return nil
+}
+
+type sizeHistogram struct {
+	buckets []uint64
+	counts  map[uint64]uint64
+}
+
+func newSizeHistogram(buckets []uint64) (*sizeHistogram, error) {
+	if len(buckets) == 0 {
+		return nil, NewUserError(fmt.Errorf("size histogram buckets can't be empty"))
+	}
+
+	counts := make(map[uint64]uint64, len(buckets)+1)
+
+	for i, b := range buckets {
+		if i > 0 && b <= buckets[i-1] {
+			return nil, NewUserError(fmt.Errorf("size histogram buckets must be strictly increasing: bucket %d at index %d <= bucket %d at index %d", b, i, buckets[i-1], i-1))
+		}
+		counts[b] = 0
+	}
+
+	return &sizeHistogram{
+		buckets: buckets,
+		counts:  counts,
+	}, nil
+}
+
+func (h *sizeHistogram) add(storage SlabStorage, storable Storable) error {
+	size := uint64(storable.ByteSize())
+
+	if id, ok := storable.(SlabIDStorable); ok {
+		slab, found, err := storage.Retrieve(SlabID(id))
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+		}
+		if !found {
+			return NewSlabNotFoundErrorf(SlabID(id), "failed to retrieve slab")
+		}
+		size = uint64(slab.ByteSize())
+	}
+
+	for _, b := range h.buckets {
+		if size <= b {
+			h.counts[b]++
+			return nil
+		}
+	}
+
+	h.counts[math.MaxUint64]++
+	return nil
+}
@@ -0,0 +1,93 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import "fmt"
+
+// SlabIterationFunc is called by IterateSlabs for each visited slab.
+// Iteration stops if it returns false or an error.
+type SlabIterationFunc func(id SlabID, slab Slab) (resume bool, err error)
+
+// IterateSlabs calls fn for every slab in the subtree with root slab rootID,
+// including metadata slabs, data slabs, external collision group slabs,
+// slabs of values stored externally, and slabs of nested containers.
+// Slabs are visited in depth-first pre-order, and child slabs are visited in
+// the order they are referenced by their parent slab.  So visiting order only
+// depends on slab layout, not on logical element order.
+// Each slab is visited at most once.
+func IterateSlabs(storage SlabStorage, rootID SlabID, fn SlabIterationFunc) error {
+	visited := make(map[SlabID]struct{})
+
+	stack := []SlabID{rootID}
+
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if _, ok := visited[id]; ok {
+			continue
+		}
+		visited[id] = struct{}{}
+
+		slab, found, err := storage.Retrieve(id)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+		}
+		if !found {
+			return NewSlabNotFoundErrorf(id, "slab not found during slab iteration")
+		}
+
+		resume, err := fn(id, slab)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabIterationFunc callback.
+			return wrapErrorfAsExternalErrorIfNeeded(err, "failed to iterate slabs")
+		}
+		if !resume {
+			return nil
+		}
+
+		var childIDs []SlabID
+		for _, childStorable := range slab.ChildStorables() {
+			childIDs = appendReferencedSlabIDs(childStorable, childIDs)
+		}
+
+		// Push child slab IDs in reverse order so that
+		// first referenced child slab is visited first.
+		for i := len(childIDs) - 1; i >= 0; i-- {
+			stack = append(stack, childIDs[i])
+		}
+	}
+
+	return nil
+}
+
+// appendReferencedSlabIDs appends IDs of slabs referenced by storable,
+// including slabs referenced by inlined storables, in reference order.
+func appendReferencedSlabIDs(storable Storable, ids []SlabID) []SlabID {
+	if sid, ok := storable.(SlabIDStorable); ok {
+		return append(ids, SlabID(sid))
+	}
+
+	for _, childStorable := range storable.ChildStorables() {
+		ids = appendReferencedSlabIDs(childStorable, ids)
+	}
+
+	return ids
+}
@@ -0,0 +1,62 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import "fmt"
+
+// Slab size estimates use the same size model as slab split and merge,
+// so callers can predict whether inserting elements splits a slab
+// by comparing estimated size with max threshold returned by SetThreshold().
+//
+// The size model always includes next slab ID, so encoded size of the
+// last data slab is SlabIDLength bytes smaller than estimated size.
+// Prev slab ID of map data slab isn't included in the size model.
+
+// EstimateArrayDataSlabSize returns encoded size of non-root array
+// data slab containing elements.
+func EstimateArrayDataSlabSize(elements []Storable) uint32 {
+	size := uint32(arrayDataSlabPrefixSize)
+	for _, e := range elements {
+		size += e.ByteSize()
+	}
+	return size
+}
+
+// EstimateMapDataSlabSize returns encoded size of non-root map data
+// slab containing elements with hkeys, keys, and values, where element i
+// has digest hkeys[i], key keys[i], and value values[i].  hkeys must be
+// distinct because colliding elements are stored in collision groups
+// with additional overhead.
+func EstimateMapDataSlabSize(hkeys []Digest, keys []Storable, values []Storable) (uint32, error) {
+	if len(hkeys) != len(keys) || len(hkeys) != len(values) {
+		return 0, NewUserError(
+			fmt.Errorf(
+				"failed to estimate map data slab size: got %d hkeys, %d keys, and %d values",
+				len(hkeys),
+				len(keys),
+				len(values),
+			))
+	}
+
+	size := uint32(nonRootMapDataSlabPrefixSize() + hkeyElementsPrefixSize)
+	for i := range hkeys {
+		size += digestSize + singleElementPrefixSize + keys[i].ByteSize() + values[i].ByteSize()
+	}
+	return size, nil
+}
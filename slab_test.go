@@ -19,11 +19,14 @@
 package atree_test
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/onflow/atree"
+	"github.com/onflow/atree/test_utils"
 )
 
 func TestIsRootOfAnObject(t *testing.T) {
@@ -187,3 +190,69 @@ func TestHasSizeLimit(t *testing.T) {
 		require.ErrorAs(t, fatalError, &decodingError)
 	})
 }
+
+func TestSlabString(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	storage := newTestPersistentStorage(t)
+
+	array, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+
+	for i := range uint64(1000) {
+		err = array.Append(test_utils.Uint64Value(i))
+		require.NoError(t, err)
+	}
+
+	// Append value stored in StorableSlab.
+	err = array.Append(test_utils.NewStringValue(strings.Repeat("a", 2048)))
+	require.NoError(t, err)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	for i := range uint64(1000) {
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+	}
+
+	slabTypes := make(map[string]struct{})
+
+	for _, rootID := range []atree.SlabID{array.SlabID(), m.SlabID()} {
+		err = atree.IterateSlabs(storage, rootID, func(id atree.SlabID, slab atree.Slab) (bool, error) {
+			typeName := reflect.TypeOf(slab).Elem().Name()
+			slabTypes[typeName] = struct{}{}
+
+			s := slab.String()
+			require.NotEmpty(t, s)
+			require.True(t, strings.HasPrefix(s, typeName+" id:"+id.String()+" "), s)
+			require.Contains(t, s, " size:")
+
+			switch slab.(type) {
+			case *atree.ArrayDataSlab, *atree.MapDataSlab:
+				require.Contains(t, s, " count:")
+				require.Contains(t, s, " next:")
+			case *atree.ArrayMetaDataSlab, *atree.MapMetaDataSlab:
+				require.Contains(t, s, " children:")
+			}
+
+			return true, nil
+		})
+		require.NoError(t, err)
+	}
+
+	require.Equal(
+		t,
+		map[string]struct{}{
+			"ArrayDataSlab":     {},
+			"ArrayMetaDataSlab": {},
+			"MapDataSlab":       {},
+			"MapMetaDataSlab":   {},
+			"StorableSlab":      {},
+		},
+		slabTypes,
+	)
+}
@@ -0,0 +1,88 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"fmt"
+	"slices"
+)
+
+// StorableRegistry maps application CBOR tag numbers to decoders of tagged storables.
+// It detects CBOR tag collisions at registration, so two storable types can't
+// accidentally claim the same tag and be silently decoded as each other.
+//
+// Application StorableDecoder can use Decoder after decoding tag number
+// to dispatch to registered decoder.
+type StorableRegistry struct {
+	decoders map[uint64]StorableDecoder
+}
+
+func NewStorableRegistry() *StorableRegistry {
+	return &StorableRegistry{
+		decoders: make(map[uint64]StorableDecoder),
+	}
+}
+
+// Register registers decoder for storables encoded with given CBOR tag number.
+// Registered decoder is called after tag number is decoded.
+// It returns error if tag number is already registered or reserved by atree.
+func (r *StorableRegistry) Register(tagNum uint64, decoder StorableDecoder) error {
+	if decoder == nil {
+		return NewUserError(fmt.Errorf("failed to register CBOR tag number %d: decoder is nil", tagNum))
+	}
+
+	available, err := IsCBORTagNumberRangeAvailable(tagNum, tagNum)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by IsCBORTagNumberRangeAvailable().
+		return err
+	}
+	if !available {
+		minTagNum, maxTagNum := ReservedCBORTagNumberRange()
+		return NewUserError(
+			fmt.Errorf(
+				"failed to register CBOR tag number %d: tag numbers [%d, %d] are reserved by atree",
+				tagNum,
+				minTagNum,
+				maxTagNum))
+	}
+
+	if _, exists := r.decoders[tagNum]; exists {
+		return NewDuplicateCBORTagError(tagNum)
+	}
+
+	r.decoders[tagNum] = decoder
+
+	return nil
+}
+
+// Decoder returns decoder registered for given CBOR tag number.
+func (r *StorableRegistry) Decoder(tagNum uint64) (StorableDecoder, bool) {
+	decoder, exists := r.decoders[tagNum]
+	return decoder, exists
+}
+
+// RegisteredTags returns registered CBOR tag numbers in ascending order.
+func (r *StorableRegistry) RegisteredTags() []uint64 {
+	tags := make([]uint64, 0, len(r.decoders))
+	for tagNum := range r.decoders {
+		tags = append(tags, tagNum)
+	}
+	slices.Sort(tags)
+	return tags
+}
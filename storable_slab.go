@@ -59,7 +59,7 @@ func NewStorableSlab(storage SlabStorage, address Address, storable Storable) (S
 }
 
 func (s *StorableSlab) String() string {
-	return fmt.Sprintf("StorableSlab id:%s storable:%s", s.slabID, s.storable)
+	return fmt.Sprintf("StorableSlab id:%s size:%d storable:%s", s.slabID, s.ByteSize(), s.storable)
 }
 
 func (s *StorableSlab) ChildStorables() []Storable {
@@ -19,11 +19,18 @@
 package atree_test
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/stretchr/testify/require"
 
 	"github.com/onflow/atree"
+	"github.com/onflow/atree/test_utils"
 )
 
 func TestIsCBORTagNumberRangeAvailable(t *testing.T) {
@@ -79,3 +86,461 @@ func TestIsCBORTagNumberRangeAvailable(t *testing.T) {
 		require.True(t, available)
 	})
 }
+
+func TestStorableRegistry(t *testing.T) {
+
+	newDecoder := func(storable atree.Storable) atree.StorableDecoder {
+		return func(*cbor.StreamDecoder, atree.SlabID, []atree.ExtraData) (atree.Storable, error) {
+			return storable, nil
+		}
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		registry := atree.NewStorableRegistry()
+		require.Equal(t, 0, len(registry.RegisteredTags()))
+
+		decoder, exists := registry.Decoder(161)
+		require.False(t, exists)
+		require.Nil(t, decoder)
+	})
+
+	t.Run("register", func(t *testing.T) {
+		registry := atree.NewStorableRegistry()
+
+		err := registry.Register(163, newDecoder(test_utils.Uint64Value(163)))
+		require.NoError(t, err)
+
+		err = registry.Register(161, newDecoder(test_utils.Uint64Value(161)))
+		require.NoError(t, err)
+
+		err = registry.Register(162, newDecoder(test_utils.Uint64Value(162)))
+		require.NoError(t, err)
+
+		require.Equal(t, []uint64{161, 162, 163}, registry.RegisteredTags())
+
+		for _, tagNum := range registry.RegisteredTags() {
+			decoder, exists := registry.Decoder(tagNum)
+			require.True(t, exists)
+
+			storable, err := decoder(nil, atree.SlabIDUndefined, nil)
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(tagNum), storable)
+		}
+	})
+
+	t.Run("duplicate tag", func(t *testing.T) {
+		registry := atree.NewStorableRegistry()
+
+		err := registry.Register(161, newDecoder(test_utils.Uint64Value(1)))
+		require.NoError(t, err)
+
+		err = registry.Register(161, newDecoder(test_utils.Uint64Value(2)))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		var duplicateCBORTagError *atree.DuplicateCBORTagError
+		require.ErrorAs(t, err, &userError)
+		require.ErrorAs(t, err, &duplicateCBORTagError)
+
+		// First registered decoder is kept.
+		require.Equal(t, []uint64{161}, registry.RegisteredTags())
+
+		decoder, exists := registry.Decoder(161)
+		require.True(t, exists)
+
+		storable, err := decoder(nil, atree.SlabIDUndefined, nil)
+		require.NoError(t, err)
+		require.Equal(t, test_utils.Uint64Value(1), storable)
+	})
+
+	t.Run("reserved tag", func(t *testing.T) {
+		minTagNum, maxTagNum := atree.ReservedCBORTagNumberRange()
+
+		registry := atree.NewStorableRegistry()
+
+		for _, tagNum := range []uint64{minTagNum, atree.CBORTagSlabID, maxTagNum} {
+			err := registry.Register(tagNum, newDecoder(test_utils.Uint64Value(0)))
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var userError *atree.UserError
+			require.ErrorAs(t, err, &userError)
+		}
+
+		require.Equal(t, 0, len(registry.RegisteredTags()))
+	})
+
+	t.Run("nil decoder", func(t *testing.T) {
+		registry := atree.NewStorableRegistry()
+
+		err := registry.Register(161, nil)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+
+		require.Equal(t, 0, len(registry.RegisteredTags()))
+	})
+}
+
+func TestStreamValue(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("empty", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array.Append(atree.NewStreamValue(bytes.NewReader(nil), 0))
+		require.NoError(t, err)
+
+		v, err := array.Get(0)
+		require.NoError(t, err)
+		require.IsType(t, &atree.StreamValue{}, v)
+
+		sv := v.(*atree.StreamValue)
+		require.Equal(t, uint64(0), sv.Len())
+
+		b, err := io.ReadAll(sv.Reader())
+		require.NoError(t, err)
+		require.Empty(t, b)
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		const streamSize = 64 * 1024
+		const threshold = 256
+
+		atree.SetThreshold(threshold)
+		defer atree.SetThreshold(1024)
+
+		r := newRand(t)
+
+		data := make([]byte, streamSize)
+		_, err := r.Read(data)
+		require.NoError(t, err)
+
+		segments := make(map[atree.SlabID][]byte)
+		baseStorage := test_utils.NewInMemBaseStorageFromMap(segments)
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array.Append(atree.NewStreamValue(bytes.NewReader(data), streamSize))
+		require.NoError(t, err)
+
+		err = storage.FastCommit(runtime.NumCPU())
+		require.NoError(t, err)
+
+		// Every chunk slab is within slab size threshold.
+		for _, b := range segments {
+			require.LessOrEqual(t, len(b), threshold)
+		}
+
+		// Stream is split into multiple chunk slabs plus array root slab.
+		require.Greater(t, len(segments), streamSize/threshold+1)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		array2, err := atree.NewArrayWithRootID(storage2, array.SlabID())
+		require.NoError(t, err)
+
+		v, err := array2.Get(0)
+		require.NoError(t, err)
+		require.IsType(t, &atree.StreamValue{}, v)
+
+		sv := v.(*atree.StreamValue)
+		require.Equal(t, uint64(streamSize), sv.Len())
+
+		b, err := io.ReadAll(sv.Reader())
+		require.NoError(t, err)
+		require.Equal(t, data, b)
+
+		// Copy stored stream value to another array.
+		array3, err := atree.NewArray(storage2, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array3.Append(sv)
+		require.NoError(t, err)
+
+		v, err = array3.Get(0)
+		require.NoError(t, err)
+
+		b, err = io.ReadAll(v.(*atree.StreamValue).Reader())
+		require.NoError(t, err)
+		require.Equal(t, data, b)
+
+		_, err = atree.CheckStorageHealth(storage2, 2)
+		require.NoError(t, err)
+	})
+
+	t.Run("short read", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array.Append(atree.NewStreamValue(bytes.NewReader(make([]byte, 10)), 11))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestInternedString(t *testing.T) {
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	distinctStrings := []string{
+		strings.Repeat("red", 20),
+		strings.Repeat("green", 12),
+		strings.Repeat("blue", 15),
+		strings.Repeat("yellow", 10),
+	}
+
+	t.Run("storage size", func(t *testing.T) {
+		const mapCount = 4096
+
+		// newMap returns map with repeated string values and
+		// total size of slabs in base storage.
+		newMap := func(t *testing.T, intern bool) (*atree.PersistentSlabStorage, *atree.OrderedMap, int) {
+			segments := make(map[atree.SlabID][]byte)
+			storage := newTestPersistentStorageWithBaseStorage(t, test_utils.NewInMemBaseStorageFromMap(segments))
+
+			var dictionary *atree.StringDictionary
+			if intern {
+				var err error
+				dictionary, err = atree.NewStringDictionary(storage, address)
+				require.NoError(t, err)
+			}
+
+			m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+			require.NoError(t, err)
+
+			for i := range mapCount {
+				s := distinctStrings[i%len(distinctStrings)]
+
+				var v atree.Value = test_utils.NewStringValue(s)
+				if intern {
+					v = dictionary.Intern(s)
+				}
+
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), v)
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+			}
+
+			err = storage.Commit()
+			require.NoError(t, err)
+
+			size := 0
+			for _, b := range segments {
+				size += len(b)
+			}
+
+			return newTestPersistentStorageWithBaseStorage(t, test_utils.NewInMemBaseStorageFromMap(segments)), m, size
+		}
+
+		_, _, size := newMap(t, false)
+		storage, m, internedSize := newMap(t, true)
+
+		require.Less(t, internedSize, size/2)
+
+		// Load map from base storage.
+		loadedMap, err := atree.NewMapWithRootID(storage, m.SlabID(), atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+		require.Equal(t, uint64(mapCount), loadedMap.Count())
+
+		for i := range mapCount {
+			v, err := loadedMap.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.IsType(t, &atree.InternedStringValue{}, v)
+			require.Equal(t, distinctStrings[i%len(distinctStrings)], v.(*atree.InternedStringValue).Str())
+		}
+
+		// Storage has map and dictionary as root slabs.
+		rootIDs, err := atree.CheckStorageHealth(storage, -1)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(rootIDs))
+	})
+
+	t.Run("reference counting", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		dictionary, err := atree.NewStringDictionary(storage, address)
+		require.NoError(t, err)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		requireCount := func(t *testing.T, expected uint64) {
+			count, err := dictionary.Count()
+			require.NoError(t, err)
+			require.Equal(t, expected, count)
+		}
+
+		requireCount(t, 0)
+
+		// Append the same string multiple times.
+		for range 3 {
+			err = array.Append(dictionary.Intern(distinctStrings[0]))
+			require.NoError(t, err)
+		}
+		err = array.Append(dictionary.Intern(distinctStrings[1]))
+		require.NoError(t, err)
+
+		// Interned value isn't added to dictionary until it is stored.
+		_ = dictionary.Intern(distinctStrings[2])
+
+		requireCount(t, 2)
+
+		// Overwrite element and release overwritten string.
+		existingStorable, err := array.Set(3, dictionary.Intern(distinctStrings[0]))
+		require.NoError(t, err)
+
+		released, err := atree.ReleaseInternedString(storage, existingStorable)
+		require.NoError(t, err)
+		require.True(t, released)
+
+		requireCount(t, 1)
+
+		// Remove elements and release removed strings.
+		for array.Count() > 0 {
+			requireCount(t, 1)
+
+			existingStorable, err := array.Remove(0)
+			require.NoError(t, err)
+
+			released, err := atree.ReleaseInternedString(storage, existingStorable)
+			require.NoError(t, err)
+			require.True(t, released)
+		}
+
+		requireCount(t, 0)
+
+		// String can be interned again after it is removed from dictionary.
+		err = array.Append(dictionary.Intern(distinctStrings[0]))
+		require.NoError(t, err)
+
+		v, err := array.Get(0)
+		require.NoError(t, err)
+		require.Equal(t, distinctStrings[0], v.(*atree.InternedStringValue).Str())
+
+		requireCount(t, 1)
+
+		// Storable which isn't interned string isn't released.
+		released, err = atree.ReleaseInternedString(storage, test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.False(t, released)
+
+		// Dictionary can be loaded by slab ID.
+		loadedDictionary, err := atree.NewStringDictionaryWithID(storage, dictionary.SlabID())
+		require.NoError(t, err)
+
+		count, err := loadedDictionary.Count()
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), count)
+
+		_, err = atree.NewStringDictionaryWithID(storage, array.SlabID())
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var slabDataError *atree.SlabDataError
+		require.ErrorAs(t, err, &slabDataError)
+	})
+
+	t.Run("rejected value", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		dictionary, err := atree.NewStringDictionary(storage, address)
+		require.NoError(t, err)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		requireCount := func(t *testing.T, expected uint64) {
+			count, err := dictionary.Count()
+			require.NoError(t, err)
+			require.Equal(t, expected, count)
+		}
+
+		err = array.Append(dictionary.Intern(distinctStrings[0]))
+		require.NoError(t, err)
+
+		// Reference storable of interned string is larger than max value size.
+		atree.SetMaxValueSize(8, atree.MaxValueSizeModeError)
+		defer atree.SetMaxValueSize(0, atree.MaxValueSizeModeError)
+
+		requireMaxValueSizeError := func(t *testing.T, err error) {
+			require.Equal(t, 1, errorCategorizationCount(err))
+			var maxValueSizeError *atree.MaxValueSizeError
+			require.ErrorAs(t, err, &maxValueSizeError)
+		}
+
+		// Rejected new string isn't added to dictionary.
+		err = array.Append(dictionary.Intern(distinctStrings[1]))
+		requireMaxValueSizeError(t, err)
+
+		_, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), dictionary.Intern(distinctStrings[2]))
+		requireMaxValueSizeError(t, err)
+
+		requireCount(t, 1)
+
+		// Rejected existing string isn't retained.
+		err = array.Append(dictionary.Intern(distinctStrings[0]))
+		requireMaxValueSizeError(t, err)
+
+		atree.SetMaxValueSize(0, atree.MaxValueSizeModeError)
+
+		existingStorable, err := array.Remove(0)
+		require.NoError(t, err)
+
+		released, err := atree.ReleaseInternedString(storage, existingStorable)
+		require.NoError(t, err)
+		require.True(t, released)
+
+		requireCount(t, 0)
+	})
+
+	t.Run("max dictionary size", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		dictionary, err := atree.NewStringDictionary(storage, address)
+		require.NoError(t, err)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		_, maxThreshold, _, _ := atree.SetThreshold(1024)
+
+		// Add distinct strings until dictionary is full.
+		count := 0
+		for {
+			err = array.Append(dictionary.Intern(fmt.Sprintf("string %d", count)))
+			if err != nil {
+				break
+			}
+			count++
+		}
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+		require.Equal(t, uint64(count), array.Count())
+
+		// Existing strings can still be stored.
+		for range 300 {
+			err = array.Append(dictionary.Intern("string 0"))
+			require.NoError(t, err)
+		}
+
+		slab, found, err := storage.Retrieve(dictionary.SlabID())
+		require.NoError(t, err)
+		require.True(t, found)
+		require.LessOrEqual(t, uint64(slab.ByteSize()), maxThreshold)
+
+		dictionaryCount, err := dictionary.Count()
+		require.NoError(t, err)
+		require.Equal(t, uint64(count), dictionaryCount)
+	})
+}
@@ -22,11 +22,14 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 
 	"github.com/fxamacker/cbor/v2"
+	"github.com/zeebo/blake3"
 )
 
 const LedgerBaseStorageSlabPrefix = "$"
@@ -54,6 +57,42 @@ type BaseStorage interface {
 	BaseStorageUsageReporter
 }
 
+// VersionedBaseStorage is BaseStorage which also returns version of
+// retrieved data.  Version of a slab must not decrease, so a lower
+// version indicates stale data (e.g. from a lagging replica).
+type VersionedBaseStorage interface {
+	BaseStorage
+	RetrieveVersioned(SlabID) (data []byte, version uint64, found bool, err error)
+}
+
+// RetryableBaseStorage is BaseStorage which classifies its errors as
+// transient (retryable) or permanent.  Retrieve errors classified as
+// transient are returned as ExternalError with Temporary() returning true.
+type RetryableBaseStorage interface {
+	BaseStorage
+	IsRetryable(err error) bool
+}
+
+// TransactionalBaseStorage is BaseStorage which supports atomic
+// multi-slab writes.  PersistentSlabStorage.Commit, CommitAddress,
+// FastCommit, and NondeterministicFastCommit write all committed slabs
+// in one transaction, so either all or none of them are applied to
+// base storage.
+type TransactionalBaseStorage interface {
+	BaseStorage
+	BeginTx() (BaseStorageTx, error)
+}
+
+// BaseStorageTx is transaction of TransactionalBaseStorage.  Stored and
+// removed slabs are applied to base storage when Commit succeeds, and
+// discarded when Rollback is called.
+type BaseStorageTx interface {
+	Store(SlabID, []byte) error
+	Remove(SlabID) error
+	Commit() error
+	Rollback() error
+}
+
 type Ledger interface {
 	// GetValue gets a value for the given key in the storage, owned by the given account.
 	GetValue(owner, key []byte) (value []byte, err error)
@@ -68,12 +107,13 @@ type Ledger interface {
 // LedgerBaseStorage
 
 type LedgerBaseStorage struct {
+	usageScopes    UsageScopes
 	ledger         Ledger
 	bytesRetrieved int
 	bytesStored    int
 }
 
-var _ BaseStorage = &LedgerBaseStorage{}
+var _ UsageScopedBaseStorage = &LedgerBaseStorage{}
 
 func NewLedgerBaseStorage(ledger Ledger) *LedgerBaseStorage {
 	return &LedgerBaseStorage{
@@ -86,6 +126,7 @@ func NewLedgerBaseStorage(ledger Ledger) *LedgerBaseStorage {
 func (s *LedgerBaseStorage) Retrieve(id SlabID) ([]byte, bool, error) {
 	v, err := s.ledger.GetValue(id.address[:], SlabIndexToLedgerKey(id.index))
 	s.bytesRetrieved += len(v)
+	s.usageScopes.RecordRetrieve(id, len(v))
 
 	if err != nil {
 		// Wrap err as external error (if needed) because err is returned by Ledger interface.
@@ -97,6 +138,7 @@ func (s *LedgerBaseStorage) Retrieve(id SlabID) ([]byte, bool, error) {
 
 func (s *LedgerBaseStorage) Store(id SlabID, data []byte) error {
 	s.bytesStored += len(data)
+	s.usageScopes.RecordStore(id, len(data))
 	err := s.ledger.SetValue(id.address[:], SlabIndexToLedgerKey(id.index), data)
 
 	if err != nil {
@@ -108,6 +150,7 @@ func (s *LedgerBaseStorage) Store(id SlabID, data []byte) error {
 }
 
 func (s *LedgerBaseStorage) Remove(id SlabID) error {
+	s.usageScopes.RecordRemove(id)
 	err := s.ledger.SetValue(id.address[:], SlabIndexToLedgerKey(id.index), nil)
 
 	if err != nil {
@@ -171,6 +214,12 @@ func (s *LedgerBaseStorage) ResetReporter() {
 	s.bytesRetrieved = 0
 }
 
+// BeginUsageScope returns a new UsageScope.  Caller must call End on
+// returned scope, otherwise the scope is kept by this storage.
+func (s *LedgerBaseStorage) BeginUsageScope() *UsageScope {
+	return s.usageScopes.BeginUsageScope()
+}
+
 type SlabIterator func() (SlabID, Slab)
 
 type SlabStorage interface {
@@ -266,6 +315,20 @@ func (s *BasicSlabStorage) Encode() (map[SlabID][]byte, error) {
 	return m, nil
 }
 
+// Load decodes and stores given serialized slabs in storage.
+// This is currently used for testing.
+func (s *BasicSlabStorage) Load(m map[SlabID][]byte) error {
+	for id, data := range m {
+		slab, err := DecodeSlab(id, data, s.cborDecMode, s.DecodeStorable, s.DecodeTypeInfo)
+		if err != nil {
+			// err is already categorized by DecodeSlab().
+			return err
+		}
+		s.Slabs[id] = slab
+	}
+	return nil
+}
+
 func (s *BasicSlabStorage) SlabIterator() (SlabIterator, error) {
 	type slabEntry struct {
 		SlabID
@@ -308,12 +371,117 @@ type PersistentSlabStorage struct {
 	DecodeTypeInfo TypeInfoDecoder
 	cborEncMode    cbor.EncMode
 	cborDecMode    cbor.DecMode
+
+	// slabVersions contains max retrieved version of each slab.
+	// It is only used when base storage is VersionedBaseStorage
+	// and WithMonotonicSlabVersions option is used.
+	slabVersions map[SlabID]uint64
+
+	// slabGenerations contains generation of each slab retrieved from
+	// or committed to base storage.  It is only used when
+	// WithSlabGenerations option is used.
+	slabGenerations map[SlabID]slabGeneration
+
+	// committedChecksums contains checksum of each slab data retrieved from
+	// or committed to base storage.  It is only used when
+	// WithDeltaDeduplication option is used, and it contains at most
+	// maxCommittedChecksumCount entries.
+	committedChecksums map[SlabID][32]byte
+
+	// encodedDeltas contains encoded data of modified slabs encoded by
+	// PendingRealChanges, so commit doesn't encode them again.  Entry is
+	// removed when slab is stored or removed again.
+	encodedDeltas map[SlabID][]byte
+
+	// snapshots contains snapshots taken by Snapshot and not released yet.
+	snapshots      map[int]*storageSnapshot
+	nextSnapshotID int
+
+	// committedSlabs records slabs written to and removed from base
+	// storage during CommitReturningIDs and FastCommitReturningIDs.
+	committedSlabs *committedSlabIDs
+
+	// canonicalEncodeMode is true if slabs are encoded in canonical
+	// encode mode.  It is only set when WithCanonicalEncodeMode option is used.
+	canonicalEncodeMode bool
+}
+
+// committedSlabIDs contains IDs of slabs written to and removed from base storage by commit.
+type committedSlabIDs struct {
+	written []SlabID
+	removed []SlabID
 }
 
 var _ SlabStorage = &PersistentSlabStorage{}
 
+// slabGeneration contains generation number and checksum of
+// last retrieved or committed slab data.
+type slabGeneration struct {
+	generation uint64
+	checksum   [32]byte
+	removed    bool
+}
+
 type StorageOption func(st *PersistentSlabStorage) *PersistentSlabStorage
 
+// WithMonotonicSlabVersions requires version of each slab retrieved from
+// VersionedBaseStorage to not decrease during the lifetime of storage.
+// Retrieving an older version than previously retrieved returns StaleReadError.
+// This option has no effect if base storage isn't VersionedBaseStorage.
+func WithMonotonicSlabVersions() StorageOption {
+	return func(st *PersistentSlabStorage) *PersistentSlabStorage {
+		if _, ok := st.baseStorage.(VersionedBaseStorage); ok {
+			st.slabVersions = make(map[SlabID]uint64)
+		}
+		return st
+	}
+}
+
+// WithSlabGenerations enables tracking of per-slab generation numbers.
+// Generation of a slab starts at 0 when slab is first retrieved from or
+// committed to base storage, and it is incremented each time slab is
+// committed with different data or removed.  Callers can compare generations
+// of a slab (e.g. container root slab) before and after some work to detect
+// whether the slab was changed in the meantime.
+func WithSlabGenerations() StorageOption {
+	return func(st *PersistentSlabStorage) *PersistentSlabStorage {
+		st.slabGenerations = make(map[SlabID]slabGeneration)
+		return st
+	}
+}
+
+// maxCommittedChecksumCount is max number of slab checksums tracked for
+// delta deduplication.  When limit is reached, checksum of an arbitrary
+// slab is evicted, so that slab is stored on next commit even if its
+// data isn't changed.
+const maxCommittedChecksumCount = 1 << 16
+
+// WithDeltaDeduplication enables skipping of redundant writes on commit.
+// Checksum of each slab data retrieved from or committed to base storage is
+// tracked, and commit doesn't store modified slab if its encoded data is the
+// same as data in base storage (e.g. after an insert and a remove cancel out).
+// Tracked checksums are bounded (see maxCommittedChecksumCount) and cleared
+// by DropCache, since base storage can be changed outside of this storage.
+func WithDeltaDeduplication() StorageOption {
+	return func(st *PersistentSlabStorage) *PersistentSlabStorage {
+		st.committedChecksums = make(map[SlabID][32]byte)
+		return st
+	}
+}
+
+// WithCanonicalEncodeMode encodes slabs in canonical encode mode
+// (see EncodeSlabCanonical) when they are committed to base storage,
+// so colliding map elements are encoded in the same order regardless of
+// history of inserts and removes.  FastCommit and NondeterministicFastCommit
+// encode slabs with one worker in canonical encode mode because encoding
+// can retrieve slabs of keys stored in separate slabs.
+func WithCanonicalEncodeMode() StorageOption {
+	return func(st *PersistentSlabStorage) *PersistentSlabStorage {
+		st.canonicalEncodeMode = true
+		return st
+	}
+}
+
 func NewPersistentSlabStorage(
 	base BaseStorage,
 	cborEncMode cbor.EncMode,
@@ -467,8 +635,16 @@ func (s *PersistentSlabStorage) SlabIterator() (SlabIterator, error) {
 	}, nil
 }
 
+// GenerateSlabID returns new slab ID for given address.
+// If address is AddressUndefined, it returns temp slab ID with index
+// unique within this storage.  Temp slabs are not committed, so
+// they must be moved to slabs with account address before commit.
 func (s *PersistentSlabStorage) GenerateSlabID(address Address) (SlabID, error) {
 	if address == AddressUndefined {
+		if s.tempSlabIndex == math.MaxUint64 {
+			return SlabID{}, NewSlabIDError("failed to generate temp slab ID: temp slab index overflow")
+		}
+
 		var idx SlabIndex
 		s.tempSlabIndex++
 		binary.BigEndian.PutUint64(idx[:], s.tempSlabIndex)
@@ -482,6 +658,21 @@ func (s *PersistentSlabStorage) GenerateSlabID(address Address) (SlabID, error)
 	return id, nil
 }
 
+// ResetTempIndex resets temp slab index, so temp slab IDs are
+// generated from the beginning.  It returns error if any temp
+// slab is still in storage to prevent duplicate temp slab IDs.
+func (s *PersistentSlabStorage) ResetTempIndex() error {
+	for id, slab := range s.deltas {
+		if id.address == AddressUndefined && slab != nil {
+			return NewSlabIDErrorf("failed to reset temp slab index: temp slab %s is in storage", id)
+		}
+	}
+
+	s.tempSlabIndex = 0
+
+	return nil
+}
+
 func (s *PersistentSlabStorage) sortedOwnedDeltaKeys() []SlabID {
 	keysWithOwners := make([]SlabID, 0, len(s.deltas))
 	for k := range s.deltas {
@@ -510,7 +701,121 @@ func (s *PersistentSlabStorage) Commit() error {
 	return s.commit(keysWithOwners)
 }
 
+// encodeSlab encodes slab with storage's encode mode.
+func (s *PersistentSlabStorage) encodeSlab(slab Slab) ([]byte, error) {
+	if s.canonicalEncodeMode {
+		// Don't need to wrap error as external error because err is already categorized by EncodeSlabCanonical().
+		return EncodeSlabCanonical(s, slab, s.cborEncMode)
+	}
+	// Don't need to wrap error as external error because err is already categorized by EncodeSlab().
+	return EncodeSlab(slab, s.cborEncMode)
+}
+
+// encodeDelta returns encoded data of modified slab, reusing data
+// encoded by PendingRealChanges if slab isn't modified since then.
+func (s *PersistentSlabStorage) encodeDelta(id SlabID, slab Slab) ([]byte, error) {
+	if data, ok := s.encodedDeltas[id]; ok {
+		return data, nil
+	}
+	// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.encodeSlab().
+	return s.encodeSlab(slab)
+}
+
+// dropEncodedDeltas drops encoded data of modified slabs kept by
+// PendingRealChanges.  It is called after commit because committed slabs
+// are removed from deltas.
+func (s *PersistentSlabStorage) dropEncodedDeltas() {
+	s.encodedDeltas = nil
+}
+
+// CommitReturningIDs is like Commit, but also returns IDs of slabs
+// written to and removed from base storage, sorted by slab ID.
+// Modified slabs which aren't written because their data is the same as
+// data in base storage (see WithDeltaDeduplication) aren't returned.
+func (s *PersistentSlabStorage) CommitReturningIDs() (written []SlabID, removed []SlabID, err error) {
+	// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.Commit().
+	return s.commitReturningIDs(s.Commit)
+}
+
+// FastCommitReturningIDs is like FastCommit, but also returns IDs of
+// slabs written to and removed from base storage, sorted by slab ID.
+// Modified slabs which aren't written because their data is the same as
+// data in base storage (see WithDeltaDeduplication) aren't returned.
+func (s *PersistentSlabStorage) FastCommitReturningIDs(numWorkers int) (written []SlabID, removed []SlabID, err error) {
+	// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.FastCommit().
+	return s.commitReturningIDs(func() error {
+		return s.FastCommit(numWorkers)
+	})
+}
+
+func (s *PersistentSlabStorage) commitReturningIDs(commit func() error) ([]SlabID, []SlabID, error) {
+	s.committedSlabs = &committedSlabIDs{}
+	defer func() {
+		s.committedSlabs = nil
+	}()
+
+	err := commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	written := s.committedSlabs.written
+	removed := s.committedSlabs.removed
+
+	compare := func(a, b SlabID) int {
+		return a.Compare(b)
+	}
+	slices.SortFunc(written, compare)
+	slices.SortFunc(removed, compare)
+
+	return written, removed, nil
+}
+
+// recordCommittedSlab records slab written to or removed from base
+// storage if commit is called by CommitReturningIDs or FastCommitReturningIDs.
+func (s *PersistentSlabStorage) recordCommittedSlab(id SlabID, removed bool) {
+	if s.committedSlabs == nil {
+		return
+	}
+	if removed {
+		s.committedSlabs.removed = append(s.committedSlabs.removed, id)
+	} else {
+		s.committedSlabs.written = append(s.committedSlabs.written, id)
+	}
+}
+
+// CommitAddress commits deltas of slabs owned by given address only.
+// Deltas of other addresses remain uncommitted, so each address can be
+// committed at its own transaction boundary.  Slabs with undefined
+// (temp) address are never committed.
+func (s *PersistentSlabStorage) CommitAddress(address Address) error {
+	if address == AddressUndefined {
+		return nil
+	}
+
+	keys := make([]SlabID, 0, len(s.deltas))
+	for k := range s.deltas {
+		if k.address == address {
+			keys = append(keys, k)
+		}
+	}
+
+	// this part ensures the keys are sorted so commit operation is deterministic
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].IndexAsUint64() < keys[j].IndexAsUint64()
+	})
+
+	return s.commit(keys)
+}
+
 func (s *PersistentSlabStorage) commit(keys []SlabID) error {
+	defer s.dropEncodedDeltas()
+
+	if txStorage, ok := s.baseStorage.(TransactionalBaseStorage); ok {
+		// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.commitTx().
+		return s.commitTx(txStorage, keys, nil)
+	}
+
 	var err error
 
 	for _, id := range keys {
@@ -518,33 +823,44 @@ func (s *PersistentSlabStorage) commit(keys []SlabID) error {
 
 		// deleted slabs
 		if slab == nil {
+			err = s.preserveSnapshotData(id)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.preserveSnapshotData().
+				return err
+			}
+
 			err = s.baseStorage.Remove(id)
 			if err != nil {
 				// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
 				return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", id))
 			}
+			s.recordCommittedSlab(id, true)
 			// Deleted slabs are removed from deltas and added to read cache so that:
 			// 1. next read is from in-memory read cache
 			// 2. deleted slabs are not re-committed in next commit
 			s.cache[id] = nil
 			delete(s.deltas, id)
+			s.updateSlabGeneration(id, nil)
+			delete(s.committedChecksums, id)
 			continue
 		}
 
 		// serialize
-		data, err := EncodeSlab(slab, s.cborEncMode)
+		data, err := s.encodeDelta(id, slab)
 		if err != nil {
 			// err is categorized already by Encode()
 			return err
 		}
 
 		// store
-		err = s.baseStorage.Store(id, data)
+		err = s.storeSlabData(id, data)
 		if err != nil {
-			// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
-			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to store slab %s", id))
+			// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.storeSlabData().
+			return err
 		}
 
+		s.updateSlabGeneration(id, data)
+
 		// add to read cache
 		s.cache[id] = slab
 		// It's safe to remove slab from deltas because
@@ -558,7 +874,163 @@ func (s *PersistentSlabStorage) commit(keys []SlabID) error {
 	return nil
 }
 
+// commitTx writes slabs of keys to base storage in one transaction.
+// Modified slabs found in encoded (e.g. encoded in parallel by FastCommit)
+// aren't encoded again.  Deltas, cache, and committed checksums are
+// updated only after the transaction is committed successfully.
+func (s *PersistentSlabStorage) commitTx(txStorage TransactionalBaseStorage, keys []SlabID, encoded map[SlabID][]byte) error {
+	tx, err := txStorage.BeginTx()
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by TransactionalBaseStorage interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to begin base storage transaction")
+	}
+
+	encodedSlabs, err := s.writeTx(tx, keys, encoded)
+	if err != nil {
+		// Discard partially written transaction.  Rollback error is ignored
+		// because err from writing transaction is more relevant.
+		_ = tx.Rollback()
+
+		// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.writeTx().
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by BaseStorageTx interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, "failed to commit base storage transaction")
+	}
+
+	for i, id := range keys {
+		data := encodedSlabs[i]
+
+		// deleted slabs
+		if data == nil {
+			s.cache[id] = nil
+			delete(s.deltas, id)
+			s.updateSlabGeneration(id, nil)
+			delete(s.committedChecksums, id)
+			continue
+		}
+
+		s.updateSlabGeneration(id, data)
+
+		s.recordCommittedChecksum(id, blake3.Sum256(data))
+
+		// add to read cache
+		s.cache[id] = s.deltas[id]
+		delete(s.deltas, id)
+	}
+
+	return nil
+}
+
+// writeTx stores and removes slabs of keys in tx, and returns encoded
+// data of stored slabs (nil for removed slabs) in the same order as keys.
+func (s *PersistentSlabStorage) writeTx(tx BaseStorageTx, keys []SlabID, encoded map[SlabID][]byte) ([][]byte, error) {
+	encodedSlabs := make([][]byte, len(keys))
+
+	for i, id := range keys {
+		slab := s.deltas[id]
+
+		// deleted slabs
+		if slab == nil {
+			err := s.preserveSnapshotData(id)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.preserveSnapshotData().
+				return nil, err
+			}
+
+			err = tx.Remove(id)
+			if err != nil {
+				// Wrap err as external error (if needed) because err is returned by BaseStorageTx interface.
+				return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", id))
+			}
+			s.recordCommittedSlab(id, true)
+			continue
+		}
+
+		// serialize
+		data, ok := encoded[id]
+		if !ok {
+			var err error
+			data, err = s.encodeDelta(id, slab)
+			if err != nil {
+				// err is categorized already by Encode()
+				return nil, err
+			}
+		}
+
+		encodedSlabs[i] = data
+
+		if s.committedChecksums != nil {
+			if committed, exists := s.committedChecksums[id]; exists && committed == blake3.Sum256(data) {
+				continue
+			}
+		}
+
+		err := s.preserveSnapshotData(id)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.preserveSnapshotData().
+			return nil, err
+		}
+
+		err = tx.Store(id, data)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by BaseStorageTx interface.
+			return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to store slab %s", id))
+		}
+		s.recordCommittedSlab(id, false)
+	}
+
+	return encodedSlabs, nil
+}
+
+// EstimateCommit returns cost of committing current deltas without
+// committing them.  Modified slabs are encoded to compute bytesToWrite.
+// slabsAffected is number of slabs to be stored or removed by commit.
+//
+// bytesToRemove is an approximation computed from encoded size of removed
+// slabs in read cache.  Removed slabs which aren't cached aren't retrieved
+// from base storage (so estimating doesn't add reads to usage metering),
+// and their size isn't included, so bytesToRemove is a lower bound.
+// Base storage isn't read or modified.
+func (s *PersistentSlabStorage) EstimateCommit() (bytesToWrite int, bytesToRemove int, slabsAffected int, err error) {
+	for id, slab := range s.deltas {
+		// Ignore slabs not owned by accounts
+		if id.address == AddressUndefined {
+			continue
+		}
+
+		slabsAffected++
+
+		// modified slabs
+		if slab != nil {
+			data, err := s.encodeDelta(id, slab)
+			if err != nil {
+				// err is categorized already by PersistentSlabStorage.encodeDelta()
+				return 0, 0, 0, err
+			}
+			bytesToWrite += len(data)
+			continue
+		}
+
+		// deleted slabs
+		if cachedSlab := s.cache[id]; cachedSlab != nil {
+			data, err := s.encodeSlab(cachedSlab)
+			if err != nil {
+				// err is categorized already by PersistentSlabStorage.encodeSlab()
+				return 0, 0, 0, err
+			}
+			bytesToRemove += len(data)
+		}
+	}
+
+	return bytesToWrite, bytesToRemove, slabsAffected, nil
+}
+
 func (s *PersistentSlabStorage) FastCommit(numWorkers int) error {
+	defer s.dropEncodedDeltas()
 
 	// this part ensures the keys are sorted so commit operation is deterministic
 	keysWithOwners := s.sortedOwnedDeltaKeys()
@@ -572,6 +1044,12 @@ func (s *PersistentSlabStorage) FastCommit(numWorkers int) error {
 		numWorkers = len(keysWithOwners)
 	}
 
+	// Encoding in canonical encode mode can retrieve slabs of keys
+	// stored in separate slabs, which isn't safe in concurrent encoders.
+	if s.canonicalEncodeMode {
+		numWorkers = 1
+	}
+
 	// construct job queue
 	jobs := make(chan SlabID, len(keysWithOwners))
 	for _, id := range keysWithOwners {
@@ -611,7 +1089,7 @@ func (s *PersistentSlabStorage) FastCommit(numWorkers int) error {
 				continue
 			}
 			// serialize
-			data, err := EncodeSlab(slab, s.cborEncMode)
+			data, err := s.encodeDelta(id, slab)
 			results <- &encodedSlabs{
 				slabID: id,
 				data:   data,
@@ -657,32 +1135,49 @@ func (s *PersistentSlabStorage) FastCommit(numWorkers int) error {
 
 	// at this stage all results has been processed
 	// and ready to be passed to base storage layer
+
+	if txStorage, ok := s.baseStorage.(TransactionalBaseStorage); ok {
+		// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.commitTx().
+		return s.commitTx(txStorage, keysWithOwners, encSlabByID)
+	}
+
 	for _, id := range keysWithOwners {
 		data := encSlabByID[id]
 
 		var err error
 		// deleted slabs
 		if data == nil {
+			err = s.preserveSnapshotData(id)
+			if err != nil {
+				// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.preserveSnapshotData().
+				return err
+			}
+
 			err = s.baseStorage.Remove(id)
 			if err != nil {
 				// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
 				return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", id))
 			}
+			s.recordCommittedSlab(id, true)
 			// Deleted slabs are removed from deltas and added to read cache so that:
 			// 1. next read is from in-memory read cache
 			// 2. deleted slabs are not re-committed in next commit
 			s.cache[id] = nil
 			delete(s.deltas, id)
+			s.updateSlabGeneration(id, nil)
+			delete(s.committedChecksums, id)
 			continue
 		}
 
 		// store
-		err = s.baseStorage.Store(id, data)
+		err = s.storeSlabData(id, data)
 		if err != nil {
-			// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
-			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to store slab %s", id))
+			// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.storeSlabData().
+			return err
 		}
 
+		s.updateSlabGeneration(id, data)
+
 		s.cache[id] = s.deltas[id]
 		// It's safe to remove slab from deltas because
 		// iteration is on non-temp slabs and temp slabs
@@ -700,6 +1195,8 @@ func (s *PersistentSlabStorage) FastCommit(numWorkers int) error {
 // IMPORTANT: This function is used by migration programs when commit order of slabs
 // is not required to be deterministic (while preserving deterministic array and map iteration).
 func (s *PersistentSlabStorage) NondeterministicFastCommit(numWorkers int) error {
+	defer s.dropEncodedDeltas()
+
 	// No changes
 	if len(s.deltas) == 0 {
 		return nil
@@ -746,7 +1243,7 @@ func (s *PersistentSlabStorage) NondeterministicFastCommit(numWorkers int) error
 			}
 
 			// Serialize
-			data, err := EncodeSlab(slab, s.cborEncMode)
+			data, err := s.encodeDelta(id, slab)
 			results <- encodedSlab{
 				slabID: id,
 				data:   data,
@@ -802,6 +1299,12 @@ func (s *PersistentSlabStorage) NondeterministicFastCommit(numWorkers int) error
 		numWorkers = modifiedSlabCount
 	}
 
+	// Encoding in canonical encode mode can retrieve slabs of keys
+	// stored in separate slabs, which isn't safe in concurrent encoders.
+	if s.canonicalEncodeMode {
+		numWorkers = 1
+	}
+
 	var wg sync.WaitGroup
 
 	// Create done signal channel
@@ -835,22 +1338,56 @@ func (s *PersistentSlabStorage) NondeterministicFastCommit(numWorkers int) error
 	}
 	close(jobs)
 
+	if txStorage, ok := s.baseStorage.(TransactionalBaseStorage); ok {
+		// Collect all encoded slabs before writing them, because
+		// modified and deleted slabs are written in one transaction.
+		encSlabByID := make(map[SlabID][]byte, modifiedSlabCount)
+		for range modifiedSlabCount {
+			result := <-results
+
+			if result.err != nil {
+				// Closing done channel signals goroutines to stop.
+				close(done)
+				// result.err is already categorized by Encode().
+				return result.err
+			}
+
+			encSlabByID[result.slabID] = result.data
+		}
+
+		ids := slices.Concat(modifiedSlabIDs, deletedSlabIDs)
+
+		// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.commitTx().
+		return s.commitTx(txStorage, ids, encSlabByID)
+	}
+
 	// Remove deleted slabs from underlying storage.
 	for _, id := range deletedSlabIDs {
 
-		err := s.baseStorage.Remove(id)
+		err := s.preserveSnapshotData(id)
+		if err != nil {
+			// Closing done channel signals goroutines to stop.
+			close(done)
+			// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.preserveSnapshotData().
+			return err
+		}
+
+		err = s.baseStorage.Remove(id)
 		if err != nil {
 			// Closing done channel signals goroutines to stop.
 			close(done)
 			// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
 			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", id))
 		}
+		s.recordCommittedSlab(id, true)
 
 		// Deleted slabs are removed from deltas and added to read cache so that:
 		// 1. next read is from in-memory read cache
 		// 2. deleted slabs are not re-committed in next commit
 		s.cache[id] = nil
 		delete(s.deltas, id)
+		s.updateSlabGeneration(id, nil)
+		delete(s.committedChecksums, id)
 	}
 
 	// Process encoded slabs
@@ -875,14 +1412,16 @@ func (s *PersistentSlabStorage) NondeterministicFastCommit(numWorkers int) error
 		}
 
 		// Store
-		err := s.baseStorage.Store(id, data)
+		err := s.storeSlabData(id, data)
 		if err != nil {
 			// Closing done channel signals goroutines to stop.
 			close(done)
-			// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
-			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to store slab %s", id))
+			// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.storeSlabData().
+			return err
 		}
 
+		s.updateSlabGeneration(id, data)
+
 		s.cache[id] = s.deltas[id]
 		// It's safe to remove slab from deltas because
 		// iteration is on non-temp slabs and temp slabs
@@ -897,10 +1436,17 @@ func (s *PersistentSlabStorage) NondeterministicFastCommit(numWorkers int) error
 
 func (s *PersistentSlabStorage) DropDeltas() {
 	s.deltas = make(map[SlabID]Slab)
+	s.dropEncodedDeltas()
 }
 
+// DropCache drops read cache.  Checksums tracked for delta deduplication
+// are also dropped, so slabs retrieved again are compared with the latest
+// data in base storage.
 func (s *PersistentSlabStorage) DropCache() {
 	s.cache = make(map[SlabID]Slab)
+	if s.committedChecksums != nil {
+		clear(s.committedChecksums)
+	}
 }
 
 func (s *PersistentSlabStorage) RetrieveIgnoringDeltas(id SlabID, cache bool) (Slab, bool, error) {
@@ -911,10 +1457,10 @@ func (s *PersistentSlabStorage) RetrieveIgnoringDeltas(id SlabID, cache bool) (S
 	}
 
 	// fetch from base storage last
-	data, ok, err := s.baseStorage.Retrieve(id)
+	data, ok, err := s.retrieveFromBaseStorage(id)
 	if err != nil {
-		// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
-		return nil, ok, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+		// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.retrieveFromBaseStorage().
+		return nil, ok, err
 	}
 	if !ok {
 		return nil, ok, nil
@@ -934,6 +1480,206 @@ func (s *PersistentSlabStorage) RetrieveIgnoringDeltas(id SlabID, cache bool) (S
 	return slab, ok, nil
 }
 
+// SlabGeneration returns generation of slab with given id.
+// Returned bool is false if slab generations aren't tracked
+// (see WithSlabGenerations) or if slab hasn't been retrieved from
+// or committed to base storage yet.
+func (s *PersistentSlabStorage) SlabGeneration(id SlabID) (uint64, bool) {
+	g, exists := s.slabGenerations[id]
+	if !exists {
+		return 0, false
+	}
+	return g.generation, true
+}
+
+// updateSlabGeneration increments generation of slab if committed data
+// is different from previously retrieved or committed data.
+// Nil data means slab is removed.
+func (s *PersistentSlabStorage) updateSlabGeneration(id SlabID, data []byte) {
+	if s.slabGenerations == nil {
+		return
+	}
+
+	var next slabGeneration
+	if data == nil {
+		next.removed = true
+	} else {
+		next.checksum = blake3.Sum256(data)
+	}
+
+	prev, exists := s.slabGenerations[id]
+	if exists {
+		if prev.removed == next.removed && prev.checksum == next.checksum {
+			// Slab data isn't changed.
+			return
+		}
+		next.generation = prev.generation + 1
+	}
+
+	s.slabGenerations[id] = next
+}
+
+// storeSlabData stores slab data in base storage.  If delta deduplication
+// is enabled, storing is skipped if data is the same as data in base storage.
+func (s *PersistentSlabStorage) storeSlabData(id SlabID, data []byte) error {
+	var checksum [32]byte
+	if s.committedChecksums != nil {
+		checksum = blake3.Sum256(data)
+		if committed, exists := s.committedChecksums[id]; exists && committed == checksum {
+			return nil
+		}
+	}
+
+	err := s.preserveSnapshotData(id)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.preserveSnapshotData().
+		return err
+	}
+
+	err = s.baseStorage.Store(id, data)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to store slab %s", id))
+	}
+	s.recordCommittedSlab(id, false)
+
+	if s.committedChecksums != nil {
+		s.recordCommittedChecksum(id, checksum)
+	}
+
+	return nil
+}
+
+// PendingRealChanges returns number of modified slabs which will be
+// written to or removed from base storage by commit.  If delta deduplication
+// is enabled (see WithDeltaDeduplication), modified slabs with the same
+// encoded data as data in base storage aren't counted.  Otherwise, it is
+// the same as number of deltas without temp addresses.
+func (s *PersistentSlabStorage) PendingRealChanges() (int, error) {
+	count := 0
+	for id, slab := range s.deltas {
+		// Ignore slabs not owned by accounts
+		if id.address == AddressUndefined {
+			continue
+		}
+
+		if slab != nil && s.committedChecksums != nil {
+			committed, exists := s.committedChecksums[id]
+			if exists {
+				data, err := s.encodeDelta(id, slab)
+				if err != nil {
+					// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.encodeDelta().
+					return 0, err
+				}
+
+				// Keep encoded data so that commit doesn't encode slab again.
+				if s.encodedDeltas == nil {
+					s.encodedDeltas = make(map[SlabID][]byte)
+				}
+				s.encodedDeltas[id] = data
+
+				if blake3.Sum256(data) == committed {
+					continue
+				}
+			}
+		}
+
+		count++
+	}
+	return count, nil
+}
+
+// retrieveFromBaseStorage retrieves slab data from base storage.
+// If monotonic slab versions are required, it also checks that
+// retrieved version isn't older than previously retrieved version.
+// If slab generations are tracked, it records checksum of retrieved data.
+func (s *PersistentSlabStorage) retrieveFromBaseStorage(id SlabID) ([]byte, bool, error) {
+	data, ok, err := s.retrieveVersionedFromBaseStorage(id)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.retrieveVersionedFromBaseStorage().
+		return nil, ok, err
+	}
+	if !ok {
+		return nil, ok, nil
+	}
+
+	// Record checksum of retrieved data so that generation isn't
+	// incremented if same data is committed later.
+	if s.slabGenerations != nil {
+		if _, exists := s.slabGenerations[id]; !exists {
+			s.slabGenerations[id] = slabGeneration{checksum: blake3.Sum256(data)}
+		}
+	}
+
+	// Record checksum of retrieved data so that same data isn't stored again.
+	// Checksum is overwritten because retrieved data is the latest data in
+	// base storage.
+	if s.committedChecksums != nil {
+		s.recordCommittedChecksum(id, blake3.Sum256(data))
+	}
+
+	return data, ok, nil
+}
+
+// recordCommittedChecksum records checksum of slab data in base storage.
+// If max number of checksums is reached, checksum of an arbitrary slab is
+// evicted, which only causes that slab to be stored on next commit.
+func (s *PersistentSlabStorage) recordCommittedChecksum(id SlabID, checksum [32]byte) {
+	if s.committedChecksums == nil {
+		return
+	}
+
+	if _, exists := s.committedChecksums[id]; !exists && len(s.committedChecksums) >= maxCommittedChecksumCount {
+		for evictedID := range s.committedChecksums {
+			delete(s.committedChecksums, evictedID)
+			break
+		}
+	}
+
+	s.committedChecksums[id] = checksum
+}
+
+// wrapRetrieveError wraps err returned by base storage as external error.
+// If base storage classifies err as retryable, wrapped error is temporary.
+func (s *PersistentSlabStorage) wrapRetrieveError(id SlabID, err error) error {
+	if rs, ok := s.baseStorage.(RetryableBaseStorage); ok && rs.IsRetryable(err) {
+		var te temporary
+		if !errors.As(err, &te) || !te.Temporary() {
+			err = &temporaryError{err: err}
+		}
+	}
+
+	// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
+	return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+}
+
+func (s *PersistentSlabStorage) retrieveVersionedFromBaseStorage(id SlabID) ([]byte, bool, error) {
+	if s.slabVersions == nil {
+		data, ok, err := s.baseStorage.Retrieve(id)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.wrapRetrieveError().
+			return nil, ok, s.wrapRetrieveError(id, err)
+		}
+		return data, ok, nil
+	}
+
+	data, version, ok, err := s.baseStorage.(VersionedBaseStorage).RetrieveVersioned(id)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.wrapRetrieveError().
+		return nil, ok, s.wrapRetrieveError(id, err)
+	}
+	if !ok {
+		return nil, ok, nil
+	}
+
+	if previousVersion, exists := s.slabVersions[id]; exists && version < previousVersion {
+		return nil, ok, NewStaleReadError(id, version, previousVersion)
+	}
+	s.slabVersions[id] = version
+
+	return data, ok, nil
+}
+
 func (s *PersistentSlabStorage) RetrieveIfLoaded(id SlabID) Slab {
 	// check deltas first.
 	if slab, ok := s.deltas[id]; ok {
@@ -965,6 +1711,7 @@ func (s *PersistentSlabStorage) Store(id SlabID, slab Slab) error {
 	}
 	// add to deltas
 	s.deltas[id] = slab
+	delete(s.encodedDeltas, id)
 	return nil
 }
 
@@ -974,6 +1721,7 @@ func (s *PersistentSlabStorage) Remove(id SlabID) error {
 	}
 	// add to nil to deltas under that id
 	s.deltas[id] = nil
+	delete(s.encodedDeltas, id)
 	return nil
 }
 
@@ -1198,10 +1946,18 @@ func (s *PersistentSlabStorage) fixBrokenReferencesInMap(old MapSlab) error {
 			size:   mapRootDataSlabPrefixSize + hkeyElementsPrefixSize,
 		},
 		extraData: &MapExtraData{
-			TypeInfo: oldExtraData.TypeInfo,
-			Seed:     oldExtraData.Seed,
+			TypeInfo:          oldExtraData.TypeInfo,
+			Seed:              oldExtraData.Seed,
+			SchemaID:          oldExtraData.SchemaID,
+			narrowDigests:     oldExtraData.narrowDigests,
+			singleDigestLevel: oldExtraData.singleDigestLevel,
+			digesterBuilderID: oldExtraData.digesterBuilderID,
+		},
+		elements: &hkeyElements{
+			level:  0,
+			size:   hkeyElementsPrefixSize,
+			narrow: oldExtraData.narrowDigests,
 		},
-		elements: newHkeyElements(0),
 	}
 
 	// Store new empty map with the same SlabID.
@@ -1320,10 +2076,10 @@ func (s *PersistentSlabStorage) BatchPreload(ids []SlabID, numWorkers int) error
 
 		for _, id := range ids {
 			// fetch from base storage last
-			data, ok, err := s.baseStorage.Retrieve(id)
+			data, ok, err := s.retrieveFromBaseStorage(id)
 			if err != nil {
-				// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
-				return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+				// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.retrieveFromBaseStorage().
+				return err
 			}
 			if !ok {
 				continue
@@ -1424,12 +2180,12 @@ func (s *PersistentSlabStorage) BatchPreload(ids []SlabID, numWorkers int) error
 
 		for _, id := range ids {
 			// fetch from base storage last
-			data, ok, err := s.baseStorage.Retrieve(id)
+			data, ok, err := s.retrieveFromBaseStorage(id)
 			if err != nil {
 				// Closing done channel signals goroutines to stop.
 				close(done)
-				// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
-				return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+				// Don't need to wrap error as external error because err is already categorized by PersistentSlabStorage.retrieveFromBaseStorage().
+				return err
 			}
 			if !ok {
 				continue
@@ -18,7 +18,10 @@
 
 package atree
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+)
 
 // CheckStorageHealth checks for the health of slab storage.
 // It traverses the slabs and checks these factors:
@@ -163,3 +166,179 @@ func CheckStorageHealth(storage SlabStorage, expectedNumberOfRootSlabs int) (map
 
 	return rootsMap, nil
 }
+
+// FindOrphanedSlabs returns sorted IDs of slabs in storage that aren't
+// reachable from any of the given root slabs.  Unlike CheckStorageHealth,
+// it doesn't stop at the first problem, so it can be used to find all
+// slabs leaked by incomplete removal.
+// This should be used for analysis and testing purposes only, as it might be slow to process.
+func FindOrphanedSlabs(s *BasicSlabStorage, roots []SlabID) ([]SlabID, error) {
+	visited := make(map[SlabID]struct{}, len(s.Slabs))
+
+	next := make([]SlabID, 0, len(roots))
+	next = append(next, roots...)
+
+	for len(next) > 0 {
+		id := next[len(next)-1]
+		next = next[:len(next)-1]
+
+		if _, ok := visited[id]; ok {
+			continue
+		}
+		visited[id] = struct{}{}
+
+		slab, found, err := s.Retrieve(id)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+		}
+		if !found {
+			return nil, NewSlabNotFoundErrorf(id, "failed to retrieve slab")
+		}
+
+		// Traverse child storables, including elements of inlined slabs,
+		// to find all referenced slabs.
+		childStorables := slab.ChildStorables()
+		for len(childStorables) > 0 {
+			var nextStorables []Storable
+
+			for _, childStorable := range childStorables {
+				if slabIDStorable, ok := childStorable.(SlabIDStorable); ok {
+					next = append(next, SlabID(slabIDStorable))
+				}
+
+				nextStorables = append(nextStorables, childStorable.ChildStorables()...)
+			}
+
+			childStorables = nextStorables
+		}
+	}
+
+	var orphaned []SlabID
+	for id := range s.Slabs {
+		if _, ok := visited[id]; !ok {
+			orphaned = append(orphaned, id)
+		}
+	}
+
+	slices.SortFunc(orphaned, SlabID.Compare)
+
+	return orphaned, nil
+}
+
+// ExpectedChildTypeInfoFunc returns expected type info of nested container
+// with root slab childID referenced by container with root slab parentID.
+// It returns false if expected type info isn't known, and check is skipped.
+type ExpectedChildTypeInfoFunc func(parentID SlabID, childID SlabID) (TypeInfo, bool)
+
+// CheckChildTypeInfo traverses container with root slab rootID and checks
+// that type info of nested containers stored in separate slabs matches
+// type info returned by expectedTypeInfo.  It returns error identifying
+// child slab ID if type info doesn't match.
+// This should be used for testing purposes only, as it might be slow to process.
+func CheckChildTypeInfo(
+	storage SlabStorage,
+	rootID SlabID,
+	expectedTypeInfo ExpectedChildTypeInfoFunc,
+	tic TypeInfoComparator,
+) error {
+	type slabToCheck struct {
+		id          SlabID
+		containerID SlabID // root slab ID of container owning slab
+	}
+
+	next := []slabToCheck{{id: rootID, containerID: rootID}}
+
+	for len(next) > 0 {
+		current := next[len(next)-1]
+		next = next[:len(next)-1]
+
+		slab, found, err := storage.Retrieve(current.id)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", current.id))
+		}
+		if !found {
+			return NewSlabNotFoundErrorf(current.id, "failed to retrieve slab")
+		}
+
+		// Traverse child storables, including elements of inlined slabs,
+		// to find all referenced slabs.
+		childStorables := slab.ChildStorables()
+		for len(childStorables) > 0 {
+			var nextStorables []Storable
+
+			for _, childStorable := range childStorables {
+				if slabIDStorable, ok := childStorable.(SlabIDStorable); ok {
+					childID := SlabID(slabIDStorable)
+
+					containerID, err := checkChildSlabTypeInfo(storage, current.containerID, childID, expectedTypeInfo, tic)
+					if err != nil {
+						// Don't need to wrap error as external error because err is already categorized by checkChildSlabTypeInfo().
+						return err
+					}
+
+					next = append(next, slabToCheck{id: childID, containerID: containerID})
+				}
+
+				nextStorables = append(nextStorables, childStorable.ChildStorables()...)
+			}
+
+			childStorables = nextStorables
+		}
+	}
+
+	return nil
+}
+
+// checkChildSlabTypeInfo checks type info of child slab referenced by
+// container parentID if child slab is root slab of nested container.
+// It returns root slab ID of container owning child slab.
+func checkChildSlabTypeInfo(
+	storage SlabStorage,
+	parentID SlabID,
+	childID SlabID,
+	expectedTypeInfo ExpectedChildTypeInfoFunc,
+	tic TypeInfoComparator,
+) (SlabID, error) {
+	slab, found, err := storage.Retrieve(childID)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return SlabIDUndefined, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", childID))
+	}
+	if !found {
+		return SlabIDUndefined, NewSlabNotFoundErrorf(childID, "failed to retrieve child slab")
+	}
+
+	var typeInfo TypeInfo
+	switch slab := slab.(type) {
+	case ArraySlab:
+		if extraData := slab.ExtraData(); extraData != nil {
+			typeInfo = extraData.TypeInfo
+		}
+	case MapSlab:
+		if extraData := slab.ExtraData(); extraData != nil {
+			typeInfo = extraData.TypeInfo
+		}
+	}
+
+	if typeInfo == nil {
+		// Child slab is non-root slab of parent container,
+		// or isn't container slab (e.g. external value).
+		return parentID, nil
+	}
+
+	expected, ok := expectedTypeInfo(parentID, childID)
+	if ok && !tic(expected, typeInfo) {
+		return SlabIDUndefined, NewFatalError(
+			fmt.Errorf(
+				"child container %s of container %s has type information %v, want %v",
+				childID,
+				parentID,
+				typeInfo,
+				expected,
+			))
+	}
+
+	return childID, nil
+}
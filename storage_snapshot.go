@@ -0,0 +1,200 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// storageSnapshot contains encoded data of slabs which are different from
+// data in base storage.  Nil data means that slab doesn't exist in snapshot.
+// Slabs not in storageSnapshot are the same as in base storage.
+type storageSnapshot struct {
+	slabs map[SlabID][]byte
+
+	// tempSlabIndex is temp slab index of storage when snapshot is taken.
+	tempSlabIndex uint64
+}
+
+// Snapshot captures current state of storage, including uncommitted deltas,
+// and returns ID of the snapshot.  Snapshot is kept until ReleaseSnapshot is
+// called, and it remains valid across commits because slab data in base
+// storage is preserved in snapshot before it is overwritten by commit.
+func (s *PersistentSlabStorage) Snapshot() (int, error) {
+	snapshot := &storageSnapshot{
+		slabs:         make(map[SlabID][]byte, len(s.deltas)),
+		tempSlabIndex: s.tempSlabIndex,
+	}
+
+	for id, slab := range s.deltas {
+		if slab == nil {
+			snapshot.slabs[id] = nil
+			continue
+		}
+
+		data, err := s.encodeSlab(slab)
+		if err != nil {
+			// err is categorized already by PersistentSlabStorage.encodeSlab()
+			return 0, err
+		}
+
+		snapshot.slabs[id] = data
+	}
+
+	if s.snapshots == nil {
+		s.snapshots = make(map[int]*storageSnapshot)
+	}
+
+	s.nextSnapshotID++
+	s.snapshots[s.nextSnapshotID] = snapshot
+
+	return s.nextSnapshotID, nil
+}
+
+// ReleaseSnapshot releases snapshot with given ID.
+func (s *PersistentSlabStorage) ReleaseSnapshot(snapshotID int) {
+	delete(s.snapshots, snapshotID)
+}
+
+// RollbackTo restores storage to state captured by snapshot with given ID.
+// Changes made after the snapshot are discarded.  Changes committed after
+// the snapshot are reverted by deltas, which are written to base storage
+// by next commit.  Snapshot is kept, so storage can be rolled back to it again.
+//
+// Temp slab index is reset to its value when snapshot was taken, so temp
+// slab IDs generated after the snapshot are reclaimed.  Temp slabs aren't
+// committed, so live temp slabs are the ones restored from snapshot, and
+// temp slab index is never lower than their indexes.
+//
+// Array and OrderedMap created or loaded before RollbackTo are outdated,
+// and they need to be loaded again by their root slab IDs.
+func (s *PersistentSlabStorage) RollbackTo(snapshotID int) error {
+	snapshot, ok := s.snapshots[snapshotID]
+	if !ok {
+		return NewUserError(fmt.Errorf("snapshot %d isn't found", snapshotID))
+	}
+
+	deltas := make(map[SlabID]Slab, len(snapshot.slabs))
+
+	for id, data := range snapshot.slabs {
+		if data == nil {
+			deltas[id] = nil
+			continue
+		}
+
+		slab, err := DecodeSlab(id, data, s.cborDecMode, s.DecodeStorable, s.DecodeTypeInfo)
+		if err != nil {
+			// err is already categorized by DecodeSlab().
+			return err
+		}
+
+		deltas[id] = slab
+	}
+
+	s.deltas = deltas
+	s.dropEncodedDeltas()
+	s.DropCache()
+
+	s.tempSlabIndex = snapshot.tempSlabIndex
+
+	for id, slab := range s.deltas {
+		if id.address == AddressUndefined && slab != nil {
+			s.tempSlabIndex = max(s.tempSlabIndex, binary.BigEndian.Uint64(id.index[:]))
+		}
+	}
+
+	return nil
+}
+
+// snapshotStorage returns read-only storage with state of snapshot.
+func (s *PersistentSlabStorage) snapshotStorage(snapshotID int) (*PersistentSlabStorage, error) {
+	snapshot, ok := s.snapshots[snapshotID]
+	if !ok {
+		return nil, NewUserError(fmt.Errorf("snapshot %d isn't found", snapshotID))
+	}
+
+	base := &snapshotBaseStorage{
+		BaseStorage: s.baseStorage,
+		snapshot:    snapshot.slabs,
+	}
+
+	return NewPersistentSlabStorage(base, s.cborEncMode, s.cborDecMode, s.DecodeStorable, s.DecodeTypeInfo), nil
+}
+
+// preserveSnapshotData adds slab data in base storage to snapshots
+// which don't have the slab, before slab is overwritten or removed
+// in base storage.
+func (s *PersistentSlabStorage) preserveSnapshotData(id SlabID) error {
+	var data []byte
+	retrieved := false
+
+	for _, snapshot := range s.snapshots {
+		if _, ok := snapshot.slabs[id]; ok {
+			continue
+		}
+
+		if !retrieved {
+			var found bool
+			var err error
+			data, found, err = s.baseStorage.Retrieve(id)
+			if err != nil {
+				// Wrap err as external error (if needed) because err is returned by BaseStorage interface.
+				return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+			}
+			if !found {
+				data = nil
+			}
+			retrieved = true
+		}
+
+		snapshot.slabs[id] = data
+	}
+
+	return nil
+}
+
+// snapshotBaseStorage is read-only BaseStorage which returns slab data
+// in snapshot, or slab data in underlying base storage if slab isn't
+// in snapshot.
+type snapshotBaseStorage struct {
+	BaseStorage
+	snapshot map[SlabID][]byte
+}
+
+var _ BaseStorage = &snapshotBaseStorage{}
+
+func (s *snapshotBaseStorage) Retrieve(id SlabID) ([]byte, bool, error) {
+	if data, ok := s.snapshot[id]; ok {
+		return data, data != nil, nil
+	}
+	return s.BaseStorage.Retrieve(id)
+}
+
+func (s *snapshotBaseStorage) Store(id SlabID, _ []byte) error {
+	return NewUserError(fmt.Errorf("failed to store slab %s: snapshot is read-only", id))
+}
+
+func (s *snapshotBaseStorage) Remove(id SlabID) error {
+	return NewUserError(fmt.Errorf("failed to remove slab %s: snapshot is read-only", id))
+}
+
+func (s *snapshotBaseStorage) GenerateSlabID(address Address) (SlabID, error) {
+	return SlabIDUndefined, NewUserError(fmt.Errorf("failed to generate slab ID for address 0x%x: snapshot is read-only", address))
+}
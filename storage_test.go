@@ -21,10 +21,13 @@ package atree_test
 import (
 	"encoding/binary"
 	"errors"
+	"math"
 	"math/rand"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/stretchr/testify/require"
@@ -415,6 +418,124 @@ func TestLedgerBaseStorageGenerateSlabID(t *testing.T) {
 	require.Equal(t, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1}, id.Index())
 }
 
+func TestBaseStorageUsageScope(t *testing.T) {
+
+	test := func(t *testing.T, baseStorage atree.UsageScopedBaseStorage) {
+		id1 := atree.NewSlabID(atree.Address{1}, atree.SlabIndex{1})
+		id2 := atree.NewSlabID(atree.Address{1}, atree.SlabIndex{2})
+		id3 := atree.NewSlabID(atree.Address{1}, atree.SlabIndex{3})
+
+		// Usage before any scope isn't accumulated by scopes.
+		err := baseStorage.Store(id1, []byte{1, 2, 3})
+		require.NoError(t, err)
+
+		// First scope
+		scope1 := baseStorage.BeginUsageScope()
+		require.Equal(t, atree.UsageReport{}, scope1.Report())
+
+		err = baseStorage.Store(id2, []byte{4, 5, 6, 7})
+		require.NoError(t, err)
+
+		_, _, err = baseStorage.Retrieve(id1)
+		require.NoError(t, err)
+
+		scope1.End()
+
+		expectedReport1 := atree.UsageReport{
+			BytesRetrieved:   3,
+			BytesStored:      4,
+			SegmentsReturned: 1,
+			SegmentsUpdated:  1,
+			SegmentsTouched:  2,
+		}
+		require.Equal(t, expectedReport1, scope1.Report())
+
+		// Second scope
+		scope2 := baseStorage.BeginUsageScope()
+
+		err = baseStorage.Store(id3, []byte{8})
+		require.NoError(t, err)
+
+		// Nested scope only accumulates usage after it begins.
+		scope3 := baseStorage.BeginUsageScope()
+
+		err = baseStorage.Remove(id1)
+		require.NoError(t, err)
+
+		_, _, err = baseStorage.Retrieve(id2)
+		require.NoError(t, err)
+
+		scope3.End()
+		scope2.End()
+
+		// Usage after all scopes ended isn't accumulated by scopes.
+		err = baseStorage.Store(id1, []byte{9, 10})
+		require.NoError(t, err)
+
+		// First scope is isolated from second scope.
+		require.Equal(t, expectedReport1, scope1.Report())
+
+		require.Equal(
+			t,
+			atree.UsageReport{
+				BytesRetrieved:   4,
+				BytesStored:      1,
+				SegmentsReturned: 1,
+				SegmentsUpdated:  2,
+				SegmentsTouched:  3,
+			},
+			scope2.Report())
+
+		require.Equal(
+			t,
+			atree.UsageReport{
+				BytesRetrieved:   4,
+				SegmentsReturned: 1,
+				SegmentsUpdated:  1,
+				SegmentsTouched:  2,
+			},
+			scope3.Report())
+
+		// Global reporter includes all usage.
+		require.Equal(t, 3+4+1+2, baseStorage.BytesStored())
+		require.Equal(t, 3+4, baseStorage.BytesRetrieved())
+
+		// Resetting global reporter doesn't affect scopes.
+		baseStorage.ResetReporter()
+		require.Equal(t, expectedReport1, scope1.Report())
+	}
+
+	t.Run("InMemBaseStorage", func(t *testing.T) {
+		test(t, test_utils.NewInMemBaseStorage())
+	})
+
+	t.Run("LedgerBaseStorage", func(t *testing.T) {
+		test(t, atree.NewLedgerBaseStorage(newTestLedger()))
+	})
+
+	t.Run("End releases scope", func(t *testing.T) {
+		var scopes atree.UsageScopes
+
+		scope1 := scopes.BeginUsageScope()
+		scope2 := scopes.BeginUsageScope()
+		require.Equal(t, 2, scopes.ActiveCount())
+
+		scope1.End()
+		require.Equal(t, 1, scopes.ActiveCount())
+
+		// End is idempotent.
+		scope1.End()
+		require.Equal(t, 1, scopes.ActiveCount())
+
+		scopes.RecordStore(atree.NewSlabID(atree.Address{1}, atree.SlabIndex{1}), 1)
+		require.Equal(t, atree.UsageReport{}, scope1.Report())
+		require.Equal(t, 1, scope2.Report().BytesStored)
+
+		scope2.End()
+		require.Equal(t, 0, scopes.ActiveCount())
+	})
+}
+
 func TestBasicSlabStorageStore(t *testing.T) {
 	storage := atree.NewBasicSlabStorage(nil, nil, nil, nil)
 
@@ -1302,6 +1423,47 @@ func (s *accessOrderTrackerBaseStorage) SegmentsTouched() int { return 0 }
 
 func (s *accessOrderTrackerBaseStorage) ResetReporter() {}
 
+// versionedBaseStorage is a VersionedBaseStorage which keeps all stored versions
+// of each slab, so it can simulate stale reads from a lagging replica.
+type versionedBaseStorage struct {
+	*test_utils.InMemBaseStorage
+	versions map[atree.SlabID][][]byte
+	stale    bool // return previous version of slabs if stale is true
+}
+
+var _ atree.VersionedBaseStorage = &versionedBaseStorage{}
+
+func newVersionedBaseStorage() *versionedBaseStorage {
+	return &versionedBaseStorage{
+		InMemBaseStorage: test_utils.NewInMemBaseStorage(),
+		versions:         make(map[atree.SlabID][][]byte),
+	}
+}
+
+func (s *versionedBaseStorage) Store(id atree.SlabID, data []byte) error {
+	s.versions[id] = append(s.versions[id], data)
+	return s.InMemBaseStorage.Store(id, data)
+}
+
+func (s *versionedBaseStorage) Retrieve(id atree.SlabID) ([]byte, bool, error) {
+	data, _, ok, err := s.RetrieveVersioned(id)
+	return data, ok, err
+}
+
+func (s *versionedBaseStorage) RetrieveVersioned(id atree.SlabID) ([]byte, uint64, bool, error) {
+	versions := s.versions[id]
+	if len(versions) == 0 {
+		return nil, 0, false, nil
+	}
+
+	version := len(versions)
+	if s.stale && version > 1 {
+		version--
+	}
+
+	return versions[version-1], uint64(version), true, nil
+}
+
 type testLedger struct {
 	values map[string][]byte
 	index  map[string]atree.SlabIndex
@@ -5198,3 +5360,2348 @@ func TestStorageBatchPreloadNotFoundSlabs(t *testing.T) {
 		}
 	})
 }
+
+func TestDecodeSlabPanicRecovery(t *testing.T) {
+
+	encMode, err := cbor.EncOptions{}.EncMode()
+	require.NoError(t, err)
+
+	decMode, err := cbor.DecOptions{}.DecMode()
+	require.NoError(t, err)
+
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+
+	// Create encoded slabs with valid data.
+	storage := newTestBasicStorage(t)
+
+	array, err := atree.NewArray(storage, address, typeInfo)
+	require.NoError(t, err)
+
+	for i := range 10 {
+		err = array.Append(test_utils.Uint64Value(i))
+		require.NoError(t, err)
+	}
+
+	rootID := array.SlabID()
+
+	encodedSlabs, err := storage.Encode()
+	require.NoError(t, err)
+
+	// panicStorableDecoder simulates a panic deep inside storable decoding.
+	panicStorableDecoder := func(*cbor.StreamDecoder, atree.SlabID, []atree.ExtraData) (atree.Storable, error) {
+		panic("corrupt storable")
+	}
+
+	t.Run("DecodeSlab", func(t *testing.T) {
+		slab, err := atree.DecodeSlab(rootID, encodedSlabs[rootID], decMode, panicStorableDecoder, test_utils.DecodeTypeInfo)
+		require.Nil(t, slab)
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var fatalError *atree.FatalError
+		var decodingError *atree.DecodingError
+		var decodingPanicError *atree.DecodingPanicError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &decodingError)
+		require.ErrorAs(t, err, &decodingPanicError)
+		require.Equal(t, rootID, decodingPanicError.SlabID())
+		require.NotEmpty(t, decodingPanicError.Stack)
+		require.Contains(t, err.Error(), "corrupt storable")
+
+		// Error message only includes a few stack frames starting from
+		// the frame which panicked, and Stack has the full stack trace.
+		require.Contains(t, string(decodingPanicError.Stack), "runtime/debug.Stack")
+		require.NotContains(t, err.Error(), "runtime/debug.Stack")
+		require.Contains(t, err.Error(), "TestDecodeSlabPanicRecovery.func1")
+		require.Less(t, strings.Count(err.Error(), "\n"), strings.Count(string(decodingPanicError.Stack), "\n"))
+		require.LessOrEqual(t, strings.Count(decodingPanicError.Error(), "\n"), 2*5+1)
+	})
+
+	t.Run("BasicSlabStorage.Load", func(t *testing.T) {
+		storage := atree.NewBasicSlabStorage(encMode, decMode, panicStorableDecoder, test_utils.DecodeTypeInfo)
+
+		err := storage.Load(encodedSlabs)
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var decodingPanicError *atree.DecodingPanicError
+		require.ErrorAs(t, err, &decodingPanicError)
+	})
+
+	t.Run("PersistentSlabStorage.Retrieve", func(t *testing.T) {
+		baseStorage := test_utils.NewInMemBaseStorageFromMap(encodedSlabs)
+		storage := atree.NewPersistentSlabStorage(baseStorage, encMode, decMode, panicStorableDecoder, test_utils.DecodeTypeInfo)
+
+		_, err := atree.NewArrayWithRootID(storage, rootID)
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var decodingPanicError *atree.DecodingPanicError
+		require.ErrorAs(t, err, &decodingPanicError)
+		require.Equal(t, rootID, decodingPanicError.SlabID())
+	})
+
+	t.Run("recovery disabled", func(t *testing.T) {
+		prev := atree.SetRecoverDecodingPanic(false)
+		defer atree.SetRecoverDecodingPanic(prev)
+
+		require.Panics(t, func() {
+			_, _ = atree.DecodeSlab(rootID, encodedSlabs[rootID], decMode, panicStorableDecoder, test_utils.DecodeTypeInfo)
+		})
+	})
+
+	t.Run("valid data", func(t *testing.T) {
+		storage := newTestBasicStorage(t)
+
+		err := storage.Load(encodedSlabs)
+		require.NoError(t, err)
+
+		array, err := atree.NewArrayWithRootID(storage, rootID)
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), array.Count())
+	})
+}
+
+func TestInMemBaseStorageSnapshot(t *testing.T) {
+
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	baseStorage := test_utils.NewInMemBaseStorage()
+
+	id1, err := baseStorage.GenerateSlabID(address)
+	require.NoError(t, err)
+
+	id2, err := baseStorage.GenerateSlabID(address)
+	require.NoError(t, err)
+
+	data1 := []byte{1, 2, 3}
+	data2 := []byte{4, 5, 6}
+
+	err = baseStorage.Store(id1, data1)
+	require.NoError(t, err)
+
+	err = baseStorage.Store(id2, data2)
+	require.NoError(t, err)
+
+	snapshot := baseStorage.Snapshot()
+
+	// Reporters of snapshot are reset
+	require.Equal(t, 0, snapshot.BytesStored())
+	require.Equal(t, 0, snapshot.SegmentsUpdated())
+	require.Equal(t, 0, snapshot.SegmentsTouched())
+
+	// Snapshot has the same segments
+	require.Equal(t, baseStorage.SegmentCounts(), snapshot.SegmentCounts())
+
+	data, found, err := snapshot.Retrieve(id1)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, []byte{1, 2, 3}, data)
+
+	// Mutate snapshot
+	data[0] = 0xff
+
+	err = snapshot.Store(id2, []byte{7, 8, 9})
+	require.NoError(t, err)
+
+	id3, err := snapshot.GenerateSlabID(address)
+	require.NoError(t, err)
+	require.Equal(t, atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 3}), id3)
+
+	err = snapshot.Store(id3, []byte{10})
+	require.NoError(t, err)
+
+	err = snapshot.Remove(id1)
+	require.NoError(t, err)
+
+	// Original storage is unchanged
+	require.Equal(t, 2, baseStorage.SegmentCounts())
+
+	data, found, err = baseStorage.Retrieve(id1)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, []byte{1, 2, 3}, data)
+
+	data, found, err = baseStorage.Retrieve(id2)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, []byte{4, 5, 6}, data)
+
+	_, found, err = baseStorage.Retrieve(id3)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// Original storage generates the same slab ID independently
+	id, err := baseStorage.GenerateSlabID(address)
+	require.NoError(t, err)
+	require.Equal(t, id3, id)
+}
+
+func TestFindOrphanedSlabs(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	newSlabIDs := func(before, after []atree.SlabID) []atree.SlabID {
+		seen := make(map[atree.SlabID]struct{}, len(before))
+		for _, id := range before {
+			seen[id] = struct{}{}
+		}
+
+		var ids []atree.SlabID
+		for _, id := range after {
+			if _, ok := seen[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		slices.SortFunc(ids, atree.SlabID.Compare)
+		return ids
+	}
+
+	newArrayWithNestedElements := func(t *testing.T, storage *atree.BasicSlabStorage) *atree.Array {
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range 100 {
+			// Large string is stored in external StorableSlab.
+			err = array.Append(test_utils.NewStringValue(strings.Repeat("a", 300+i)))
+			require.NoError(t, err)
+
+			childArray, err := atree.NewArray(storage, address, typeInfo)
+			require.NoError(t, err)
+
+			for j := range 50 {
+				err = childArray.Append(test_utils.Uint64Value(j))
+				require.NoError(t, err)
+			}
+
+			err = array.Append(childArray)
+			require.NoError(t, err)
+
+			childMap, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+			require.NoError(t, err)
+
+			existingStorable, err := childMap.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			// Inlined child map
+			err = array.Append(childMap)
+			require.NoError(t, err)
+		}
+
+		return array
+	}
+
+	t.Run("no orphaned slabs", func(t *testing.T) {
+		storage := newTestBasicStorage(t)
+
+		array := newArrayWithNestedElements(t, storage)
+
+		orphaned, err := atree.FindOrphanedSlabs(storage, []atree.SlabID{array.SlabID()})
+		require.NoError(t, err)
+		require.Empty(t, orphaned)
+	})
+
+	t.Run("leaked slabs", func(t *testing.T) {
+		storage := newTestBasicStorage(t)
+
+		array := newArrayWithNestedElements(t, storage)
+
+		// Leak a storable slab by storing it without linking it to any container.
+		before := storage.SlabIDs()
+
+		storable, err := atree.NewStorableSlab(storage, address, test_utils.NewStringValue(strings.Repeat("b", 1000)))
+		require.NoError(t, err)
+		require.IsType(t, atree.SlabIDStorable{}, storable)
+
+		leakedStorableSlabIDs := newSlabIDs(before, storage.SlabIDs())
+		require.Equal(t, 1, len(leakedStorableSlabIDs))
+
+		// Leak all slabs of a container by not including it in roots.
+		before = storage.SlabIDs()
+
+		leakedArray := newArrayWithNestedElements(t, storage)
+
+		leakedArraySlabIDs := newSlabIDs(before, storage.SlabIDs())
+		require.True(t, len(leakedArraySlabIDs) > 1)
+
+		expected := append(leakedStorableSlabIDs, leakedArraySlabIDs...)
+		slices.SortFunc(expected, atree.SlabID.Compare)
+
+		orphaned, err := atree.FindOrphanedSlabs(storage, []atree.SlabID{array.SlabID()})
+		require.NoError(t, err)
+		require.Equal(t, expected, orphaned)
+
+		// Include leaked array in roots.
+		orphaned, err = atree.FindOrphanedSlabs(storage, []atree.SlabID{array.SlabID(), leakedArray.SlabID()})
+		require.NoError(t, err)
+		require.Equal(t, leakedStorableSlabIDs, orphaned)
+	})
+
+	t.Run("root not found", func(t *testing.T) {
+		storage := newTestBasicStorage(t)
+
+		rootID := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1})
+
+		orphaned, err := atree.FindOrphanedSlabs(storage, []atree.SlabID{rootID})
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var slabNotFoundError *atree.SlabNotFoundError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &slabNotFoundError)
+		require.Nil(t, orphaned)
+	})
+}
+
+func TestPersistentStorageMonotonicSlabVersions(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const mapCount = 200
+
+	encMode, err := cbor.EncOptions{}.EncMode()
+	require.NoError(t, err)
+
+	decMode, err := cbor.DecOptions{}.DecMode()
+	require.NoError(t, err)
+
+	newStorage := func(baseStorage atree.BaseStorage, opts ...atree.StorageOption) *atree.PersistentSlabStorage {
+		return atree.NewPersistentSlabStorage(
+			baseStorage,
+			encMode,
+			decMode,
+			test_utils.DecodeStorable,
+			test_utils.DecodeTypeInfo,
+			opts...,
+		)
+	}
+
+	// setupBaseStorage returns base storage with two versions of map slabs:
+	// the first version has even keys and the second version has all keys.
+	setupBaseStorage := func(t *testing.T) (*versionedBaseStorage, atree.SlabID) {
+		baseStorage := newVersionedBaseStorage()
+
+		storage := newStorage(baseStorage)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := 0; i < mapCount; i += 2 {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		for i := 1; i < mapCount; i += 2 {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		require.False(t, IsMapRootDataSlab(m))
+
+		return baseStorage, m.SlabID()
+	}
+
+	getAll := func(t *testing.T, m *atree.OrderedMap) error {
+		for i := range mapCount {
+			v, err := m.Get(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i))
+			if err != nil {
+				return err
+			}
+			require.Equal(t, test_utils.Uint64Value(i), v)
+		}
+		return nil
+	}
+
+	t.Run("without option", func(t *testing.T) {
+		baseStorage, rootSlabID := setupBaseStorage(t)
+
+		storage := newStorage(baseStorage)
+
+		m, err := atree.NewMapWithRootID(storage, rootSlabID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		err = getAll(t, m)
+		require.NoError(t, err)
+
+		// Stale read isn't detected and surfaces as missing key.
+		storage.DropCache()
+		baseStorage.stale = true
+
+		err = getAll(t, m)
+		var keyNotFoundError *atree.KeyNotFoundError
+		require.ErrorAs(t, err, &keyNotFoundError)
+	})
+
+	t.Run("with option", func(t *testing.T) {
+		baseStorage, rootSlabID := setupBaseStorage(t)
+
+		storage := newStorage(baseStorage, atree.WithMonotonicSlabVersions())
+
+		m, err := atree.NewMapWithRootID(storage, rootSlabID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		err = getAll(t, m)
+		require.NoError(t, err)
+
+		// Retrieving same versions again is allowed.
+		storage.DropCache()
+
+		err = getAll(t, m)
+		require.NoError(t, err)
+
+		// Stale read is detected.
+		storage.DropCache()
+		baseStorage.stale = true
+
+		err = getAll(t, m)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var staleReadError *atree.StaleReadError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &staleReadError)
+	})
+
+	t.Run("with option and unversioned base storage", func(t *testing.T) {
+		baseStorage, rootSlabID := setupBaseStorage(t)
+
+		// Option has no effect if base storage isn't VersionedBaseStorage.
+		storage := newStorage(baseStorage.InMemBaseStorage, atree.WithMonotonicSlabVersions())
+
+		m, err := atree.NewMapWithRootID(storage, rootSlabID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		err = getAll(t, m)
+		require.NoError(t, err)
+	})
+}
+
+func TestEncodeSlabByID(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	encMode, err := cbor.EncOptions{}.EncMode()
+	require.NoError(t, err)
+
+	storage := newTestBasicStorage(t)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	for i := range 100 {
+		childArray, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for j := range 20 {
+			err = childArray.Append(test_utils.Uint64Value(j))
+			require.NoError(t, err)
+		}
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), childArray)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+	}
+
+	encodedSlabs, err := storage.Encode()
+	require.NoError(t, err)
+	require.True(t, len(encodedSlabs) > 1)
+
+	for id, expected := range encodedSlabs {
+		data, err := atree.EncodeSlabByID(storage, id, encMode)
+		require.NoError(t, err)
+		require.Equal(t, expected, data)
+	}
+
+	t.Run("slab not found", func(t *testing.T) {
+		id := atree.NewSlabID(address, atree.SlabIndex{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+		data, err := atree.EncodeSlabByID(storage, id, encMode)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var slabNotFoundError *atree.SlabNotFoundError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &slabNotFoundError)
+		require.Nil(t, data)
+	})
+}
+
+func TestPersistentStorageTempSlabID(t *testing.T) {
+
+	t.Run("unique", func(t *testing.T) {
+		const count = 100_000
+
+		storage := newTestPersistentStorage(t)
+
+		ids := make(map[atree.SlabID]struct{}, count)
+		for range count {
+			id, err := storage.GenerateSlabID(atree.AddressUndefined)
+			require.NoError(t, err)
+			require.Equal(t, atree.AddressUndefined, id.Address())
+
+			_, exists := ids[id]
+			require.False(t, exists)
+
+			ids[id] = struct{}{}
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		atree.SetPersistentSlabStorageTempSlabIndex(storage, math.MaxUint64-1)
+
+		id, err := storage.GenerateSlabID(atree.AddressUndefined)
+		require.NoError(t, err)
+		require.Equal(t, atree.NewSlabID(atree.AddressUndefined, atree.SlabIndex{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}), id)
+
+		id, err = storage.GenerateSlabID(atree.AddressUndefined)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var slabIDError *atree.SlabIDError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &slabIDError)
+		require.Equal(t, atree.SlabIDUndefined, id)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		typeInfo := test_utils.NewSimpleTypeInfo(42)
+
+		storage := newTestPersistentStorage(t)
+
+		id1, err := storage.GenerateSlabID(atree.AddressUndefined)
+		require.NoError(t, err)
+
+		// Reset without temp slabs in storage.
+		err = storage.ResetTempIndex()
+		require.NoError(t, err)
+
+		id2, err := storage.GenerateSlabID(atree.AddressUndefined)
+		require.NoError(t, err)
+		require.Equal(t, id1, id2)
+
+		// Reset with temp slab in storage.
+		array, err := atree.NewArray(storage, atree.AddressUndefined, typeInfo, atree.AllowTempAddress())
+		require.NoError(t, err)
+
+		err = storage.ResetTempIndex()
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var slabIDError *atree.SlabIDError
+		require.ErrorAs(t, err, &slabIDError)
+
+		// Reset after temp slab is removed.
+		err = storage.Remove(array.SlabID())
+		require.NoError(t, err)
+
+		err = storage.ResetTempIndex()
+		require.NoError(t, err)
+
+		id3, err := storage.GenerateSlabID(atree.AddressUndefined)
+		require.NoError(t, err)
+		require.Equal(t, id1, id3)
+	})
+}
+
+func TestPersistentStorageSlabGeneration(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	encMode, err := cbor.EncOptions{}.EncMode()
+	require.NoError(t, err)
+
+	decMode, err := cbor.DecOptions{}.DecMode()
+	require.NoError(t, err)
+
+	newStorage := func(baseStorage atree.BaseStorage, opts ...atree.StorageOption) *atree.PersistentSlabStorage {
+		return atree.NewPersistentSlabStorage(
+			baseStorage,
+			encMode,
+			decMode,
+			test_utils.DecodeStorable,
+			test_utils.DecodeTypeInfo,
+			opts...,
+		)
+	}
+
+	t.Run("without option", func(t *testing.T) {
+		storage := newStorage(test_utils.NewInMemBaseStorage())
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array.Append(test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		generation, exists := storage.SlabGeneration(array.SlabID())
+		require.False(t, exists)
+		require.Equal(t, uint64(0), generation)
+	})
+
+	commitFuncs := map[string]func(*atree.PersistentSlabStorage) error{
+		"Commit": func(storage *atree.PersistentSlabStorage) error {
+			return storage.Commit()
+		},
+		"FastCommit": func(storage *atree.PersistentSlabStorage) error {
+			return storage.FastCommit(2)
+		},
+		"NondeterministicFastCommit": func(storage *atree.PersistentSlabStorage) error {
+			return storage.NondeterministicFastCommit(2)
+		},
+	}
+
+	for name, commit := range commitFuncs {
+		t.Run(name, func(t *testing.T) {
+			baseStorage := test_utils.NewInMemBaseStorage()
+
+			storage := newStorage(baseStorage, atree.WithSlabGenerations())
+
+			array, err := atree.NewArray(storage, address, typeInfo)
+			require.NoError(t, err)
+
+			rootID := array.SlabID()
+
+			requireGeneration := func(t *testing.T, storage *atree.PersistentSlabStorage, expected uint64) {
+				generation, exists := storage.SlabGeneration(rootID)
+				require.True(t, exists)
+				require.Equal(t, expected, generation)
+			}
+
+			// Slab isn't committed yet.
+			_, exists := storage.SlabGeneration(rootID)
+			require.False(t, exists)
+
+			err = array.Append(test_utils.Uint64Value(0))
+			require.NoError(t, err)
+
+			err = commit(storage)
+			require.NoError(t, err)
+			requireGeneration(t, storage, 0)
+
+			// Commit without changes doesn't increment generation.
+			err = commit(storage)
+			require.NoError(t, err)
+			requireGeneration(t, storage, 0)
+
+			// Commit with same content doesn't increment generation.
+			existingStorable, err := array.Set(0, test_utils.Uint64Value(0))
+			require.NoError(t, err)
+			require.Equal(t, test_utils.Uint64Value(0), existingStorable)
+			require.True(t, storage.HasUnsavedChanges(address))
+
+			err = commit(storage)
+			require.NoError(t, err)
+			requireGeneration(t, storage, 0)
+
+			// Commit with different content increments generation.
+			err = array.Append(test_utils.Uint64Value(1))
+			require.NoError(t, err)
+
+			err = commit(storage)
+			require.NoError(t, err)
+			requireGeneration(t, storage, 1)
+
+			// Generation starts at 0 for slab retrieved by new storage.
+			storage2 := newStorage(baseStorage, atree.WithSlabGenerations())
+
+			array2, err := atree.NewArrayWithRootID(storage2, rootID)
+			require.NoError(t, err)
+			requireGeneration(t, storage2, 0)
+
+			// Commit with same content as retrieved doesn't increment generation.
+			_, err = array2.Set(1, test_utils.Uint64Value(1))
+			require.NoError(t, err)
+
+			err = commit(storage2)
+			require.NoError(t, err)
+			requireGeneration(t, storage2, 0)
+
+			// Commit with different content increments generation.
+			_, err = array2.Set(1, test_utils.Uint64Value(2))
+			require.NoError(t, err)
+
+			err = commit(storage2)
+			require.NoError(t, err)
+			requireGeneration(t, storage2, 1)
+
+			// Removing slab increments generation.
+			err = storage2.Remove(rootID)
+			require.NoError(t, err)
+
+			err = commit(storage2)
+			require.NoError(t, err)
+			requireGeneration(t, storage2, 2)
+		})
+	}
+}
+
+func TestPersistentStorageEstimateCommit(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("no changes", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		bytesToWrite, bytesToRemove, slabsAffected, err := storage.EstimateCommit()
+		require.NoError(t, err)
+		require.Equal(t, 0, bytesToWrite)
+		require.Equal(t, 0, bytesToRemove)
+		require.Equal(t, 0, slabsAffected)
+	})
+
+	t.Run("write", func(t *testing.T) {
+		const arrayCount = 1024
+
+		baseStorage := test_utils.NewInMemBaseStorage()
+
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(arrayCount) {
+			err := array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		// Temp slabs aren't committed.
+		tempArray, err := atree.NewArray(storage, atree.AddressUndefined, typeInfo, atree.AllowTempAddress())
+		require.NoError(t, err)
+
+		err = tempArray.Append(test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		bytesToWrite, bytesToRemove, slabsAffected, err := storage.EstimateCommit()
+		require.NoError(t, err)
+		require.Equal(t, 0, bytesToRemove)
+		require.Equal(t, int(storage.DeltasWithoutTempAddresses()), slabsAffected)
+
+		// Estimating commit doesn't change base storage.
+		require.Equal(t, 0, baseStorage.BytesStored())
+		require.Equal(t, 0, baseStorage.SegmentCounts())
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		require.Equal(t, baseStorage.BytesStored(), bytesToWrite)
+		require.Equal(t, baseStorage.SegmentCounts(), slabsAffected)
+
+		// Modify some elements and estimate again.
+		for i := uint64(0); i < arrayCount; i += 100 {
+			_, err := array.Set(i, test_utils.Uint64Value(i*2))
+			require.NoError(t, err)
+		}
+
+		bytesStored := baseStorage.BytesStored()
+
+		bytesToWrite, bytesToRemove, slabsAffected, err = storage.EstimateCommit()
+		require.NoError(t, err)
+		require.Equal(t, 0, bytesToRemove)
+		require.Equal(t, int(storage.DeltasWithoutTempAddresses()), slabsAffected)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		require.Equal(t, baseStorage.BytesStored()-bytesStored, bytesToWrite)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		baseStorage := test_utils.NewInMemBaseStorage()
+
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array.Append(test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		rootID := array.SlabID()
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		size := baseStorage.Size()
+
+		for _, cached := range []bool{true, false} {
+			storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			if cached {
+				_, found, err := storage.Retrieve(rootID)
+				require.NoError(t, err)
+				require.True(t, found)
+			}
+
+			err = storage.Remove(rootID)
+			require.NoError(t, err)
+
+			segmentsReturned := baseStorage.SegmentsReturned()
+
+			bytesToWrite, bytesToRemove, slabsAffected, err := storage.EstimateCommit()
+			require.NoError(t, err)
+			require.Equal(t, 0, bytesToWrite)
+			require.Equal(t, 1, slabsAffected)
+
+			// Base storage isn't read by EstimateCommit.
+			require.Equal(t, segmentsReturned, baseStorage.SegmentsReturned())
+
+			if cached {
+				require.Equal(t, size, bytesToRemove)
+			} else {
+				// Size of removed slab which isn't cached isn't included.
+				require.Equal(t, 0, bytesToRemove)
+			}
+		}
+
+		err = storage.Remove(rootID)
+		require.NoError(t, err)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+		require.Equal(t, 0, baseStorage.Size())
+	})
+}
+
+func TestPersistentStorageCommitAddress(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address1 := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+	address2 := atree.Address{2, 3, 4, 5, 6, 7, 8, 9}
+
+	const arrayCount = 1024
+
+	segments := make(map[atree.SlabID][]byte)
+	baseStorage := test_utils.NewInMemBaseStorageFromMap(segments)
+
+	storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	array1, err := atree.NewArray(storage, address1, typeInfo)
+	require.NoError(t, err)
+
+	array2, err := atree.NewArray(storage, address2, typeInfo)
+	require.NoError(t, err)
+
+	for i := range uint64(arrayCount) {
+		err := array1.Append(test_utils.Uint64Value(i))
+		require.NoError(t, err)
+
+		err = array2.Append(test_utils.Uint64Value(i))
+		require.NoError(t, err)
+	}
+
+	require.True(t, storage.HasUnsavedChanges(address1))
+	require.True(t, storage.HasUnsavedChanges(address2))
+
+	deltas := storage.Deltas()
+
+	// Commit address1 only.
+	err = storage.CommitAddress(address1)
+	require.NoError(t, err)
+
+	require.False(t, storage.HasUnsavedChanges(address1))
+	require.True(t, storage.HasUnsavedChanges(address2))
+
+	// Only slabs owned by address1 are stored in base storage.
+	committed := uint(baseStorage.SegmentCounts())
+	require.True(t, committed > 0)
+	require.Equal(t, deltas-committed, storage.Deltas())
+
+	for id := range segments {
+		require.Equal(t, address1, id.Address())
+	}
+
+	// Commit of address with no changes is no-op.
+	err = storage.CommitAddress(address1)
+	require.NoError(t, err)
+	require.Equal(t, committed, uint(baseStorage.SegmentCounts()))
+
+	// Commit remaining address.
+	err = storage.CommitAddress(address2)
+	require.NoError(t, err)
+
+	require.False(t, storage.HasUnsavedChanges(address2))
+	require.Equal(t, uint(0), storage.Deltas())
+	require.Equal(t, deltas, uint(baseStorage.SegmentCounts()))
+
+	// Committed arrays can be loaded from base storage.
+	storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+	for _, rootID := range []atree.SlabID{array1.SlabID(), array2.SlabID()} {
+		array, err := atree.NewArrayWithRootID(storage2, rootID)
+		require.NoError(t, err)
+		require.Equal(t, uint64(arrayCount), array.Count())
+	}
+}
+
+var errFlakyRead = errors.New("flaky read")
+
+// flakyBaseStorage is a RetryableBaseStorage which fails
+// retrieving slabs with given error while failing is true.
+type flakyBaseStorage struct {
+	*test_utils.InMemBaseStorage
+	err     error
+	failing bool
+}
+
+var _ atree.RetryableBaseStorage = &flakyBaseStorage{}
+
+func (s *flakyBaseStorage) Retrieve(id atree.SlabID) ([]byte, bool, error) {
+	if s.failing {
+		return nil, false, s.err
+	}
+	return s.InMemBaseStorage.Retrieve(id)
+}
+
+func (s *flakyBaseStorage) IsRetryable(err error) bool {
+	return errors.Is(err, errFlakyRead)
+}
+
+func TestPersistentStorageRetryableRetrieveError(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	newCommittedArray := func(t *testing.T, baseStorage atree.BaseStorage) atree.SlabID {
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		err = array.Append(test_utils.Uint64Value(0))
+		require.NoError(t, err)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		return array.SlabID()
+	}
+
+	testCases := []struct {
+		name      string
+		err       error
+		temporary bool
+	}{
+		{name: "temporary", err: errFlakyRead, temporary: true},
+		{name: "wrapped temporary", err: errors.Join(errors.New("ledger"), errFlakyRead), temporary: true},
+		{name: "permanent", err: errors.New("corrupted"), temporary: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			baseStorage := &flakyBaseStorage{
+				InMemBaseStorage: test_utils.NewInMemBaseStorage(),
+				err:              tc.err,
+			}
+
+			rootID := newCommittedArray(t, baseStorage)
+
+			baseStorage.failing = true
+
+			storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+			_, _, err := storage.Retrieve(rootID)
+			require.Equal(t, 1, errorCategorizationCount(err))
+			require.ErrorIs(t, err, tc.err)
+
+			var externalError *atree.ExternalError
+			require.ErrorAs(t, err, &externalError)
+			require.Equal(t, tc.temporary, externalError.Temporary())
+			require.Equal(t, tc.temporary, atree.IsTemporaryError(err))
+
+			// Retry succeeds after transient failure is gone.
+			baseStorage.failing = false
+
+			slab, found, err := storage.Retrieve(rootID)
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Equal(t, rootID, slab.SlabID())
+		})
+	}
+
+	t.Run("error implementing Temporary", func(t *testing.T) {
+		baseStorage := newAccessOrderTrackerBaseStorage()
+
+		storage := newTestPersistentStorageWithBaseStorage(t, &temporaryErrorBaseStorage{baseStorage})
+
+		_, _, err := storage.Retrieve(atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, 1}))
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.True(t, atree.IsTemporaryError(err))
+	})
+
+	t.Run("non-external error", func(t *testing.T) {
+		require.False(t, atree.IsTemporaryError(nil))
+		require.False(t, atree.IsTemporaryError(atree.NewUserError(errFlakyRead)))
+	})
+}
+
+type temporaryNetworkError struct{}
+
+func (temporaryNetworkError) Error() string   { return "network timeout" }
+func (temporaryNetworkError) Temporary() bool { return true }
+
+// temporaryErrorBaseStorage isn't RetryableBaseStorage, but its
+// errors implement Temporary().
+type temporaryErrorBaseStorage struct {
+	*accessOrderTrackerBaseStorage
+}
+
+func (s *temporaryErrorBaseStorage) Retrieve(atree.SlabID) ([]byte, bool, error) {
+	return nil, false, temporaryNetworkError{}
+}
+
+func TestPersistentStorageDeltaDeduplication(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	encMode, err := cbor.EncOptions{}.EncMode()
+	require.NoError(t, err)
+
+	decMode, err := cbor.DecOptions{}.DecMode()
+	require.NoError(t, err)
+
+	newStorage := func(baseStorage atree.BaseStorage, opts ...atree.StorageOption) *atree.PersistentSlabStorage {
+		return atree.NewPersistentSlabStorage(
+			baseStorage,
+			encMode,
+			decMode,
+			test_utils.DecodeStorable,
+			test_utils.DecodeTypeInfo,
+			opts...,
+		)
+	}
+
+	// setupMap returns base storage with committed map of one element.
+	setupMap := func(t *testing.T) (*test_utils.InMemBaseStorage, atree.SlabID) {
+		baseStorage := test_utils.NewInMemBaseStorage()
+
+		storage := newStorage(baseStorage)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(0), test_utils.Uint64Value(0))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		return baseStorage, m.SlabID()
+	}
+
+	// setAndRemove sets and removes the same key, which cancels out.
+	setAndRemove := func(t *testing.T, storage *atree.PersistentSlabStorage, rootID atree.SlabID) {
+		m, err := atree.NewMapWithRootID(storage, rootID, atree.NewDefaultDigesterBuilder())
+		require.NoError(t, err)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(1))
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+
+		_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1))
+		require.NoError(t, err)
+
+		require.Equal(t, uint(1), storage.DeltasWithoutTempAddresses())
+	}
+
+	commitFuncs := map[string]func(*atree.PersistentSlabStorage) error{
+		"Commit": func(storage *atree.PersistentSlabStorage) error {
+			return storage.Commit()
+		},
+		"FastCommit": func(storage *atree.PersistentSlabStorage) error {
+			return storage.FastCommit(2)
+		},
+		"NondeterministicFastCommit": func(storage *atree.PersistentSlabStorage) error {
+			return storage.NondeterministicFastCommit(2)
+		},
+	}
+
+	for name, commit := range commitFuncs {
+		t.Run(name, func(t *testing.T) {
+
+			t.Run("unchanged slab isn't stored", func(t *testing.T) {
+				baseStorage, rootID := setupMap(t)
+				baseStorage.ResetReporter()
+
+				storage := newStorage(baseStorage, atree.WithDeltaDeduplication())
+
+				setAndRemove(t, storage, rootID)
+
+				pending, err := storage.PendingRealChanges()
+				require.NoError(t, err)
+				require.Equal(t, 0, pending)
+
+				err = commit(storage)
+				require.NoError(t, err)
+
+				require.Equal(t, uint(0), storage.Deltas())
+				require.Equal(t, 0, baseStorage.SegmentsUpdated())
+
+				// Changed slab is stored.
+				m, err := atree.NewMapWithRootID(storage, rootID, atree.NewDefaultDigesterBuilder())
+				require.NoError(t, err)
+
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(1))
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+
+				pending, err = storage.PendingRealChanges()
+				require.NoError(t, err)
+				require.Equal(t, 1, pending)
+
+				err = commit(storage)
+				require.NoError(t, err)
+				require.Equal(t, 1, baseStorage.SegmentsUpdated())
+
+				// Storing committed data again is skipped.
+				_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1))
+				require.NoError(t, err)
+
+				existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(1))
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+
+				pending, err = storage.PendingRealChanges()
+				require.NoError(t, err)
+				require.Equal(t, 0, pending)
+
+				err = commit(storage)
+				require.NoError(t, err)
+				require.Equal(t, 1, baseStorage.SegmentsUpdated())
+
+				storage2 := newStorage(baseStorage)
+				m2, err := atree.NewMapWithRootID(storage2, rootID, atree.NewDefaultDigesterBuilder())
+				require.NoError(t, err)
+				require.Equal(t, uint64(2), m2.Count())
+			})
+
+			t.Run("slab changed after PendingRealChanges", func(t *testing.T) {
+				baseStorage, rootID := setupMap(t)
+
+				storage := newStorage(baseStorage, atree.WithDeltaDeduplication())
+
+				m, err := atree.NewMapWithRootID(storage, rootID, atree.NewDefaultDigesterBuilder())
+				require.NoError(t, err)
+
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(1))
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+
+				pending, err := storage.PendingRealChanges()
+				require.NoError(t, err)
+				require.Equal(t, 1, pending)
+
+				// Slab encoded by PendingRealChanges is changed again.
+				existingStorable, err = m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(2), test_utils.Uint64Value(2))
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+
+				err = commit(storage)
+				require.NoError(t, err)
+
+				storage2 := newStorage(baseStorage)
+				m2, err := atree.NewMapWithRootID(storage2, rootID, atree.NewDefaultDigesterBuilder())
+				require.NoError(t, err)
+				require.Equal(t, uint64(3), m2.Count())
+			})
+
+			t.Run("base storage changed by other storage", func(t *testing.T) {
+				baseStorage, rootID := setupMap(t)
+
+				storage := newStorage(baseStorage, atree.WithDeltaDeduplication())
+
+				// Checksum of map with one element is recorded.
+				m, err := atree.NewMapWithRootID(storage, rootID, atree.NewDefaultDigesterBuilder())
+				require.NoError(t, err)
+				require.Equal(t, uint64(1), m.Count())
+
+				// Other storage adds element.
+				storage2 := newStorage(baseStorage)
+				m2, err := atree.NewMapWithRootID(storage2, rootID, atree.NewDefaultDigesterBuilder())
+				require.NoError(t, err)
+
+				existingStorable, err := m2.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1), test_utils.Uint64Value(1))
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+
+				err = storage2.Commit()
+				require.NoError(t, err)
+
+				// Map is retrieved again after dropping cache, and added element is removed.
+				storage.DropCache()
+
+				m, err = atree.NewMapWithRootID(storage, rootID, atree.NewDefaultDigesterBuilder())
+				require.NoError(t, err)
+				require.Equal(t, uint64(2), m.Count())
+
+				_, _, err = m.Remove(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(1))
+				require.NoError(t, err)
+
+				// Data is the same as data first retrieved, but not as data in base storage.
+				pending, err := storage.PendingRealChanges()
+				require.NoError(t, err)
+				require.Equal(t, 1, pending)
+
+				err = commit(storage)
+				require.NoError(t, err)
+
+				storage3 := newStorage(baseStorage)
+				m3, err := atree.NewMapWithRootID(storage3, rootID, atree.NewDefaultDigesterBuilder())
+				require.NoError(t, err)
+				require.Equal(t, uint64(1), m3.Count())
+			})
+
+			t.Run("without deduplication", func(t *testing.T) {
+				baseStorage, rootID := setupMap(t)
+				baseStorage.ResetReporter()
+
+				storage := newStorage(baseStorage)
+
+				setAndRemove(t, storage, rootID)
+
+				pending, err := storage.PendingRealChanges()
+				require.NoError(t, err)
+				require.Equal(t, 1, pending)
+
+				err = commit(storage)
+				require.NoError(t, err)
+
+				require.Equal(t, 1, baseStorage.SegmentsUpdated())
+			})
+		})
+	}
+}
+
+var errTxStore = errors.New("failed to store slab in transaction")
+
+// txBaseStorage is a TransactionalBaseStorage which applies stored
+// and removed slabs to InMemBaseStorage when transaction is committed.
+type txBaseStorage struct {
+	*test_utils.InMemBaseStorage
+
+	// failStoreID is slab ID which fails to be stored in transaction.
+	failStoreID atree.SlabID
+
+	txCount int
+}
+
+var _ atree.TransactionalBaseStorage = &txBaseStorage{}
+
+func (s *txBaseStorage) BeginTx() (atree.BaseStorageTx, error) {
+	s.txCount++
+	return &baseStorageTx{storage: s, updates: make(map[atree.SlabID][]byte)}, nil
+}
+
+type baseStorageTx struct {
+	storage *txBaseStorage
+	// updates contains stored data, or nil for removed slab.
+	updates map[atree.SlabID][]byte
+}
+
+var _ atree.BaseStorageTx = &baseStorageTx{}
+
+func (tx *baseStorageTx) Store(id atree.SlabID, data []byte) error {
+	if id == tx.storage.failStoreID {
+		return errTxStore
+	}
+	tx.updates[id] = data
+	return nil
+}
+
+func (tx *baseStorageTx) Remove(id atree.SlabID) error {
+	tx.updates[id] = nil
+	return nil
+}
+
+func (tx *baseStorageTx) Commit() error {
+	for id, data := range tx.updates {
+		var err error
+		if data == nil {
+			err = tx.storage.InMemBaseStorage.Remove(id)
+		} else {
+			err = tx.storage.InMemBaseStorage.Store(id, data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	tx.updates = nil
+	return nil
+}
+
+func (tx *baseStorageTx) Rollback() error {
+	tx.updates = nil
+	return nil
+}
+
+func TestPersistentStorageTransactionalCommit(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const arrayCount = 256
+
+	test := func(t *testing.T, commit func(*atree.PersistentSlabStorage) error) {
+		baseStorage := &txBaseStorage{InMemBaseStorage: test_utils.NewInMemBaseStorage()}
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range arrayCount {
+			err := array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		deltas := storage.Deltas()
+		require.True(t, deltas > 1)
+
+		// Fail storing the last slab in transaction.
+		baseStorage.failStoreID = atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0, byte(deltas)})
+
+		err = commit(storage)
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var externalError *atree.ExternalError
+		require.ErrorAs(t, err, &externalError)
+		require.ErrorIs(t, err, errTxStore)
+
+		// Nothing is applied to base storage, and deltas are kept.
+		require.Equal(t, 1, baseStorage.txCount)
+		require.Equal(t, 0, baseStorage.SegmentCounts())
+		require.Equal(t, deltas, storage.Deltas())
+
+		// Commit again after fixing base storage.
+		baseStorage.failStoreID = atree.SlabIDUndefined
+
+		err = commit(storage)
+		require.NoError(t, err)
+
+		require.Equal(t, 2, baseStorage.txCount)
+		require.Equal(t, int(deltas), baseStorage.SegmentCounts())
+		require.Equal(t, uint(0), storage.Deltas())
+
+		// Removed slabs are applied in the same transaction.
+		for array.Count() > 1 {
+			_, err := array.Remove(array.Count() - 1)
+			require.NoError(t, err)
+		}
+
+		err = commit(storage)
+		require.NoError(t, err)
+
+		require.Equal(t, 3, baseStorage.txCount)
+		require.Equal(t, 1, baseStorage.SegmentCounts())
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+		array2, err := atree.NewArrayWithRootID(storage2, array.SlabID())
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), array2.Count())
+
+		v, err := array2.Get(0)
+		require.NoError(t, err)
+		require.Equal(t, test_utils.Uint64Value(0), v)
+	}
+
+	t.Run("Commit", func(t *testing.T) {
+		test(t, func(storage *atree.PersistentSlabStorage) error {
+			return storage.Commit()
+		})
+	})
+
+	t.Run("FastCommit", func(t *testing.T) {
+		test(t, func(storage *atree.PersistentSlabStorage) error {
+			return storage.FastCommit(2)
+		})
+	})
+
+	t.Run("NondeterministicFastCommit", func(t *testing.T) {
+		test(t, func(storage *atree.PersistentSlabStorage) error {
+			return storage.NondeterministicFastCommit(2)
+		})
+	})
+}
+
+func TestCheckChildTypeInfo(t *testing.T) {
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	parentTypeInfo := test_utils.NewSimpleTypeInfo(42)
+	childTypeInfo := test_utils.NewSimpleTypeInfo(43)
+	mistypedChildTypeInfo := test_utils.NewSimpleTypeInfo(44)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const (
+		mapCount        = 64
+		childArrayCount = 64
+	)
+
+	// setup returns parent map with child arrays stored in separate
+	// slabs, and slab ID of child array created with mistypedChildTypeInfo.
+	setup := func(t *testing.T) (atree.SlabStorage, *atree.OrderedMap, atree.SlabID) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), parentTypeInfo)
+		require.NoError(t, err)
+
+		var mistypedChildID atree.SlabID
+
+		for i := range mapCount {
+			typeInfo := childTypeInfo
+			if i == mapCount/2 {
+				typeInfo = mistypedChildTypeInfo
+			}
+
+			childArray, err := atree.NewArray(storage, address, typeInfo)
+			require.NoError(t, err)
+
+			for j := range childArrayCount {
+				err := childArray.Append(test_utils.Uint64Value(j))
+				require.NoError(t, err)
+			}
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), childArray)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+			require.False(t, childArray.Inlined())
+
+			if i == mapCount/2 {
+				mistypedChildID = childArray.SlabID()
+			}
+		}
+
+		// Parent map has non-root slabs which aren't nested containers,
+		// so root slab is metadata slab with fewer child storables than
+		// keys and values of map elements.
+		require.Less(t, len(atree.GetMapRootSlabStorables(m)), mapCount*2)
+
+		return storage, m, mistypedChildID
+	}
+
+	t.Run("matched", func(t *testing.T) {
+		storage, m, mistypedChildID := setup(t)
+
+		checked := 0
+		err := atree.CheckChildTypeInfo(
+			storage,
+			m.SlabID(),
+			func(parentID atree.SlabID, childID atree.SlabID) (atree.TypeInfo, bool) {
+				require.Equal(t, m.SlabID(), parentID)
+				checked++
+
+				// Type info of mistyped child isn't known, so it isn't checked.
+				return childTypeInfo, childID != mistypedChildID
+			},
+			test_utils.CompareTypeInfo,
+		)
+		require.NoError(t, err)
+		require.Equal(t, mapCount, checked)
+	})
+
+	t.Run("mistyped", func(t *testing.T) {
+		storage, m, mistypedChildID := setup(t)
+
+		err := atree.CheckChildTypeInfo(
+			storage,
+			m.SlabID(),
+			func(atree.SlabID, atree.SlabID) (atree.TypeInfo, bool) {
+				return childTypeInfo, true
+			},
+			test_utils.CompareTypeInfo,
+		)
+		require.Equal(t, 1, errorCategorizationCount(err))
+
+		var fatalError *atree.FatalError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorContains(t, err, mistypedChildID.String())
+	})
+}
+
+func TestPersistentStorageCommitReturningIDs(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	compare := func(a, b atree.SlabID) int {
+		return a.Compare(b)
+	}
+
+	// expectedCommittedIDs returns IDs of slabs to be written and removed by next commit.
+	expectedCommittedIDs := func(storage *atree.PersistentSlabStorage) (written []atree.SlabID, removed []atree.SlabID) {
+		for id, slab := range atree.GetDeltas(storage) {
+			if id.HasTempAddress() {
+				continue
+			}
+			if slab == nil {
+				removed = append(removed, id)
+			} else {
+				written = append(written, id)
+			}
+		}
+		slices.SortFunc(written, compare)
+		slices.SortFunc(removed, compare)
+		return written, removed
+	}
+
+	testCommit := func(t *testing.T, commit func(*atree.PersistentSlabStorage) ([]atree.SlabID, []atree.SlabID, error)) {
+		storage := newTestPersistentStorage(t)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		const mapCount = 500
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+			v := test_utils.NewStringValue(strings.Repeat("a", 20))
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		expectedWritten, expectedRemoved := expectedCommittedIDs(storage)
+		require.True(t, len(expectedWritten) > 1)
+		require.Equal(t, 0, len(expectedRemoved))
+
+		written, removed, err := commit(storage)
+		require.NoError(t, err)
+		require.Equal(t, expectedWritten, written)
+		require.Equal(t, 0, len(removed))
+
+		// Mix of set and remove modifies some slabs and removes others.
+		for i := range mapCount {
+			k := test_utils.Uint64Value(i)
+
+			if i%10 == 0 {
+				v := test_utils.NewStringValue(strings.Repeat("b", 20))
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+				require.NoError(t, err)
+				require.NotNil(t, existingStorable)
+				continue
+			}
+
+			existingKeyStorable, existingValueStorable, err := m.Remove(test_utils.CompareValue, test_utils.GetHashInput, k)
+			require.NoError(t, err)
+			require.NotNil(t, existingKeyStorable)
+			require.NotNil(t, existingValueStorable)
+		}
+
+		expectedWritten, expectedRemoved = expectedCommittedIDs(storage)
+		require.True(t, len(expectedWritten) > 0)
+		require.True(t, len(expectedRemoved) > 0)
+
+		written, removed, err = commit(storage)
+		require.NoError(t, err)
+		require.Equal(t, expectedWritten, written)
+		require.Equal(t, expectedRemoved, removed)
+
+		// Commit without changes doesn't write or remove slabs.
+		written, removed, err = commit(storage)
+		require.NoError(t, err)
+		require.Equal(t, 0, len(written))
+		require.Equal(t, 0, len(removed))
+
+		// Returned IDs match base storage.
+		baseStorage := atree.GetBaseStorage(storage)
+		for _, id := range expectedWritten {
+			_, found, err := baseStorage.Retrieve(id)
+			require.NoError(t, err)
+			require.True(t, found)
+		}
+		for _, id := range expectedRemoved {
+			_, found, err := baseStorage.Retrieve(id)
+			require.NoError(t, err)
+			require.False(t, found)
+		}
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		testCommit(t, func(storage *atree.PersistentSlabStorage) ([]atree.SlabID, []atree.SlabID, error) {
+			return storage.CommitReturningIDs()
+		})
+	})
+
+	t.Run("fast commit", func(t *testing.T) {
+		testCommit(t, func(storage *atree.PersistentSlabStorage) ([]atree.SlabID, []atree.SlabID, error) {
+			return storage.FastCommitReturningIDs(runtime.NumCPU())
+		})
+	})
+}
+
+func TestStorageDecodeWithoutTypeInfoDecoder(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	encMode, err := cbor.EncOptions{}.EncMode()
+	require.NoError(t, err)
+
+	decMode, err := cbor.DecOptions{}.DecMode()
+	require.NoError(t, err)
+
+	storage := atree.NewBasicSlabStorage(encMode, decMode, test_utils.DecodeStorable, test_utils.DecodeTypeInfo)
+
+	m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+	require.NoError(t, err)
+
+	const mapCount = 100
+	for i := range mapCount {
+		k := test_utils.Uint64Value(i)
+		v := test_utils.Uint64Value(i)
+
+		existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+		require.NoError(t, err)
+		require.Nil(t, existingStorable)
+	}
+
+	encodedData, err := storage.Encode()
+	require.NoError(t, err)
+	require.True(t, len(encodedData) > 1)
+
+	t.Run("DecodeSlab", func(t *testing.T) {
+		for id, data := range encodedData {
+			slab, err := atree.DecodeSlab(id, data, decMode, test_utils.DecodeStorable, nil)
+
+			if id == m.SlabID() {
+				// Root slab has extra data with type info.
+				require.Equal(t, 1, errorCategorizationCount(err))
+				var fatalError *atree.FatalError
+				var decodingError *atree.DecodingError
+				require.ErrorAs(t, err, &fatalError)
+				require.ErrorAs(t, err, &decodingError)
+				require.ErrorContains(t, err, "type info decoder not configured")
+				require.Nil(t, slab)
+				continue
+			}
+
+			// Non-root slabs don't need type info decoder.
+			require.NoError(t, err)
+			require.NotNil(t, slab)
+		}
+	})
+
+	t.Run("BasicSlabStorage.Load", func(t *testing.T) {
+		storage := atree.NewBasicSlabStorage(encMode, decMode, test_utils.DecodeStorable, nil)
+
+		err := storage.Load(encodedData)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		var decodingError *atree.DecodingError
+		require.ErrorAs(t, err, &fatalError)
+		require.ErrorAs(t, err, &decodingError)
+		require.ErrorContains(t, err, "type info decoder not configured")
+	})
+}
+
+func TestValidateAll(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const containerCount = 3
+	const elementCount = 100
+
+	createContainers := func(t *testing.T, storage *atree.PersistentSlabStorage) (arrays []*atree.Array, maps []*atree.OrderedMap) {
+		for range containerCount {
+			array, err := atree.NewArray(storage, address, typeInfo)
+			require.NoError(t, err)
+
+			for i := range uint64(elementCount) {
+				err := array.Append(test_utils.Uint64Value(i))
+				require.NoError(t, err)
+			}
+
+			arrays = append(arrays, array)
+
+			m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+			require.NoError(t, err)
+
+			for i := range uint64(elementCount) {
+				existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+				require.NoError(t, err)
+				require.Nil(t, existingStorable)
+			}
+
+			require.False(t, IsMapRootDataSlab(m))
+
+			maps = append(maps, m)
+		}
+
+		return arrays, maps
+	}
+
+	rootIDs := func(arrays []*atree.Array, maps []*atree.OrderedMap) []atree.SlabID {
+		var ids []atree.SlabID
+		for _, array := range arrays {
+			ids = append(ids, array.SlabID())
+		}
+		for _, m := range maps {
+			ids = append(ids, m.SlabID())
+		}
+		return ids
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		err := atree.ValidateAll(storage, nil, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.NoError(t, err)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		arrays, maps := createContainers(t, storage)
+
+		err := storage.Commit()
+		require.NoError(t, err)
+
+		// Validate containers loaded from base storage.
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		err = atree.ValidateAll(storage2, rootIDs(arrays, maps), test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		arrays, maps := createContainers(t, storage)
+
+		// Corrupt digest order in first data slab of second map.
+		corruptedMap := maps[1]
+
+		rootSlab, ok := atree.GetMapRootSlab(corruptedMap).(*atree.MapMetaDataSlab)
+		require.True(t, ok)
+
+		childSlabIDs, _, _ := atree.GetMapMetaDataSlabChildInfo(rootSlab)
+
+		slab, found, err := storage.Retrieve(childSlabIDs[0])
+		require.NoError(t, err)
+		require.True(t, found)
+
+		dataSlab, ok := slab.(*atree.MapDataSlab)
+		require.True(t, ok)
+
+		atree.SetMapDataSlabDigest(dataSlab, 0, atree.Digest(math.MaxUint64))
+
+		missingID := atree.NewSlabID(address, atree.SlabIndex{0, 0, 0, 0, 0, 0, 0xff, 0xff})
+
+		roots := append(rootIDs(arrays, maps), missingID)
+
+		err = atree.ValidateAll(storage, roots, test_utils.CompareTypeInfo, test_utils.GetHashInput, true)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var fatalError *atree.FatalError
+		require.ErrorAs(t, err, &fatalError)
+		var validationError *atree.ContainerValidationError
+		require.ErrorAs(t, err, &validationError)
+
+		errs := validationError.Errors()
+		require.Equal(t, 2, len(errs))
+
+		require.Contains(t, errs, corruptedMap.SlabID())
+		require.Contains(t, errs, missingID)
+
+		var slabNotFoundError *atree.SlabNotFoundError
+		require.ErrorAs(t, errs[missingID], &slabNotFoundError)
+
+		require.ErrorContains(t, err, corruptedMap.SlabID().String())
+	})
+}
+
+func TestVerifyPersistentStorage(t *testing.T) {
+
+	atree.SetThreshold(256)
+	defer atree.SetThreshold(1024)
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const (
+		arrayCount = 500
+		mapCount   = 100
+	)
+
+	newArray := func(t *testing.T, storage *atree.PersistentSlabStorage, count int) *atree.Array {
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(count) {
+			err := array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		return array
+	}
+
+	newMap := func(t *testing.T, storage *atree.PersistentSlabStorage, count int) *atree.OrderedMap {
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(count) {
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, test_utils.Uint64Value(i), test_utils.Uint64Value(i))
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+		}
+
+		return m
+	}
+
+	requireNoFindings := func(t *testing.T, result atree.RootVerification, isMap bool, count uint64) {
+		require.Equal(t, 0, len(result.Errors))
+		require.Equal(t, isMap, result.IsMap)
+		require.Equal(t, count, result.Count)
+		require.Equal(t, count, result.RecomputedCount)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array := newArray(t, storage, arrayCount)
+		m := newMap(t, storage, mapCount)
+
+		err := storage.Commit()
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		report, err := atree.VerifyPersistentStorage(storage2, []atree.SlabID{array.SlabID(), m.SlabID()}, test_utils.CompareTypeInfo, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.True(t, report.OK())
+		require.False(t, report.OrphanCheckSkipped)
+
+		require.Equal(t, 2, len(report.Roots))
+		requireNoFindings(t, report.Roots[0], false, arrayCount)
+		requireNoFindings(t, report.Roots[1], true, mapCount)
+	})
+
+	t.Run("seeded defects", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		validArray := newArray(t, storage, arrayCount)
+		validMap := newMap(t, storage, mapCount)
+
+		// Array with missing data slab.
+		missingSlabArray := newArray(t, storage, arrayCount)
+
+		arrayRootSlab, ok := atree.GetArrayRootSlab(missingSlabArray).(*atree.ArrayMetaDataSlab)
+		require.True(t, ok)
+
+		arrayChildSlabIDs, _ := atree.GetArrayMetaDataSlabChildInfo(arrayRootSlab)
+		missingID := arrayChildSlabIDs[1]
+
+		// Map with unsorted digests in first data slab.
+		unsortedMap := newMap(t, storage, mapCount)
+
+		mapRootSlab, ok := atree.GetMapRootSlab(unsortedMap).(*atree.MapMetaDataSlab)
+		require.True(t, ok)
+
+		mapChildSlabIDs, _, _ := atree.GetMapMetaDataSlabChildInfo(mapRootSlab)
+
+		slab, found, err := storage.Retrieve(mapChildSlabIDs[0])
+		require.NoError(t, err)
+		require.True(t, found)
+
+		dataSlab, ok := slab.(*atree.MapDataSlab)
+		require.True(t, ok)
+
+		atree.SetMapDataSlabDigest(dataSlab, 0, atree.Digest(math.MaxUint64))
+
+		// Array not referenced by any root.
+		orphanedArray := newArray(t, storage, 1)
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		baseStorage := atree.GetBaseStorage(storage)
+
+		err = baseStorage.Remove(missingID)
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		roots := []atree.SlabID{
+			validArray.SlabID(),
+			validMap.SlabID(),
+			missingSlabArray.SlabID(),
+			unsortedMap.SlabID(),
+		}
+
+		report, err := atree.VerifyPersistentStorage(storage2, roots, test_utils.CompareTypeInfo, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.False(t, report.OK())
+
+		require.Equal(t, len(roots), len(report.Roots))
+		for i, result := range report.Roots {
+			require.Equal(t, roots[i], result.RootID)
+		}
+
+		requireNoFindings(t, report.Roots[0], false, arrayCount)
+		requireNoFindings(t, report.Roots[1], true, mapCount)
+
+		// Array with missing data slab fails structural validation and count recomputation.
+		missingSlabResult := report.Roots[2]
+		require.Equal(t, uint64(arrayCount), missingSlabResult.Count)
+		require.True(t, missingSlabResult.RecomputedCount < arrayCount)
+		require.True(t, len(missingSlabResult.Errors) >= 2)
+		for _, err := range missingSlabResult.Errors {
+			require.Equal(t, 1, errorCategorizationCount(err))
+		}
+
+		// Map with unsorted digests fails structural validation and digest order validation.
+		unsortedResult := report.Roots[3]
+		require.True(t, unsortedResult.IsMap)
+		require.Equal(t, uint64(mapCount), unsortedResult.Count)
+		require.True(t, len(unsortedResult.Errors) >= 2)
+
+		var slabDataError *atree.SlabDataError
+		require.ErrorAs(t, unsortedResult.Errors[1], &slabDataError)
+
+		require.Equal(t, []atree.SlabID{missingID}, report.MissingSlabs)
+		require.Equal(t, 0, len(report.SlabErrors))
+
+		require.False(t, report.OrphanCheckSkipped)
+		require.Equal(t, []atree.SlabID{orphanedArray.SlabID()}, report.OrphanedSlabs)
+	})
+
+	t.Run("base storage without listing", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array := newArray(t, storage, arrayCount)
+
+		// Array not referenced by any root isn't found without listing.
+		_ = newArray(t, storage, 1)
+
+		err := storage.Commit()
+		require.NoError(t, err)
+
+		baseStorage := struct{ atree.BaseStorage }{atree.GetBaseStorage(storage)}
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		report, err := atree.VerifyPersistentStorage(storage2, []atree.SlabID{array.SlabID()}, test_utils.CompareTypeInfo, test_utils.GetHashInput)
+		require.NoError(t, err)
+		require.True(t, report.OK())
+		require.True(t, report.OrphanCheckSkipped)
+		require.Nil(t, report.OrphanedSlabs)
+
+		require.Equal(t, 1, len(report.Roots))
+		requireNoFindings(t, report.Roots[0], false, arrayCount)
+	})
+}
+
+func TestPersistentStorageRollbackTo(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	newArray := func(t *testing.T, storage *atree.PersistentSlabStorage, address atree.Address, values ...atree.Value) *atree.Array {
+		array, err := atree.NewArray(storage, address, typeInfo, atree.AllowTempAddress())
+		require.NoError(t, err)
+
+		for _, v := range values {
+			err := array.Append(v)
+			require.NoError(t, err)
+		}
+
+		return array
+	}
+
+	requireArrayValues := func(t *testing.T, storage *atree.PersistentSlabStorage, id atree.SlabID, expected ...atree.Value) {
+		array, err := atree.NewArrayWithRootID(storage, id)
+		require.NoError(t, err)
+
+		require.Equal(t, uint64(len(expected)), array.Count())
+		for i, expectedValue := range expected {
+			v, err := array.Get(uint64(i))
+			require.NoError(t, err)
+			require.Equal(t, expectedValue, v)
+		}
+	}
+
+	t.Run("temp slabs", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		survivingArrays := make([]*atree.Array, 3)
+		for i := range survivingArrays {
+			survivingArrays[i] = newArray(t, storage, atree.AddressUndefined, test_utils.Uint64Value(i))
+		}
+
+		snapshotTempSlabIndex := atree.GetPersistentSlabStorageTempSlabIndex(storage)
+		require.Equal(t, uint64(len(survivingArrays)), snapshotTempSlabIndex)
+
+		snapshotID, err := storage.Snapshot()
+		require.NoError(t, err)
+
+		// Create and modify temp slabs after snapshot.
+		for i := range 10 {
+			_ = newArray(t, storage, atree.AddressUndefined, test_utils.Uint64Value(i))
+		}
+
+		err = survivingArrays[0].Append(test_utils.Uint64Value(100))
+		require.NoError(t, err)
+
+		require.Equal(t, snapshotTempSlabIndex+10, atree.GetPersistentSlabStorageTempSlabIndex(storage))
+
+		err = storage.RollbackTo(snapshotID)
+		require.NoError(t, err)
+
+		// Temp slab index returns to snapshot value.
+		require.Equal(t, snapshotTempSlabIndex, atree.GetPersistentSlabStorageTempSlabIndex(storage))
+
+		// Temp slabs created after snapshot are discarded.
+		require.Equal(t, uint(len(survivingArrays)), storage.Deltas())
+
+		// Surviving temp slabs are restored to snapshot state.
+		survivingIDs := make(map[atree.SlabID]struct{}, len(survivingArrays))
+		for i, array := range survivingArrays {
+			survivingIDs[array.SlabID()] = struct{}{}
+			requireArrayValues(t, storage, array.SlabID(), test_utils.Uint64Value(i))
+		}
+
+		// New temp slab IDs don't collide with surviving temp slab IDs.
+		for i := range 10 {
+			array := newArray(t, storage, atree.AddressUndefined, test_utils.Uint64Value(i))
+			require.NotContains(t, survivingIDs, array.SlabID())
+		}
+
+		require.Equal(t, uint(len(survivingArrays)+10), storage.Deltas())
+
+		for i, array := range survivingArrays {
+			requireArrayValues(t, storage, array.SlabID(), test_utils.Uint64Value(i))
+		}
+	})
+
+	t.Run("committed changes", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		array := newArray(t, storage, address, test_utils.Uint64Value(0))
+
+		err := storage.Commit()
+		require.NoError(t, err)
+
+		snapshotID, err := storage.Snapshot()
+		require.NoError(t, err)
+
+		err = array.Append(test_utils.Uint64Value(1))
+		require.NoError(t, err)
+
+		newArrayAfterSnapshot := newArray(t, storage, address, test_utils.Uint64Value(2))
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		err = storage.RollbackTo(snapshotID)
+		require.NoError(t, err)
+
+		requireArrayValues(t, storage, array.SlabID(), test_utils.Uint64Value(0))
+
+		_, found, err := storage.Retrieve(newArrayAfterSnapshot.SlabID())
+		require.NoError(t, err)
+		require.False(t, found)
+
+		// Commit writes reverted changes to base storage.
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		storage2 := newTestPersistentStorageWithBaseStorage(t, atree.GetBaseStorage(storage))
+
+		requireArrayValues(t, storage2, array.SlabID(), test_utils.Uint64Value(0))
+
+		_, found, err = storage2.Retrieve(newArrayAfterSnapshot.SlabID())
+		require.NoError(t, err)
+		require.False(t, found)
+	})
+
+	t.Run("snapshot not found", func(t *testing.T) {
+		storage := newTestPersistentStorage(t)
+
+		snapshotID, err := storage.Snapshot()
+		require.NoError(t, err)
+
+		storage.ReleaseSnapshot(snapshotID)
+
+		err = storage.RollbackTo(snapshotID)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		var userError *atree.UserError
+		require.ErrorAs(t, err, &userError)
+	})
+}
+
+// failFirstRetrieveBaseStorage is a RetryableBaseStorage which fails
+// the first retrieval of each slab with errFlakyRead.
+type failFirstRetrieveBaseStorage struct {
+	*test_utils.InMemBaseStorage
+	retrieved map[atree.SlabID]struct{}
+	failures  int
+}
+
+var _ atree.RetryableBaseStorage = &failFirstRetrieveBaseStorage{}
+
+func (s *failFirstRetrieveBaseStorage) Retrieve(id atree.SlabID) ([]byte, bool, error) {
+	if _, ok := s.retrieved[id]; !ok {
+		s.retrieved[id] = struct{}{}
+		s.failures++
+		return nil, false, errFlakyRead
+	}
+	return s.InMemBaseStorage.Retrieve(id)
+}
+
+func (s *failFirstRetrieveBaseStorage) IsRetryable(err error) bool {
+	return errors.Is(err, errFlakyRead)
+}
+
+func TestIterateWithRetrieveRetries(t *testing.T) {
+
+	typeInfo := test_utils.NewSimpleTypeInfo(42)
+	address := atree.Address{1, 2, 3, 4, 5, 6, 7, 8}
+
+	const count = 500
+
+	newFlakyStorage := func(t *testing.T, baseStorage *test_utils.InMemBaseStorage) (*atree.PersistentSlabStorage, *failFirstRetrieveBaseStorage) {
+		flakyBaseStorage := &failFirstRetrieveBaseStorage{
+			InMemBaseStorage: baseStorage,
+			retrieved:        make(map[atree.SlabID]struct{}),
+		}
+		return newTestPersistentStorageWithBaseStorage(t, flakyBaseStorage), flakyBaseStorage
+	}
+
+	t.Run("array", func(t *testing.T) {
+		baseStorage := test_utils.NewInMemBaseStorage()
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(count) {
+			err = array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		iterate := map[string]func(*atree.Array, atree.ArrayIterationFunc, ...atree.IterationOption) error{
+			"Iterate":         (*atree.Array).Iterate,
+			"IterateReadOnly": (*atree.Array).IterateReadOnly,
+		}
+
+		for name, iterate := range iterate {
+			t.Run(name, func(t *testing.T) {
+				// Iteration fails without retries.
+				flakyStorage, _ := newFlakyStorage(t, baseStorage)
+
+				flakyArray, err := atree.NewArrayWithRootID(flakyStorage, array.SlabID())
+				require.Error(t, err)
+				require.True(t, atree.IsTemporaryError(err))
+
+				flakyArray, err = atree.NewArrayWithRootID(flakyStorage, array.SlabID())
+				require.NoError(t, err)
+
+				err = iterate(flakyArray, func(atree.Value) (bool, error) {
+					return true, nil
+				})
+				require.Equal(t, 1, errorCategorizationCount(err))
+				require.True(t, atree.IsTemporaryError(err))
+
+				// Iteration completes with retries.
+				flakyStorage, flakyBaseStorage := newFlakyStorage(t, baseStorage)
+
+				_, err = atree.NewArrayWithRootID(flakyStorage, array.SlabID())
+				require.Error(t, err)
+
+				flakyArray, err = atree.NewArrayWithRootID(flakyStorage, array.SlabID())
+				require.NoError(t, err)
+
+				i := uint64(0)
+				err = iterate(
+					flakyArray,
+					func(v atree.Value) (bool, error) {
+						require.Equal(t, test_utils.Uint64Value(i), v)
+						i++
+						return true, nil
+					},
+					atree.WithRetrieveRetries(1, time.Millisecond),
+				)
+				require.NoError(t, err)
+				require.Equal(t, uint64(count), i)
+				require.True(t, flakyBaseStorage.failures > 1)
+			})
+		}
+	})
+
+	t.Run("map", func(t *testing.T) {
+		baseStorage := test_utils.NewInMemBaseStorage()
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		m, err := atree.NewMap(storage, address, atree.NewDefaultDigesterBuilder(), typeInfo)
+		require.NoError(t, err)
+
+		keyValues := make(map[atree.Value]atree.Value, count)
+		for i := range uint64(count) {
+			k := test_utils.Uint64Value(i)
+
+			var v atree.Value = test_utils.Uint64Value(i)
+			if i%10 == 0 {
+				// Large string is stored in external StorableSlab.
+				v = test_utils.NewStringValue(strings.Repeat("a", 2048))
+			}
+
+			existingStorable, err := m.Set(test_utils.CompareValue, test_utils.GetHashInput, k, v)
+			require.NoError(t, err)
+			require.Nil(t, existingStorable)
+
+			keyValues[k] = v
+		}
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		iterate := map[string]func(*atree.OrderedMap, atree.MapEntryIterationFunc, ...atree.IterationOption) error{
+			"Iterate": func(m *atree.OrderedMap, fn atree.MapEntryIterationFunc, opts ...atree.IterationOption) error {
+				return m.Iterate(test_utils.CompareValue, test_utils.GetHashInput, fn, opts...)
+			},
+			"IterateReadOnly": (*atree.OrderedMap).IterateReadOnly,
+		}
+
+		for name, iterate := range iterate {
+			t.Run(name, func(t *testing.T) {
+				// Iteration fails without retries.
+				flakyStorage, _ := newFlakyStorage(t, baseStorage)
+
+				_, err := atree.NewMapWithRootID(flakyStorage, m.SlabID(), atree.NewDefaultDigesterBuilder())
+				require.Error(t, err)
+
+				flakyMap, err := atree.NewMapWithRootID(flakyStorage, m.SlabID(), atree.NewDefaultDigesterBuilder())
+				require.NoError(t, err)
+
+				err = iterate(flakyMap, func(atree.Value, atree.Value) (bool, error) {
+					return true, nil
+				})
+				require.Equal(t, 1, errorCategorizationCount(err))
+				require.True(t, atree.IsTemporaryError(err))
+
+				// Iteration completes with retries.
+				flakyStorage, flakyBaseStorage := newFlakyStorage(t, baseStorage)
+
+				_, err = atree.NewMapWithRootID(flakyStorage, m.SlabID(), atree.NewDefaultDigesterBuilder())
+				require.Error(t, err)
+
+				flakyMap, err = atree.NewMapWithRootID(flakyStorage, m.SlabID(), atree.NewDefaultDigesterBuilder())
+				require.NoError(t, err)
+
+				iterated := make(map[atree.Value]atree.Value, count)
+				err = iterate(
+					flakyMap,
+					func(k atree.Value, v atree.Value) (bool, error) {
+						require.NotContains(t, iterated, k)
+						iterated[k] = v
+						return true, nil
+					},
+					// Getting next element can retrieve several slabs
+					// (e.g. next data slab and external value slab),
+					// and first retrieval of each slab fails.
+					atree.WithRetrieveRetries(5, time.Millisecond),
+				)
+				require.NoError(t, err)
+				require.Equal(t, keyValues, iterated)
+				require.True(t, flakyBaseStorage.failures > 1)
+			})
+		}
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		baseStorage := &flakyBaseStorage{
+			InMemBaseStorage: test_utils.NewInMemBaseStorage(),
+			err:              errFlakyRead,
+		}
+
+		storage := newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		array, err := atree.NewArray(storage, address, typeInfo)
+		require.NoError(t, err)
+
+		for i := range uint64(count) {
+			err = array.Append(test_utils.Uint64Value(i))
+			require.NoError(t, err)
+		}
+
+		err = storage.Commit()
+		require.NoError(t, err)
+
+		storage = newTestPersistentStorageWithBaseStorage(t, baseStorage)
+
+		array, err = atree.NewArrayWithRootID(storage, array.SlabID())
+		require.NoError(t, err)
+
+		baseStorage.failing = true
+
+		err = array.IterateReadOnly(
+			func(atree.Value) (bool, error) {
+				return true, nil
+			},
+			atree.WithRetrieveRetries(3, time.Millisecond),
+		)
+		require.Equal(t, 1, errorCategorizationCount(err))
+		require.True(t, atree.IsTemporaryError(err))
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		opts := []atree.IterationOption{atree.WithRetrieveRetries(math.MaxInt, time.Millisecond)}
+
+		require.Equal(t, time.Millisecond, atree.GetRetryBackoff(opts, 0))
+		require.Equal(t, 2*time.Millisecond, atree.GetRetryBackoff(opts, 1))
+		require.Equal(t, 8*time.Millisecond, atree.GetRetryBackoff(opts, 3))
+
+		// Backoff stops doubling at max backoff instead of overflowing.
+		require.Equal(t, atree.MaxRetryBackoff, atree.GetRetryBackoff(opts, 14))
+		require.Equal(t, atree.MaxRetryBackoff, atree.GetRetryBackoff(opts, 64))
+		require.Equal(t, atree.MaxRetryBackoff, atree.GetRetryBackoff(opts, math.MaxInt))
+
+		// Backoff longer than max backoff isn't shortened.
+		opts = []atree.IterationOption{atree.WithRetrieveRetries(math.MaxInt, time.Minute)}
+		require.Equal(t, time.Minute, atree.GetRetryBackoff(opts, 64))
+
+		// Retries don't wait without backoff.
+		opts = []atree.IterationOption{atree.WithRetrieveRetries(math.MaxInt, 0)}
+		require.Equal(t, time.Duration(0), atree.GetRetryBackoff(opts, 64))
+	})
+}
@@ -0,0 +1,142 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+// UsageScopedBaseStorage is BaseStorage which can also accumulate
+// usage of a scope (e.g. a transaction) independent of its global
+// usage reporter, so ResetReporter isn't needed between scopes.
+type UsageScopedBaseStorage interface {
+	BaseStorage
+
+	// BeginUsageScope returns a new UsageScope.  Caller must call End
+	// when the scope is no longer needed (e.g. defer scope.End()),
+	// because base storage keeps the scope and records usage in it
+	// until End is called.
+	BeginUsageScope() *UsageScope
+}
+
+// UsageReport is base storage usage accumulated by a UsageScope.
+type UsageReport struct {
+	BytesRetrieved   int
+	BytesStored      int
+	SegmentsReturned int
+	SegmentsUpdated  int
+	SegmentsTouched  int
+}
+
+// UsageScope accumulates base storage usage from when it is created
+// by BeginUsageScope until End is called.
+type UsageScope struct {
+	owner            *UsageScopes
+	bytesRetrieved   int
+	bytesStored      int
+	segmentsReturned map[SlabID]struct{}
+	segmentsUpdated  map[SlabID]struct{}
+	segmentsTouched  map[SlabID]struct{}
+}
+
+// Report returns usage accumulated by this scope.
+func (s *UsageScope) Report() UsageReport {
+	return UsageReport{
+		BytesRetrieved:   s.bytesRetrieved,
+		BytesStored:      s.bytesStored,
+		SegmentsReturned: len(s.segmentsReturned),
+		SegmentsUpdated:  len(s.segmentsUpdated),
+		SegmentsTouched:  len(s.segmentsTouched),
+	}
+}
+
+// End stops accumulating usage in this scope and releases the scope
+// from its base storage.  Report still returns usage accumulated
+// before End is called.  Calling End more than once has no effect.
+func (s *UsageScope) End() {
+	if s.owner == nil {
+		return
+	}
+	s.owner.remove(s)
+	s.owner = nil
+}
+
+// UsageScopes tracks active UsageScopes of a base storage.
+// BaseStorage implementations keep UsageScopes in an unexported field,
+// forward BeginUsageScope to it, and record each retrieve, store, and
+// remove to implement UsageScopedBaseStorage.
+//
+// Active scopes are kept until End is called, so callers of
+// BeginUsageScope must end every scope.
+type UsageScopes struct {
+	scopes []*UsageScope
+}
+
+// BeginUsageScope returns a new UsageScope which accumulates
+// usage recorded from now until the scope is ended.
+func (s *UsageScopes) BeginUsageScope() *UsageScope {
+	scope := &UsageScope{
+		owner:            s,
+		segmentsReturned: make(map[SlabID]struct{}),
+		segmentsUpdated:  make(map[SlabID]struct{}),
+		segmentsTouched:  make(map[SlabID]struct{}),
+	}
+	s.scopes = append(s.scopes, scope)
+	return scope
+}
+
+// ActiveCount returns number of scopes which aren't ended.
+func (s *UsageScopes) ActiveCount() int {
+	return len(s.scopes)
+}
+
+// RecordRetrieve records retrieving size bytes of slab id in active scopes.
+func (s *UsageScopes) RecordRetrieve(id SlabID, size int) {
+	for _, scope := range s.scopes {
+		scope.bytesRetrieved += size
+		scope.segmentsReturned[id] = struct{}{}
+		scope.segmentsTouched[id] = struct{}{}
+	}
+}
+
+// RecordStore records storing size bytes of slab id in active scopes.
+func (s *UsageScopes) RecordStore(id SlabID, size int) {
+	for _, scope := range s.scopes {
+		scope.bytesStored += size
+		scope.segmentsUpdated[id] = struct{}{}
+		scope.segmentsTouched[id] = struct{}{}
+	}
+}
+
+// RecordRemove records removing slab id in active scopes.
+func (s *UsageScopes) RecordRemove(id SlabID) {
+	for _, scope := range s.scopes {
+		scope.segmentsUpdated[id] = struct{}{}
+		scope.segmentsTouched[id] = struct{}{}
+	}
+}
+
+// remove removes ended scope from active scopes.
+func (s *UsageScopes) remove(scope *UsageScope) {
+	for i, active := range s.scopes {
+		if active == scope {
+			last := len(s.scopes) - 1
+			copy(s.scopes[i:], s.scopes[i+1:])
+			s.scopes[last] = nil
+			s.scopes = s.scopes[:last]
+			return
+		}
+	}
+}
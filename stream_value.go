@@ -0,0 +1,347 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// StreamValue is a byte stream value that is too large to be held in memory
+// or in a single slab.  StreamValue is stored as a linked list of chunk slabs
+// so that each slab stays within the slab size threshold.
+//
+// StreamValue is immutable.  Storing a StreamValue always writes a new chunk
+// list, so a StreamValue retrieved from storage is copied (not aliased) when
+// it is stored again.
+type StreamValue struct {
+	// r and length are set for StreamValue created by NewStreamValue().
+	r      io.ReaderAt
+	length uint64
+
+	// storage and head are set for StreamValue retrieved from storage.
+	storage SlabStorage
+	head    *streamChunkStorable
+}
+
+var _ Value = &StreamValue{}
+
+// NewStreamValue returns a StreamValue reading length bytes from r.
+// r is read when the StreamValue is stored.
+func NewStreamValue(r io.ReaderAt, length uint64) *StreamValue {
+	return &StreamValue{
+		r:      r,
+		length: length,
+	}
+}
+
+// Len returns number of bytes in the stream.
+func (v *StreamValue) Len() uint64 {
+	if v.head != nil {
+		return v.head.length
+	}
+	return v.length
+}
+
+// Reader returns a reader of the stream.  Chunk slabs of StreamValue
+// retrieved from storage are loaded lazily as the reader advances.
+func (v *StreamValue) Reader() io.Reader {
+	if v.head != nil {
+		return &streamChunkReader{
+			storage: v.storage,
+			data:    v.head.data,
+			next:    v.head.next,
+		}
+	}
+	return io.NewSectionReader(v.r, 0, int64(v.length))
+}
+
+// Storable writes the stream to storage as linked chunk slabs and
+// returns SlabIDStorable of the first chunk slab.
+func (v *StreamValue) Storable(storage SlabStorage, address Address, _ uint64) (Storable, error) {
+	chunkSize := maxStreamChunkDataSize()
+
+	r := v.Reader()
+	remaining := v.Len()
+
+	id, err := storage.GenerateSlabID(address)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(
+			err,
+			fmt.Sprintf(
+				"failed to generate slab ID for address 0x%x",
+				address,
+			),
+		)
+	}
+
+	headID := id
+
+	for {
+		data := make([]byte, min(remaining, chunkSize))
+
+		_, err := io.ReadFull(r, data)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			// Wrap err as external error (if needed) because err is returned by io.Reader.
+			return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to read stream")
+		}
+
+		chunk := &streamChunkStorable{
+			length: remaining,
+			data:   data,
+		}
+
+		remaining -= uint64(len(data))
+
+		if remaining > 0 {
+			chunk.next, err = storage.GenerateSlabID(address)
+			if err != nil {
+				// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+				return nil, wrapErrorfAsExternalErrorIfNeeded(
+					err,
+					fmt.Sprintf(
+						"failed to generate slab ID for address 0x%x",
+						address,
+					),
+				)
+			}
+		}
+
+		err = storeSlab(storage, &StorableSlab{slabID: id, storable: chunk})
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by storeSlab().
+			return nil, err
+		}
+
+		if remaining == 0 {
+			return SlabIDStorable(headID), nil
+		}
+
+		id = chunk.next
+	}
+}
+
+// streamChunkReader reads data of linked chunk slabs.
+type streamChunkReader struct {
+	storage SlabStorage
+	data    []byte
+	next    SlabID
+}
+
+var _ io.Reader = &streamChunkReader{}
+
+func (r *streamChunkReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.next == SlabIDUndefined {
+			return 0, io.EOF
+		}
+
+		chunk, err := getStreamChunk(r.storage, r.next)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by getStreamChunk().
+			return 0, err
+		}
+
+		r.data = chunk.data
+		r.next = chunk.next
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func getStreamChunk(storage SlabStorage, id SlabID) (*streamChunkStorable, error) {
+	slab, found, err := storage.Retrieve(id)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+	}
+	if !found {
+		return nil, NewSlabNotFoundErrorf(id, "stream chunk slab not found")
+	}
+
+	storableSlab, ok := slab.(*StorableSlab)
+	if !ok {
+		return nil, NewSlabDataErrorf("slab %s isn't StorableSlab", id)
+	}
+
+	chunk, ok := storableSlab.storable.(*streamChunkStorable)
+	if !ok {
+		return nil, NewSlabDataErrorf("slab %s isn't stream chunk", id)
+	}
+
+	return chunk, nil
+}
+
+const (
+	// streamChunkStorable is encoded as builtin storable with 3 fields.
+	streamChunkStorableLength = 3
+
+	// maxStreamChunkOverheadSize is max encoded size of stream chunk slab excluding chunk data:
+	// slab version and flag (2 bytes) + tag number (2 bytes) + array head (1 byte) + kind (1 byte) +
+	// length (max 9 bytes) + data byte string head (max 9 bytes) + next slab ID (17 bytes)
+	maxStreamChunkOverheadSize = versionAndFlagSize + 2 + 1 + 1 + 9 + 9 + 1 + SlabIDLength
+)
+
+func maxStreamChunkDataSize() uint64 {
+	return targetThreshold - maxStreamChunkOverheadSize
+}
+
+// streamChunkStorable is a chunk of StreamValue stored in StorableSlab.
+type streamChunkStorable struct {
+	// length is number of bytes in this chunk and all following chunks.
+	length uint64
+	data   []byte
+	next   SlabID
+}
+
+var _ ContainerStorable = &streamChunkStorable{}
+
+// Encode encodes streamChunkStorable as
+//
+//	cbor.Tag{
+//			Number: CBORTagBuiltinStorable,
+//			Content: []any{
+//				builtinStorableKindStreamChunk,
+//				length (uint64),
+//				data ([]byte),
+//				next slab ID ([]byte, empty if this is the last chunk),
+//			},
+//	}
+func (s *streamChunkStorable) Encode(enc *Encoder) error {
+	err := encodeBuiltinStorableHead(enc, builtinStorableKindStreamChunk, streamChunkStorableLength)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by encodeBuiltinStorableHead().
+		return err
+	}
+
+	err = enc.CBOR.EncodeUint64(s.length)
+	if err != nil {
+		return NewEncodingError(err)
+	}
+
+	err = enc.CBOR.EncodeBytes(s.data)
+	if err != nil {
+		return NewEncodingError(err)
+	}
+
+	next := []byte{}
+	if s.next != SlabIDUndefined {
+		copy(enc.Scratch[:], s.next.address[:])
+		copy(enc.Scratch[SlabAddressLength:], s.next.index[:])
+		next = enc.Scratch[:SlabIDLength]
+	}
+
+	err = enc.CBOR.EncodeBytes(next)
+	if err != nil {
+		return NewEncodingError(err)
+	}
+
+	return nil
+}
+
+func (s *streamChunkStorable) ByteSize() uint32 {
+	size := builtinStorableHeadSize(builtinStorableKindStreamChunk, streamChunkStorableLength)
+
+	size += GetUintCBORSize(s.length)
+
+	size += GetUintCBORSize(uint64(len(s.data))) + uint32(len(s.data))
+
+	size += 1
+	if s.next != SlabIDUndefined {
+		size += SlabIDLength
+	}
+
+	return size
+}
+
+func (s *streamChunkStorable) StoredValue(storage SlabStorage) (Value, error) {
+	return &StreamValue{
+		storage: storage,
+		head:    s,
+	}, nil
+}
+
+func (s *streamChunkStorable) ChildStorables() []Storable {
+	if s.next == SlabIDUndefined {
+		return nil
+	}
+	return []Storable{SlabIDStorable(s.next)}
+}
+
+func (s *streamChunkStorable) HasPointer() bool {
+	return s.next != SlabIDUndefined
+}
+
+func (s *streamChunkStorable) String() string {
+	return fmt.Sprintf("streamChunkStorable(length:%d, chunk:%d, next:%s)", s.length, len(s.data), s.next)
+}
+
+// decodeStreamChunkStorable decodes stream chunk with fieldCount fields.
+// Builtin storable head is already decoded by caller.
+func decodeStreamChunkStorable(dec *cbor.StreamDecoder, fieldCount uint64) (Storable, error) {
+	if fieldCount != streamChunkStorableLength {
+		return nil, NewDecodingErrorf("failed to decode stream chunk: expect %d elements, got %d", streamChunkStorableLength, fieldCount)
+	}
+
+	streamLength, err := dec.DecodeUint64()
+	if err != nil {
+		return nil, NewDecodingError(err)
+	}
+
+	data, err := dec.DecodeBytes()
+	if err != nil {
+		return nil, NewDecodingError(err)
+	}
+
+	b, err := dec.DecodeBytes()
+	if err != nil {
+		return nil, NewDecodingError(err)
+	}
+
+	var next SlabID
+	if len(b) > 0 {
+		next, err = NewSlabIDFromRawBytes(b)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by NewSlabIDFromRawBytes().
+			return nil, err
+		}
+	}
+
+	if uint64(len(data)) > streamLength {
+		return nil, NewDecodingErrorf("failed to decode stream chunk: chunk size %d exceeds stream length %d", len(data), streamLength)
+	}
+
+	if (next == SlabIDUndefined) != (uint64(len(data)) == streamLength) {
+		return nil, NewDecodingErrorf("failed to decode stream chunk: chunk size %d and stream length %d don't match next slab ID %s", len(data), streamLength, next)
+	}
+
+	return &streamChunkStorable{
+		length: streamLength,
+		data:   data,
+		next:   next,
+	}, nil
+}
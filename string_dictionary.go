@@ -0,0 +1,683 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// StringDictionary interns repeated strings.  Each distinct string is
+// stored once in a dedicated dictionary slab, and array elements and
+// map keys/values of InternedStringValue are stored as small reference
+// storables containing dictionary slab ID and string ID.
+//
+// Dictionary lifecycle:
+//   - Each time InternedStringValue is stored in Array or OrderedMap,
+//     reference count of the string is incremented.  Reference count
+//     isn't changed if value is rejected (e.g. with MaxValueSizeError).
+//   - When reference storable is removed or overwritten, caller must pass
+//     returned storable to ReleaseInternedString (the same way caller
+//     removes slabs of returned SlabIDStorable), which decrements reference
+//     count and removes string from dictionary when count reaches 0.
+//     String IDs are never reused.
+//   - Dictionary slab is removed by caller with storage.Remove when
+//     dictionary is no longer used.
+//
+// Interning reduces storage size only if strings are longer than reference
+// storable (about 20 bytes) and repeated.  Dictionary slab isn't split, and
+// adding a string which can make dictionary slab larger than max slab size
+// returns UserError, so dictionary is only suitable for a small number of
+// distinct strings.
+type StringDictionary struct {
+	storage SlabStorage
+	slabID  SlabID
+}
+
+var _ Value = &StringDictionary{}
+
+// NewStringDictionary creates empty dictionary in a new slab at address.
+func NewStringDictionary(storage SlabStorage, address Address) (*StringDictionary, error) {
+	id, err := storage.GenerateSlabID(address)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(
+			err,
+			fmt.Sprintf(
+				"failed to generate slab ID for address 0x%x",
+				address,
+			),
+		)
+	}
+
+	slab := &StorableSlab{
+		slabID:   id,
+		storable: newStringDictionaryStorable(id),
+	}
+
+	err = storeSlab(storage, slab)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by storeSlab().
+		return nil, err
+	}
+
+	return &StringDictionary{
+		storage: storage,
+		slabID:  id,
+	}, nil
+}
+
+// NewStringDictionaryWithID returns existing dictionary with slab ID.
+func NewStringDictionaryWithID(storage SlabStorage, id SlabID) (*StringDictionary, error) {
+	_, err := getStringDictionaryStorable(storage, id)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getStringDictionaryStorable().
+		return nil, err
+	}
+
+	return &StringDictionary{
+		storage: storage,
+		slabID:  id,
+	}, nil
+}
+
+// SlabID returns ID of dictionary slab.
+func (d *StringDictionary) SlabID() SlabID {
+	return d.slabID
+}
+
+// Count returns number of distinct strings in dictionary.
+func (d *StringDictionary) Count() (uint64, error) {
+	dict, err := getStringDictionaryStorable(d.storage, d.slabID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getStringDictionaryStorable().
+		return 0, err
+	}
+	return uint64(len(dict.entries)), nil
+}
+
+// Intern returns InternedStringValue of s.  String is added to
+// dictionary when returned value is stored.
+func (d *StringDictionary) Intern(s string) *InternedStringValue {
+	return &InternedStringValue{
+		dictionaryID: d.slabID,
+		str:          s,
+	}
+}
+
+// Storable returns SlabIDStorable of dictionary slab, so dictionary
+// can be referenced by application's values.  Dictionary slab is always
+// stored separately.
+func (d *StringDictionary) Storable(_ SlabStorage, _ Address, _ uint64) (Storable, error) {
+	return SlabIDStorable(d.slabID), nil
+}
+
+// ReleaseInternedString decrements reference count of interned string
+// referenced by storable, and removes string from dictionary if it isn't
+// referenced anymore.  It returns false if storable isn't reference to
+// interned string.
+func ReleaseInternedString(storage SlabStorage, storable Storable) (bool, error) {
+	ref, ok := unwrapStorable(storable).(internedStringStorable)
+	if !ok {
+		return false, nil
+	}
+
+	dict, err := getStringDictionaryStorable(storage, ref.dictionaryID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getStringDictionaryStorable().
+		return false, err
+	}
+
+	err = dict.release(ref.id)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by stringDictionaryStorable.release().
+		return false, err
+	}
+
+	err = storeSlab(storage, &StorableSlab{slabID: ref.dictionaryID, storable: dict})
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by storeSlab().
+		return false, err
+	}
+
+	return true, nil
+}
+
+// retainInternedString increments reference count of interned string
+// referenced by storable.  It is called after storable is accepted as
+// array element or map key or value.
+func retainInternedString(storage SlabStorage, storable Storable) error {
+	ref, ok := unwrapStorable(storable).(internedStringStorable)
+	if !ok {
+		return nil
+	}
+
+	dict, err := getStringDictionaryStorable(storage, ref.dictionaryID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getStringDictionaryStorable().
+		return err
+	}
+
+	err = dict.retain(ref.id)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by stringDictionaryStorable.retain().
+		return err
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by storeSlab().
+	return storeSlab(storage, &StorableSlab{slabID: ref.dictionaryID, storable: dict})
+}
+
+// removeUnreferencedInternedString removes interned string referenced by
+// rejected storable from dictionary if string isn't referenced by other
+// storables, so string added by InternedStringValue.Storable isn't leaked.
+func removeUnreferencedInternedString(storage SlabStorage, storable Storable) error {
+	ref, ok := unwrapStorable(storable).(internedStringStorable)
+	if !ok {
+		return nil
+	}
+
+	dict, err := getStringDictionaryStorable(storage, ref.dictionaryID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getStringDictionaryStorable().
+		return err
+	}
+
+	if !dict.removeUnreferenced(ref.id) {
+		return nil
+	}
+
+	// Don't need to wrap error as external error because err is already categorized by storeSlab().
+	return storeSlab(storage, &StorableSlab{slabID: ref.dictionaryID, storable: dict})
+}
+
+func getStringDictionaryStorable(storage SlabStorage, id SlabID) (*stringDictionaryStorable, error) {
+	slab, found, err := storage.Retrieve(id)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+	}
+	if !found {
+		return nil, NewSlabNotFoundErrorf(id, "string dictionary slab not found")
+	}
+
+	storableSlab, ok := slab.(*StorableSlab)
+	if !ok {
+		return nil, NewSlabDataErrorf("slab %s isn't StorableSlab", id)
+	}
+
+	dict, ok := storableSlab.storable.(*stringDictionaryStorable)
+	if !ok {
+		return nil, NewSlabDataErrorf("slab %s isn't string dictionary", id)
+	}
+
+	return dict, nil
+}
+
+// InternedStringValue is a string interned in StringDictionary.
+type InternedStringValue struct {
+	dictionaryID SlabID
+	str          string
+}
+
+var _ Value = &InternedStringValue{}
+
+// Str returns interned string.
+func (v *InternedStringValue) Str() string {
+	return v.str
+}
+
+// DictionaryID returns slab ID of dictionary which interns the string.
+func (v *InternedStringValue) DictionaryID() SlabID {
+	return v.dictionaryID
+}
+
+// Storable adds string to dictionary if it isn't in dictionary yet,
+// and returns reference storable of the string.  Reference count of the
+// string is incremented by Array and OrderedMap after storable is accepted
+// (see retainInternedString).
+func (v *InternedStringValue) Storable(storage SlabStorage, _ Address, _ uint64) (Storable, error) {
+	dict, err := getStringDictionaryStorable(storage, v.dictionaryID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getStringDictionaryStorable().
+		return nil, err
+	}
+
+	id, added, err := dict.add(v.str)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by stringDictionaryStorable.add().
+		return nil, err
+	}
+
+	if added {
+		err = storeSlab(storage, &StorableSlab{slabID: v.dictionaryID, storable: dict})
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by storeSlab().
+			return nil, err
+		}
+	}
+
+	return internedStringStorable{
+		dictionaryID: v.dictionaryID,
+		id:           id,
+	}, nil
+}
+
+func (v *InternedStringValue) String() string {
+	return v.str
+}
+
+// internedStringStorable references string in dictionary.
+type internedStringStorable struct {
+	dictionaryID SlabID
+	id           uint64
+}
+
+var _ Storable = internedStringStorable{}
+
+// internedStringStorable is encoded as builtin storable with 2 fields.
+const internedStringStorableLength = 2
+
+// Encode encodes internedStringStorable as
+//
+//	cbor.Tag{
+//			Number: CBORTagBuiltinStorable,
+//			Content: []any{
+//				builtinStorableKindInternedString,
+//				dictionary slab ID ([]byte),
+//				string ID (uint64),
+//			},
+//	}
+func (s internedStringStorable) Encode(enc *Encoder) error {
+	err := encodeBuiltinStorableHead(enc, builtinStorableKindInternedString, internedStringStorableLength)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by encodeBuiltinStorableHead().
+		return err
+	}
+
+	copy(enc.Scratch[:], s.dictionaryID.address[:])
+	copy(enc.Scratch[SlabAddressLength:], s.dictionaryID.index[:])
+
+	err = enc.CBOR.EncodeBytes(enc.Scratch[:SlabIDLength])
+	if err != nil {
+		return NewEncodingError(err)
+	}
+
+	err = enc.CBOR.EncodeUint64(s.id)
+	if err != nil {
+		return NewEncodingError(err)
+	}
+
+	return nil
+}
+
+func (s internedStringStorable) ByteSize() uint32 {
+	// builtin storable head + byte string head (1 byte) + slab ID (16 bytes) + string ID
+	return builtinStorableHeadSize(builtinStorableKindInternedString, internedStringStorableLength) +
+		1 + SlabIDLength + GetUintCBORSize(s.id)
+}
+
+func (s internedStringStorable) StoredValue(storage SlabStorage) (Value, error) {
+	dict, err := getStringDictionaryStorable(storage, s.dictionaryID)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by getStringDictionaryStorable().
+		return nil, err
+	}
+
+	entry, ok := dict.entries[s.id]
+	if !ok {
+		return nil, NewSlabDataErrorf("string %d isn't found in string dictionary %s", s.id, s.dictionaryID)
+	}
+
+	return &InternedStringValue{
+		dictionaryID: s.dictionaryID,
+		str:          entry.str,
+	}, nil
+}
+
+func (s internedStringStorable) ChildStorables() []Storable {
+	return nil
+}
+
+func (s internedStringStorable) String() string {
+	return fmt.Sprintf("internedStringStorable(dictionary:%s, id:%d)", s.dictionaryID, s.id)
+}
+
+// decodeInternedStringStorable decodes reference to interned string with
+// fieldCount fields.  Builtin storable head is already decoded by caller.
+func decodeInternedStringStorable(dec *cbor.StreamDecoder, fieldCount uint64) (Storable, error) {
+	if fieldCount != internedStringStorableLength {
+		return nil, NewDecodingErrorf("failed to decode interned string: expect %d elements, got %d", internedStringStorableLength, fieldCount)
+	}
+
+	b, err := dec.DecodeBytes()
+	if err != nil {
+		return nil, NewDecodingError(err)
+	}
+
+	dictionaryID, err := NewSlabIDFromRawBytes(b)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by NewSlabIDFromRawBytes().
+		return nil, err
+	}
+
+	id, err := dec.DecodeUint64()
+	if err != nil {
+		return nil, NewDecodingError(err)
+	}
+
+	return internedStringStorable{
+		dictionaryID: dictionaryID,
+		id:           id,
+	}, nil
+}
+
+// stringDictionaryStorable is content of string dictionary slab.
+type stringDictionaryStorable struct {
+	// slabID is ID of dictionary slab.  It isn't encoded.
+	slabID SlabID
+
+	// nextID is ID of the next added string.
+	nextID uint64
+
+	entries map[uint64]*stringDictionaryEntry
+
+	// ids is index of entries by string.
+	ids map[string]uint64
+}
+
+type stringDictionaryEntry struct {
+	str      string
+	refCount uint64
+}
+
+var _ Storable = &stringDictionaryStorable{}
+
+func newStringDictionaryStorable(slabID SlabID) *stringDictionaryStorable {
+	return &stringDictionaryStorable{
+		slabID:  slabID,
+		entries: make(map[uint64]*stringDictionaryEntry),
+		ids:     make(map[string]uint64),
+	}
+}
+
+// add returns ID of str, and adds str with reference count 0 if it isn't
+// in dictionary.  It returns UserError if adding str can make dictionary
+// slab larger than max slab size.
+func (s *stringDictionaryStorable) add(str string) (id uint64, added bool, err error) {
+	id, ok := s.ids[str]
+	if ok {
+		return id, false, nil
+	}
+
+	id = s.nextID
+
+	size := s.maxByteSize(1) + stringDictionaryEntryMaxSize(id, str)
+	maxSize := uint32(maxThreshold) - versionAndFlagSize
+	if size > maxSize {
+		return 0, false, NewUserError(
+			fmt.Errorf(
+				"failed to add string to string dictionary %s: dictionary size %d exceeds max size %d",
+				s.slabID,
+				size,
+				maxSize,
+			))
+	}
+
+	s.nextID++
+
+	s.entries[id] = &stringDictionaryEntry{str: str}
+	s.ids[str] = id
+
+	return id, true, nil
+}
+
+// retain increments reference count of string with id.
+func (s *stringDictionaryStorable) retain(id uint64) error {
+	entry, ok := s.entries[id]
+	if !ok {
+		return NewSlabDataErrorf("string %d isn't found in string dictionary", id)
+	}
+
+	entry.refCount++
+
+	return nil
+}
+
+// release decrements reference count of string with id, and removes
+// string if it isn't referenced anymore.
+func (s *stringDictionaryStorable) release(id uint64) error {
+	entry, ok := s.entries[id]
+	if !ok {
+		return NewSlabDataErrorf("string %d isn't found in string dictionary", id)
+	}
+
+	entry.refCount--
+
+	if entry.refCount == 0 {
+		delete(s.entries, id)
+		delete(s.ids, entry.str)
+	}
+
+	return nil
+}
+
+// removeUnreferenced removes string with id if it isn't referenced.
+// It returns true if string is removed.
+func (s *stringDictionaryStorable) removeUnreferenced(id uint64) bool {
+	entry, ok := s.entries[id]
+	if !ok || entry.refCount > 0 {
+		return false
+	}
+
+	delete(s.entries, id)
+	delete(s.ids, entry.str)
+
+	return true
+}
+
+func (s *stringDictionaryStorable) sortedIDs() []uint64 {
+	ids := make([]uint64, 0, len(s.entries))
+	for id := range s.entries {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// stringDictionaryStorable is encoded as builtin storable with 2 fields.
+const stringDictionaryStorableLength = 2
+
+// Encode encodes stringDictionaryStorable as
+//
+//	cbor.Tag{
+//			Number: CBORTagBuiltinStorable,
+//			Content: []any{
+//				builtinStorableKindStringDictionary,
+//				next string ID (uint64),
+//				[]any{
+//					[]any{string ID (uint64), string (string), reference count (uint64)},
+//					...
+//				},
+//			},
+//	}
+//
+// Entries are sorted by string ID.
+func (s *stringDictionaryStorable) Encode(enc *Encoder) error {
+	err := encodeBuiltinStorableHead(enc, builtinStorableKindStringDictionary, stringDictionaryStorableLength)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by encodeBuiltinStorableHead().
+		return err
+	}
+
+	err = enc.CBOR.EncodeUint64(s.nextID)
+	if err != nil {
+		return NewEncodingError(err)
+	}
+
+	err = enc.CBOR.EncodeArrayHead(uint64(len(s.entries)))
+	if err != nil {
+		return NewEncodingError(err)
+	}
+
+	for _, id := range s.sortedIDs() {
+		entry := s.entries[id]
+
+		err = enc.CBOR.EncodeArrayHead(3)
+		if err != nil {
+			return NewEncodingError(err)
+		}
+
+		err = enc.CBOR.EncodeUint64(id)
+		if err != nil {
+			return NewEncodingError(err)
+		}
+
+		err = enc.CBOR.EncodeString(entry.str)
+		if err != nil {
+			return NewEncodingError(err)
+		}
+
+		err = enc.CBOR.EncodeUint64(entry.refCount)
+		if err != nil {
+			return NewEncodingError(err)
+		}
+	}
+
+	return nil
+}
+
+func (s *stringDictionaryStorable) ByteSize() uint32 {
+	size := builtinStorableHeadSize(builtinStorableKindStringDictionary, stringDictionaryStorableLength)
+
+	size += GetUintCBORSize(s.nextID)
+
+	size += GetUintCBORSize(uint64(len(s.entries)))
+
+	for id, entry := range s.entries {
+		// array head (1 byte)
+		size += 1
+		size += GetUintCBORSize(id)
+		size += GetUintCBORSize(uint64(len(entry.str))) + uint32(len(entry.str))
+		size += GetUintCBORSize(entry.refCount)
+	}
+
+	return size
+}
+
+// maxByteSize returns max encoded size of dictionary with newEntryCount more
+// entries, excluding new entries.  Next string ID and reference counts are
+// counted with max size, so dictionary size doesn't exceed max size when
+// they are incremented.
+func (s *stringDictionaryStorable) maxByteSize(newEntryCount uint64) uint32 {
+	size := builtinStorableHeadSize(builtinStorableKindStringDictionary, stringDictionaryStorableLength)
+
+	// next string ID (max 9 bytes)
+	size += 9
+
+	size += GetUintCBORSize(uint64(len(s.entries)) + newEntryCount)
+
+	for id, entry := range s.entries {
+		size += stringDictionaryEntryMaxSize(id, entry.str)
+	}
+
+	return size
+}
+
+// stringDictionaryEntryMaxSize returns max encoded size of dictionary entry.
+func stringDictionaryEntryMaxSize(id uint64, str string) uint32 {
+	// array head (1 byte) + string ID + string + reference count (max 9 bytes)
+	return 1 + GetUintCBORSize(id) + GetUintCBORSize(uint64(len(str))) + uint32(len(str)) + 9
+}
+
+func (s *stringDictionaryStorable) StoredValue(storage SlabStorage) (Value, error) {
+	return &StringDictionary{
+		storage: storage,
+		slabID:  s.slabID,
+	}, nil
+}
+
+func (s *stringDictionaryStorable) ChildStorables() []Storable {
+	return nil
+}
+
+func (s *stringDictionaryStorable) String() string {
+	return fmt.Sprintf("stringDictionaryStorable(count:%d)", len(s.entries))
+}
+
+// decodeStringDictionaryStorable decodes string dictionary with fieldCount
+// fields in slab with id.  Builtin storable head is already decoded by caller.
+func decodeStringDictionaryStorable(dec *cbor.StreamDecoder, fieldCount uint64, id SlabID) (Storable, error) {
+	if fieldCount != stringDictionaryStorableLength {
+		return nil, NewDecodingErrorf("failed to decode string dictionary: expect %d elements, got %d", stringDictionaryStorableLength, fieldCount)
+	}
+
+	dict := newStringDictionaryStorable(id)
+
+	var err error
+	dict.nextID, err = dec.DecodeUint64()
+	if err != nil {
+		return nil, NewDecodingError(err)
+	}
+
+	count, err := dec.DecodeArrayHead()
+	if err != nil {
+		return nil, NewDecodingError(err)
+	}
+
+	for range count {
+		length, err := dec.DecodeArrayHead()
+		if err != nil {
+			return nil, NewDecodingError(err)
+		}
+		if length != 3 {
+			return nil, NewDecodingErrorf("failed to decode string dictionary entry: expect 3 elements, got %d", length)
+		}
+
+		id, err := dec.DecodeUint64()
+		if err != nil {
+			return nil, NewDecodingError(err)
+		}
+
+		str, err := dec.DecodeString()
+		if err != nil {
+			return nil, NewDecodingError(err)
+		}
+
+		refCount, err := dec.DecodeUint64()
+		if err != nil {
+			return nil, NewDecodingError(err)
+		}
+
+		if id >= dict.nextID {
+			return nil, NewDecodingErrorf("failed to decode string dictionary: string ID %d >= next ID %d", id, dict.nextID)
+		}
+
+		if _, exists := dict.ids[str]; exists {
+			return nil, NewDecodingErrorf("failed to decode string dictionary: duplicate string %q", str)
+		}
+
+		dict.entries[id] = &stringDictionaryEntry{str: str, refCount: refCount}
+		dict.ids[str] = id
+	}
+
+	return dict, nil
+}
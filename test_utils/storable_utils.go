@@ -236,6 +236,9 @@ func DecodeStorable(dec *cbor.StreamDecoder, id atree.SlabID, inlinedExtraData [
 		case atree.CBORTagSlabID:
 			return atree.DecodeSlabIDStorable(dec)
 
+		case atree.CBORTagBuiltinStorable:
+			return atree.DecodeBuiltinStorable(dec, DecodeStorable, id, inlinedExtraData)
+
 		case cborTagUInt8Value:
 			n, err := dec.DecodeUint64()
 			if err != nil {
@@ -23,6 +23,7 @@ import (
 )
 
 type InMemBaseStorage struct {
+	usageScopes      atree.UsageScopes
 	segments         map[atree.SlabID][]byte
 	slabIndex        map[atree.Address]atree.SlabIndex
 	bytesRetrieved   int
@@ -32,7 +33,8 @@ type InMemBaseStorage struct {
 	segmentsTouched  map[atree.SlabID]struct{}
 }
 
-var _ atree.BaseStorage = &InMemBaseStorage{}
+var _ atree.UsageScopedBaseStorage = &InMemBaseStorage{}
+var _ atree.ListableBaseStorage = &InMemBaseStorage{}
 
 func NewInMemBaseStorage() *InMemBaseStorage {
 	return NewInMemBaseStorageFromMap(
@@ -55,6 +57,7 @@ func (s *InMemBaseStorage) Retrieve(id atree.SlabID) ([]byte, bool, error) {
 	s.bytesRetrieved += len(seg)
 	s.segmentsReturned[id] = struct{}{}
 	s.segmentsTouched[id] = struct{}{}
+	s.usageScopes.RecordRetrieve(id, len(seg))
 	return seg, ok, nil
 }
 
@@ -63,12 +66,14 @@ func (s *InMemBaseStorage) Store(id atree.SlabID, data []byte) error {
 	s.bytesStored += len(data)
 	s.segmentsUpdated[id] = struct{}{}
 	s.segmentsTouched[id] = struct{}{}
+	s.usageScopes.RecordStore(id, len(data))
 	return nil
 }
 
 func (s *InMemBaseStorage) Remove(id atree.SlabID) error {
 	s.segmentsUpdated[id] = struct{}{}
 	s.segmentsTouched[id] = struct{}{}
+	s.usageScopes.RecordRemove(id)
 	delete(s.segments, id)
 	return nil
 }
@@ -85,6 +90,15 @@ func (s *InMemBaseStorage) SegmentCounts() int {
 	return len(s.segments)
 }
 
+// SlabIDs returns IDs of all stored slabs.
+func (s *InMemBaseStorage) SlabIDs() ([]atree.SlabID, error) {
+	ids := make([]atree.SlabID, 0, len(s.segments))
+	for id := range s.segments {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
 func (s *InMemBaseStorage) Size() int {
 	total := 0
 	for _, seg := range s.segments {
@@ -120,3 +134,26 @@ func (s *InMemBaseStorage) ResetReporter() {
 	s.segmentsUpdated = make(map[atree.SlabID]struct{})
 	s.segmentsTouched = make(map[atree.SlabID]struct{})
 }
+
+// BeginUsageScope returns a new UsageScope.  Caller must call End on
+// returned scope, otherwise the scope is kept by this storage.
+func (s *InMemBaseStorage) BeginUsageScope() *atree.UsageScope {
+	return s.usageScopes.BeginUsageScope()
+}
+
+// Snapshot returns an independent deep copy of this storage.
+// Reporters of returned storage are reset.
+func (s *InMemBaseStorage) Snapshot() *InMemBaseStorage {
+	segments := make(map[atree.SlabID][]byte, len(s.segments))
+	for id, seg := range s.segments {
+		segments[id] = append([]byte(nil), seg...)
+	}
+
+	snapshot := NewInMemBaseStorageFromMap(segments)
+
+	for address, index := range s.slabIndex {
+		snapshot.slabIndex[address] = index
+	}
+
+	return snapshot
+}
@@ -256,6 +256,7 @@ type Uint64Value uint64
 var _ atree.Value = Uint64Value(0)
 var _ atree.Storable = Uint64Value(0)
 var _ HashableValue = Uint64Value(0)
+var _ atree.FixedSizeHashInputValue = Uint64Value(0)
 
 func (v Uint64Value) ChildStorables() []atree.Storable { return nil }
 
@@ -285,41 +286,50 @@ func (v Uint64Value) Encode(enc *atree.Encoder) error {
 }
 
 func (v Uint64Value) HashInput(scratch []byte) ([]byte, error) {
-	const cborTypePositiveInt = 0x00
-
 	buf := scratch
 	if len(buf) < 16 {
 		buf = make([]byte, 16)
 	}
 
+	return v.hashInput(buf), nil
+}
+
+// FixedSizeHashInput returns the same hash input as HashInput without allocation.
+func (v Uint64Value) FixedSizeHashInput(scratch *[atree.HashInputScratchSize]byte) []byte {
+	return v.hashInput(scratch[:])
+}
+
+func (v Uint64Value) hashInput(buf []byte) []byte {
+	const cborTypePositiveInt = 0x00
+
 	buf[0], buf[1] = 0xd8, cborTagUInt64Value // Tag number
 
 	if v <= 23 {
 		buf[2] = cborTypePositiveInt | byte(v)
-		return buf[:3], nil
+		return buf[:3]
 	}
 
 	if v <= math.MaxUint8 {
 		buf[2] = cborTypePositiveInt | byte(24)
 		buf[3] = byte(v)
-		return buf[:4], nil
+		return buf[:4]
 	}
 
 	if v <= math.MaxUint16 {
 		buf[2] = cborTypePositiveInt | byte(25)
 		binary.BigEndian.PutUint16(buf[3:], uint16(v))
-		return buf[:5], nil
+		return buf[:5]
 	}
 
 	if v <= math.MaxUint32 {
 		buf[2] = cborTypePositiveInt | byte(26)
 		binary.BigEndian.PutUint32(buf[3:], uint32(v))
-		return buf[:7], nil
+		return buf[:7]
 	}
 
 	buf[2] = cborTypePositiveInt | byte(27)
 	binary.BigEndian.PutUint64(buf[3:], uint64(v))
-	return buf[:11], nil
+	return buf[:11]
 }
 
 func (v Uint64Value) ByteSize() uint32 {
@@ -697,6 +707,9 @@ func CompareValue(storage atree.SlabStorage, value atree.Value, storable atree.S
 
 		return CompareValue(storage, v.Value, other.Storable)
 
+	case *atree.TupleValue:
+		return atree.CompareTuple(storage, CompareValue, v, storable)
+
 	case *HashableMap:
 		other, err := storable.StoredValue(storage)
 		if err != nil {
@@ -715,6 +728,10 @@ func CompareValue(storage atree.SlabStorage, value atree.Value, storable atree.S
 }
 
 func GetHashInput(value atree.Value, buffer []byte) ([]byte, error) {
+	if tuple, ok := value.(*atree.TupleValue); ok {
+		return atree.TupleHashInput(GetHashInput, tuple, buffer)
+	}
+
 	if hashable, ok := value.(HashableValue); ok {
 		return hashable.HashInput(buffer)
 	}
@@ -0,0 +1,295 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// TupleValue is an ordered list of non-container values, such as
+// composite map key (e.g. (account, id)).
+//
+// Applications use TupleHashInput in HashInputProvider and CompareTuple
+// in ValueComparator to support TupleValue as map key, and decode
+// CBORTagBuiltinStorable with DecodeBuiltinStorable in StorableDecoder.
+type TupleValue struct {
+	elements []Value
+}
+
+var _ Value = &TupleValue{}
+
+// NewTupleValue returns TupleValue with elements.  Elements can't be
+// Array or OrderedMap because tuple is immutable.  Elements can't be
+// InternedStringValue because interned strings are reference counted
+// only when they are stored directly in Array or OrderedMap.
+func NewTupleValue(elements ...Value) (*TupleValue, error) {
+	for i, e := range elements {
+		switch e.(type) {
+		case *Array, *OrderedMap, *InternedStringValue:
+			return nil, NewUserError(fmt.Errorf("tuple element %d can't be %T", i, e))
+		}
+	}
+
+	return &TupleValue{
+		elements: slices.Clone(elements),
+	}, nil
+}
+
+// Count returns number of tuple elements.
+func (v *TupleValue) Count() int {
+	return len(v.elements)
+}
+
+// Get returns tuple element at index.
+func (v *TupleValue) Get(index int) (Value, error) {
+	if index < 0 || index >= len(v.elements) {
+		return nil, NewIndexOutOfBoundsError(uint64(index), 0, uint64(len(v.elements)))
+	}
+	return v.elements[index], nil
+}
+
+// DeepCopy returns a copy of tuple with deep copied elements.
+func (v *TupleValue) DeepCopy(
+	storage SlabStorage,
+	address Address,
+	comparator ValueComparator,
+	hip HashInputProvider,
+) (*TupleValue, error) {
+	elements := make([]Value, len(v.elements))
+
+	for i, e := range v.elements {
+		copied, err := deepCopyValue(storage, address, comparator, hip, e)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by deepCopyValue().
+			return nil, err
+		}
+		elements[i] = copied
+	}
+
+	return &TupleValue{elements: elements}, nil
+}
+
+// Storable returns tupleStorable of tuple elements.  If tuple storable
+// is larger than maxInlineSize, it is stored in a separate slab.
+func (v *TupleValue) Storable(storage SlabStorage, address Address, maxInlineSize uint64) (Storable, error) {
+	elements := make([]Storable, len(v.elements))
+
+	for i, e := range v.elements {
+		s, err := e.Storable(storage, address, maxInlineSize)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Value interface.
+			return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get value's storable")
+		}
+		elements[i] = s
+	}
+
+	storable := &tupleStorable{elements: elements}
+
+	if uint64(storable.ByteSize()) > maxInlineSize {
+		// Don't need to wrap error as external error because err is already categorized by NewStorableSlab().
+		return NewStorableSlab(storage, address, storable)
+	}
+
+	return storable, nil
+}
+
+func (v *TupleValue) String() string {
+	var sb strings.Builder
+	sb.WriteString("(")
+	for i, e := range v.elements {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(fmt.Sprint(e))
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// TupleHashInput returns hash input of tuple by concatenating hash
+// inputs of elements provided by hip.  Number of elements and size of
+// each element hash input are prepended as uvarint, so tuples with
+// different elements (e.g. ("ab", "c") and ("a", "bc")) have different
+// hash inputs.
+func TupleHashInput(hip HashInputProvider, v *TupleValue, scratch []byte) ([]byte, error) {
+	input := binary.AppendUvarint(nil, uint64(len(v.elements)))
+
+	for _, e := range v.elements {
+		b, err := hip(e, scratch)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by HashInputProvider callback.
+			return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get tuple element hash input")
+		}
+
+		input = binary.AppendUvarint(input, uint64(len(b)))
+		input = append(input, b...)
+	}
+
+	return input, nil
+}
+
+// CompareTuple returns true if tuple storable has the same number of
+// elements as v, and each element is equal to element of v by comparator.
+func CompareTuple(storage SlabStorage, comparator ValueComparator, v *TupleValue, storable Storable) (bool, error) {
+	tuple, ok := storable.(*tupleStorable)
+	if !ok {
+		id, isSlabID := storable.(SlabIDStorable)
+		if !isSlabID {
+			return false, nil
+		}
+
+		slab, found, err := storage.Retrieve(SlabID(id))
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+			return false, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", id))
+		}
+		if !found {
+			return false, NewSlabNotFoundErrorf(SlabID(id), "failed to retrieve slab")
+		}
+
+		storableSlab, ok := slab.(*StorableSlab)
+		if !ok {
+			return false, nil
+		}
+
+		tuple, ok = storableSlab.storable.(*tupleStorable)
+		if !ok {
+			return false, nil
+		}
+	}
+
+	if len(tuple.elements) != len(v.elements) {
+		return false, nil
+	}
+
+	for i, e := range v.elements {
+		equal, err := comparator(storage, e, tuple.elements[i])
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by ValueComparator callback.
+			return false, wrapErrorfAsExternalErrorIfNeeded(err, "failed to compare tuple element")
+		}
+		if !equal {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// tupleStorable is storable of TupleValue.
+type tupleStorable struct {
+	elements []Storable
+}
+
+var _ ContainerStorable = &tupleStorable{}
+
+// Encode encodes tupleStorable as
+//
+//	cbor.Tag{
+//			Number:  CBORTagBuiltinStorable,
+//			Content: []any{builtinStorableKindTuple, element storables...},
+//	}
+func (s *tupleStorable) Encode(enc *Encoder) error {
+	err := encodeBuiltinStorableHead(enc, builtinStorableKindTuple, uint64(len(s.elements)))
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by encodeBuiltinStorableHead().
+		return err
+	}
+
+	for _, e := range s.elements {
+		err = e.Encode(enc)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Storable interface.
+			return wrapErrorfAsExternalErrorIfNeeded(err, "failed to encode tuple element")
+		}
+	}
+
+	return nil
+}
+
+func (s *tupleStorable) ByteSize() uint32 {
+	size := builtinStorableHeadSize(builtinStorableKindTuple, uint64(len(s.elements)))
+	for _, e := range s.elements {
+		size += e.ByteSize()
+	}
+	return size
+}
+
+func (s *tupleStorable) StoredValue(storage SlabStorage) (Value, error) {
+	elements := make([]Value, len(s.elements))
+
+	for i, e := range s.elements {
+		v, err := e.StoredValue(storage)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by Storable interface.
+			return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get storable's stored value")
+		}
+		elements[i] = v
+	}
+
+	return &TupleValue{elements: elements}, nil
+}
+
+func (s *tupleStorable) ChildStorables() []Storable {
+	return s.elements
+}
+
+func (s *tupleStorable) HasPointer() bool {
+	for _, e := range s.elements {
+		if hasPointer(e) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *tupleStorable) String() string {
+	return fmt.Sprintf("tupleStorable(%v)", s.elements)
+}
+
+// decodeTupleStorable decodes count tuple elements with decodeStorable.
+// Builtin storable head is already decoded by caller.
+func decodeTupleStorable(
+	dec *cbor.StreamDecoder,
+	count uint64,
+	decodeStorable StorableDecoder,
+	slabID SlabID,
+	inlinedExtraData []ExtraData,
+) (
+	Storable,
+	error,
+) {
+	elements := make([]Storable, count)
+
+	for i := range elements {
+		var err error
+		elements[i], err = decodeStorable(dec, slabID, inlinedExtraData)
+		if err != nil {
+			// Wrap err as external error (if needed) because err is returned by StorableDecoder callback.
+			return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to decode tuple element")
+		}
+	}
+
+	return &tupleStorable{elements: elements}, nil
+}
@@ -19,6 +19,7 @@
 package atree_test
 
 import (
+	"errors"
 	"flag"
 	"math/rand"
 	"testing"
@@ -38,16 +39,20 @@ var (
 
 var seed = flag.Int64("seed", 0, "seed for pseudo-random source")
 
+// SeedTestRandom sets seed used by pseudo-random sources created by newRand.
+// It is used to replay a failed randomized test with the logged seed.
+func SeedTestRandom(s int64) {
+	*seed = s
+}
+
 func newRand(tb testing.TB) *rand.Rand {
 	if *seed == 0 {
 		*seed = time.Now().UnixNano()
 	}
 
-	// Benchmarks always log, so only log for tests which
-	// will only log with -v flag or on error.
-	if t, ok := tb.(*testing.T); ok {
-		t.Logf("seed: %d\n", *seed)
-	}
+	// Log seed so failed tests and benchmarks can be replayed with -seed flag.
+	// Tests only log with -v flag or on error.
+	tb.Logf("seed: %d\n", *seed)
 
 	return rand.New(rand.NewSource(*seed))
 }
@@ -89,6 +94,24 @@ func randomValue(r *rand.Rand, maxInlineSize int) atree.Value {
 	}
 }
 
+func TestRandomValueSeed(t *testing.T) {
+	const count = 1000
+
+	savedSeed := *seed
+	defer SeedTestRandom(savedSeed)
+
+	SeedTestRandom(time.Now().UnixNano())
+
+	r1 := newRand(t)
+	r2 := newRand(t)
+
+	for range count {
+		testValueEqual(t, randomValue(r1, 32), randomValue(r2, 32))
+		testValueEqual(t, RandomValue(r1), RandomValue(r2))
+		require.Equal(t, randStr(r1, 16), randStr(r2, 16))
+	}
+}
+
 func testValueEqual(t *testing.T, expected atree.Value, actual atree.Value) {
 	equal, err := test_utils.ValueEqual(expected, actual)
 	require.NoError(t, err)
@@ -190,6 +213,17 @@ func newTestBasicStorage(t testing.TB) *atree.BasicSlabStorage {
 	)
 }
 
+var errRemoveSlab = errors.New("failed to remove slab")
+
+// removeFailingSlabStorage is BasicSlabStorage which fails to remove slabs.
+type removeFailingSlabStorage struct {
+	*atree.BasicSlabStorage
+}
+
+func (s *removeFailingSlabStorage) Remove(atree.SlabID) error {
+	return errRemoveSlab
+}
+
 // SlabID and ValueID test util functions
 
 func NewSlabIDFromRawAddressAndIndex(rawAddress, rawIndex []byte) atree.SlabID {
@@ -18,6 +18,8 @@
 
 package atree
 
+import "fmt"
+
 type Value interface {
 	Storable(SlabStorage, Address, uint64) (Storable, error)
 }
@@ -30,6 +32,107 @@ type WrapperValue interface {
 	UnwrapAtreeValue() (Value, uint64)
 }
 
+// NaNValue is an interface implemented by Value types that can be NaN,
+// such as floating-point values.  OrderedMap.Set returns NaNKeyError
+// if key is NaNValue and IsNaN returns true, because NaN isn't equal
+// to itself and element with NaN key can't be found after insertion.
+type NaNValue interface {
+	Value
+
+	// IsNaN returns true if value is NaN.
+	IsNaN() bool
+}
+
+// isNaNKey returns true if key (or value wrapped by key) is NaN.
+func isNaNKey(key Value) bool {
+	unwrapped, _ := unwrapValue(key)
+	v, ok := unwrapped.(NaNValue)
+	return ok && v.IsNaN()
+}
+
+// newValueStorable returns storable of array element or map value with maxInlineSize.
+// It returns MaxValueSizeError if value is larger than max value size in
+// MaxValueSizeModeError mode (see SetMaxValueSize).  Value stored externally
+// in StorableSlab is checked by the size of its storable, and the slab is
+// removed if value is rejected.  Reference count of interned string is
+// only incremented if value is accepted.
+func newValueStorable(storage SlabStorage, address Address, value Value, maxInlineSize uint64) (Storable, error) {
+	storable, err := value.Storable(storage, address, maxInlineSize)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by Value interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to get value's storable")
+	}
+
+	err = checkValueStorableSize(storage, value, storable)
+	if err != nil {
+		// Remove string added to dictionary by rejected value.
+		// Ignore removal error because err from checkValueStorableSize() is more relevant.
+		_ = removeUnreferencedInternedString(storage, storable)
+
+		// Don't need to wrap error as external error because err is already categorized by checkValueStorableSize().
+		return nil, err
+	}
+
+	err = retainInternedString(storage, storable)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by retainInternedString().
+		return nil, err
+	}
+
+	return storable, nil
+}
+
+// checkValueStorableSize returns MaxValueSizeError if storable of value is
+// larger than max value size in MaxValueSizeModeError mode.  If rejected
+// storable is stored externally in StorableSlab, the slab is removed.
+func checkValueStorableSize(storage SlabStorage, value Value, storable Storable) error {
+	if maxValueSize == 0 || maxValueSizeMode != MaxValueSizeModeError {
+		return nil
+	}
+
+	if _, ok := value.(movedValue); ok {
+		// Existing value moved by OrderedMap.Swap isn't checked again.
+		return nil
+	}
+
+	id, isSlabID := storable.(SlabIDStorable)
+	if !isSlabID {
+		size := uint64(storable.ByteSize())
+		if size > maxValueSize {
+			return NewMaxValueSizeError(size, maxValueSize)
+		}
+		return nil
+	}
+
+	slab, found, err := storage.Retrieve(SlabID(id))
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", SlabID(id)))
+	}
+	if !found {
+		return NewSlabNotFoundErrorf(SlabID(id), "external value slab not found")
+	}
+
+	storableSlab, ok := slab.(*StorableSlab)
+	if !ok {
+		// Array and map values stored in their own slabs aren't checked.
+		return nil
+	}
+
+	size := uint64(storableSlab.storable.ByteSize())
+	if size <= maxValueSize {
+		return nil
+	}
+
+	err = storage.Remove(SlabID(id))
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to remove slab %s", SlabID(id)))
+	}
+
+	return NewMaxValueSizeError(size, maxValueSize)
+}
+
 type ValueComparator func(SlabStorage, Value, Storable) (bool, error)
 
 type StorableComparator func(Storable, Storable) bool
@@ -53,3 +156,172 @@ func unwrapValue(v Value) (Value, uint64) {
 		return v, 0
 	}
 }
+
+// IsContainerValue returns true if v is Array or OrderedMap, or
+// WrapperValue wrapping Array or OrderedMap.
+func IsContainerValue(v Value) bool {
+	unwrapped, _ := unwrapValue(v)
+
+	switch unwrapped.(type) {
+	case *Array, *OrderedMap:
+		return true
+	default:
+		return false
+	}
+}
+
+// ContainerRootID returns root slab ID of Array or OrderedMap (including
+// container wrapped by WrapperValue), so nested container can be loaded
+// by NewArrayWithRootID or NewMapWithRootID.  It returns false if v isn't
+// container, or if container is inlined because inlined container's root
+// slab is stored in parent slab instead of storage.
+func ContainerRootID(v Value) (SlabID, bool) {
+	unwrapped, _ := unwrapValue(v)
+
+	switch unwrapped := unwrapped.(type) {
+	case *Array:
+		if unwrapped.Inlined() {
+			return SlabIDUndefined, false
+		}
+		return unwrapped.SlabID(), true
+	case *OrderedMap:
+		if unwrapped.Inlined() {
+			return SlabIDUndefined, false
+		}
+		return unwrapped.SlabID(), true
+	default:
+		return SlabIDUndefined, false
+	}
+}
+
+// deepCopyValue returns deep copy of nested Array and OrderedMap values.
+// Other values are returned as is because they are stored again (including
+// any external StorableSlab) when inserted into a container.
+// Container wrapped in WrapperValue can't be rewrapped after copying
+// because WrapperValue doesn't provide a way to wrap a value, so UserError
+// is returned instead of returning wrapper sharing container with source.
+func deepCopyValue(
+	storage SlabStorage,
+	address Address,
+	comparator ValueComparator,
+	hip HashInputProvider,
+	v Value,
+) (Value, error) {
+	switch v := v.(type) {
+	case *Array:
+		// Don't need to wrap error as external error because err is already categorized by Array.DeepCopy().
+		return v.DeepCopy(storage, address, comparator, hip)
+	case *OrderedMap:
+		// Don't need to wrap error as external error because err is already categorized by OrderedMap.DeepCopy().
+		return v.DeepCopy(storage, address, comparator, hip)
+	case *TupleValue:
+		// Don't need to wrap error as external error because err is already categorized by TupleValue.DeepCopy().
+		return v.DeepCopy(storage, address, comparator, hip)
+	case WrapperValue:
+		unwrapped, _ := v.UnwrapAtreeValue()
+		switch unwrapped.(type) {
+		case *Array, *OrderedMap, *TupleValue:
+			return nil, NewUserError(fmt.Errorf("failed to deep copy %T: wrapped container %T can't be rewrapped", v, unwrapped))
+		default:
+			return v, nil
+		}
+	default:
+		return v, nil
+	}
+}
+
+// CopyContainerToAddress deep copies array or map with root slab rootID
+// from src storage to dst storage at address newAddr, and returns root slab
+// ID of the copy.  All slabs of the copy (including slabs of nested
+// containers and values stored in separate slabs) have new slab IDs under
+// newAddr, so slab ID pointers in the copy only reference slabs of the copy.
+// Source container isn't modified.  src and dst can be the same storage.
+// digesterBuilder is used to load source map (and it is inherited by
+// copied map), and comparator and hip are used to deep copy nested maps.
+func CopyContainerToAddress(
+	src SlabStorage,
+	dst SlabStorage,
+	rootID SlabID,
+	newAddr Address,
+	digesterBuilder DigesterBuilder,
+	comparator ValueComparator,
+	hip HashInputProvider,
+) (SlabID, error) {
+	slab, found, err := src.Retrieve(rootID)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return SlabIDUndefined, wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", rootID))
+	}
+	if !found {
+		return SlabIDUndefined, NewSlabNotFoundErrorf(rootID, "root slab not found")
+	}
+
+	switch slab := slab.(type) {
+	case ArraySlab:
+		if slab.ExtraData() == nil {
+			return SlabIDUndefined, NewNotValueError(rootID)
+		}
+
+		array, err := NewArrayWithRootID(src, rootID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by NewArrayWithRootID().
+			return SlabIDUndefined, err
+		}
+
+		copied, err := array.DeepCopy(dst, newAddr, comparator, hip)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by Array.DeepCopy().
+			return SlabIDUndefined, err
+		}
+
+		return copied.SlabID(), nil
+
+	case MapSlab:
+		if slab.ExtraData() == nil {
+			return SlabIDUndefined, NewNotValueError(rootID)
+		}
+
+		m, err := NewMapWithRootID(src, rootID, digesterBuilder)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by NewMapWithRootID().
+			return SlabIDUndefined, err
+		}
+
+		copied, err := m.DeepCopy(dst, newAddr, comparator, hip)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by OrderedMap.DeepCopy().
+			return SlabIDUndefined, err
+		}
+
+		return copied.SlabID(), nil
+
+	default:
+		return SlabIDUndefined, NewNotValueError(rootID)
+	}
+}
+
+// AllowTempAddress returns option which allows NewArray, NewMap, and
+// NewMapWithSeed to create container with AddressUndefined.  Slabs with
+// AddressUndefined are temporary and they are not committed to base storage.
+func AllowTempAddress() TempAddressOption {
+	return TempAddressOption{}
+}
+
+// TempAddressOption is option returned by AllowTempAddress.
+type TempAddressOption struct{}
+
+var _ ArrayOption = TempAddressOption{}
+var _ MapOption = TempAddressOption{}
+
+func (TempAddressOption) applyArrayOption(*Array) {}
+
+func (TempAddressOption) applyMapOption(*OrderedMap) {}
+
+func tempAddressAllowed[T any](opts []T) bool {
+	for _, opt := range opts {
+		if _, ok := any(opt).(TempAddressOption); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,364 @@
+/*
+ * Atree - Scalable Arrays and Ordered Maps
+ *
+ * Copyright Flow Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atree
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ValidateAll verifies containers with given root slab IDs, and returns
+// ContainerValidationError with errors of all invalid containers.
+// Root slab kind determines whether container is verified by VerifyArray
+// or VerifyMap.  Container type information isn't compared to expected
+// type information, so tic is only used to verify nested containers.
+func ValidateAll(
+	storage SlabStorage,
+	roots []SlabID,
+	tic TypeInfoComparator,
+	hip HashInputProvider,
+	inlineEnabled bool,
+) error {
+	errs := make(map[SlabID]error)
+
+	for _, rootID := range roots {
+		if _, ok := errs[rootID]; ok {
+			continue
+		}
+
+		err := validateContainer(storage, rootID, tic, hip, inlineEnabled)
+		if err != nil {
+			errs[rootID] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return NewContainerValidationError(errs)
+	}
+
+	return nil
+}
+
+func validateContainer(
+	storage SlabStorage,
+	rootID SlabID,
+	tic TypeInfoComparator,
+	hip HashInputProvider,
+	inlineEnabled bool,
+) error {
+	slab, found, err := storage.Retrieve(rootID)
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by SlabStorage interface.
+		return wrapErrorfAsExternalErrorIfNeeded(err, fmt.Sprintf("failed to retrieve slab %s", rootID))
+	}
+	if !found {
+		return NewSlabNotFoundErrorf(rootID, "root slab not found")
+	}
+
+	switch slab.(type) {
+	case ArraySlab:
+		array, err := NewArrayWithRootID(storage, rootID)
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by NewArrayWithRootID().
+			return err
+		}
+		// Don't need to wrap error as external error because err is already categorized by VerifyArray().
+		return VerifyArray(array, rootID.address, array.Type(), tic, hip, inlineEnabled)
+
+	case MapSlab:
+		m, err := NewMapWithRootID(storage, rootID, NewDefaultDigesterBuilder())
+		if err != nil {
+			// Don't need to wrap error as external error because err is already categorized by NewMapWithRootID().
+			return err
+		}
+		// Don't need to wrap error as external error because err is already categorized by VerifyMap().
+		return VerifyMap(m, rootID.address, m.Type(), tic, hip, inlineEnabled)
+
+	default:
+		return NewNotValueError(rootID)
+	}
+}
+
+// ListableBaseStorage is BaseStorage which can list IDs of all stored slabs.
+// It is needed by VerifyPersistentStorage to find orphaned slabs.
+type ListableBaseStorage interface {
+	BaseStorage
+	SlabIDs() ([]SlabID, error)
+}
+
+// RootVerification contains findings of one root container
+// verified by VerifyPersistentStorage.
+type RootVerification struct {
+	RootID SlabID
+
+	// IsMap is true if root slab is map slab, and false if root slab is array slab.
+	IsMap bool
+
+	// Count is element count stored in root slab.
+	Count uint64
+
+	// RecomputedCount is element count computed by iterating container.
+	// It is 0 if container can't be iterated.
+	RecomputedCount uint64
+
+	// Errors contains errors of loading, structural validation,
+	// digest order validation (map only), and count recomputation.
+	Errors []error
+}
+
+// VerificationReport contains findings of VerifyPersistentStorage.
+type VerificationReport struct {
+	Roots []RootVerification
+
+	// MissingSlabs contains sorted IDs of slabs referenced by
+	// reachable slabs but not found in storage.
+	MissingSlabs []SlabID
+
+	// SlabErrors contains errors of reachable slabs that can't be
+	// retrieved (e.g. decoding error), ordered by slab ID.
+	SlabErrors []error
+
+	// OrphanedSlabs contains sorted IDs of slabs in base storage
+	// that aren't reachable from any root.
+	OrphanedSlabs []SlabID
+
+	// OrphanCheckSkipped is true if base storage isn't
+	// ListableBaseStorage, so orphaned slabs aren't checked.
+	OrphanCheckSkipped bool
+}
+
+// OK returns true if report doesn't have any finding.
+func (r *VerificationReport) OK() bool {
+	for _, root := range r.Roots {
+		if len(root.Errors) > 0 {
+			return false
+		}
+	}
+	return len(r.MissingSlabs) == 0 && len(r.SlabErrors) == 0 && len(r.OrphanedSlabs) == 0
+}
+
+// VerifyPersistentStorage verifies containers with given root slab IDs
+// and returns report with all findings.  For each root, it verifies the
+// container like ValidateAll, runs ValidateMapDigestOrder for map, and
+// recomputes element count by iterating the container.
+// Then it finds slabs referenced but missing from storage, and slabs in
+// base storage that aren't reachable from any root (only if base storage
+// is ListableBaseStorage).
+//
+// Maps are loaded with default digester builder.  Changes not committed
+// to base storage are verified, but uncommitted new slabs aren't in
+// base storage listing, so storage should be committed first.
+//
+// Findings are reported instead of returned as error, so one run finds
+// all problems.  Returned error is only for failing to list slabs, or
+// failing to retrieve slabs with external error.
+// This should be used for analysis and testing purposes only, as it might be slow to process.
+func VerifyPersistentStorage(
+	s *PersistentSlabStorage,
+	roots []SlabID,
+	typeInfoComparator TypeInfoComparator,
+	hip HashInputProvider,
+) (*VerificationReport, error) {
+
+	report := &VerificationReport{
+		Roots: make([]RootVerification, 0, len(roots)),
+	}
+
+	verified := make(map[SlabID]struct{}, len(roots))
+
+	for _, rootID := range roots {
+		if _, ok := verified[rootID]; ok {
+			continue
+		}
+		verified[rootID] = struct{}{}
+
+		report.Roots = append(report.Roots, verifyRootContainer(s, rootID, typeInfoComparator, hip))
+	}
+
+	reachable, err := report.traverseSlabs(s, roots)
+	if err != nil {
+		// Don't need to wrap error as external error because err is already categorized by VerificationReport.traverseSlabs().
+		return nil, err
+	}
+
+	lister, ok := s.baseStorage.(ListableBaseStorage)
+	if !ok {
+		report.OrphanCheckSkipped = true
+		return report, nil
+	}
+
+	ids, err := lister.SlabIDs()
+	if err != nil {
+		// Wrap err as external error (if needed) because err is returned by ListableBaseStorage interface.
+		return nil, wrapErrorfAsExternalErrorIfNeeded(err, "failed to list slab IDs")
+	}
+
+	for _, id := range ids {
+		if _, ok := reachable[id]; !ok {
+			report.OrphanedSlabs = append(report.OrphanedSlabs, id)
+		}
+	}
+
+	slices.SortFunc(report.OrphanedSlabs, SlabID.Compare)
+
+	return report, nil
+}
+
+func verifyRootContainer(
+	s *PersistentSlabStorage,
+	rootID SlabID,
+	tic TypeInfoComparator,
+	hip HashInputProvider,
+) RootVerification {
+
+	result := RootVerification{RootID: rootID}
+
+	// Verify container structure.
+	err := validateContainer(s, rootID, tic, hip, true)
+	if err != nil {
+		result.Errors = append(result.Errors, err)
+	}
+
+	slab, found, err := s.Retrieve(rootID)
+	if err != nil || !found {
+		// Error is already reported by validateContainer().
+		return result
+	}
+
+	switch slab.(type) {
+	case ArraySlab:
+		array, err := NewArrayWithRootID(s, rootID)
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			return result
+		}
+
+		result.Count = array.Count()
+
+		err = array.IterateReadOnly(func(Value) (bool, error) {
+			result.RecomputedCount++
+			return true, nil
+		})
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+		}
+
+	case MapSlab:
+		result.IsMap = true
+
+		m, err := NewMapWithRootID(s, rootID, NewDefaultDigesterBuilder())
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			return result
+		}
+
+		result.Count = m.Count()
+
+		err = ValidateMapDigestOrder(m)
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+		}
+
+		err = m.IterateReadOnlyKeys(func(Value) (bool, error) {
+			result.RecomputedCount++
+			return true, nil
+		})
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+		}
+
+	default:
+		// Error is already reported by validateContainer().
+		return result
+	}
+
+	if result.RecomputedCount != result.Count {
+		result.Errors = append(
+			result.Errors,
+			NewSlabDataErrorf("root slab %s count %d is wrong, recomputed count is %d", rootID, result.Count, result.RecomputedCount))
+	}
+
+	return result
+}
+
+// traverseSlabs returns IDs of slabs reachable from roots.  Referenced slabs
+// not found in storage and slabs failed to be retrieved are added to report.
+// It returns error only if retrieving slab fails with external error.
+func (r *VerificationReport) traverseSlabs(storage SlabStorage, roots []SlabID) (map[SlabID]struct{}, error) {
+	visited := make(map[SlabID]struct{})
+
+	next := make([]SlabID, 0, len(roots))
+	next = append(next, roots...)
+
+	var slabErrorIDs []SlabID
+	slabErrors := make(map[SlabID]error)
+
+	for len(next) > 0 {
+		id := next[len(next)-1]
+		next = next[:len(next)-1]
+
+		if _, ok := visited[id]; ok {
+			continue
+		}
+		visited[id] = struct{}{}
+
+		slab, found, err := storage.Retrieve(id)
+		if err != nil {
+			var externalError *ExternalError
+			if errors.As(err, &externalError) {
+				// Don't need to wrap error as external error because err is already categorized.
+				return nil, err
+			}
+			slabErrorIDs = append(slabErrorIDs, id)
+			slabErrors[id] = err
+			continue
+		}
+		if !found {
+			r.MissingSlabs = append(r.MissingSlabs, id)
+			continue
+		}
+
+		// Traverse child storables, including elements of inlined slabs,
+		// to find all referenced slabs.
+		childStorables := slab.ChildStorables()
+		for len(childStorables) > 0 {
+			var nextStorables []Storable
+
+			for _, childStorable := range childStorables {
+				if slabIDStorable, ok := childStorable.(SlabIDStorable); ok {
+					next = append(next, SlabID(slabIDStorable))
+				}
+
+				nextStorables = append(nextStorables, childStorable.ChildStorables()...)
+			}
+
+			childStorables = nextStorables
+		}
+	}
+
+	slices.SortFunc(r.MissingSlabs, SlabID.Compare)
+
+	slices.SortFunc(slabErrorIDs, SlabID.Compare)
+	for _, id := range slabErrorIDs {
+		r.SlabErrors = append(r.SlabErrors, slabErrors[id])
+	}
+
+	return visited, nil
+}